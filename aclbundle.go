@@ -0,0 +1,176 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mig /* import "github.com/mozilla/mig" */
+
+// This file contains structures and functions related to the distribution of
+// signed ACL bundles to agents by the API, the scheduler and the loader.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/mozilla/mig/pgp"
+)
+
+// ACLBundleMessageType is the type set on relay messages that carry an ACL bundle
+// to an agent, as opposed to messages that carry a command
+const ACLBundleMessageType = "aclbundle"
+
+// ACLBundle is an ACL document signed by one or more holders of the ACL signing
+// keys. Active bundles are pushed to the agents matching their target by the
+// scheduler, and pulled by the loader, and replace the ACL the agent was started with.
+type ACLBundle struct {
+	ID         float64   `json:"id"`         // ACL bundle record ID
+	Name       string    `json:"name"`       // The name of the ACL bundle
+	ACL        ACL       `json:"acl"`        // ACL distributed to the agents
+	Timestamp  time.Time `json:"timestamp"`  // Bundle timestamp, agents refuse bundles older than their current one
+	Status     string    `json:"status"`     // Bundle status
+	Target     string    `json:"target"`     // Targetting parameters for the bundle
+	Signatures []string  `json:"signatures"` // Signatures applied to the bundle
+}
+
+// ACLBundleAgent describes an agent an ACL bundle applies to, and indicates if the
+// agent has applied the bundle yet
+type ACLBundleAgent struct {
+	ID       float64 `json:"id"`
+	Name     string  `json:"name"`
+	QueueLoc string  `json:"queueloc"`
+	Status   string  `json:"status"`
+	ACLHash  string  `json:"aclhash"`  // Hash of the ACL bundle reported by the agent
+	UpToDate bool    `json:"uptodate"` // True if the agent reported the hash of the bundle
+}
+
+// aclBundleContent is the part of an ACL bundle covered by the signatures
+type aclBundleContent struct {
+	Name      string `json:"name"`
+	ACL       ACL    `json:"acl"`
+	Timestamp string `json:"timestamp"`
+	Target    string `json:"target"`
+}
+
+// Validate validates an ACL bundle
+func (b *ACLBundle) Validate() (err error) {
+	if b.Name == "" {
+		return fmt.Errorf("acl bundle has invalid name")
+	}
+	if b.Target == "" {
+		return fmt.Errorf("acl bundle has invalid target")
+	}
+	if b.Status != "staged" && b.Status != "active" && b.Status != "disabled" {
+		return fmt.Errorf("acl bundle has invalid status")
+	}
+	if len(b.ACL) == 0 {
+		return fmt.Errorf("acl bundle has an empty acl")
+	}
+	for name, ent := range b.ACL {
+		if ent.MinimumWeight < 1 {
+			return fmt.Errorf("invalid ACL %v in bundle, weight must be > 0", name)
+		}
+	}
+	return
+}
+
+// content returns the serialized form of the bundle that signatures apply to. The
+// target is signed so a bundle can't be distributed to other agents than the ones
+// its signers approved. The timestamp is normalized so that the content is
+// identical after a round trip through the database.
+func (b *ACLBundle) content() ([]byte, error) {
+	return json.Marshal(aclBundleContent{
+		Name:      b.Name,
+		ACL:       b.ACL,
+		Timestamp: b.Timestamp.UTC().Format(time.RFC3339),
+		Target:    b.Target,
+	})
+}
+
+// Hash returns the hex encoded SHA256 of the signed content of the bundle. Agents
+// report the hash of the bundle they are using in their heartbeats.
+func (b *ACLBundle) Hash() (string, error) {
+	buf, err := b.content()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(buf)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Sign signs an ACL bundle using the indicated key ID
+func (b *ACLBundle) Sign(keyid string, secring io.Reader) (sig string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("Sign() -> %v", e)
+		}
+	}()
+	buf, err := b.content()
+	if err != nil {
+		panic(err)
+	}
+	sig, err = pgp.Sign(string(buf), keyid, secring)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// VerifySignatures verifies the signatures present in an ACL bundle against the keys
+// present in keyring. It returns the number of valid unique signatures identified in
+// the bundle. Signatures made with keys that are not in the keyring are not counted,
+// and an error is returned if the same key signed the bundle more than once.
+func (b *ACLBundle) VerifySignatures(keyring io.Reader) (validcnt int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("VerifySignatures() -> %v", e)
+		}
+	}()
+	buf, err := b.content()
+	if err != nil {
+		panic(err)
+	}
+	// Create a copy of the keyring we can use during validation of each
+	// signature, as the reader will be drained after the first verification.
+	keycopy, err := ioutil.ReadAll(keyring)
+	if err != nil {
+		panic(err)
+	}
+	fpcache := make([]string, 0)
+	for _, sig := range b.Signatures {
+		fp, err := verifyACLBundleSignature(string(buf), sig, bytes.NewBuffer(keycopy))
+		if err != nil {
+			continue
+		}
+		for _, x := range fpcache {
+			if x == fp {
+				return 0, fmt.Errorf("duplicate signature for fingerprint %v", fp)
+			}
+		}
+		fpcache = append(fpcache, fp)
+		validcnt++
+	}
+	return
+}
+
+// verifyACLBundleSignature verifies a single signature, and returns the fingerprint of
+// the key that made it
+func verifyACLBundleSignature(data, sig string, keyring io.Reader) (fp string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("verifyACLBundleSignature() -> %v", e)
+		}
+	}()
+	valid, ent, err := pgp.Verify(data, sig, keyring)
+	if err != nil {
+		panic(err)
+	}
+	if !valid {
+		panic("invalid signature")
+	}
+	return hex.EncodeToString(ent.PrimaryKey.Fingerprint[:]), nil
+}
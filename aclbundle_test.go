@@ -0,0 +1,174 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mig
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig/pgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+type testACLBundleSigner struct {
+	fp      string
+	pubkey  []byte
+	secring []byte
+}
+
+func newTestACLBundleSigner(t *testing.T, name string) (s testACLBundleSigner) {
+	pub, priv, fp, err := pgp.GenerateKeyPair(name, "", name+"@tests")
+	if err != nil {
+		t.Fatalf("pgp.GenerateKeyPair: %v", err)
+	}
+	blk, err := armor.Decode(bytes.NewBuffer(priv))
+	if err != nil {
+		t.Fatalf("armor.Decode: %v", err)
+	}
+	var secring bytes.Buffer
+	_, err = secring.ReadFrom(blk.Body)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	return testACLBundleSigner{fp: strings.ToUpper(fp), pubkey: pub, secring: secring.Bytes()}
+}
+
+func testACLBundle(t *testing.T) (b ACLBundle) {
+	aclstr := `{
+		"default": {
+			"minimumweight": 1,
+			"investigators": {
+				"valid user": {
+					"fingerprint": "397FD1F5E3DD4020BEF0E37E0F382D21C84C143A",
+					"weight": 1
+				}
+			}
+		}
+	}`
+	err := json.Unmarshal([]byte(aclstr), &b.ACL)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	b.Name = "test bundle"
+	b.Target = "status='online'"
+	b.Status = "staged"
+	b.Timestamp = time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	return
+}
+
+func testACLBundleSign(t *testing.T, b *ACLBundle, signers ...testACLBundleSigner) {
+	for _, s := range signers {
+		sig, err := b.Sign(s.fp, bytes.NewBuffer(s.secring))
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		b.Signatures = append(b.Signatures, sig)
+	}
+}
+
+func testACLBundleKeyring(t *testing.T, signers ...testACLBundleSigner) *bytes.Buffer {
+	var keys [][]byte
+	for _, s := range signers {
+		keys = append(keys, s.pubkey)
+	}
+	keyring, _, err := pgp.ArmoredKeysToKeyring(keys)
+	if err != nil {
+		t.Fatalf("pgp.ArmoredKeysToKeyring: %v", err)
+	}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(keyring)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	return &buf
+}
+
+func TestACLBundleValidate(t *testing.T) {
+	b := testACLBundle(t)
+	err := b.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	b.ACL = ACL{}
+	err = b.Validate()
+	if err == nil {
+		t.Fatalf("Validate should have failed with an empty acl")
+	}
+}
+
+func TestACLBundleVerifySignatures(t *testing.T) {
+	s1 := newTestACLBundleSigner(t, "signer one")
+	s2 := newTestACLBundleSigner(t, "signer two")
+	unknown := newTestACLBundleSigner(t, "unknown signer")
+
+	b := testACLBundle(t)
+	testACLBundleSign(t, &b, s1, s2, unknown)
+	cnt, err := b.VerifySignatures(testACLBundleKeyring(t, s1, s2))
+	if err != nil {
+		t.Fatalf("VerifySignatures: %v", err)
+	}
+	if cnt != 2 {
+		t.Fatalf("expected 2 valid signatures, got %v", cnt)
+	}
+
+	// changing the signed content invalidates the signatures, but the status
+	// is not signed
+	b.Status = "active"
+	cnt, err = b.VerifySignatures(testACLBundleKeyring(t, s1, s2))
+	if err != nil || cnt != 2 {
+		t.Fatalf("expected 2 valid signatures, got %v (%v)", cnt, err)
+	}
+	signed := b
+	b.Target = "name='test'"
+	cnt, err = b.VerifySignatures(testACLBundleKeyring(t, s1, s2))
+	if err != nil || cnt != 0 {
+		t.Fatalf("expected 0 valid signatures after changing the target, got %v (%v)", cnt, err)
+	}
+	b = signed
+	b.Timestamp = b.Timestamp.Add(time.Second)
+	cnt, err = b.VerifySignatures(testACLBundleKeyring(t, s1, s2))
+	if err != nil || cnt != 0 {
+		t.Fatalf("expected 0 valid signatures, got %v (%v)", cnt, err)
+	}
+}
+
+func TestACLBundleDuplicateSignature(t *testing.T) {
+	s1 := newTestACLBundleSigner(t, "signer one")
+
+	b := testACLBundle(t)
+	testACLBundleSign(t, &b, s1, s1)
+	_, err := b.VerifySignatures(testACLBundleKeyring(t, s1))
+	if err == nil {
+		t.Fatalf("VerifySignatures should have failed with a duplicate signature")
+	}
+}
+
+func TestACLBundleHash(t *testing.T) {
+	b := testACLBundle(t)
+	h1, err := b.Hash()
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	// the hash does not depend on the location of the timestamp
+	b.Timestamp = b.Timestamp.In(time.FixedZone("test", 3600))
+	h2, err := b.Hash()
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if h1 != h2 {
+		t.Fatalf("hash changed with the timestamp location")
+	}
+	b.Name = "other bundle"
+	h3, err := b.Hash()
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if h1 == h3 {
+		t.Fatalf("hash did not change with the bundle name")
+	}
+}
@@ -25,6 +25,14 @@ import (
 // ActionVersion is the version of the syntax that is expected
 const ActionVersion uint16 = 2
 
+// Statuses of actions submitted for approval. An action pending approval is not
+// picked up by the scheduler until it has been co-signed by enough investigators,
+// at which point it becomes pending.
+const (
+	ActionStatusPendingApproval = "pendingapproval"
+	ActionStatusRejected        = "rejected"
+)
+
 // Action is the json object that is created by an investigator
 // and provided to the MIG platform. It must be PGP signed.
 type Action struct {
@@ -58,6 +66,13 @@ type ActionCounters struct {
 	TimeOut   int `json:"timeout,omitempty"`
 }
 
+// ActionStreamStatus is sent by the API in streams of action results to report
+// the progress of an action
+type ActionStreamStatus struct {
+	Status   string         `json:"status"`
+	Counters ActionCounters `json:"counters"`
+}
+
 // Description is a simple object that contains detail about the
 // action's author, and it's revision.
 type Description struct {
@@ -392,6 +407,52 @@ func (a Action) VerifyACL(acl ACL, keyring io.Reader, onlyVerifyPubKey bool) (er
 	return
 }
 
+// VerifyApproval validates that the signatures of an action pending approval carry
+// enough weight for the action to be released to the scheduler. If acl is not empty,
+// the signatures must satisfy it for every operation of the action, in the same way
+// agents verify actions. Otherwise, each investigator has a weight of 1 and the action
+// must be signed by at least minweight different investigators. In both cases, only
+// the signatures of investigators for whom canApprove returns true are counted.
+func (a Action) VerifyApproval(acl ACL, minweight int, keyring io.Reader, canApprove func(fp string) bool) (err error) {
+	astr, err := a.String()
+	if err != nil {
+		return errors.New("failed to stringify action")
+	}
+	keycopy, err := ioutil.ReadAll(keyring)
+	if err != nil {
+		return err
+	}
+	var (
+		fingerprints []string
+		approvals    []string
+	)
+	for _, sig := range a.PGPSignatures {
+		fp, err := pgp.GetFingerprintFromSignature(astr, sig, bytes.NewBuffer(keycopy))
+		if err != nil {
+			return fmt.Errorf("failed to retrieve fingerprint from signatures: %v", err)
+		}
+		for _, seen := range fingerprints {
+			if seen == fp {
+				return fmt.Errorf("key %v used to sign multiple times", fp)
+			}
+		}
+		fingerprints = append(fingerprints, fp)
+		if canApprove(fp) {
+			approvals = append(approvals, sig)
+		}
+	}
+	if len(acl) > 0 {
+		approved := a
+		approved.PGPSignatures = approvals
+		return approved.VerifyACL(acl, bytes.NewBuffer(keycopy), false)
+	}
+	if len(approvals) < minweight {
+		return fmt.Errorf("insufficient signatures weight (need %v, got %v)",
+			minweight, len(approvals))
+	}
+	return
+}
+
 // PrintCounters prints the counters of an action to stderr
 func (a Action) PrintCounters() {
 	out := fmt.Sprintf("%d sent, %d done", a.Counters.Sent, a.Counters.Done)
@@ -7,8 +7,11 @@
 package mig
 
 import (
+	"bytes"
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mozilla/mig/pgp"
 )
@@ -589,3 +592,83 @@ func TestVerifyNoKeyring(t *testing.T) {
 		t.Fatalf("VerifyACL should have failed")
 	}
 }
+
+func TestVerifyApproval(t *testing.T) {
+	s1 := newTestACLBundleSigner(t, "approval signer 1")
+	s2 := newTestACLBundleSigner(t, "approval signer 2")
+	keyring := testACLBundleKeyring(t, s1, s2)
+
+	a := Action{
+		Name:          "approval test",
+		Target:        "status='online'",
+		ValidFrom:     time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC),
+		ExpireAfter:   time.Date(2017, 10, 1, 13, 0, 0, 0, time.UTC),
+		Operations:    []Operation{{Module: "file", Parameters: map[string]interface{}{"searches": nil}}},
+		SyntaxVersion: ActionVersion,
+	}
+	sign := func(s testACLBundleSigner) {
+		sig, err := a.Sign(s.fp, bytes.NewBuffer(s.secring))
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		a.PGPSignatures = append(a.PGPSignatures, sig)
+	}
+
+	approvers := map[string]bool{s1.fp: true, s2.fp: true}
+	canApprove := func(fp string) bool { return approvers[strings.ToUpper(fp)] }
+
+	sign(s1)
+	err := a.VerifyApproval(nil, 2, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err == nil {
+		t.Fatalf("VerifyApproval should have failed with a single signature")
+	}
+	// the same investigator signing twice does not add weight
+	dup := a
+	dup.PGPSignatures = append([]string{}, a.PGPSignatures...)
+	dup.PGPSignatures = append(dup.PGPSignatures, a.PGPSignatures[0])
+	err = dup.VerifyApproval(nil, 2, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err == nil {
+		t.Fatalf("VerifyApproval should have failed with a duplicate signature")
+	}
+	sign(s2)
+	err = a.VerifyApproval(nil, 2, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err != nil {
+		t.Fatalf("VerifyApproval: %v", err)
+	}
+	// signatures of investigators without the approve permission are not counted
+	approvers[s2.fp] = false
+	err = a.VerifyApproval(nil, 2, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err == nil {
+		t.Fatalf("VerifyApproval should have failed without the approve permission")
+	}
+
+	// with an approval acl, the weights of the acl are used
+	var acl ACL
+	aclstr := `{
+		"default": {
+			"minimumweight": 3,
+			"investigators": {
+				"signer 1": {"fingerprint": "` + s1.fp + `", "weight": 2},
+				"signer 2": {"fingerprint": "` + s2.fp + `", "weight": 1}
+			}
+		}
+	}`
+	err = json.Unmarshal([]byte(aclstr), &acl)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	err = a.VerifyApproval(acl, 0, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err == nil {
+		t.Fatalf("VerifyApproval should have failed without the approve permission")
+	}
+	approvers[s2.fp] = true
+	err = a.VerifyApproval(acl, 0, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err != nil {
+		t.Fatalf("VerifyApproval: %v", err)
+	}
+	a.PGPSignatures = a.PGPSignatures[1:]
+	err = a.VerifyApproval(acl, 0, bytes.NewBuffer(keyring.Bytes()), canApprove)
+	if err == nil {
+		t.Fatalf("VerifyApproval should have failed with insufficient acl weight")
+	}
+}
@@ -34,6 +34,7 @@ type Agent struct {
 	Authorized      bool              `json:"authorized,omitempty"`
 	Env             AgentEnv          `json:"environment,omitempty"`
 	Tags            map[string]string `json:"tags,omitempty"`
+	ACLHash         string            `json:"aclhash,omitempty"`
 }
 
 // AgentEnv stores basic information of the endpoint
@@ -29,6 +29,7 @@ import (
 	"golang.org/x/crypto/openpgp"
 	"gopkg.in/gcfg.v1"
 	"github.com/mozilla/mig"
+	migtarget "github.com/mozilla/mig/database/target"
 	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/pgp"
 )
@@ -330,7 +331,7 @@ func MakeConfiguration(file string) (err error) {
 	fmt.Fprintf(fd, "[api]\n\turl = \"%v\"\n", cfg.API.URL)
 	fmt.Fprintf(fd, "[gpg]\n\thome = \"%v\"\n\tkeyid = \"%v\"\n", cfg.GPG.Home, cfg.GPG.KeyID)
 	// Add one initial target macro
-	fmt.Fprintf(fd, "[targets]\n\tmacro = allonline:status='online'\n")
+	fmt.Fprintf(fd, "[targets]\n\tmacro = allonline:status = online\n")
 	fd.Close()
 	fmt.Printf("\ncreated configuration file at %v\n\n", file)
 	return
@@ -845,6 +846,36 @@ func (cli Client) PostAction(a mig.Action) (a2 mig.Action, err error) {
 			err = fmt.Errorf("PostAction() -> %v", e)
 		}
 	}()
+	a2, err = cli.postAction(a, false)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostActionForApproval submits a partially signed MIG Action to the API. The action
+// is held in the pendingapproval status until other investigators co-sign it, and
+// the reflected action with API ID is returned.
+func (cli Client) PostActionForApproval(a mig.Action) (a2 mig.Action, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostActionForApproval() -> %v", e)
+		}
+	}()
+	a2, err = cli.postAction(a, true)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// postAction submits a MIG Action to the API, for approval if approval is set
+func (cli Client) postAction(a mig.Action, approval bool) (a2 mig.Action, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("postAction() -> %v", e)
+		}
+	}()
 	a.SyntaxVersion = mig.ActionVersion
 	// serialize
 	ajson, err := json.Marshal(a)
@@ -853,6 +884,9 @@ func (cli Client) PostAction(a mig.Action) (a2 mig.Action, err error) {
 	}
 	actionstr := string(ajson)
 	data := url.Values{"action": {actionstr}}
+	if approval {
+		data.Set("approval", "true")
+	}
 	r, err := http.NewRequest("POST", cli.Conf.API.URL+"action/create/", strings.NewReader(data.Encode()))
 	if err != nil {
 		panic(err)
@@ -886,6 +920,157 @@ func (cli Client) PostAction(a mig.Action) (a2 mig.Action, err error) {
 	return
 }
 
+// GetPendingApprovals retrieves the actions that are pending approval from the API
+func (cli Client) GetPendingApprovals() (actions []mig.Action, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetPendingApprovals() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource("action/approval/")
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "action" {
+				continue
+			}
+			a, err := ValueToAction(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			actions = append(actions, a)
+		}
+	}
+	return
+}
+
+// GetApprovalSigningString retrieves an action pending approval from the API, along
+// with the string investigators sign to approve it
+func (cli Client) GetApprovalSigningString(aid float64) (a mig.Action, str string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetApprovalSigningString() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource(fmt.Sprintf("action/approval/signingstring?actionid=%.0f", aid))
+	if err != nil {
+		panic(err)
+	}
+	for _, data := range resource.Collection.Items[0].Data {
+		switch data.Name {
+		case "action":
+			a, err = ValueToAction(data.Value)
+			if err != nil {
+				panic(err)
+			}
+		case "signing string":
+			str = data.Value.(string)
+		}
+	}
+	if a.ID == 0 || str == "" {
+		panic("API returned an incomplete approval response")
+	}
+	return
+}
+
+// ApproveAction co-signs action aid, which must be pending approval, with the key
+// identified in the configuration. The signature is computed over the action
+// as returned by the API, and the string the API expects is verified to match it
+// before signing. The updated action is returned, its status becomes pending once
+// it has enough signatures.
+func (cli Client) ApproveAction(aid float64) (a2 mig.Action, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("ApproveAction() -> %v", e)
+		}
+	}()
+	a, str, err := cli.GetApprovalSigningString(aid)
+	if err != nil {
+		panic(err)
+	}
+	localstr, err := a.String()
+	if err != nil {
+		panic(err)
+	}
+	if localstr != str {
+		panic("signing string returned by the API does not match the action")
+	}
+	secring, err := os.Open(cli.Conf.GPG.Home + "/secring.gpg")
+	if err != nil {
+		panic(err)
+	}
+	defer secring.Close()
+	sig, err := pgp.Sign(str, cli.Conf.GPG.KeyID, secring)
+	if err != nil {
+		panic(err)
+	}
+	data := url.Values{"actionid": {fmt.Sprintf("%.0f", aid)}, "signature": {sig}}
+	resource, err := cli.postApproval("action/approval/sign/", data)
+	if err != nil {
+		panic(err)
+	}
+	a2, err = ValueToAction(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// RejectAction rejects action aid, which must be pending approval
+func (cli Client) RejectAction(aid float64) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("RejectAction() -> %v", e)
+		}
+	}()
+	data := url.Values{"actionid": {fmt.Sprintf("%.0f", aid)}}
+	_, err = cli.postApproval("action/approval/reject/", data)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// postApproval posts an approval form to the API
+func (cli Client) postApproval(path string, data url.Values) (resource *cljs.Resource, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("postApproval() -> %v", e)
+		}
+	}()
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+path, strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resource == nil {
+			panic(fmt.Sprintf("error: HTTP %d. approval request failed", resp.StatusCode))
+		}
+		err = fmt.Errorf("error: HTTP %d. approval request failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	return
+}
+
 // ValueToAction converts JSON data in interface v into a mig.Action
 func ValueToAction(v interface{}) (a mig.Action, err error) {
 	defer func() {
@@ -1240,6 +1425,346 @@ func (cli Client) PostInvestigatorAPIKeyStatus(iid float64, newstatus string) (i
 	return
 }
 
+// GetAgentModulePermissions retrieves the module requirements and the investigators
+// module permissions set on an agent
+func (cli Client) GetAgentModulePermissions(agtid float64) (reqs []mig.ModuleRequirement,
+	perms []mig.ModulePermission, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetAgentModulePermissions() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource("modulepermission?agentid=" + fmt.Sprintf("%.0f", agtid))
+	if err != nil {
+		panic(err)
+	}
+	reqs, perms, err = valueToModulePermissions(resource)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// GetInvestigatorModulePermissions retrieves the module permissions an investigator
+// has on agents
+func (cli Client) GetInvestigatorModulePermissions(iid float64) (perms []mig.ModulePermission, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetInvestigatorModulePermissions() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource("modulepermission?investigatorid=" + fmt.Sprintf("%.0f", iid))
+	if err != nil {
+		panic(err)
+	}
+	_, perms, err = valueToModulePermissions(resource)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostModuleRequirement sets the minimum signature weight required to run a module on
+// the agent req.AgentID, or on all the active agents matching target if target is not
+// empty. A minimum weight of 0 removes the requirement.
+func (cli Client) PostModuleRequirement(req mig.ModuleRequirement, target string) (reqs []mig.ModuleRequirement, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostModuleRequirement() -> %v", e)
+		}
+	}()
+	data := url.Values{"module": {req.Module}, "minimumweight": {fmt.Sprintf("%d", req.MinimumWeight)}}
+	if target != "" {
+		data.Set("target", target)
+	} else {
+		data.Set("agentid", fmt.Sprintf("%.0f", req.AgentID))
+	}
+	resource, err := cli.postModulePermission("modulepermission/requirement/", data)
+	if err != nil {
+		panic(err)
+	}
+	reqs, _, err = valueToModulePermissions(resource)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostModulePermission sets the weight of investigator perm.InvestigatorID for a module
+// on the agent perm.AgentID, or on all the active agents matching target if target is
+// not empty. A weight of 0 removes the permission.
+func (cli Client) PostModulePermission(perm mig.ModulePermission, target string) (perms []mig.ModulePermission, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostModulePermission() -> %v", e)
+		}
+	}()
+	data := url.Values{"investigatorid": {fmt.Sprintf("%.0f", perm.InvestigatorID)},
+		"module": {perm.Module}, "weight": {fmt.Sprintf("%d", perm.Weight)}}
+	if target != "" {
+		data.Set("target", target)
+	} else {
+		data.Set("agentid", fmt.Sprintf("%.0f", perm.AgentID))
+	}
+	resource, err := cli.postModulePermission("modulepermission/investigator/", data)
+	if err != nil {
+		panic(err)
+	}
+	_, perms, err = valueToModulePermissions(resource)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// postModulePermission posts a module permission update form to the API
+func (cli Client) postModulePermission(path string, data url.Values) (resource *cljs.Resource, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("postModulePermission() -> %v", e)
+		}
+	}()
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+path, strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resource == nil {
+			panic(fmt.Sprintf("error: HTTP %d. module permission update failed", resp.StatusCode))
+		}
+		err = fmt.Errorf("error: HTTP %d. module permission update failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	return
+}
+
+// valueToModulePermissions extracts module requirements and module permissions from
+// an API resource
+func valueToModulePermissions(resource *cljs.Resource) (reqs []mig.ModuleRequirement,
+	perms []mig.ModulePermission, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("valueToModulePermissions() -> %v", e)
+		}
+	}()
+	if resource == nil || len(resource.Collection.Items) == 0 {
+		panic("API returned no module permissions")
+	}
+	for _, item := range resource.Collection.Items[0].Data {
+		bData, err := json.Marshal(item.Value)
+		if err != nil {
+			panic(err)
+		}
+		switch item.Name {
+		case "module requirements":
+			err = json.Unmarshal(bData, &reqs)
+		case "module permissions":
+			err = json.Unmarshal(bData, &perms)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	return
+}
+
+// GetACLBundle retrieves an ACL bundle from the API using its ID
+func (cli Client) GetACLBundle(bid float64) (b mig.ACLBundle, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetACLBundle() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource(fmt.Sprintf("aclbundle?aclbundleid=%.0f", bid))
+	if err != nil {
+		panic(err)
+	}
+	if resource.Collection.Items[0].Data[0].Name != "aclbundle" {
+		panic("API returned something that is not an acl bundle... something's wrong.")
+	}
+	b, err = valueToACLBundle(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// GetACLBundleAgents retrieves the active agents ACL bundle bid applies to, and
+// whether they have applied it yet
+func (cli Client) GetACLBundleAgents(bid float64) (agents []mig.ACLBundleAgent, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetACLBundleAgents() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource(fmt.Sprintf("aclbundle/agents/?aclbundleid=%.0f", bid))
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "aclbundle agent" {
+				continue
+			}
+			bData, err := json.Marshal(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			var agt mig.ACLBundleAgent
+			err = json.Unmarshal(bData, &agt)
+			if err != nil {
+				panic(err)
+			}
+			agents = append(agents, agt)
+		}
+	}
+	return
+}
+
+// PostNewACLBundle posts a new ACL bundle to the API, and returns the bundle as stored
+// by the API
+func (cli Client) PostNewACLBundle(b mig.ACLBundle) (ret mig.ACLBundle, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostNewACLBundle() -> %v", e)
+		}
+	}()
+	buf, err := json.Marshal(b)
+	if err != nil {
+		panic(err)
+	}
+	resource, err := cli.postACLBundle("aclbundle/new/", url.Values{"aclbundle": {string(buf)}},
+		http.StatusCreated)
+	if err != nil {
+		panic(err)
+	}
+	ret, err = valueToACLBundle(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostACLBundleSignature adds signature sig to ACL bundle b
+func (cli Client) PostACLBundleSignature(b mig.ACLBundle, sig string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostACLBundleSignature() -> %v", e)
+		}
+	}()
+	data := url.Values{"aclbundleid": {fmt.Sprintf("%.0f", b.ID)}, "signature": {sig}}
+	_, err = cli.postACLBundle("aclbundle/sign/", data, http.StatusOK)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// ACLBundleStatus changes the status of ACL bundle b, status can be staged or disabled
+func (cli Client) ACLBundleStatus(b mig.ACLBundle, status string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("ACLBundleStatus() -> %v", e)
+		}
+	}()
+	data := url.Values{"aclbundleid": {fmt.Sprintf("%.0f", b.ID)}, "status": {status}}
+	_, err = cli.postACLBundle("aclbundle/status/", data, http.StatusOK)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SignACLBundle signs ACL bundle b with the key keyid from the secring of the client,
+// or with the key of the investigator if keyid is empty. ACL bundles are usually signed
+// with dedicated ACL signing keys.
+func (cli Client) SignACLBundle(b mig.ACLBundle, keyid string) (ret string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("SignACLBundle() -> %v", e)
+		}
+	}()
+	if keyid == "" {
+		keyid = cli.Conf.GPG.KeyID
+	}
+	secring, err := os.Open(cli.Conf.GPG.Home + "/secring.gpg")
+	if err != nil {
+		panic(err)
+	}
+	defer secring.Close()
+	ret, err = b.Sign(keyid, secring)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// postACLBundle posts an ACL bundle form to the API, and checks the API responded
+// with the expected status code
+func (cli Client) postACLBundle(path string, data url.Values, expect int) (resource *cljs.Resource, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("postACLBundle() -> %v", e)
+		}
+	}()
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+path, strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != expect {
+		if resource == nil {
+			panic(fmt.Sprintf("error: HTTP %d. acl bundle request failed", resp.StatusCode))
+		}
+		err = fmt.Errorf("error: HTTP %d. acl bundle request failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	return
+}
+
+// valueToACLBundle converts JSON data in interface v into a mig.ACLBundle
+func valueToACLBundle(v interface{}) (b mig.ACLBundle, err error) {
+	bData, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(bData, &b)
+	return
+}
+
 // ValueToInvestigator converts JSON data in interface v into a mig.Investigator
 func ValueToInvestigator(v interface{}) (inv mig.Investigator, err error) {
 	defer func() {
@@ -1368,6 +1893,10 @@ func (cli Client) EvaluateAgentTarget(target string) (agents []mig.Agent, err er
 			err = fmt.Errorf("EvaluateAgentTarget() -> %v", e)
 		}
 	}()
+	err = migtarget.Validate(target)
+	if err != nil {
+		panic(err)
+	}
 	query := "search?type=agent&limit=1000000&target=" + url.QueryEscape(target)
 	resource, err := cli.GetAPIResource(query)
 	if err != nil {
@@ -1396,12 +1925,21 @@ func (cli Client) EvaluateAgentTarget(target string) (agents []mig.Agent, err er
 //
 // stop is of type chan bool, and passing a value to this channel will cause the routine to return
 // immediately.
+//
+// If the API provides streams of action results, the completion is updated as commands return,
+// otherwise the action is polled every two seconds.
 func (cli Client) FollowAction(a mig.Action, total int, stop chan bool) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("followAction() -> %v", e)
 		}
 	}()
+	// use the stream of results when the API provides it, and poll the action otherwise
+	err = cli.FollowActionResults(a, total, stop, nil)
+	if err != ErrStreamUnsupported {
+		return
+	}
+	err = nil
 	fmt.Fprintf(os.Stderr, "\x1b[34mFollowing action ID %.0f.\x1b[0m\n", a.ID)
 	previousctr := 0
 	status := ""
@@ -1459,17 +1997,23 @@ finish:
 	bar.Add(total - previousctr)
 	bar.Update()
 	bar.Finish()
-	a, _, err = cli.GetAction(a.ID)
+	cli.printFollowSummary(a.ID)
+	return
+}
+
+// printFollowSummary prints the completion and counters of an action that
+// has been followed to stderr
+func (cli Client) printFollowSummary(aid float64) {
+	a, _, err := cli.GetAction(aid)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[error] failed to retrieve action counters\n")
 	} else {
-		completion = (float64(a.Counters.Done) / float64(a.Counters.Sent)) * 100
+		completion := (float64(a.Counters.Done) / float64(a.Counters.Sent)) * 100
 		fmt.Fprintf(os.Stderr, "\x1b[34m%2.1f%% done in %s\x1b[0m\n", completion, time.Now().Sub(a.StartTime).String())
 	}
 	fmt.Fprintf(os.Stderr, "\x1b[34m")
 	a.PrintCounters()
 	fmt.Fprintf(os.Stderr, "\x1b[0m")
-	return
 }
 
 // FetchActionResults retrieves mig command results associated with a
@@ -1694,6 +2238,24 @@ func PrintCommandResults(cmd mig.Command, onlyFound, showAgent bool) (err error)
 	return
 }
 
+// ShowCommand returns true if the results of cmd should be displayed when showing
+// results of type show, which can be found, notfound or all, as PrintActionResults does
+func ShowCommand(cmd mig.Command, show string) bool {
+	switch show {
+	case "found", "notfound":
+		if cmd.Status != mig.StatusSuccess {
+			return false
+		}
+		for _, r := range cmd.Results {
+			if r.FoundAnything == (show == "found") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
 // EnableDebug enables debugging mode in the client
 func (cli *Client) EnableDebug() {
 	cli.debug = true
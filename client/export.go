@@ -0,0 +1,426 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package client /* import "github.com/mozilla/mig/client" */
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/modules"
+)
+
+// ExportFormats lists the formats supported by NewResultExporter
+var ExportFormats = []string{"ndjson", "csv", "columnar"}
+
+// baseColumns are the columns describing the command and the agent that ran it,
+// they are written first in tabular formats. Agent tags and environment columns
+// are inserted after agent.id.
+var baseColumns = []string{"action.id", "action.name", "command.id", "command.status",
+	"agent.name", "agent.id", "operation", "module", "foundanything", "errors"}
+
+// ResultExporter writes the results of commands as flat rows in a given format
+type ResultExporter interface {
+	// AddCommand flattens the results of cmd into rows and adds them to the
+	// export. If onlyFound is set, only results that found something are added.
+	AddCommand(cmd mig.Command, onlyFound bool) error
+	// Close flushes the export to the underlying writer
+	Close() error
+}
+
+// NewResultExporter returns an exporter that writes rows to w in the given format.
+//
+// ndjson writes one JSON object per row as commands are added. csv and columnar
+// need to know every column before writing anything, so rows are kept in memory
+// until Close is called. columnar writes a single JSON document where each column
+// holds a name, a type (string, number or boolean) and the list of its values.
+func NewResultExporter(format string, w io.Writer) (ResultExporter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonExporter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvExporter{w: w}, nil
+	case "columnar":
+		return &columnarExporter{w: w}, nil
+	}
+	return nil, ValidateExportFormat(format)
+}
+
+// ValidateExportFormat returns an error if format is not a supported export format
+func ValidateExportFormat(format string) error {
+	for _, f := range ExportFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown export format '%s', must be one of %s",
+		format, strings.Join(ExportFormats, ", "))
+}
+
+// FlattenCommandResults returns the results of cmd as flat rows. Each row contains
+// the command and agent columns, followed by the columns returned by the module
+// prefixed with "result.". Commands that did not succeed, and operations that did
+// not find anything, are returned as a single row without result columns unless
+// onlyFound is set.
+func FlattenCommandResults(cmd mig.Command, onlyFound bool) (rows []modules.ResultRow, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("FlattenCommandResults() -> %v", e)
+		}
+	}()
+	base := commandBaseRow(cmd)
+	if cmd.Status != mig.StatusSuccess {
+		if !onlyFound {
+			rows = append(rows, base)
+		}
+		return
+	}
+	for i, result := range cmd.Results {
+		if onlyFound && !result.FoundAnything {
+			continue
+		}
+		oprow := copyRow(base)
+		oprow["operation"] = float64(i)
+		oprow["foundanything"] = result.FoundAnything
+		errs := result.Errors
+		var moduleName string
+		if len(cmd.Action.Operations) > i {
+			moduleName = cmd.Action.Operations[i].Module
+			oprow["module"] = moduleName
+		} else {
+			errs = append(errs, fmt.Sprintf("operation %d is not part of the action", i))
+		}
+		var modrows []modules.ResultRow
+		if _, ok := modules.Available[moduleName]; ok {
+			run := modules.Available[moduleName].NewRun()
+			// look for a result flattener in the module, and fallback to flattening
+			// the raw elements if it doesn't have one
+			if _, ok := run.(modules.HasResultsFlattener); ok {
+				modrows, err = run.(modules.HasResultsFlattener).FlattenResults(result, onlyFound)
+			} else {
+				modrows, err = modules.FlattenElements(result)
+			}
+			if err != nil {
+				panic(err)
+			}
+		} else if moduleName != "" {
+			errs = append(errs, fmt.Sprintf("unknown module '%s'", moduleName))
+		}
+		if len(errs) > 0 {
+			oprow["errors"] = strings.Join(errs, "; ")
+		}
+		if len(modrows) == 0 {
+			if !onlyFound {
+				rows = append(rows, oprow)
+			}
+			continue
+		}
+		for _, modrow := range modrows {
+			row := copyRow(oprow)
+			for k, v := range modrow {
+				row["result."+k] = v
+			}
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// commandBaseRow returns a row with the columns describing cmd and its agent
+func commandBaseRow(cmd mig.Command) modules.ResultRow {
+	row := modules.ResultRow{
+		"action.id":      cmd.Action.ID,
+		"action.name":    cmd.Action.Name,
+		"command.id":     cmd.ID,
+		"command.status": cmd.Status,
+		"agent.name":     cmd.Agent.Name,
+		"agent.id":       cmd.Agent.ID,
+	}
+	for k, v := range cmd.Agent.Tags {
+		row["agent.tags."+k] = v
+	}
+	env := cmd.Agent.Env
+	for k, v := range map[string]string{
+		"init":             env.Init,
+		"ident":            env.Ident,
+		"os":               env.OS,
+		"arch":             env.Arch,
+		"proxy":            env.Proxy,
+		"publicip":         env.PublicIP,
+		"addresses":        strings.Join(env.Addresses, ","),
+		"aws.instanceid":   env.AWS.InstanceID,
+		"aws.localipv4":    env.AWS.LocalIPV4,
+		"aws.amiid":        env.AWS.AMIID,
+		"aws.instancetype": env.AWS.InstanceType,
+	} {
+		if v != "" {
+			row["agent.env."+k] = v
+		}
+	}
+	row["agent.env.isproxied"] = env.IsProxied
+	return row
+}
+
+func copyRow(in modules.ResultRow) modules.ResultRow {
+	out := make(modules.ResultRow, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// rowColumns returns the union of the columns of rows. Base columns come first,
+// with agent tags and environment after agent.id, then result columns sorted by name.
+func rowColumns(rows []modules.ResultRow) (columns []string) {
+	var agentCols, otherCols []string
+	seen := make(map[string]bool)
+	for _, c := range baseColumns {
+		seen[c] = true
+	}
+	for _, row := range rows {
+		for k := range row {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if strings.HasPrefix(k, "agent.") {
+				agentCols = append(agentCols, k)
+			} else {
+				otherCols = append(otherCols, k)
+			}
+		}
+	}
+	sort.Strings(agentCols)
+	sort.Strings(otherCols)
+	for _, c := range baseColumns {
+		columns = append(columns, c)
+		if c == "agent.id" {
+			columns = append(columns, agentCols...)
+		}
+	}
+	return append(columns, otherCols...)
+}
+
+// valueToString formats the value of a cell for text based formats
+func valueToString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// valueType returns the columnar type of a cell value
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return "boolean"
+	case float64, float32, int, int32, int64, uint, uint32, uint64:
+		return "number"
+	}
+	return "string"
+}
+
+// ndjsonExporter writes each row as a JSON object on its own line
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) AddCommand(cmd mig.Command, onlyFound bool) (err error) {
+	rows, err := FlattenCommandResults(cmd, onlyFound)
+	if err != nil {
+		return
+	}
+	for _, row := range rows {
+		err = e.enc.Encode(row)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *ndjsonExporter) Close() error {
+	return nil
+}
+
+// csvExporter writes rows as CSV with a header line
+type csvExporter struct {
+	w    io.Writer
+	rows []modules.ResultRow
+}
+
+func (e *csvExporter) AddCommand(cmd mig.Command, onlyFound bool) (err error) {
+	rows, err := FlattenCommandResults(cmd, onlyFound)
+	if err != nil {
+		return
+	}
+	e.rows = append(e.rows, rows...)
+	return
+}
+
+func (e *csvExporter) Close() (err error) {
+	columns := rowColumns(e.rows)
+	cw := csv.NewWriter(e.w)
+	err = cw.Write(columns)
+	if err != nil {
+		return
+	}
+	for _, row := range e.rows {
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			record[i] = valueToString(row[c])
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// columnarExporter writes rows as a JSON document organized by columns
+type columnarExporter struct {
+	w    io.Writer
+	rows []modules.ResultRow
+}
+
+type columnarDocument struct {
+	Rows    int              `json:"rows"`
+	Columns []columnarColumn `json:"columns"`
+}
+
+type columnarColumn struct {
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	Values []interface{} `json:"values"`
+}
+
+func (e *columnarExporter) AddCommand(cmd mig.Command, onlyFound bool) (err error) {
+	rows, err := FlattenCommandResults(cmd, onlyFound)
+	if err != nil {
+		return
+	}
+	e.rows = append(e.rows, rows...)
+	return
+}
+
+func (e *columnarExporter) Close() (err error) {
+	doc := columnarDocument{Rows: len(e.rows)}
+	for _, name := range rowColumns(e.rows) {
+		col := columnarColumn{Name: name, Values: make([]interface{}, len(e.rows))}
+		for i, row := range e.rows {
+			t := valueType(row[name])
+			if t == "" {
+				continue
+			}
+			if col.Type == "" {
+				col.Type = t
+			} else if col.Type != t {
+				col.Type = "mixed"
+			}
+			col.Values[i] = row[name]
+		}
+		switch col.Type {
+		case "":
+			col.Type = "string"
+		case "mixed":
+			// values of different types are stored as strings
+			col.Type = "string"
+			for i, v := range col.Values {
+				if v != nil {
+					col.Values[i] = valueToString(v)
+				}
+			}
+		}
+		doc.Columns = append(doc.Columns, col)
+	}
+	return json.NewEncoder(e.w).Encode(doc)
+}
+
+// ExportActionResults retrieves the results of action a and writes them to w
+// in the given format. show can be "found", "notfound" or "all".
+func (cli Client) ExportActionResults(a mig.Action, show, format string, w io.Writer) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("ExportActionResults() -> %v", e)
+		}
+	}()
+	var (
+		found                   bool
+		foundQ                  string
+		limit, offset, agtCount int = 37, 0, 0
+	)
+	switch show {
+	case "found":
+		found = true
+		foundQ = "&foundanything=true"
+	case "notfound":
+		foundQ = "&foundanything=false"
+	case "all":
+	default:
+		return fmt.Errorf("invalid parameter '%s'", show)
+	}
+	exp, err := NewResultExporter(format, w)
+	if err != nil {
+		panic(err)
+	}
+	// loop until all results have been retrieved using paginated queries
+	for {
+		target := fmt.Sprintf("search?type=command&limit=%d&offset=%d&actionid=%.0f%s", limit, offset, a.ID, foundQ)
+		resource, err := cli.GetAPIResource(target)
+		// because we query using pagination, the last query will return a 404 with no result.
+		// When that happens, GetAPIResource returns an error which we do not report to the user
+		switch resource.Collection.Error.Message {
+		case "", "no results found":
+			err = nil
+		default:
+			panic(err)
+		}
+		count := 0
+		for _, item := range resource.Collection.Items {
+			for _, data := range item.Data {
+				if data.Name != "command" {
+					continue
+				}
+				cmd, err := ValueToCommand(data.Value)
+				if err != nil {
+					panic(err)
+				}
+				err = exp.AddCommand(cmd, found)
+				if err != nil {
+					panic(err)
+				}
+				count++
+			}
+		}
+		if count == 0 {
+			break
+		}
+		offset += limit
+		agtCount += count
+	}
+	err = exp.Close()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d agents exported with %s results\n", agtCount, show)
+	return
+}
@@ -0,0 +1,362 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package client /* import "github.com/mozilla/mig/client" */
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/modules"
+)
+
+// elementsModule has no result flattener, its results are flattened by
+// modules.FlattenElements
+type elementsModule struct{}
+
+func (m *elementsModule) NewRun() modules.Runner {
+	return new(elementsRunner)
+}
+
+type elementsRunner struct{}
+
+func (r *elementsRunner) ValidateParameters() error {
+	return nil
+}
+
+func (r *elementsRunner) Run(in modules.ModuleReader) string {
+	return ""
+}
+
+// rowsModule returns one row per element of its results, and skips elements
+// with an empty name when only found results are requested
+type rowsModule struct{}
+
+func (m *rowsModule) NewRun() modules.Runner {
+	return new(rowsRunner)
+}
+
+type rowsRunner struct {
+	elementsRunner
+}
+
+func (r *rowsRunner) FlattenResults(result modules.Result, foundOnly bool) (rows []modules.ResultRow, err error) {
+	var el []string
+	err = result.GetElements(&el)
+	if err != nil {
+		return
+	}
+	for _, name := range el {
+		if name == "" && foundOnly {
+			continue
+		}
+		rows = append(rows, modules.ResultRow{"name": name})
+	}
+	return
+}
+
+func init() {
+	modules.Register("exportelements", new(elementsModule))
+	modules.Register("exportrows", new(rowsModule))
+}
+
+func testCommand(id float64, module string, results ...modules.Result) mig.Command {
+	var cmd mig.Command
+	cmd.ID = id
+	cmd.Status = mig.StatusSuccess
+	cmd.Action.ID = 1
+	cmd.Action.Name = "export test"
+	cmd.Action.Operations = []mig.Operation{{Module: module}}
+	cmd.Agent.ID = id
+	cmd.Agent.Name = "agent.example.net"
+	cmd.Results = results
+	return cmd
+}
+
+func TestFlattenCommandResults(t *testing.T) {
+	failed := testCommand(1, "exportelements")
+	failed.Status = mig.StatusFailed
+	var tests = []struct {
+		Description string
+		Command     mig.Command
+		OnlyFound   bool
+		Expect      []modules.ResultRow
+	}{
+		{
+			Description: `failed command returns the base row`,
+			Command:     failed,
+			Expect: []modules.ResultRow{
+				{"command.status": mig.StatusFailed},
+			},
+		},
+		{
+			Description: `failed command is skipped with onlyFound`,
+			Command:     failed,
+			OnlyFound:   true,
+		},
+		{
+			Description: `nested elements are flattened without a module flattener`,
+			Command: testCommand(2, "exportelements", modules.Result{
+				FoundAnything: true,
+				Elements: map[string]interface{}{
+					"count":  3,
+					"nested": map[string]interface{}{"ok": true},
+				},
+			}),
+			Expect: []modules.ResultRow{
+				{
+					"operation":           float64(0),
+					"module":              "exportelements",
+					"foundanything":       true,
+					"result.count":        float64(3),
+					"result.nested.ok":    true,
+					"agent.env.isproxied": false,
+				},
+			},
+		},
+		{
+			Description: `module flattener returns one row per element`,
+			Command: testCommand(3, "exportrows", modules.Result{
+				FoundAnything: true,
+				Elements:      []string{"a", "", "b"},
+			}),
+			Expect: []modules.ResultRow{
+				{"result.name": "a"},
+				{"result.name": ""},
+				{"result.name": "b"},
+			},
+		},
+		{
+			Description: `module flattener is called with onlyFound`,
+			Command: testCommand(4, "exportrows", modules.Result{
+				FoundAnything: true,
+				Elements:      []string{"a", "", "b"},
+			}),
+			OnlyFound: true,
+			Expect: []modules.ResultRow{
+				{"result.name": "a"},
+				{"result.name": "b"},
+			},
+		},
+		{
+			Description: `results that found nothing are skipped with onlyFound`,
+			Command: testCommand(5, "exportrows", modules.Result{
+				Elements: []string{"a"},
+			}),
+			OnlyFound: true,
+		},
+		{
+			Description: `unknown module is reported in the errors column`,
+			Command:     testCommand(6, "nosuchmodule", modules.Result{Errors: []string{"failed"}}),
+			Expect: []modules.ResultRow{
+				{"module": "nosuchmodule", "errors": "failed; unknown module 'nosuchmodule'"},
+			},
+		},
+		{
+			Description: `results without an operation are reported in the errors column`,
+			Command:     testCommand(7, "exportrows", modules.Result{}, modules.Result{}),
+			Expect: []modules.ResultRow{
+				{"operation": float64(0), "module": "exportrows"},
+				{"operation": float64(1), "errors": "operation 1 is not part of the action"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			rows, err := FlattenCommandResults(tc.Command, tc.OnlyFound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tc.Expect) {
+				t.Fatalf("expected %d rows, got %d: %v", len(tc.Expect), len(rows), rows)
+			}
+			for i, expect := range tc.Expect {
+				if rows[i]["command.id"] != tc.Command.ID || rows[i]["agent.name"] != tc.Command.Agent.Name {
+					t.Fatalf("row %d is missing the base columns: %v", i, rows[i])
+				}
+				for k, v := range expect {
+					if rows[i][k] != v {
+						t.Fatalf("row %d column %s has value %v, expected %v", i, k, rows[i][k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+// exportCommands flattens cmds with an exporter of the given format and returns
+// its output
+func exportCommands(t *testing.T, format string, cmds ...mig.Command) []byte {
+	var buf bytes.Buffer
+	exp, err := NewResultExporter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range cmds {
+		err = exp.AddCommand(cmd, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = exp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func csvRecords(t *testing.T, data []byte) [][]string {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func columnarDoc(t *testing.T, data []byte) (doc columnarDocument) {
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestExportColumnOrder(t *testing.T) {
+	first := testCommand(1, "exportelements", modules.Result{
+		FoundAnything: true,
+		Elements:      map[string]interface{}{"zeta": "z", "alpha": "a"},
+	})
+	first.Agent.Tags = map[string]string{"operator": "it"}
+	first.Agent.Env.OS = "linux"
+	second := testCommand(2, "exportelements", modules.Result{
+		FoundAnything: true,
+		Elements:      map[string]interface{}{"mid": "m"},
+	})
+	second.Agent.Tags = map[string]string{"env": "prod"}
+	expect := []string{"action.id", "action.name", "command.id", "command.status",
+		"agent.name", "agent.id", "agent.env.isproxied", "agent.env.os",
+		"agent.tags.env", "agent.tags.operator", "operation", "module",
+		"foundanything", "errors", "result.alpha", "result.mid", "result.zeta"}
+	var tests = []struct {
+		Description string
+		Commands    []mig.Command
+	}{
+		{`commands in order`, []mig.Command{first, second}},
+		{`commands in reverse order`, []mig.Command{second, first}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			// run several times to catch ordering that depends on map iteration
+			for i := 0; i < 10; i++ {
+				records := csvRecords(t, exportCommands(t, "csv", tc.Commands...))
+				if len(records) != 3 {
+					t.Fatalf("expected a header and 2 records, got %d lines", len(records))
+				}
+				if !reflect.DeepEqual(records[0], expect) {
+					t.Fatalf("unexpected csv header\n got: %v\nwant: %v", records[0], expect)
+				}
+				doc := columnarDoc(t, exportCommands(t, "columnar", tc.Commands...))
+				var names []string
+				for _, col := range doc.Columns {
+					names = append(names, col.Name)
+				}
+				if !reflect.DeepEqual(names, expect) {
+					t.Fatalf("unexpected columnar columns\n got: %v\nwant: %v", names, expect)
+				}
+			}
+		})
+	}
+}
+
+func TestExportMixedTypes(t *testing.T) {
+	cmds := []mig.Command{
+		testCommand(1, "exportelements", modules.Result{
+			Elements: map[string]interface{}{"value": 12.5, "count": 1},
+		}),
+		testCommand(2, "exportelements", modules.Result{
+			Elements: map[string]interface{}{"value": "high", "count": 2},
+		}),
+		testCommand(3, "exportelements", modules.Result{
+			Elements: map[string]interface{}{"value": true},
+		}),
+	}
+	var tests = []struct {
+		Description string
+		Column      string
+		Type        string
+		Values      []interface{}
+		CSV         []string
+	}{
+		{
+			Description: `mixed values are stored as strings`,
+			Column:      "result.value",
+			Type:        "string",
+			Values:      []interface{}{"12.5", "high", "true"},
+			CSV:         []string{"12.5", "high", "true"},
+		},
+		{
+			Description: `missing values keep the column type`,
+			Column:      "result.count",
+			Type:        "number",
+			Values:      []interface{}{float64(1), float64(2), nil},
+			CSV:         []string{"1", "2", ""},
+		},
+		{
+			Description: `boolean column`,
+			Column:      "foundanything",
+			Type:        "boolean",
+			Values:      []interface{}{false, false, false},
+			CSV:         []string{"false", "false", "false"},
+		},
+		{
+			Description: `empty column`,
+			Column:      "errors",
+			Type:        "string",
+			Values:      []interface{}{nil, nil, nil},
+			CSV:         []string{"", "", ""},
+		},
+	}
+	doc := columnarDoc(t, exportCommands(t, "columnar", cmds...))
+	if doc.Rows != len(cmds) {
+		t.Fatalf("expected %d rows, got %d", len(cmds), doc.Rows)
+	}
+	records := csvRecords(t, exportCommands(t, "csv", cmds...))
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			var col *columnarColumn
+			for i := range doc.Columns {
+				if doc.Columns[i].Name == tc.Column {
+					col = &doc.Columns[i]
+				}
+			}
+			if col == nil {
+				t.Fatalf("column %s not found in columnar output", tc.Column)
+			}
+			if col.Type != tc.Type {
+				t.Fatalf("expected column type %s, got %s", tc.Type, col.Type)
+			}
+			if !reflect.DeepEqual(col.Values, tc.Values) {
+				t.Fatalf("unexpected columnar values\n got: %#v\nwant: %#v", col.Values, tc.Values)
+			}
+			idx := -1
+			for i, name := range records[0] {
+				if name == tc.Column {
+					idx = i
+				}
+			}
+			if idx < 0 {
+				t.Fatalf("column %s not found in csv header", tc.Column)
+			}
+			for i, expect := range tc.CSV {
+				if records[i+1][idx] != expect {
+					t.Fatalf("csv record %d has value %q, expected %q", i+1, records[i+1][idx], expect)
+				}
+			}
+		})
+	}
+}
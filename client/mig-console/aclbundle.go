@@ -0,0 +1,224 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/readline"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/client"
+)
+
+// aclBundleReader is used to manipulate ACL bundles
+func aclBundleReader(input string, cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("aclBundleReader() -> %v", e)
+		}
+	}()
+	inputArr := strings.Split(input, " ")
+	if len(inputArr) < 2 {
+		panic("wrong order format. must be 'aclbundle <bundleid>'")
+	}
+	bid, err := strconv.ParseFloat(inputArr[1], 64)
+	if err != nil {
+		panic(err)
+	}
+	b, err := cli.GetACLBundle(bid)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Entering acl bundle reader mode. Type \x1b[32;1mexit\x1b[0m or press \x1b[32;1mctrl+d\x1b[0m to leave. \x1b[32;1mhelp\x1b[0m may help.")
+	fmt.Printf("ACL bundle: '%s'.\nStatus '%s'.\n", b.Name, b.Status)
+
+	prompt := fmt.Sprintf("\x1b[31;1maclbundle %d>\x1b[0m ", uint64(bid)%1000)
+	for {
+		var symbols = []string{"agents", "disable", "exit", "hash", "help", "json", "r", "reset", "sign"}
+		readline.Completer = func(query, ctx string) []string {
+			var res []string
+			for _, sym := range symbols {
+				if strings.HasPrefix(sym, query) {
+					res = append(res, sym)
+				}
+			}
+			return res
+		}
+
+		input, err := readline.String(prompt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("error: ", err)
+			break
+		}
+		orders := strings.Split(strings.TrimSpace(input), " ")
+		switch orders[0] {
+		case "agents":
+			agents, err := cli.GetACLBundleAgents(bid)
+			if err != nil {
+				panic(err)
+			}
+			lagging := 0
+			for _, agt := range agents {
+				if len(orders) == 2 && orders[1] == "lagging" && agt.UpToDate {
+					continue
+				}
+				if !agt.UpToDate {
+					lagging++
+				}
+				fmt.Printf("%.0f %s %s uptodate=%t\n", agt.ID, agt.Name, agt.Status, agt.UpToDate)
+			}
+			fmt.Printf("%d agents, %d not up to date\n", len(agents), lagging)
+		case "disable":
+			err = cli.ACLBundleStatus(b, "disabled")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("ACL bundle has been disabled")
+		case "hash":
+			hash, err := b.Hash()
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(hash)
+		case "help":
+			fmt.Printf(`The following orders are available:
+agents [lagging]  show active agents the bundle applies to, or only the agents that did not apply it yet
+
+disable           disables acl bundle and stops its distribution
+
+help              show this help
+
+exit              exit this mode (also works with ctrl+d)
+
+hash              show the hash agents report when using this bundle
+
+json              show json of acl bundle stored in database
+
+r                 refresh the acl bundle (get latest version from database)
+
+reset             reset acl bundle status (marks bundle as staged, removes signatures)
+
+sign [keyid]      add a signature to the acl bundle, using the configured key if keyid is not set
+`)
+		case "exit":
+			fmt.Printf("exit\n")
+			goto exit
+		case "json":
+			jsonb, err := json.MarshalIndent(b, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("%s\n", jsonb)
+		case "r":
+			b, err = cli.GetACLBundle(bid)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("reloaded")
+		case "reset":
+			err = cli.ACLBundleStatus(b, "staged")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("ACL bundle has been reset")
+		case "sign":
+			keyid := ""
+			if len(orders) == 2 {
+				keyid = orders[1]
+			}
+			sig, err := cli.SignACLBundle(b, keyid)
+			if err != nil {
+				panic(err)
+			}
+			err = cli.PostACLBundleSignature(b, sig)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("ACL bundle signature has been accepted")
+		case "":
+			break
+		default:
+			fmt.Printf("Unknown order '%s'. You are in acl bundle reader mode. Try `help`.\n", orders[0])
+		}
+		readline.AddHistory(input)
+	}
+
+exit:
+	fmt.Printf("\n")
+	return
+}
+
+// Prompts for input and creates a new ACL bundle through the API
+func aclBundleCreator(cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("aclBundleCreator() -> %v", e)
+		}
+	}()
+	var newb mig.ACLBundle
+	fmt.Println("Entering acl bundle creation mode.\nPlease provide the name" +
+		" of the new acl bundle")
+	newb.Name, err = readline.String("name> ")
+	if err != nil {
+		panic(err)
+	}
+	if len(newb.Name) < 3 {
+		panic("input name too short")
+	}
+	fmt.Printf("Name: '%s'\n", newb.Name)
+	fmt.Println("Please provide agent targeting string for acl bundle.")
+	newb.Target, err = readline.String("target> ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Target: '%s'\n", newb.Target)
+	fmt.Println("Please enter path to a JSON file containing the ACL.")
+	aclpath, err := readline.String("aclpath> ")
+	if err != nil {
+		panic(err)
+	}
+	buf, err := ioutil.ReadFile(aclpath)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(buf, &newb.ACL)
+	if err != nil {
+		panic(err)
+	}
+	newb.Status = "staged"
+	// Validate the new acl bundle before sending it to the API
+	err = newb.Validate()
+	if err != nil {
+		panic(err)
+	}
+	jsonb, err := json.MarshalIndent(newb, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s\n", jsonb)
+	input, err := readline.String("create acl bundle? (y/n)> ")
+	if err != nil {
+		panic(err)
+	}
+	if input != "y" {
+		fmt.Println("abort")
+		return
+	}
+	newb, err = cli.PostNewACLBundle(newb)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("ACL bundle %.0f successfully created\n", newb.ID)
+	return
+}
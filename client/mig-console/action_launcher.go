@@ -16,6 +16,7 @@ import (
 	"github.com/bobappleyard/readline"
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/client"
+	migtarget "github.com/mozilla/mig/database/target"
 	"github.com/mozilla/mig/modules"
 )
 
@@ -55,7 +56,7 @@ func actionLauncher(tpl mig.Action, cli client.Client) (err error) {
 		// completion
 		var symbols = []string{"addoperation", "compress", "deloperation", "exit", "help", "init",
 			"json", "launch", "listagents", "load", "details", "filechecker", "netstat",
-			"setname", "settarget", "settimes", "sign", "times"}
+			"setname", "settarget", "settimes", "sign", "submit", "times"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -180,9 +181,10 @@ json <pack>		show the json of the action
 launch <nofollow>	launch the action. to return before completion, add "nofollow"
 load <path>		load an action from a file at <path>
 setname <name>		set the name of the action
-settarget <target>	set the target
+settarget <target>	set the target, use "settarget help" to list the available fields
 settimes <start> <stop>	set the validity and expiration dates
 sign			PGP sign the action
+submit			sign the action and submit it for approval by other investigators
 times			show the various timestamps of the action
 `)
 		case "json":
@@ -208,9 +210,12 @@ times			show the various timestamps of the action
 				panic(err)
 			}
 			fmt.Printf("%s\n", ajson)
-		case "launch":
-			follow := true
-			if len(orders) > 1 {
+		case "launch", "submit":
+			// submitted actions are held by the API until other investigators
+			// approve them, and are not followed
+			approval := orders[0] == "submit"
+			follow := !approval
+			if len(orders) > 1 && !approval {
 				if orders[1] == "nofollow" {
 					follow = false
 				} else {
@@ -269,10 +274,24 @@ times			show the various timestamps of the action
 				a = asig
 				hasSignatures = true
 			}
+			if approval {
+				a, err = cli.PostActionForApproval(a)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Printf("Action '%s' submitted for approval with ID '%.0f', status is '%s'\n",
+					a.Name, a.ID, a.Status)
+				goto exit
+			}
 			a, err = cli.PostAction(a)
 			if err != nil {
 				panic(err)
 			}
+			if a.Status == mig.ActionStatusPendingApproval {
+				fmt.Printf("Action '%s' is held by the API for approval with ID '%.0f'\n",
+					a.Name, a.ID)
+				goto exit
+			}
 			fmt.Printf("Action '%s' successfully launched with ID '%.0f' on target '%s'\n",
 				a.Name, a.ID, a.Target)
 			if follow {
@@ -339,10 +358,25 @@ times			show the various timestamps of the action
 				fmt.Println("Wrong arguments. Must be 'settarget <some_target_string>'")
 				break
 			}
-			a.Target = strings.Join(orders[1:], " ")
+			if len(orders) == 2 && orders[1] == "help" {
+				fmt.Println(`Targets are conditions on agent fields combined with AND, OR, NOT and parenthesis.
+Operators are =, !=, ~ (regex), !~, like, in, <, <=, >, >=. For example:
+settarget os = linux AND tag.operator = IT AND env.addresses in 10.0.0.0/8 AND NOT name ~ "^build"
+The following fields are available:`)
+				for _, f := range migtarget.Fields() {
+					fmt.Printf("%-22s %s\n", f[0], f[1])
+				}
+				break
+			}
 			// Convert the target string to the desired value if the input was a
 			// target macro
-			a.Target = cli.ResolveTargetMacro(a.Target)
+			tgt := cli.ResolveTargetMacro(strings.Join(orders[1:], " "))
+			err = migtarget.Validate(tgt)
+			if err != nil {
+				fmt.Println(err)
+				break
+			}
+			a.Target = tgt
 			agents, err := cli.EvaluateAgentTarget(a.Target)
 			if err != nil {
 				fmt.Println(err)
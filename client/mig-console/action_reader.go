@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -47,8 +48,9 @@ func actionReader(input string, cli client.Client) (err error) {
 	prompt := fmt.Sprintf("\x1b[31;1maction %d>\x1b[0m ", uint64(aid)%1000)
 	for {
 		// completion
-		var symbols = []string{"command", "copy", "counters", "details", "exit", "grep", "help", "investigators",
-			"json", "list", "all", "found", "notfound", "pretty", "r", "results", "times"}
+		var symbols = []string{"approve", "command", "copy", "counters", "details", "exit", "grep", "help", "investigators",
+			"json", "list", "all", "found", "notfound", "pretty", "r", "reject", "results", "times",
+			"text", "ndjson", "csv", "columnar"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -69,6 +71,16 @@ func actionReader(input string, cli client.Client) (err error) {
 		}
 		orders := strings.Split(strings.TrimSpace(input), " ")
 		switch orders[0] {
+		case "approve":
+			if a.Status != mig.ActionStatusPendingApproval {
+				fmt.Println("Action is not pending approval")
+				break
+			}
+			a, err = cli.ApproveAction(aid)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Action signed, status is now '%s'\n", a.Status)
 		case "command":
 			err = commandReader(input, cli)
 			if err != nil {
@@ -93,6 +105,8 @@ func actionReader(input string, cli client.Client) (err error) {
 			goto exit
 		case "help":
 			fmt.Printf(`The following orders are available:
+approve		co-sign an action pending approval
+
 command <id>	jump to command reader mode for command <id>
 
 copy		enter action launcher mode using current action as template
@@ -118,12 +132,17 @@ list <show>	returns the list of commands with their status
 
 r		refresh the action (get latest version from upstream)
 
-results <show> <render>	display results of all commands
+reject		reject an action pending approval
+
+results <show> <render> <path>	display or export results of all commands
 			<show>: * set to "all" to get all results (default)
 				* set to "found" to only display positive results
 				* set to "notfound" for negative results
 			<render>: * set to "text" to print results in console (default)
-				  * set to "map" to generate an open a google map
+				  * set to "ndjson" to export one JSON object per result
+				  * set to "csv" to export comma separated values
+				  * set to "columnar" to export a JSON document organized by columns
+			<path>: file to write the export to, defaults to the console
 
 times		show the various timestamps of the action
 `)
@@ -153,6 +172,17 @@ times		show the various timestamps of the action
 				panic(err)
 			}
 			fmt.Println("reloaded")
+		case "reject":
+			if a.Status != mig.ActionStatusPendingApproval {
+				fmt.Println("Action is not pending approval")
+				break
+			}
+			err = cli.RejectAction(aid)
+			if err != nil {
+				panic(err)
+			}
+			a.Status = mig.ActionStatusRejected
+			fmt.Println("Action has been rejected")
 		case "results":
 			show := "all"
 			if len(orders) > 1 {
@@ -160,10 +190,17 @@ times		show the various timestamps of the action
 				case "all", "found", "notfound":
 					show = orders[1]
 				default:
-					panic("invalid show '" + orders[2] + "'")
+					panic("invalid show '" + orders[1] + "'")
+				}
+			}
+			if len(orders) < 3 || orders[2] == "text" {
+				err = cli.PrintActionResults(a, show)
+				if err != nil {
+					panic(err)
 				}
+				break
 			}
-			err = cli.PrintActionResults(a, show)
+			err = exportResults(cli, a, show, orders[2], orders[3:])
 			if err != nil {
 				panic(err)
 			}
@@ -394,3 +431,31 @@ func searchCommands(aid float64, show string, cli client.Client) (cmds []mig.Com
 	}
 	return
 }
+
+// exportResults writes the results of action a in the given format to the file
+// named in args, or to the console if no file is given
+func exportResults(cli client.Client, a mig.Action, show, format string, args []string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("exportResults() -> %v", e)
+		}
+	}()
+	err = client.ValidateExportFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	if len(args) == 0 {
+		return cli.ExportActionResults(a, show, format, os.Stdout)
+	}
+	fd, err := os.Create(args[0])
+	if err != nil {
+		panic(err)
+	}
+	defer fd.Close()
+	err = cli.ExportActionResults(a, show, format, fd)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Results exported to %s\n", args[0])
+	return
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/client"
 
 	"github.com/bobappleyard/readline"
@@ -44,7 +45,8 @@ func agentReader(input string, cli client.Client) (err error) {
 	prompt := fmt.Sprintf("\x1b[34;1magent %d>\x1b[0m ", uint64(agtid)%1000)
 	for {
 		// completion
-		var symbols = []string{"details", "exit", "help", "json", "pretty", "r", "lastactions"}
+		var symbols = []string{"details", "exit", "help", "json", "pretty", "r", "lastactions",
+			"modperms", "setmodreq", "setmodperm"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -103,6 +105,11 @@ help			show this help
 json <pretty>		show the json of the agent registration
 r			refresh the agent (get latest version from upstream)
 lastactions <limit>	print the last actions that ran on the agent. limit=10 by default.
+modperms		print the module requirements and investigators module permissions of the agent
+setmodreq <module> <weight>
+			set the minimum signatures weight needed to run a module on the agent, 0 removes it
+setmodperm <investigatorid> <module> <weight>
+			set the weight of an investigator for a module on the agent, 0 removes it
 `)
 		case "lastactions":
 			limit := 10
@@ -116,6 +123,46 @@ lastactions <limit>	print the last actions that ran on the agent. limit=10 by de
 			if err != nil {
 				panic(err)
 			}
+		case "modperms":
+			reqs, perms, err := cli.GetAgentModulePermissions(agtid)
+			if err != nil {
+				panic(err)
+			}
+			printModulePermissions(reqs, perms)
+		case "setmodreq":
+			if len(orders) != 3 {
+				fmt.Println("Wrong arguments. Expects 'setmodreq <module> <weight>'")
+				break
+			}
+			weight, err := strconv.Atoi(orders[2])
+			if err != nil {
+				panic(err)
+			}
+			req := mig.ModuleRequirement{AgentID: agtid, Module: orders[1], MinimumWeight: weight}
+			_, err = cli.PostModuleRequirement(req, "")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Module requirement updated")
+		case "setmodperm":
+			if len(orders) != 4 {
+				fmt.Println("Wrong arguments. Expects 'setmodperm <investigatorid> <module> <weight>'")
+				break
+			}
+			iid, err := strconv.ParseFloat(orders[1], 64)
+			if err != nil {
+				panic(err)
+			}
+			weight, err := strconv.Atoi(orders[3])
+			if err != nil {
+				panic(err)
+			}
+			perm := mig.ModulePermission{InvestigatorID: iid, AgentID: agtid, Module: orders[2], Weight: weight}
+			_, err = cli.PostModulePermission(perm, "")
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Module permission updated")
 		case "json":
 			var agtjson []byte
 			if len(orders) > 1 {
@@ -185,3 +232,23 @@ func printAgentLastCommands(agtid float64, limit int, cli client.Client) (err er
 	}
 	return
 }
+
+// printModulePermissions prints module requirements and module permissions as tables
+func printModulePermissions(reqs []mig.ModuleRequirement, perms []mig.ModulePermission) {
+	if len(reqs) > 0 {
+		fmt.Println("---- Agent ID ---- + ----  Module  ---- + Minimum Weight")
+		for _, req := range reqs {
+			fmt.Printf("%18.0f   %-18s   %d\n", req.AgentID, req.Module, req.MinimumWeight)
+		}
+	}
+	if len(perms) > 0 {
+		fmt.Println("---- Agent ID ---- + Investigator ID + ----  Module  ---- + Weight")
+		for _, perm := range perms {
+			fmt.Printf("%18.0f   %15.0f   %-18s   %d\n", perm.AgentID, perm.InvestigatorID,
+				perm.Module, perm.Weight)
+		}
+	}
+	if len(reqs) == 0 && len(perms) == 0 {
+		fmt.Println("No module permissions found")
+	}
+}
@@ -93,7 +93,7 @@ func main() {
 	fmt.Fprintf(out, "\nConnected to %s. Exit with \x1b[32;1mctrl+d\x1b[0m. Type \x1b[32;1mhelp\x1b[0m for help.\n", cli.Conf.API.URL)
 	for {
 		// completion
-		var symbols = []string{"action", "agent", "create", "command", "help", "history",
+		var symbols = []string{"action", "aclbundle", "agent", "approvals", "create", "command", "help", "history",
 			"exit", "manifest", "showcfg", "status", "investigator", "search", "query",
 			"where", "and", "loader"}
 		readline.Completer = func(query, ctx string) []string {
@@ -125,17 +125,29 @@ func main() {
 			} else {
 				fmt.Println("error: missing action id in 'action <id>'")
 			}
+		case "aclbundle":
+			err = aclBundleReader(input, cli)
+			if err != nil {
+				log.Println(err)
+			}
 		case "agent":
 			err = agentReader(input, cli)
 			if err != nil {
 				log.Println(err)
 			}
+		case "approvals":
+			err = printApprovals(cli)
+			if err != nil {
+				log.Println(err)
+			}
 		case "create":
 			if len(orders) == 2 {
 				switch orders[1] {
 				case "action":
 					var a mig.Action
 					err = actionLauncher(a, cli)
+				case "aclbundle":
+					err = aclBundleCreator(cli)
 				case "investigator":
 					err = investigatorCreator(cli)
 				case "loader":
@@ -162,8 +174,11 @@ func main() {
 		case "help":
 			fmt.Printf(`The following orders are available:
 action <id>		enter interactive action reader mode for action <id>
+aclbundle <id>          enter acl bundle management mode for acl bundle <id>
 agent <id>		enter interactive agent reader mode for agent <id>
+approvals		list the actions pending approval, use "action <id>" to approve or reject them
 create action		create a new action
+create aclbundle        create a new acl bundle, will prompt for name, target and acl file
 create investigator	create a new investigator, will prompt for name and public key
 create loader           create a new loader entry
 create manifest         create a new manifest
@@ -360,6 +375,32 @@ func printStatus(cli client.Client) (err error) {
 	return
 }
 
+// printApprovals prints the list of actions pending approval
+func printApprovals(cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("printApprovals() -> %v", e)
+		}
+	}()
+	actions, err := cli.GetPendingApprovals()
+	if err != nil {
+		panic(err)
+	}
+	if len(actions) == 0 {
+		fmt.Println("No action pending approval")
+		return
+	}
+	fmt.Println("----  ID  ---- + ----         Name         ---- + -Sigs- + ---- Investigators ----")
+	for _, a := range actions {
+		idstr, name, _, _, invs, _, _, err := actionPrintShort(a)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s   %s   %6d   %s\n", idstr, name, len(a.PGPSignatures), invs)
+	}
+	return
+}
+
 var banner string = `
 ## ##                                     _.---._     .---.
 # # # /-\ ---||  |    /\         __...---' .---. '---'-.   '.
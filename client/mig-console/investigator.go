@@ -47,7 +47,8 @@ func investigatorReader(input string, cli client.Client) (err error) {
 	for {
 		// completion, for convenience also add permission categories here
 		var symbols = []string{"apikey", "details", "exit", "help", "pubkey", "r", "lastactions",
-			"setperms", "setstatus", "PermManifest", "PermLoader", "PermAdmin"}
+			"modperms", "setmodperm", "setperms", "setstatus", "PermManifest", "PermLoader", "PermACLBundle",
+			"PermApprover", "PermAdmin"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -131,6 +132,9 @@ details			  print the details of the investigator
 exit			  exit this mode
 help			  show this help
 lastactions <limit>	  print the last actions ran by the investigator. limit=10 by default.
+modperms		  print the module permissions of the investigator on agents
+setmodperm <module> <weight> <target>
+			  set the weight of the investigator for a module on the agents matching target, 0 removes it
 pubkey			  show the armored public key of the investigator
 r			  refresh the investigator (get latest version from upstream)
 setperms [permissions...] set permissions for investigator, no arguments to apply default
@@ -149,6 +153,28 @@ setstatus <status>	  changes the status of the investigator to <status> (can be
 			if err != nil {
 				panic(err)
 			}
+		case "modperms":
+			perms, err := cli.GetInvestigatorModulePermissions(iid)
+			if err != nil {
+				panic(err)
+			}
+			printModulePermissions(nil, perms)
+		case "setmodperm":
+			if len(orders) < 4 {
+				fmt.Println("error: must be 'setmodperm <module> <weight> <target>'. try 'help'")
+				break
+			}
+			weight, err := strconv.Atoi(orders[2])
+			if err != nil {
+				panic(err)
+			}
+			target := cli.ResolveTargetMacro(strings.Join(orders[3:], " "))
+			perm := mig.ModulePermission{InvestigatorID: iid, Module: orders[1], Weight: weight}
+			perms, err := cli.PostModulePermission(perm, target)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Module permission updated on %d agents\n", len(perms))
 		case "pubkey":
 			armoredPubKey, err := pgp.ArmorPubKey(inv.PublicKey)
 			if err != nil {
@@ -320,10 +346,36 @@ func investigatorCreator(cli client.Client) (err error) {
 	}
 	switch strings.ToLower(respv) {
 	case "yes":
-		fmt.Printf("Investigator will have manifest management permissions\n\n")
+		fmt.Println("Investigator will have manifest management permissions")
 		pset.ManifestSet()
 	case "no":
-		fmt.Printf("Investigator will not have manifest management permissions\n\n")
+		fmt.Println("Investigator will not have manifest management permissions")
+	default:
+		panic("must specify yes or no")
+	}
+	respv, err = readline.String("Allow investigator to manage acl bundles? (yes/no)> ")
+	if err != nil {
+		panic(err)
+	}
+	switch strings.ToLower(respv) {
+	case "yes":
+		fmt.Println("Investigator will have acl bundle management permissions")
+		pset.ACLBundleSet()
+	case "no":
+		fmt.Println("Investigator will not have acl bundle management permissions")
+	default:
+		panic("must specify yes or no")
+	}
+	respv, err = readline.String("Allow investigator to approve actions? (yes/no)> ")
+	if err != nil {
+		panic(err)
+	}
+	switch strings.ToLower(respv) {
+	case "yes":
+		fmt.Printf("Investigator will have action approval permissions\n\n")
+		pset.ApproverSet()
+	case "no":
+		fmt.Printf("Investigator will not have action approval permissions\n\n")
 	default:
 		panic("must specify yes or no")
 	}
@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/client"
+)
+
+// approvalCommand implements the approval sub-command, which lets investigators
+// review, co-sign and reject actions submitted for approval
+func approvalCommand(migrc string, args []string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("approvalCommand() -> %v", e)
+		}
+	}()
+	fs := flag.NewFlagSet("mig approval", flag.ContinueOnError)
+	fs.StringVar(&migrc, "c", migrc, "alternative configuration file")
+	if len(args) < 1 {
+		panic("missing approval command, must be list, show, sign or reject")
+	}
+	order := args[0]
+	err = fs.Parse(args[1:])
+	if err != nil {
+		panic(err)
+	}
+	var aid float64
+	if order != "list" {
+		if fs.NArg() != 1 {
+			panic(fmt.Sprintf("must be 'approval %s <action ID>'", order))
+		}
+		aid, err = strconv.ParseFloat(fs.Arg(0), 64)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	conf, err := client.ReadConfiguration(migrc)
+	if err != nil {
+		panic(err)
+	}
+	conf, err = client.ReadEnvConfiguration(conf)
+	if err != nil {
+		panic(err)
+	}
+	cli, err := client.NewClient(conf, "cmd-"+mig.Version)
+	if err != nil {
+		panic(err)
+	}
+
+	switch order {
+	case "list":
+		actions, err := cli.GetPendingApprovals()
+		if err != nil {
+			panic(err)
+		}
+		for _, a := range actions {
+			fmt.Printf("%.0f  %-40s  %d signature(s) from %s, expires %s\n",
+				a.ID, a.Name, len(a.PGPSignatures), approvalSigners(a),
+				a.ExpireAfter.Format("2006-01-02T15:04:05Z07:00"))
+		}
+	case "show":
+		a, _, err := cli.GetApprovalSigningString(aid)
+		if err != nil {
+			panic(err)
+		}
+		astr, err := a.IndentedString()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\nsigned by: %s\n", astr, approvalSigners(a))
+	case "sign":
+		a, err := cli.ApproveAction(aid)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "action %.0f signed, status is %s\n", a.ID, a.Status)
+	case "reject":
+		err = cli.RejectAction(aid)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "action %.0f rejected\n", aid)
+	default:
+		panic(fmt.Sprintf("unknown approval command %q, must be list, show, sign or reject", order))
+	}
+	return
+}
+
+// approvalSigners returns the names of the investigators who signed action a
+func approvalSigners(a mig.Action) string {
+	var names []string
+	for _, inv := range a.Investigators {
+		names = append(names, inv.Name)
+	}
+	return strings.Join(names, ", ")
+}
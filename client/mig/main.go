@@ -20,6 +20,7 @@ import (
 
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/client"
+	migtarget "github.com/mozilla/mig/database/target"
 	"github.com/mozilla/mig/modules"
 )
 
@@ -30,6 +31,10 @@ usage: %s <module> <global options> <module parameters>
 
 --- Global options ---
 
+-approval	 Submit the signed action for approval instead of launching it. The
+		 action is held by the API until other investigators co-sign it with
+		 "%s approval sign <action ID>", and is not followed.
+
 -c <path>	 Path to config file, defaults to ~/.migrc
 
 -e <duration>	 Time after which the action expires, defaults to 60 seconds.
@@ -42,6 +47,14 @@ usage: %s <module> <global options> <module parameters>
 		 (no module should be specified since it is indicated in the action
 		 file) and only global options should be used.
 
+-o <format>	 Export results to stdout in the given format instead of printing them.
+		 Each row holds a result returned by a module along with the name,
+		 tags and environment of the agent and the status of the command.
+
+		 * ndjson:	one JSON object per row
+		 * csv:		comma separated values with a header line
+		 * columnar:	a JSON document with one array of values per column
+
 -p <bool>        Display action JSON that would be used and exit, useful to write
 		 an action for later import with the -i flag.
 
@@ -52,16 +65,21 @@ usage: %s <module> <global options> <module parameters>
 		 * all:		Print all results
 
 -t <target>	 Target to launch the action on. If no target is specified, the value will
-		 default to all online agents (status='online')
+		 default to all online agents (status = online)
 
 		 Examples:
-		 * Linux agents:          -t "queueloc LIKE 'linux.%%'"
-		 * Agents named *mysql*:  -t "name like '%%mysql%%'"
-		 * Proxied Linux agents:  -t "queueloc LIKE 'linux.%%' AND environment->>'isproxied' = 'true'"
-		 * Agents operated by IT: -t "tags#>>'{operator}'='IT'"
+		 * Linux agents:          -t "os = linux"
+		 * Agents named *mysql*:  -t "name ~ mysql"
+		 * Proxied Linux agents:  -t "os = linux AND env.isproxied = true"
+		 * Agents operated by IT: -t "tag.operator = IT"
+		 * Agents in a network:   -t "env.addresses in 10.0.0.0/8"
 		 * Run on local system:	 -t local
 		 * Use a migrc macro:     -t mymacroname
 
+		 Conditions are combined with AND, OR, NOT and parenthesis. If
+		 enabled on the API, a raw SQL condition can be used by prefixing
+		 the target with "sql:".
+
 -s <bool>        Create and sign the action, and output the action to stdout
                  this is useful for dual-signing; the signed action can be provided
                  to another investigator for launch using the -i flag.
@@ -80,7 +98,13 @@ usage: %s <module> <global options> <module parameters>
 --- Modules documentation ---
 Each module provides its own set of parameters. Module parameters must be set *after*
 global options. Help is available by calling "<module> help". Available modules are:
-`, os.Args[0], os.Args[0])
+--- Approvals ---
+%s approval list			list the actions pending approval
+%s approval show <action ID>	display an action pending approval
+%s approval sign <action ID>	co-sign an action pending approval
+%s approval reject <action ID>	reject an action pending approval
+
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	for module := range modules.Available {
 		fmt.Printf("* %s\n", module)
 	}
@@ -98,9 +122,9 @@ func main() {
 		err                                       error
 		op                                        mig.Operation
 		a                                         mig.Action
-		migrc, show, target, expiration           string
+		migrc, show, target, expiration, output   string
 		afile, aname, targetfound, targetnotfound string
-		signAndOutput                             bool
+		signAndOutput, submitForApproval          bool
 		printAndExit                              bool
 		verbose, showversion                      bool
 		compressAction                            bool
@@ -122,6 +146,7 @@ func main() {
 	fs.BoolVar(&printAndExit, "p", false, "display action json that would be used and exit")
 	fs.StringVar(&migrc, "c", homedir+"/.migrc", "alternative configuration file")
 	fs.StringVar(&show, "show", "found", "type of results to show")
+	fs.StringVar(&output, "o", "", "export results in format ndjson, csv or columnar")
 	fs.StringVar(&target, "t", "", "action target")
 	fs.StringVar(&targetfound, "target-found", "", "targets agents that have found results in a previous action.")
 	fs.StringVar(&targetnotfound, "target-notfound", "", "targets agents that haven't found results in a previous action.")
@@ -129,6 +154,7 @@ func main() {
 	fs.StringVar(&afile, "i", "/path/to/file", "Load action from file")
 	fs.StringVar(&aname, "n", "action name", "A name for the action")
 	fs.BoolVar(&signAndOutput, "s", false, "Fully sign action and print to stdout, useful for dual-signing")
+	fs.BoolVar(&submitForApproval, "approval", false, "Submit action for approval by other investigators")
 	fs.BoolVar(&verbose, "v", false, "Enable verbose output")
 	fs.BoolVar(&showversion, "V", false, "Show version")
 	fs.BoolVar(&compressAction, "z", false, "Request compression of action parameters")
@@ -145,6 +171,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if os.Args[1] == "approval" {
+		err = approvalCommand(migrc, os.Args[2:])
+		if err != nil {
+			panic(err)
+		}
+		os.Exit(0)
+	}
+
 	// when reading the action from a file, go directly to launch
 	if os.Args[1] == "-i" {
 		conf, err = client.ReadConfiguration(migrc)
@@ -235,9 +269,9 @@ func main() {
 	}
 	// Make sure a target value was specified
 	if target == "" {
-		target = "status='online'"
+		target = "status = online"
 		// Quell this warning if targetfound or targetnotfound is in use, we will still default
-		// to status='online' as the base queried is AND'd with the results query later on in this
+		// to status = online as the base queried is AND'd with the results query later on in this
 		// function.
 		if targetfound == "" && targetnotfound == "" {
 			fmt.Fprint(os.Stderr, "[notice] no target specified, defaulting to all online agents\n")
@@ -314,15 +348,19 @@ func main() {
 	if targetfound != "" && targetnotfound != "" {
 		panic("Both -target-found and -target-foundnothing cannot be used simultaneously")
 	}
-	if targetfound != "" {
-		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, json_array_elements(commands.results) as `+
-			`r where actionid=%s and r#>>'{foundanything}' = 'true')`, targetfound)
-		target = targetQuery + " AND " + target
+	if targetfound != "" || targetnotfound != "" {
+		if migtarget.IsRawSQL(target) {
+			panic("-target-found and -target-notfound cannot be combined with a raw SQL target")
+		}
+		if targetfound != "" {
+			target = fmt.Sprintf("found = %s AND (%s)", targetfound, target)
+		} else {
+			target = fmt.Sprintf("notfound = %s AND (%s)", targetnotfound, target)
+		}
 	}
-	if targetnotfound != "" {
-		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, json_array_elements(commands.results) as `+
-			`r where actionid=%s and r#>>'{foundanything}' = 'false')`, targetnotfound)
-		target = targetQuery + " AND " + target
+	err = migtarget.Validate(target)
+	if err != nil {
+		panic(err)
 	}
 	a.Target = target
 
@@ -368,11 +406,26 @@ readytolaunch:
 		}
 	}
 
+	if output != "" {
+		err = client.ValidateExportFormat(output)
+		if err != nil {
+			panic(err)
+		}
+	}
 	// evaluate target before launch, give a chance to cancel before going out to agents
 	agents, err := cli.EvaluateAgentTarget(a.Target)
 	if err != nil {
 		panic(err)
 	}
+	if submitForApproval {
+		a, err = cli.PostActionForApproval(a)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stderr, "action %.0f targeting %d agents submitted with status %s\n",
+			a.ID, len(agents), a.Status)
+		os.Exit(0)
+	}
 	fmt.Fprintf(os.Stderr, "\x1b[33m%d agents will be targeted. ctrl+c to cancel. launching in \x1b[0m", len(agents))
 	for i := 5; i > 0; i-- {
 		time.Sleep(1 * time.Second)
@@ -385,6 +438,34 @@ readytolaunch:
 	if err != nil {
 		panic(err)
 	}
+	// the api may hold the action for approval even if it was not submitted for it
+	if a.Status == mig.ActionStatusPendingApproval {
+		fmt.Fprintf(os.Stderr, "action %.0f is held by the api with status %s, it runs once approved\n",
+			a.ID, a.Status)
+		os.Exit(0)
+	}
+
+	// Results are printed or exported as commands complete when the API streams
+	// them, otherwise they are retrieved once the action has been followed.
+	var exp client.ResultExporter
+	if output != "" {
+		exp, err = client.NewResultExporter(output, os.Stdout)
+		if err != nil {
+			panic(err)
+		}
+	}
+	streamed := true
+	agtCount := 0
+	handler := func(cmd mig.Command) error {
+		if !client.ShowCommand(cmd, show) {
+			return nil
+		}
+		agtCount++
+		if exp != nil {
+			return exp.AddCommand(cmd, show == "found")
+		}
+		return client.PrintCommandResults(cmd, show == "found", true)
+	}
 
 	// Follow the action for completion, and handle an interrupt to abort waiting for
 	// completion, but still print out available results.
@@ -398,7 +479,11 @@ readytolaunch:
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err = cli.FollowAction(a, len(agents), sigint)
+		err = cli.FollowActionResults(a, len(agents), sigint, handler)
+		if err == client.ErrStreamUnsupported {
+			streamed = false
+			err = cli.FollowAction(a, len(agents), sigint)
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -415,11 +500,28 @@ readytolaunch:
 		}
 	}()
 	wg.Wait()
+	if streamed {
+		if cancelled {
+			fmt.Fprintf(os.Stderr, "[notice] stopped following action, but agents may still be running.\n")
+		}
+		if exp != nil {
+			err = exp.Close()
+			if err != nil {
+				panic(err)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\x1b[31m%d agents have %s results\x1b[0m\n", agtCount, show)
+		return
+	}
 	if cancelled {
 		fmt.Fprintf(os.Stderr, "[notice] stopped following action, but agents may still be running.\n")
 		fmt.Fprintf(os.Stderr, "fetching available results:\n")
 	}
-	err = cli.PrintActionResults(a, show)
+	if output != "" {
+		err = cli.ExportActionResults(a, show, output, os.Stdout)
+	} else {
+		err = cli.PrintActionResults(a, show)
+	}
 	if err != nil {
 		panic(err)
 	}
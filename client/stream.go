@@ -0,0 +1,294 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package client /* import "github.com/mozilla/mig/client" */
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// ErrStreamUnsupported is returned when the API does not provide a stream of
+// action results, in which case the action must be followed by polling it
+var ErrStreamUnsupported = errors.New("the API does not support streaming action results")
+
+// maximum size of a single event in a stream of action results
+const maxStreamEventSize = 64 * 1024 * 1024
+
+// StreamActionResults connects to the stream of results of action a, and calls
+// onCommand with each command as soon as it completes and onStatus when the counters
+// of the action change. Either function can be nil. It returns the final status of
+// the action once it has finished, or when stop receives a value.
+//
+// If the connection to the API is interrupted, the stream is resumed after the last
+// command received, and commands that are sent again are ignored. Once the action has
+// finished, its commands are retrieved from the API to pass to onCommand the ones the
+// stream did not send. ErrStreamUnsupported is returned if the API does not provide
+// streams.
+func (cli Client) StreamActionResults(a mig.Action, stop chan bool,
+	onCommand func(mig.Command) error, onStatus func(mig.ActionStreamStatus)) (status mig.ActionStreamStatus, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("StreamActionResults() -> %v", e)
+		}
+	}()
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan bool, 1)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			stopped <- true
+			cancel()
+		case <-done:
+		}
+	}()
+
+	var (
+		lastEventID string
+		finished    bool
+		attempts    int
+		eventErr    error
+		// IDs of the commands already passed to onCommand
+		seen = make(map[float64]bool)
+	)
+	// errors returned by handleEvent are kept in eventErr, to tell them apart
+	// from errors caused by an interrupted connection
+	handleEvent := func(event, id, data string) error {
+		switch event {
+		case "command":
+			var cmd mig.Command
+			eventErr = json.Unmarshal([]byte(data), &cmd)
+			if eventErr == nil && !seen[cmd.ID] && onCommand != nil {
+				eventErr = onCommand(cmd)
+			}
+			if eventErr != nil {
+				return eventErr
+			}
+			seen[cmd.ID] = true
+			lastEventID = id
+		case "counters", "done":
+			eventErr = json.Unmarshal([]byte(data), &status)
+			if eventErr != nil {
+				return eventErr
+			}
+			if onStatus != nil {
+				onStatus(status)
+			}
+			finished = event == "done"
+		case "error":
+			eventErr = fmt.Errorf("API error: %s", data)
+			return eventErr
+		}
+		// a successful event resets the reconnection attempts
+		attempts = 0
+		return nil
+	}
+	for !finished {
+		target := fmt.Sprintf("action/stream?actionid=%.0f", a.ID)
+		if lastEventID != "" {
+			target += "&lasteventid=" + url.QueryEscape(lastEventID)
+		}
+		r, err := http.NewRequest("GET", cli.Conf.API.URL+target, nil)
+		if err != nil {
+			panic(err)
+		}
+		r = r.WithContext(cctx)
+		r.Header.Set("Accept", "text/event-stream")
+		resp, err := cli.Do(r)
+		if err != nil {
+			select {
+			case <-stopped:
+				return status, nil
+			default:
+			}
+			attempts++
+			if attempts > 5 {
+				panic(err)
+			}
+			time.Sleep(time.Duration(attempts) * time.Second)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) &&
+				lastEventID == "" && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+				return status, ErrStreamUnsupported
+			}
+			var resource *cljs.Resource
+			if json.Unmarshal(body, &resource) == nil && resource != nil {
+				panic(fmt.Sprintf("error: HTTP %d. API call failed with error '%v' (code %s)",
+					resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code))
+			}
+			panic(fmt.Sprintf("error: HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+		}
+		err = readStreamEvents(resp.Body, handleEvent)
+		resp.Body.Close()
+		select {
+		case <-stopped:
+			return status, nil
+		default:
+		}
+		if eventErr != nil {
+			panic(eventErr)
+		}
+		if err == bufio.ErrTooLong {
+			panic(err)
+		}
+		if !finished {
+			// the connection was closed before the end of the action, reconnect
+			attempts++
+			if attempts > 5 {
+				panic(fmt.Sprintf("stream of results interrupted too many times: %v", err))
+			}
+			time.Sleep(time.Duration(attempts) * time.Second)
+		}
+	}
+	if onCommand == nil {
+		return
+	}
+	// look for commands that completed without being sent by the stream
+	cmds, err := cli.FetchActionResults(a)
+	if err != nil {
+		panic(err)
+	}
+	for _, cmd := range cmds {
+		if seen[cmd.ID] || cmd.Status == mig.StatusSent {
+			continue
+		}
+		err = onCommand(cmd)
+		if err != nil {
+			panic(err)
+		}
+		seen[cmd.ID] = true
+	}
+	return
+}
+
+// readStreamEvents reads server-sent events from r and calls fn for each of them
+// with the event type, its ID and its data
+func readStreamEvents(r io.Reader, fn func(event, id, data string) error) (err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamEventSize)
+	var (
+		event, id string
+		data      []string
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// an empty line dispatches the event
+			if event != "" || len(data) > 0 {
+				err = fn(event, id, strings.Join(data, "\n"))
+				if err != nil {
+					return
+				}
+			}
+			event, id, data = "", "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field = line[:i]
+			value = strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	return scanner.Err()
+}
+
+// FollowActionResults follows the completion of action a like FollowAction does, and
+// calls handler with each command as soon as it completes. It returns
+// ErrStreamUnsupported if the API does not provide streams of action results, in which
+// case the results must be retrieved once the action has been followed.
+func (cli Client) FollowActionResults(a mig.Action, total int, stop chan bool, handler func(mig.Command) error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("FollowActionResults() -> %v", e)
+		}
+	}()
+	var bar *pb.ProgressBar
+	previousctr := 0
+	cancelled := false
+	stopStream := make(chan bool, 1)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		// stop is left alone once the stream returns, so the caller
+		// can fall back to polling with the same channel
+		select {
+		case <-stop:
+			cancelled = true
+			stopStream <- true
+		case <-done:
+		}
+	}()
+	_, err = cli.StreamActionResults(a, stopStream, handler, func(st mig.ActionStreamStatus) {
+		// the progress bar is started once the stream is established
+		if bar == nil {
+			fmt.Fprintf(os.Stderr, "\x1b[34mFollowing action ID %.0f.\x1b[0m\n", a.ID)
+			bar = pb.New(total)
+			bar.ShowSpeed = true
+			bar.SetMaxWidth(80)
+			bar.Output = os.Stderr
+			bar.Start()
+		}
+		if st.Counters.Done > previousctr && st.Counters.Done <= total {
+			bar.Add(st.Counters.Done - previousctr)
+			bar.Update()
+			previousctr = st.Counters.Done
+		}
+	})
+	if err != nil {
+		if bar != nil {
+			bar.Finish()
+		}
+		if err == ErrStreamUnsupported {
+			return
+		}
+		panic(err)
+	}
+	if cancelled {
+		if bar != nil {
+			bar.Postfix(" [cancelling]")
+			bar.Finish()
+		}
+		return nil
+	}
+	if bar != nil {
+		bar.Add(total - previousctr)
+		bar.Update()
+		bar.Finish()
+	}
+	cli.printFollowSummary(a.ID)
+	return
+}
@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package client /* import "github.com/mozilla/mig/client" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+func streamCommand(id float64, status string) mig.Command {
+	var cmd mig.Command
+	cmd.ID = id
+	cmd.Action.ID = 1
+	cmd.Status = status
+	return cmd
+}
+
+// writeTestEvent writes a server-sent event with data marshalled to JSON
+func writeTestEvent(w http.ResponseWriter, event, id string, data interface{}) {
+	buf, _ := json.Marshal(data)
+	if id != "" {
+		fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event, id, buf)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, buf)
+}
+
+func TestStreamActionResults(t *testing.T) {
+	done := mig.ActionStreamStatus{Status: "completed"}
+	var tests = []struct {
+		Description string
+		// events sent by each connection to the stream, a connection that
+		// does not end with a done event is interrupted
+		Connections [][]mig.Command
+		// commands returned by the search endpoint once the stream is done
+		Search []mig.Command
+		Expect []float64
+	}{
+		{
+			Description: `all commands sent by the stream`,
+			Connections: [][]mig.Command{{streamCommand(101, mig.StatusSuccess), streamCommand(102, mig.StatusFailed)}},
+			Search:      []mig.Command{streamCommand(101, mig.StatusSuccess), streamCommand(102, mig.StatusFailed)},
+			Expect:      []float64{101, 102},
+		},
+		{
+			Description: `command committed after the stream read past it`,
+			Connections: [][]mig.Command{{streamCommand(102, mig.StatusSuccess)}},
+			Search: []mig.Command{streamCommand(101, mig.StatusSuccess), streamCommand(102, mig.StatusSuccess),
+				streamCommand(103, mig.StatusSent)},
+			Expect: []float64{102, 101},
+		},
+		{
+			Description: `commands sent again after an interruption`,
+			Connections: [][]mig.Command{
+				{streamCommand(101, mig.StatusSuccess)},
+				{streamCommand(101, mig.StatusSuccess), streamCommand(102, mig.StatusSuccess)},
+			},
+			Search: []mig.Command{streamCommand(101, mig.StatusSuccess), streamCommand(102, mig.StatusSuccess)},
+			Expect: []float64{101, 102},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			conn := 0
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1/action/stream", func(w http.ResponseWriter, r *http.Request) {
+				if conn > 0 && r.URL.Query().Get("lasteventid") != "0" {
+					t.Errorf("stream resumed without the last event ID: %s", r.URL)
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, cmd := range tc.Connections[conn] {
+					writeTestEvent(w, "command", "0", cmd)
+				}
+				conn++
+				if conn == len(tc.Connections) {
+					writeTestEvent(w, "done", "", done)
+				}
+			})
+			mux.HandleFunc("/api/v1/search", func(w http.ResponseWriter, r *http.Request) {
+				resource := cljs.New(r.URL.String())
+				if r.URL.Query().Get("offset") != "0" {
+					resource.SetError(cljs.Error{Code: "0", Message: "no results found"})
+					w.WriteHeader(http.StatusNotFound)
+					json.NewEncoder(w).Encode(resource)
+					return
+				}
+				for _, cmd := range tc.Search {
+					resource.AddItem(cljs.Item{
+						Href: "command",
+						Data: []cljs.Data{{Name: "command", Value: cmd}},
+					})
+				}
+				json.NewEncoder(w).Encode(resource)
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			var cli Client
+			cli.API = srv.Client()
+			cli.Conf.API.URL = srv.URL + "/api/v1/"
+			cli.Conf.GPG.UseAPIKeyAuth = "testkey"
+			var a mig.Action
+			a.ID = 1
+			var got []float64
+			status, err := cli.StreamActionResults(a, make(chan bool), func(cmd mig.Command) error {
+				got = append(got, cmd.ID)
+				return nil
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Status != done.Status {
+				t.Fatalf("expected final status %s, got %s", done.Status, status.Status)
+			}
+			if !reflect.DeepEqual(got, tc.Expect) {
+				t.Fatalf("expected commands %v, got %v", tc.Expect, got)
+			}
+		})
+	}
+}
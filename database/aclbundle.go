@@ -0,0 +1,192 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mozilla/mig"
+)
+
+// ACLBundleAdd adds a new ACL bundle to the database in the staged status, and returns
+// the ID it was assigned
+func (db *DB) ACLBundleAdd(b mig.ACLBundle) (bid float64, err error) {
+	jACL, err := json.Marshal(b.ACL)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal acl: '%v'", err)
+		return
+	}
+	err = db.c.QueryRow(`INSERT INTO aclbundles VALUES
+		(DEFAULT, $1, $2, date_trunc('second', now()), 'staged', $3)
+		RETURNING id`, b.Name, jACL, b.Target).Scan(&bid)
+	if err != nil {
+		err = fmt.Errorf("Failed to insert acl bundle: '%v'", err)
+		return
+	}
+	return
+}
+
+// ACLBundleAddSignature adds a signature to an existing ACL bundle, and activates the
+// bundle once it has reqsig signatures
+func (db *DB) ACLBundleAddSignature(bid float64, sig string, invid float64, reqsig int) (err error) {
+	res, err := db.c.Exec(`INSERT INTO aclbundlesig
+		(aclbundleid, pgpsignature, investigatorid)
+		SELECT $1, $2, $3
+		WHERE EXISTS (SELECT id FROM aclbundles
+		WHERE id=$4 AND status!='disabled')`, bid, sig, invid, bid)
+	if err != nil {
+		return
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if ra != 1 {
+		return fmt.Errorf("ACL bundle signing operation failed")
+	}
+	err = db.ACLBundleUpdateStatus(bid, reqsig)
+	return
+}
+
+// ACLBundleDisable disables an ACL bundle
+func (db *DB) ACLBundleDisable(bid float64) (err error) {
+	_, err = db.c.Exec(`UPDATE aclbundles SET status='disabled' WHERE
+		id=$1`, bid)
+	return
+}
+
+// ACLBundleUpdateStatus updates the status of an ACL bundle based on the number of
+// signatures it has, reqsig indicates the number of signatures a bundle must have
+// to be considered active
+func (db *DB) ACLBundleUpdateStatus(bid float64, reqsig int) (err error) {
+	var cnt int
+	err = db.c.QueryRow(`SELECT COUNT(*) FROM aclbundlesig
+		WHERE aclbundleid=$1`, bid).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	status := "staged"
+	if cnt >= reqsig {
+		status = "active"
+	}
+	_, err = db.c.Exec(`UPDATE aclbundles SET status=$1 WHERE
+		id=$2 AND status!='disabled'`, status, bid)
+	return
+}
+
+// ACLBundleClearSignatures removes the existing signatures of an ACL bundle
+func (db *DB) ACLBundleClearSignatures(bid float64) (err error) {
+	_, err = db.c.Exec(`DELETE FROM aclbundlesig WHERE aclbundleid=$1`, bid)
+	return err
+}
+
+// GetACLBundleFromID returns ACL bundle bid and its signatures from the database
+func (db *DB) GetACLBundleFromID(bid float64) (ret mig.ACLBundle, err error) {
+	var jACL []byte
+	err = db.c.QueryRow(`SELECT id, name, acl, timestamp, status, target
+		FROM aclbundles WHERE id=$1`, bid).Scan(&ret.ID, &ret.Name, &jACL,
+		&ret.Timestamp, &ret.Status, &ret.Target)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving acl bundle: '%v'", err)
+		return
+	}
+	err = json.Unmarshal(jACL, &ret.ACL)
+	if err != nil {
+		err = fmt.Errorf("Failed to unmarshal acl: '%v'", err)
+		return
+	}
+	ret.Signatures, err = db.aclBundleSignatures(bid)
+	return
+}
+
+// ActiveACLBundles returns the active ACL bundles, most recent first
+func (db *DB) ActiveACLBundles() (bundles []mig.ACLBundle, err error) {
+	rows, err := db.c.Query(`SELECT id, name, acl, timestamp, status, target
+		FROM aclbundles WHERE status='active' ORDER BY timestamp DESC, id DESC`)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while listing acl bundles: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var (
+			b    mig.ACLBundle
+			jACL []byte
+		)
+		err = rows.Scan(&b.ID, &b.Name, &jACL, &b.Timestamp, &b.Status, &b.Target)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve acl bundle: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jACL, &b.ACL)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal acl: '%v'", err)
+			return
+		}
+		bundles = append(bundles, b)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+		return
+	}
+	for i := range bundles {
+		bundles[i].Signatures, err = db.aclBundleSignatures(bundles[i].ID)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ACLBundleAgents returns the active ACL bundles, most recent first, and for each of them
+// the active agents it applies to, indexed by bundle ID. An agent matched by the target of
+// several bundles is only assigned the most recent one.
+func (db *DB) ACLBundleAgents() (bundles []mig.ACLBundle, agents map[float64][]mig.Agent, err error) {
+	bundles, err = db.ActiveACLBundles()
+	if err != nil {
+		return
+	}
+	agents = make(map[float64][]mig.Agent)
+	assigned := make(map[float64]bool)
+	for _, b := range bundles {
+		targeted, err := db.ActiveAgentsByTarget(b.Target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to evaluate target of acl bundle %.0f: '%v'", b.ID, err)
+		}
+		for _, agt := range targeted {
+			if assigned[agt.ID] {
+				continue
+			}
+			assigned[agt.ID] = true
+			agents[b.ID] = append(agents[b.ID], agt)
+		}
+	}
+	return
+}
+
+// aclBundleSignatures returns the signatures of ACL bundle bid
+func (db *DB) aclBundleSignatures(bid float64) (sigs []string, err error) {
+	rows, err := db.c.Query(`SELECT pgpsignature FROM aclbundlesig
+		WHERE aclbundleid=$1`, bid)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return
+		}
+		sigs = append(sigs, s)
+	}
+	err = rows.Err()
+	return
+}
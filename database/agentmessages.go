@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"fmt"
+	"time"
+)
+
+// AgentMessage is a message exchanged between the scheduler and an agent that
+// polls the API instead of using the relay. ID is set when the message is read
+// from the database.
+type AgentMessage struct {
+	ID   float64
+	Type string
+	Body []byte
+}
+
+// InsertAgentMessage queues a message in the database, the message is discarded
+// if it has not been taken before expireafter
+func (db *DB) InsertAgentMessage(queue string, msg AgentMessage, expireafter time.Time) (err error) {
+	_, err = db.c.Exec(`INSERT INTO agentmessages (queue, type, body, createdat, expireafter)
+		VALUES ($1, $2, $3, NOW(), $4)`, queue, msg.Type, msg.Body, expireafter)
+	if err != nil {
+		err = fmt.Errorf("Failed to insert agent message: '%v'", err)
+	}
+	return
+}
+
+// TakeAgentMessages removes up to limit messages from a queue and returns them,
+// in the order they were queued. Expired messages are discarded. The scheduler
+// uses it to collect the results uploaded by agents.
+func (db *DB) TakeAgentMessages(queue string, limit int) (msgs []AgentMessage, err error) {
+	rows, err := db.c.Query(`DELETE FROM agentmessages WHERE id IN (
+		SELECT id FROM agentmessages WHERE queue=$1 AND expireafter > NOW()
+		ORDER BY id ASC LIMIT $2 FOR UPDATE SKIP LOCKED)
+		RETURNING id, type, body`, queue, limit)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to take agent messages: '%v'", err)
+		return
+	}
+	var ids []float64
+	for rows.Next() {
+		var (
+			id  float64
+			msg AgentMessage
+		)
+		err = rows.Scan(&id, &msg.Type, &msg.Body)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent message: '%v'", err)
+			return
+		}
+		msg.ID = id
+		ids = append(ids, id)
+		msgs = append(msgs, msg)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+		return
+	}
+	// RETURNING does not preserve the order of the subquery
+	sortAgentMessages(ids, msgs)
+	return
+}
+
+// PendingAgentMessages returns up to limit messages of a queue with an ID above
+// after, in the order they were queued, without removing them. Expired messages
+// are not returned.
+func (db *DB) PendingAgentMessages(queue string, after float64, limit int) (msgs []AgentMessage, err error) {
+	rows, err := db.c.Query(`SELECT id, type, body FROM agentmessages
+		WHERE queue=$1 AND id > $2 AND expireafter > NOW()
+		ORDER BY id ASC LIMIT $3`, queue, after, limit)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to find agent messages: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var msg AgentMessage
+		err = rows.Scan(&msg.ID, &msg.Type, &msg.Body)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent message: '%v'", err)
+			return
+		}
+		msgs = append(msgs, msg)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// AckAgentMessages removes the messages of a queue with an ID up to upto, once
+// the agent has acknowledged receiving them
+func (db *DB) AckAgentMessages(queue string, upto float64) (err error) {
+	_, err = db.c.Exec(`DELETE FROM agentmessages WHERE queue=$1 AND id <= $2`, queue, upto)
+	if err != nil {
+		err = fmt.Errorf("Failed to acknowledge agent messages: '%v'", err)
+	}
+	return
+}
+
+// sortAgentMessages sorts msgs by their ids, in place
+func sortAgentMessages(ids []float64, msgs []AgentMessage) {
+	for i := 1; i < len(ids); i++ {
+		for j := i; j > 0 && ids[j] < ids[j-1]; j-- {
+			ids[j], ids[j-1] = ids[j-1], ids[j]
+			msgs[j], msgs[j-1] = msgs[j-1], msgs[j]
+		}
+	}
+}
+
+// DeleteExpiredAgentMessages removes the messages that have expired before being
+// taken, and returns how many were removed
+func (db *DB) DeleteExpiredAgentMessages() (count int64, err error) {
+	res, err := db.c.Exec(`DELETE FROM agentmessages WHERE expireafter <= NOW()`)
+	if err != nil {
+		err = fmt.Errorf("Failed to delete expired agent messages: '%v'", err)
+		return
+	}
+	return res.RowsAffected()
+}
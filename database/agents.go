@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/target"
 
 	_ "github.com/lib/pq"
 )
@@ -36,12 +37,15 @@ func (db *DB) AgentByQueueAndPID(queueloc string, pid int) (agent mig.Agent, err
 
 // AgentByID returns a single agent identified by its ID
 func (db *DB) AgentByID(id float64) (agent mig.Agent, err error) {
-	var jTags, jEnv []byte
+	var (
+		jTags, jEnv []byte
+		aclHash     sql.NullString
+	)
 	err = db.c.QueryRow(`SELECT id, name, queueloc, mode, version, pid, starttime, heartbeattime,
-		refreshtime, status, tags, environment FROM agents WHERE id=$1`, id).Scan(
+		refreshtime, status, tags, environment, aclhash FROM agents WHERE id=$1`, id).Scan(
 		&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Mode, &agent.Version, &agent.PID,
 		&agent.StartTime, &agent.HeartBeatTS, &agent.RefreshTS, &agent.Status,
-		&jTags, &jEnv)
+		&jTags, &jEnv, &aclHash)
 	if err != nil {
 		err = fmt.Errorf("Error while retrieving agent: '%v'", err)
 		return
@@ -59,6 +63,7 @@ func (db *DB) AgentByID(id float64) (agent mig.Agent, err error) {
 		err = fmt.Errorf("failed to unmarshal agent environment")
 		return
 	}
+	agent.ACLHash = aclHash.String
 	return
 }
 
@@ -91,10 +96,16 @@ func (db *DB) AgentsActiveSince(pointInTime time.Time) (agents []mig.Agent, err
 }
 
 // InsertAgent creates a new agent in the database
+func (db *DB) InsertAgent(agt mig.Agent) (err error) {
+	_, err = db.insertAgent(agt, nil)
+	return
+}
+
+// insertAgent inserts an agent and returns the ID it was assigned
 //
 // If useTx is not nil, the transaction will be used instead of the standard
 // connection
-func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
+func (db *DB) insertAgent(agt mig.Agent, useTx *sql.Tx) (agtid float64, err error) {
 	jEnv, err := json.Marshal(agt.Env)
 	if err != nil {
 		err = fmt.Errorf("Failed to marshal agent environment: '%v'", err)
@@ -105,31 +116,31 @@ func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
 		err = fmt.Errorf("Failed to marshal agent tags: '%v'", err)
 		return
 	}
-	agtid := mig.GenID()
+	agtid = mig.GenID()
 	// Insert the new agent; note here we also attempt to query the loaders table
 	// and see if we can get a loadername for the new agent instance, if it's not
 	// associated with a loader the value will just be NULL.
 	if useTx != nil {
 		_, err = useTx.Exec(`INSERT INTO agents
 		(id, name, queueloc, mode, version, pid, starttime, destructiontime,
-		heartbeattime, refreshtime, status, environment, tags, loadername)
+		heartbeattime, refreshtime, status, environment, tags, loadername, aclhash)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
-		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1))`,
+		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1), $15)`,
 			agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
 			agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
-			agt.Status, jEnv, jTags, agt.QueueLoc)
+			agt.Status, jEnv, jTags, agt.QueueLoc, agt.ACLHash)
 	} else {
 		_, err = db.c.Exec(`INSERT INTO agents
 		(id, name, queueloc, mode, version, pid, starttime, destructiontime,
-		heartbeattime, refreshtime, status, environment, tags, loadername)
+		heartbeattime, refreshtime, status, environment, tags, loadername, aclhash)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
-		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1))`,
+		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1), $15)`,
 			agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
 			agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
-			agt.Status, jEnv, jTags, agt.QueueLoc)
+			agt.Status, jEnv, jTags, agt.QueueLoc, agt.ACLHash)
 	}
 	if err != nil {
-		return fmt.Errorf("Failed to insert agent in database: '%v'", err)
+		return 0, fmt.Errorf("Failed to insert agent in database: '%v'", err)
 	}
 	return
 }
@@ -138,9 +149,9 @@ func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
 // unless the agent has been marked as destroyed
 func (db *DB) UpdateAgentHeartbeat(agt mig.Agent) (err error) {
 	_, err = db.c.Exec(`UPDATE agents SET status=$1, heartbeattime=$2,
-		loadername=(SELECT loadername FROM loaders WHERE queueloc = $3 LIMIT 1)
-		WHERE id=$4`,
-		mig.AgtStatusOnline, agt.HeartBeatTS, agt.QueueLoc, agt.ID)
+		loadername=(SELECT loadername FROM loaders WHERE queueloc = $3 LIMIT 1),
+		aclhash=$4 WHERE id=$5`,
+		mig.AgtStatusOnline, agt.HeartBeatTS, agt.QueueLoc, agt.ACLHash, agt.ID)
 	if err != nil {
 		return fmt.Errorf("Failed to update agent in database: '%v'", err)
 	}
@@ -163,7 +174,13 @@ func (db *DB) ReplaceRefreshedAgent(agt mig.Agent) (err error) {
 		_ = tx.Rollback()
 		return
 	}
-	err = db.InsertAgent(agt, tx)
+	newid, err := db.insertAgent(agt, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	// the refreshed agent keeps the module permissions of the agent it replaces
+	err = copyModulePermissions(tx, agt.ID, newid)
 	if err != nil {
 		_ = tx.Rollback()
 		return
@@ -235,9 +252,29 @@ func (db *DB) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agent
 }
 
 // ActiveAgentsByTarget runs a search for all agents that match a given target string.
-// For safety, it does so in a transaction that runs as a readonly user.
-func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error) {
-	var jTags, jEnv []byte
+// The target is compiled into a parameterized condition using the target package, unless
+// it is a raw SQL condition and those have been allowed with AllowRawSQLTargets. For
+// safety, the search runs in a transaction as a readonly user.
+func (db *DB) ActiveAgentsByTarget(tgt string) (agents []mig.Agent, err error) {
+	var (
+		jTags, jEnv []byte
+		cond        string
+	)
+	args := []interface{}{mig.AgtStatusOnline, mig.AgtStatusIdle}
+	if target.IsRawSQL(tgt) {
+		if !db.rawSQLTargets {
+			err = fmt.Errorf("raw SQL targets are not allowed")
+			return
+		}
+		cond = target.RawSQL(tgt)
+	} else {
+		var targs []interface{}
+		cond, targs, err = target.Compile(tgt, len(args))
+		if err != nil {
+			return
+		}
+		args = append(args, targs...)
+	}
 	// save current user
 	var dbuser string
 	err = db.c.QueryRow("SELECT CURRENT_USER").Scan(&dbuser)
@@ -255,9 +292,9 @@ func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error
 	}
 	rows, err := txn.Query(fmt.Sprintf(`SELECT DISTINCT ON (queueloc) id, name, queueloc,
 		version, pid, starttime, destructiontime, heartbeattime, refreshtime, status,
-		mode, environment, tags, loadername
-		FROM agents WHERE agents.status IN ('%s', '%s') AND (%s)
-		ORDER BY agents.queueloc ASC`, mig.AgtStatusOnline, mig.AgtStatusIdle, target))
+		mode, environment, tags, loadername, aclhash
+		FROM agents WHERE agents.status IN ($1, $2) AND (%s)
+		ORDER BY agents.queueloc ASC`, cond), args...)
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -270,10 +307,11 @@ func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error
 		var (
 			agent      mig.Agent
 			loaderName sql.NullString
+			aclHash    sql.NullString
 		)
 		err = rows.Scan(&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Version,
 			&agent.PID, &agent.StartTime, &agent.DestructionTime, &agent.HeartBeatTS,
-			&agent.RefreshTS, &agent.Status, &agent.Mode, &jEnv, &jTags, &loaderName)
+			&agent.RefreshTS, &agent.Status, &agent.Mode, &jEnv, &jTags, &loaderName, &aclHash)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve agent data: '%v'", err)
 			return
@@ -291,6 +329,7 @@ func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error
 		if loaderName.Valid && loaderName.String != "" {
 			agent.LoaderName = loaderName.String
 		}
+		agent.ACLHash = aclHash.String
 		agents = append(agents, agent)
 	}
 	if err := rows.Err(); err != nil {
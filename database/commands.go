@@ -128,6 +128,94 @@ func (db *DB) CommandsByActionID(actionid float64) (commands []mig.Command, err
 	return
 }
 
+// FinishedCommand is a command returned by CommandsFinishedAfter, along with its
+// position in the order in which commands were updated in the database
+type FinishedCommand struct {
+	Command mig.Command
+	Seq     float64
+}
+
+// CommandsFinishedAfter returns up to limit commands of an action that are no longer
+// in flight and were updated after position after, in the order they were updated.
+// The position is taken from a sequence when the status of a command is updated,
+// rather than from its finish time, which is set by the scheduler before the update
+// is committed. Updates are not committed in the order of the sequence, so a later
+// call can return commands below the position of the last command returned, and
+// callers must read again the positions they have seen. 0 starts before any command.
+func (db *DB) CommandsFinishedAfter(actionid float64, after float64, limit int) (commands []FinishedCommand, err error) {
+	rows, err := db.c.Query(`SELECT commands.id, commands.status, commands.results, commands.starttime,
+		COALESCE(commands.finishtime, commands.starttime), COALESCE(commands.finishseq, 0),
+		actions.id, actions.name, actions.target, actions.description, actions.threat,
+		actions.operations, actions.validfrom, actions.expireafter,
+		actions.pgpsignatures, actions.syntaxversion,
+		agents.id, agents.name, agents.version, agents.tags, agents.environment
+		FROM commands, actions, agents
+		WHERE commands.actionid=actions.id AND commands.agentid=agents.id AND actions.id=$1
+		AND commands.status!=$2 AND COALESCE(commands.finishseq, 0) > $3
+		ORDER BY COALESCE(commands.finishseq, 0), commands.id LIMIT $4`,
+		actionid, mig.StatusSent, after, limit)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while finding finished commands: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var jRes, jDesc, jThreat, jOps, jSig, jAgtTags, jAgtEnv []byte
+		var cmd mig.Command
+		var seq float64
+		err = rows.Scan(&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &cmd.FinishTime, &seq,
+			&cmd.Action.ID, &cmd.Action.Name, &cmd.Action.Target, &jDesc, &jThreat, &jOps,
+			&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion,
+			&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.Version, &jAgtTags, &jAgtEnv)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve command: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jRes, &cmd.Results)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal command results: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jDesc, &cmd.Action.Description)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal action description: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jThreat, &cmd.Action.Threat)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal action threat: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jOps, &cmd.Action.Operations)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal action operations: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jSig, &cmd.Action.PGPSignatures)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal action signatures: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jAgtTags, &cmd.Agent.Tags)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal agent tags: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jAgtEnv, &cmd.Agent.Env)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal agent environment: '%v'", err)
+			return
+		}
+		commands = append(commands, FinishedCommand{Command: cmd, Seq: seq})
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
 // InsertCommand writes a command into the database
 func (db *DB) InsertCommand(cmd mig.Command, agt mig.Agent) (err error) {
 	jResults, err := json.Marshal(cmd.Results)
@@ -185,7 +273,8 @@ func (db *DB) InsertCommands(cmds []mig.Command) (insertCount int64, err error)
 // UpdateSentCommand updates a command into the database, unless its status is already
 // set to 'success'
 func (db *DB) UpdateSentCommand(cmd mig.Command) (err error) {
-	res, err := db.c.Exec(`UPDATE commands SET status=$1 WHERE id=$2 and status!=$3`,
+	res, err := db.c.Exec(`UPDATE commands SET status=$1, finishseq=nextval('commands_finishseq_seq')
+		WHERE id=$2 and status!=$3`,
 		cmd.Status, cmd.ID, mig.StatusSuccess)
 	if err != nil {
 		return fmt.Errorf("Error while updating command: '%v'", err)
@@ -225,7 +314,8 @@ func (db *DB) FinishCommand(cmd mig.Command) (err error) {
 		return err
 	}
 
-	res, err := db.c.Exec(`UPDATE commands SET status=$1, results=$2, finishtime=$3
+	res, err := db.c.Exec(`UPDATE commands SET status=$1, results=$2, finishtime=$3,
+		finishseq=nextval('commands_finishseq_seq')
 		WHERE id=$4 AND status!=$5 AND agentid IN (
 			SELECT id FROM agents
 			WHERE agents.queueloc=$6 AND agents.pid=$7 AND status IN ('online','idle')
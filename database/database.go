@@ -14,7 +14,8 @@ import (
 )
 
 type DB struct {
-	c *sql.DB
+	c             *sql.DB
+	rawSQLTargets bool
 }
 
 // NewDB constructs a new DB from a SQL database connection.
@@ -43,3 +44,9 @@ func (db *DB) Close() {
 func (db *DB) SetMaxOpenConns(n int) {
 	db.c.SetMaxOpenConns(n)
 }
+
+// AllowRawSQLTargets controls whether action targets can be raw SQL conditions
+// instead of expressions of the target language
+func (db *DB) AllowRawSQLTargets(allow bool) {
+	db.rawSQLTargets = allow
+}
@@ -0,0 +1,468 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"fmt"
+	"time"
+)
+
+// Migration is a change to the database schema. Migrations are applied in order
+// of version, and the versions that have been applied are recorded in the
+// schema_version table.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// CompareSchemaVersion returns an error if a database at schema version version
+// can't be used by a binary that knows migrations up to version latest
+func CompareSchemaVersion(version, latest int) error {
+	switch {
+	case version == 0:
+		return fmt.Errorf("database has no schema, run with -migrate to create it")
+	case version < latest:
+		return fmt.Errorf("database schema version %d is older than version %d, run with -migrate to upgrade it", version, latest)
+	case version > latest:
+		return fmt.Errorf("database schema version %d is unknown, this release supports up to version %d", version, latest)
+	}
+	return nil
+}
+
+// LatestSchemaVersion returns the version of the last migration of the Postgres schema
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the schema of the database, or 0 if the
+// database is empty. Databases created before migrations were introduced have
+// no schema_version table, and are at version 1.
+func (db *DB) SchemaVersion() (version int, err error) {
+	var versioned, populated bool
+	err = db.c.QueryRow(`SELECT to_regclass('schema_version') IS NOT NULL,
+		to_regclass('actions') IS NOT NULL`).Scan(&versioned, &populated)
+	if err != nil {
+		err = fmt.Errorf("Failed to look up schema version: '%v'", err)
+		return
+	}
+	if !versioned {
+		if populated {
+			version = 1
+		}
+		return
+	}
+	err = db.c.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		err = fmt.Errorf("Failed to look up schema version: '%v'", err)
+	}
+	return
+}
+
+// CheckSchemaVersion returns an error if the schema of the database is not at
+// the version of the last migration
+func (db *DB) CheckSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	return CompareSchemaVersion(version, LatestSchemaVersion())
+}
+
+// Migrate applies the migrations the database is missing, each in its own
+// transaction, and returns the migrations that were applied. It must be run
+// by a role that owns the schema, such as migadmin.
+func (db *DB) Migrate() (applied []Migration, err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+	if version > LatestSchemaVersion() {
+		return nil, CompareSchemaVersion(version, LatestSchemaVersion())
+	}
+	_, err = db.c.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version     integer NOT NULL PRIMARY KEY,
+		description character varying(256) NOT NULL,
+		appliedat   timestamp with time zone NOT NULL
+	);
+	GRANT SELECT ON schema_version TO migapi, migscheduler;`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create schema_version table: '%v'", err)
+	}
+	if version == 1 {
+		// record the schema of an unversioned database as the initial one
+		_, err = db.c.Exec(`INSERT INTO schema_version (version, description, appliedat)
+			VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			migrations[0].Version, migrations[0].Description, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to record schema version: '%v'", err)
+		}
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		err = db.applyMigration(m)
+		if err != nil {
+			return
+		}
+		applied = append(applied, m)
+	}
+	return
+}
+
+func (db *DB) applyMigration(m Migration) (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(m.SQL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to apply migration %d (%s): '%v'", m.Version, m.Description, err)
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description, appliedat)
+		VALUES ($1, $2, $3)`, m.Version, m.Description, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to record migration %d: '%v'", m.Version, err)
+	}
+	return tx.Commit()
+}
+
+// migrations lists the changes to the Postgres schema since the initial one.
+// database/schema.sql must be kept identical to the result of applying them
+// all, and must record the version of the last one. Databases that predate
+// migrations are upgraded from version 1 even if they were created with a later
+// schema.sql, so migrations create objects only if they don't exist yet.
+// Migrations must run on PostgreSQL 9.5, which doesn't support ADD COLUMN IF
+// NOT EXISTS, so columns are added in DO blocks that check for them first.
+var migrations = []Migration{
+	{1, "initial schema", schemaV1},
+	{2, "module requirements and permissions", `
+GRANT INSERT ON agtmodreq, invagtmodperm TO migscheduler;
+GRANT INSERT, UPDATE, DELETE ON agtmodreq, invagtmodperm TO migapi;
+GRANT INSERT ON modules TO migapi;
+`},
+	{3, "acl bundles", `
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name='agents' AND column_name='aclhash') THEN
+		ALTER TABLE agents ADD COLUMN aclhash character varying(64);
+	END IF;
+END
+$$;
+
+CREATE SEQUENCE IF NOT EXISTS aclbundles_id_seq START 1;
+CREATE TABLE IF NOT EXISTS aclbundles (
+	id        numeric NOT NULL DEFAULT nextval('aclbundles_id_seq') PRIMARY KEY,
+	name      character varying(256) NOT NULL,
+	acl       json NOT NULL,
+	timestamp timestamp with time zone NOT NULL,
+	status    character varying(255) NOT NULL,
+	target    character varying(2048) NOT NULL
+);
+ALTER TABLE public.aclbundles OWNER TO migadmin;
+CREATE INDEX IF NOT EXISTS aclbundles_status_idx ON aclbundles(status);
+
+CREATE TABLE IF NOT EXISTS aclbundlesig (
+	aclbundleid    numeric NOT NULL REFERENCES aclbundles(id),
+	investigatorid numeric NOT NULL,
+	pgpsignature   character varying(4096) NOT NULL
+);
+ALTER TABLE public.aclbundlesig OWNER TO migadmin;
+CREATE UNIQUE INDEX IF NOT EXISTS aclbundlesig_aclbundleid_investigatorid_idx ON aclbundlesig USING btree(aclbundleid, investigatorid);
+
+GRANT SELECT ON aclbundles, aclbundlesig TO migscheduler;
+GRANT SELECT, INSERT ON aclbundles, aclbundlesig TO migapi;
+GRANT DELETE ON aclbundlesig TO migapi;
+GRANT UPDATE (status) ON aclbundles TO migapi;
+GRANT USAGE ON SEQUENCE aclbundles_id_seq TO migapi;
+`},
+	{4, "action approval", `
+GRANT UPDATE (pgpsignatures, status) ON actions TO migapi;
+`},
+	{5, "agent messages", `
+CREATE SEQUENCE IF NOT EXISTS agentmessages_id_seq START 1;
+CREATE TABLE IF NOT EXISTS agentmessages (
+	id          numeric NOT NULL DEFAULT nextval('agentmessages_id_seq') PRIMARY KEY,
+	queue       character varying(2048) NOT NULL,
+	type        character varying(256) NOT NULL,
+	body        bytea NOT NULL,
+	createdat   timestamp with time zone NOT NULL,
+	expireafter timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agentmessages OWNER TO migadmin;
+CREATE INDEX IF NOT EXISTS agentmessages_queue_idx ON agentmessages(queue);
+CREATE INDEX IF NOT EXISTS agentmessages_expireafter_idx ON agentmessages(expireafter);
+
+GRANT SELECT, INSERT, DELETE ON agentmessages TO migscheduler;
+GRANT USAGE ON SEQUENCE agentmessages_id_seq TO migscheduler;
+GRANT SELECT, INSERT, DELETE ON agentmessages TO migapi;
+GRANT USAGE ON SEQUENCE agentmessages_id_seq TO migapi;
+`},
+	{6, "module ids from a sequence", `
+CREATE SEQUENCE IF NOT EXISTS modules_id_seq START 1;
+SELECT setval('modules_id_seq', COALESCE((SELECT MAX(id) FROM modules), 0)::bigint + 1, false);
+ALTER TABLE modules ALTER COLUMN id SET DEFAULT nextval('modules_id_seq');
+CREATE UNIQUE INDEX IF NOT EXISTS modules_name_idx ON modules USING btree(name);
+GRANT USAGE ON SEQUENCE modules_id_seq TO migapi;
+`},
+	{7, "command update sequence", `
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name='commands' AND column_name='finishseq') THEN
+		ALTER TABLE commands ADD COLUMN finishseq bigint;
+	END IF;
+END
+$$;
+CREATE INDEX IF NOT EXISTS commands_actionid_finishseq ON commands(actionid, finishseq);
+CREATE SEQUENCE IF NOT EXISTS commands_finishseq_seq START 1;
+GRANT USAGE ON SEQUENCE commands_finishseq_seq TO migscheduler;
+`},
+}
+
+// schemaV1 is database/schema.sql as it was before migrations were introduced
+const schemaV1 = `
+CREATE TABLE actions (
+    id              numeric NOT NULL,
+    name            character varying(2048) NOT NULL,
+    target          character varying(2048) NOT NULL,
+    description     json,
+    threat          json,
+    operations      json,
+    validfrom       timestamp with time zone NOT NULL,
+    expireafter     timestamp with time zone NOT NULL,
+    starttime       timestamp with time zone,
+    finishtime      timestamp with time zone,
+    lastupdatetime  timestamp with time zone,
+    status          character varying(256),
+    syntaxversion   integer,
+    pgpsignatures   character varying(4096) NOT NULL
+);
+ALTER TABLE public.actions OWNER TO migadmin;
+ALTER TABLE ONLY actions
+    ADD CONSTRAINT actions_pkey PRIMARY KEY (id);
+
+CREATE TABLE agents (
+    id                  numeric NOT NULL,
+    name                character varying(2048) NOT NULL,
+    queueloc            character varying(2048) NOT NULL,
+    mode                character varying(2048) NOT NULL,
+    version             character varying(2048) NOT NULL,
+    pid                 integer NOT NULL,
+    starttime           timestamp with time zone NOT NULL,
+    destructiontime     timestamp with time zone,
+    heartbeattime       timestamp with time zone NOT NULL,
+    refreshtime         timestamp with time zone NOT NULL,
+    status              character varying(255),
+    environment         json,
+    tags                json,
+    loadername          character varying(2048)
+);
+ALTER TABLE public.agents OWNER TO migadmin;
+ALTER TABLE ONLY agents
+    ADD CONSTRAINT agents_pkey PRIMARY KEY (id);
+CREATE INDEX agents_heartbeattime_idx ON agents(heartbeattime DESC);
+CREATE INDEX agents_starttime_idx ON agents(starttime DESC);
+CREATE INDEX agents_queueloc_pid_idx ON agents(queueloc, pid);
+CREATE INDEX agents_status_idx ON agents(status);
+
+CREATE TABLE agents_stats (
+    timestamp                   timestamp with time zone not null,
+    online_agents               numeric,
+    online_agents_by_version    json,
+    online_endpoints            numeric,
+    idle_agents                 numeric,
+    idle_agents_by_version      json,
+    idle_endpoints              numeric,
+    new_endpoints               numeric,
+    multi_agents_endpoints      numeric,
+    disappeared_endpoints       numeric,
+    flapping_endpoints          numeric
+);
+
+CREATE TABLE agtmodreq (
+    moduleid        numeric NOT NULL,
+    agentid         numeric NOT NULL,
+    minimumweight   integer NOT NULL
+);
+ALTER TABLE public.agtmodreq OWNER TO migadmin;
+CREATE UNIQUE INDEX agtmodreq_moduleid_agentid_idx ON agtmodreq USING btree (moduleid, agentid);
+CREATE INDEX agtmodreq_agentid_idx ON agtmodreq USING btree (agentid);
+CREATE INDEX agtmodreq_moduleid_idx ON agtmodreq USING btree (moduleid);
+
+CREATE TABLE commands (
+    id          numeric NOT NULL,
+    actionid    numeric NOT NULL,
+    agentid     numeric NOT NULL,
+    status      character varying(255) NOT NULL,
+    results     json,
+    starttime   timestamp with time zone NOT NULL,
+    finishtime  timestamp with time zone
+);
+ALTER TABLE public.commands OWNER TO migadmin;
+ALTER TABLE ONLY commands
+    ADD CONSTRAINT commands_pkey PRIMARY KEY (id);
+CREATE INDEX commands_agentid ON commands(agentid DESC);
+CREATE INDEX commands_actionid ON commands(actionid DESC);
+
+CREATE TABLE invagtmodperm (
+    investigatorid  numeric NOT NULL,
+    agentid         numeric NOT NULL,
+    moduleid        numeric NOT NULL,
+    weight          integer NOT NULL
+);
+ALTER TABLE public.invagtmodperm OWNER TO migadmin;
+CREATE UNIQUE INDEX invagtmodperm_investigatorid_agentid_moduleid_idx ON invagtmodperm USING btree (investigatorid, agentid, moduleid);
+CREATE INDEX invagtmodperm_agentid_idx ON invagtmodperm USING btree (agentid);
+CREATE INDEX invagtmodperm_investigatorid_idx ON invagtmodperm USING btree (investigatorid);
+CREATE INDEX invagtmodperm_moduleid_idx ON invagtmodperm USING btree (moduleid);
+
+CREATE SEQUENCE investigators_id_seq START 1;
+CREATE TABLE investigators (
+    id              numeric NOT NULL DEFAULT nextval('investigators_id_seq'),
+    name            character varying(1024) NOT NULL,
+    pgpfingerprint  character varying(128),
+    publickey       bytea,
+    privatekey      bytea,
+    status          character varying(255) NOT NULL,
+    createdat       timestamp with time zone NOT NULL,
+    lastmodified    timestamp with time zone NOT NULL,
+    permissions     bigint NOT NULL DEFAULT 0,
+    apikey          bytea,
+    apisalt         bytea
+);
+ALTER TABLE public.investigators OWNER TO migadmin;
+ALTER TABLE ONLY investigators
+    ADD CONSTRAINT investigators_pkey PRIMARY KEY (id);
+CREATE UNIQUE INDEX investigators_pgpfingerprint_idx ON investigators USING btree (pgpfingerprint);
+
+CREATE SEQUENCE manifests_id_seq START 1;
+CREATE TABLE manifests (
+	id        numeric NOT NULL DEFAULT nextval('manifests_id_seq'),
+	name      character varying(256) NOT NULL,
+	content   text NOT NULL,
+	timestamp timestamp with time zone NOT NULL,
+	status    character varying(255) NOT NULL,
+	target    character varying(2048) NOT NULL
+);
+ALTER TABLE public.manifests OWNER TO migadmin;
+ALTER TABLE ONLY manifests
+    ADD CONSTRAINT manifests_pkey PRIMARY KEY (id);
+
+CREATE TABLE manifestsig (
+	manifestid     numeric NOT NULL,
+	investigatorid numeric NOT NULL,
+	pgpsignature   character varying(4096) NOT NULL
+);
+CREATE UNIQUE INDEX manifestsig_manifestid_investigatorid_idx ON manifestsig USING btree(manifestid, investigatorid);
+
+CREATE SEQUENCE loaders_id_seq START 1;
+CREATE TABLE loaders (
+	id            numeric NOT NULL DEFAULT nextval('loaders_id_seq'),
+	loadername    character varying(256) NOT NULL,
+	keyprefix     character varying(256) NOT NULL,
+	loaderkey     bytea NOT NULL,
+	salt          bytea NOT NULL,
+	name          character varying(2048),
+	env           json,
+	tags          json,
+	lastseen      timestamp with time zone NOT NULL,
+	enabled       boolean NOT NULL DEFAULT false,
+	expectenv     character varying(2048),
+	queueloc      character varying(2048)
+);
+ALTER TABLE ONLY loaders
+    ADD CONSTRAINT loaders_pkey PRIMARY KEY (id);
+CREATE UNIQUE INDEX loaders_loadername_idx ON loaders USING btree(loadername);
+CREATE UNIQUE INDEX loaders_loaderkey_idx ON loaders USING btree(loaderkey);
+CREATE UNIQUE INDEX loaders_keyprefix_idx ON loaders USING btree(keyprefix);
+CREATE UNIQUE INDEX loaders_queueloc_idx ON loaders USING btree(queueloc);
+ALTER TABLE public.loaders OWNER TO migadmin;
+
+CREATE TABLE modules (
+    id      numeric NOT NULL,
+    name    character varying(256) NOT NULL
+);
+ALTER TABLE public.modules OWNER TO migadmin;
+ALTER TABLE ONLY modules
+    ADD CONSTRAINT modules_pkey PRIMARY KEY (id);
+
+CREATE TABLE signatures (
+    actionid        numeric NOT NULL,
+    investigatorid  numeric NOT NULL,
+    pgpsignature    character varying(4096) NOT NULL
+);
+ALTER TABLE public.signatures OWNER TO migadmin;
+CREATE UNIQUE INDEX signatures_actionid_investigatorid_idx ON signatures USING btree (actionid, investigatorid);
+CREATE INDEX signatures_actionid_idx ON signatures USING btree (actionid);
+CREATE INDEX signatures_investigatorid_idx ON signatures USING btree (investigatorid);
+
+ALTER TABLE ONLY agtmodreq
+    ADD CONSTRAINT agtmodreq_moduleid_fkey FOREIGN KEY (moduleid) REFERENCES modules(id);
+
+ALTER TABLE ONLY commands
+    ADD CONSTRAINT commands_actionid_fkey FOREIGN KEY (actionid) REFERENCES actions(id);
+
+ALTER TABLE ONLY commands
+    ADD CONSTRAINT commands_agentid_fkey FOREIGN KEY (agentid) REFERENCES agents(id);
+
+ALTER TABLE ONLY manifestsig
+    ADD CONSTRAINT manifestsig_manifestid_fkey FOREIGN KEY (manifestid) REFERENCES manifests(id);
+
+ALTER TABLE ONLY invagtmodperm
+    ADD CONSTRAINT invagtmodperm_agentid_fkey FOREIGN KEY (agentid) REFERENCES agents(id);
+
+ALTER TABLE ONLY invagtmodperm
+    ADD CONSTRAINT invagtmodperm_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+ALTER TABLE ONLY invagtmodperm
+    ADD CONSTRAINT invagtmodperm_moduleid_fkey FOREIGN KEY (moduleid) REFERENCES modules(id);
+
+ALTER TABLE ONLY signatures
+    ADD CONSTRAINT signatures_actionid_fkey FOREIGN KEY (actionid) REFERENCES actions(id);
+
+ALTER TABLE ONLY signatures
+    ADD CONSTRAINT signatures_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+-- Scheduler can read all tables, insert and select private keys in the investigators table, but cannot update investigators
+GRANT SELECT ON ALL TABLES IN SCHEMA public TO migscheduler;
+GRANT INSERT, UPDATE ON actions, commands, agents, agents_stats, signatures TO migscheduler;
+GRANT INSERT ON investigators TO migscheduler;
+GRANT USAGE ON SEQUENCE investigators_id_seq TO migscheduler;
+
+-- API has limited permissions, and cannot list scheduler private keys in the investigators table, but can update their statuses
+GRANT SELECT ON actions, agents, agents_stats, agtmodreq, commands, invagtmodperm, loaders, manifests, manifestsig, modules, signatures TO migapi;
+GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions, apikey, apisalt) ON investigators TO migapi;
+GRANT INSERT ON agents, actions, signatures, manifests, manifestsig, loaders TO migapi;
+GRANT UPDATE ON agents TO migapi;
+GRANT DELETE ON manifestsig TO migapi;
+GRANT INSERT (name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions) ON investigators TO migapi;
+GRANT UPDATE (permissions, status, lastmodified, apikey, apisalt) ON investigators TO migapi;
+GRANT UPDATE (name, env, tags, loaderkey, salt, lastseen, enabled, expectenv, queueloc) ON loaders TO migapi;
+GRANT UPDATE (status) ON manifests TO migapi;
+GRANT USAGE ON SEQUENCE investigators_id_seq TO migapi;
+GRANT USAGE ON SEQUENCE loaders_id_seq TO migapi;
+GRANT USAGE ON SEQUENCE manifests_id_seq TO migapi;
+
+-- readonly user is used for things like expanding targets. roles are shared
+-- by all the databases of a cluster, so it may already exist.
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname='migreadonly') THEN
+		CREATE ROLE migreadonly;
+	END IF;
+END
+$$;
+ALTER ROLE migreadonly WITH NOSUPERUSER INHERIT NOCREATEROLE NOCREATEDB NOLOGIN;
+GRANT SELECT ON actions, agents, agtmodreq, commands, invagtmodperm, modules, signatures TO migreadonly;
+GRANT SELECT (id, env, tags, expectenv, loadername, queueloc) ON loaders TO migreadonly;
+GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified) ON investigators TO migreadonly;
+GRANT migreadonly TO migapi;
+GRANT migreadonly TO migscheduler;
+`
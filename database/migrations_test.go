@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %q has version %d, expected %d", m.Description, m.Version, i+1)
+		}
+		if strings.TrimSpace(m.SQL) == "" {
+			t.Errorf("migration %d is empty", m.Version)
+		}
+	}
+}
+
+// TestMigrationsPostgres95 looks for ADD COLUMN IF NOT EXISTS, which PostgreSQL
+// 9.5, the oldest supported release, doesn't know
+func TestMigrationsPostgres95(t *testing.T) {
+	for _, m := range migrations {
+		if strings.Contains(strings.ToUpper(m.SQL), "ADD COLUMN IF NOT EXISTS") {
+			t.Errorf("migration %d uses ADD COLUMN IF NOT EXISTS, which PostgreSQL 9.5 doesn't support", m.Version)
+		}
+	}
+}
+
+func TestSchemaFileVersion(t *testing.T) {
+	schema, err := ioutil.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := migrations[len(migrations)-1]
+	insert := fmt.Sprintf("INSERT INTO schema_version (version, description, appliedat) VALUES (%d, '%s', NOW());",
+		last.Version, last.Description)
+	if !strings.Contains(string(schema), insert) {
+		t.Errorf("schema.sql does not record version %d of the schema, expected %q", last.Version, insert)
+	}
+}
+
+// TestTestingSchema checks that the database of the dockerized test environment
+// is created with the current schema, which the API and the scheduler require
+func TestTestingSchema(t *testing.T) {
+	schema, err := ioutil.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initdb, err := ioutil.ReadFile("../testing/init_migapi_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(initdb), string(schema)) {
+		t.Errorf("testing/init_migapi_db.sql does not end with database/schema.sql")
+	}
+}
+
+func TestCompareSchemaVersion(t *testing.T) {
+	var tests = []struct {
+		version, latest int
+		ok              bool
+	}{
+		{5, 5, true},
+		{0, 5, false},
+		{3, 5, false},
+		{6, 5, false},
+	}
+	for _, tt := range tests {
+		err := CompareSchemaVersion(tt.version, tt.latest)
+		if (err == nil) != tt.ok {
+			t.Errorf("CompareSchemaVersion(%d, %d) returned %v", tt.version, tt.latest, err)
+		}
+	}
+}
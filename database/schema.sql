@@ -1,3 +1,6 @@
+-- Schema of a new MIG database, at the version of the last migration in
+-- database/migrations.go. Changes to this file must come with a migration.
+
 CREATE TABLE actions (
     id              numeric NOT NULL,
     name            character varying(2048) NOT NULL,
@@ -32,7 +35,8 @@ CREATE TABLE agents (
     status              character varying(255),
     environment         json,
     tags                json,
-    loadername          character varying(2048)
+    loadername          character varying(2048),
+    aclhash             character varying(64)
 );
 ALTER TABLE public.agents OWNER TO migadmin;
 ALTER TABLE ONLY agents
@@ -73,13 +77,16 @@ CREATE TABLE commands (
     status      character varying(255) NOT NULL,
     results     json,
     starttime   timestamp with time zone NOT NULL,
-    finishtime  timestamp with time zone
+    finishtime  timestamp with time zone,
+    finishseq   bigint
 );
 ALTER TABLE public.commands OWNER TO migadmin;
 ALTER TABLE ONLY commands
     ADD CONSTRAINT commands_pkey PRIMARY KEY (id);
 CREATE INDEX commands_agentid ON commands(agentid DESC);
 CREATE INDEX commands_actionid ON commands(actionid DESC);
+CREATE INDEX commands_actionid_finishseq ON commands(actionid, finishseq);
+CREATE SEQUENCE commands_finishseq_seq START 1;
 
 CREATE TABLE invagtmodperm (
     investigatorid  numeric NOT NULL,
@@ -132,6 +139,28 @@ CREATE TABLE manifestsig (
 );
 CREATE UNIQUE INDEX manifestsig_manifestid_investigatorid_idx ON manifestsig USING btree(manifestid, investigatorid);
 
+CREATE SEQUENCE aclbundles_id_seq START 1;
+CREATE TABLE aclbundles (
+	id        numeric NOT NULL DEFAULT nextval('aclbundles_id_seq'),
+	name      character varying(256) NOT NULL,
+	acl       json NOT NULL,
+	timestamp timestamp with time zone NOT NULL,
+	status    character varying(255) NOT NULL,
+	target    character varying(2048) NOT NULL
+);
+ALTER TABLE public.aclbundles OWNER TO migadmin;
+ALTER TABLE ONLY aclbundles
+    ADD CONSTRAINT aclbundles_pkey PRIMARY KEY (id);
+CREATE INDEX aclbundles_status_idx ON aclbundles(status);
+
+CREATE TABLE aclbundlesig (
+	aclbundleid    numeric NOT NULL,
+	investigatorid numeric NOT NULL,
+	pgpsignature   character varying(4096) NOT NULL
+);
+ALTER TABLE public.aclbundlesig OWNER TO migadmin;
+CREATE UNIQUE INDEX aclbundlesig_aclbundleid_investigatorid_idx ON aclbundlesig USING btree(aclbundleid, investigatorid);
+
 CREATE SEQUENCE loaders_id_seq START 1;
 CREATE TABLE loaders (
 	id            numeric NOT NULL DEFAULT nextval('loaders_id_seq'),
@@ -155,13 +184,15 @@ CREATE UNIQUE INDEX loaders_keyprefix_idx ON loaders USING btree(keyprefix);
 CREATE UNIQUE INDEX loaders_queueloc_idx ON loaders USING btree(queueloc);
 ALTER TABLE public.loaders OWNER TO migadmin;
 
+CREATE SEQUENCE modules_id_seq START 1;
 CREATE TABLE modules (
-    id      numeric NOT NULL,
+    id      numeric NOT NULL DEFAULT nextval('modules_id_seq'),
     name    character varying(256) NOT NULL
 );
 ALTER TABLE public.modules OWNER TO migadmin;
 ALTER TABLE ONLY modules
     ADD CONSTRAINT modules_pkey PRIMARY KEY (id);
+CREATE UNIQUE INDEX modules_name_idx ON modules USING btree(name);
 
 CREATE TABLE signatures (
     actionid        numeric NOT NULL,
@@ -173,6 +204,21 @@ CREATE UNIQUE INDEX signatures_actionid_investigatorid_idx ON signatures USING b
 CREATE INDEX signatures_actionid_idx ON signatures USING btree (actionid);
 CREATE INDEX signatures_investigatorid_idx ON signatures USING btree (investigatorid);
 
+CREATE SEQUENCE agentmessages_id_seq START 1;
+CREATE TABLE agentmessages (
+	id          numeric NOT NULL DEFAULT nextval('agentmessages_id_seq'),
+	queue       character varying(2048) NOT NULL,
+	type        character varying(256) NOT NULL,
+	body        bytea NOT NULL,
+	createdat   timestamp with time zone NOT NULL,
+	expireafter timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agentmessages OWNER TO migadmin;
+ALTER TABLE ONLY agentmessages
+    ADD CONSTRAINT agentmessages_pkey PRIMARY KEY (id);
+CREATE INDEX agentmessages_queue_idx ON agentmessages(queue);
+CREATE INDEX agentmessages_expireafter_idx ON agentmessages(expireafter);
+
 ALTER TABLE ONLY agtmodreq
     ADD CONSTRAINT agtmodreq_moduleid_fkey FOREIGN KEY (moduleid) REFERENCES modules(id);
 
@@ -185,6 +231,9 @@ ALTER TABLE ONLY commands
 ALTER TABLE ONLY manifestsig
     ADD CONSTRAINT manifestsig_manifestid_fkey FOREIGN KEY (manifestid) REFERENCES manifests(id);
 
+ALTER TABLE ONLY aclbundlesig
+    ADD CONSTRAINT aclbundlesig_aclbundleid_fkey FOREIGN KEY (aclbundleid) REFERENCES aclbundles(id);
+
 ALTER TABLE ONLY invagtmodperm
     ADD CONSTRAINT invagtmodperm_agentid_fkey FOREIGN KEY (agentid) REFERENCES agents(id);
 
@@ -200,28 +249,56 @@ ALTER TABLE ONLY signatures
 ALTER TABLE ONLY signatures
     ADD CONSTRAINT signatures_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
 
+CREATE TABLE schema_version (
+    version     integer NOT NULL PRIMARY KEY,
+    description character varying(256) NOT NULL,
+    appliedat   timestamp with time zone NOT NULL
+);
+ALTER TABLE public.schema_version OWNER TO migadmin;
+INSERT INTO schema_version (version, description, appliedat) VALUES (7, 'command update sequence', NOW());
+
 -- Scheduler can read all tables, insert and select private keys in the investigators table, but cannot update investigators
 GRANT SELECT ON ALL TABLES IN SCHEMA public TO migscheduler;
 GRANT INSERT, UPDATE ON actions, commands, agents, agents_stats, signatures TO migscheduler;
+GRANT INSERT ON agtmodreq, invagtmodperm TO migscheduler;
 GRANT INSERT ON investigators TO migscheduler;
+GRANT INSERT, DELETE ON agentmessages TO migscheduler;
+GRANT USAGE ON SEQUENCE agentmessages_id_seq TO migscheduler;
 GRANT USAGE ON SEQUENCE investigators_id_seq TO migscheduler;
+GRANT USAGE ON SEQUENCE commands_finishseq_seq TO migscheduler;
 
 -- API has limited permissions, and cannot list scheduler private keys in the investigators table, but can update their statuses
-GRANT SELECT ON actions, agents, agents_stats, agtmodreq, commands, invagtmodperm, loaders, manifests, manifestsig, modules, signatures TO migapi;
+GRANT SELECT ON aclbundles, aclbundlesig, actions, agents, agents_stats, agtmodreq, commands, invagtmodperm, loaders, manifests, manifestsig, modules, signatures TO migapi;
 GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions, apikey, apisalt) ON investigators TO migapi;
-GRANT INSERT ON agents, actions, signatures, manifests, manifestsig, loaders TO migapi;
+GRANT INSERT ON agents, actions, signatures, manifests, manifestsig, aclbundles, aclbundlesig, loaders TO migapi;
 GRANT UPDATE ON agents TO migapi;
-GRANT DELETE ON manifestsig TO migapi;
+GRANT DELETE ON manifestsig, aclbundlesig TO migapi;
+GRANT INSERT, UPDATE, DELETE ON agtmodreq, invagtmodperm TO migapi;
+GRANT SELECT, INSERT, DELETE ON agentmessages TO migapi;
+GRANT INSERT ON modules TO migapi;
 GRANT INSERT (name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions) ON investigators TO migapi;
 GRANT UPDATE (permissions, status, lastmodified, apikey, apisalt) ON investigators TO migapi;
 GRANT UPDATE (name, env, tags, loaderkey, salt, lastseen, enabled, expectenv, queueloc) ON loaders TO migapi;
+GRANT UPDATE (pgpsignatures, status) ON actions TO migapi;
 GRANT UPDATE (status) ON manifests TO migapi;
+GRANT UPDATE (status) ON aclbundles TO migapi;
 GRANT USAGE ON SEQUENCE investigators_id_seq TO migapi;
 GRANT USAGE ON SEQUENCE loaders_id_seq TO migapi;
 GRANT USAGE ON SEQUENCE manifests_id_seq TO migapi;
+GRANT USAGE ON SEQUENCE aclbundles_id_seq TO migapi;
+GRANT USAGE ON SEQUENCE agentmessages_id_seq TO migapi;
+GRANT USAGE ON SEQUENCE modules_id_seq TO migapi;
+GRANT SELECT ON schema_version TO migapi;
 
--- readonly user is used for things like expanding targets
-CREATE ROLE migreadonly;
+-- readonly user is used for things like expanding targets. roles are shared
+-- by all the databases of a cluster, so it may already exist.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname='migreadonly') THEN
+        CREATE ROLE migreadonly;
+    END IF;
+END
+$$;
 ALTER ROLE migreadonly WITH NOSUPERUSER INHERIT NOCREATEROLE NOCREATEDB NOLOGIN;
 GRANT SELECT ON actions, agents, agtmodreq, commands, invagtmodperm, modules, signatures TO migreadonly;
 GRANT SELECT (id, env, tags, expectenv, loadername, queueloc) ON loaders TO migreadonly;
@@ -0,0 +1,320 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+const actionColumns = `actions.id, actions.name, actions.target, actions.description,
+	actions.threat, actions.operations, actions.validfrom, actions.expireafter,
+	actions.starttime, actions.finishtime, actions.lastupdatetime, actions.status,
+	actions.pgpsignatures, actions.syntaxversion`
+
+// scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAction reads the actionColumns of a row
+func scanAction(row scanner) (a mig.Action, err error) {
+	var jDesc, jThreat, jOps, jSig []byte
+	var status sql.NullString
+	err = row.Scan(&a.ID, &a.Name, &a.Target, &jDesc, &jThreat, &jOps,
+		&a.ValidFrom, &a.ExpireAfter, &a.StartTime, &a.FinishTime, &a.LastUpdateTime,
+		&status, &jSig, &a.SyntaxVersion)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving action: '%v'", err)
+		return
+	}
+	a.Status = status.String
+	err = unmarshalAction(&a, jDesc, jThreat, jOps, jSig)
+	return
+}
+
+func unmarshalAction(a *mig.Action, jDesc, jThreat, jOps, jSig []byte) (err error) {
+	err = json.Unmarshal(jDesc, &a.Description)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal action description: '%v'", err)
+	}
+	err = json.Unmarshal(jThreat, &a.Threat)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal action threat: '%v'", err)
+	}
+	err = json.Unmarshal(jOps, &a.Operations)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal action operations: '%v'", err)
+	}
+	err = json.Unmarshal(jSig, &a.PGPSignatures)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal action signatures: '%v'", err)
+	}
+	return
+}
+
+// actions runs a query returning actionColumns, and reads all its rows
+func (db *DB) actions(query string, args ...interface{}) (actions []mig.Action, err error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while listing actions: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a mig.Action
+		a, err = scanAction(rows)
+		if err != nil {
+			return
+		}
+		actions = append(actions, a)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// withCounters sets the counters of actions. The rows of the query that
+// returned the actions must have been read first.
+func (db *DB) withCounters(actions []mig.Action) (err error) {
+	for i := range actions {
+		actions[i].Counters, err = db.GetActionCounters(actions[i].ID)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LastActions retrieves the last X actions by time from the database
+func (db *DB) LastActions(limit int) (actions []mig.Action, err error) {
+	actions, err = db.actions(`SELECT `+actionColumns+`
+		FROM actions ORDER BY starttime DESC LIMIT ?1`, limit)
+	if err != nil {
+		return
+	}
+	err = db.withCounters(actions)
+	return
+}
+
+// ActionByID retrieves an action from the database using its ID
+// If the query fails, the returned action will have ID -1
+func (db *DB) ActionByID(id float64) (a mig.Action, err error) {
+	a, err = scanAction(db.queryRow(`SELECT `+actionColumns+` FROM actions WHERE id=?1`, id))
+	if err != nil {
+		a.ID = -1
+		return
+	}
+	a.Counters, err = db.GetActionCounters(a.ID)
+	return
+}
+
+// ActionMetaByID retrieves the metadata fields of an action from the database using its ID
+func (db *DB) ActionMetaByID(id float64) (a mig.Action, err error) {
+	err = db.queryRow(`SELECT id, name, validfrom, expireafter, starttime, finishtime, lastupdatetime,
+		status FROM actions WHERE id=?1`, id).Scan(&a.ID, &a.Name, &a.ValidFrom, &a.ExpireAfter,
+		&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving action: '%v'", err)
+	}
+	return
+}
+
+// InsertAction writes an action into the database.
+func (db *DB) InsertAction(a mig.Action) (err error) {
+	jDesc, err := json.Marshal(a.Description)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal description: '%v'", err)
+	}
+	jThreat, err := json.Marshal(a.Threat)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal threat: '%v'", err)
+	}
+	jOperations, err := json.Marshal(a.Operations)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal operations: '%v'", err)
+	}
+	aPGPSignatures, err := json.Marshal(a.PGPSignatures)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal pgp signatures: '%v'", err)
+	}
+	_, err = db.exec(`INSERT INTO actions
+		(id, name, target, description, threat, operations,
+		validfrom, expireafter, starttime, finishtime, lastupdatetime,
+		status, pgpsignatures, syntaxversion)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14)`,
+		a.ID, a.Name, a.Target, string(jDesc), string(jThreat), string(jOperations),
+		a.ValidFrom, a.ExpireAfter, a.StartTime, a.FinishTime, a.LastUpdateTime,
+		a.Status, string(aPGPSignatures), a.SyntaxVersion)
+	if err != nil {
+		return fmt.Errorf("Failed to store action: '%v'", err)
+	}
+	return
+}
+
+// UpdateAction stores updated action fields into the database.
+func (db *DB) UpdateAction(a mig.Action) (err error) {
+	_, err = db.exec(`UPDATE actions SET starttime=?2, lastupdatetime=?3, status=?4 WHERE id=?1`,
+		a.ID, a.StartTime, a.LastUpdateTime, a.Status)
+	if err != nil {
+		return fmt.Errorf("Failed to update action: '%v'", err)
+	}
+	return
+}
+
+// InsertOrUpdateAction looks for an existing action in DB and update it,
+// or insert a new one if none is found
+func (db *DB) InsertOrUpdateAction(a mig.Action) (inserted bool, err error) {
+	var id float64
+	err = db.queryRow(`SELECT id FROM actions WHERE id=?1`, a.ID).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return inserted, fmt.Errorf("Error while retrieving action: '%v'", err)
+	}
+	if err == sql.ErrNoRows {
+		inserted = true
+		return inserted, db.InsertAction(a)
+	}
+	return inserted, db.UpdateAction(a)
+}
+
+// UpdateActionStatus updates the status of an action
+func (db *DB) UpdateActionStatus(a mig.Action) (err error) {
+	_, err = db.exec(`UPDATE actions SET status=?2 WHERE id=?1`, a.ID, a.Status)
+	if err != nil {
+		return fmt.Errorf("Failed to update action status: '%v'", err)
+	}
+	return
+}
+
+// UpdateRunningAction stores updated time and counters on a running action
+func (db *DB) UpdateRunningAction(a mig.Action) (err error) {
+	_, err = db.exec(`UPDATE actions SET lastupdatetime=?2 WHERE id=?1`, a.ID, a.LastUpdateTime)
+	if err != nil {
+		return fmt.Errorf("Failed to update action: '%v'", err)
+	}
+	return
+}
+
+// FinishAction updates the action fields to mark it as done
+func (db *DB) FinishAction(a mig.Action) (err error) {
+	a.FinishTime = time.Now()
+	a.Status = "completed"
+	_, err = db.exec(`UPDATE actions SET finishtime=?1, lastupdatetime=?2, status=?3 WHERE id=?4`,
+		a.FinishTime, a.LastUpdateTime, a.Status, a.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update action: '%v'", err)
+	}
+	return
+}
+
+// InsertSignature create an entry in the signatures tables that map an investigator
+// to an action and a signature
+func (db *DB) InsertSignature(aid, iid float64, sig string) (err error) {
+	_, err = db.exec(`INSERT INTO signatures(actionid, investigatorid, pgpsignature)
+		VALUES(?1, ?2, ?3)`, aid, iid, sig)
+	if err != nil {
+		return fmt.Errorf("Failed to store signature: '%v'", err)
+	}
+	return
+}
+
+func (db *DB) GetActionCounters(aid float64) (counters mig.ActionCounters, err error) {
+	rows, err := db.query(`SELECT status, COUNT(id) FROM commands
+		WHERE actionid = ?1 GROUP BY status`, aid)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving counters: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var count int
+		var status string
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			err = fmt.Errorf("Error while retrieving counter: '%v'", err)
+			return
+		}
+		switch status {
+		case mig.StatusSent:
+			counters.InFlight = count
+			counters.Sent += count
+		case mig.StatusSuccess:
+			counters.Success = count
+			counters.Done += count
+			counters.Sent += count
+		case mig.StatusCancelled:
+			counters.Cancelled = count
+			counters.Done += count
+			counters.Sent += count
+		case mig.StatusExpired:
+			counters.Expired = count
+			counters.Done += count
+			counters.Sent += count
+		case mig.StatusFailed:
+			counters.Failed = count
+			counters.Done += count
+			counters.Sent += count
+		case mig.StatusTimeout:
+			counters.TimeOut = count
+			counters.Done += count
+			counters.Sent += count
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SetupRunnableActions retrieves actions that are ready to run, and marks them as
+// scheduled. The actions are selected and updated in a single transaction, so that
+// schedulers sharing the database don't run the same action twice.
+func (db *DB) SetupRunnableActions() (actions []mig.Action, err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	t := now()
+	rows, err := tx.Query(`SELECT `+actionColumns+` FROM actions
+		WHERE status='pending' AND validfrom < ?1 AND expireafter > ?1`, t)
+	if err != nil {
+		err = fmt.Errorf("Error while setting up runnable actions: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var a mig.Action
+		a, err = scanAction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.Status = "scheduled"
+		actions = append(actions, a)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	for _, a := range actions {
+		_, err = tx.Exec(`UPDATE actions SET status='scheduled' WHERE id=?1`, a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("Error while setting up runnable actions: '%v'", err)
+		}
+	}
+	err = tx.Commit()
+	return
+}
@@ -0,0 +1,117 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	migdb "github.com/mozilla/mig/database"
+)
+
+// InsertAgentMessage queues a message in the database, the message is discarded
+// if it has not been taken before expireafter
+func (db *DB) InsertAgentMessage(queue string, msg migdb.AgentMessage, expireafter time.Time) (err error) {
+	_, err = db.exec(`INSERT INTO agentmessages (queue, type, body, createdat, expireafter)
+		VALUES (?1, ?2, ?3, ?4, ?5)`, queue, msg.Type, msg.Body, now(), expireafter)
+	if err != nil {
+		err = fmt.Errorf("Failed to insert agent message: '%v'", err)
+	}
+	return
+}
+
+// TakeAgentMessages removes up to limit messages from a queue and returns them,
+// in the order they were queued. Expired messages are discarded.
+func (db *DB) TakeAgentMessages(queue string, limit int) (msgs []migdb.AgentMessage, err error) {
+	rows, err := db.query(`DELETE FROM agentmessages WHERE id IN (
+		SELECT id FROM agentmessages WHERE queue=?1 AND expireafter > ?2
+		ORDER BY id ASC LIMIT ?3)
+		RETURNING id, type, body`, queue, now(), limit)
+	if err != nil {
+		err = fmt.Errorf("Failed to take agent messages: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	type message struct {
+		id  int64
+		msg migdb.AgentMessage
+	}
+	var taken []message
+	for rows.Next() {
+		var m message
+		err = rows.Scan(&m.id, &m.msg.Type, &m.msg.Body)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent message: '%v'", err)
+			return
+		}
+		taken = append(taken, m)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+		return
+	}
+	// RETURNING does not preserve the order of the subquery
+	sort.Slice(taken, func(i, j int) bool { return taken[i].id < taken[j].id })
+	for _, m := range taken {
+		m.msg.ID = float64(m.id)
+		msgs = append(msgs, m.msg)
+	}
+	return
+}
+
+// PendingAgentMessages returns up to limit messages of a queue with an ID above
+// after, in the order they were queued, without removing them. Expired messages
+// are not returned.
+func (db *DB) PendingAgentMessages(queue string, after float64, limit int) (msgs []migdb.AgentMessage, err error) {
+	rows, err := db.query(`SELECT id, type, body FROM agentmessages
+		WHERE queue=?1 AND id > ?2 AND expireafter > ?3
+		ORDER BY id ASC LIMIT ?4`, queue, after, now(), limit)
+	if err != nil {
+		err = fmt.Errorf("Failed to find agent messages: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id  int64
+			msg migdb.AgentMessage
+		)
+		err = rows.Scan(&id, &msg.Type, &msg.Body)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent message: '%v'", err)
+			return
+		}
+		msg.ID = float64(id)
+		msgs = append(msgs, msg)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// AckAgentMessages removes the messages of a queue with an ID up to upto, once
+// the agent has acknowledged receiving them
+func (db *DB) AckAgentMessages(queue string, upto float64) (err error) {
+	_, err = db.exec(`DELETE FROM agentmessages WHERE queue=?1 AND id <= ?2`, queue, upto)
+	if err != nil {
+		err = fmt.Errorf("Failed to acknowledge agent messages: '%v'", err)
+	}
+	return
+}
+
+// DeleteExpiredAgentMessages removes the messages that have expired before being
+// taken, and returns how many were removed
+func (db *DB) DeleteExpiredAgentMessages() (count int64, err error) {
+	res, err := db.exec(`DELETE FROM agentmessages WHERE expireafter <= ?1`, now())
+	if err != nil {
+		err = fmt.Errorf("Failed to delete expired agent messages: '%v'", err)
+		return
+	}
+	return res.RowsAffected()
+}
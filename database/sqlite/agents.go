@@ -0,0 +1,480 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/target"
+)
+
+const agentColumns = `agents.id, agents.name, agents.queueloc, agents.mode, agents.version,
+	agents.pid, agents.starttime, agents.destructiontime, agents.heartbeattime,
+	agents.refreshtime, agents.status, agents.environment, agents.tags,
+	agents.loadername, agents.aclhash`
+
+// scanAgent reads the agentColumns of a row
+func scanAgent(row scanner) (agent mig.Agent, err error) {
+	var (
+		jEnv, jTags                 []byte
+		destruction                 sql.NullTime
+		status, loaderName, aclHash sql.NullString
+	)
+	err = row.Scan(&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Mode, &agent.Version,
+		&agent.PID, &agent.StartTime, &destruction, &agent.HeartBeatTS,
+		&agent.RefreshTS, &status, &jEnv, &jTags, &loaderName, &aclHash)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving agent: '%v'", err)
+		return
+	}
+	agent.DestructionTime = destruction.Time
+	agent.Status = status.String
+	agent.LoaderName = loaderName.String
+	agent.ACLHash = aclHash.String
+	if len(jTags) > 0 {
+		err = json.Unmarshal(jTags, &agent.Tags)
+		if err != nil {
+			err = fmt.Errorf("failed to unmarshal agent tags")
+			return
+		}
+	}
+	if len(jEnv) > 0 {
+		err = json.Unmarshal(jEnv, &agent.Env)
+		if err != nil {
+			err = fmt.Errorf("failed to unmarshal agent environment")
+			return
+		}
+	}
+	return
+}
+
+// agents runs a query returning agentColumns, and reads all its rows
+func (db *DB) agents(query string, args ...interface{}) (agents []mig.Agent, err error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while finding agents: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agent mig.Agent
+		agent, err = scanAgent(rows)
+		if err != nil {
+			return
+		}
+		agents = append(agents, agent)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// strings runs a query returning a single text column, and reads all its rows
+func (db *DB) strings(query string, args ...interface{}) (ret []string, err error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return
+		}
+		ret = append(ret, s)
+	}
+	err = rows.Err()
+	return
+}
+
+// AgentByQueueAndPID returns a single agent that is located at a given queueloc and has a given PID
+func (db *DB) AgentByQueueAndPID(queueloc string, pid int) (agent mig.Agent, err error) {
+	return scanAgent(db.queryRow(`SELECT `+agentColumns+` FROM agents
+		WHERE queueloc=?1 AND pid=?2 AND status!=?3`, queueloc, pid, mig.AgtStatusOffline))
+}
+
+// AgentByID returns a single agent identified by its ID
+func (db *DB) AgentByID(id float64) (agent mig.Agent, err error) {
+	return scanAgent(db.queryRow(`SELECT `+agentColumns+` FROM agents WHERE id=?1`, id))
+}
+
+// AgentsActiveSince returns an array of Agents that have sent a heartbeat between
+// a point in time and now
+func (db *DB) AgentsActiveSince(pointInTime time.Time) (agents []mig.Agent, err error) {
+	rows, err := db.query(`SELECT queueloc, name FROM agents
+		WHERE heartbeattime >= ?1 AND heartbeattime <= ?2
+		GROUP BY queueloc, name`, pointInTime, now())
+	if err != nil {
+		err = fmt.Errorf("Error while finding agents: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agent mig.Agent
+		err = rows.Scan(&agent.QueueLoc, &agent.Name)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent data: '%v'", err)
+			return
+		}
+		agents = append(agents, agent)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// InsertAgent creates a new agent in the database
+func (db *DB) InsertAgent(agt mig.Agent) (err error) {
+	_, err = insertAgent(db.c, agt)
+	return
+}
+
+// execer is implemented by sql.DB and sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertAgent inserts an agent and returns the ID it was assigned. The name of
+// the loader of the agent is looked up in the loaders table.
+func insertAgent(c execer, agt mig.Agent) (agtid float64, err error) {
+	jEnv, err := json.Marshal(agt.Env)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal agent environment: '%v'", err)
+		return
+	}
+	jTags, err := json.Marshal(agt.Tags)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal agent tags: '%v'", err)
+		return
+	}
+	agtid = mig.GenID()
+	_, err = c.Exec(`INSERT INTO agents
+		(id, name, queueloc, mode, version, pid, starttime, destructiontime,
+		heartbeattime, refreshtime, status, environment, tags, loadername, aclhash)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13,
+		(SELECT loadername FROM loaders WHERE queueloc = ?3 LIMIT 1), ?14)`,
+		utc([]interface{}{agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
+			agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
+			agt.Status, string(jEnv), string(jTags), agt.ACLHash})...)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to insert agent in database: '%v'", err)
+	}
+	return
+}
+
+// UpdateAgentHeartbeat updates the heartbeat timestamp of an agent in the database
+// unless the agent has been marked as destroyed
+func (db *DB) UpdateAgentHeartbeat(agt mig.Agent) (err error) {
+	_, err = db.exec(`UPDATE agents SET status=?1, heartbeattime=?2,
+		loadername=(SELECT loadername FROM loaders WHERE queueloc = ?3 LIMIT 1),
+		aclhash=?4 WHERE id=?5`,
+		mig.AgtStatusOnline, agt.HeartBeatTS, agt.QueueLoc, agt.ACLHash, agt.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update agent in database: '%v'", err)
+	}
+	return
+}
+
+// ReplaceRefreshedAgent replaces an existing agent in the database with newer
+// environment information. This should be called when we receive a heartbeat for an
+// active agent, but the refresh time indicates newer environment information exists.
+func (db *DB) ReplaceRefreshedAgent(agt mig.Agent) (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`UPDATE agents SET status=?1 WHERE id=?2`, mig.AgtStatusOffline, agt.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	newid, err := insertAgent(tx, agt)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	// the refreshed agent keeps the module permissions of the agent it replaces
+	err = copyModulePermissions(tx, agt.ID, newid)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	return tx.Commit()
+}
+
+// ListMultiAgentsQueues retrieves an array of queues that have more than one active agent
+func (db *DB) ListMultiAgentsQueues(pointInTime time.Time) (queues []string, err error) {
+	queues, err = db.strings(`SELECT queueloc FROM agents
+		WHERE heartbeattime > ?1 AND mode != 'checkin'
+		GROUP BY queueloc HAVING COUNT(queueloc) > 1`, pointInTime)
+	if err != nil {
+		err = fmt.Errorf("Error while listing multi agents queues: '%v'", err)
+	}
+	return
+}
+
+// ActiveAgentsByQueue retrieves an array of agents identified by their QueueLoc value
+func (db *DB) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agents []mig.Agent, err error) {
+	return db.agents(`SELECT `+agentColumns+` FROM agents
+		WHERE heartbeattime > ?1 AND queueloc=?2 AND status!=?3`,
+		pointInTime, queueloc, mig.AgtStatusOffline)
+}
+
+// ActiveAgentsByTarget returns one online or idle agent per queue location among the
+// agents that match a target expression. The expression is evaluated with
+// target.Match against every active agent, and raw SQL targets are rejected.
+func (db *DB) ActiveAgentsByTarget(tgt string) (agents []mig.Agent, err error) {
+	if target.IsRawSQL(tgt) {
+		return nil, fmt.Errorf("raw SQL targets are not supported by the sqlite backend")
+	}
+	expr, err := target.Parse(tgt)
+	if err != nil {
+		return
+	}
+	active, err := db.agents(`SELECT `+agentColumns+` FROM agents
+		WHERE status IN (?1, ?2) ORDER BY queueloc ASC, id ASC`,
+		mig.AgtStatusOnline, mig.AgtStatusIdle)
+	if err != nil {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, agt := range active {
+		if seen[agt.QueueLoc] {
+			continue
+		}
+		var ok bool
+		ok, err = target.Match(expr, agt, db.foundInAction)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			seen[agt.QueueLoc] = true
+			agents = append(agents, agt)
+		}
+	}
+	return
+}
+
+// foundInAction returns true if the agent returned results in which
+// foundanything is set to found for the given action
+func (db *DB) foundInAction(agt mig.Agent, aid float64, found bool) (bool, error) {
+	var n int
+	err := db.queryRow(`SELECT COUNT(*) FROM commands, json_each(commands.results) AS r
+		WHERE commands.actionid=?1 AND commands.agentid=?2
+		AND json_extract(r.value, '$.foundanything')=?3`, aid, agt.ID, found).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("Error while retrieving command results: '%v'", err)
+	}
+	return n > 0, nil
+}
+
+// MarkAgentDestroyed updated the status and destructiontime of an agent in the database
+func (db *DB) MarkAgentDestroyed(agent mig.Agent) (err error) {
+	_, err = db.exec(`UPDATE agents SET destructiontime=?1, status=?2 WHERE id=?3`,
+		time.Now(), mig.AgtStatusDestroyed, agent.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to mark agent as destroyed in database: '%v'", err)
+	}
+	return
+}
+
+// GetAgentsStats retrieves the latest agents statistics. limit controls how many rows
+// of statistics are returned
+func (db *DB) GetAgentsStats(limit int) (stats []mig.AgentsStats, err error) {
+	rows, err := db.query(`SELECT timestamp, online_agents, online_agents_by_version,
+		online_endpoints, idle_agents, idle_agents_by_version, idle_endpoints, new_endpoints,
+		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints
+		FROM agents_stats ORDER BY timestamp DESC LIMIT ?1`, limit)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving agent statistics: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jOnlAgtVer, jIdlAgtVer []byte
+		var s mig.AgentsStats
+		err = rows.Scan(&s.Timestamp, &s.OnlineAgents, &jOnlAgtVer, &s.OnlineEndpoints,
+			&s.IdleAgents, &jIdlAgtVer, &s.IdleEndpoints, &s.NewEndpoints,
+			&s.MultiAgentsEndpoints, &s.DisappearedEndpoints, &s.FlappingEndpoints)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve agent statistics data: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jOnlAgtVer, &s.OnlineAgentsByVersion)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal online agent by version statistics: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jIdlAgtVer, &s.IdleAgentsByVersion)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal idle agent by version statistics: '%v'", err)
+			return
+		}
+		stats = append(stats, s)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// StoreAgentsStats store a new row of agents statistics and sets the timestamp to the current time
+func (db *DB) StoreAgentsStats(stats mig.AgentsStats) (err error) {
+	jOnlAgtVer, err := json.Marshal(stats.OnlineAgentsByVersion)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal online agents by version: '%v'", err)
+	}
+	jIdlAgtVer, err := json.Marshal(stats.IdleAgentsByVersion)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal idle agents by version: '%v'", err)
+	}
+	_, err = db.exec(`INSERT INTO agents_stats
+		(timestamp, online_agents, online_agents_by_version, online_endpoints,
+		idle_agents, idle_agents_by_version, idle_endpoints, new_endpoints,
+		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)`,
+		now(), stats.OnlineAgents, string(jOnlAgtVer), stats.OnlineEndpoints,
+		stats.IdleAgents, string(jIdlAgtVer), stats.IdleEndpoints, stats.NewEndpoints,
+		stats.MultiAgentsEndpoints, stats.DisappearedEndpoints, stats.FlappingEndpoints)
+	if err != nil {
+		return fmt.Errorf("Failed to insert agent statistics in database: '%v'", err)
+	}
+	return
+}
+
+func (db *DB) sumAgentsByVersion(query string, args ...interface{}) (sum []mig.AgentsVersionsSum, err error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while counting agents: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var asum mig.AgentsVersionsSum
+		err = rows.Scan(&asum.Count, &asum.Version)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve summary data: '%v'", err)
+			return
+		}
+		sum = append(sum, asum)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SumOnlineAgentsByVersion retrieves a sum of online agents grouped by version
+func (db *DB) SumOnlineAgentsByVersion() (sum []mig.AgentsVersionsSum, err error) {
+	return db.sumAgentsByVersion(`SELECT COUNT(*), version FROM agents
+		WHERE status=?1 GROUP BY version`, mig.AgtStatusOnline)
+}
+
+// SumIdleAgentsByVersion retrieves a sum of idle agents grouped by version
+// and excludes endpoints where an online agent is running
+func (db *DB) SumIdleAgentsByVersion() (sum []mig.AgentsVersionsSum, err error) {
+	return db.sumAgentsByVersion(`SELECT COUNT(*), version FROM agents
+		WHERE status=?1 AND queueloc NOT IN (
+			SELECT DISTINCT queueloc FROM agents WHERE status=?2)
+		GROUP BY version`, mig.AgtStatusIdle, mig.AgtStatusOnline)
+}
+
+// count runs a query that returns a single count
+func (db *DB) count(desc, query string, args ...interface{}) (sum float64, err error) {
+	err = db.queryRow(query, args...).Scan(&sum)
+	if err != nil {
+		err = fmt.Errorf("Error while counting %s: '%v'", desc, err)
+	}
+	return
+}
+
+// CountOnlineEndpoints retrieves a count of unique endpoints that have online agents
+func (db *DB) CountOnlineEndpoints() (sum float64, err error) {
+	return db.count("online endpoints", `SELECT COUNT(DISTINCT queueloc) FROM agents
+		WHERE status=?1`, mig.AgtStatusOnline)
+}
+
+// CountIdleEndpoints retrieves a count of unique endpoints that have idle agents
+// and do not have an online agent
+func (db *DB) CountIdleEndpoints() (sum float64, err error) {
+	return db.count("idle endpoints", `SELECT COUNT(DISTINCT queueloc) FROM agents
+		WHERE status=?1 AND queueloc NOT IN (
+			SELECT queueloc FROM agents WHERE status=?2)`,
+		mig.AgtStatusIdle, mig.AgtStatusOnline)
+}
+
+// CountNewEndpoints retrieves a count of new endpoints that started after recent
+// and were not active between old and recent
+func (db *DB) CountNewEndpoints(recent, old time.Time) (sum float64, err error) {
+	return db.count("new endpoints", `SELECT COUNT(DISTINCT queueloc) FROM agents
+		WHERE queueloc NOT IN (
+			SELECT queueloc FROM agents
+			WHERE heartbeattime > ?2 AND heartbeattime < ?1)
+		AND starttime > ?1`, recent, old)
+}
+
+// CountDoubleAgents counts the number of endpoints that run more than one agent
+func (db *DB) CountDoubleAgents() (sum float64, err error) {
+	return db.count("double agents", `SELECT COUNT(*) FROM (
+			SELECT queueloc FROM agents WHERE status=?1
+			GROUP BY queueloc HAVING COUNT(queueloc) > 1)`, mig.AgtStatusOnline)
+}
+
+// CountDisappearedEndpoints a count of endpoints that have disappeared over a given period
+func (db *DB) CountDisappearedEndpoints(pointInTime time.Time) (sum float64, err error) {
+	return db.count("disappeared endpoints", `SELECT COUNT(DISTINCT queueloc) FROM agents
+		WHERE queueloc NOT IN (
+			SELECT queueloc FROM agents WHERE status=?1 OR status=?2)
+		AND heartbeattime > ?3`, mig.AgtStatusIdle, mig.AgtStatusOnline, pointInTime)
+}
+
+// GetDisappearedEndpoints retrieves a list of queues from endpoints that are no longer active
+func (db *DB) GetDisappearedEndpoints(oldest time.Time) (queues []string, err error) {
+	queues, err = db.strings(`SELECT DISTINCT queueloc FROM agents
+		WHERE status=?1 AND heartbeattime > ?2 AND queueloc NOT IN (
+			SELECT queueloc FROM agents WHERE status=?3 OR status=?4)`,
+		mig.AgtStatusOffline, oldest, mig.AgtStatusIdle, mig.AgtStatusOnline)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving disappeared endpoints: '%v'", err)
+	}
+	return
+}
+
+// CountFlappingEndpoints a count of endpoints that have restarted their agent recently
+func (db *DB) CountFlappingEndpoints() (sum float64, err error) {
+	return db.count("flapping endpoints", `SELECT COUNT(*) FROM (
+			SELECT queueloc FROM agents WHERE status=?1 OR status=?2
+			GROUP BY queueloc HAVING COUNT(queueloc) > 1)`,
+		mig.AgtStatusOnline, mig.AgtStatusIdle)
+}
+
+// MarkOfflineAgents updates the status of idle agents that have not sent a heartbeat since pointInTime
+func (db *DB) MarkOfflineAgents(pointInTime time.Time) (err error) {
+	_, err = db.exec(`UPDATE agents SET status=?1 WHERE heartbeattime<?2 AND status=?3`,
+		mig.AgtStatusOffline, pointInTime, mig.AgtStatusIdle)
+	if err != nil {
+		return fmt.Errorf("Failed to mark agents as offline in database: '%v'", err)
+	}
+	return
+}
+
+// MarkIdleAgents updates the status of online agents that have not sent a heartbeat since pointInTime
+func (db *DB) MarkIdleAgents(pointInTime time.Time) (err error) {
+	_, err = db.exec(`UPDATE agents SET status=?1 WHERE heartbeattime<?2 AND status=?3`,
+		mig.AgtStatusIdle, pointInTime, mig.AgtStatusOnline)
+	if err != nil {
+		return fmt.Errorf("Failed to mark agents as idle in database: '%v'", err)
+	}
+	return
+}
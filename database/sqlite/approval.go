@@ -0,0 +1,87 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mozilla/mig"
+)
+
+// ActionsPendingApproval returns the actions that are waiting to be co-signed by
+// investigators and have not expired yet, most recent first
+func (db *DB) ActionsPendingApproval() (actions []mig.Action, err error) {
+	actions, err = db.actions(`SELECT `+actionColumns+` FROM actions
+		WHERE status=?1 AND expireafter > ?2 ORDER BY validfrom DESC`,
+		mig.ActionStatusPendingApproval, now())
+	if err != nil {
+		err = fmt.Errorf("Error while listing actions pending approval: '%v'", err)
+	}
+	return
+}
+
+// AddApprovalSignature adds signature sig from investigator iid to action a, which must
+// be pending approval, and sets the status of the action to status. The signatures of a
+// must be the ones it had when it was retrieved from the database, if another signature
+// was added in the meantime the update fails and must be retried.
+func (db *DB) AddApprovalSignature(a mig.Action, iid float64, sig, status string) (err error) {
+	prevsigs, err := json.Marshal(a.PGPSignatures)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal pgp signatures: '%v'", err)
+	}
+	sigs := append([]string{}, a.PGPSignatures...)
+	newsigs, err := json.Marshal(append(sigs, sig))
+	if err != nil {
+		return fmt.Errorf("Failed to marshal pgp signatures: '%v'", err)
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	res, err := tx.Exec(`UPDATE actions SET pgpsignatures=?1, status=?2
+		WHERE id=?3 AND status=?4 AND pgpsignatures=?5`,
+		string(newsigs), status, a.ID, mig.ActionStatusPendingApproval, string(prevsigs))
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Failed to update action signatures: '%v'", err)
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	if ra != 1 {
+		_ = tx.Rollback()
+		return fmt.Errorf("Action %.0f is no longer pending approval or was modified", a.ID)
+	}
+	_, err = tx.Exec(`INSERT INTO signatures(actionid, investigatorid, pgpsignature)
+		VALUES(?1, ?2, ?3)`, a.ID, iid, sig)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Failed to store signature: '%v'", err)
+	}
+	return tx.Commit()
+}
+
+// RejectAction marks an action pending approval as rejected, it will never be
+// picked up by the scheduler
+func (db *DB) RejectAction(aid float64) (err error) {
+	res, err := db.exec(`UPDATE actions SET status=?1 WHERE id=?2 AND status=?3`,
+		mig.ActionStatusRejected, aid, mig.ActionStatusPendingApproval)
+	if err != nil {
+		return fmt.Errorf("Failed to reject action: '%v'", err)
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if ra != 1 {
+		return fmt.Errorf("Action %.0f is not pending approval", aid)
+	}
+	return
+}
@@ -0,0 +1,260 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/modules"
+)
+
+const commandColumns = `commands.id, commands.status, commands.results, commands.starttime,
+	commands.finishtime, actions.id, actions.name, actions.target, actions.description,
+	actions.threat, actions.operations, actions.validfrom, actions.expireafter,
+	actions.pgpsignatures, actions.syntaxversion,
+	agents.id, agents.name, agents.queueloc, agents.mode, agents.version,
+	agents.tags, agents.environment`
+
+// scanCommand reads the commandColumns of a row. Commands that are not finished
+// yet get their start time as finish time.
+func scanCommand(row scanner) (cmd mig.Command, err error) {
+	var (
+		jRes, jDesc, jThreat, jOps, jSig []byte
+		jAgtTags, jAgtEnv                []byte
+		finishtime                       sql.NullTime
+	)
+	err = row.Scan(&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &finishtime,
+		&cmd.Action.ID, &cmd.Action.Name, &cmd.Action.Target, &jDesc, &jThreat, &jOps,
+		&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion,
+		&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.QueueLoc, &cmd.Agent.Mode,
+		&cmd.Agent.Version, &jAgtTags, &jAgtEnv)
+	if err != nil {
+		err = fmt.Errorf("Failed to retrieve command: '%v'", err)
+		return
+	}
+	cmd.FinishTime = cmd.StartTime
+	if finishtime.Valid {
+		cmd.FinishTime = finishtime.Time
+	}
+	err = json.Unmarshal(jRes, &cmd.Results)
+	if err != nil {
+		err = fmt.Errorf("Failed to unmarshal command results: '%v'", err)
+		return
+	}
+	err = unmarshalAction(&cmd.Action, jDesc, jThreat, jOps, jSig)
+	if err != nil {
+		return
+	}
+	if len(jAgtTags) > 0 {
+		err = json.Unmarshal(jAgtTags, &cmd.Agent.Tags)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal agent tags: '%v'", err)
+			return
+		}
+	}
+	if len(jAgtEnv) > 0 {
+		err = json.Unmarshal(jAgtEnv, &cmd.Agent.Env)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal agent environment: '%v'", err)
+			return
+		}
+	}
+	return
+}
+
+// commands runs a query returning commandColumns, and reads all its rows
+func (db *DB) commands(query string, args ...interface{}) (commands []mig.Command, err error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while finding commands: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cmd mig.Command
+		cmd, err = scanCommand(rows)
+		if err != nil {
+			return
+		}
+		commands = append(commands, cmd)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// CommandByID retrieves a command from the database using its ID
+func (db *DB) CommandByID(id float64) (cmd mig.Command, err error) {
+	cmd, err = scanCommand(db.queryRow(`SELECT `+commandColumns+`
+		FROM commands, actions, agents
+		WHERE commands.id=?1
+		AND commands.actionid = actions.id AND commands.agentid = agents.id`, id))
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving command: '%v'", err)
+	}
+	return
+}
+
+// CommandsByActionID retrieves the commands of an action
+func (db *DB) CommandsByActionID(actionid float64) (commands []mig.Command, err error) {
+	return db.commands(`SELECT `+commandColumns+`
+		FROM commands, actions, agents
+		WHERE commands.actionid=actions.id AND commands.agentid=agents.id AND actions.id=?1`,
+		actionid)
+}
+
+// seqScanner reads the position of a command after its commandColumns
+type seqScanner struct {
+	row scanner
+	seq *float64
+}
+
+func (s seqScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.seq)...)
+}
+
+// CommandsFinishedAfter returns up to limit commands of an action that are no longer
+// in flight and were updated after position after, in the order they were updated.
+// SQLite serializes writers, so positions are assigned in commit order by the
+// updates of the commands. 0 starts before any command.
+func (db *DB) CommandsFinishedAfter(actionid float64, after float64, limit int) (commands []migdb.FinishedCommand, err error) {
+	rows, err := db.query(`SELECT `+commandColumns+`, COALESCE(commands.finishseq, 0)
+		FROM commands, actions, agents
+		WHERE commands.actionid=actions.id AND commands.agentid=agents.id AND actions.id=?1
+		AND commands.status!=?2 AND COALESCE(commands.finishseq, 0) > ?3
+		ORDER BY COALESCE(commands.finishseq, 0), commands.id LIMIT ?4`,
+		actionid, mig.StatusSent, after, limit)
+	if err != nil {
+		err = fmt.Errorf("Error while finding finished commands: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fc migdb.FinishedCommand
+		fc.Command, err = scanCommand(seqScanner{rows, &fc.Seq})
+		if err != nil {
+			return
+		}
+		commands = append(commands, fc)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// InsertCommand writes a command into the database
+func (db *DB) InsertCommand(cmd mig.Command, agt mig.Agent) (err error) {
+	jResults, err := json.Marshal(cmd.Results)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal results: '%v'", err)
+	}
+	_, err = db.exec(`INSERT INTO commands
+		(id, actionid, agentid, status, results, starttime, finishtime)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)`, cmd.ID, cmd.Action.ID,
+		agt.ID, cmd.Status, string(jResults), cmd.StartTime, cmd.FinishTime)
+	if err != nil {
+		return fmt.Errorf("Error while inserting command: '%v'", err)
+	}
+	return
+}
+
+// InsertCommands writes an array of commands into the database
+func (db *DB) InsertCommands(cmds []mig.Command) (insertCount int64, err error) {
+	futureDate := time.Date(9998, time.January, 11, 11, 11, 11, 11, time.UTC)
+	var (
+		values []string
+		vals   []interface{}
+	)
+	for i, cmd := range cmds {
+		jRes, err := json.Marshal(cmd.Results)
+		if err != nil {
+			return int64(i), err
+		}
+		n := len(vals)
+		values = append(values, fmt.Sprintf("(?%d, ?%d, ?%d, ?%d, ?%d, ?%d, ?%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		vals = append(vals, cmd.ID, cmd.Action.ID, cmd.Agent.ID, cmd.Status,
+			cmd.StartTime, futureDate, string(jRes))
+	}
+	res, err := db.exec(`INSERT INTO commands
+		(id, actionid, agentid, status, starttime, finishtime, results)
+		VALUES `+strings.Join(values, ", "), vals...)
+	if err != nil {
+		err = fmt.Errorf("Error while inserting commands: '%v'", err)
+		return
+	}
+	insertCount, err = res.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("Error while counting inserted commands: '%v'", err)
+	}
+	return
+}
+
+// UpdateSentCommand updates a command into the database, unless its status is already
+// set to 'success'
+func (db *DB) UpdateSentCommand(cmd mig.Command) (err error) {
+	res, err := db.exec(`UPDATE commands SET status=?1,
+		finishseq=(SELECT COALESCE(MAX(finishseq), 0) + 1 FROM commands)
+		WHERE id=?2 AND status!=?3`,
+		cmd.Status, cmd.ID, mig.StatusSuccess)
+	if err != nil {
+		return fmt.Errorf("Error while updating command: '%v'", err)
+	}
+	ctr, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Error while evaluating query results: '%v'", err)
+	}
+	if ctr != 1 {
+		return fmt.Errorf("Failed to update command status correctly, %d rows affected", ctr)
+	}
+	return
+}
+
+// FinishCommand updates a command into the database unless its status is already set
+// to 'success', so a scheduler doesn't expire a command that has already succeeded
+// and been returned to another scheduler.
+func (db *DB) FinishCommand(cmd mig.Command) (err error) {
+	jResults, err := json.Marshal(cmd.Results)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal results: '%v'", err)
+	}
+	// Filter unicode NULL escape sequences the same way the Postgres backend does,
+	// so the results are stored identically by both backends
+	jResults = bytes.Replace(jResults, []byte("\\u0000"), []byte("NULL"), -1)
+	var tmpres []modules.Result
+	err = json.Unmarshal(jResults, &tmpres)
+	if err != nil {
+		return err
+	}
+	res, err := db.exec(`UPDATE commands SET status=?1, results=?2, finishtime=?3,
+		finishseq=(SELECT COALESCE(MAX(finishseq), 0) + 1 FROM commands)
+		WHERE id=?4 AND status!=?5 AND agentid IN (
+			SELECT id FROM agents
+			WHERE agents.queueloc=?6 AND agents.pid=?7 AND status IN ('online','idle')
+		)`, cmd.Status, string(jResults), cmd.FinishTime, cmd.ID, mig.StatusSuccess,
+		cmd.Agent.QueueLoc, cmd.Agent.PID)
+	if err != nil {
+		return fmt.Errorf("Error while updating command: '%v'", err)
+	}
+	ctr, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Error while evaluating query results: '%v'", err)
+	}
+	if ctr != 1 {
+		return fmt.Errorf("Failed to finish command status correctly, %d rows affected", ctr)
+	}
+	return
+}
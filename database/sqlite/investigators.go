@@ -0,0 +1,258 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/mozilla/mig"
+)
+
+// isUniqueViolation returns true if err is caused by a unique index
+func isUniqueViolation(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && e.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// ActiveInvestigatorsPubKeys returns a slice of investigators keys marked as active
+func (db *DB) ActiveInvestigatorsPubKeys() (keys [][]byte, err error) {
+	rows, err := db.query(`SELECT publickey FROM investigators WHERE status='active'
+		AND publickey IS NOT NULL`)
+	if err != nil {
+		err = fmt.Errorf("Error while listing active investigators keys: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key []byte
+		err = rows.Scan(&key)
+		if err != nil {
+			err = fmt.Errorf("Error while retrieving investigator key: '%v'", err)
+			return
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete active investigators query: '%v'", err)
+	}
+	return
+}
+
+// InvestigatorByID searches the database for an investigator with a given ID
+func (db *DB) InvestigatorByID(iid float64) (inv mig.Investigator, err error) {
+	var perm int64
+	err = db.queryRow(`SELECT id, name, COALESCE(pgpfingerprint, ''),
+		COALESCE(publickey, ''), status, createdat, lastmodified, permissions,
+		CASE WHEN apikey IS NOT NULL THEN 'set' ELSE '' END
+		FROM investigators WHERE id=?1`,
+		iid).Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.PublicKey,
+		&inv.Status, &inv.CreatedAt, &inv.LastModified, &perm, &inv.APIKey)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving investigator: '%v'", err)
+		return
+	}
+	inv.Permissions.FromMask(perm)
+	return
+}
+
+// InvestigatorByFingerprint searches the database for an investigator that
+// has a given fingerprint
+func (db *DB) InvestigatorByFingerprint(fp string) (inv mig.Investigator, err error) {
+	var perm int64
+	err = db.queryRow(`SELECT id, name, pgpfingerprint, publickey, status, createdat,
+		lastmodified, permissions
+		FROM investigators WHERE pgpfingerprint IS NOT NULL AND
+		LOWER(pgpfingerprint)=LOWER(?1)`,
+		fp).Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.PublicKey, &inv.Status,
+		&inv.CreatedAt, &inv.LastModified, &perm)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("InvestigatorByFingerprint: no investigator found for fingerprint '%s'", fp)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while finding investigator: '%v'", err)
+		return
+	}
+	inv.Permissions.FromMask(perm)
+	return
+}
+
+// InvestigatorAPIKeyAuthHelpers returns a set of InvestigatorAPIAuthHelper structs that
+// the API can utilize to authorize requests containing the X-MIGAPIKEY header
+func (db *DB) InvestigatorAPIKeyAuthHelpers() (ret []mig.InvestigatorAPIAuthHelper, err error) {
+	rows, err := db.query(`SELECT id, apikey, apisalt FROM investigators
+		WHERE apikey IS NOT NULL AND apisalt IS NOT NULL AND status='active'`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var nh mig.InvestigatorAPIAuthHelper
+		err = rows.Scan(&nh.ID, &nh.APIKey, &nh.Salt)
+		if err != nil {
+			return
+		}
+		ret = append(ret, nh)
+	}
+	err = rows.Err()
+	return
+}
+
+// InvestigatorByActionID returns the list of investigators that signed a given action
+func (db *DB) InvestigatorByActionID(aid float64) (invs []mig.Investigator, err error) {
+	rows, err := db.query(`SELECT investigators.id, investigators.name, investigators.pgpfingerprint,
+		investigators.status, investigators.createdat, investigators.lastmodified,
+		investigators.permissions
+		FROM investigators, signatures
+		WHERE signatures.actionid=?1
+		AND signatures.investigatorid=investigators.id`, aid)
+	if err != nil {
+		err = fmt.Errorf("Error while finding investigator: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			inv  mig.Investigator
+			perm int64
+		)
+		err = rows.Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.Status, &inv.CreatedAt,
+			&inv.LastModified, &perm)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve investigator data: '%v'", err)
+			return
+		}
+		inv.Permissions.FromMask(perm)
+		invs = append(invs, inv)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// InsertInvestigator creates a new investigator in the database and returns its ID,
+// or an error if the insertion failed, or if the investigator already exists
+func (db *DB) InsertInvestigator(inv mig.Investigator) (iid float64, err error) {
+	var fp, pubkey interface{}
+	if inv.PGPFingerprint != "" {
+		fp = inv.PGPFingerprint
+	}
+	if len(inv.PublicKey) > 0 {
+		pubkey = inv.PublicKey
+	}
+	res, err := db.exec(`INSERT INTO investigators
+		(name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions)
+		VALUES (?1, ?2, ?3, 'active', ?4, ?4, ?5)`,
+		inv.Name, fp, pubkey, now(), inv.Permissions.ToMask())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+		}
+		return iid, fmt.Errorf("Failed to create investigator: '%v'", err)
+	}
+	newid, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to retrieve investigator ID: '%v'", err)
+	}
+	return float64(newid), nil
+}
+
+// InsertSchedulerInvestigator creates a new migscheduler investigator in the database
+// and returns its ID, or an error if the insertion failed, or if the investigator already exists
+func (db *DB) InsertSchedulerInvestigator(inv mig.Investigator) (iid float64, err error) {
+	res, err := db.exec(`INSERT INTO investigators
+		(name, pgpfingerprint, publickey, privatekey, status, createdat, lastmodified)
+		VALUES (?1, ?2, ?3, ?4, 'active', ?5, ?5)`,
+		inv.Name, inv.PGPFingerprint, inv.PublicKey, inv.PrivateKey, now())
+	if err != nil {
+		if isUniqueViolation(err) {
+			return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+		}
+		return iid, fmt.Errorf("Failed to create investigator: '%v'", err)
+	}
+	newid, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to retrieve investigator ID: '%v'", err)
+	}
+	return float64(newid), nil
+}
+
+// UpdateInvestigatorStatus updates the status of an investigator
+func (db *DB) UpdateInvestigatorStatus(inv mig.Investigator) (err error) {
+	if inv.Status != mig.StatusActiveInvestigator && inv.Status != mig.StatusDisabledInvestigator {
+		return fmt.Errorf("Invalid investigator status '%s'", inv.Status)
+	}
+	_, err = db.exec(`UPDATE investigators SET status=?1, lastmodified=?2 WHERE id=?3`,
+		inv.Status, now(), inv.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update investigator: '%v'", err)
+	}
+	return
+}
+
+// UpdateInvestigatorAPIKey enables or disabled a standard API key for an investigator
+func (db *DB) UpdateInvestigatorAPIKey(inv mig.Investigator, key []byte, salt []byte) (err error) {
+	if len(key) == 0 {
+		_, err = db.exec(`UPDATE investigators SET apikey=NULL, apisalt=NULL WHERE id=?1`, inv.ID)
+	} else {
+		_, err = db.exec(`UPDATE investigators SET apikey=?1, apisalt=?2 WHERE id=?3`,
+			key, salt, inv.ID)
+	}
+	return
+}
+
+// UpdateInvestigatorPerms updates the permissions of an investigator, unless the
+// update would remove the last administrator
+func (db *DB) UpdateInvestigatorPerms(inv mig.Investigator) (err error) {
+	mask := inv.Permissions.ToMask()
+	if ((mask & mig.PermInvestigator) == 0) || ((mask & mig.PermInvestigatorUpdate) == 0) {
+		var ts mig.InvestigatorPerms
+		ts.AdminSet()
+		cnt := 0
+		err = db.queryRow(`SELECT COUNT(*) FROM investigators
+			WHERE (permissions & ?1) != 0 AND id != ?2`, ts.ToMask(), inv.ID).Scan(&cnt)
+		if err != nil {
+			return fmt.Errorf("Failed to update investigator: '%v'", err)
+		}
+		if cnt < 1 {
+			return fmt.Errorf("Failed to update investigator: 'will not remove last admin'")
+		}
+	}
+	_, err = db.exec(`UPDATE investigators SET permissions=?1 WHERE id=?2`, mask, inv.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update investigator: '%v'", err)
+	}
+	return
+}
+
+// GetSchedulerPrivKey returns the first active private key found for user migscheduler
+func (db *DB) GetSchedulerPrivKey() (key []byte, err error) {
+	err = db.queryRow(`SELECT privatekey FROM investigators
+		WHERE name ='migscheduler' AND status='active'
+		ORDER BY id ASC LIMIT 1`).Scan(&key)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no private key found for migscheduler")
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving scheduler private key: '%v'", err)
+	}
+	return
+}
+
+// GetSchedulerInvestigator returns the first active scheduler investigator
+func (db *DB) GetSchedulerInvestigator() (inv mig.Investigator, err error) {
+	err = db.queryRow(`SELECT id, name, pgpfingerprint, publickey, status, createdat, lastmodified
+		FROM investigators WHERE name ='migscheduler' AND status='active' ORDER BY id ASC LIMIT 1`,
+	).Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.PublicKey, &inv.Status, &inv.CreatedAt, &inv.LastModified)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving scheduler investigator: '%v'", err)
+	}
+	return
+}
@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mozilla/mig"
+)
+
+// GetLoaderEntryID returns a loader entry ID given a loader key
+func (db *DB) GetLoaderEntryID(key string) (ret float64, err error) {
+	if key == "" {
+		return ret, fmt.Errorf("key cannot be empty")
+	}
+	err = db.queryRow(`SELECT id FROM loaders WHERE
+		loaderkey=?1 AND enabled=1`, key).Scan(&ret)
+	if err != nil {
+		err = fmt.Errorf("No matching loader entry found for key")
+	}
+	return
+}
+
+// GetLoaderAuthDetails returns a loader ID and hashed key given a prefix string
+func (db *DB) GetLoaderAuthDetails(prefix string) (lad mig.LoaderAuthDetails, err error) {
+	err = db.queryRow(`SELECT id, salt, loaderkey FROM loaders WHERE
+		keyprefix=?1 AND enabled=1`, prefix).Scan(&lad.ID, &lad.Salt, &lad.Hash)
+	if err != nil {
+		err = fmt.Errorf("Unable to locate loader from prefix")
+		return
+	}
+	err = lad.Validate()
+	return
+}
+
+// GetLoaderName returns a loader name given an ID
+func (db *DB) GetLoaderName(id float64) (ret string, err error) {
+	err = db.queryRow(`SELECT loadername FROM loaders
+		WHERE id=?1 AND enabled=1`, id).Scan(&ret)
+	if err != nil {
+		err = fmt.Errorf("Unable to locate name for loader ID")
+	}
+	return
+}
+
+// UpdateLoaderEntry updates a given loader entry using supplied agent information
+func (db *DB) UpdateLoaderEntry(lid float64, agt mig.Agent) (err error) {
+	if agt.Name == "" {
+		return fmt.Errorf("will not update loader entry with no agent name")
+	}
+	jEnv, err := json.Marshal(agt.Env)
+	if err != nil {
+		return
+	}
+	jTags, err := json.Marshal(agt.Tags)
+	if err != nil {
+		return
+	}
+	_, err = db.exec(`UPDATE loaders
+		SET name=?1, env=?2, tags=?3, queueloc=NULLIF(?4, ''), lastseen=?5
+		WHERE id=?6`,
+		agt.Name, string(jEnv), string(jTags), agt.QueueLoc, now(), lid)
+	return
+}
+
+// matchLoader reports whether loader lid matches cond, a SQL condition on the
+// loaders table. The condition is evaluated with writes to the database disabled,
+// which replaces the read only role the Postgres backend uses.
+func (db *DB) matchLoader(lid float64, cond string) (match bool, err error) {
+	_, err = db.exec(`PRAGMA query_only=1`)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, e := db.exec(`PRAGMA query_only=0`)
+		if err == nil {
+			err = e
+		}
+	}()
+	var one int
+	err = db.queryRow(fmt.Sprintf(`SELECT 1 FROM loaders WHERE id=?1 AND (%v)`, cond), lid).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompareLoaderExpectEnv validates the environment submitted by a loader matches
+// the expected environment set on its entry, if any; returns an error if not
+func (db *DB) CompareLoaderExpectEnv(lid float64) error {
+	var expectenv sql.NullString
+	rerr := fmt.Errorf("loader environment verification failed")
+	err := db.queryRow(`SELECT expectenv FROM loaders WHERE id=?1`, lid).Scan(&expectenv)
+	if err != nil {
+		return rerr
+	}
+	// If no expected environment is set, we are done here
+	if !expectenv.Valid || expectenv.String == "" {
+		return nil
+	}
+	match, err := db.matchLoader(lid, expectenv.String)
+	if err != nil || !match {
+		return rerr
+	}
+	return nil
+}
+
+// ManifestIDFromLoaderID identifies which manifest is applicable to return to a
+// loader in a manifest request
+func (db *DB) ManifestIDFromLoaderID(lid float64) (ret float64, err error) {
+	type manifest struct {
+		id     float64
+		target string
+	}
+	var manifests []manifest
+	rows, err := db.query(`SELECT id, target FROM manifests
+		WHERE status='active' ORDER BY timestamp DESC, id DESC`)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var m manifest
+		err = rows.Scan(&m.id, &m.target)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		manifests = append(manifests, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for _, m := range manifests {
+		match, err := db.matchLoader(lid, m.target)
+		if err != nil {
+			return 0, err
+		}
+		if match {
+			return m.id, nil
+		}
+	}
+	err = fmt.Errorf("No matching manifest was found for loader entry")
+	return
+}
+
+// AllLoadersFromManifestID returns all the loader entries that match the targeting
+// string for manifest mid
+func (db *DB) AllLoadersFromManifestID(mid float64) (ret []mig.LoaderEntry, err error) {
+	var mtarg string
+	err = db.queryRow(`SELECT target FROM manifests
+		WHERE (status='active' OR status='staged') AND id=?1`, mid).Scan(&mtarg)
+	if err != nil {
+		return
+	}
+	_, err = db.exec(`PRAGMA query_only=1`)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, e := db.exec(`PRAGMA query_only=0`)
+		if err == nil {
+			err = e
+		}
+	}()
+	rows, err := db.query(fmt.Sprintf(`SELECT id, loadername, name, lastseen, enabled
+		FROM loaders WHERE enabled=1 AND (%v)`, mtarg))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var agtname sql.NullString
+		nle := mig.LoaderEntry{}
+		err = rows.Scan(&nle.ID, &nle.Name, &agtname, &nle.LastSeen, &nle.Enabled)
+		if err != nil {
+			return ret, err
+		}
+		nle.AgentName = agtname.String
+		ret = append(ret, nle)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetLoaderFromID returns a loader entry given an ID
+func (db *DB) GetLoaderFromID(lid float64) (ret mig.LoaderEntry, err error) {
+	var name, expectenv sql.NullString
+	err = db.queryRow(`SELECT id, loadername, keyprefix, name, lastseen, enabled,
+		expectenv FROM loaders WHERE id=?1`, lid).Scan(&ret.ID, &ret.Name,
+		&ret.Prefix, &name, &ret.LastSeen, &ret.Enabled, &expectenv)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving loader: '%v'", err)
+		return
+	}
+	ret.AgentName = name.String
+	ret.ExpectEnv = expectenv.String
+	return
+}
+
+// LoaderUpdateStatus enables or disables a loader entry in the database
+func (db *DB) LoaderUpdateStatus(lid float64, status bool) (err error) {
+	_, err = db.exec(`UPDATE loaders SET enabled=?1 WHERE id=?2`, status, lid)
+	return
+}
+
+// LoaderUpdateExpect updates loader expect fields
+func (db *DB) LoaderUpdateExpect(lid float64, eenv string) (err error) {
+	var eset sql.NullString
+	if eenv != "" {
+		eset.String = eenv
+		eset.Valid = true
+	}
+	_, err = db.exec(`UPDATE loaders SET expectenv=?1 WHERE id=?2`, eset, lid)
+	return
+}
+
+// LoaderUpdateKey changes a loader key, hashkey should be the hashed version of
+// the key component
+func (db *DB) LoaderUpdateKey(lid float64, hashkey []byte, salt []byte) (err error) {
+	_, err = db.exec(`UPDATE loaders SET loaderkey=?1, salt=?2 WHERE id=?3`,
+		hashkey, salt, lid)
+	return
+}
+
+// LoaderAdd adds a new loader entry to the database; the hashed loader key should
+// be provided as hashkey
+func (db *DB) LoaderAdd(le mig.LoaderEntry, hashkey []byte, salt []byte) (newle mig.LoaderEntry, err error) {
+	var eval sql.NullString
+	if le.ExpectEnv != "" {
+		eval.String = le.ExpectEnv
+		eval.Valid = true
+	}
+	res, err := db.exec(`INSERT INTO loaders
+		(loadername, keyprefix, loaderkey, salt, lastseen, enabled, expectenv)
+		VALUES (?1, ?2, ?3, ?4, ?5, 0, ?6)`,
+		le.Name, le.Prefix, hashkey, salt, now(), eval)
+	if err != nil {
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return
+	}
+	le.ID = float64(id)
+	newle = le
+	return
+}
@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"fmt"
+
+	"github.com/mozilla/mig"
+)
+
+// ManifestAdd adds a new manifest record to the database
+func (db *DB) ManifestAdd(mr mig.ManifestRecord) (err error) {
+	_, err = db.exec(`INSERT INTO manifests (name, content, timestamp, status, target)
+		VALUES (?1, ?2, ?3, 'staged', ?4)`, mr.Name, mr.Content, now(), mr.Target)
+	return
+}
+
+// ManifestAddSignature adds a signature to the database for an existing manifest
+func (db *DB) ManifestAddSignature(mid float64, sig string, invid float64, reqsig int) (err error) {
+	res, err := db.exec(`INSERT INTO manifestsig
+		(manifestid, pgpsignature, investigatorid)
+		SELECT ?1, ?2, ?3
+		WHERE EXISTS (SELECT id FROM manifests
+		WHERE id=?1 AND status!='disabled')`, mid, sig, invid)
+	if err != nil {
+		return
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if ra != 1 {
+		return fmt.Errorf("Manifest signing operation failed")
+	}
+	return db.ManifestUpdateStatus(mid, reqsig)
+}
+
+// ManifestDisable disables a manifest record
+func (db *DB) ManifestDisable(mid float64) (err error) {
+	_, err = db.exec(`UPDATE manifests SET status='disabled' WHERE id=?1`, mid)
+	return
+}
+
+// ManifestUpdateStatus updates the status of a manifest based on the number of
+// signatures it has, reqsig indicates the number of signatures a manifest must
+// have to be considered active
+func (db *DB) ManifestUpdateStatus(mid float64, reqsig int) (err error) {
+	var cnt int
+	err = db.queryRow(`SELECT COUNT(*) FROM manifestsig WHERE manifestid=?1`, mid).Scan(&cnt)
+	if err != nil {
+		return err
+	}
+	status := "staged"
+	if cnt >= reqsig {
+		status = "active"
+	}
+	_, err = db.exec(`UPDATE manifests SET status=?1 WHERE id=?2 AND status!='disabled'`,
+		status, mid)
+	return
+}
+
+// ManifestClearSignatures clears existing signatures for a manifest record
+func (db *DB) ManifestClearSignatures(mid float64) (err error) {
+	_, err = db.exec(`DELETE FROM manifestsig WHERE manifestid=?1`, mid)
+	return err
+}
+
+// GetManifestFromID returns the entire contents of manifest ID mid from the database
+func (db *DB) GetManifestFromID(mid float64) (ret mig.ManifestRecord, err error) {
+	err = db.queryRow(`SELECT id, name, content, timestamp, status, target
+		FROM manifests WHERE id=?1`, mid).Scan(&ret.ID, &ret.Name, &ret.Content,
+		&ret.Timestamp, &ret.Status, &ret.Target)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving manifest: '%v'", err)
+		return
+	}
+	ret.Signatures, err = db.strings(`SELECT pgpsignature FROM manifestsig
+		WHERE manifestid=?1`, mid)
+	return
+}
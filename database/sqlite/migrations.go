@@ -0,0 +1,104 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"fmt"
+
+	migdb "github.com/mozilla/mig/database"
+)
+
+// migrations lists the changes to the SQLite schema. They are versioned
+// independently of the migrations of the Postgres schema.
+var migrations = []migdb.Migration{
+	{Version: 1, Description: "initial schema", SQL: schema},
+	{Version: 2, Description: "command update sequence", SQL: `
+ALTER TABLE commands ADD COLUMN finishseq integer;
+CREATE INDEX IF NOT EXISTS commands_actionid_finishseq ON commands(actionid, finishseq);
+`},
+}
+
+// LatestSchemaVersion returns the version of the last migration of the SQLite schema
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the schema of the database, or 0 if the
+// database is empty
+func (db *DB) SchemaVersion() (version int, err error) {
+	var tables int
+	err = db.queryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type='table' AND name='schema_version'`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return
+	}
+	err = db.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		err = fmt.Errorf("Failed to look up schema version: '%v'", err)
+	}
+	return
+}
+
+// CheckSchemaVersion returns an error if the schema of the database is not at
+// the version of the last migration
+func (db *DB) CheckSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	return migdb.CompareSchemaVersion(version, LatestSchemaVersion())
+}
+
+// Migrate applies the migrations the database is missing, each in its own
+// transaction, and returns the migrations that were applied
+func (db *DB) Migrate() (applied []migdb.Migration, err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+	if version > LatestSchemaVersion() {
+		return nil, migdb.CompareSchemaVersion(version, LatestSchemaVersion())
+	}
+	_, err = db.exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version     integer NOT NULL PRIMARY KEY,
+		description text NOT NULL,
+		appliedat   timestamp NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create schema_version table: '%v'", err)
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		err = db.applyMigration(m)
+		if err != nil {
+			return
+		}
+		applied = append(applied, m)
+	}
+	return
+}
+
+func (db *DB) applyMigration(m migdb.Migration) (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(m.SQL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to apply migration %d (%s): '%v'", m.Version, m.Description, err)
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description, appliedat)
+		VALUES (?1, ?2, ?3)`, m.Version, m.Description, now())
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Failed to record migration %d: '%v'", m.Version, err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,215 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/mozilla/mig"
+)
+
+// inList returns a list of numbered placeholders for vals, starting after
+// offset, and the values as arguments. SQLite has no array type, so lists are
+// expanded into IN conditions.
+func inList(offset int, vals []float64) (string, []interface{}) {
+	if len(vals) == 0 {
+		return "NULL", nil
+	}
+	var (
+		ph   []string
+		args []interface{}
+	)
+	for i, v := range vals {
+		ph = append(ph, fmt.Sprintf("?%d", offset+i+1))
+		args = append(args, v)
+	}
+	return strings.Join(ph, ", "), args
+}
+
+// ModuleRequirementsByAgent returns the module requirements set on an agent
+func (db *DB) ModuleRequirementsByAgent(agentid float64) (reqs []mig.ModuleRequirement, err error) {
+	return db.moduleRequirements(`agtmodreq.agentid=?1`, agentid)
+}
+
+// ModuleRequirementsByAgents returns the module requirements set on a list of agents
+func (db *DB) ModuleRequirementsByAgents(agentids []float64) (reqs []mig.ModuleRequirement, err error) {
+	list, args := inList(0, agentids)
+	return db.moduleRequirements(`agtmodreq.agentid IN (`+list+`)`, args...)
+}
+
+func (db *DB) moduleRequirements(cond string, args ...interface{}) (reqs []mig.ModuleRequirement, err error) {
+	rows, err := db.query(`SELECT agtmodreq.agentid, modules.name, agtmodreq.minimumweight
+		FROM agtmodreq INNER JOIN modules ON (agtmodreq.moduleid=modules.id)
+		WHERE `+cond+` ORDER BY agtmodreq.agentid, modules.name`, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving module requirements: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var req mig.ModuleRequirement
+		err = rows.Scan(&req.AgentID, &req.Module, &req.MinimumWeight)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve module requirement: '%v'", err)
+			return
+		}
+		reqs = append(reqs, req)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// ModulePermissionsByAgent returns the module permissions investigators have on an agent
+func (db *DB) ModulePermissionsByAgent(agentid float64) (perms []mig.ModulePermission, err error) {
+	return db.modulePermissions(`invagtmodperm.agentid=?1`, agentid)
+}
+
+// ModulePermissionsByInvestigator returns the module permissions an investigator has on agents
+func (db *DB) ModulePermissionsByInvestigator(iid float64) (perms []mig.ModulePermission, err error) {
+	return db.modulePermissions(`invagtmodperm.investigatorid=?1`, iid)
+}
+
+// ModulePermissionsByAgentsAndInvestigators returns the module permissions a list of
+// investigators have on a list of agents
+func (db *DB) ModulePermissionsByAgentsAndInvestigators(agentids, iids []float64) (perms []mig.ModulePermission, err error) {
+	agents, args := inList(0, agentids)
+	invs, iargs := inList(len(args), iids)
+	return db.modulePermissions(`invagtmodperm.agentid IN (`+agents+`)
+		AND invagtmodperm.investigatorid IN (`+invs+`)`, append(args, iargs...)...)
+}
+
+func (db *DB) modulePermissions(cond string, args ...interface{}) (perms []mig.ModulePermission, err error) {
+	rows, err := db.query(`SELECT invagtmodperm.investigatorid, invagtmodperm.agentid,
+		modules.name, invagtmodperm.weight
+		FROM invagtmodperm INNER JOIN modules ON (invagtmodperm.moduleid=modules.id)
+		WHERE `+cond+` ORDER BY invagtmodperm.agentid, invagtmodperm.investigatorid, modules.name`, args...)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving module permissions: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var perm mig.ModulePermission
+		err = rows.Scan(&perm.InvestigatorID, &perm.AgentID, &perm.Module, &perm.Weight)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve module permission: '%v'", err)
+			return
+		}
+		perms = append(perms, perm)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SetModuleRequirement stores the minimum weight required to run a module on an agent,
+// replacing any existing requirement. A minimum weight of 0 removes the requirement.
+func (db *DB) SetModuleRequirement(req mig.ModuleRequirement) (err error) {
+	if req.MinimumWeight < 0 {
+		return fmt.Errorf("Invalid minimum weight %d", req.MinimumWeight)
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	modid, err := moduleID(tx, req.Module)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	if req.MinimumWeight == 0 {
+		_, err = tx.Exec(`DELETE FROM agtmodreq WHERE moduleid=?1 AND agentid=?2`,
+			modid, req.AgentID)
+	} else {
+		_, err = tx.Exec(`INSERT INTO agtmodreq (moduleid, agentid, minimumweight)
+			VALUES (?1, ?2, ?3) ON CONFLICT (moduleid, agentid)
+			DO UPDATE SET minimumweight=excluded.minimumweight`,
+			modid, req.AgentID, req.MinimumWeight)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Failed to store module requirement: '%v'", err)
+	}
+	return tx.Commit()
+}
+
+// SetModulePermission stores the weight of an investigator for a module on an agent,
+// replacing any existing permission. A weight of 0 removes the permission.
+func (db *DB) SetModulePermission(perm mig.ModulePermission) (err error) {
+	if perm.Weight < 0 {
+		return fmt.Errorf("Invalid weight %d", perm.Weight)
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	modid, err := moduleID(tx, perm.Module)
+	if err != nil {
+		_ = tx.Rollback()
+		return
+	}
+	if perm.Weight == 0 {
+		_, err = tx.Exec(`DELETE FROM invagtmodperm
+			WHERE investigatorid=?1 AND agentid=?2 AND moduleid=?3`,
+			perm.InvestigatorID, perm.AgentID, modid)
+	} else {
+		_, err = tx.Exec(`INSERT INTO invagtmodperm (investigatorid, agentid, moduleid, weight)
+			VALUES (?1, ?2, ?3, ?4) ON CONFLICT (investigatorid, agentid, moduleid)
+			DO UPDATE SET weight=excluded.weight`,
+			perm.InvestigatorID, perm.AgentID, modid, perm.Weight)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("Failed to store module permission: '%v'", err)
+	}
+	return tx.Commit()
+}
+
+// moduleID returns the ID of a module in the modules table, inserting the module
+// if it does not exist yet
+func moduleID(tx *sql.Tx, name string) (id float64, err error) {
+	if name == "" {
+		return 0, fmt.Errorf("Module name cannot be empty")
+	}
+	err = tx.QueryRow(`SELECT id FROM modules WHERE name=?1`, name).Scan(&id)
+	if err == nil {
+		return
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("Error while retrieving module: '%v'", err)
+	}
+	err = tx.QueryRow(`INSERT INTO modules (id, name)
+		SELECT COALESCE(MAX(id), 0) + 1, ?1 FROM modules
+		RETURNING id`, name).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to insert module: '%v'", err)
+	}
+	return
+}
+
+// copyModulePermissions copies the module requirements and permissions of agent
+// oldid to agent newid
+func copyModulePermissions(tx *sql.Tx, oldid, newid float64) (err error) {
+	_, err = tx.Exec(`INSERT INTO agtmodreq (moduleid, agentid, minimumweight)
+		SELECT moduleid, ?2, minimumweight FROM agtmodreq WHERE agentid=?1`,
+		oldid, newid)
+	if err != nil {
+		return fmt.Errorf("Failed to copy module requirements: '%v'", err)
+	}
+	_, err = tx.Exec(`INSERT INTO invagtmodperm (investigatorid, agentid, moduleid, weight)
+		SELECT investigatorid, ?2, moduleid, weight FROM invagtmodperm WHERE agentid=?1`,
+		oldid, newid)
+	if err != nil {
+		return fmt.Errorf("Failed to copy module permissions: '%v'", err)
+	}
+	return
+}
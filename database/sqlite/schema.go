@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+// schema is the initial SQLite schema, which follows version 5 of the Postgres
+// schema in database/schema.sql. JSON documents are stored as text, and the
+// sequences of Postgres are replaced by autoincremented keys. Columns declared
+// as timestamp are converted to time.Time by the driver.
+const schema = `
+CREATE TABLE IF NOT EXISTS actions (
+	id              numeric NOT NULL PRIMARY KEY,
+	name            text NOT NULL,
+	target          text NOT NULL,
+	description     text,
+	threat          text,
+	operations      text,
+	validfrom       timestamp NOT NULL,
+	expireafter     timestamp NOT NULL,
+	starttime       timestamp,
+	finishtime      timestamp,
+	lastupdatetime  timestamp,
+	status          text,
+	syntaxversion   integer,
+	pgpsignatures   text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS actions_status_idx ON actions(status);
+
+CREATE TABLE IF NOT EXISTS agents (
+	id                  numeric NOT NULL PRIMARY KEY,
+	name                text NOT NULL,
+	queueloc            text NOT NULL,
+	mode                text NOT NULL,
+	version             text NOT NULL,
+	pid                 integer NOT NULL,
+	starttime           timestamp NOT NULL,
+	destructiontime     timestamp,
+	heartbeattime       timestamp NOT NULL,
+	refreshtime         timestamp NOT NULL,
+	status              text,
+	environment         text,
+	tags                text,
+	loadername          text,
+	aclhash             text
+);
+CREATE INDEX IF NOT EXISTS agents_heartbeattime_idx ON agents(heartbeattime DESC);
+CREATE INDEX IF NOT EXISTS agents_starttime_idx ON agents(starttime DESC);
+CREATE INDEX IF NOT EXISTS agents_queueloc_pid_idx ON agents(queueloc, pid);
+CREATE INDEX IF NOT EXISTS agents_status_idx ON agents(status);
+
+CREATE TABLE IF NOT EXISTS agents_stats (
+	timestamp                   timestamp NOT NULL,
+	online_agents               numeric,
+	online_agents_by_version    text,
+	online_endpoints            numeric,
+	idle_agents                 numeric,
+	idle_agents_by_version      text,
+	idle_endpoints              numeric,
+	new_endpoints               numeric,
+	multi_agents_endpoints      numeric,
+	disappeared_endpoints       numeric,
+	flapping_endpoints          numeric
+);
+
+CREATE TABLE IF NOT EXISTS modules (
+	id      numeric NOT NULL PRIMARY KEY,
+	name    text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS agtmodreq (
+	moduleid        numeric NOT NULL REFERENCES modules(id),
+	agentid         numeric NOT NULL,
+	minimumweight   integer NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS agtmodreq_moduleid_agentid_idx ON agtmodreq(moduleid, agentid);
+CREATE INDEX IF NOT EXISTS agtmodreq_agentid_idx ON agtmodreq(agentid);
+
+CREATE TABLE IF NOT EXISTS commands (
+	id          numeric NOT NULL PRIMARY KEY,
+	actionid    numeric NOT NULL REFERENCES actions(id),
+	agentid     numeric NOT NULL REFERENCES agents(id),
+	status      text NOT NULL,
+	results     text,
+	starttime   timestamp NOT NULL,
+	finishtime  timestamp
+);
+CREATE INDEX IF NOT EXISTS commands_agentid ON commands(agentid DESC);
+CREATE INDEX IF NOT EXISTS commands_actionid ON commands(actionid DESC);
+
+CREATE TABLE IF NOT EXISTS investigators (
+	id              integer PRIMARY KEY AUTOINCREMENT,
+	name            text NOT NULL,
+	pgpfingerprint  text,
+	publickey       blob,
+	privatekey      blob,
+	status          text NOT NULL,
+	createdat       timestamp NOT NULL,
+	lastmodified    timestamp NOT NULL,
+	permissions     integer NOT NULL DEFAULT 0,
+	apikey          blob,
+	apisalt         blob
+);
+CREATE UNIQUE INDEX IF NOT EXISTS investigators_pgpfingerprint_idx ON investigators(pgpfingerprint);
+
+CREATE TABLE IF NOT EXISTS invagtmodperm (
+	investigatorid  numeric NOT NULL REFERENCES investigators(id),
+	agentid         numeric NOT NULL REFERENCES agents(id),
+	moduleid        numeric NOT NULL REFERENCES modules(id),
+	weight          integer NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS invagtmodperm_investigatorid_agentid_moduleid_idx
+	ON invagtmodperm(investigatorid, agentid, moduleid);
+CREATE INDEX IF NOT EXISTS invagtmodperm_agentid_idx ON invagtmodperm(agentid);
+CREATE INDEX IF NOT EXISTS invagtmodperm_investigatorid_idx ON invagtmodperm(investigatorid);
+
+CREATE TABLE IF NOT EXISTS manifests (
+	id        integer PRIMARY KEY AUTOINCREMENT,
+	name      text NOT NULL,
+	content   text NOT NULL,
+	timestamp timestamp NOT NULL,
+	status    text NOT NULL,
+	target    text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS manifestsig (
+	manifestid     numeric NOT NULL REFERENCES manifests(id),
+	investigatorid numeric NOT NULL,
+	pgpsignature   text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS manifestsig_manifestid_investigatorid_idx ON manifestsig(manifestid, investigatorid);
+
+CREATE TABLE IF NOT EXISTS aclbundles (
+	id        integer PRIMARY KEY AUTOINCREMENT,
+	name      text NOT NULL,
+	acl       text NOT NULL,
+	timestamp timestamp NOT NULL,
+	status    text NOT NULL,
+	target    text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS aclbundles_status_idx ON aclbundles(status);
+
+CREATE TABLE IF NOT EXISTS aclbundlesig (
+	aclbundleid    numeric NOT NULL REFERENCES aclbundles(id),
+	investigatorid numeric NOT NULL,
+	pgpsignature   text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS aclbundlesig_aclbundleid_investigatorid_idx ON aclbundlesig(aclbundleid, investigatorid);
+
+CREATE TABLE IF NOT EXISTS loaders (
+	id            integer PRIMARY KEY AUTOINCREMENT,
+	loadername    text NOT NULL,
+	keyprefix     text NOT NULL,
+	loaderkey     blob NOT NULL,
+	salt          blob NOT NULL,
+	name          text,
+	env           text,
+	tags          text,
+	lastseen      timestamp NOT NULL,
+	enabled       boolean NOT NULL DEFAULT false,
+	expectenv     text,
+	queueloc      text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS loaders_loadername_idx ON loaders(loadername);
+CREATE UNIQUE INDEX IF NOT EXISTS loaders_loaderkey_idx ON loaders(loaderkey);
+CREATE UNIQUE INDEX IF NOT EXISTS loaders_keyprefix_idx ON loaders(keyprefix);
+CREATE UNIQUE INDEX IF NOT EXISTS loaders_queueloc_idx ON loaders(queueloc);
+
+CREATE TABLE IF NOT EXISTS signatures (
+	actionid        numeric NOT NULL REFERENCES actions(id),
+	investigatorid  numeric NOT NULL REFERENCES investigators(id),
+	pgpsignature    text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS signatures_actionid_investigatorid_idx ON signatures(actionid, investigatorid);
+CREATE INDEX IF NOT EXISTS signatures_investigatorid_idx ON signatures(investigatorid);
+
+CREATE TABLE IF NOT EXISTS agentmessages (
+	id          integer PRIMARY KEY AUTOINCREMENT,
+	queue       text NOT NULL,
+	type        text NOT NULL,
+	body        blob NOT NULL,
+	createdat   timestamp NOT NULL,
+	expireafter timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS agentmessages_queue_idx ON agentmessages(queue);
+CREATE INDEX IF NOT EXISTS agentmessages_expireafter_idx ON agentmessages(expireafter);
+`
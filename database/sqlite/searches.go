@@ -0,0 +1,411 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/search"
+)
+
+// maxID is the largest ID that can be represented exactly by a float64
+const maxID float64 = 9007199254740991 // 2^53-1
+
+// idRange returns the range of IDs matched by a search parameter, which is
+// either "∞" to match all IDs or a single ID
+func idRange(param string) (min, max float64, err error) {
+	if param == "∞" {
+		return 0, maxID, nil
+	}
+	min, err = strconv.ParseFloat(param, 64)
+	return min, min, err
+}
+
+// filter builds the WHERE clause of a search query. Its conditions use ? placeholders
+// and are joined with AND. SQLite's LIKE is case insensitive, like ILIKE in Postgres.
+type filter struct {
+	conds []string
+	vals  []interface{}
+}
+
+func (f *filter) add(cond string, vals ...interface{}) {
+	f.conds = append(f.conds, cond)
+	f.vals = append(f.vals, vals...)
+}
+
+// like adds a LIKE condition on column, unless pattern matches everything
+func (f *filter) like(column, pattern string) bool {
+	if pattern == "%" {
+		return false
+	}
+	f.add(column+` LIKE ?`, pattern)
+	return true
+}
+
+// ids adds a condition on an ID column, unless param matches all IDs
+func (f *filter) ids(column, param string) (bool, error) {
+	if param == "∞" {
+		return false, nil
+	}
+	min, max, err := idRange(param)
+	if err != nil {
+		return false, err
+	}
+	f.add(column+` >= ? AND `+column+` <= ?`, min, max)
+	return true, nil
+}
+
+// window adds conditions on a time column for the Before and After parameters,
+// when they are narrower than the default search window
+func (f *filter) window(column string, p search.Parameters) {
+	if p.Before.Before(time.Now().Add(search.DefaultWindow - time.Hour)) {
+		f.add(column+` <= ?`, p.Before)
+	}
+	if p.After.After(time.Now().Add(-(search.DefaultWindow - time.Hour))) {
+		f.add(column+` >= ?`, p.After)
+	}
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return "1"
+	}
+	return strings.Join(f.conds, " AND ")
+}
+
+// SearchCommands returns an array of commands that match search parameters
+func (db *DB) SearchCommands(p search.Parameters, doFoundAnything bool) (commands []mig.Command, err error) {
+	var f filter
+	f.window("commands.starttime", p)
+	if _, err = f.ids("commands.id", p.CommandID); err != nil {
+		return
+	}
+	f.like("commands.status", p.Status)
+	if _, err = f.ids("actions.id", p.ActionID); err != nil {
+		return
+	}
+	f.like("actions.name", p.ActionName)
+	if _, err = f.ids("investigators.id", p.InvestigatorID); err != nil {
+		return
+	}
+	f.like("investigators.name", p.InvestigatorName)
+	if _, err = f.ids("agents.id", p.AgentID); err != nil {
+		return
+	}
+	f.like("agents.name", p.AgentName)
+	f.like("agents.version", p.AgentVersion)
+	if doFoundAnything {
+		f.add(`commands.status = ? AND EXISTS (
+			SELECT 1 FROM json_each(commands.results) AS r
+			WHERE json_extract(r.value, '$.foundanything') = ?)`,
+			mig.StatusSuccess, p.FoundAnything)
+	}
+	f.like("json_extract(actions.threat, '$.family')", p.ThreatFamily)
+	commands, err = db.commands(`SELECT `+commandColumns+`
+		FROM commands
+		INNER JOIN actions ON ( commands.actionid = actions.id )
+		INNER JOIN signatures ON ( actions.id = signatures.actionid )
+		INNER JOIN investigators ON ( signatures.investigatorid = investigators.id )
+		INNER JOIN agents ON ( commands.agentid = agents.id )
+		WHERE `+f.where()+`
+		GROUP BY commands.id, actions.id, agents.id
+		ORDER BY commands.starttime DESC LIMIT ? OFFSET ?`,
+		append(f.vals, int64(p.Limit), int64(p.Offset))...)
+	if err != nil {
+		return
+	}
+	for i := range commands {
+		commands[i].Action.Counters, err = db.GetActionCounters(commands[i].Action.ID)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve action counters: '%v'", err)
+			return
+		}
+		commands[i].Action.Investigators, err = db.InvestigatorByActionID(commands[i].Action.ID)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve action investigators: '%v'", err)
+			return
+		}
+	}
+	return
+}
+
+// SearchActions returns an array of actions that match search parameters
+func (db *DB) SearchActions(p search.Parameters) (actions []mig.Action, err error) {
+	var (
+		f                                        filter
+		joinAgent, joinInvestigator, joinCommand bool
+		ok                                       bool
+	)
+	f.window("actions.validfrom", p)
+	f.like("actions.status", p.Status)
+	if _, err = f.ids("actions.id", p.ActionID); err != nil {
+		return
+	}
+	f.like("actions.name", p.ActionName)
+	if ok, err = f.ids("investigators.id", p.InvestigatorID); err != nil {
+		return
+	}
+	joinInvestigator = ok
+	if f.like("investigators.name", p.InvestigatorName) {
+		joinInvestigator = true
+	}
+	if ok, err = f.ids("agents.id", p.AgentID); err != nil {
+		return
+	}
+	joinAgent = ok
+	if f.like("agents.name", p.AgentName) {
+		joinAgent = true
+	}
+	if f.like("agents.version", p.AgentVersion) {
+		joinAgent = true
+	}
+	if ok, err = f.ids("commands.id", p.CommandID); err != nil {
+		return
+	}
+	joinCommand = ok || joinAgent
+	f.like("json_extract(actions.threat, '$.family')", p.ThreatFamily)
+	join := ""
+	if joinCommand {
+		join += " INNER JOIN commands ON ( commands.actionid = actions.id )"
+	}
+	if joinAgent {
+		join += " INNER JOIN agents ON ( commands.agentid = agents.id )"
+	}
+	if joinInvestigator {
+		join += ` INNER JOIN signatures ON ( actions.id = signatures.actionid )
+			INNER JOIN investigators ON ( signatures.investigatorid = investigators.id )`
+	}
+	actions, err = db.actions(`SELECT `+actionColumns+` FROM actions`+join+`
+		WHERE `+f.where()+` GROUP BY actions.id
+		ORDER BY actions.validfrom DESC LIMIT ? OFFSET ?`,
+		append(f.vals, int64(p.Limit), int64(p.Offset))...)
+	if err != nil {
+		return
+	}
+	for i := range actions {
+		actions[i].Counters, err = db.GetActionCounters(actions[i].ID)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve action counters: '%v'", err)
+			return
+		}
+		actions[i].Investigators, err = db.InvestigatorByActionID(actions[i].ID)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve action investigators: '%v'", err)
+			return
+		}
+	}
+	return
+}
+
+// SearchAgents returns an array of agents that match search parameters
+func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error) {
+	var (
+		f                                         filter
+		joinAction, joinInvestigator, joinCommand bool
+		ok                                        bool
+	)
+	f.window("agents.heartbeattime", p)
+	if _, err = f.ids("agents.id", p.AgentID); err != nil {
+		return
+	}
+	f.like("agents.name", p.AgentName)
+	f.like("agents.version", p.AgentVersion)
+	f.like("agents.status", p.Status)
+	if ok, err = f.ids("actions.id", p.ActionID); err != nil {
+		return
+	}
+	joinAction = ok
+	if f.like("actions.name", p.ActionName) {
+		joinAction = true
+	}
+	if f.like("json_extract(actions.threat, '$.family')", p.ThreatFamily) {
+		joinAction = true
+	}
+	if ok, err = f.ids("investigators.id", p.InvestigatorID); err != nil {
+		return
+	}
+	joinInvestigator = ok
+	if f.like("investigators.name", p.InvestigatorName) {
+		joinInvestigator = true
+	}
+	if ok, err = f.ids("commands.id", p.CommandID); err != nil {
+		return
+	}
+	joinAction = joinAction || joinInvestigator
+	joinCommand = ok || joinAction
+	join := ""
+	if joinCommand {
+		join += " INNER JOIN commands ON ( commands.agentid = agents.id )"
+	}
+	if joinAction {
+		join += " INNER JOIN actions ON ( commands.actionid = actions.id )"
+	}
+	if joinInvestigator {
+		join += ` INNER JOIN signatures ON ( actions.id = signatures.actionid )
+			INNER JOIN investigators ON ( signatures.investigatorid = investigators.id )`
+	}
+	return db.agents(`SELECT `+agentColumns+` FROM agents`+join+`
+		WHERE `+f.where()+` GROUP BY agents.id
+		ORDER BY agents.heartbeattime DESC LIMIT ? OFFSET ?`,
+		append(f.vals, int64(p.Limit), int64(p.Offset))...)
+}
+
+// SearchInvestigators returns an array of investigators that match search parameters
+func (db *DB) SearchInvestigators(p search.Parameters) (investigators []mig.Investigator, err error) {
+	var (
+		f                                  filter
+		joinAction, joinAgent, joinCommand bool
+		ok                                 bool
+	)
+	f.window("investigators.lastmodified", p)
+	if _, err = f.ids("investigators.id", p.InvestigatorID); err != nil {
+		return
+	}
+	f.like("investigators.name", p.InvestigatorName)
+	f.like("investigators.status", p.Status)
+	if ok, err = f.ids("actions.id", p.ActionID); err != nil {
+		return
+	}
+	joinAction = ok
+	if f.like("actions.name", p.ActionName) {
+		joinAction = true
+	}
+	if f.like("json_extract(actions.threat, '$.family')", p.ThreatFamily) {
+		joinAction = true
+	}
+	if ok, err = f.ids("commands.id", p.CommandID); err != nil {
+		return
+	}
+	joinCommand = ok
+	if ok, err = f.ids("agents.id", p.AgentID); err != nil {
+		return
+	}
+	joinAgent = ok
+	if f.like("agents.name", p.AgentName) {
+		joinAgent = true
+	}
+	if f.like("agents.version", p.AgentVersion) {
+		joinAgent = true
+	}
+	joinCommand = joinCommand || joinAgent
+	joinAction = joinAction || joinCommand
+	join := ""
+	if joinAction {
+		join += ` INNER JOIN signatures ON ( signatures.investigatorid = investigators.id )
+			INNER JOIN actions ON ( actions.id = signatures.actionid )`
+	}
+	if joinCommand {
+		join += " INNER JOIN commands ON ( commands.actionid = actions.id )"
+	}
+	if joinAgent {
+		join += " INNER JOIN agents ON ( commands.agentid = agents.id )"
+	}
+	rows, err := db.query(`SELECT investigators.id, investigators.name,
+		COALESCE(investigators.pgpfingerprint, ''), investigators.status,
+		investigators.createdat, investigators.lastmodified, investigators.permissions
+		FROM investigators`+join+`
+		WHERE `+f.where()+` GROUP BY investigators.id
+		ORDER BY investigators.id ASC LIMIT ? OFFSET ?`,
+		append(f.vals, int64(p.Limit), int64(p.Offset))...)
+	if err != nil {
+		err = fmt.Errorf("Error while finding investigators: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			inv  mig.Investigator
+			perm int64
+		)
+		err = rows.Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.Status,
+			&inv.CreatedAt, &inv.LastModified, &perm)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve investigator data: '%v'", err)
+			return
+		}
+		inv.Permissions.FromMask(perm)
+		investigators = append(investigators, inv)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SearchManifests returns an array of manifest records that match search parameters
+func (db *DB) SearchManifests(p search.Parameters) (mrecords []mig.ManifestRecord, err error) {
+	var f filter
+	f.window("manifests.timestamp", p)
+	f.like("manifests.name", p.ManifestName)
+	if _, err = f.ids("manifests.id", p.ManifestID); err != nil {
+		return
+	}
+	f.like("manifests.status", p.Status)
+	rows, err := db.query(`SELECT id, name, status, target, timestamp FROM manifests
+		WHERE `+f.where()+` ORDER BY timestamp DESC`, f.vals...)
+	if err != nil {
+		err = fmt.Errorf("Error while finding manifests: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mr mig.ManifestRecord
+		err = rows.Scan(&mr.ID, &mr.Name, &mr.Status, &mr.Target, &mr.Timestamp)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve manifest data: '%v'", err)
+			return
+		}
+		mrecords = append(mrecords, mr)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SearchLoaders returns an array of loader entries that match search parameters
+func (db *DB) SearchLoaders(p search.Parameters) (lrecords []mig.LoaderEntry, err error) {
+	var f filter
+	f.window("loaders.lastseen", p)
+	f.like("loaders.loadername", p.LoaderName)
+	f.like("loaders.name", p.AgentName)
+	if _, err = f.ids("loaders.id", p.LoaderID); err != nil {
+		return
+	}
+	rows, err := db.query(`SELECT id, loadername, name, lastseen, enabled FROM loaders
+		WHERE `+f.where()+` ORDER BY loadername`, f.vals...)
+	if err != nil {
+		err = fmt.Errorf("Error while finding loaders: '%v'", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			le          mig.LoaderEntry
+			agtnameNull sql.NullString
+		)
+		err = rows.Scan(&le.ID, &le.Name, &agtnameNull, &le.LastSeen, &le.Enabled)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve loader data: '%v'", err)
+			return
+		}
+		le.AgentName = "unset"
+		if agtnameNull.Valid {
+			le.AgentName = agtnameNull.String
+		}
+		lrecords = append(lrecords, le)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
@@ -0,0 +1,106 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+// Package sqlite implements the database.Store interface on top of a SQLite
+// database, for small deployments and tests where running Postgres is not
+// practical. The API and the scheduler can share the same database file.
+//
+// The SQLite backend differs from the Postgres one in a few ways: action
+// targets are evaluated in Go with target.Match instead of being compiled to
+// SQL, and raw SQL targets are not supported. Manifest targets and the expected
+// environment of loaders are SQL conditions on the loaders table, and must use
+// the JSON functions of SQLite, such as json_extract(env, '$.os')='linux'.
+// Database roles do not exist, so the API and the scheduler have the same
+// access to the database.
+//
+// The package is only built with the sqlite build tag. The go-sqlite3 driver
+// requires cgo and Go 1.18 or later, which the other components of MIG don't.
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	migdb "github.com/mozilla/mig/database"
+)
+
+// DB is a SQLite database
+type DB struct {
+	c *sql.DB
+}
+
+var _ migdb.Store = (*DB)(nil)
+
+// Open opens the SQLite database at path. A new database is created at the
+// latest schema version, existing databases are upgraded by Migrate. If path is
+// ":memory:", the database is kept in memory and lost when it is closed.
+func Open(path string) (db *DB, err error) {
+	dsn := "file:" + path + "?_busy_timeout=10000&_foreign_keys=1"
+	if path != ":memory:" {
+		dsn += "&_journal_mode=WAL"
+	}
+	c, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return
+	}
+	// SQLite only allows one writer at a time, and an in memory database only
+	// exists in the connection that created it, so all queries share a single
+	// connection. Methods must read their rows before running other queries.
+	c.SetMaxOpenConns(1)
+	db = &DB{c: c}
+	version, err := db.SchemaVersion()
+	if err == nil && version == 0 {
+		_, err = db.Migrate()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) Close() {
+	db.c.Close()
+}
+
+// SetMaxOpenConns does nothing, since the database uses a single connection
+func (db *DB) SetMaxOpenConns(n int) {
+}
+
+// AllowRawSQLTargets does nothing, since targets are not evaluated in SQL
+func (db *DB) AllowRawSQLTargets(allow bool) {
+}
+
+// Timestamps are stored as text and compared as strings, which is only correct
+// if they are all in the same time zone. The following methods convert time
+// arguments to UTC before running queries.
+
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.c.Exec(query, utc(args)...)
+}
+
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.c.Query(query, utc(args)...)
+}
+
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	return db.c.QueryRow(query, utc(args)...)
+}
+
+func utc(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.UTC()
+		}
+	}
+	return args
+}
+
+// now returns the current time, used in place of the NOW() function of Postgres
+func now() time.Time {
+	return time.Now().UTC()
+}
@@ -0,0 +1,311 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package sqlite /* import "github.com/mozilla/mig/database/sqlite" */
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/modules"
+)
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return db
+}
+
+func insertTestAgents(t *testing.T, db *DB) {
+	hb := time.Now().Add(-time.Minute)
+	agents := []mig.Agent{
+		{Name: "web1", QueueLoc: "linux.web1.aaa", PID: 1, Status: mig.AgtStatusOnline,
+			Env:  mig.AgentEnv{OS: "linux", Addresses: []string{"10.1.2.3/8"}},
+			Tags: map[string]string{"operator": "IT"}},
+		{Name: "web2", QueueLoc: "linux.web2.bbb", PID: 2, Status: mig.AgtStatusOnline,
+			Env: mig.AgentEnv{OS: "linux", Addresses: []string{"192.168.1.2/24"}}},
+		{Name: "laptop", QueueLoc: "darwin.laptop.ccc", PID: 3, Status: mig.AgtStatusIdle,
+			Env: mig.AgentEnv{OS: "darwin"}, Tags: map[string]string{"operator": "IT"}},
+		{Name: "old", QueueLoc: "linux.old.ddd", PID: 4, Status: mig.AgtStatusOffline,
+			Env: mig.AgentEnv{OS: "linux"}},
+	}
+	for _, agt := range agents {
+		agt.Mode = "daemon"
+		agt.Version = "20170101-0.abcdef.dev"
+		agt.StartTime = hb
+		agt.HeartBeatTS = hb
+		agt.RefreshTS = hb
+		err := db.InsertAgent(agt)
+		if err != nil {
+			t.Fatalf("InsertAgent: %v", err)
+		}
+	}
+}
+
+func TestActiveAgentsByTarget(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+	insertTestAgents(t, db)
+
+	var tests = []struct {
+		target string
+		names  []string
+	}{
+		{`os = linux`, []string{"web1", "web2"}},
+		{`tag.operator = IT`, []string{"laptop", "web1"}},
+		{`env.addresses in 10.0.0.0/8`, []string{"web1"}},
+		{`queueloc like "linux.%" AND NOT name = web1`, []string{"web2"}},
+		{`status = idle OR pid = 2`, []string{"laptop", "web2"}},
+	}
+	for _, tt := range tests {
+		agents, err := db.ActiveAgentsByTarget(tt.target)
+		if err != nil {
+			t.Errorf("ActiveAgentsByTarget(%q) returned error: %v", tt.target, err)
+			continue
+		}
+		var names []string
+		for _, agt := range agents {
+			names = append(names, agt.Name)
+		}
+		if len(names) != len(tt.names) {
+			t.Errorf("ActiveAgentsByTarget(%q) = %v, expected %v", tt.target, names, tt.names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.names[i] {
+				t.Errorf("ActiveAgentsByTarget(%q) = %v, expected %v", tt.target, names, tt.names)
+				break
+			}
+		}
+	}
+	_, err := db.ActiveAgentsByTarget(`sql:status='online'`)
+	if err == nil {
+		t.Errorf("raw SQL target was accepted")
+	}
+}
+
+func TestActionLifecycle(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+	insertTestAgents(t, db)
+
+	iid, err := db.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "ABCD"})
+	if err != nil {
+		t.Fatalf("InsertInvestigator: %v", err)
+	}
+	_, err = db.InsertInvestigator(mig.Investigator{Name: "Bob again", PGPFingerprint: "ABCD"})
+	if err == nil {
+		t.Errorf("InsertInvestigator accepted a duplicate fingerprint")
+	}
+
+	a := mig.Action{
+		ID:             mig.GenID(),
+		Name:           "test action",
+		Target:         "os = linux",
+		ValidFrom:      time.Now().Add(-time.Minute),
+		ExpireAfter:    time.Now().Add(time.Hour),
+		StartTime:      time.Now(),
+		FinishTime:     time.Now().Add(time.Hour),
+		LastUpdateTime: time.Now(),
+		Status:         "pending",
+		PGPSignatures:  []string{"sig"},
+		SyntaxVersion:  2,
+	}
+	err = db.InsertAction(a)
+	if err != nil {
+		t.Fatalf("InsertAction: %v", err)
+	}
+	err = db.InsertSignature(a.ID, iid, "sig")
+	if err != nil {
+		t.Fatalf("InsertSignature: %v", err)
+	}
+	actions, err := db.SetupRunnableActions()
+	if err != nil {
+		t.Fatalf("SetupRunnableActions: %v", err)
+	}
+	if len(actions) != 1 || actions[0].ID != a.ID || actions[0].Status != "scheduled" {
+		t.Fatalf("SetupRunnableActions returned %+v", actions)
+	}
+	actions, err = db.SetupRunnableActions()
+	if err != nil || len(actions) != 0 {
+		t.Fatalf("action was set up twice: %v %v", actions, err)
+	}
+
+	agents, err := db.ActiveAgentsByTarget(a.Target)
+	if err != nil {
+		t.Fatalf("ActiveAgentsByTarget: %v", err)
+	}
+	var cmds []mig.Command
+	for _, agt := range agents {
+		cmds = append(cmds, mig.Command{ID: mig.GenID(), Action: a, Agent: agt,
+			Status: mig.StatusSent, StartTime: time.Now()})
+	}
+	n, err := db.InsertCommands(cmds)
+	if err != nil || n != 2 {
+		t.Fatalf("InsertCommands inserted %d commands: %v", n, err)
+	}
+
+	finished := cmds[0]
+	finished.Status = mig.StatusSuccess
+	finished.FinishTime = time.Now()
+	finished.Results = []modules.Result{{FoundAnything: true, Success: true}}
+	err = db.FinishCommand(finished)
+	if err != nil {
+		t.Fatalf("FinishCommand: %v", err)
+	}
+	counters, err := db.GetActionCounters(a.ID)
+	if err != nil {
+		t.Fatalf("GetActionCounters: %v", err)
+	}
+	if counters.Success != 1 || counters.InFlight != 1 || counters.Sent != 2 {
+		t.Errorf("unexpected counters %+v", counters)
+	}
+
+	done, err := db.CommandsFinishedAfter(a.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("CommandsFinishedAfter: %v", err)
+	}
+	if len(done) != 1 || done[0].Command.ID != finished.ID || !done[0].Command.Results[0].FoundAnything {
+		t.Fatalf("CommandsFinishedAfter returned %+v", done)
+	}
+	after := done[0].Seq
+	done, err = db.CommandsFinishedAfter(a.ID, after, 10)
+	if err != nil || len(done) != 0 {
+		t.Errorf("CommandsFinishedAfter returned the last command again: %v %v", done, err)
+	}
+
+	// a command that finishes later with an earlier finish time is still returned
+	late := cmds[1]
+	late.Status = mig.StatusSuccess
+	late.FinishTime = finished.FinishTime.Add(-time.Minute)
+	err = db.FinishCommand(late)
+	if err != nil {
+		t.Fatalf("FinishCommand: %v", err)
+	}
+	done, err = db.CommandsFinishedAfter(a.ID, after, 10)
+	if err != nil || len(done) != 1 || done[0].Command.ID != late.ID {
+		t.Errorf("CommandsFinishedAfter did not return the late command: %+v %v", done, err)
+	}
+
+	found, err := db.ActiveAgentsByTarget(`found = ` + strconv.FormatFloat(a.ID, 'f', 0, 64))
+	if err != nil {
+		t.Fatalf("ActiveAgentsByTarget: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != finished.Agent.ID {
+		t.Errorf("found target returned %+v", found)
+	}
+
+	invs, err := db.InvestigatorByActionID(a.ID)
+	if err != nil || len(invs) != 1 || invs[0].Name != "Bob" {
+		t.Errorf("InvestigatorByActionID returned %+v: %v", invs, err)
+	}
+}
+
+func TestAgentMessages(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	for _, body := range []string{"one", "two", "three"} {
+		err := db.InsertAgentMessage("q", migdb.AgentMessage{Type: "command", Body: []byte(body)},
+			time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("InsertAgentMessage: %v", err)
+		}
+	}
+	err := db.InsertAgentMessage("q", migdb.AgentMessage{Type: "command", Body: []byte("expired")},
+		time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("InsertAgentMessage: %v", err)
+	}
+	msgs, err := db.TakeAgentMessages("q", 2)
+	if err != nil {
+		t.Fatalf("TakeAgentMessages: %v", err)
+	}
+	if len(msgs) != 2 || string(msgs[0].Body) != "one" || string(msgs[1].Body) != "two" {
+		t.Errorf("TakeAgentMessages returned %+v", msgs)
+	}
+	msgs, err = db.TakeAgentMessages("q", 10)
+	if err != nil || len(msgs) != 1 || string(msgs[0].Body) != "three" {
+		t.Errorf("TakeAgentMessages returned %+v: %v", msgs, err)
+	}
+	count, err := db.DeleteExpiredAgentMessages()
+	if err != nil || count != 1 {
+		t.Errorf("DeleteExpiredAgentMessages removed %d messages: %v", count, err)
+	}
+
+	// messages polled by agents stay queued until they are acknowledged
+	for _, body := range []string{"four", "five"} {
+		err = db.InsertAgentMessage("q", migdb.AgentMessage{Type: "command", Body: []byte(body)},
+			time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("InsertAgentMessage: %v", err)
+		}
+	}
+	msgs, err = db.PendingAgentMessages("q", 0, 10)
+	if err != nil || len(msgs) != 2 || string(msgs[0].Body) != "four" || msgs[0].ID >= msgs[1].ID {
+		t.Fatalf("PendingAgentMessages returned %+v: %v", msgs, err)
+	}
+	err = db.AckAgentMessages("q", msgs[0].ID)
+	if err != nil {
+		t.Fatalf("AckAgentMessages: %v", err)
+	}
+	msgs, err = db.PendingAgentMessages("q", 0, 10)
+	if err != nil || len(msgs) != 1 || string(msgs[0].Body) != "five" {
+		t.Errorf("PendingAgentMessages returned %+v after acknowledgement: %v", msgs, err)
+	}
+	msgs, err = db.PendingAgentMessages("q", msgs[0].ID, 10)
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("PendingAgentMessages returned %+v after the last message: %v", msgs, err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migsqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mig.db")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		t.Errorf("new database is not at the latest schema version: %v", err)
+	}
+	applied, err := db.Migrate()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Migrate applied %v to an up to date database: %v", applied, err)
+	}
+	_, err = db.exec(`INSERT INTO schema_version (version, description, appliedat)
+		VALUES (?1, 'from the future', ?2)`, LatestSchemaVersion()+1, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if db.CheckSchemaVersion() == nil {
+		t.Errorf("unknown schema version was accepted")
+	}
+	if _, err = db.Migrate(); err == nil {
+		t.Errorf("Migrate accepted an unknown schema version")
+	}
+}
@@ -0,0 +1,177 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/search"
+)
+
+// ActionStore stores actions, their signatures and their approval
+type ActionStore interface {
+	LastActions(limit int) ([]mig.Action, error)
+	ActionByID(id float64) (mig.Action, error)
+	ActionMetaByID(id float64) (mig.Action, error)
+	InsertAction(a mig.Action) error
+	UpdateAction(a mig.Action) error
+	InsertOrUpdateAction(a mig.Action) (bool, error)
+	UpdateActionStatus(a mig.Action) error
+	UpdateRunningAction(a mig.Action) error
+	FinishAction(a mig.Action) error
+	InsertSignature(aid, iid float64, sig string) error
+	GetActionCounters(aid float64) (mig.ActionCounters, error)
+	SetupRunnableActions() ([]mig.Action, error)
+	ActionsPendingApproval() ([]mig.Action, error)
+	AddApprovalSignature(a mig.Action, iid float64, sig, status string) error
+	RejectAction(aid float64) error
+}
+
+// CommandStore stores the commands sent to agents and their results
+type CommandStore interface {
+	CommandByID(id float64) (mig.Command, error)
+	CommandsByActionID(actionid float64) ([]mig.Command, error)
+	CommandsFinishedAfter(actionid float64, after float64, limit int) ([]FinishedCommand, error)
+	InsertCommand(cmd mig.Command, agt mig.Agent) error
+	InsertCommands(cmds []mig.Command) (int64, error)
+	UpdateSentCommand(cmd mig.Command) error
+	FinishCommand(cmd mig.Command) error
+}
+
+// AgentStore stores agents, the statistics computed on them and the ACL
+// bundles they receive
+type AgentStore interface {
+	AgentByQueueAndPID(queueloc string, pid int) (mig.Agent, error)
+	AgentByID(id float64) (mig.Agent, error)
+	AgentsActiveSince(pointInTime time.Time) ([]mig.Agent, error)
+	InsertAgent(agt mig.Agent) error
+	UpdateAgentHeartbeat(agt mig.Agent) error
+	ReplaceRefreshedAgent(agt mig.Agent) error
+	ListMultiAgentsQueues(pointInTime time.Time) ([]string, error)
+	ActiveAgentsByQueue(queueloc string, pointInTime time.Time) ([]mig.Agent, error)
+	ActiveAgentsByTarget(tgt string) ([]mig.Agent, error)
+	MarkAgentDestroyed(agent mig.Agent) error
+	MarkOfflineAgents(pointInTime time.Time) error
+	MarkIdleAgents(pointInTime time.Time) error
+
+	GetAgentsStats(limit int) ([]mig.AgentsStats, error)
+	StoreAgentsStats(stats mig.AgentsStats) error
+	SumOnlineAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+	SumIdleAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+	CountOnlineEndpoints() (float64, error)
+	CountIdleEndpoints() (float64, error)
+	CountNewEndpoints(recent, old time.Time) (float64, error)
+	CountDoubleAgents() (float64, error)
+	CountDisappearedEndpoints(pointInTime time.Time) (float64, error)
+	GetDisappearedEndpoints(oldest time.Time) ([]string, error)
+	CountFlappingEndpoints() (float64, error)
+
+	ACLBundleAdd(b mig.ACLBundle) (float64, error)
+	ACLBundleAddSignature(bid float64, sig string, invid float64, reqsig int) error
+	ACLBundleDisable(bid float64) error
+	ACLBundleUpdateStatus(bid float64, reqsig int) error
+	ACLBundleClearSignatures(bid float64) error
+	GetACLBundleFromID(bid float64) (mig.ACLBundle, error)
+	ActiveACLBundles() ([]mig.ACLBundle, error)
+	ACLBundleAgents() ([]mig.ACLBundle, map[float64][]mig.Agent, error)
+}
+
+// InvestigatorStore stores investigators, and the module permissions granted
+// to them on agents
+type InvestigatorStore interface {
+	ActiveInvestigatorsPubKeys() ([][]byte, error)
+	InvestigatorByID(iid float64) (mig.Investigator, error)
+	InvestigatorByFingerprint(fp string) (mig.Investigator, error)
+	InvestigatorAPIKeyAuthHelpers() ([]mig.InvestigatorAPIAuthHelper, error)
+	InvestigatorByActionID(aid float64) ([]mig.Investigator, error)
+	InsertInvestigator(inv mig.Investigator) (float64, error)
+	InsertSchedulerInvestigator(inv mig.Investigator) (float64, error)
+	UpdateInvestigatorStatus(inv mig.Investigator) error
+	UpdateInvestigatorAPIKey(inv mig.Investigator, key []byte, salt []byte) error
+	UpdateInvestigatorPerms(inv mig.Investigator) error
+	GetSchedulerPrivKey() ([]byte, error)
+	GetSchedulerInvestigator() (mig.Investigator, error)
+
+	ModuleRequirementsByAgent(agentid float64) ([]mig.ModuleRequirement, error)
+	ModuleRequirementsByAgents(agentids []float64) ([]mig.ModuleRequirement, error)
+	ModulePermissionsByAgent(agentid float64) ([]mig.ModulePermission, error)
+	ModulePermissionsByInvestigator(iid float64) ([]mig.ModulePermission, error)
+	ModulePermissionsByAgentsAndInvestigators(agentids, iids []float64) ([]mig.ModulePermission, error)
+	SetModuleRequirement(req mig.ModuleRequirement) error
+	SetModulePermission(perm mig.ModulePermission) error
+}
+
+// LoaderStore stores loader entries and the manifests they fetch
+type LoaderStore interface {
+	GetLoaderEntryID(key string) (float64, error)
+	GetLoaderAuthDetails(prefix string) (mig.LoaderAuthDetails, error)
+	GetLoaderName(id float64) (string, error)
+	UpdateLoaderEntry(lid float64, agt mig.Agent) error
+	CompareLoaderExpectEnv(lid float64) error
+	ManifestIDFromLoaderID(lid float64) (float64, error)
+	AllLoadersFromManifestID(mid float64) ([]mig.LoaderEntry, error)
+	GetLoaderFromID(lid float64) (mig.LoaderEntry, error)
+	LoaderUpdateStatus(lid float64, status bool) error
+	LoaderUpdateExpect(lid float64, eenv string) error
+	LoaderUpdateKey(lid float64, hashkey []byte, salt []byte) error
+	LoaderAdd(le mig.LoaderEntry, hashkey []byte, salt []byte) (mig.LoaderEntry, error)
+
+	ManifestAdd(mr mig.ManifestRecord) error
+	ManifestAddSignature(mid float64, sig string, invid float64, reqsig int) error
+	ManifestDisable(mid float64) error
+	ManifestUpdateStatus(mid float64, reqsig int) error
+	ManifestClearSignatures(mid float64) error
+	GetManifestFromID(mid float64) (mig.ManifestRecord, error)
+}
+
+// SearchStore runs the searches of the API
+type SearchStore interface {
+	SearchCommands(p search.Parameters, doFoundAnything bool) ([]mig.Command, error)
+	SearchActions(p search.Parameters) ([]mig.Action, error)
+	SearchAgents(p search.Parameters) ([]mig.Agent, error)
+	SearchInvestigators(p search.Parameters) ([]mig.Investigator, error)
+	SearchManifests(p search.Parameters) ([]mig.ManifestRecord, error)
+	SearchLoaders(p search.Parameters) ([]mig.LoaderEntry, error)
+}
+
+// AgentMessageStore queues the messages exchanged with agents that poll the API
+type AgentMessageStore interface {
+	InsertAgentMessage(queue string, msg AgentMessage, expireafter time.Time) error
+	TakeAgentMessages(queue string, limit int) ([]AgentMessage, error)
+	PendingAgentMessages(queue string, after float64, limit int) ([]AgentMessage, error)
+	AckAgentMessages(queue string, upto float64) error
+	DeleteExpiredAgentMessages() (int64, error)
+}
+
+// SchemaStore manages the version of the database schema
+type SchemaStore interface {
+	SchemaVersion() (int, error)
+	CheckSchemaVersion() error
+	Migrate() ([]Migration, error)
+}
+
+// Store is implemented by the database backends of the API and the scheduler.
+// DB stores data in Postgres, and the database/sqlite package provides a
+// backend for small deployments and tests.
+type Store interface {
+	ActionStore
+	CommandStore
+	AgentStore
+	InvestigatorStore
+	LoaderStore
+	SearchStore
+	AgentMessageStore
+	SchemaStore
+
+	// AllowRawSQLTargets controls whether action targets can be raw SQL
+	// conditions instead of expressions of the target language. Backends
+	// that can't evaluate SQL conditions reject raw SQL targets regardless.
+	AllowRawSQLTargets(allow bool)
+	SetMaxOpenConns(n int)
+	Close()
+}
+
+var _ Store = (*DB)(nil)
@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type fieldType int
+
+const (
+	typeString     fieldType = iota // a text value
+	typeInt                         // an integer value
+	typeBool                        // true or false
+	typeAddr                        // a single IP address
+	typeAddrList                    // a list of IP addresses in CIDR notation
+	typeStringList                  // a list of text values
+	typeActionID                    // the ID of a previous action
+)
+
+// field describes a value of the agents table that can be used in a target
+// expression
+type field struct {
+	ftype fieldType
+	// column is the SQL expression returning the value of the field
+	column string
+	// nullable is set if the column can be NULL, in which case comparisons
+	// are wrapped so that missing values never match
+	nullable bool
+	doc      string
+}
+
+// fields lists the fields supported by the target language. Tags are not
+// listed here since any tag name can be used with the "tag." prefix.
+var fields = map[string]field{
+	"id":                 {typeInt, "agents.id", false, "agent ID"},
+	"name":               {typeString, "agents.name", false, "agent hostname"},
+	"queueloc":           {typeString, "agents.queueloc", false, "agent queue location"},
+	"mode":               {typeString, "agents.mode", false, "agent mode, daemon or checkin"},
+	"version":            {typeString, "agents.version", false, "agent version"},
+	"pid":                {typeInt, "agents.pid", false, "PID of the agent process"},
+	"status":             {typeString, "agents.status", true, "agent status, online or idle"},
+	"loadername":         {typeString, "agents.loadername", true, "name of the loader the agent belongs to"},
+	"env.init":           {typeString, "agents.environment->>'init'", true, "init system of the endpoint"},
+	"env.ident":          {typeString, "agents.environment->>'ident'", true, "operating system identifier"},
+	"env.os":             {typeString, "agents.environment->>'os'", true, "operating system family, linux, darwin or windows"},
+	"env.arch":           {typeString, "agents.environment->>'arch'", true, "processor architecture"},
+	"env.isproxied":      {typeBool, "agents.environment->>'isproxied'", true, "true if the agent uses a proxy"},
+	"env.proxy":          {typeString, "agents.environment->>'proxy'", true, "proxy used by the agent"},
+	"env.addresses":      {typeAddrList, "agents.environment->'addresses'", true, "local IP addresses of the endpoint"},
+	"env.publicip":       {typeAddr, "agents.environment->>'publicip'", true, "public IP of the endpoint"},
+	"env.modules":        {typeStringList, "agents.environment->'modules'", true, "modules supported by the agent"},
+	"env.aws.instanceid": {typeString, "agents.environment#>>'{aws,instanceid}'", true, "AWS instance ID"},
+	"env.aws.localipv4":  {typeAddr, "agents.environment#>>'{aws,localipv4}'", true, "AWS local IPv4 address"},
+	"env.aws.amiid":      {typeString, "agents.environment#>>'{aws,amiid}'", true, "AWS AMI ID"},
+	"env.aws.instancetype": {typeString, "agents.environment#>>'{aws,instancetype}'", true,
+		"AWS instance type"},
+	"found":    {typeActionID, "", false, "agents that found results in the given action ID"},
+	"notfound": {typeActionID, "", false, "agents that did not find results in the given action ID"},
+}
+
+// aliases are short names for commonly used fields
+var aliases = map[string]string{
+	"os":    "env.os",
+	"arch":  "env.arch",
+	"ident": "env.ident",
+	"init":  "env.init",
+}
+
+// tagPrefixes are the prefixes used to reference an agent tag
+var tagPrefixes = []string{"tag.", "tags."}
+
+// lookupField resolves a field name used in an expression. If the field is a
+// tag, the tag name is returned as well.
+func lookupField(name string) (f field, canonical, tag string, ok bool) {
+	lname := strings.ToLower(name)
+	for _, prefix := range tagPrefixes {
+		if strings.HasPrefix(lname, prefix) && len(name) > len(prefix) {
+			// tag names are case sensitive, only the prefix is not
+			tag = name[len(prefix):]
+			return field{ftype: typeString, nullable: true}, "tag." + tag, tag, true
+		}
+	}
+	if a, found := aliases[lname]; found {
+		lname = a
+	}
+	f, ok = fields[lname]
+	return f, lname, "", ok
+}
+
+// Fields returns a sorted list of the field names supported by the target
+// language along with a short description of each
+func Fields() (ret [][2]string) {
+	for name, f := range fields {
+		ret = append(ret, [2]string{name, f.doc})
+	}
+	for alias, name := range aliases {
+		ret = append(ret, [2]string{alias, "alias of " + name})
+	}
+	ret = append(ret, [2]string{"tag.<name>", "value of agent tag <name>"})
+	sort.Slice(ret, func(i, j int) bool { return ret[i][0] < ret[j][0] })
+	return
+}
+
+// operators lists the operators accepted by each field type
+var operators = map[fieldType][]string{
+	typeString:     {"=", "!=", "~", "!~", "like", "in"},
+	typeInt:        {"=", "!=", "<", "<=", ">", ">=", "in"},
+	typeBool:       {"=", "!="},
+	typeAddr:       {"=", "!=", "in"},
+	typeAddrList:   {"=", "!=", "in"},
+	typeStringList: {"=", "!=", "~"},
+	typeActionID:   {"="},
+}
+
+// checkValue verifies that a value is valid for a given field type and
+// operator, and returns its normalized form
+func checkValue(ft fieldType, op, val string) (string, error) {
+	if op == "~" || op == "!~" {
+		// regular expressions are evaluated by the database, but use a syntax
+		// close enough to Go's that invalid ones can be caught early
+		if _, err := regexp.Compile(val); err != nil {
+			return "", err
+		}
+		return val, nil
+	}
+	switch ft {
+	case typeInt, typeActionID:
+		if _, err := strconv.ParseInt(val, 10, 64); err != nil {
+			return "", errInvalidValue(val, "an integer")
+		}
+	case typeBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return "", errInvalidValue(val, "true or false")
+		}
+		return strconv.FormatBool(b), nil
+	case typeAddr, typeAddrList:
+		if op == "in" {
+			if ip := net.ParseIP(val); ip != nil {
+				return ip.String(), nil
+			}
+			_, ipnet, err := net.ParseCIDR(val)
+			if err != nil {
+				return "", errInvalidValue(val, "an IP address or a CIDR network")
+			}
+			return ipnet.String(), nil
+		}
+		ip := net.ParseIP(val)
+		if ip == nil {
+			return "", errInvalidValue(val, "an IP address")
+		}
+		return ip.String(), nil
+	}
+	return val, nil
+}
+
+func errInvalidValue(val, expected string) error {
+	return fmt.Errorf("invalid value %q, expected %s", val, expected)
+}
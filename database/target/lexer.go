@@ -0,0 +1,156 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.val)
+	}
+	return fmt.Sprintf("%q", t.val)
+}
+
+// isKeyword returns true if the token is a bare word matching keyword kw,
+// keywords are case insensitive
+func (t token) isKeyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.val, kw)
+}
+
+// SyntaxError is returned when a target expression cannot be parsed or
+// validated. Pos is the byte offset in the expression where the error
+// was detected.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("target syntax error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+func errorAt(pos int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isWordChar returns true if r can be part of a bare word. Bare words
+// are used for field names, keywords and unquoted values, and allow
+// most characters found in hostnames, addresses and versions.
+func isWordChar(r rune) bool {
+	if unicode.IsLetter(r) || unicode.IsDigit(r) {
+		return true
+	}
+	return strings.ContainsRune("._-/:*%@+", r)
+}
+
+// lex splits a target expression into tokens
+func lex(input string) (tokens []token, err error) {
+	runes := []rune(input)
+	// offsets maps rune indexes to byte offsets in the input
+	offsets := make([]int, len(runes)+1)
+	off := 0
+	for i, r := range runes {
+		offsets[i] = off
+		off += len(string(r))
+	}
+	offsets[len(runes)] = off
+
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		pos := offsets[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, val: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, val: ")", pos: pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, val: ",", pos: pos})
+			i++
+		case r == '"' || r == '\'':
+			var (
+				val    []rune
+				closed bool
+			)
+			quote := r
+			i++
+			for i < len(runes) {
+				c := runes[i]
+				if c == '\\' && i+1 < len(runes) {
+					val = append(val, runes[i+1])
+					i += 2
+					continue
+				}
+				i++
+				if c == quote {
+					closed = true
+					break
+				}
+				val = append(val, c)
+			}
+			if !closed {
+				return nil, errorAt(pos, "unterminated string")
+			}
+			tokens = append(tokens, token{kind: tokString, val: string(val), pos: pos})
+		case strings.ContainsRune("=!~<>", r):
+			op := string(r)
+			if i+1 < len(runes) {
+				two := string(runes[i : i+2])
+				switch two {
+				case "!=", "!~", "<=", ">=", "==":
+					op = two
+				}
+			}
+			if op == "!" {
+				return nil, errorAt(pos, "unexpected character '!'")
+			}
+			if op == "==" {
+				op = "="
+				i++
+			}
+			tokens = append(tokens, token{kind: tokOp, val: op, pos: pos})
+			i += len([]rune(op))
+		case isWordChar(r):
+			start := i
+			for i < len(runes) && isWordChar(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokWord, val: string(runes[start:i]), pos: pos})
+		default:
+			return nil, errorAt(pos, "unexpected character %q", r)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(input)})
+	return
+}
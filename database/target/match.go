@@ -0,0 +1,234 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/mig"
+)
+
+// FoundFunc reports whether an agent returned results for an action in which
+// foundanything is set to found. It evaluates the found and notfound fields.
+type FoundFunc func(agt mig.Agent, actionid float64, found bool) (bool, error)
+
+// Match evaluates a parsed target expression against an agent, in the same way
+// the SQL condition returned by Compile does. It is used by database backends
+// that can't run the compiled condition. Fields that are empty on the agent are
+// treated as NULL and never match.
+func Match(e Expr, agt mig.Agent, found FoundFunc) (bool, error) {
+	switch n := e.(type) {
+	case And:
+		left, err := Match(n.Left, agt, found)
+		if err != nil || !left {
+			return false, err
+		}
+		return Match(n.Right, agt, found)
+	case Or:
+		left, err := Match(n.Left, agt, found)
+		if err != nil || left {
+			return left, err
+		}
+		return Match(n.Right, agt, found)
+	case Not:
+		m, err := Match(n.Expr, agt, found)
+		return !m, err
+	case Condition:
+		return matchCondition(n, agt, found)
+	}
+	return false, fmt.Errorf("unknown target expression type %T", e)
+}
+
+func matchCondition(cond Condition, agt mig.Agent, found FoundFunc) (bool, error) {
+	if cond.Tag != "" {
+		v, ok := agt.Tags[cond.Tag]
+		if !ok {
+			return false, nil
+		}
+		return matchString(cond, v)
+	}
+	switch cond.Field {
+	case "id":
+		return matchInt(cond, int64(agt.ID))
+	case "pid":
+		return matchInt(cond, int64(agt.PID))
+	case "name":
+		return matchString(cond, agt.Name)
+	case "queueloc":
+		return matchString(cond, agt.QueueLoc)
+	case "mode":
+		return matchString(cond, agt.Mode)
+	case "version":
+		return matchString(cond, agt.Version)
+	case "env.isproxied":
+		return (cond.Values[0] == strconv.FormatBool(agt.Env.IsProxied)) == (cond.Operator == "="), nil
+	case "env.publicip":
+		return matchAddrs(cond, []string{agt.Env.PublicIP})
+	case "env.aws.localipv4":
+		return matchAddrs(cond, []string{agt.Env.AWS.LocalIPV4})
+	case "env.addresses":
+		return matchAddrs(cond, agt.Env.Addresses)
+	case "env.modules":
+		return matchStringList(cond, agt.Env.Modules)
+	case "found", "notfound":
+		aid, _ := strconv.ParseFloat(cond.Values[0], 64)
+		return found(agt, aid, cond.Field == "found")
+	}
+	nullable := map[string]string{
+		"status":               agt.Status,
+		"loadername":           agt.LoaderName,
+		"env.init":             agt.Env.Init,
+		"env.ident":            agt.Env.Ident,
+		"env.os":               agt.Env.OS,
+		"env.arch":             agt.Env.Arch,
+		"env.proxy":            agt.Env.Proxy,
+		"env.aws.instanceid":   agt.Env.AWS.InstanceID,
+		"env.aws.amiid":        agt.Env.AWS.AMIID,
+		"env.aws.instancetype": agt.Env.AWS.InstanceType,
+	}
+	v, ok := nullable[cond.Field]
+	if !ok {
+		return false, fmt.Errorf("unknown target field %q", cond.Field)
+	}
+	if v == "" {
+		return false, nil
+	}
+	return matchString(cond, v)
+}
+
+func matchString(cond Condition, v string) (bool, error) {
+	switch cond.Operator {
+	case "=":
+		return v == cond.Values[0], nil
+	case "!=":
+		return v != cond.Values[0], nil
+	case "in":
+		for _, val := range cond.Values {
+			if v == val {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "~", "!~":
+		re, err := regexp.Compile(cond.Values[0])
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(v) == (cond.Operator == "~"), nil
+	case "like":
+		return likePattern(cond.Values[0]).MatchString(v), nil
+	}
+	return false, fmt.Errorf("operator %q is not supported on field %q", cond.Operator, cond.Field)
+}
+
+// likePattern converts the pattern of a SQL LIKE operator into a regular expression
+func likePattern(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
+
+func matchInt(cond Condition, v int64) (bool, error) {
+	if cond.Operator == "in" {
+		for _, val := range cond.Values {
+			i, _ := strconv.ParseInt(val, 10, 64)
+			if v == i {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	i, _ := strconv.ParseInt(cond.Values[0], 10, 64)
+	switch cond.Operator {
+	case "=":
+		return v == i, nil
+	case "!=":
+		return v != i, nil
+	case "<":
+		return v < i, nil
+	case "<=":
+		return v <= i, nil
+	case ">":
+		return v > i, nil
+	case ">=":
+		return v >= i, nil
+	}
+	return false, fmt.Errorf("operator %q is not supported on field %q", cond.Operator, cond.Field)
+}
+
+// matchAddrs evaluates a condition on a list of addresses, which matches if any
+// of the addresses matches. Addresses in CIDR notation are reduced to their host
+// part. With the != operator, the condition matches if no address is equal to
+// the value.
+func matchAddrs(cond Condition, addrs []string) (bool, error) {
+	var ips []net.IP
+	for _, a := range addrs {
+		if ip, _, err := net.ParseCIDR(a); err == nil {
+			ips = append(ips, ip)
+		} else if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return false, nil
+	}
+	for _, ip := range ips {
+		switch cond.Operator {
+		case "=", "!=":
+			if ip.Equal(net.ParseIP(cond.Values[0])) {
+				return cond.Operator == "=", nil
+			}
+		case "in":
+			for _, v := range cond.Values {
+				if _, ipnet, err := net.ParseCIDR(v); err == nil && ipnet.Contains(ip) {
+					return true, nil
+				}
+				if ip.Equal(net.ParseIP(v)) {
+					return true, nil
+				}
+			}
+		default:
+			return false, fmt.Errorf("operator %q is not supported on field %q", cond.Operator, cond.Field)
+		}
+	}
+	return cond.Operator == "!=", nil
+}
+
+// matchStringList evaluates a condition on a list of strings, which matches if
+// any of the strings matches
+func matchStringList(cond Condition, vals []string) (bool, error) {
+	if len(vals) == 0 {
+		return false, nil
+	}
+	op := cond.Operator
+	if op == "!=" {
+		cond.Operator = "="
+	}
+	for _, v := range vals {
+		m, err := matchString(cond, v)
+		if err != nil {
+			return false, err
+		}
+		if m {
+			return op != "!=", nil
+		}
+	}
+	return op == "!=", nil
+}
@@ -0,0 +1,280 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package target implements the language used to select the agents an action
+// runs on. A target expression is a set of conditions on agent fields combined
+// with AND, OR, NOT and parenthesis, for example:
+//
+//	os = linux AND tag.operator = IT AND env.addresses in 10.0.0.0/8 AND NOT name ~ "^build"
+//
+// Expressions are parsed and validated by Parse, and compiled into a
+// parameterized SQL condition against the agents table by Compile. For
+// compatibility with older deployments, a target prefixed with "sql:" is
+// treated as a raw SQL WHERE condition, which the API and scheduler only
+// accept if explicitly enabled in their configuration.
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawSQLPrefix marks a target string as a raw SQL condition rather than an
+// expression of the target language
+const RawSQLPrefix = "sql:"
+
+// IsRawSQL returns true if the target string is a raw SQL condition
+func IsRawSQL(tgt string) bool {
+	return strings.HasPrefix(strings.TrimSpace(tgt), RawSQLPrefix)
+}
+
+// RawSQL returns the SQL condition of a raw SQL target string
+func RawSQL(tgt string) string {
+	return strings.TrimPrefix(strings.TrimSpace(tgt), RawSQLPrefix)
+}
+
+// Validate verifies that a target string is a valid expression. Raw SQL
+// targets are not verified, since only the database can evaluate them.
+func Validate(tgt string) (err error) {
+	if IsRawSQL(tgt) {
+		return
+	}
+	_, err = Parse(tgt)
+	return
+}
+
+// Quote returns s as a quoted string value that can be safely inserted into
+// a target expression
+func Quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+// Expr is a node of a parsed target expression
+type Expr interface {
+	String() string
+}
+
+// And matches agents that match both Left and Right
+type And struct {
+	Left, Right Expr
+}
+
+func (e And) String() string {
+	return fmt.Sprintf("(%s AND %s)", e.Left, e.Right)
+}
+
+// Or matches agents that match either Left or Right
+type Or struct {
+	Left, Right Expr
+}
+
+func (e Or) String() string {
+	return fmt.Sprintf("(%s OR %s)", e.Left, e.Right)
+}
+
+// Not matches agents that do not match Expr
+type Not struct {
+	Expr Expr
+}
+
+func (e Not) String() string {
+	return fmt.Sprintf("NOT %s", e.Expr)
+}
+
+// Condition compares an agent field with one or more values. Field is the
+// canonical name of the field, and Tag is set if the field references an
+// agent tag.
+type Condition struct {
+	Field    string
+	Tag      string
+	Operator string
+	Values   []string
+
+	ftype fieldType
+}
+
+func (c Condition) String() string {
+	var vals []string
+	for _, v := range c.Values {
+		vals = append(vals, Quote(v))
+	}
+	if c.Operator == "in" {
+		return fmt.Sprintf("%s in (%s)", c.Field, strings.Join(vals, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, c.Operator, vals[0])
+}
+
+type parser struct {
+	tokens []token
+	cur    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.cur]
+	if t.kind != tokEOF {
+		p.cur++
+	}
+	return t
+}
+
+// Parse parses and validates a target expression
+func Parse(input string) (e Expr, err error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, errorAt(0, "target is empty")
+	}
+	if IsRawSQL(input) {
+		return nil, errorAt(0, "raw SQL targets cannot be parsed")
+	}
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	e, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorAt(t.pos, "unexpected %s, expected AND or OR", t)
+	}
+	return e, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.peek()
+	switch {
+	case t.isKeyword("not"):
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: e}, nil
+	case t.kind == tokLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, errorAt(closing.pos, "unexpected %s, expected ')'", closing)
+		}
+		return e, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (e Expr, err error) {
+	ft := p.next()
+	if ft.kind != tokWord || ft.isKeyword("and") || ft.isKeyword("or") || ft.isKeyword("in") {
+		return nil, errorAt(ft.pos, "unexpected %s, expected a field name", ft)
+	}
+	f, name, tag, ok := lookupField(ft.val)
+	if !ok {
+		return nil, errorAt(ft.pos, "unknown field %q", ft.val)
+	}
+	cond := Condition{Field: name, Tag: tag, ftype: f.ftype}
+
+	opt := p.next()
+	switch {
+	case opt.kind == tokOp:
+		cond.Operator = opt.val
+	case opt.isKeyword("in"), opt.isKeyword("like"):
+		cond.Operator = strings.ToLower(opt.val)
+	default:
+		return nil, errorAt(opt.pos, "unexpected %s, expected an operator after %q", opt, ft.val)
+	}
+	if !hasOperator(f.ftype, cond.Operator) {
+		return nil, errorAt(opt.pos, "operator %q cannot be used with field %q, valid operators are %s",
+			cond.Operator, ft.val, strings.Join(operators[f.ftype], " "))
+	}
+
+	// the in operator accepts either a single value or a parenthesized list
+	if cond.Operator == "in" && p.peek().kind == tokLParen {
+		p.next()
+		for {
+			val, err := p.parseValue(cond)
+			if err != nil {
+				return nil, err
+			}
+			cond.Values = append(cond.Values, val)
+			sep := p.next()
+			if sep.kind == tokRParen {
+				break
+			}
+			if sep.kind != tokComma {
+				return nil, errorAt(sep.pos, "unexpected %s, expected ',' or ')'", sep)
+			}
+		}
+		return cond, nil
+	}
+	val, err := p.parseValue(cond)
+	if err != nil {
+		return nil, err
+	}
+	cond.Values = []string{val}
+	return cond, nil
+}
+
+func (p *parser) parseValue(cond Condition) (string, error) {
+	vt := p.next()
+	if vt.kind != tokWord && vt.kind != tokString {
+		return "", errorAt(vt.pos, "unexpected %s, expected a value for %q", vt, cond.Field)
+	}
+	if vt.kind == tokWord && (vt.isKeyword("and") || vt.isKeyword("or") || vt.isKeyword("not")) {
+		return "", errorAt(vt.pos, "unexpected keyword %s, quote it to use it as a value", vt)
+	}
+	val, err := checkValue(cond.ftype, cond.Operator, vt.val)
+	if err != nil {
+		return "", errorAt(vt.pos, "%v", err)
+	}
+	return val, nil
+}
+
+func hasOperator(ft fieldType, op string) bool {
+	for _, o := range operators[ft] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
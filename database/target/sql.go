@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Compile parses a target expression and returns the equivalent SQL condition
+// against the agents table, along with the arguments referenced by its
+// placeholders. Placeholders are numbered starting at argOffset+1, so the
+// condition can be embedded in a query that already uses arguments.
+func Compile(tgt string, argOffset int) (cond string, args []interface{}, err error) {
+	e, err := Parse(tgt)
+	if err != nil {
+		return
+	}
+	c := compiler{offset: argOffset}
+	cond = c.compile(e)
+	args = c.args
+	return
+}
+
+type compiler struct {
+	offset int
+	args   []interface{}
+}
+
+// arg registers a new argument and returns its placeholder
+func (c *compiler) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	return fmt.Sprintf("$%d", c.offset+len(c.args))
+}
+
+func (c *compiler) compile(e Expr) string {
+	switch n := e.(type) {
+	case And:
+		return fmt.Sprintf("(%s AND %s)", c.compile(n.Left), c.compile(n.Right))
+	case Or:
+		return fmt.Sprintf("(%s OR %s)", c.compile(n.Left), c.compile(n.Right))
+	case Not:
+		return fmt.Sprintf("(NOT %s)", c.compile(n.Expr))
+	case Condition:
+		return c.condition(n)
+	}
+	panic(fmt.Sprintf("unknown target expression type %T", e))
+}
+
+func (c *compiler) condition(cond Condition) string {
+	f, _, _, _ := lookupField(cond.Field)
+	column := f.column
+	if cond.Tag != "" {
+		column = "agents.tags->>" + c.arg(cond.Tag)
+	}
+	var sql string
+	switch cond.ftype {
+	case typeActionID:
+		found := "true"
+		if cond.Field == "notfound" {
+			found = "false"
+		}
+		id, _ := strconv.ParseInt(cond.Values[0], 10, 64)
+		return fmt.Sprintf(`agents.id IN (SELECT agentid FROM commands, `+
+			`json_array_elements(commands.results) AS r WHERE commands.actionid = %s `+
+			`AND r#>>'{foundanything}' = '%s')`, c.arg(id), found)
+	case typeAddrList, typeStringList:
+		sql = c.listCondition(column, cond)
+	default:
+		sql = c.scalarCondition(column, cond)
+	}
+	if f.nullable || cond.Tag != "" {
+		// comparing a NULL value returns NULL, which would make NOT
+		// conditions exclude agents that miss the field
+		sql = fmt.Sprintf("COALESCE(%s, false)", sql)
+	}
+	return sql
+}
+
+// value converts a value to the type expected by the database
+func (c *compiler) value(cond Condition, v string) string {
+	if cond.ftype == typeInt {
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return c.arg(i)
+	}
+	return c.arg(v)
+}
+
+func (c *compiler) scalarCondition(column string, cond Condition) string {
+	if cond.ftype == typeAddr {
+		// addresses are compared as inet values so that networks can be
+		// matched, empty strings are ignored since they cannot be cast
+		column = fmt.Sprintf("NULLIF(%s, '')::inet", column)
+	}
+	switch cond.Operator {
+	case "in":
+		if cond.ftype == typeAddr {
+			var nets []string
+			for _, v := range cond.Values {
+				nets = append(nets, fmt.Sprintf("%s <<= %s::inet", column, c.arg(v)))
+			}
+			return "(" + strings.Join(nets, " OR ") + ")"
+		}
+		var vals []string
+		for _, v := range cond.Values {
+			vals = append(vals, c.value(cond, v))
+		}
+		return fmt.Sprintf("%s IN (%s)", column, strings.Join(vals, ", "))
+	case "like":
+		return fmt.Sprintf("%s LIKE %s", column, c.arg(cond.Values[0]))
+	case "=", "!=":
+		if cond.ftype == typeAddr {
+			return fmt.Sprintf("%s %s %s::inet", column, sqlOperator(cond.Operator), c.arg(cond.Values[0]))
+		}
+		return fmt.Sprintf("%s %s %s", column, sqlOperator(cond.Operator), c.value(cond, cond.Values[0]))
+	}
+	return fmt.Sprintf("%s %s %s", column, cond.Operator, c.value(cond, cond.Values[0]))
+}
+
+// listCondition compiles a condition on a JSON array of values, which
+// matches if any element of the array matches
+func (c *compiler) listCondition(column string, cond Condition) string {
+	elem := "elem"
+	if cond.ftype == typeAddrList {
+		// addresses are stored in CIDR notation, only keep the host part
+		elem = "host(elem::inet)::inet"
+	}
+	var test string
+	switch cond.Operator {
+	case "in":
+		var nets []string
+		for _, v := range cond.Values {
+			nets = append(nets, fmt.Sprintf("%s <<= %s::inet", elem, c.arg(v)))
+		}
+		test = strings.Join(nets, " OR ")
+	case "~":
+		test = fmt.Sprintf("%s ~ %s", elem, c.arg(cond.Values[0]))
+	default:
+		v := c.arg(cond.Values[0])
+		if cond.ftype == typeAddrList {
+			v += "::inet"
+		}
+		test = fmt.Sprintf("%s = %s", elem, v)
+	}
+	sql := fmt.Sprintf("EXISTS (SELECT 1 FROM json_array_elements_text(%s) AS elem WHERE %s)",
+		column, test)
+	if cond.Operator == "!=" {
+		sql = "NOT " + sql
+	}
+	return sql
+}
+
+func sqlOperator(op string) string {
+	if op == "!=" {
+		return "<>"
+	}
+	return op
+}
@@ -0,0 +1,152 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package target /* import "github.com/mozilla/mig/database/target" */
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{`os = linux`, `env.os = "linux"`},
+		{`name ~ "^build"`, `name ~ "^build"`},
+		{`tag.operator = IT`, `tag.operator = "IT"`},
+		{`Tags.Operator == 'IT'`, `tag.Operator = "IT"`},
+		{`os = linux AND tag.operator = IT AND env.addresses in 10.0.0.0/8 AND NOT name ~ "^build"`,
+			`(((env.os = "linux" AND tag.operator = "IT") AND env.addresses in ("10.0.0.0/8")) AND NOT name ~ "^build")`},
+		{`os = linux or os = darwin and mode = daemon`,
+			`(env.os = "linux" OR (env.os = "darwin" AND mode = "daemon"))`},
+		{`(os = linux or os = darwin) and mode = daemon`,
+			`((env.os = "linux" OR env.os = "darwin") AND mode = "daemon")`},
+		{`os in (linux, darwin)`, `env.os in ("linux", "darwin")`},
+		{`pid >= 100`, `pid >= "100"`},
+		{`env.isproxied = TRUE`, `env.isproxied = "true"`},
+		{`env.publicip = 192.168.1.1`, `env.publicip = "192.168.1.1"`},
+		{`env.addresses in 10.1.2.3/8`, `env.addresses in ("10.0.0.0/8")`},
+		{`queueloc like "linux.%"`, `queueloc like "linux.%"`},
+		{`name = "a \"quoted\" name"`, `name = "a \"quoted\" name"`},
+		{`found = 12345`, `found = "12345"`},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if e.String() != tt.out {
+			t.Errorf("Parse(%q) = %s, expected %s", tt.in, e, tt.out)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		pos int
+	}{
+		{``, 0},
+		{`os`, 2},
+		{`os =`, 4},
+		{`foo = bar`, 0},
+		{`os = linux AND`, 14},
+		{`os = linux os = darwin`, 11},
+		{`(os = linux`, 11},
+		{`pid = abc`, 6},
+		{`pid ~ abc`, 4},
+		{`env.addresses in 10.0.0.0/33`, 17},
+		{`env.isproxied = maybe`, 16},
+		{`name ~ "(unclosed"`, 7},
+		{`name = "unterminated`, 7},
+		{`name = a; DROP TABLE agents`, 8},
+		{`found != 1`, 6},
+		{`os in (linux darwin)`, 13},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.in)
+		if err == nil {
+			t.Errorf("Parse(%q) should have failed", tt.in)
+			continue
+		}
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) returned %T, expected *SyntaxError", tt.in, err)
+			continue
+		}
+		if serr.Pos != tt.pos {
+			t.Errorf("Parse(%q) failed at position %d, expected %d: %v", tt.in, serr.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	var tests = []struct {
+		in   string
+		cond string
+		args []interface{}
+	}{
+		{`name = host1`, `agents.name = $3`, []interface{}{"host1"}},
+		{`os = linux AND NOT pid in (1, 2)`,
+			`(COALESCE(agents.environment->>'os' = $3, false) AND (NOT agents.pid IN ($4, $5)))`,
+			[]interface{}{"linux", int64(1), int64(2)}},
+		{`tag.operator != IT`, `COALESCE(agents.tags->>$3 <> $4, false)`, []interface{}{"operator", "IT"}},
+		{`env.addresses in 10.0.0.0/8`,
+			`COALESCE(EXISTS (SELECT 1 FROM json_array_elements_text(agents.environment->'addresses') ` +
+				`AS elem WHERE host(elem::inet)::inet <<= $3::inet), false)`,
+			[]interface{}{"10.0.0.0/8"}},
+		{`env.modules != file`,
+			`COALESCE(NOT EXISTS (SELECT 1 FROM json_array_elements_text(agents.environment->'modules') ` +
+				`AS elem WHERE elem = $3), false)`,
+			[]interface{}{"file"}},
+		{`found = 42`,
+			`agents.id IN (SELECT agentid FROM commands, json_array_elements(commands.results) AS r ` +
+				`WHERE commands.actionid = $3 AND r#>>'{foundanything}' = 'true')`,
+			[]interface{}{int64(42)}},
+	}
+	for _, tt := range tests {
+		cond, args, err := Compile(tt.in, 2)
+		if err != nil {
+			t.Errorf("Compile(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if cond != tt.cond {
+			t.Errorf("Compile(%q) returned condition\n%s\nexpected\n%s", tt.in, cond, tt.cond)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Compile(%q) returned arguments %v, expected %v", tt.in, args, tt.args)
+		}
+	}
+}
+
+func TestRawSQL(t *testing.T) {
+	tgt := "sql: queueloc LIKE 'linux.%'"
+	if !IsRawSQL(tgt) {
+		t.Fatalf("IsRawSQL(%q) should be true", tgt)
+	}
+	if RawSQL(tgt) != " queueloc LIKE 'linux.%'" {
+		t.Fatalf("RawSQL(%q) returned %q", tgt, RawSQL(tgt))
+	}
+	if err := Validate(tgt); err != nil {
+		t.Fatalf("Validate(%q) should not fail: %v", tgt, err)
+	}
+	if _, _, err := Compile(tgt, 0); err == nil {
+		t.Fatalf("Compile(%q) should fail", tgt)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{`plain`, `with "quotes"`, `back\slash`, `and`} {
+		e, err := Parse("name = " + Quote(s))
+		if err != nil {
+			t.Fatalf("Parse of quoted %q failed: %v", s, err)
+		}
+		if v := e.(Condition).Values[0]; v != s {
+			t.Fatalf("quoted value %q parsed as %q", s, v)
+		}
+	}
+}
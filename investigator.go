@@ -65,31 +65,52 @@ func (i *Investigator) CheckPermission(pv int64) bool {
 		return i.Permissions.InvestigatorCreate
 	case PermInvestigatorUpdate:
 		return i.Permissions.InvestigatorUpdate
+	case PermModulePermission:
+		return i.Permissions.ModulePermission
+	case PermModulePermissionSet:
+		return i.Permissions.ModulePermissionSet
+	case PermACLBundle:
+		return i.Permissions.ACLBundle
+	case PermACLBundleSign:
+		return i.Permissions.ACLBundleSign
+	case PermACLBundleNew:
+		return i.Permissions.ACLBundleNew
+	case PermACLBundleStatus:
+		return i.Permissions.ACLBundleStatus
+	case PermActionApprove:
+		return i.Permissions.ActionApprove
 	}
 	return false
 }
 
 // InvestigatorPerms describes permissions assigned to an investigator
 type InvestigatorPerms struct {
-	Search             bool `json:"search"`
-	Action             bool `json:"action"`
-	ActionCreate       bool `json:"action_create"`
-	Command            bool `json:"command"`
-	Agent              bool `json:"agent"`
-	Dashboard          bool `json:"dashboard"`
-	Loader             bool `json:"loader"`
-	LoaderStatus       bool `json:"loader_status"`
-	LoaderExpect       bool `json:"loader_expect"`
-	LoaderKey          bool `json:"loader_key"`
-	LoaderNew          bool `json:"loader_new"`
-	Manifest           bool `json:"manifest"`
-	ManifestSign       bool `json:"manifest_sign"`
-	ManifestStatus     bool `json:"manifest_status"`
-	ManifestNew        bool `json:"manifest_new"`
-	ManifestLoaders    bool `json:"manifest_loaders"`
-	Investigator       bool `json:"investigator"`
-	InvestigatorCreate bool `json:"investigator_create"`
-	InvestigatorUpdate bool `json:"investigator_update"`
+	Search              bool `json:"search"`
+	Action              bool `json:"action"`
+	ActionCreate        bool `json:"action_create"`
+	Command             bool `json:"command"`
+	Agent               bool `json:"agent"`
+	Dashboard           bool `json:"dashboard"`
+	Loader              bool `json:"loader"`
+	LoaderStatus        bool `json:"loader_status"`
+	LoaderExpect        bool `json:"loader_expect"`
+	LoaderKey           bool `json:"loader_key"`
+	LoaderNew           bool `json:"loader_new"`
+	Manifest            bool `json:"manifest"`
+	ManifestSign        bool `json:"manifest_sign"`
+	ManifestStatus      bool `json:"manifest_status"`
+	ManifestNew         bool `json:"manifest_new"`
+	ManifestLoaders     bool `json:"manifest_loaders"`
+	Investigator        bool `json:"investigator"`
+	InvestigatorCreate  bool `json:"investigator_create"`
+	InvestigatorUpdate  bool `json:"investigator_update"`
+	ModulePermission    bool `json:"module_permission"`
+	ModulePermissionSet bool `json:"module_permission_set"`
+	ACLBundle           bool `json:"aclbundle"`
+	ACLBundleSign       bool `json:"aclbundle_sign"`
+	ACLBundleNew        bool `json:"aclbundle_new"`
+	ACLBundleStatus     bool `json:"aclbundle_status"`
+	ActionApprove       bool `json:"action_approve"`
 }
 
 // FromMask converts a permission bit mask into a boolean permission set
@@ -151,6 +172,27 @@ func (ip *InvestigatorPerms) FromMask(mask int64) {
 	if (mask & PermInvestigatorUpdate) != 0 {
 		ip.InvestigatorUpdate = true
 	}
+	if (mask & PermModulePermission) != 0 {
+		ip.ModulePermission = true
+	}
+	if (mask & PermModulePermissionSet) != 0 {
+		ip.ModulePermissionSet = true
+	}
+	if (mask & PermACLBundle) != 0 {
+		ip.ACLBundle = true
+	}
+	if (mask & PermACLBundleSign) != 0 {
+		ip.ACLBundleSign = true
+	}
+	if (mask & PermACLBundleNew) != 0 {
+		ip.ACLBundleNew = true
+	}
+	if (mask & PermACLBundleStatus) != 0 {
+		ip.ACLBundleStatus = true
+	}
+	if (mask & PermActionApprove) != 0 {
+		ip.ActionApprove = true
+	}
 }
 
 // ToMask converts a boolean permission set to a permission bit mask
@@ -212,6 +254,27 @@ func (ip *InvestigatorPerms) ToMask() (ret int64) {
 	if ip.InvestigatorUpdate {
 		ret |= PermInvestigatorUpdate
 	}
+	if ip.ModulePermission {
+		ret |= PermModulePermission
+	}
+	if ip.ModulePermissionSet {
+		ret |= PermModulePermissionSet
+	}
+	if ip.ACLBundle {
+		ret |= PermACLBundle
+	}
+	if ip.ACLBundleSign {
+		ret |= PermACLBundleSign
+	}
+	if ip.ACLBundleNew {
+		ret |= PermACLBundleNew
+	}
+	if ip.ACLBundleStatus {
+		ret |= PermACLBundleStatus
+	}
+	if ip.ActionApprove {
+		ret |= PermActionApprove
+	}
 	return ret
 }
 
@@ -286,12 +349,40 @@ func (ip *InvestigatorPerms) ToDescriptive() string {
 		ret += ","
 	}
 	ret += av
+
+	av = ""
+	tv = InvestigatorPerms{}
+	tv.ACLBundleSet()
+	fs, part = cf(tv.ToMask(), ip.ToMask())
+	if fs {
+		av = "PermACLBundle"
+	} else if part > 0 {
+		av = "PermACLBundle(partial)"
+	}
+	if ret != "" && av != "" {
+		ret += ","
+	}
+	ret += av
+
+	av = ""
+	tv = InvestigatorPerms{}
+	tv.ApproverSet()
+	fs, part = cf(tv.ToMask(), ip.ToMask())
+	if fs {
+		av = "PermApprover"
+	} else if part > 0 {
+		av = "PermApprover(partial)"
+	}
+	if ret != "" && av != "" {
+		ret += ","
+	}
+	ret += av
 	return ret
 }
 
 // PermSets describes permission sets that can be applied; note default is omitted as this
 // is currently always applied
-var PermSets = []string{"PermManifest", "PermLoader", "PermAdmin"}
+var PermSets = []string{"PermManifest", "PermLoader", "PermACLBundle", "PermApprover", "PermAdmin"}
 
 // FromSetList applies permission sets in slice sl to the investigator
 func (ip *InvestigatorPerms) FromSetList(sl []string) error {
@@ -301,6 +392,10 @@ func (ip *InvestigatorPerms) FromSetList(sl []string) error {
 			ip.ManifestSet()
 		case "PermLoader":
 			ip.LoaderSet()
+		case "PermACLBundle":
+			ip.ACLBundleSet()
+		case "PermApprover":
+			ip.ApproverSet()
 		case "PermAdmin":
 			ip.AdminSet()
 		default:
@@ -338,11 +433,26 @@ func (ip *InvestigatorPerms) LoaderSet() {
 	ip.LoaderNew = true
 }
 
+// ACLBundleSet sets ACL bundle related permissions on the investigator
+func (ip *InvestigatorPerms) ACLBundleSet() {
+	ip.ACLBundle = true
+	ip.ACLBundleSign = true
+	ip.ACLBundleNew = true
+	ip.ACLBundleStatus = true
+}
+
+// ApproverSet sets action approval permissions on the investigator
+func (ip *InvestigatorPerms) ApproverSet() {
+	ip.ActionApprove = true
+}
+
 // AdminSet sets administrative permissions on the investigator
 func (ip *InvestigatorPerms) AdminSet() {
 	ip.Investigator = true
 	ip.InvestigatorCreate = true
 	ip.InvestigatorUpdate = true
+	ip.ModulePermission = true
+	ip.ModulePermissionSet = true
 }
 
 // Permissions that can be assigned to investigators
@@ -366,6 +476,13 @@ const (
 	PermInvestigator
 	PermInvestigatorCreate
 	PermInvestigatorUpdate
+	PermModulePermission
+	PermModulePermissionSet
+	PermACLBundle
+	PermACLBundleSign
+	PermACLBundleNew
+	PermACLBundleStatus
+	PermActionApprove
 )
 
 // Possible status values for an investigator
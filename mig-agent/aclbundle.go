@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/pgp"
+)
+
+// aclState holds the ACL in use by the agent, which is replaced when a signed ACL
+// bundle is received from the relay, and the keys ACL bundles are verified with.
+// The Context only keeps a pointer to it, so all copies of the Context share the
+// same state and the same lock.
+type aclState struct {
+	acl       mig.ACL
+	hash      string // hash of the bundle the ACL comes from, empty if it comes from the configuration
+	timestamp time.Time
+	keys      []string // armored public keys of the ACL bundle signers
+
+	sync.RWMutex // Protects acl, hash and timestamp
+}
+
+// get returns the ACL in use and the hash of the bundle it comes from
+func (s *aclState) get() (acl mig.ACL, hash string) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.acl, s.hash
+}
+
+// aclBundlePath returns the location the ACL bundle in use by the agent is stored at,
+// the loader installs bundles at the same location
+func aclBundlePath() string {
+	return path.Join(agentcontext.GetConfDir(), "acl.bundle")
+}
+
+// initACLKeyring loads the ACL signing keys from the aclkeys directory in the agent
+// configuration directory if present. These keys override any keys present in the
+// ACLPUBLICPGPKEYS configuration variable.
+func initACLKeyring(ctx *Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("initACLKeyring() -> %v", e)
+		}
+	}()
+	ctx.ACL.keys = ACLPUBLICPGPKEYS
+	krdir := path.Join(agentcontext.GetConfDir(), "aclkeys")
+	files, err := ioutil.ReadDir(krdir)
+	if err != nil && os.IsNotExist(err) {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("acl key directory %v not found, continuing with "+
+			"built-in acl keyring", krdir)}.Debug()
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	ctx.ACL.keys = nil
+	for _, x := range files {
+		keypath := path.Join(krdir, x.Name())
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("loading acl key from %v", keypath)}.Info()
+		buf, err := ioutil.ReadFile(keypath)
+		if err != nil {
+			panic(err)
+		}
+		_, err = pgp.LoadArmoredPubKey(buf)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring invalid acl key %v: %v", keypath, err)}.Warning()
+			continue
+		}
+		ctx.ACL.keys = append(ctx.ACL.keys, string(buf))
+	}
+	return
+}
+
+// verifyACLBundle checks that an ACL bundle is valid and carries at least
+// ACLREQUIREDSIGNATURES valid signatures from the ACL signing keys, and returns
+// its hash
+func verifyACLBundle(b mig.ACLBundle, signers []string) (hash string, err error) {
+	if len(signers) == 0 {
+		return "", fmt.Errorf("no acl signing keys configured")
+	}
+	err = b.Validate()
+	if err != nil {
+		return
+	}
+	var keys [][]byte
+	for _, pk := range signers {
+		keys = append(keys, []byte(pk))
+	}
+	keyring, _, err := pgp.ArmoredKeysToKeyring(keys)
+	if err != nil {
+		return
+	}
+	cnt, err := b.VerifySignatures(keyring)
+	if err != nil {
+		return
+	}
+	if cnt < 1 || cnt < ACLREQUIREDSIGNATURES {
+		return "", fmt.Errorf("not enough valid signatures on acl bundle (need %v, got %v)",
+			ACLREQUIREDSIGNATURES, cnt)
+	}
+	return b.Hash()
+}
+
+// loadACLBundle reads the ACL bundle stored in the agent configuration directory,
+// and verifies it
+func loadACLBundle(signers []string) (b mig.ACLBundle, hash string, err error) {
+	buf, err := ioutil.ReadFile(aclBundlePath())
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, &b)
+	if err != nil {
+		return
+	}
+	hash, err = verifyACLBundle(b, signers)
+	return
+}
+
+// applyACLBundle verifies an ACL bundle received from the relay and, if it is more
+// recent than the one in use, replaces the ACL of the agent with it. The bundle is
+// stored in the configuration directory so it survives restarts of the agent.
+func applyACLBundle(ctx *Context, msg []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("applyACLBundle() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{Desc: "leaving applyACLBundle()"}.Debug()
+	}()
+	var b mig.ACLBundle
+	err = json.Unmarshal(msg, &b)
+	if err != nil {
+		panic(err)
+	}
+	hash, err := verifyACLBundle(b, ctx.ACL.keys)
+	if err != nil {
+		panic(err)
+	}
+	ctx.ACL.Lock()
+	defer ctx.ACL.Unlock()
+	if hash == ctx.ACL.hash {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("acl bundle %s already in use", hash)}.Debug()
+		return
+	}
+	// refuse to roll back to an older bundle, which could have been replayed
+	if ctx.ACL.hash != "" && !b.Timestamp.After(ctx.ACL.timestamp) {
+		panic(fmt.Sprintf("acl bundle %s is older than the bundle in use", hash))
+	}
+	ctx.ACL.acl = b.ACL
+	ctx.ACL.hash = hash
+	ctx.ACL.timestamp = b.Timestamp
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("acl replaced with bundle %q (%s)", b.Name, hash)}.Info()
+
+	err = ioutil.WriteFile(aclBundlePath(), msg, 0600)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("failed to store acl bundle: %v", err)}.Err()
+	}
+	return nil
+}
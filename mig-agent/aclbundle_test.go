@@ -0,0 +1,64 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// testACL returns an ACL with a default permission requiring minweight
+func testACL(t *testing.T, minweight int) (acl mig.ACL) {
+	err := json.Unmarshal([]byte(`{"default": {"minimumweight": `+strconv.Itoa(minweight)+`,
+		"investigators": {"user": {"fingerprint": "ABCD", "weight": 1}}}}`), &acl)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	return
+}
+
+func TestVerifyACLBundleInvalid(t *testing.T) {
+	valid := mig.ACLBundle{
+		Name:      "test bundle",
+		Target:    "status='online'",
+		Status:    "active",
+		Timestamp: time.Now(),
+		ACL:       testACL(t, 1),
+	}
+	var cases = []struct {
+		Description string
+		Modify      func(b *mig.ACLBundle)
+		Expect      string
+	}{
+		{
+			Description: "a bundle with an empty acl is refused",
+			Modify:      func(b *mig.ACLBundle) { b.ACL = mig.ACL{} },
+			Expect:      "empty acl",
+		},
+		{
+			Description: "a bundle with a minimum weight of zero is refused",
+			Modify:      func(b *mig.ACLBundle) { b.ACL = testACL(t, 0) },
+			Expect:      "weight must be > 0",
+		},
+		{
+			Description: "a bundle without a target is refused",
+			Modify:      func(b *mig.ACLBundle) { b.Target = "" },
+			Expect:      "invalid target",
+		},
+	}
+	for _, c := range cases {
+		b := valid
+		c.Modify(&b)
+		_, err := verifyACLBundle(b, []string{"unused key"})
+		if err == nil || !strings.Contains(err.Error(), c.Expect) {
+			t.Errorf("%s: expected error containing %q, got %v", c.Description, c.Expect, err)
+		}
+	}
+}
@@ -12,8 +12,6 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path"
@@ -26,7 +24,7 @@ import (
 	"github.com/mozilla/mig/mig-agent/agentcontext"
 	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/service"
-	"github.com/streadway/amqp"
+	"github.com/mozilla/mig/transport"
 )
 
 // publication lock is used to prevent publication when the channels are not
@@ -99,6 +97,7 @@ type Heartbeat struct {
 	RefreshTime time.Time   `json:"refreshTime"`
 	Environment Environment `json:"environment"`
 	Tags        []Tag       `json:"tags"`
+	ACLHash     string      `json:"aclHash"`
 }
 
 var runningOps = make(map[float64]moduleOp)
@@ -182,6 +181,11 @@ func main() {
 	// modules can determine the request socket path
 	modules.ModuleRunDir = agentcontext.GetRunDir()
 
+	// Share the certificate of the agent with persistent modules, so they can
+	// authenticate to other services using the identity of the agent
+	modules.AgentCert = AGENTCERT
+	modules.AgentKey = AGENTKEY
+
 	// See if we should disable persistent modules
 	if runOpt.norunpersist {
 		SPAWNPERSISTENT = false
@@ -395,6 +399,16 @@ func runAgentCheckin(runOpt runtimeOptions) (err error) {
 
 	ctx.Agent.Mode = "checkin"
 
+	// don't hold a long poll of the API the agent may not wait for
+	if TRANSPORT == transport.HTTPS {
+		ctx.Transport.Close()
+		ctx.Transport, err = newHTTPSTransport(ctx.Agent.QueueLoc, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create transport: '%v'", err)
+			os.Exit(1)
+		}
+	}
+
 	err = startRoutines(&ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to start agent routines: '%v'", err)
@@ -404,22 +418,22 @@ func runAgentCheckin(runOpt runtimeOptions) (err error) {
 	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("Mozilla InvestiGator version %s: started agent %s in checkin mode", mig.Version, ctx.Agent.Hostname)}
 	ctx.Agent.Unlock()
 
+	msgs, err := ctx.Transport.Messages()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to receive messages: '%v'", err)
+		os.Exit(0)
+	}
+
 	// The loop below retrieves messages from the relay. If no message is available,
 	// it will timeout and break out of the loop after 10 seconds, causing the agent to exit
 	for {
 		select {
-		case m := <-ctx.MQ.Bind.Chan:
-			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message '%s'", m.Body)}.Debug()
-			// Ack this message only
-			err := m.Ack(true)
-			if err != nil {
-				desc := fmt.Sprintf("Failed to acknowledge reception. Message will be ignored. Body: '%s'", m.Body)
-				ctx.Channels.Log <- mig.Log{Desc: desc}.Err()
-				continue
+		case m, ok := <-msgs:
+			if !ok {
+				ctx.Channels.Log <- mig.Log{Desc: "Collection from relay is failing"}.Err()
+				goto done
 			}
-			// pass it along
-			ctx.Channels.NewCommand <- m.Body
-			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message. queued in position %d", len(ctx.Channels.NewCommand))}
+			handleMessage(&ctx, m)
 		case <-time.After(3 * time.Second):
 			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("No outstanding messages in relay.")}
 			goto done
@@ -473,6 +487,7 @@ func runAgent(runOpt runtimeOptions) (err error) {
 		os.Exit(1)
 	}
 	ctx.Agent.Mode = "daemon"
+	ctx.Agent.ConfPath = runOpt.config
 
 	// Goroutine that receives messages from AMQP
 	go getCommands(&ctx)
@@ -601,31 +616,43 @@ func startRoutines(ctx *Context) (err error) {
 	return
 }
 
-// getCommands receives AMQP messages, and feed them to the action chan
+// getCommands receives messages from the transport, and feed them to the action chan
 func getCommands(ctx *Context) (err error) {
-	for m := range ctx.MQ.Bind.Chan {
-		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message '%s'", m.Body)}.Debug()
-
-		// Ack this message only
-		err := m.Ack(true)
-		if err != nil {
-			desc := fmt.Sprintf("Failed to acknowledge reception. Message will be ignored. Body: '%s'", m.Body)
-			ctx.Channels.Log <- mig.Log{Desc: desc}.Err()
-			continue
+	msgs, err := ctx.Transport.Messages()
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("getCommands() -> %v", err)}.Err()
+	} else {
+		for m := range msgs {
+			handleMessage(ctx, m)
 		}
-
-		// pass it along
-		ctx.Channels.NewCommand <- m.Body
-		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message. queued in position %d", len(ctx.Channels.NewCommand))}
 	}
 	ctx.Channels.Log <- mig.Log{Desc: "closing getCommands goroutine"}.Emerg()
-	// If the getCommands goroutine fails, we have no way to receive incoming AMQP
+	// If the getCommands goroutine fails, we have no way to receive incoming
 	// messages. Treat this in the same way we treat publication failures, and send
 	// a termination note.
 	ctx.Channels.Terminate <- "Collection from relay is failing"
 	return
 }
 
+// handleMessage applies the ACL bundles received from the scheduler, and queues
+// the commands
+func handleMessage(ctx *Context, m transport.Message) {
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message '%s'", m.Body)}.Debug()
+
+	// ACL bundles are applied directly, and not queued with the commands
+	if m.Type == mig.ACLBundleMessageType {
+		err := applyACLBundle(ctx, m.Body)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("acl bundle rejected: %v", err)}.Err()
+		}
+		return
+	}
+
+	// pass it along
+	ctx.Channels.NewCommand <- m.Body
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message. queued in position %d", len(ctx.Channels.NewCommand))}
+}
+
 // parseCommands transforms a message into a MIG Command struct, performs validation
 // and run the command
 func parseCommands(ctx *Context, msg []byte) (err error) {
@@ -923,7 +950,7 @@ func sendResults(ctx *Context, result mig.Command) (err error) {
 		panic(err)
 	}
 
-	err = publish(ctx, mig.ExchangeToSchedulers, mig.QueueAgentResults, body)
+	err = publish(ctx, body)
 	if err != nil {
 		panic(err)
 	}
@@ -969,6 +996,8 @@ func heartbeat(ctx *Context) (err error) {
 
 		ctx.Agent.Unlock()
 
+		_, heartbeat.ACLHash = ctx.ACL.get()
+
 		// make a heartbeat
 		body, err := json.Marshal(heartbeat)
 		if err != nil {
@@ -982,18 +1011,9 @@ func heartbeat(ctx *Context) (err error) {
 		desc := fmt.Sprintf("heartbeat %q", body)
 		ctx.Channels.Log <- mig.Log{Desc: desc}.Debug()
 
-		heartbeatURL, err := url.Parse(APIURL)
-		heartbeatURL.Path = path.Join(heartbeatURL.Path, "heartbeat")
-		heartbeatAPIURL := heartbeatURL.String()
-
-		response, err := http.Post(heartbeatAPIURL, "application/json", bytes.NewReader(body))
+		err = ctx.Transport.PublishHeartbeat(body)
 		if err != nil {
-			panic(err)
-		}
-
-		if response.StatusCode != http.StatusOK {
-			content, _ := ioutil.ReadAll(response.Body)
-			desc := fmt.Sprintf("Expected status code %d but got %d \n %s", http.StatusOK, response.StatusCode, string(content))
+			desc := fmt.Sprintf("heartbeat failed with error '%v'", err)
 			ctx.Channels.Log <- mig.Log{Desc: desc}.Err()
 		}
 
@@ -1008,8 +1028,8 @@ func heartbeat(ctx *Context) (err error) {
 	return
 }
 
-// publish is a generic function that sends messages to an AMQP exchange
-func publish(ctx *Context, exchange, routingKey string, body []byte) (err error) {
+// publish sends the results of a command to the scheduler through the transport
+func publish(ctx *Context, body []byte) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("publish() -> %v", e)
@@ -1020,20 +1040,10 @@ func publish(ctx *Context, exchange, routingKey string, body []byte) (err error)
 	publication.Lock()
 	defer publication.Unlock()
 
-	msg := amqp.Publishing{
-		DeliveryMode: amqp.Persistent,
-		Timestamp:    time.Now(),
-		ContentType:  "text/plain",
-		Expiration:   fmt.Sprintf("%d", int64(ctx.Sleeper/time.Millisecond)*10),
-		Body:         []byte(body),
-	}
 	for tries := 0; tries < 2; tries++ {
-		err = ctx.MQ.Chan.Publish(exchange, routingKey,
-			true,  // is mandatory
-			false, // is immediate
-			msg)   // AMQP message
+		err = ctx.Transport.PublishResult(body)
 		if err == nil { // success! exit the function
-			desc := fmt.Sprintf("Message published to exchange %q with routing key %q and body %q", exchange, routingKey, msg.Body)
+			desc := fmt.Sprintf("Message published with body %q", body)
 			ctx.Channels.Log <- mig.Log{Desc: desc}.Debug()
 			return
 		}
@@ -1059,6 +1069,7 @@ func printConfigSettings() {
 	fmt.Println("REFRESHENV        : ", REFRESHENV)
 	fmt.Println("AMQPBROKER        : ", AMQPBROKER)
 	fmt.Println("APIURL            : ", APIURL)
+	fmt.Println("TRANSPORT         : ", TRANSPORT)
 	fmt.Println("PROXIES           : ", PROXIES)
 	fmt.Println("SOCKET            : ", SOCKET)
 	fmt.Println("HEARTBEATFREQ     : ", HEARTBEATFREQ)
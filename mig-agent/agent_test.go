@@ -12,6 +12,8 @@ import (
 	"testing"
 
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/testutil/harness"
 )
 
 var testContext Context
@@ -29,6 +31,13 @@ func initTestContext() {
 }
 
 func TestMain(m *testing.M) {
+	// the test harness runs this test binary as a real agent, and the agent
+	// runs it again to execute modules
+	if confdir := os.Getenv(harness.AgentConfDirEnv); confdir != "" {
+		agentcontext.EnableTestHooks(confdir)
+		main()
+		os.Exit(0)
+	}
 	initTestContext()
 	ret := m.Run()
 	os.Exit(ret)
@@ -15,6 +15,7 @@ import (
 
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/transport"
 
 	"gopkg.in/gcfg.v1"
 )
@@ -32,11 +33,14 @@ type config struct {
 		HeartbeatFreq    string
 		ModuleTimeout    string
 		Api              string
+		Transport        string
 		RefreshEnv       string
 		NoPersistMods    bool
 		ExtraPrivacyMode bool
 		OnlyVerifyPubKey bool
 		Tags             []string
+
+		ACLRequiredSignatures int
 	}
 	Stats struct {
 		MaxActions int
@@ -141,6 +145,9 @@ type globals struct {
 	// Maximum number of past actions to keep statistics on in the agent, 0 to disable
 	statsMaxActions int
 
+	// number of valid signatures from ACL signing keys required to accept an ACL bundle
+	aclRequiredSignatures int
+
 	// Not supported by config
 	// Control modules permissions by PGP keys
 	// AGENTACL [...]string
@@ -165,31 +172,36 @@ type globals struct {
 
 	// Provides some meta data about agents and lets you target specific ones
 	tags map[string]string
+
+	// how the agent exchanges messages with the scheduler, amqp or https
+	transport string
 }
 
 func newGlobals() *globals {
 	return &globals{
-		isImmortal:         ISIMMORTAL,
-		mustInstallService: MUSTINSTALLSERVICE,
-		discoverPulicIP:    DISCOVERPUBLICIP,
-		discoverAWSMeta:    DISCOVERAWSMETA,
-		checkin:            CHECKIN,
-		extraPrivacyMode:   EXTRAPRIVACYMODE,
-		spawnPersistent:    SPAWNPERSISTENT,
-		refreshEnv:         REFRESHENV,
-		loggingConf:        LOGGINGCONF,
-		amqBroker:          AMQPBROKER,
-		apiURL:             APIURL,
-		proxies:            PROXIES,
-		socket:             SOCKET,
-		heartBeatFreq:      HEARTBEATFREQ,
-		moduleTimeout:      MODULETIMEOUT,
-		onlyVerifyPubKey:   ONLYVERIFYPUBKEY,
-		statsMaxActions:    STATSMAXACTIONS,
-		caCert:             CACERT,
-		agentCert:          AGENTCERT,
-		agentKey:           AGENTKEY,
-		tags:               TAGS,
+		isImmortal:            ISIMMORTAL,
+		mustInstallService:    MUSTINSTALLSERVICE,
+		discoverPulicIP:       DISCOVERPUBLICIP,
+		discoverAWSMeta:       DISCOVERAWSMETA,
+		checkin:               CHECKIN,
+		extraPrivacyMode:      EXTRAPRIVACYMODE,
+		spawnPersistent:       SPAWNPERSISTENT,
+		refreshEnv:            REFRESHENV,
+		loggingConf:           LOGGINGCONF,
+		amqBroker:             AMQPBROKER,
+		apiURL:                APIURL,
+		proxies:               PROXIES,
+		socket:                SOCKET,
+		heartBeatFreq:         HEARTBEATFREQ,
+		moduleTimeout:         MODULETIMEOUT,
+		onlyVerifyPubKey:      ONLYVERIFYPUBKEY,
+		statsMaxActions:       STATSMAXACTIONS,
+		aclRequiredSignatures: ACLREQUIREDSIGNATURES,
+		caCert:                CACERT,
+		agentCert:             AGENTCERT,
+		agentKey:              AGENTKEY,
+		tags:                  TAGS,
+		transport:             TRANSPORT,
 	}
 }
 
@@ -247,6 +259,12 @@ func (g globals) parseConfig(config config) error {
 	g.loggingConf = config.Logging
 	g.amqBroker = config.Agent.Relay
 	g.apiURL = config.Agent.Api
+	if config.Agent.Transport != "" {
+		if err = transport.Validate(config.Agent.Transport); err != nil {
+			return fmt.Errorf("config.Agent.Transport %v", err)
+		}
+		g.transport = config.Agent.Transport
+	}
 	g.onlyVerifyPubKey = config.Agent.OnlyVerifyPubKey
 	if config.Agent.Proxies != "" {
 		g.proxies = strings.Split(config.Agent.Proxies, ",")
@@ -260,6 +278,12 @@ func (g globals) parseConfig(config config) error {
 	if err != nil {
 		return fmt.Errorf("config.Agent.ModuleTimeout %v", err)
 	}
+	if config.Agent.ACLRequiredSignatures < 0 {
+		return fmt.Errorf("config.Agent.ACLRequiredSignatures must be positive")
+	}
+	if config.Agent.ACLRequiredSignatures > 0 {
+		g.aclRequiredSignatures = config.Agent.ACLRequiredSignatures
+	}
 	g.statsMaxActions = config.Stats.MaxActions
 	if g.statsMaxActions > 30 || g.statsMaxActions < 0 {
 		return fmt.Errorf("config.Stats.MaxActions must be from 0 - 30")
@@ -316,8 +340,10 @@ func (g globals) apply() {
 	MODULETIMEOUT = g.moduleTimeout
 	ONLYVERIFYPUBKEY = g.onlyVerifyPubKey
 	STATSMAXACTIONS = g.statsMaxActions
+	ACLREQUIREDSIGNATURES = g.aclRequiredSignatures
 	CACERT = g.caCert
 	AGENTCERT = g.agentCert
 	AGENTKEY = g.agentKey
 	TAGS = g.tags
+	TRANSPORT = g.transport
 }
@@ -12,34 +12,41 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"sync"
 	"time"
 
-	"github.com/streadway/amqp"
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/mig-agent/agentcontext"
 	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/pgp"
 	"github.com/mozilla/mig/service"
+	"github.com/mozilla/mig/transport"
+	"github.com/streadway/amqp"
 )
 
 // Context contains all configuration variables as well as handlers for
 // logs and channels
 // Context is intended as a single structure that can be passed around easily.
 type Context struct {
-	ACL   mig.ACL
+	ACL   *aclState
 	Agent struct {
 		BinPath, RunDir, QueueLoc, Mode, UID string
 		Respawn                              bool
 		CheckIn                              bool
 
+		// ConfPath is the configuration file given to the agent, which
+		// persistent modules load to share its certificate
+		ConfPath string
+
 		// A lock must be obtained before reading these values.
 		Hostname  string
 		Env       mig.AgentEnv
@@ -73,16 +80,13 @@ type Context struct {
 		Port             int
 		// internal
 		UseTLS bool
-		conn   *amqp.Connection
-		Chan   *amqp.Channel
-		Bind   struct {
-			Queue, Key string
-			Chan       <-chan amqp.Delivery
-		}
 	}
-	OpID    float64       // ID of the current operation, used for tracking
-	Sleeper time.Duration // timer used when the agent has to sleep for a while
-	Socket  struct {
+	// Transport receives messages from the scheduler, and publishes heartbeats
+	// and results to it
+	Transport transport.AgentTransport
+	OpID      float64       // ID of the current operation, used for tracking
+	Sleeper   time.Duration // timer used when the agent has to sleep for a while
+	Socket    struct {
 		Bind string
 	}
 	Logging mig.Logging
@@ -203,6 +207,12 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 
 	// set the agent message queue location
 	ctx.Agent.QueueLoc = actx.QueueLoc
+	if TRANSPORT == transport.HTTPS {
+		ctx.Agent.QueueLoc, err = httpsQueueLoc(actx.QueueLoc)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	// daemonize if not in foreground mode
 	if !foreground {
@@ -232,6 +242,20 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 	}
 
 	connected := false
+	// the https transport polls the API and doesn't need a connection
+	if TRANSPORT == transport.HTTPS {
+		if len(PROXIES) > 0 {
+			ctx.Agent.Env.IsProxied = true
+			ctx.Agent.Env.Proxy = PROXIES[0]
+		}
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("Polling API %s for messages", APIURL)}.Debug()
+		ctx.Transport, err = newHTTPSTransport(ctx.Agent.QueueLoc, httpsPollWait)
+		if err != nil {
+			panic(err)
+		}
+		connected = true
+		goto mqdone
+	}
 	// connect to the message broker
 	//
 	// If any proxies have been configured, we try to use those first. If they fail, or
@@ -318,11 +342,30 @@ func initACL(orig_ctx Context) (ctx Context, err error) {
 		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("loading acls from %v", aclpath)}.Info()
 	}
 
-	err = json.Unmarshal([]byte(AGENTACL), &ctx.ACL)
+	ctx.ACL = new(aclState)
+	err = json.Unmarshal([]byte(AGENTACL), &ctx.ACL.acl)
 	if err != nil {
 		panic(err)
 	}
 
+	// a signed ACL bundle received previously takes precedence over the
+	// configured ACL, as long as its signatures are still valid
+	err = initACLKeyring(&ctx)
+	if err != nil {
+		panic(err)
+	}
+	b, hash, err := loadACLBundle(ctx.ACL.keys)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring acl bundle: %v", err)}.Warning()
+		}
+		err = nil
+		return
+	}
+	ctx.ACL.acl = b.ACL
+	ctx.ACL.hash = hash
+	ctx.ACL.timestamp = b.Timestamp
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("loading acls from bundle %q (%s)", b.Name, hash)}.Info()
 	return
 }
 
@@ -376,10 +419,6 @@ func initMQ(orig_ctx Context, try_proxy bool, proxy string) (ctx Context, err er
 		ctx.Channels.Log <- mig.Log{Desc: "leaving initMQ()"}.Debug()
 	}()
 
-	//Define the AMQP binding
-	ctx.MQ.Bind.Queue = fmt.Sprintf("mig.agt.%s", ctx.Agent.QueueLoc)
-	ctx.MQ.Bind.Key = fmt.Sprintf("mig.agt.%s", ctx.Agent.QueueLoc)
-
 	// parse the dial string and use TLS if using amqps
 	amqp_uri, err := amqp.ParseURI(AMQPBROKER)
 	if err != nil {
@@ -471,54 +510,84 @@ func initMQ(orig_ctx Context, try_proxy bool, proxy string) (ctx Context, err er
 	}
 	// Open AMQP connection
 	ctx.Channels.Log <- mig.Log{Desc: "Establishing connection to relay"}.Debug()
-	ctx.MQ.conn, err = amqp.DialConfig(AMQPBROKER, dialConfig)
+	conn, err := amqp.DialConfig(AMQPBROKER, dialConfig)
 	if err != nil {
 		ctx.Channels.Log <- mig.Log{Desc: "Connection failed"}.Debug()
 		panic(err)
 	}
 
-	ctx.MQ.Chan, err = ctx.MQ.conn.Channel()
+	// results are kept on the relay for ten heartbeats
+	ctx.Transport, err = transport.NewAMQPAgent(conn, ctx.Agent.QueueLoc, ctx.Sleeper*10, APIURL)
 	if err != nil {
+		conn.Close()
 		panic(err)
 	}
+	return
+}
 
-	// Limit the number of message the channel will receive at once
-	err = ctx.MQ.Chan.Qos(1, // prefetch count (in # of msg)
-		0,     // prefetch size (in bytes)
-		false) // is global
+// httpsPollWait is how long each poll of the API waits for new messages when the
+// agent uses the https transport
+const httpsPollWait = 30 * time.Second
 
-	_, err = ctx.MQ.Chan.QueueDeclare(ctx.MQ.Bind.Queue, // Queue name
-		true,  // is durable
-		false, // is autoDelete
-		false, // is exclusive
-		false, // is noWait
-		nil)   // AMQP args
-	if err != nil {
-		panic(err)
+// newHTTPSTransport returns the transport that polls the API for messages, waiting
+// up to wait in each poll. The agent authenticates to the API with the client
+// certificate it would present to the relay, and also trusts the CA certificate
+// of the relay to verify the API. The first configured proxy is used in place
+// of the environment.
+func newHTTPSTransport(queueLoc string, wait time.Duration) (t transport.AgentTransport, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("newHTTPSTransport() -> %v", e)
+		}
+	}()
+	proxy := http.ProxyFromEnvironment
+	if len(PROXIES) > 0 {
+		proxy = http.ProxyURL(&url.URL{Scheme: "http", Host: PROXIES[0]})
 	}
-
-	err = ctx.MQ.Chan.QueueBind(ctx.MQ.Bind.Queue, // Queue name
-		ctx.MQ.Bind.Key,      // Routing key name
-		mig.ExchangeToAgents, // Exchange name
-		false,                // is noWait
-		nil)                  // AMQP args
-	if err != nil {
-		panic(err)
+	TLSconfig := tls.Config{Rand: rand.Reader}
+	if len(AGENTCERT) > 0 {
+		cert, err := tls.X509KeyPair(AGENTCERT, AGENTKEY)
+		if err != nil {
+			panic(err)
+		}
+		TLSconfig.Certificates = []tls.Certificate{cert}
 	}
+	if len(CACERT) > 0 {
+		ca, err := x509.SystemCertPool()
+		if err != nil {
+			ca = x509.NewCertPool()
+		}
+		if ok := ca.AppendCertsFromPEM(CACERT); !ok {
+			panic("failed to import CA Certificate")
+		}
+		TLSconfig.RootCAs = ca
+	}
+	client := &http.Client{
+		Timeout: wait + 30*time.Second,
+		Transport: &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: &TLSconfig,
+		},
+	}
+	return transport.NewHTTPSAgent(client, APIURL, queueLoc, wait), nil
+}
 
-	// Consume AMQP message into channel
-	ctx.MQ.Bind.Chan, err = ctx.MQ.Chan.Consume(ctx.MQ.Bind.Queue, // queue name
-		"",    // some tag
-		false, // is autoAck
-		false, // is exclusive
-		false, // is noLocal
-		false, // is noWait
-		nil)   // AMQP args
+// httpsQueueLoc returns the queue location of an agent that uses the https
+// transport, which carries the common name of the agent certificate so the API
+// only serves the queue to this certificate
+func httpsQueueLoc(queueLoc string) (string, error) {
+	block, _ := pem.Decode(AGENTCERT)
+	if block == nil {
+		return "", fmt.Errorf("failed to decode the agent certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("failed to parse the agent certificate: %v", err)
 	}
-
-	return
+	if cert.Subject.CommonName == "" {
+		return "", fmt.Errorf("the agent certificate has no common name")
+	}
+	return transport.HTTPSQueueLoc(queueLoc, cert.Subject.CommonName), nil
 }
 
 func Destroy(ctx Context) (err error) {
@@ -526,7 +595,7 @@ func Destroy(ctx Context) (err error) {
 	close(ctx.Channels.RunAgentCommand)
 	close(ctx.Channels.RunExternalCommand)
 	close(ctx.Channels.Results)
-	ctx.MQ.conn.Close()
+	ctx.Transport.Close()
 	// give one second for the goroutines to close
 	time.Sleep(1 * time.Second)
 	close(ctx.Channels.Terminate)
@@ -165,7 +165,7 @@ func managePersistModule(ctx *Context, name string) {
 		if !isRunning {
 			logfunc("starting module")
 			lastPing = time.Now()
-			cmd = exec.Command(ctx.Agent.BinPath, "-P", strings.ToLower(name))
+			cmd = exec.Command(ctx.Agent.BinPath, "-c", ctx.Agent.ConfPath, "-P", strings.ToLower(name))
 			cmdpipeout, err := cmd.StdinPipe()
 			if err != nil {
 				logfunc("error creating stdin pipe, %v", err)
@@ -0,0 +1,327 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mozilla/mig"
+
+	"github.com/jvehent/cljs"
+)
+
+// Return an existing ACL bundle
+func getACLBundle(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getACLBundle()"}.Debug()
+	}()
+	b, found := aclBundleFromRequest(opid, request.URL.Query().Get("aclbundleid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	item, err := aclBundleToItem(b)
+	if err != nil {
+		panic(err)
+	}
+	resource.AddItem(item)
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// Add a new ACL bundle, the bundle is created in the staged status and becomes
+// active once it has been signed enough times
+func newACLBundle(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving newACLBundle()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var b mig.ACLBundle
+	err = json.Unmarshal([]byte(request.FormValue("aclbundle")), &b)
+	if err != nil {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid ACL bundle: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	b.Status = "staged"
+	err = b.Validate()
+	if err == nil {
+		err = checkTarget(b.Target)
+	}
+	if err != nil {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid ACL bundle: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	bid, err := ctx.DB.ACLBundleAdd(b)
+	if err != nil {
+		panic(err)
+	}
+	b, err = ctx.DB.GetACLBundleFromID(bid)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("ACL bundle %.0f created", bid)}
+	item, err := aclBundleToItem(b)
+	if err != nil {
+		panic(err)
+	}
+	resource.AddItem(item)
+	respond(http.StatusCreated, resource, respWriter, request)
+}
+
+// Add a signature to an existing ACL bundle
+func signACLBundle(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving signACLBundle()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	b, found := aclBundleFromRequest(opid, request.FormValue("aclbundleid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	sig := request.FormValue("signature")
+	if sig == "" {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "Invalid signature specified"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	err = ctx.DB.ACLBundleAddSignature(b.ID, sig, getInvID(request), ctx.ACLBundle.RequiredSignatures)
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// Change the status of an existing ACL bundle. A bundle can be marked as staged,
+// which clears its signatures, or disabled, after which its status can no longer
+// be changed.
+func statusACLBundle(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving statusACLBundle()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	b, found := aclBundleFromRequest(opid, request.FormValue("aclbundleid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	switch request.FormValue("status") {
+	case "staged":
+		err = ctx.DB.ACLBundleClearSignatures(b.ID)
+		if err != nil {
+			panic(err)
+		}
+		err = ctx.DB.ACLBundleUpdateStatus(b.ID, ctx.ACLBundle.RequiredSignatures)
+		if err != nil {
+			panic(err)
+		}
+	case "disabled":
+		err = ctx.DB.ACLBundleDisable(b.ID)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "Invalid status specified, must be disabled or staged"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// Return the active agents an ACL bundle applies to, and whether they have applied
+// it yet. Agents that are matched by a more recent active bundle are not included.
+func aclBundleAgents(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving aclBundleAgents()"}.Debug()
+	}()
+	b, found := aclBundleFromRequest(opid, request.URL.Query().Get("aclbundleid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	if b.Status != "active" {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("ACL bundle '%.0f' is not active", b.ID)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	hash, err := b.Hash()
+	if err != nil {
+		panic(err)
+	}
+	_, agents, err := ctx.DB.ACLBundleAgents()
+	if err != nil {
+		panic(err)
+	}
+	for _, agt := range agents[b.ID] {
+		resource.AddItem(cljs.Item{
+			Href: fmt.Sprintf("%s/agent?agentid=%.0f", ctx.Server.BaseURL, agt.ID),
+			Data: []cljs.Data{{Name: "aclbundle agent", Value: mig.ACLBundleAgent{
+				ID:       agt.ID,
+				Name:     agt.Name,
+				QueueLoc: agt.QueueLoc,
+				Status:   agt.Status,
+				ACLHash:  agt.ACLHash,
+				UpToDate: agt.ACLHash == hash,
+			}}},
+		})
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// This API entry point is used by the loader to retrieve the ACL bundle that applies
+// to the agent it manages, so it can be installed alongside the agent configuration.
+// The agent verifies the signatures on the bundle before using it.
+func getAgentACLBundle(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getAgentACLBundle()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var manifestParam mig.ManifestParameters
+	err = json.Unmarshal([]byte(request.FormValue("parameters")), &manifestParam)
+	if err != nil {
+		panic(err)
+	}
+	err = manifestParam.Validate()
+	if err != nil {
+		panic(err)
+	}
+	loaderid := getLoaderID(request)
+	if loaderid == 0 {
+		panic("Request has no valid loader ID")
+	}
+	err = ctx.DB.CompareLoaderExpectEnv(loaderid)
+	if err != nil {
+		panic(err)
+	}
+	bundles, agents, err := ctx.DB.ACLBundleAgents()
+	if err != nil {
+		panic(err)
+	}
+	for _, b := range bundles {
+		for _, agt := range agents[b.ID] {
+			if agt.QueueLoc != manifestParam.AgentIdentifier.QueueLoc {
+				continue
+			}
+			err = resource.AddItem(cljs.Item{
+				Href: request.URL.String(),
+				Data: []cljs.Data{{Name: "aclbundle", Value: b}},
+			})
+			if err != nil {
+				panic(err)
+			}
+			respond(http.StatusOK, resource, respWriter, request)
+			return
+		}
+	}
+	resource.SetError(cljs.Error{
+		Code:    fmt.Sprintf("%.0f", opid),
+		Message: "No active ACL bundle for this loader"})
+	respond(http.StatusNotFound, resource, respWriter, request)
+}
+
+// aclBundleFromRequest parses an ACL bundle ID and retrieves the bundle from the
+// database. If the ID is invalid or the bundle does not exist, an error is sent to
+// the client and found is false.
+func aclBundleFromRequest(opid float64, param string, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (b mig.ACLBundle, found bool) {
+	bid, err := strconv.ParseFloat(param, 64)
+	if err != nil || bid <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid ACL bundle ID '%s'", param)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	b, err = ctx.DB.GetACLBundleFromID(bid)
+	if err != nil {
+		if fmt.Sprintf("%v", err) == "Error while retrieving acl bundle: 'sql: no rows in result set'" {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("ACL bundle ID '%.0f' not found", bid)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	return b, true
+}
+
+func aclBundleToItem(b mig.ACLBundle) (item cljs.Item, err error) {
+	item.Href = fmt.Sprintf("%s/aclbundle?aclbundleid=%.0f", ctx.Server.BaseURL, b.ID)
+	item.Data = []cljs.Data{
+		{Name: "aclbundle", Value: b},
+	}
+	return
+}
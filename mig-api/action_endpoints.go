@@ -67,12 +67,33 @@ func createAction(respWriter http.ResponseWriter, request *http.Request) {
 	if err != nil {
 		panic(err)
 	}
+	err = checkTarget(action.Target)
+	if err != nil {
+		// bad request, return 400
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid action target: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
 	err = action.VerifySignatures(keyring)
 	if err != nil {
 		panic(err)
 	}
 	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: action.ID, Desc: "Received new action with valid signature"}
 
+	// actions that need approval, per the configuration of the api or because the
+	// investigator submitted them for approval, are held until they are co-signed
+	// by enough investigators, unless the signatures they carry are already sufficient
+	if approvalRequired(action) || request.FormValue("approval") == "true" {
+		action.Status = mig.ActionStatusPendingApproval
+		if verifyApproval(action) == nil {
+			action.Status = "pending"
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: action.ID,
+			Desc: fmt.Sprintf("Action submitted for approval, status is %s", action.Status)}
+	}
+
 	// write action to database
 	err = ctx.DB.InsertAction(action)
 	if err != nil {
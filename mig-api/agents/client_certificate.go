@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package agents
+
+import (
+	"net/http"
+)
+
+// RequireClientCertificate is an HTTP request handler that only passes requests
+// on to its handler if they were made over TLS with a client certificate that the
+// API verified. Agents that use the https transport authenticate with the same
+// certificate they present to the relay.
+type RequireClientCertificate struct {
+	handler http.Handler
+}
+
+// NewRequireClientCertificate constructs a new RequireClientCertificate that
+// protects handler.
+func NewRequireClientCertificate(handler http.Handler) RequireClientCertificate {
+	return RequireClientCertificate{
+		handler: handler,
+	}
+}
+
+func (auth RequireClientCertificate) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		response.Header().Set("Content-Type", "application/json")
+		respondMessagesError(response, http.StatusUnauthorized, "A valid agent certificate is required")
+		return
+	}
+	auth.handler.ServeHTTP(response, request)
+}
+
+// certificateIdentity returns the common name of the verified client certificate
+// of request, or an empty string if it has none
+func certificateIdentity(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 || len(request.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return request.TLS.VerifiedChains[0][0].Subject.CommonName
+}
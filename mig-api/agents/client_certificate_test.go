@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package agents
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireClientCertificate(t *testing.T) {
+	testCases := []struct {
+		Description    string
+		TLS            *tls.ConnectionState
+		ExpectedStatus int
+	}{
+		{
+			Description:    `Should get status 401 without TLS`,
+			TLS:            nil,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Description:    `Should get status 401 without a verified client certificate`,
+			TLS:            &tls.ConnectionState{},
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Description: `Should pass requests with a verified client certificate`,
+			TLS: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+			},
+			ExpectedStatus: http.StatusNoContent,
+		},
+	}
+
+	for caseNum, testCase := range testCases {
+		t.Logf("Running TestRequireClientCertificate case #%d: %s", caseNum, testCase.Description)
+
+		called := false
+		handler := NewRequireClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		request := httptest.NewRequest("GET", "/agent/messages?queueloc=linux.agent", nil)
+		request.TLS = testCase.TLS
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Errorf("Expected status %d but got %d", testCase.ExpectedStatus, recorder.Code)
+		}
+		if called != (testCase.ExpectedStatus == http.StatusNoContent) {
+			t.Errorf("Handler called is %v", called)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mozilla/mig/transport"
+)
+
+// maxPollWait is the longest an agent can wait for new messages in a single poll
+const maxPollWait = 60 * time.Second
+
+// maxResultSize is the largest command result an agent can upload
+const maxResultSize = 20 * 1024 * 1024
+
+// MessageQueue abstracts over operations that allow the MIG API to relay the
+// messages exchanged between the scheduler and agents that do not use a relay.
+// Messages stay in the queue of an agent until it acknowledges them.
+type MessageQueue interface {
+	PendingAgentMessages(queueLoc string, after float64) ([]transport.Message, error)
+	AckAgentMessages(queueLoc string, upto float64) error
+	QueueResult(body []byte) error
+}
+
+// PollMessages is an HTTP request handler that serves GET requests from agents
+// retrieving the messages sent to them. If no message is pending, the request is
+// held until one arrives or the wait duration requested by the agent elapses.
+//
+// Each poll acknowledges the messages the agent received from the previous one,
+// which are then removed from its queue. Agents can only poll the queue named
+// after their certificate.
+type PollMessages struct {
+	queue    MessageQueue
+	interval time.Duration
+}
+
+// NewPollMessages constructs a new PollMessages that checks the queue of the agent
+// for new messages every interval.
+func NewPollMessages(queue MessageQueue, interval time.Duration) PollMessages {
+	return PollMessages{
+		queue:    queue,
+		interval: interval,
+	}
+}
+
+// UploadResult is an HTTP request handler that serves POST requests containing
+// the results of a command encoded as JSON. The command must have been run by
+// an agent whose queue is named after the certificate of the request.
+type UploadResult struct {
+	queue MessageQueue
+}
+
+// NewUploadResult constructs a new UploadResult.
+func NewUploadResult(queue MessageQueue) UploadResult {
+	return UploadResult{
+		queue: queue,
+	}
+}
+
+type messagesErrorResponse struct {
+	Error *string `json:"error"`
+}
+
+func respondMessagesError(response http.ResponseWriter, status int, errMsg string) {
+	response.WriteHeader(status)
+	json.NewEncoder(response).Encode(&messagesErrorResponse{&errMsg})
+}
+
+func (handler PollMessages) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+
+	queueLoc := request.URL.Query().Get("queueloc")
+	if queueLoc == "" || len(queueLoc) > 2048 {
+		respondMessagesError(response, http.StatusBadRequest, "Missing or invalid queueloc parameter")
+		return
+	}
+	if !transport.HTTPSQueueOwner(queueLoc, certificateIdentity(request)) {
+		respondMessagesError(response, http.StatusForbidden, "The queue does not belong to the agent certificate")
+		return
+	}
+	ack := float64(0)
+	if a := request.URL.Query().Get("ack"); a != "" {
+		var err error
+		ack, err = strconv.ParseFloat(a, 64)
+		if err != nil || ack < 0 {
+			respondMessagesError(response, http.StatusBadRequest, fmt.Sprintf("Invalid ack parameter '%s'", a))
+			return
+		}
+	}
+	wait := time.Duration(0)
+	if w := request.URL.Query().Get("wait"); w != "" {
+		seconds, err := strconv.Atoi(w)
+		if err != nil || seconds < 0 {
+			respondMessagesError(response, http.StatusBadRequest, fmt.Sprintf("Invalid wait parameter '%s'", w))
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+
+	if ack > 0 {
+		err := handler.queue.AckAgentMessages(queueLoc, ack)
+		if err != nil {
+			respondMessagesError(response, http.StatusInternalServerError,
+				fmt.Sprintf("Failed to acknowledge messages: %s", err.Error()))
+			return
+		}
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		msgs, err := handler.queue.PendingAgentMessages(queueLoc, ack)
+		if err != nil {
+			respondMessagesError(response, http.StatusInternalServerError,
+				fmt.Sprintf("Failed to retrieve messages: %s", err.Error()))
+			return
+		}
+		if len(msgs) > 0 || !time.Now().Before(deadline) {
+			if msgs == nil {
+				msgs = []transport.Message{}
+			}
+			json.NewEncoder(response).Encode(msgs)
+			return
+		}
+		select {
+		case <-request.Context().Done():
+			return
+		case <-time.After(handler.interval):
+		}
+	}
+}
+
+func (handler UploadResult) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	defer request.Body.Close()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(response, request.Body, maxResultSize))
+	if err != nil {
+		respondMessagesError(response, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %s", err.Error()))
+		return
+	}
+	// the results are only stored here, they are parsed and validated by the
+	// scheduler like the ones received from the relay
+	if len(body) < 10 || !json.Valid(body) || body[0] != '{' {
+		respondMessagesError(response, http.StatusBadRequest, "Request body is not a valid command result")
+		return
+	}
+	var result struct {
+		Agent struct {
+			QueueLoc string `json:"queueloc"`
+		} `json:"agent"`
+	}
+	if json.Unmarshal(body, &result) != nil {
+		respondMessagesError(response, http.StatusBadRequest, "Request body is not a valid command result")
+		return
+	}
+	if !transport.HTTPSQueueOwner(result.Agent.QueueLoc, certificateIdentity(request)) {
+		respondMessagesError(response, http.StatusForbidden, "The command was not run by the agent of the certificate")
+		return
+	}
+	err = handler.queue.QueueResult(body)
+	if err != nil {
+		respondMessagesError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to save result: %s", err.Error()))
+		return
+	}
+	json.NewEncoder(response).Encode(&messagesErrorResponse{nil})
+}
@@ -0,0 +1,55 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package agents
+
+import (
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/transport"
+)
+
+// maximum number of messages returned to an agent in a single poll
+const messagesPerPoll = 50
+
+// MessageQueuePostgres implements MessageQueue using the agentmessages table
+// of the database, which the scheduler reads and writes on its side. It works
+// with any backend implementing database.AgentMessageStore.
+type MessageQueuePostgres struct {
+	db               migdb.AgentMessageStore
+	resultExpiration time.Duration
+}
+
+// NewMessageQueuePostgres constructs a new MessageQueuePostgres. Results that
+// are not collected by the scheduler within resultExpiration are discarded.
+func NewMessageQueuePostgres(db migdb.AgentMessageStore, resultExpiration time.Duration) MessageQueuePostgres {
+	return MessageQueuePostgres{
+		db:               db,
+		resultExpiration: resultExpiration,
+	}
+}
+
+func (queue MessageQueuePostgres) PendingAgentMessages(queueLoc string, after float64) ([]transport.Message, error) {
+	stored, err := queue.db.PendingAgentMessages(transport.AgentQueue(queueLoc), after, messagesPerPoll)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]transport.Message, 0, len(stored))
+	for _, m := range stored {
+		msgs = append(msgs, transport.Message{ID: m.ID, Type: m.Type, Body: m.Body})
+	}
+	return msgs, nil
+}
+
+func (queue MessageQueuePostgres) AckAgentMessages(queueLoc string, upto float64) error {
+	return queue.db.AckAgentMessages(transport.AgentQueue(queueLoc), upto)
+}
+
+func (queue MessageQueuePostgres) QueueResult(body []byte) error {
+	return queue.db.InsertAgentMessage(mig.QueueAgentResults,
+		migdb.AgentMessage{Body: body},
+		time.Now().Add(queue.resultExpiration))
+}
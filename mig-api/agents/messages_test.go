@@ -0,0 +1,242 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package agents
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig/transport"
+)
+
+type MockMessageQueue struct {
+	TakeFn  func(string) ([]transport.Message, error)
+	AckFn   func(string, float64) error
+	QueueFn func([]byte) error
+}
+
+func (mock MockMessageQueue) PendingAgentMessages(queueLoc string, after float64) ([]transport.Message, error) {
+	return mock.TakeFn(queueLoc)
+}
+
+func (mock MockMessageQueue) AckAgentMessages(queueLoc string, upto float64) error {
+	return mock.AckFn(queueLoc, upto)
+}
+
+func (mock MockMessageQueue) QueueResult(body []byte) error {
+	return mock.QueueFn(body)
+}
+
+// agentRequest returns a request made with a verified client certificate whose
+// common name is identity
+func agentRequest(method, target, identity string, body io.Reader) *http.Request {
+	request := httptest.NewRequest(method, target, body)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return request
+}
+
+func TestPollMessages(t *testing.T) {
+	testCases := []struct {
+		Description    string
+		Query          string
+		Identity       string
+		ExpectedStatus int
+		ExpectedCount  int
+		ExpectedAck    float64
+		TakeFn         func(string) ([]transport.Message, error)
+	}{
+		{
+			Description:    `Should return pending messages`,
+			Query:          "queueloc=linux.agent.agent1",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusOK,
+			ExpectedCount:  2,
+			TakeFn: func(queueLoc string) ([]transport.Message, error) {
+				if queueLoc != "linux.agent.agent1" {
+					return nil, errors.New("wrong queue")
+				}
+				return []transport.Message{{ID: 1, Body: []byte("a")}, {ID: 2, Type: "aclbundle", Body: []byte("b")}}, nil
+			},
+		},
+		{
+			Description:    `Should return an empty list when nothing is pending`,
+			Query:          "queueloc=linux.agent.agent1&wait=0",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusOK,
+			ExpectedCount:  0,
+			TakeFn: func(_ string) ([]transport.Message, error) {
+				return nil, nil
+			},
+		},
+		{
+			Description:    `Should wait for a message to arrive`,
+			Query:          "queueloc=linux.agent.agent1&wait=5",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusOK,
+			ExpectedCount:  1,
+			TakeFn: func() func(string) ([]transport.Message, error) {
+				calls := 0
+				return func(_ string) ([]transport.Message, error) {
+					calls++
+					if calls < 3 {
+						return nil, nil
+					}
+					return []transport.Message{{ID: 1, Body: []byte("a")}}, nil
+				}
+			}(),
+		},
+		{
+			Description:    `Should acknowledge the messages received from the previous poll`,
+			Query:          "queueloc=linux.agent.agent1&ack=42",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusOK,
+			ExpectedAck:    42,
+			TakeFn: func(_ string) ([]transport.Message, error) {
+				return nil, nil
+			},
+		},
+		{
+			Description:    `Should get status 400 without a queueloc`,
+			Query:          "wait=1",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Description:    `Should get status 400 with an invalid wait`,
+			Query:          "queueloc=linux.agent.agent1&wait=soon",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Description:    `Should get status 400 with an invalid ack`,
+			Query:          "queueloc=linux.agent.agent1&ack=-1",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Description:    `Should get status 403 for the queue of another agent`,
+			Query:          "queueloc=linux.agent.agent1",
+			Identity:       "agent2",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Description:    `Should get status 403 for a certificate without a common name`,
+			Query:          "queueloc=linux.agent.",
+			Identity:       "",
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Description:    `Should get status 500 if retrieving messages fails`,
+			Query:          "queueloc=linux.agent.agent1",
+			Identity:       "agent1",
+			ExpectedStatus: http.StatusInternalServerError,
+			TakeFn: func(_ string) ([]transport.Message, error) {
+				return nil, errors.New("database is down")
+			},
+		},
+	}
+
+	for caseNum, testCase := range testCases {
+		t.Logf("Running TestPollMessages case #%d: %s", caseNum, testCase.Description)
+
+		var acked float64
+		handler := NewPollMessages(MockMessageQueue{
+			TakeFn: testCase.TakeFn,
+			AckFn: func(queueLoc string, upto float64) error {
+				if queueLoc != "linux.agent.agent1" {
+					return errors.New("wrong queue")
+				}
+				acked = upto
+				return nil
+			},
+		}, 10*time.Millisecond)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, agentRequest("GET", "/agent/messages?"+testCase.Query, testCase.Identity, nil))
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Errorf("Expected status %d but got %d", testCase.ExpectedStatus, recorder.Code)
+		}
+		if acked != testCase.ExpectedAck {
+			t.Errorf("Expected messages to be acknowledged up to %.0f, got %.0f", testCase.ExpectedAck, acked)
+		}
+		if recorder.Code == http.StatusOK {
+			var msgs []transport.Message
+			err := json.NewDecoder(recorder.Body).Decode(&msgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msgs == nil || len(msgs) != testCase.ExpectedCount {
+				t.Errorf("Expected %d messages but got %v", testCase.ExpectedCount, msgs)
+			}
+			for _, m := range msgs {
+				if m.ID == 0 {
+					t.Errorf("Message %v has no ID to acknowledge", m)
+				}
+			}
+		}
+	}
+}
+
+func TestUploadResult(t *testing.T) {
+	testCases := []struct {
+		Description    string
+		RequestBody    string
+		ExpectedStatus int
+		QueueFn        func([]byte) error
+	}{
+		{
+			Description:    `Should get status 200 if queueing succeeds`,
+			RequestBody:    `{"id": 1234, "status": "success", "agent": {"queueloc": "linux.agent.agent1"}}`,
+			ExpectedStatus: http.StatusOK,
+			QueueFn: func(_ []byte) error {
+				return nil
+			},
+		},
+		{
+			Description:    `Should get status 400 if the body is not a json object`,
+			RequestBody:    `["id", 1234, "status"]`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Description:    `Should get status 400 if the body is not valid json`,
+			RequestBody:    `{"id": 1234, "status": `,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Description:    `Should get status 403 if the command was run by another agent`,
+			RequestBody:    `{"id": 1234, "status": "success", "agent": {"queueloc": "linux.agent.agent2"}}`,
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Description:    `Should get status 500 if queueing fails`,
+			RequestBody:    `{"id": 1234, "status": "success", "agent": {"queueloc": "linux.agent.agent1"}}`,
+			ExpectedStatus: http.StatusInternalServerError,
+			QueueFn: func(_ []byte) error {
+				return errors.New("database is down")
+			},
+		},
+	}
+
+	for caseNum, testCase := range testCases {
+		t.Logf("Running TestUploadResult case #%d: %s", caseNum, testCase.Description)
+
+		handler := NewUploadResult(MockMessageQueue{QueueFn: testCase.QueueFn})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, agentRequest("POST", "/agent/results", "agent1",
+			strings.NewReader(testCase.RequestBody)))
+		if recorder.Code != testCase.ExpectedStatus {
+			t.Errorf("Expected status %d but got %d", testCase.ExpectedStatus, recorder.Code)
+		}
+	}
+}
@@ -13,11 +13,13 @@ import (
 	migdb "github.com/mozilla/mig/database"
 )
 
+// PersistHeartbeatPostgres stores heartbeats in the agents table of the
+// database. It works with any backend implementing database.AgentStore.
 type PersistHeartbeatPostgres struct {
-	db *migdb.DB
+	db migdb.AgentStore
 }
 
-func NewPersistHeartbeatPostgres(db *migdb.DB) PersistHeartbeatPostgres {
+func NewPersistHeartbeatPostgres(db migdb.AgentStore) PersistHeartbeatPostgres {
 	return PersistHeartbeatPostgres{
 		db: db,
 	}
@@ -36,7 +38,7 @@ func (persist PersistHeartbeatPostgres) PersistHeartbeat(heartbeat Heartbeat) er
 		agent.DestructionTime = time.Date(9998, time.January, 11, 11, 11, 11, 11, time.UTC)
 		agent.Status = mig.AgtStatusOnline
 		agent.StartTime = time.Now()
-		return persist.db.InsertAgent(agent, nil)
+		return persist.db.InsertAgent(agent)
 	}
 
 	agent.Status = mig.AgtStatusOnline
@@ -75,6 +75,7 @@ type Heartbeat struct {
 	RefreshTime time.Time   `json:"refreshTime"`
 	Environment Environment `json:"environment"`
 	Tags        []Tag       `json:"tags"`
+	ACLHash     string      `json:"aclHash"`
 }
 
 type uploadHeartbeatResponse struct {
@@ -87,16 +88,15 @@ type uploadHeartbeatResponse struct {
 // zero values (such as "" for strings) instead of erroring, which is not what we want.
 func (hb Heartbeat) validate() error {
 	missingFields := map[string]bool{
-		"name":                 hb.Name == "",
-		"mode":                 hb.Mode == "",
-		"version":              hb.Version == "",
-		"queueLoc":             hb.QueueLoc == "",
-		"pid":                  hb.PID == 0,
-		"environment.init":     hb.Environment.Init == "",
-		"environment.ident":    hb.Environment.Ident == "",
-		"environment.os":       hb.Environment.OS == "",
-		"environment.arch":     hb.Environment.Arch == "",
-		"environment.publicIP": hb.Environment.PublicIP == "",
+		"name":              hb.Name == "",
+		"mode":              hb.Mode == "",
+		"version":           hb.Version == "",
+		"queueLoc":          hb.QueueLoc == "",
+		"pid":               hb.PID == 0,
+		"environment.init":  hb.Environment.Init == "",
+		"environment.ident": hb.Environment.Ident == "",
+		"environment.os":    hb.Environment.OS == "",
+		"environment.arch":  hb.Environment.Arch == "",
 	}
 
 	missing := []string{}
@@ -205,6 +205,7 @@ func (hb Heartbeat) ToMigAgent() mig.Agent {
 			PublicIP:  hb.Environment.PublicIP,
 			Modules:   hb.Environment.Modules,
 		},
-		Tags: tags,
+		Tags:    tags,
+		ACLHash: hb.ACLHash,
 	}
 }
@@ -57,6 +57,33 @@ func TestUploadHeartbeat(t *testing.T) {
           "modules": []
         },
         "tags": []
+      }`, time.Now().Format(time.RFC3339)),
+			PersistFn: func(_ Heartbeat) error { return nil },
+			AuthFn:    func(_ Heartbeat) error { return nil },
+		},
+		{
+			Description:    `Should get status 200 if the agent doesn't know its public IP`,
+			ShouldError:    false,
+			ExpectedStatus: http.StatusOK,
+			RequestBody: fmt.Sprintf(`{
+        "name": "name",
+        "mode": "daemon",
+        "version": "version",
+        "pid": 3210,
+        "queueLoc": "loc",
+        "startTime": "%s",
+        "environment": {
+          "init": "init",
+          "ident": "ident",
+          "os": "os",
+          "arch": "arch",
+          "isProxied": false,
+          "proxy": "",
+          "addresses": [],
+          "publicIP": "",
+          "modules": []
+        },
+        "tags": []
       }`, time.Now().Format(time.RFC3339)),
 			PersistFn: func(_ Heartbeat) error { return nil },
 			AuthFn:    func(_ Heartbeat) error { return nil },
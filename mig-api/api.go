@@ -6,6 +6,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
@@ -33,6 +35,7 @@ func main() {
 	var config = flag.String("c", "/etc/mig/api.cfg", "Load configuration from file")
 	var debug = flag.Bool("d", false, "Debug mode: run in foreground, log to stdout.")
 	var showversion = flag.Bool("V", false, "Show build version and exit")
+	var migrate = flag.Bool("migrate", false, "Apply pending database schema migrations and exit")
 	flag.Parse()
 
 	if *showversion {
@@ -40,6 +43,19 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *migrate {
+		applied, err := Migrate(*config)
+		if err != nil {
+			fmt.Printf("FATAL: %v\n", err)
+			os.Exit(9)
+		}
+		for _, m := range applied {
+			fmt.Printf("applied migration %d: %s\n", m.Version, m.Description)
+		}
+		fmt.Println("database schema is up to date")
+		os.Exit(0)
+	}
+
 	// The context initialization takes care of parsing the configuration,
 	// and creating connections to database, syslog, ...
 	fmt.Fprintf(os.Stderr, "Initializing API context...")
@@ -72,11 +88,21 @@ func main() {
 	s := r.PathPrefix(ctx.Server.BaseRoute).Subrouter()
 
 	postHeartbeat := agents.NewUploadHeartbeat(
-		agents.NewPersistHeartbeatPostgres(&ctx.DB),
+		agents.NewPersistHeartbeatPostgres(ctx.DB),
 		agents.NewNilAuthenticator())
 
+	// results uploaded by agents expire after an hour if the scheduler
+	// doesn't collect them, as they would on the relay
+	agentMessages := agents.NewMessageQueuePostgres(ctx.DB, time.Hour)
+
 	// Endpoints that replace previously direct-to-rabbitmq communications.
+	// Agents must present a client certificate signed by the client CA to
+	// retrieve their messages and upload results.
 	s.Handle("/heartbeat", postHeartbeat).Methods("POST")
+	s.Handle("/agent/messages", agents.NewRequireClientCertificate(
+		agents.NewPollMessages(agentMessages, time.Second))).Methods("GET")
+	s.Handle("/agent/results", agents.NewRequireClientCertificate(
+		agents.NewUploadResult(agentMessages))).Methods("POST")
 
 	// unauthenticated endpoints
 	s.HandleFunc("/heartbeat", getHeartbeat).Methods("GET")
@@ -89,6 +115,8 @@ func main() {
 		authenticateLoader(getAgentManifest)).Methods("POST")
 	s.HandleFunc("/manifest/fetch/",
 		authenticateLoader(getManifestFile)).Methods("POST")
+	s.HandleFunc("/aclbundle/agent/",
+		authenticateLoader(getAgentACLBundle)).Methods("POST")
 
 	// Investigator resources that require authentication
 	s.HandleFunc("/search",
@@ -97,6 +125,16 @@ func main() {
 		authenticate(getAction, mig.PermAction)).Methods("GET")
 	s.HandleFunc("/action/create/",
 		authenticate(createAction, mig.PermActionCreate)).Methods("POST")
+	s.HandleFunc("/action/approval/",
+		authenticate(listApprovals, mig.PermAction)).Methods("GET")
+	s.HandleFunc("/action/approval/signingstring",
+		authenticate(getApprovalString, mig.PermAction)).Methods("GET")
+	s.HandleFunc("/action/approval/sign/",
+		authenticate(signApproval, mig.PermActionApprove)).Methods("POST")
+	s.HandleFunc("/action/approval/reject/",
+		authenticate(rejectApproval, mig.PermActionApprove)).Methods("POST")
+	s.HandleFunc("/action/stream",
+		authenticate(streamActionResults, mig.PermCommand)).Methods("GET")
 	s.HandleFunc("/command",
 		authenticate(getCommand, mig.PermCommand)).Methods("GET")
 	s.HandleFunc("/agent",
@@ -125,19 +163,49 @@ func main() {
 		authenticate(newManifest, mig.PermManifestNew)).Methods("POST")
 	s.HandleFunc("/manifest/loaders/",
 		authenticate(manifestLoaders, mig.PermManifestLoaders)).Methods("GET")
+	s.HandleFunc("/aclbundle",
+		authenticate(getACLBundle, mig.PermACLBundle)).Methods("GET")
+	s.HandleFunc("/aclbundle/sign/",
+		authenticate(signACLBundle, mig.PermACLBundleSign)).Methods("POST")
+	s.HandleFunc("/aclbundle/status/",
+		authenticate(statusACLBundle, mig.PermACLBundleStatus)).Methods("POST")
+	s.HandleFunc("/aclbundle/new/",
+		authenticate(newACLBundle, mig.PermACLBundleNew)).Methods("POST")
+	s.HandleFunc("/aclbundle/agents/",
+		authenticate(aclBundleAgents, mig.PermACLBundle)).Methods("GET")
 	s.HandleFunc("/investigator",
 		authenticate(getInvestigator, mig.PermInvestigator)).Methods("GET")
 	s.HandleFunc("/investigator/create/",
 		authenticate(createInvestigator, mig.PermInvestigatorCreate)).Methods("POST")
 	s.HandleFunc("/investigator/update/",
 		authenticate(updateInvestigator, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/modulepermission",
+		authenticate(getModulePermission, mig.PermModulePermission)).Methods("GET")
+	s.HandleFunc("/modulepermission/requirement/",
+		authenticate(setModuleRequirement, mig.PermModulePermissionSet)).Methods("POST")
+	s.HandleFunc("/modulepermission/investigator/",
+		authenticate(setModulePermission, mig.PermModulePermissionSet)).Methods("POST")
 
 	ctx.Channels.Log <- mig.Log{Desc: "Starting HTTP handler"}
 
 	// all set, start the http handler
 	http.Handle("/", context.ClearHandler(r))
 	listenAddr := fmt.Sprintf("%s:%d", ctx.Server.IP, ctx.Server.Port)
-	err = http.ListenAndServe(listenAddr, nil)
+	if ctx.Server.clientCAs == nil {
+		ctx.Channels.Log <- mig.Log{Desc: "clientca is not set, agents using the https transport cannot authenticate"}.Warning()
+	}
+	if ctx.Server.TLSCert != "" {
+		server := &http.Server{Addr: listenAddr, TLSConfig: &tls.Config{}}
+		if ctx.Server.clientCAs != nil {
+			// client certificates are optional at the TLS level, investigators
+			// authenticate with their PGP keys or API keys instead
+			server.TLSConfig.ClientCAs = ctx.Server.clientCAs
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		err = server.ListenAndServeTLS(ctx.Server.TLSCert, ctx.Server.TLSKey)
+	} else {
+		err = http.ListenAndServe(listenAddr, nil)
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,261 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/pgp"
+)
+
+// verifyApproval returns nil if the signatures of action a satisfy the approval
+// requirements set in the configuration. Only the signatures of investigators
+// that hold the permission to approve actions are counted, whether they were
+// attached when the action was submitted or added through the sign endpoint.
+func verifyApproval(a mig.Action) error {
+	keyring, err := getKeyring()
+	if err != nil {
+		return err
+	}
+	return a.VerifyApproval(ctx.Approval.acl, ctx.Approval.MinimumWeight, keyring, canApprove)
+}
+
+// canApprove returns true if the investigator with PGP fingerprint fp has the
+// permission to approve actions
+func canApprove(fp string) bool {
+	inv, err := ctx.DB.InvestigatorByFingerprint(fp)
+	if err != nil {
+		return false
+	}
+	return inv.CheckPermission(mig.PermActionApprove)
+}
+
+// approvalRequired returns true if the configuration of the API requires action
+// a to be approved before it is given to the scheduler, either because all
+// actions need approval or because it runs a module that does
+func approvalRequired(a mig.Action) bool {
+	if ctx.Approval.Required {
+		return true
+	}
+	for _, op := range a.Operations {
+		for _, m := range ctx.Approval.Module {
+			if op.Module == m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listApprovals returns the actions pending approval
+func listApprovals(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving listApprovals()"}.Debug()
+	}()
+	actions, err := ctx.DB.ActionsPendingApproval()
+	if err != nil {
+		panic(err)
+	}
+	for _, a := range actions {
+		a.Investigators, err = ctx.DB.InvestigatorByActionID(a.ID)
+		if err != nil {
+			panic(err)
+		}
+		item, err := actionToItem(a, false, ctx)
+		if err != nil {
+			panic(err)
+		}
+		resource.AddItem(item)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// getApprovalString returns an action pending approval along with the string
+// investigators must sign to approve it
+func getApprovalString(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getApprovalString()"}.Debug()
+	}()
+	a, found := approvalFromRequest(opid, request.URL.Query().Get("actionid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	astr, err := a.String()
+	if err != nil {
+		panic(err)
+	}
+	item, err := actionToItem(a, false, ctx)
+	if err != nil {
+		panic(err)
+	}
+	item.Data = append(item.Data, cljs.Data{Name: "signing string", Value: astr})
+	resource.AddItem(item)
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// signApproval adds the signature of an investigator to an action pending approval.
+// Once the signatures of the action satisfy the approval requirements, the action
+// becomes pending and is picked up by the scheduler.
+func signApproval(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving signApproval()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	a, found := approvalFromRequest(opid, request.FormValue("actionid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	sig := request.FormValue("signature")
+	astr, err := a.String()
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := getKeyring()
+	if err != nil {
+		panic(err)
+	}
+	fp, err := pgp.GetFingerprintFromSignature(astr, sig, keyring)
+	if err != nil {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid signature: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	inv, err := ctx.DB.InvestigatorByFingerprint(fp)
+	if err != nil {
+		panic(err)
+	}
+	for _, x := range a.Investigators {
+		if x.ID == inv.ID {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Action already signed by investigator '%s'", inv.Name)})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+	}
+
+	status := mig.ActionStatusPendingApproval
+	signed := a
+	signed.PGPSignatures = append(append([]string{}, a.PGPSignatures...), sig)
+	if verifyApproval(signed) == nil {
+		status = "pending"
+	}
+	err = ctx.DB.AddApprovalSignature(a, inv.ID, sig, status)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: a.ID,
+		Desc: fmt.Sprintf("Action signed by investigator %.0f for approval, status is %s", inv.ID, status)}
+
+	signed.Status = status
+	signed.Investigators = append(a.Investigators, inv)
+	item, err := actionToItem(signed, false, ctx)
+	if err != nil {
+		panic(err)
+	}
+	resource.AddItem(item)
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// rejectApproval rejects an action pending approval, which will then never run
+func rejectApproval(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving rejectApproval()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	a, found := approvalFromRequest(opid, request.FormValue("actionid"), resource, respWriter, request)
+	if !found {
+		return
+	}
+	err = ctx.DB.RejectAction(a.ID)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: a.ID,
+		Desc: fmt.Sprintf("Action rejected by investigator %.0f", getInvID(request))}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// approvalFromRequest parses an action ID and retrieves the action and the investigators
+// who signed it from the database. If the ID is invalid, the action does not exist or it
+// is not pending approval, an error is sent to the client and found is false.
+func approvalFromRequest(opid float64, param string, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (a mig.Action, found bool) {
+	aid, err := strconv.ParseFloat(param, 64)
+	if err != nil || aid < 1 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Action ID '%s'", param)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	a, err = ctx.DB.ActionByID(aid)
+	if err != nil {
+		if a.ID == -1 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Action ID '%.0f' not found", aid)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	if a.Status != mig.ActionStatusPendingApproval {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Action ID '%.0f' is not pending approval (status %s)", aid, a.Status)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	a.Investigators, err = ctx.DB.InvestigatorByActionID(a.ID)
+	if err != nil {
+		panic(err)
+	}
+	return a, true
+}
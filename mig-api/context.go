@@ -6,9 +6,12 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"gopkg.in/gcfg.v1"
 	"io"
+	"io/ioutil"
 	"github.com/mozilla/mig"
 	migdb "github.com/mozilla/mig/database"
 	"os"
@@ -22,6 +25,16 @@ import (
 // database and logging. It also contains some statistics.
 // Context is intended as a single structure that can be passed around easily.
 type Context struct {
+	ACLBundle struct {
+		RequiredSignatures int
+	}
+	Approval struct {
+		Required      bool
+		Module        []string
+		MinimumWeight int
+		ACL           string
+		acl           mig.ACL
+	}
 	Authentication struct {
 		Enabled       bool
 		TokenDuration string
@@ -30,7 +43,7 @@ type Context struct {
 	Channels struct {
 		Log chan mig.Log
 	}
-	DB      migdb.DB
+	DB      migdb.Store
 	Keyring struct {
 		Reader     io.ReadSeeker
 		Mutex      sync.Mutex
@@ -43,12 +56,25 @@ type Context struct {
 		Host, User, Password, DBName, SSLMode string
 		Port, MaxConn                         int
 	}
+	SQLite struct {
+		Path string
+	}
 	Server struct {
 		IP                       string
 		Port                     int
 		Host, BaseRoute, BaseURL string
 		ClientPublicIP           string
 		ClientPublicIPOffset     int
+		TLSCert, TLSKey          string
+		ClientCA                 string
+		clientCAs                *x509.CertPool
+	}
+	Stream struct {
+		PollInterval string
+		pollInterval time.Duration
+	}
+	Targets struct {
+		AllowRawSQL bool
 	}
 	Logging mig.Logging
 }
@@ -84,6 +110,22 @@ func Init(path string, debug bool) (ctx Context, err error) {
 		panic(err)
 	}
 
+	// Agents that use the https transport authenticate with their client
+	// certificate, which requires the API to terminate TLS itself
+	if ctx.Server.ClientCA != "" {
+		if ctx.Server.TLSCert == "" || ctx.Server.TLSKey == "" {
+			panic("clientca requires tlscert and tlskey to be set")
+		}
+		buf, err := ioutil.ReadFile(ctx.Server.ClientCA)
+		if err != nil {
+			panic(err)
+		}
+		ctx.Server.clientCAs = x509.NewCertPool()
+		if !ctx.Server.clientCAs.AppendCertsFromPEM(buf) {
+			panic("failed to import client CA certificate")
+		}
+	}
+
 	if debug {
 		ctx.Logging.Level = "debug"
 		ctx.Logging.Mode = "stdout"
@@ -96,6 +138,36 @@ func Init(path string, debug bool) (ctx Context, err error) {
 	if ctx.Manifest.RequiredSignatures < 1 {
 		panic("manifest:requiredsignatures must be at least 1 in config file")
 	}
+	// ACL bundles use the same signature threshold as manifests unless
+	// one is set in the aclbundle section
+	if ctx.ACLBundle.RequiredSignatures < 1 {
+		ctx.ACLBundle.RequiredSignatures = ctx.Manifest.RequiredSignatures
+	}
+
+	// Actions submitted for approval need a second investigator by default
+	if ctx.Approval.MinimumWeight < 1 {
+		ctx.Approval.MinimumWeight = 2
+	}
+	if ctx.Approval.ACL != "" {
+		buf, err := ioutil.ReadFile(ctx.Approval.ACL)
+		if err != nil {
+			panic(err)
+		}
+		err = json.Unmarshal(buf, &ctx.Approval.acl)
+		if err != nil {
+			panic(fmt.Sprintf("invalid approval acl: %v", err))
+		}
+	}
+
+	// Result streams check the database for completed commands every second
+	// unless configured otherwise
+	if ctx.Stream.PollInterval == "" {
+		ctx.Stream.PollInterval = "1s"
+	}
+	ctx.Stream.pollInterval, err = time.ParseDuration(ctx.Stream.PollInterval)
+	if err != nil {
+		panic(err)
+	}
 
 	ctx, err = initDB(ctx)
 	if err != nil {
@@ -105,7 +177,8 @@ func Init(path string, debug bool) (ctx Context, err error) {
 	return
 }
 
-// initDB() sets up the connection to the MongoDB backend database
+// initDB sets up the connection to the backend database, and verifies its
+// schema is at the version this release expects
 func initDB(orig_ctx Context) (ctx Context, err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -114,18 +187,64 @@ func initDB(orig_ctx Context) (ctx Context, err error) {
 		ctx.Channels.Log <- mig.Log{Desc: "leaving initDB()"}.Debug()
 	}()
 
-	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
 	ctx = orig_ctx
-	ctx.DB, err = migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
-		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
+	db, err := openDB(ctx)
 	if err != nil {
 		panic(err)
 	}
-	ctx.DB.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		db.Close()
+		panic(err)
+	}
+	ctx.DB = db
 	ctx.Channels.Log <- mig.Log{Desc: "Database connection opened"}
 	return
 }
 
+// openDB opens the backend database, which is the SQLite database at SQLite.Path
+// if it is set, and Postgres otherwise
+func openDB(ctx Context) (db migdb.Store, err error) {
+	if ctx.SQLite.Path != "" {
+		fmt.Fprintf(os.Stdout, "Attempting to open sqlite database...")
+		return openSQLite(ctx.SQLite.Path)
+	}
+	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
+	pgdb, err := migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
+		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
+	if err != nil {
+		return
+	}
+	pgdb.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	pgdb.AllowRawSQLTargets(ctx.Targets.AllowRawSQL)
+	return &pgdb, nil
+}
+
+// Migrate applies the pending migrations to the database of configuration
+// file path. With Postgres, the configured user must own the schema.
+func Migrate(path string) (applied []migdb.Migration, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("Migrate() -> %v", e)
+		}
+	}()
+	var ctx Context
+	err = gcfg.ReadFileInto(&ctx, path)
+	if err != nil {
+		panic(err)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	applied, err = db.Migrate()
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
 func parseClientPublicIP(s string) (ret int, err error) {
 	defer func() {
 		if e := recover(); e != nil {
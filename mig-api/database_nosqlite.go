@@ -0,0 +1,18 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !sqlite
+
+package main
+
+import (
+	"fmt"
+
+	migdb "github.com/mozilla/mig/database"
+)
+
+// openSQLite fails, as SQLite support requires building with the sqlite tag
+func openSQLite(path string) (migdb.Store, error) {
+	return nil, fmt.Errorf("cannot open %s: built without sqlite support, rebuild with the sqlite build tag", path)
+}
@@ -0,0 +1,266 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mozilla/mig"
+
+	"github.com/jvehent/cljs"
+)
+
+// getModulePermission returns the module requirements and module permissions set on
+// an agent if agentid is given, or the module permissions of an investigator if
+// investigatorid is given
+func getModulePermission(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getModulePermission()"}.Debug()
+	}()
+	var (
+		reqs  []mig.ModuleRequirement
+		perms []mig.ModulePermission
+	)
+	agentid := request.URL.Query().Get("agentid")
+	investigatorid := request.URL.Query().Get("investigatorid")
+	switch {
+	case agentid != "":
+		aid, err := strconv.ParseFloat(agentid, 64)
+		if err != nil || aid <= 0 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid Agent ID '%s'", agentid)})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+		reqs, err = ctx.DB.ModuleRequirementsByAgent(aid)
+		if err != nil {
+			panic(err)
+		}
+		perms, err = ctx.DB.ModulePermissionsByAgent(aid)
+		if err != nil {
+			panic(err)
+		}
+	case investigatorid != "":
+		iid, err := strconv.ParseFloat(investigatorid, 64)
+		if err != nil || iid <= 0 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid Investigator ID '%s'", investigatorid)})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+		perms, err = ctx.DB.ModulePermissionsByInvestigator(iid)
+		if err != nil {
+			panic(err)
+		}
+	default:
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "One of 'agentid' or 'investigatorid' must be set"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	if reqs == nil {
+		reqs = []mig.ModuleRequirement{}
+	}
+	if perms == nil {
+		perms = []mig.ModulePermission{}
+	}
+	err = resource.AddItem(cljs.Item{
+		Href: loc,
+		Data: []cljs.Data{
+			{Name: "module requirements", Value: reqs},
+			{Name: "module permissions", Value: perms},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// setModuleRequirement sets the minimum signature weight required to run a module
+// on the agent identified by agentid, or on all the agents matching target. A minimum
+// weight of 0 removes the requirement.
+func setModuleRequirement(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setModuleRequirement()"}.Debug()
+	}()
+	err = request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var req mig.ModuleRequirement
+	req.Module = request.FormValue("module")
+	req.MinimumWeight, err = strconv.Atoi(request.FormValue("minimumweight"))
+	if err != nil || req.Module == "" || req.MinimumWeight < 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "Parameters 'module' and 'minimumweight' must be set, and minimumweight must be >= 0"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	agentids, err := modulePermissionAgents(request)
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: err.Error()})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	var reqs []mig.ModuleRequirement
+	for _, aid := range agentids {
+		req.AgentID = aid
+		err = ctx.DB.SetModuleRequirement(req)
+		if err != nil {
+			panic(err)
+		}
+		reqs = append(reqs, req)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("Module %s minimum weight set to %d on %d agents",
+		req.Module, req.MinimumWeight, len(agentids))}
+	err = resource.AddItem(cljs.Item{
+		Href: loc,
+		Data: []cljs.Data{{Name: "module requirements", Value: reqs}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// setModulePermission sets the weight investigatorid has when running a module on
+// the agent identified by agentid, or on all the agents matching target. A weight of
+// 0 removes the permission.
+func setModulePermission(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setModulePermission()"}.Debug()
+	}()
+	err = request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var perm mig.ModulePermission
+	perm.Module = request.FormValue("module")
+	perm.Weight, err = strconv.Atoi(request.FormValue("weight"))
+	if err != nil || perm.Module == "" || perm.Weight < 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "Parameters 'module' and 'weight' must be set, and weight must be >= 0"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	perm.InvestigatorID, err = strconv.ParseFloat(request.FormValue("investigatorid"), 64)
+	if err != nil || perm.InvestigatorID <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Investigator ID '%s'", request.FormValue("investigatorid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	_, err = ctx.DB.InvestigatorByID(perm.InvestigatorID)
+	if err != nil {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Investigator ID '%.0f' not found", perm.InvestigatorID)})
+		respond(http.StatusNotFound, resource, respWriter, request)
+		return
+	}
+	agentids, err := modulePermissionAgents(request)
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: err.Error()})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	var perms []mig.ModulePermission
+	for _, aid := range agentids {
+		perm.AgentID = aid
+		err = ctx.DB.SetModulePermission(perm)
+		if err != nil {
+			panic(err)
+		}
+		perms = append(perms, perm)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("Investigator %.0f weight for module %s set to %d on %d agents",
+		perm.InvestigatorID, perm.Module, perm.Weight, len(agentids))}
+	err = resource.AddItem(cljs.Item{
+		Href: loc,
+		Data: []cljs.Data{{Name: "module permissions", Value: perms}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// modulePermissionAgents returns the IDs of the agents a module permission request
+// applies to, either the agent given in agentid, or the active agents matching target
+func modulePermissionAgents(request *http.Request) (agentids []float64, err error) {
+	agentid := request.FormValue("agentid")
+	tgt := request.FormValue("target")
+	if agentid != "" && tgt != "" {
+		return nil, fmt.Errorf("Only one of 'agentid' or 'target' can be set")
+	}
+	if agentid != "" {
+		aid, err := strconv.ParseFloat(agentid, 64)
+		if err != nil || aid <= 0 {
+			return nil, fmt.Errorf("Invalid Agent ID '%s'", agentid)
+		}
+		_, err = ctx.DB.AgentByID(aid)
+		if err != nil {
+			return nil, fmt.Errorf("Agent ID '%.0f' not found", aid)
+		}
+		return []float64{aid}, nil
+	}
+	if tgt == "" {
+		return nil, fmt.Errorf("One of 'agentid' or 'target' must be set")
+	}
+	err = checkTarget(tgt)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid target: %v", err)
+	}
+	agents, err := ctx.DB.ActiveAgentsByTarget(tgt)
+	if err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("No active agent found for target")
+	}
+	for _, agt := range agents {
+		agentids = append(agentids, agt.ID)
+	}
+	return
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/jvehent/cljs"
 	"github.com/mozilla/mig"
 	migdbsearch "github.com/mozilla/mig/database/search"
+	"github.com/mozilla/mig/database/target"
 )
 
 type pagination struct {
@@ -24,6 +25,15 @@ type pagination struct {
 	Next   string  `json:"next"`
 }
 
+// checkTarget verifies that a target string is valid and can be evaluated
+// with the current configuration of the API
+func checkTarget(tgt string) error {
+	if target.IsRawSQL(tgt) && !ctx.Targets.AllowRawSQL {
+		return fmt.Errorf("raw SQL targets are not allowed by this API")
+	}
+	return target.Validate(tgt)
+}
+
 // search runs searches
 func search(respWriter http.ResponseWriter, request *http.Request) {
 	var (
@@ -58,6 +68,18 @@ func search(respWriter http.ResponseWriter, request *http.Request) {
 		panic(err)
 	}
 
+	if p.Type == "agent" && p.Target != "" {
+		err = checkTarget(p.Target)
+		if err != nil {
+			// bad request, return 400
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid agent target: %v", err)})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+	}
+
 	// run the search based on the type
 	var results interface{}
 	switch p.Type {
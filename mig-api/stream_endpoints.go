@@ -0,0 +1,279 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+)
+
+// number of commands retrieved from the database in each query of a stream
+const streamBatchSize = 100
+
+// streamActionResults sends the commands of an action to the client as server-sent
+// events as they complete, until the action finishes or the client disconnects.
+//
+// Each completed command is sent in a "command" event whose ID can be passed back in
+// the Last-Event-ID header to resume the stream. Commands sent shortly before the
+// stream was interrupted can be sent again when it is resumed. "counters" events are sent
+// when the counters of the action change, and a final "done" event is sent once the
+// action has finished.
+func streamActionResults(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	streaming := false
+	sent := 0
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			if streaming {
+				// headers have already been sent, report the error in the stream
+				_ = writeStreamEvent(respWriter, "error", "", fmt.Sprintf("%v", e))
+			} else {
+				resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+				respond(http.StatusInternalServerError, resource, respWriter, request)
+			}
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("leaving streamActionResults() after sending %d commands", sent)}.Debug()
+	}()
+	aid, err := strconv.ParseFloat(request.URL.Query().Get("actionid"), 64)
+	if err != nil || aid < 1 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Action ID '%s'", request.URL.Query().Get("actionid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = request.URL.Query().Get("lasteventid")
+	}
+	after, err := parseStreamEventID(lastEventID)
+	if err != nil {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid event ID '%s': %v", lastEventID, err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	a, err := ctx.DB.ActionByID(aid)
+	if err != nil {
+		if a.ID == -1 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Action ID '%.0f' not found", aid)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	flusher, ok := respWriter.(http.Flusher)
+	if !ok {
+		panic("streaming is not supported by the http server")
+	}
+
+	respWriter.Header().Set("Content-Type", "text/event-stream")
+	respWriter.Header().Set("Cache-Control", "no-cache")
+	respWriter.Header().Set("X-Accel-Buffering", "no")
+	respWriter.WriteHeader(http.StatusOK)
+	streaming = true
+	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: aid,
+		Desc: fmt.Sprintf("streaming results of action to investigator %.0f", getInvID(request))}
+
+	var prevctr mig.ActionCounters
+	cursor := newStreamCursor(after)
+	first := true
+	for {
+		// retrieve the action before the commands, so that commands completed
+		// right before the action finished are sent before the done event
+		if !first {
+			a, err = ctx.DB.ActionByID(aid)
+			if err != nil {
+				panic(err)
+			}
+		}
+		// read every command above the cursor, including the ones committed in
+		// the gaps left by earlier reads, and skip the ones already sent
+		from := cursor.after
+		for {
+			cmds, err := ctx.DB.CommandsFinishedAfter(aid, from, streamBatchSize)
+			if err != nil {
+				panic(err)
+			}
+			for _, fc := range cmds {
+				from = fc.Seq
+				if !cursor.add(fc) {
+					continue
+				}
+				err = writeStreamEvent(respWriter, "command", makeStreamEventID(cursor.after), fc.Command)
+				if err != nil {
+					// client went away
+					return
+				}
+				sent++
+			}
+			if len(cmds) < streamBatchSize {
+				break
+			}
+		}
+		cursor.settle(time.Now())
+		status := mig.ActionStreamStatus{Status: a.Status, Counters: a.Counters}
+		if actionStreamFinished(a) {
+			_ = writeStreamEvent(respWriter, "done", "", status)
+			flusher.Flush()
+			return
+		}
+		if first || a.Counters != prevctr {
+			err = writeStreamEvent(respWriter, "counters", "", status)
+			if err != nil {
+				return
+			}
+			prevctr = a.Counters
+		}
+		flusher.Flush()
+		first = false
+		select {
+		case <-request.Context().Done():
+			return
+		case <-time.After(ctx.Stream.pollInterval):
+		}
+	}
+}
+
+// streamSettleDelay is the time after which a gap in the sequence of finished
+// commands is considered permanent. Sequence values are taken when commands are
+// updated, and a command can be committed after commands that took later values,
+// or never if its update is rolled back.
+const streamSettleDelay = 10 * time.Second
+
+// streamCursor tracks the commands sent in a stream of action results. Commands
+// are not visible in the order of their sequence values, so the stream reads again
+// the commands above the cursor until the gaps between them have settled, and
+// skips the ones it already sent.
+type streamCursor struct {
+	// every command at or below after has been sent, or will never be
+	after float64
+	// sequence values of the commands sent above after, by command ID
+	sent map[float64]float64
+	// highest sequence value sent
+	max float64
+	// highest sequence values seen by past reads, and when they were seen
+	marks []streamMark
+}
+
+type streamMark struct {
+	seq  float64
+	seen time.Time
+}
+
+func newStreamCursor(after float64) *streamCursor {
+	return &streamCursor{after: after, max: after, sent: make(map[float64]float64)}
+}
+
+// add records fc as sent, and returns false if it was already sent
+func (c *streamCursor) add(fc migdb.FinishedCommand) bool {
+	if fc.Seq <= c.after {
+		return false
+	}
+	if _, ok := c.sent[fc.Command.ID]; ok {
+		return false
+	}
+	c.sent[fc.Command.ID] = fc.Seq
+	if fc.Seq > c.max {
+		c.max = fc.Seq
+	}
+	return true
+}
+
+// settle is called after reading the commands above the cursor. Commands below the
+// highest sequence value seen at that time had taken their value before it, so the
+// cursor moves past them once streamSettleDelay has passed.
+func (c *streamCursor) settle(now time.Time) {
+	if len(c.marks) == 0 || c.marks[len(c.marks)-1].seq < c.max {
+		c.marks = append(c.marks, streamMark{seq: c.max, seen: now})
+	}
+	settled := 0
+	for _, m := range c.marks {
+		if now.Sub(m.seen) < streamSettleDelay {
+			break
+		}
+		c.after = m.seq
+		settled++
+	}
+	if settled == 0 {
+		return
+	}
+	c.marks = c.marks[settled:]
+	for id, seq := range c.sent {
+		if seq <= c.after {
+			delete(c.sent, id)
+		}
+	}
+}
+
+// actionStreamFinished returns true when no more commands are expected for action a
+func actionStreamFinished(a mig.Action) bool {
+	switch a.Status {
+	case "pending", "scheduled", "preparing", "inflight":
+	default:
+		return true
+	}
+	if a.Counters.Done > 0 && a.Counters.Done >= a.Counters.Sent {
+		return true
+	}
+	return time.Now().After(a.ExpireAfter.Add(10 * time.Second))
+}
+
+// writeStreamEvent writes a server-sent event. Data that is not a string is
+// marshalled to JSON.
+func writeStreamEvent(w http.ResponseWriter, event, id string, data interface{}) (err error) {
+	var payload string
+	if s, ok := data.(string); ok {
+		payload = s
+	} else {
+		buf, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		payload = string(buf)
+	}
+	msg := "event: " + event + "\n"
+	if id != "" {
+		msg += "id: " + id + "\n"
+	}
+	for _, line := range strings.Split(payload, "\n") {
+		msg += "data: " + line + "\n"
+	}
+	_, err = w.Write([]byte(msg + "\n"))
+	return
+}
+
+// makeStreamEventID returns the ID of the event of a command, which is the position
+// of the cursor of the stream when the command was sent
+func makeStreamEventID(seq float64) string {
+	return fmt.Sprintf("%.0f", seq)
+}
+
+// parseStreamEventID parses an event ID generated by makeStreamEventID. An empty
+// ID returns a starting point before any command.
+func parseStreamEventID(id string) (seq float64, err error) {
+	if id == "" {
+		return 0, nil
+	}
+	seq, err = strconv.ParseFloat(id, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("must be a positive number")
+	}
+	return
+}
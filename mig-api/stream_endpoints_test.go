@@ -0,0 +1,219 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+)
+
+// streamStore is a fake database where commands become visible in the order set
+// by the test rather than in the order of their sequence values. Each retrieval
+// of the action applies the next step of the test.
+type streamStore struct {
+	migdb.Store
+	action   mig.Action
+	visible  []migdb.FinishedCommand
+	steps    []func(s *streamStore)
+	retrieve int
+}
+
+func (s *streamStore) ActionByID(id float64) (mig.Action, error) {
+	if s.retrieve < len(s.steps) {
+		s.steps[s.retrieve](s)
+	}
+	s.retrieve++
+	return s.action, nil
+}
+
+func (s *streamStore) CommandsFinishedAfter(actionid float64, after float64, limit int) (cmds []migdb.FinishedCommand, err error) {
+	for _, fc := range s.visible {
+		if fc.Seq > after {
+			cmds = append(cmds, fc)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Seq < cmds[j].Seq })
+	if len(cmds) > limit {
+		cmds = cmds[:limit]
+	}
+	return
+}
+
+// commit makes the command with the given ID and sequence value visible
+func commit(id, seq float64) func(s *streamStore) {
+	return func(s *streamStore) {
+		var cmd mig.Command
+		cmd.ID = id
+		cmd.Status = mig.StatusSuccess
+		s.visible = append(s.visible, migdb.FinishedCommand{Command: cmd, Seq: seq})
+	}
+}
+
+// finish sets the status of the action to completed
+func finish(s *streamStore) {
+	s.action.Status = "completed"
+}
+
+type streamEvent struct {
+	Event, ID, Data string
+}
+
+func parseStreamEvents(t *testing.T, body string) (events []streamEvent) {
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var ev streamEvent
+		for _, line := range strings.Split(block, "\n") {
+			i := strings.Index(line, ": ")
+			if i < 0 {
+				t.Fatalf("invalid event line %q", line)
+			}
+			switch line[:i] {
+			case "event":
+				ev.Event = line[i+2:]
+			case "id":
+				ev.ID = line[i+2:]
+			case "data":
+				ev.Data = line[i+2:]
+			}
+		}
+		events = append(events, ev)
+	}
+	return
+}
+
+func TestStreamActionResults(t *testing.T) {
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+	ctx.Stream.pollInterval = time.Millisecond
+	var tests = []struct {
+		Description string
+		LastEventID string
+		Steps       []func(s *streamStore)
+		Expect      []float64
+	}{
+		{
+			Description: `commands committed in order`,
+			Steps:       []func(s *streamStore){commit(101, 1), commit(102, 2), finish},
+			Expect:      []float64{101, 102},
+		},
+		{
+			Description: `command committed after a later one was sent`,
+			Steps:       []func(s *streamStore){commit(102, 2), commit(101, 1), finish},
+			Expect:      []float64{102, 101},
+		},
+		{
+			Description: `command committed right before the action finished`,
+			Steps: []func(s *streamStore){commit(103, 3),
+				func(s *streamStore) { commit(101, 1)(s); finish(s) }},
+			Expect: []float64{103, 101},
+		},
+		{
+			Description: `gap between commands is never filled`,
+			Steps:       []func(s *streamStore){commit(101, 1), commit(103, 3), finish},
+			Expect:      []float64{101, 103},
+		},
+		{
+			Description: `resumed stream skips commands before the event ID`,
+			LastEventID: "1",
+			Steps: []func(s *streamStore){
+				func(s *streamStore) { commit(101, 1)(s); commit(102, 2)(s) }, finish},
+			Expect: []float64{102},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			store := &streamStore{steps: tc.Steps}
+			store.action.ID = 1
+			store.action.Status = "inflight"
+			store.action.ExpireAfter = time.Now().Add(time.Hour)
+			ctx.DB = store
+			req := httptest.NewRequest("GET", "/api/v1/action/stream?actionid=1", nil)
+			if tc.LastEventID != "" {
+				req.Header.Set("Last-Event-ID", tc.LastEventID)
+			}
+			rec := httptest.NewRecorder()
+			streamActionResults(rec, req)
+			if rec.Code != 200 {
+				t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			events := parseStreamEvents(t, rec.Body.String())
+			var got []float64
+			for _, ev := range events {
+				if ev.Event != "command" {
+					continue
+				}
+				var cmd mig.Command
+				err := json.Unmarshal([]byte(ev.Data), &cmd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, cmd.ID)
+			}
+			if !reflect.DeepEqual(got, tc.Expect) {
+				t.Fatalf("expected commands %v, got %v", tc.Expect, got)
+			}
+			if events[len(events)-1].Event != "done" {
+				t.Fatalf("expected the stream to end with a done event, got %q", events[len(events)-1].Event)
+			}
+		})
+	}
+}
+
+func TestStreamCursor(t *testing.T) {
+	fc := func(id, seq float64) migdb.FinishedCommand {
+		var cmd mig.Command
+		cmd.ID = id
+		return migdb.FinishedCommand{Command: cmd, Seq: seq}
+	}
+	start := time.Now()
+	c := newStreamCursor(0)
+	if !c.add(fc(102, 2)) {
+		t.Fatalf("command 102 was not added")
+	}
+	c.settle(start)
+	if c.after != 0 {
+		t.Fatalf("cursor moved to %.0f before the gap below 2 settled", c.after)
+	}
+	if c.add(fc(102, 2)) {
+		t.Fatalf("command 102 was added twice")
+	}
+	if !c.add(fc(101, 1)) {
+		t.Fatalf("command 101 committed in the gap was not added")
+	}
+	if !c.add(fc(104, 4)) {
+		t.Fatalf("command 104 was not added")
+	}
+	c.settle(start.Add(time.Second))
+	c.settle(start.Add(streamSettleDelay))
+	if c.after != 2 {
+		t.Fatalf("expected the cursor to move to 2, got %.0f", c.after)
+	}
+	if len(c.sent) != 1 {
+		t.Fatalf("expected 1 command sent above the cursor, got %d", len(c.sent))
+	}
+	if !c.add(fc(103, 3)) {
+		t.Fatalf("command 103 committed in an unsettled gap was not added")
+	}
+	c.settle(start.Add(time.Second + streamSettleDelay))
+	if c.after != 4 {
+		t.Fatalf("expected the cursor to move to 4, got %.0f", c.after)
+	}
+	if len(c.sent) != 0 || len(c.marks) != 0 {
+		t.Fatalf("expected the cursor to be settled, got %d commands and %d marks", len(c.sent), len(c.marks))
+	}
+	if c.add(fc(101, 1)) {
+		t.Fatalf("command 101 below the cursor was added")
+	}
+}
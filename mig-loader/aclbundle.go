@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+)
+
+// requestACLBundle retrieves the ACL bundle that applies to the agent from the API,
+// and installs it in the agent configuration directory if it is more recent than the
+// bundle already present. The signatures on the bundle are verified by the agent when
+// it loads it. If the API has no bundle for this loader, nothing is done.
+func requestACLBundle() (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("requestACLBundle() -> %v", e)
+		}
+	}()
+
+	burl := APIURL + "aclbundle/agent/"
+	logInfo("requesting acl bundle from %v", burl)
+
+	mparam := mig.ManifestParameters{}
+	mparam.AgentIdentifier = ctx.AgentIdentifier
+	buf, err := json.Marshal(mparam)
+	if err != nil {
+		panic(err)
+	}
+	data := url.Values{"parameters": {string(buf)}}
+	r, err := http.NewRequest("POST", burl, strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("X-LOADERKEY", ctx.LoaderKey)
+	client := http.Client{}
+	resp, err := client.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		logInfo("no acl bundle available for this loader")
+		return
+	}
+	var resource *cljs.Resource
+	err = json.Unmarshal(body, &resource)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("HTTP %v, API call failed with error '%v' (code %s)", resp.StatusCode,
+			resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+
+	bbuf, err := json.Marshal(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	var b mig.ACLBundle
+	err = json.Unmarshal(bbuf, &b)
+	if err != nil {
+		panic(err)
+	}
+	if len(b.ACL) == 0 || len(b.Signatures) == 0 {
+		panic("acl bundle from api has no acl or no signatures")
+	}
+
+	bpath := path.Join(agentcontext.GetConfDir(), "acl.bundle")
+	current, err := ioutil.ReadFile(bpath)
+	if err == nil {
+		if bytes.Equal(current, bbuf) {
+			logInfo("acl bundle is up to date")
+			return nil
+		}
+		var cb mig.ACLBundle
+		err = json.Unmarshal(current, &cb)
+		if err == nil && !b.Timestamp.After(cb.Timestamp) {
+			logInfo("ignoring acl bundle %q, it is not more recent than the installed bundle", b.Name)
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		panic(err)
+	}
+	err = ioutil.WriteFile(bpath, bbuf, 0600)
+	if err != nil {
+		panic(err)
+	}
+	logInfo("installed acl bundle %q", b.Name)
+	haveChanges = true
+	return
+}
@@ -623,6 +623,13 @@ func main() {
 		doExit(1)
 	}
 
+	// A failure to retrieve the ACL bundle is not fatal, the agent will
+	// continue using its current ACL and receive bundles from the relay
+	err = requestACLBundle()
+	if err != nil {
+		logError("%v", err)
+	}
+
 	if haveChanges {
 		err = runTriggers()
 		if err != nil {
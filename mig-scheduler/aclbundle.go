@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/transport"
+)
+
+// pushACLBundles sends the active ACL bundles to the online agents they apply to,
+// when the ACL hash reported in the agent heartbeat does not match the bundle. The
+// agents verify the signatures on the bundle before swapping their ACL.
+func pushACLBundles(ctx Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("pushACLBundles() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving pushACLBundles()"}.Debug()
+	}()
+	// bundles that have not been consumed by the next periodic run are expired,
+	// and sent again to the agents that are still lagging behind
+	expire, err := time.ParseDuration(ctx.Periodic.Freq)
+	if err != nil {
+		panic(err)
+	}
+	bundles, agents, err := ctx.DB.ACLBundleAgents()
+	if err != nil {
+		panic(err)
+	}
+	for _, b := range bundles {
+		hash, err := b.Hash()
+		if err != nil {
+			panic(err)
+		}
+		body, err := json.Marshal(b)
+		if err != nil {
+			panic(err)
+		}
+		pushed := 0
+		for _, agt := range agents[b.ID] {
+			if agt.Status != mig.AgtStatusOnline || agt.ACLHash == hash {
+				continue
+			}
+			msg := transport.Message{Type: mig.ACLBundleMessageType, Body: body}
+			agtQueue := transport.AgentQueue(agt.QueueLoc)
+			err = ctx.Transport.relay.SendToAgent(agt.QueueLoc, msg, expire)
+			if err != nil {
+				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("publishing acl bundle %.0f failed to queue %s",
+					b.ID, agtQueue)}.Err()
+				continue
+			}
+			pushed++
+		}
+		if pushed > 0 {
+			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("acl bundle %.0f pushed to %d agents", b.ID, pushed)}
+		}
+	}
+	return
+}
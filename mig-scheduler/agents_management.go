@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/target"
 	"time"
 )
 
@@ -113,7 +114,7 @@ func issueKillAction(agent mig.Agent, ctx Context) (err error) {
 	killAction := mig.Action{
 		ID:            mig.GenID(),
 		Name:          fmt.Sprintf("Kill agent %s", agent.Name),
-		Target:        fmt.Sprintf("queueloc='%s'", agent.QueueLoc),
+		Target:        "queueloc = " + target.Quote(agent.QueueLoc),
 		ValidFrom:     time.Now().Add(-60 * time.Second).UTC(),
 		ExpireAfter:   time.Now().Add(30 * time.Minute).UTC(),
 		SyntaxVersion: 2,
@@ -11,12 +11,11 @@ import (
 	"time"
 
 	"github.com/mozilla/mig"
-
-	"github.com/streadway/amqp"
+	"github.com/mozilla/mig/transport"
 )
 
 // startHeartbeatsListener initializes the routine that receives heartbeats from agents
-func startHeartbeatsListener(ctx Context) (heartbeatChan <-chan amqp.Delivery, err error) {
+func startHeartbeatsListener(ctx Context) (heartbeatChan <-chan transport.Message, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("startHeartbeatsListener() -> %v", e)
@@ -24,22 +23,7 @@ func startHeartbeatsListener(ctx Context) (heartbeatChan <-chan amqp.Delivery, e
 		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving startHeartbeatsListener()"}.Debug()
 	}()
 
-	_, err = ctx.MQ.Chan.QueueDeclare(mig.QueueAgentHeartbeat, true, false, false, false, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	err = ctx.MQ.Chan.QueueBind(mig.QueueAgentHeartbeat, mig.QueueAgentHeartbeat, mig.ExchangeToSchedulers, false, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	err = ctx.MQ.Chan.Qos(0, 0, false)
-	if err != nil {
-		panic(err)
-	}
-
-	heartbeatChan, err = ctx.MQ.Chan.Consume(mig.QueueAgentHeartbeat, "", true, false, false, false, nil)
+	heartbeatChan, err = ctx.Transport.relay.Heartbeats()
 	if err != nil {
 		panic(err)
 	}
@@ -49,7 +33,7 @@ func startHeartbeatsListener(ctx Context) (heartbeatChan <-chan amqp.Delivery, e
 }
 
 // getHeartbeats processes the heartbeat messages sent by agents
-func getHeartbeats(msg amqp.Delivery, ctx Context) (err error) {
+func getHeartbeats(msg transport.Message, ctx Context) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("getHeartbeats() -> %v", e)
@@ -117,7 +101,7 @@ func getHeartbeats(msg amqp.Delivery, ctx Context) (err error) {
 			agt.Status = mig.AgtStatusOnline
 			// create a new agent, set starttime to now
 			agt.StartTime = time.Now()
-			err = ctx.DB.InsertAgent(agt, nil)
+			err = ctx.DB.InsertAgent(agt)
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("Heartbeat DB insertion failed with error '%v' for agent '%s'", err, agt.Name)}.Err()
 			}
@@ -167,8 +151,8 @@ func getHeartbeats(msg amqp.Delivery, ctx Context) (err error) {
 	return
 }
 
-// startResultsListener initializes the routine that receives heartbeats from agents
-func startResultsListener(ctx Context) (resultsChan <-chan amqp.Delivery, err error) {
+// startResultsListener initializes the routine that receives results from agents
+func startResultsListener(ctx Context) (resultsChan <-chan transport.Message, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("startResultsListener() -> %v", e)
@@ -176,22 +160,7 @@ func startResultsListener(ctx Context) (resultsChan <-chan amqp.Delivery, err er
 		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving startResultsListener()"}.Debug()
 	}()
 
-	_, err = ctx.MQ.Chan.QueueDeclare(mig.QueueAgentResults, true, false, false, false, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	err = ctx.MQ.Chan.QueueBind(mig.QueueAgentResults, mig.QueueAgentResults, mig.ExchangeToSchedulers, false, nil)
-	if err != nil {
-		panic(err)
-	}
-
-	err = ctx.MQ.Chan.Qos(0, 0, false)
-	if err != nil {
-		panic(err)
-	}
-
-	resultsChan, err = ctx.MQ.Chan.Consume(mig.QueueAgentResults, "", true, false, false, false, nil)
+	resultsChan, err = ctx.Transport.relay.Results()
 	if err != nil {
 		panic(err)
 	}
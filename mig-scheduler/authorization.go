@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/pgp"
 	"os"
 	"regexp"
 )
@@ -69,3 +70,80 @@ func isAgentAuthorized(agentQueueLoc string, ctx Context) (ok bool, err error) {
 	// whitelist check failed, agent isn't authorized
 	return
 }
+
+// actionSigners returns the investigators who signed an action, in the order of
+// the action signatures
+func actionSigners(ctx Context, action mig.Action) (signers []mig.Investigator, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("actionSigners() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: action.ID, Desc: "leaving actionSigners()"}.Debug()
+	}()
+	astr, err := action.String()
+	if err != nil {
+		panic(err)
+	}
+	for _, sig := range action.PGPSignatures {
+		pubring, err := getPubring(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fp, err := pgp.GetFingerprintFromSignature(astr, sig, pubring)
+		if err != nil {
+			panic(err)
+		}
+		inv, err := ctx.DB.InvestigatorByFingerprint(fp)
+		if err != nil {
+			panic(err)
+		}
+		signers = append(signers, inv)
+	}
+	return
+}
+
+// authorizedAgents returns the agents on which the signers of an action have enough
+// weight to run all of its operations, as defined by the module requirements and
+// module permissions stored in the database. Agents that fail the requirements are
+// logged and removed from the list.
+func authorizedAgents(ctx Context, action mig.Action, agents []mig.Agent,
+	signers []mig.Investigator) (authorized []mig.Agent, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("authorizedAgents() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: action.ID, Desc: "leaving authorizedAgents()"}.Debug()
+	}()
+	if len(agents) == 0 {
+		return agents, nil
+	}
+	var agentids, invids []float64
+	for _, agent := range agents {
+		agentids = append(agentids, agent.ID)
+	}
+	for _, inv := range signers {
+		invids = append(invids, inv.ID)
+	}
+	reqs, err := ctx.DB.ModuleRequirementsByAgents(agentids)
+	if err != nil {
+		panic(err)
+	}
+	if len(reqs) == 0 {
+		// no requirement set on any of the agents
+		return agents, nil
+	}
+	perms, err := ctx.DB.ModulePermissionsByAgentsAndInvestigators(agentids, invids)
+	if err != nil {
+		panic(err)
+	}
+	for _, agent := range agents {
+		err = mig.VerifyModulePermissions(action, agent.ID, invids, reqs, perms)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: action.ID,
+				Desc: fmt.Sprintf("Refusing to create command on agent %s: %v", agent.Name, err)}.Warning()
+			continue
+		}
+		authorized = append(authorized, agent)
+	}
+	return authorized, nil
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/mozilla/mig"
 	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/transport"
 	"github.com/streadway/amqp"
 	"gopkg.in/gcfg.v1"
 )
@@ -61,7 +62,7 @@ type Context struct {
 			InFlight, Returned string
 		}
 	}
-	DB migdb.DB
+	DB schedulerDB
 	MQ struct {
 		// configuration
 		Host, User, Pass, Vhost string
@@ -69,8 +70,12 @@ type Context struct {
 		UseTLS                  bool
 		TLScert, TLSkey, CAcert string
 		Timeout                 string
-		conn                    *amqp.Connection
-		Chan                    *amqp.Channel
+	}
+	Transport struct {
+		// configuration
+		Mode, PollInterval string
+		// internal
+		relay transport.SchedulerTransport
 	}
 	PGP struct {
 		Pubring, Secring   io.ReadSeeker
@@ -82,14 +87,81 @@ type Context struct {
 		Host, User, Password, DBName, SSLMode string
 		Port, MaxConn                         int
 	}
+	SQLite struct {
+		Path string
+	}
 	Stats struct {
 	}
+	Targets struct {
+		AllowRawSQL bool
+	}
 	Logging mig.Logging
 	Debug   struct {
 		Heartbeats bool
 	}
 }
 
+// schedulerDB lists the database operations used by the scheduler. It is
+// implemented by the Postgres and SQLite backends of the database package.
+type schedulerDB interface {
+	// actions
+	ActionMetaByID(id float64) (mig.Action, error)
+	FinishAction(a mig.Action) error
+	GetActionCounters(aid float64) (mig.ActionCounters, error)
+	InsertOrUpdateAction(a mig.Action) (bool, error)
+	InsertSignature(aid, iid float64, sig string) error
+	SetupRunnableActions() ([]mig.Action, error)
+	UpdateAction(a mig.Action) error
+	UpdateActionStatus(a mig.Action) error
+	UpdateRunningAction(a mig.Action) error
+
+	// commands
+	FinishCommand(cmd mig.Command) error
+	InsertCommands(cmds []mig.Command) (int64, error)
+
+	// agents
+	ACLBundleAgents() ([]mig.ACLBundle, map[float64][]mig.Agent, error)
+	ActiveAgentsByQueue(queueloc string, pointInTime time.Time) ([]mig.Agent, error)
+	ActiveAgentsByTarget(tgt string) ([]mig.Agent, error)
+	AgentByQueueAndPID(queueloc string, pid int) (mig.Agent, error)
+	InsertAgent(agt mig.Agent) error
+	ListMultiAgentsQueues(pointInTime time.Time) ([]string, error)
+	MarkAgentDestroyed(agent mig.Agent) error
+	MarkIdleAgents(pointInTime time.Time) error
+	MarkOfflineAgents(pointInTime time.Time) error
+	ReplaceRefreshedAgent(agt mig.Agent) error
+	UpdateAgentHeartbeat(agt mig.Agent) error
+
+	// agents statistics
+	CountDisappearedEndpoints(pointInTime time.Time) (float64, error)
+	CountDoubleAgents() (float64, error)
+	CountFlappingEndpoints() (float64, error)
+	CountIdleEndpoints() (float64, error)
+	CountNewEndpoints(recent, old time.Time) (float64, error)
+	CountOnlineEndpoints() (float64, error)
+	GetDisappearedEndpoints(oldest time.Time) ([]string, error)
+	StoreAgentsStats(stats mig.AgentsStats) error
+	SumIdleAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+	SumOnlineAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+
+	// investigators and permissions
+	ActiveInvestigatorsPubKeys() ([][]byte, error)
+	GetSchedulerInvestigator() (mig.Investigator, error)
+	GetSchedulerPrivKey() ([]byte, error)
+	InsertSchedulerInvestigator(inv mig.Investigator) (float64, error)
+	InvestigatorByFingerprint(fp string) (mig.Investigator, error)
+	ModulePermissionsByAgentsAndInvestigators(agentids, iids []float64) ([]mig.ModulePermission, error)
+	ModuleRequirementsByAgents(agentids []float64) ([]mig.ModuleRequirement, error)
+
+	// messages exchanged with agents that poll the API
+	agentMessageDB
+	DeleteExpiredAgentMessages() (int64, error)
+
+	AllowRawSQLTargets(allow bool)
+	SetMaxOpenConns(n int)
+	Close()
+}
+
 // Init() initializes a context from a configuration file into an
 // existing context struct
 func Init(path string) (ctx Context, err error) {
@@ -124,7 +196,7 @@ func Init(path string) (ctx Context, err error) {
 		panic(err)
 	}
 
-	ctx, err = initRelay(ctx)
+	ctx, err = initTransport(ctx)
 	if err != nil {
 		panic(err)
 	}
@@ -186,7 +258,8 @@ func initDirectories(orig_ctx Context) (ctx Context, err error) {
 	return
 }
 
-// initDB sets up the connection to the Postgres backend database
+// initDB sets up the connection to the backend database, and verifies its
+// schema is at the version this release expects
 func initDB(orig_ctx Context) (ctx Context, err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -195,28 +268,116 @@ func initDB(orig_ctx Context) (ctx Context, err error) {
 		ctx.Channels.Log <- mig.Log{Desc: "leaving initDB()"}.Debug()
 	}()
 
-	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
 	ctx = orig_ctx
-	ctx.DB, err = migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
-		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
+	db, err := openDB(ctx)
 	if err != nil {
 		panic(err)
 	}
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		db.Close()
+		panic(err)
+	}
+	ctx.DB = db
 	ctx.Channels.Log <- mig.Log{Desc: "Database connection opened"}
-	ctx.DB.SetMaxOpenConns(ctx.Postgres.MaxConn)
 	return
 }
 
-// initRelay() sets up the connection to the RabbitMQ broker
-func initRelay(orig_ctx Context) (ctx Context, err error) {
+// openDB opens the backend database, which is the SQLite database at SQLite.Path
+// if it is set, and Postgres otherwise
+func openDB(ctx Context) (db migdb.Store, err error) {
+	if ctx.SQLite.Path != "" {
+		fmt.Fprintf(os.Stdout, "Attempting to open sqlite database...")
+		return openSQLite(ctx.SQLite.Path)
+	}
+	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
+	pgdb, err := migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
+		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
+	if err != nil {
+		return
+	}
+	pgdb.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	pgdb.AllowRawSQLTargets(ctx.Targets.AllowRawSQL)
+	return &pgdb, nil
+}
+
+// Migrate applies the pending migrations to the database of configuration
+// file path. With Postgres, the configured user must own the schema.
+func Migrate(path string) (applied []migdb.Migration, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = fmt.Errorf("initRelay() -> %v", e)
+			err = fmt.Errorf("Migrate() -> %v", e)
 		}
-		ctx.Channels.Log <- mig.Log{Desc: "leaving initRelay()"}.Debug()
+	}()
+	var ctx Context
+	err = gcfg.ReadFileInto(&ctx, path)
+	if err != nil {
+		panic(err)
+	}
+	db, err := openDB(ctx)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	applied, err = db.Migrate()
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// initTransport() sets up the transport used to communicate with agents, either
+// through the RabbitMQ broker or through the database when agents poll the API
+func initTransport(orig_ctx Context) (ctx Context, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("initTransport() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{Desc: "leaving initTransport()"}.Debug()
 	}()
 
 	ctx = orig_ctx
+	if ctx.Transport.Mode == "" {
+		ctx.Transport.Mode = transport.AMQP
+	}
+	err = transport.Validate(ctx.Transport.Mode)
+	if err != nil {
+		panic(err)
+	}
+	if ctx.Transport.Mode == transport.HTTPS {
+		if ctx.Transport.PollInterval == "" {
+			ctx.Transport.PollInterval = "1s"
+		}
+		interval, perr := time.ParseDuration(ctx.Transport.PollInterval)
+		if perr != nil {
+			panic(fmt.Sprintf("invalid transport poll interval: %v", perr))
+		}
+		ctx.Transport.relay = newDBTransport(ctx.DB, interval, ctx.Channels.Log)
+		ctx.Channels.Log <- mig.Log{Desc: "exchanging messages with agents through the database"}
+		return
+	}
+	conn, err := dialRelay(ctx)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Transport.relay, err = transport.NewAMQPScheduler(conn)
+	if err != nil {
+		conn.Close()
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{Desc: "AMQP connection opened"}
+	return
+}
+
+// dialRelay() opens a connection to the RabbitMQ broker
+func dialRelay(ctx Context) (conn *amqp.Connection, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("dialRelay() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{Desc: "leaving dialRelay()"}.Debug()
+	}()
+
 	// dialing address use format "<scheme>://<user>:<pass>@<host>:<port><vhost>"
 	var scheme, user, pass, host, port, vhost string
 	if ctx.MQ.UseTLS {
@@ -281,26 +442,10 @@ func initRelay(orig_ctx Context) (ctx Context, err error) {
 	}
 
 	// Setup the AMQP broker connection
-	ctx.MQ.conn, err = amqp.DialConfig(dialaddr, dialConfig)
+	conn, err = amqp.DialConfig(dialaddr, dialConfig)
 	if err != nil {
 		panic(err)
 	}
-
-	ctx.MQ.Chan, err = ctx.MQ.conn.Channel()
-	if err != nil {
-		panic(err)
-	}
-	// declare the "toagents" exchange used for communication from schedulers to agents
-	err = ctx.MQ.Chan.ExchangeDeclare(mig.ExchangeToAgents, "direct", true, false, false, false, nil)
-	if err != nil {
-		panic(err)
-	}
-	// declare the "toschedulers" exchange used for communication from agents to schedulers
-	err = ctx.MQ.Chan.ExchangeDeclare(mig.ExchangeToSchedulers, "direct", true, false, false, false, nil)
-	if err != nil {
-		panic(err)
-	}
-	ctx.Channels.Log <- mig.Log{Desc: "AMQP connection opened"}
 	return
 }
 
@@ -360,9 +505,9 @@ func initSecring(orig_ctx Context) (ctx Context, err error) {
 
 // Destroy closes all the connections
 func Destroy(ctx Context) {
-	// close rabbitmq
-	ctx.MQ.conn.Close()
-	ctx.Channels.Log <- mig.Log{Sev: "info", Desc: "AMQP connection closed"}
+	// close the transport
+	ctx.Transport.relay.Close()
+	ctx.Channels.Log <- mig.Log{Sev: "info", Desc: "Transport closed"}
 	// close database
 	ctx.DB.Close()
 	ctx.Channels.Log <- mig.Log{Sev: "info", Desc: "MongoDB connection closed"}
@@ -0,0 +1,21 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package main
+
+import (
+	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/database/sqlite"
+)
+
+// openSQLite opens the SQLite database at path
+func openSQLite(path string) (migdb.Store, error) {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,199 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build sqlite
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/sqlite"
+	"github.com/mozilla/mig/testutil/harness"
+	"github.com/mozilla/mig/transport"
+)
+
+// newTestContext returns a scheduler context that stores its data in db and
+// exchanges messages with agents through the database, as with the https
+// transport
+func newTestContext(t *testing.T, spool string, db schedulerDB) Context {
+	var ctx Context
+	ctx, err := initChannels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Logging, err = mig.InitLogger(mig.Logging{Mode: "stdout", Level: "info"}, "mig-scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Directories.Spool = spool
+	ctx.Directories.Tmp = filepath.Join(spool, "tmp")
+	err = os.MkdirAll(ctx.Directories.Tmp, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err = initDirectories(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.DB = db
+	ctx.Transport.Mode = transport.HTTPS
+	ctx.Transport.relay = newDBTransport(db, 200*time.Millisecond, ctx.Channels.Log)
+	ctx.Agent.TimeOut = "1m"
+	ctx.Agent.HeartbeatFreq = "1s"
+	// the routines that process spooled files send them in batches after one
+	// second without new files, so the collector must not run more often
+	ctx.Collector.Freq = "2s"
+	ctx.Periodic.Freq = "1m"
+	ctx.Periodic.DeleteAfter = "1h"
+	ctx.Periodic.QueuesCleanupFreq = "1h"
+	ctx, err = initSecring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+// waitFor calls cond until it returns true, and fails the test if it doesn't
+// within a minute
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	for deadline := time.Now().Add(time.Minute); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// TestEndToEnd runs an action through the mig-api binary, the routines of the
+// scheduler and mig-agent processes that poll the API with the https transport.
+// The scheduler and the API share a SQLite database, so the test only runs with
+// the sqlite build tag.
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs mig-api and mig-agent")
+	}
+	dir, err := ioutil.TempDir("", "mig-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	apiBin, err := harness.BuildCommand(dir, "github.com/mozilla/mig/mig-api", "-tags", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the agent is needed from the test binary of mig-agent, which isn't
+	// vetted here
+	agentBin, err := harness.BuildTestBinary(dir, "github.com/mozilla/mig/mig-agent", "-vet=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki, err := harness.NewPKI(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searched := filepath.Join(dir, "searched")
+	err = os.Mkdir(searched, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(searched, "secret.txt"), []byte("the needle is here\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlite.Open(filepath.Join(dir, "mig.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	inv, err := harness.NewInvestigator(db, "Bob Kelso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spool := filepath.Join(dir, "spool")
+	ctx := newTestContext(t, spool, db)
+	stopped := make(chan bool)
+	go func() {
+		startRoutines(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		ctx.Channels.Terminate <- errors.New("end of test")
+		<-stopped
+	}()
+
+	api, err := harness.StartAPI(dir, apiBin, pki, filepath.Join(dir, "mig.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Stop()
+	var agents []*harness.Agent
+	for _, name := range []string{"agt1", "agt2", "agt3", "other"} {
+		role := "target"
+		if name == "other" {
+			role = "other"
+		}
+		agtdir := filepath.Join(dir, name)
+		err = os.Mkdir(agtdir, 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agt, err := harness.StartAgent(agtdir, agentBin, api, pki, map[string]string{"role": role}, inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer agt.Stop()
+		agents = append(agents, agt)
+	}
+	defer func() {
+		if t.Failed() {
+			t.Logf("mig-api output:\n%s", api.Output())
+			for _, agt := range agents {
+				t.Logf("mig-agent %s output:\n%s", agt.ConfDir, agt.Output())
+			}
+		}
+	}()
+	waitFor(t, "agents to come online", func() bool {
+		online, err := db.ActiveAgentsByTarget("tag.role = target")
+		return err == nil && len(online) == 3
+	})
+
+	action := harness.NewAction("find the needle", "tag.role = target", harness.FileSearch(searched, "needle"))
+	err = inv.Sign(&action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action, err = api.CreateAction(inv, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the action to complete", func() bool {
+		action, err = api.Action(inv, action.ID)
+		return err == nil && action.Status == "completed"
+	})
+	if action.Counters.Sent != 3 || action.Counters.Success != 3 {
+		t.Fatalf("action completed with counters %+v, expected 3 successful commands", action.Counters)
+	}
+	cmds, err := db.CommandsByActionID(action.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range cmds {
+		if cmd.Agent.Tags["role"] != "target" {
+			t.Errorf("agent %q is not a target of the action", cmd.Agent.QueueLoc)
+		}
+		if cmd.Status != mig.StatusSuccess || len(cmd.Results) != 1 || !cmd.Results[0].FoundAnything {
+			t.Errorf("command on agent %q returned status %q and results %+v",
+				cmd.Agent.QueueLoc, cmd.Status, cmd.Results)
+		}
+	}
+}
@@ -49,6 +49,10 @@ func periodic(ctx Context) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	err = pushACLBundles(ctx)
+	if err != nil {
+		panic(err)
+	}
 	return
 }
 
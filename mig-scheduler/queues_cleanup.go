@@ -4,27 +4,41 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/streadway/amqp"
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/transport"
+	"github.com/streadway/amqp"
 )
 
 // QueuesCleanup deletes rabbitmq queues of endpoints that no
 // longer have any agent running on them. Only the queues with 0 consumers and 0
-// pending messages are deleted.
+// pending messages are deleted. When agents poll the API instead of using the
+// relay, it deletes the expired messages queued in the database.
 func QueuesCleanup(ctx Context) (err error) {
-	// temporary context for amqp operations
-	tmpctx := ctx
-	tmpctxinitiliazed := false
+	// separate connection for amqp operations
+	var (
+		conn *amqp.Connection
+		ch   *amqp.Channel
+	)
 	start := time.Now()
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("QueuesCleanup() -> %v", e)
 		}
 		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving QueuesCleanup()"}.Debug()
-		if tmpctxinitiliazed {
-			tmpctx.MQ.conn.Close()
+		if conn != nil {
+			conn.Close()
 		}
 	}()
+	// without a relay, agents queues are kept in the database and only the messages
+	// that have expired before being delivered need to be removed
+	if ctx.Transport.Mode == transport.HTTPS {
+		count, err := ctx.DB.DeleteExpiredAgentMessages()
+		if err != nil {
+			panic(err)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("QueuesCleanup(): removed %d expired agent messages", count)}
+		return nil
+	}
 	// cleanup runs every QueuesCleanupFreq and lists endpoints queues that have disappeared
 	// and for which the rabbitmq queue should be deleted.
 	//
@@ -49,23 +63,31 @@ func QueuesCleanup(ctx Context) (err error) {
 	makeamqpchan := true
 	for _, queue := range queues {
 		if makeamqpchan {
-			// keep the existing context, but create a new rabbitmq connection to prevent breaking
+			// create a new rabbitmq connection to prevent breaking
 			// the main one if something goes wrong.
-			tmpctx, err = initRelay(tmpctx)
+			if conn != nil {
+				conn.Close()
+			}
+			conn, err = dialRelay(ctx)
+			if err != nil {
+				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("QueuesCleanup(): %v. Continuing!", err)}.Err()
+				conn = nil
+				continue
+			}
+			ch, err = conn.Channel()
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("QueuesCleanup(): %v. Continuing!", err)}.Err()
 				continue
 			}
 			makeamqpchan = false
-			tmpctxinitiliazed = true
 		}
 		// the call to inspect will fail if the queue doesn't exist, so we fail silently and continue
-		_, err = tmpctx.MQ.Chan.QueueInspect("mig.agt." + queue)
+		_, err = ch.QueueInspect("mig.agt." + queue)
 		if err != nil {
 			// If a queue by this name does not exist, an error will be returned and the channel will be closed.
 			// Reopen the channel and continue
 			if amqp.ErrClosed == err || err.(*amqp.Error).Recover {
-				tmpctx.MQ.Chan, err = tmpctx.MQ.conn.Channel()
+				ch, err = conn.Channel()
 				if err != nil {
 					ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("QueuesCleanup(): QueueInspect failed with error '%v'. Continuing.", err)}.Warning()
 					makeamqpchan = true
@@ -80,7 +102,7 @@ func QueuesCleanup(ctx Context) (err error) {
 			err = nil
 			continue
 		}
-		_, err = tmpctx.MQ.Chan.QueueDelete("mig.agt."+queue, false, false, false)
+		_, err = ch.QueueDelete("mig.agt."+queue, false, false, false)
 		if err != nil {
 			desc := fmt.Sprintf("error while deleting queue mig.agt.%s: %v", queue, err)
 			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}.Err()
@@ -15,8 +15,7 @@ import (
 
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/modules"
-	"github.com/mozilla/mig/pgp"
-	"github.com/streadway/amqp"
+	"github.com/mozilla/mig/transport"
 )
 
 func main() {
@@ -26,6 +25,7 @@ func main() {
 	// command line options
 	var config = flag.String("c", "/etc/mig/scheduler.cfg", "Load configuration from file")
 	var showversion = flag.Bool("V", false, "Show build version and exit")
+	var migrate = flag.Bool("migrate", false, "Apply pending database schema migrations and exit")
 	flag.Parse()
 
 	if *showversion {
@@ -33,6 +33,19 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *migrate {
+		applied, err := Migrate(*config)
+		if err != nil {
+			fmt.Printf("FATAL: %v\n", err)
+			os.Exit(9)
+		}
+		for _, m := range applied {
+			fmt.Printf("applied migration %d: %s\n", m.Version, m.Description)
+		}
+		fmt.Println("database schema is up to date")
+		os.Exit(0)
+	}
+
 	// The context initialization takes care of parsing the configuration,
 	// and creating connections to database, message broker, syslog, ...
 	fmt.Fprintf(os.Stderr, "Initializing Scheduler context...")
@@ -92,6 +105,15 @@ func processNewAction(actionPath string, ctx Context) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	// only keep the agents the signers of the action are allowed to run it on
+	signers, err := actionSigners(ctx, action)
+	if err != nil {
+		panic(err)
+	}
+	agents, err = authorizedAgents(ctx, action, agents, signers)
+	if err != nil {
+		panic(err)
+	}
 	action.Counters.Sent = len(agents)
 	if action.Counters.Sent == 0 {
 		err = fmt.Errorf("No agents found for target '%s'. invalidating action.", action.Target)
@@ -110,24 +132,8 @@ func processNewAction(actionPath string, ctx Context) (err error) {
 	if inserted {
 		// action was inserted, and not updated, so we need to insert
 		// the signatures as well
-		astr, err := action.String()
-		if err != nil {
-			panic(err)
-		}
-		for _, sig := range action.PGPSignatures {
-			pubring, err := getPubring(ctx)
-			if err != nil {
-				panic(err)
-			}
-			fp, err := pgp.GetFingerprintFromSignature(astr, sig, pubring)
-			if err != nil {
-				panic(err)
-			}
-			inv, err := ctx.DB.InvestigatorByFingerprint(fp)
-			if err != nil {
-				panic(err)
-			}
-			err = ctx.DB.InsertSignature(action.ID, inv.ID, sig)
+		for i, sig := range action.PGPSignatures {
+			err = ctx.DB.InsertSignature(action.ID, signers[i].ID, sig)
 			if err != nil {
 				panic(err)
 			}
@@ -217,18 +223,12 @@ func sendCommands(cmds []mig.Command, ctx Context) (err error) {
 		if err != nil {
 			panic(err)
 		}
-		// send amqp message with an expiration timer
+		// send the message with an expiration timer
 		expire := cmd.Action.ExpireAfter.Sub(cmd.Action.ValidFrom)
-		msg := amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			Timestamp:    time.Now(),
-			ContentType:  "text/plain",
-			Expiration:   fmt.Sprintf("%d", int64(expire/time.Millisecond)),
-			Body:         []byte(data),
-		}
-		agtQueue := fmt.Sprintf("mig.agt.%s", cmd.Agent.QueueLoc)
+		msg := transport.Message{Body: data}
+		agtQueue := transport.AgentQueue(cmd.Agent.QueueLoc)
 		go func() {
-			err = ctx.MQ.Chan.Publish(mig.ExchangeToAgents, agtQueue, true, false, msg)
+			err = ctx.Transport.relay.SendToAgent(cmd.Agent.QueueLoc, msg, expire)
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: "publishing failed to queue" + agtQueue}.Err()
 			} else {
@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+	"github.com/mozilla/mig/transport"
+)
+
+// number of results retrieved from the database in each query
+const dbTransportBatchSize = 100
+
+// agentMessageDB lists the database operations used to queue the messages
+// exchanged with agents
+type agentMessageDB interface {
+	InsertAgentMessage(queue string, msg migdb.AgentMessage, expireafter time.Time) error
+	TakeAgentMessages(queue string, limit int) ([]migdb.AgentMessage, error)
+}
+
+// dbTransport is the scheduler side of the https transport. Messages to agents
+// are queued in the database, where the API picks them up when agents poll it.
+// Results posted to the API by agents are queued in the database as well, and
+// collected by the scheduler every interval.
+type dbTransport struct {
+	db        agentMessageDB
+	interval  time.Duration
+	log       chan mig.Log
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func newDBTransport(db agentMessageDB, interval time.Duration, log chan mig.Log) *dbTransport {
+	return &dbTransport{
+		db:       db,
+		interval: interval,
+		log:      log,
+		done:     make(chan bool),
+	}
+}
+
+// SendToAgent queues a message for the agent listening on queueLoc
+func (t *dbTransport) SendToAgent(queueLoc string, msg transport.Message, expiration time.Duration) error {
+	return t.db.InsertAgentMessage(transport.AgentQueue(queueLoc),
+		migdb.AgentMessage{Type: msg.Type, Body: msg.Body},
+		time.Now().Add(expiration))
+}
+
+// Heartbeats returns a channel that never receives anything, as agents post their
+// heartbeats to the API, which stores them in the database directly. The channel
+// is closed when the transport is closed.
+func (t *dbTransport) Heartbeats() (<-chan transport.Message, error) {
+	msgs := make(chan transport.Message)
+	go func() {
+		<-t.done
+		close(msgs)
+	}()
+	return msgs, nil
+}
+
+// Results collects the results queued in the database until the transport is closed
+func (t *dbTransport) Results() (<-chan transport.Message, error) {
+	msgs := make(chan transport.Message)
+	go func() {
+		defer close(msgs)
+		for {
+			results, err := t.db.TakeAgentMessages(mig.QueueAgentResults, dbTransportBatchSize)
+			if err != nil {
+				t.log <- mig.Log{Desc: fmt.Sprintf("failed to collect agent results: %v", err)}.Err()
+			} else {
+				for _, r := range results {
+					select {
+					case msgs <- transport.Message{Type: r.Type, Body: r.Body}:
+					case <-t.done:
+						return
+					}
+				}
+				if len(results) == dbTransportBatchSize {
+					continue
+				}
+			}
+			select {
+			case <-t.done:
+				return
+			case <-time.After(t.interval):
+			}
+		}
+	}()
+	return msgs, nil
+}
+
+// Close stops the collection of results
+func (t *dbTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return nil
+}
@@ -15,7 +15,9 @@ import (
 	_ "github.com/mozilla/mig/modules/netstat"
 	_ "github.com/mozilla/mig/modules/ping"
 	_ "github.com/mozilla/mig/modules/pkg"
+	_ "github.com/mozilla/mig/modules/process"
 	_ "github.com/mozilla/mig/modules/scribe"
 	_ "github.com/mozilla/mig/modules/sshkey"
 	_ "github.com/mozilla/mig/modules/timedrift"
+	_ "github.com/mozilla/mig/modules/user"
 )
@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"fmt"
+)
+
+// ModuleRequirement sets the minimum signature weight an action must have to run
+// a given module on an agent. It is stored in the agtmodreq table of the database.
+type ModuleRequirement struct {
+	AgentID       float64 `json:"agentid"`
+	Module        string  `json:"module"`
+	MinimumWeight int     `json:"minimumweight"`
+}
+
+// ModulePermission grants weight to the signature of an investigator when running a
+// given module on an agent. It is stored in the invagtmodperm table of the database.
+type ModulePermission struct {
+	InvestigatorID float64 `json:"investigatorid"`
+	AgentID        float64 `json:"agentid"`
+	Module         string  `json:"module"`
+	Weight         int     `json:"weight"`
+}
+
+// VerifyModulePermissions validates that the investigators who signed an action have
+// enough weight to run each operation of the action on agent agentID. Only the module
+// requirements set for the agent are enforced, and operations on modules that have no
+// requirement for the agent are permitted.
+//
+// This complements the ACL verified by the agent, and is used by the scheduler to
+// decide if a command can be created for an agent.
+func VerifyModulePermissions(a Action, agentID float64, signers []float64,
+	reqs []ModuleRequirement, perms []ModulePermission) error {
+	// if the same investigator signed multiple times, return an error
+	for i := range signers {
+		for j := i + 1; j < len(signers); j++ {
+			if signers[i] == signers[j] {
+				return fmt.Errorf("permission violation: investigator %.0f signed multiple times", signers[i])
+			}
+		}
+	}
+	for _, op := range a.Operations {
+		minweight := 0
+		for _, req := range reqs {
+			if req.AgentID == agentID && req.Module == op.Module {
+				minweight = req.MinimumWeight
+				break
+			}
+		}
+		if minweight == 0 {
+			continue
+		}
+		weight := 0
+		for _, signer := range signers {
+			for _, perm := range perms {
+				if perm.InvestigatorID == signer && perm.AgentID == agentID && perm.Module == op.Module {
+					weight += perm.Weight
+				}
+			}
+		}
+		if weight < minweight {
+			return fmt.Errorf("permission denied for operation %v on agent %.0f, insufficient "+
+				"signatures weight (need %v, got %v)", op.Module, agentID, minweight, weight)
+		}
+	}
+	return nil
+}
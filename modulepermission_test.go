@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"testing"
+)
+
+var testModuleRequirements = []ModuleRequirement{
+	{AgentID: 1, Module: "file", MinimumWeight: 2},
+	{AgentID: 2, Module: "file", MinimumWeight: 1},
+}
+
+var testModulePermissions = []ModulePermission{
+	{InvestigatorID: 10, AgentID: 1, Module: "file", Weight: 1},
+	{InvestigatorID: 11, AgentID: 1, Module: "file", Weight: 1},
+	{InvestigatorID: 10, AgentID: 2, Module: "netstat", Weight: 5},
+}
+
+func testModulePermissionAction(modules ...string) (a Action) {
+	for _, m := range modules {
+		a.Operations = append(a.Operations, Operation{Module: m})
+	}
+	return
+}
+
+func TestModulePermissionsNoRequirement(t *testing.T) {
+	a := testModulePermissionAction("netstat", "pkg")
+	err := VerifyModulePermissions(a, 1, []float64{12}, testModuleRequirements, testModulePermissions)
+	if err != nil {
+		t.Fatalf("VerifyModulePermissions should have returned no error: %v", err)
+	}
+}
+
+func TestModulePermissionsSufficientWeight(t *testing.T) {
+	a := testModulePermissionAction("file")
+	err := VerifyModulePermissions(a, 1, []float64{10, 11}, testModuleRequirements, testModulePermissions)
+	if err != nil {
+		t.Fatalf("VerifyModulePermissions should have returned no error: %v", err)
+	}
+}
+
+func TestModulePermissionsInsufficientWeight(t *testing.T) {
+	a := testModulePermissionAction("netstat", "file")
+	err := VerifyModulePermissions(a, 1, []float64{10}, testModuleRequirements, testModulePermissions)
+	if err == nil {
+		t.Fatalf("VerifyModulePermissions should have failed with a single signer")
+	}
+}
+
+func TestModulePermissionsOtherAgent(t *testing.T) {
+	// weight granted on agent 1 must not count on agent 2
+	a := testModulePermissionAction("file")
+	err := VerifyModulePermissions(a, 2, []float64{10, 11}, testModuleRequirements, testModulePermissions)
+	if err == nil {
+		t.Fatalf("VerifyModulePermissions should have failed on agent 2")
+	}
+}
+
+func TestModulePermissionsDuplicateSigner(t *testing.T) {
+	a := testModulePermissionAction("file")
+	err := VerifyModulePermissions(a, 1, []float64{10, 10}, testModuleRequirements, testModulePermissions)
+	if err == nil {
+		t.Fatalf("VerifyModulePermissions should have failed with a duplicate signer")
+	}
+}
@@ -11,11 +11,10 @@
 package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/jvehent/gozdef"
-	"net/http"
+	"path"
 	"runtime"
 	"time"
 
@@ -67,6 +66,10 @@ var messageBuf chan string
 var lastDrop time.Time
 var dropCounter int
 
+// outputs are the destinations of the records, each with the spool that stores
+// records on disk until they are dispatched
+var outputs []dispatchOutput
+
 // Dispatch record describes the formatting of JSON data submitted from the dispatch
 // module.
 type DispatchRecord struct {
@@ -116,6 +119,41 @@ func moduleMain() {
 		logChan <- "channel size not specified, defaulting to 1024"
 	}
 
+	if cfg.Dispatch.BatchSize == 0 {
+		cfg.Dispatch.BatchSize = 1
+	}
+	if cfg.Dispatch.SpoolDir == "" {
+		cfg.Dispatch.SpoolDir = path.Join(modules.ModuleRunDir, "dispatch-spool")
+	}
+	if cfg.Dispatch.SpoolMaxSize == 0 {
+		cfg.Dispatch.SpoolMaxSize = 100
+	}
+	maxage := 7 * 24 * time.Hour
+	if cfg.Dispatch.SpoolMaxAge != "" {
+		maxage, err = time.ParseDuration(cfg.Dispatch.SpoolMaxAge)
+		if err != nil {
+			handlerErrChan <- fmt.Errorf("invalid spoolmaxage: %v", err)
+			return
+		}
+	}
+	outs, err := newOutputs(cfg)
+	if err != nil {
+		handlerErrChan <- err
+		return
+	}
+	for _, o := range outs {
+		o.spool, err = newSpool(path.Join(cfg.Dispatch.SpoolDir, o.name),
+			int64(cfg.Dispatch.SpoolMaxSize)*1024*1024, maxage)
+		if err != nil {
+			handlerErrChan <- err
+			return
+		}
+		if st := o.spool.getStats(); st.Records > 0 {
+			logChan <- fmt.Sprintf("%v records left in %v spool by a previous run", st.Records, o.name)
+		}
+		outputs = append(outputs, o)
+	}
+
 	messageBuf = make(chan string, cfg.Dispatch.ChannelSize)
 
 	// Register the dispatch function, which will be called when the module
@@ -135,6 +173,9 @@ func dispatchIn(msg string) {
 	case messageBuf <- msg:
 	default:
 		dropCounter++
+		for _, o := range outputs {
+			o.spool.dropped(1)
+		}
 		// If we can't queue the message it is just dropped
 		now := time.Now()
 		if now.After(lastDrop.Add(time.Duration(time.Minute * 5))) {
@@ -147,66 +188,61 @@ func dispatchIn(msg string) {
 }
 
 func runDispatch(cfg config) error {
-	var (
-		httpClient http.Client
-		dr         DispatchRecord
-	)
-
-	if cfg.Dispatch.SNSTopic != "" {
-		err := initSNS(cfg)
-		if err != nil {
-			return err
-		}
+	for _, o := range outputs {
+		go o.run(cfg.Dispatch.BatchSize)
 	}
+	spoolMessages(cfg)
+	return nil
+}
 
+// spoolMessages formats the messages received by the module into records and
+// writes them to the spool of each output
+func spoolMessages(cfg config) {
 	for {
 		msg := <-messageBuf
-		dr.fromString(msg)
-		var buf []byte
-		var err error
-		if cfg.Dispatch.OutputMozdef {
-			// If we are set to output records to MozDef, convert the
-			// dispatch record into a gozdef event
-			ge, err := gozdef.NewEvent()
-			if err != nil {
-				logChan <- fmt.Sprintf("create gozdef event: %v", err)
-				continue
-			}
-			ge.Info()
-			ge.Summary = "mig dispatch event"
-			ge.Category = "mig"
-			ge.Details = dr
-			ge.Tags = append(ge.Tags, "mig-dispatch")
-			buf, err = json.Marshal(ge)
-			if err != nil {
-				logChan <- fmt.Sprintf("populate gozdef event: %v", err)
-				continue
-			}
-		} else {
-			buf, err = json.Marshal(dr)
-			if err != nil {
-				logChan <- fmt.Sprintf("create dispatch record: %v", err)
-				continue
+		buf, err := formatRecord(cfg, msg)
+		if err != nil {
+			logChan <- err.Error()
+			for _, o := range outputs {
+				o.spool.dropped(1)
 			}
+			continue
 		}
-		if cfg.Dispatch.SNSTopic != "" {
-			err := dispatchSNS(buf)
+		for _, o := range outputs {
+			err = o.spool.put(buf)
 			if err != nil {
-				logChan <- fmt.Sprintf("sns dispatch: %v", err)
-				continue
+				logChan <- fmt.Sprintf("spool %v record: %v", o.name, err)
 			}
-		} else {
-			// Default to HTTP POST
-			b := bytes.NewBuffer(buf)
-			resp, err := httpClient.Post(cfg.Dispatch.HTTPURL, "application/json", b)
-			if err != nil {
-				logChan <- fmt.Sprintf("http post: %v", err)
-				continue
-			}
-			resp.Body.Close()
 		}
 	}
-	return nil
+}
+
+func formatRecord(cfg config, msg string) (buf []byte, err error) {
+	var dr DispatchRecord
+	dr.fromString(msg)
+	if !cfg.Dispatch.OutputMozdef {
+		buf, err = json.Marshal(dr)
+		if err != nil {
+			return nil, fmt.Errorf("create dispatch record: %v", err)
+		}
+		return
+	}
+	// If we are set to output records to MozDef, convert the
+	// dispatch record into a gozdef event
+	ge, err := gozdef.NewEvent()
+	if err != nil {
+		return nil, fmt.Errorf("create gozdef event: %v", err)
+	}
+	ge.Info()
+	ge.Summary = "mig dispatch event"
+	ge.Category = "mig"
+	ge.Details = dr
+	ge.Tags = append(ge.Tags, "mig-dispatch")
+	buf, err = json.Marshal(ge)
+	if err != nil {
+		return nil, fmt.Errorf("populate gozdef event: %v", err)
+	}
+	return
 }
 
 func requestHandler(p interface{}) (ret string) {
@@ -221,6 +257,11 @@ func requestHandler(p interface{}) (ret string) {
 		}
 	}()
 	e := elements{Ok: true}
+	for _, o := range outputs {
+		st := o.spool.getStats()
+		st.Output = o.name
+		e.Spools = append(e.Spools, st)
+	}
 	resp, err := buildResults(e, &results)
 	if err != nil {
 		panic(err)
@@ -230,10 +271,29 @@ func requestHandler(p interface{}) (ret string) {
 
 type config struct {
 	Dispatch struct {
-		OutputMozdef bool   `json:"outputmozdef"`
-		HTTPURL      string `json:"httpurl"`
-		SNSTopic     string `json:"snstopic"`
-		ChannelSize  int    `json:"channelsize"`
+		OutputMozdef    bool   `json:"outputmozdef"`
+		HTTPURL         string `json:"httpurl"`
+		HTTPBearerToken string `json:"httpbearertoken"`
+		HTTPHMACKey     string `json:"httphmackey"`
+		HTTPCACert      string `json:"httpcacert"`
+		HTTPAgentCert   bool   `json:"httpagentcert"`
+		HTTPClientCert  string `json:"httpclientcert"`
+		HTTPClientKey   string `json:"httpclientkey"`
+		SNSTopic        string `json:"snstopic"`
+		ChannelSize     int    `json:"channelsize"`
+		SyslogAddress   string `json:"syslogaddress"`
+		SyslogCACert    string `json:"syslogcacert"`
+		SyslogFacility  int    `json:"syslogfacility"`
+		FileOutput      string `json:"fileoutput"`
+		FileMaxSize     int    `json:"filemaxsize"` // in megabytes
+		FileMaxFiles    int    `json:"filemaxfiles"`
+		KafkaBrokers    string `json:"kafkabrokers"`
+		KafkaTopic      string `json:"kafkatopic"`
+		KafkaPartition  int    `json:"kafkapartition"`
+		SpoolDir        string `json:"spooldir"`
+		SpoolMaxSize    int    `json:"spoolmaxsize"` // in megabytes
+		SpoolMaxAge     string `json:"spoolmaxage"`
+		BatchSize       int    `json:"batchsize"`
 	} `json:"dispatch"`
 }
 
@@ -304,6 +364,20 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	}
 	resStr := fmt.Sprintf("ok:%v", elem.Ok)
 	prints = append(prints, resStr)
+	for _, st := range elem.Spools {
+		resStr = fmt.Sprintf("output:%v spool:%v records:%v bytes:%v dispatched:%v failures:%v dropped:%v rejected:%v",
+			st.Output, st.Directory, st.Records, st.Bytes, st.Dispatched, st.Failures, st.Dropped, st.Rejected)
+		if !st.Oldest.IsZero() {
+			resStr += fmt.Sprintf(" oldest:%v", st.Oldest.UTC().Format(time.RFC3339))
+		}
+		if !st.LastSuccess.IsZero() {
+			resStr += fmt.Sprintf(" lastsuccess:%v", st.LastSuccess.UTC().Format(time.RFC3339))
+		}
+		if st.LastError != "" {
+			resStr += fmt.Sprintf(" lasterror:%q", st.LastError)
+		}
+		prints = append(prints, resStr)
+	}
 	if !foundOnly {
 		for _, we := range result.Errors {
 			prints = append(prints, we)
@@ -313,7 +387,8 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 }
 
 type elements struct {
-	Ok bool `json:"ok"`
+	Ok     bool         `json:"ok"`
+	Spools []spoolStats `json:"spools,omitempty"`
 }
 
 // Parameters defines any query parameters used in this module.
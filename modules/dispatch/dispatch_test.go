@@ -7,10 +7,625 @@
 package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
 
 import (
-	"github.com/mozilla/mig/testutil"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mozilla/mig/modules"
+	"github.com/mozilla/mig/testutil"
 )
 
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "dispatch")
 }
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	for _, r := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		err = s.put([]byte(r))
+		if err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	recs, bufs := s.batch(2)
+	if len(recs) != 2 || string(bufs[0]) != `{"n":1}` || string(bufs[1]) != `{"n":2}` {
+		t.Fatalf("unexpected batch %q", bufs)
+	}
+	s.remove(recs[:1])
+
+	// records that were not dispatched are reloaded by a new spool, and
+	// partially written records are discarded
+	err = ioutil.WriteFile(path.Join(dir, "0000000000000000001-0000000001.rec.tmp"), []byte("{"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s, err = newSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	st := s.getStats()
+	if st.Records != 2 || st.Bytes != 14 {
+		t.Fatalf("unexpected stats after reload %+v", st)
+	}
+	_, bufs = s.batch(10)
+	if len(bufs) != 2 || string(bufs[0]) != `{"n":2}` {
+		t.Fatalf("unexpected batch after reload %q", bufs)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files in spool, got %d", len(files))
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newSpool(dir, 20, 0)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	for _, r := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		s.put([]byte(r))
+	}
+	st := s.getStats()
+	if st.Records != 2 || st.Dropped != 1 {
+		t.Fatalf("expected the oldest record to be dropped, got %+v", st)
+	}
+	_, bufs := s.batch(1)
+	if string(bufs[0]) != `{"n":2}` {
+		t.Fatalf("unexpected oldest record %q", bufs[0])
+	}
+
+	s, err = newSpool(dir, 0, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	st = s.getStats()
+	if st.Records != 0 || st.Dropped != 2 {
+		t.Fatalf("expected expired records to be dropped, got %+v", st)
+	}
+}
+
+func TestHTTPOutput(t *testing.T) {
+	var (
+		bodies [][]byte
+		status = http.StatusOK
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, buf)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	o := &httpOutput{url: ts.URL}
+	n, err := o.send([][]byte{[]byte(`{"n":1}`)})
+	if err != nil || n != 1 || string(bodies[0]) != `{"n":1}` {
+		t.Fatalf("unexpected single record dispatch: %v, %v, %q", n, err, bodies)
+	}
+	o.batch = true
+	n, err = o.send([][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)})
+	if err != nil || n != 2 {
+		t.Fatalf("unexpected batch dispatch: %v, %v", n, err)
+	}
+	var batch []map[string]int
+	err = json.Unmarshal(bodies[1], &batch)
+	if err != nil || len(batch) != 2 || batch[1]["n"] != 2 {
+		t.Fatalf("unexpected batch body %q: %v", bodies[1], err)
+	}
+	for _, c := range []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusUnauthorized, true},
+	} {
+		status = c.status
+		n, err = o.send([][]byte{[]byte(`{"n":3}`)})
+		if err == nil || n != 0 || isPermanent(err) != c.permanent {
+			t.Fatalf("unexpected dispatch result for status %d: %v, %v", c.status, n, err)
+		}
+	}
+}
+
+// rejectingOutput accepts records, unless a batch includes a record it rejects
+type rejectingOutput struct {
+	sent   []string
+	reject string
+}
+
+func (o *rejectingOutput) send(bufs [][]byte) (n int, err error) {
+	for _, buf := range bufs {
+		if string(buf) == o.reject {
+			return 0, permanentError{fmt.Errorf("record %s rejected", buf)}
+		}
+	}
+	for _, buf := range bufs {
+		o.sent = append(o.sent, string(buf))
+	}
+	return len(bufs), nil
+}
+
+func TestOutputRunRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := newSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+	for _, r := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`} {
+		s.put([]byte(r))
+	}
+	logChan = make(chan string, 64)
+	go func() {
+		for range logChan {
+		}
+	}()
+	o := &rejectingOutput{reject: `{"n":2}`}
+	go dispatchOutput{name: "test", out: o, spool: s}.run(3)
+	var st spoolStats
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		st = s.getStats()
+		if st.Records == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Records != 0 || st.Dispatched != 3 || st.Rejected != 1 || st.Failures != 0 {
+		t.Fatalf("expected the rejected record to be dropped, got %+v", st)
+	}
+	if strings.Join(o.sent, ",") != `{"n":1},{"n":3},{"n":4}` {
+		t.Fatalf("unexpected records dispatched %q", o.sent)
+	}
+}
+
+func TestHTTPOutputAuth(t *testing.T) {
+	// issue a client certificate for the agent from a test CA
+	cakey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	catmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mig test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	cader, err := x509.CreateCertificate(rand.Reader, catmpl, catmpl, &cakey.PublicKey, cakey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(cader)
+	agentkey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	agenttmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "agent.example.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	agentder, err := x509.CreateCertificate(rand.Reader, agenttmpl, ca, &agentkey.PublicKey, cakey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyder, _ := x509.MarshalECPrivateKey(agentkey)
+	modules.AgentCert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: agentder})
+	modules.AgentKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder})
+	defer func() {
+		modules.AgentCert = nil
+		modules.AgentKey = nil
+	}()
+
+	hmackey := []byte("collector secret")
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "agent.example.net" {
+			http.Error(w, "missing client certificate", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		body, err := VerifyRequest(r, hmackey, time.Minute)
+		if err != nil || string(body) != `{"n":1}` {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	ts.TLS.ClientCAs.AddCert(ca)
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cacert := path.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(cacert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	var cfg config
+	cfg.Dispatch.HTTPURL = ts.URL
+	cfg.Dispatch.HTTPCACert = cacert
+	cfg.Dispatch.HTTPAgentCert = true
+	cfg.Dispatch.HTTPBearerToken = "s3cr3t"
+	cfg.Dispatch.HTTPHMACKey = string(hmackey)
+	o, err := newHTTPOutput(cfg)
+	if err != nil {
+		t.Fatalf("newHTTPOutput: %v", err)
+	}
+	n, err := o.send([][]byte{[]byte(`{"n":1}`)})
+	if err != nil || n != 1 {
+		t.Fatalf("authenticated send: %v, %v", n, err)
+	}
+	for _, tc := range []struct {
+		mod  func(c *config)
+		want string
+	}{
+		{func(c *config) { c.Dispatch.HTTPAgentCert = false }, "certificate"},
+		{func(c *config) { c.Dispatch.HTTPBearerToken = "wrong" }, "401"},
+		{func(c *config) { c.Dispatch.HTTPHMACKey = "wrong" }, "401"},
+		{func(c *config) { c.Dispatch.HTTPCACert = "" }, "certificate"},
+	} {
+		c := cfg
+		tc.mod(&c)
+		o, err := newHTTPOutput(c)
+		if err != nil {
+			t.Fatalf("newHTTPOutput: %v", err)
+		}
+		_, err = o.send([][]byte{[]byte(`{"n":1}`)})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("expected error containing %q, got %v", tc.want, err)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	key := []byte("collector secret")
+	body := []byte(`{"n":1}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := VerifySignature(key, now, Sign(key, now, body), body, time.Minute); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := VerifySignature(key, old, Sign(key, old, body), body, 0); err != nil {
+		t.Fatalf("valid signature rejected without timestamp check: %v", err)
+	}
+	for _, tc := range []struct {
+		ts, sig string
+		body    []byte
+	}{
+		{now, Sign(key, now, body), []byte(`{"n":2}`)},
+		{now, Sign([]byte("other"), now, body), body},
+		{old, Sign(key, old, body), body},
+		{old, Sign(key, now, body), body},
+		{now, "", body},
+	} {
+		if VerifySignature(key, tc.ts, tc.sig, tc.body, time.Minute) == nil {
+			t.Errorf("signature %q of %q at %s should be rejected", tc.sig, tc.body, tc.ts)
+		}
+	}
+}
+
+func TestSyslogOutput(t *testing.T) {
+	// udp, one message per datagram
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	o, err := newSyslogOutput("udp://"+pc.LocalAddr().String(), "", 16)
+	if err != nil {
+		t.Fatalf("newSyslogOutput: %v", err)
+	}
+	o.hostname = "agent.example.net"
+	n, err := o.send([][]byte{[]byte(`{"n":1}`)})
+	if err != nil || n != 1 {
+		t.Fatalf("udp send: %v, %v", n, err)
+	}
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	l, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	re := regexp.MustCompile(`^<133>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z agent\.example\.net mig-agent \d+ dispatch - \{"n":1\}$`)
+	if !re.Match(buf[:l]) {
+		t.Fatalf("unexpected syslog message %q", buf[:l])
+	}
+
+	// tls with octet counting framing, using the certificate of a test server
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cacert := path.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(cacert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", ts.TLS)
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var all []byte
+		buf := make([]byte, 1024)
+		for {
+			n, err := c.Read(buf)
+			all = append(all, buf[:n]...)
+			if err != nil || bytes.Count(all, []byte("dispatch - ")) == 2 {
+				break
+			}
+		}
+		received <- all
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	o, err = newSyslogOutput("tls://example.com:"+port, cacert, 0)
+	if err != nil {
+		t.Fatalf("newSyslogOutput: %v", err)
+	}
+	o.addr = ln.Addr().String()
+	n, err = o.send([][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)})
+	if err != nil || n != 2 {
+		t.Fatalf("tls send: %v, %v", n, err)
+	}
+	all := <-received
+	for _, want := range []string{`{"n":1}`, `{"n":2}`} {
+		sp := bytes.IndexByte(all, ' ')
+		if sp == -1 {
+			t.Fatalf("missing frame in %q", all)
+		}
+		l, err := strconv.Atoi(string(all[:sp]))
+		if err != nil || len(all) < sp+1+l {
+			t.Fatalf("invalid frame in %q", all)
+		}
+		msg := all[sp+1 : sp+1+l]
+		if !bytes.HasPrefix(msg, []byte("<13>1 ")) || !bytes.HasSuffix(msg, []byte(want)) {
+			t.Fatalf("unexpected syslog message %q", msg)
+		}
+		all = all[sp+1+l:]
+	}
+
+	for _, addr := range []string{"syslog.example.net:514", "http://syslog.example.net", "udp://"} {
+		_, err = newSyslogOutput(addr, "", 0)
+		if err == nil {
+			t.Errorf("syslog address %q should be invalid", addr)
+		}
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migdispatch")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "dispatch.json")
+	o := newFileOutput(file, 1, 2)
+	o.maxsize = 12
+	for i := 1; i <= 4; i++ {
+		n, err := o.send([][]byte{[]byte(fmt.Sprintf(`{"n":%d}`, i)), []byte(`{}`)})
+		if err != nil || n != 2 {
+			t.Fatalf("send: %v, %v", n, err)
+		}
+	}
+	for name, want := range map[string]string{
+		file:        "{\"n\":4}\n{}\n",
+		file + ".1": "{\"n\":3}\n{}\n",
+		file + ".2": "{\"n\":2}\n{}\n",
+	} {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil || string(buf) != want {
+			t.Fatalf("unexpected content of %s: %q, %v", name, buf, err)
+		}
+	}
+	if _, err = os.Stat(file + ".3"); err == nil {
+		t.Fatalf("expected at most 2 rotated files")
+	}
+}
+
+func TestKafkaOutput(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		recs, correlation, err := readProduceRequest(c)
+		if err != nil {
+			received <- []string{err.Error()}
+			return
+		}
+		received <- recs
+		resp := produceResponse(correlation, 0)
+		binary.Write(c, binary.BigEndian, int32(len(resp)))
+		c.Write(resp)
+	}()
+	o, err := newKafkaOutput(" "+ln.Addr().String()+", ", "mig", 2)
+	if err != nil {
+		t.Fatalf("newKafkaOutput: %v", err)
+	}
+	n, err := o.send([][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)})
+	if err != nil || n != 2 {
+		t.Fatalf("kafka send: %v, %v", n, err)
+	}
+	recs := <-received
+	if len(recs) != 2 || recs[0] != `{"n":1}` || recs[1] != `{"n":2}` {
+		t.Fatalf("unexpected records received by broker %q", recs)
+	}
+
+	// the broker closed the connection, the next attempt fails
+	n, err = o.send([][]byte{[]byte(`{"n":3}`)})
+	if err == nil || n != 0 || o.conn != nil {
+		t.Fatalf("expected send to a closed broker to fail, got %v, %v", n, err)
+	}
+}
+
+func TestKafkaResponseErrors(t *testing.T) {
+	o, err := newKafkaOutput("127.0.0.1", "mig", 2)
+	if err != nil {
+		t.Fatalf("newKafkaOutput: %v", err)
+	}
+	o.correlation = 7
+	for _, c := range []struct {
+		code      int16
+		permanent bool
+	}{
+		{6, false},
+		{7, false},
+		{10, true},
+	} {
+		err = o.parseResponse(produceResponse(7, c.code))
+		if err == nil || isPermanent(err) != c.permanent {
+			t.Fatalf("unexpected result for error %d: %v", c.code, err)
+		}
+	}
+}
+
+// produceResponse returns a produce response for partition 2 of topic mig,
+// with an error code
+func produceResponse(correlation int32, code int16) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, correlation)
+	binary.Write(&b, binary.BigEndian, int32(1))
+	kafkaString(&b, "mig")
+	binary.Write(&b, binary.BigEndian, int32(1))
+	binary.Write(&b, binary.BigEndian, int32(2))
+	binary.Write(&b, binary.BigEndian, code)
+	binary.Write(&b, binary.BigEndian, int64(42))
+	binary.Write(&b, binary.BigEndian, int64(-1))
+	binary.Write(&b, binary.BigEndian, int32(0))
+	return b.Bytes()
+}
+
+// readProduceRequest decodes a produce request sent by the kafka output to
+// partition 2 of topic mig, and returns the values of its records
+func readProduceRequest(r io.Reader) (recs []string, correlation int32, err error) {
+	var size int32
+	err = binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return
+	}
+	req := make([]byte, size)
+	_, err = io.ReadFull(r, req)
+	if err != nil {
+		return
+	}
+	br := bytes.NewReader(req)
+	var (
+		key, version, txid, acks int16
+		timeout, ntopics, nparts int32
+		partition, batchlen      int32
+	)
+	binary.Read(br, binary.BigEndian, &key)
+	binary.Read(br, binary.BigEndian, &version)
+	binary.Read(br, binary.BigEndian, &correlation)
+	client, _ := readKafkaString(br)
+	binary.Read(br, binary.BigEndian, &txid)
+	binary.Read(br, binary.BigEndian, &acks)
+	binary.Read(br, binary.BigEndian, &timeout)
+	binary.Read(br, binary.BigEndian, &ntopics)
+	topic, _ := readKafkaString(br)
+	binary.Read(br, binary.BigEndian, &nparts)
+	binary.Read(br, binary.BigEndian, &partition)
+	binary.Read(br, binary.BigEndian, &batchlen)
+	if key != 0 || version != 3 || client != "mig-agent" || txid != -1 || acks != 1 ||
+		ntopics != 1 || topic != "mig" || nparts != 1 || partition != 2 {
+		return nil, 0, fmt.Errorf("unexpected request header")
+	}
+	batch := make([]byte, batchlen)
+	_, err = io.ReadFull(br, batch)
+	if err != nil || br.Len() != 0 {
+		return nil, 0, fmt.Errorf("invalid record batch length")
+	}
+	if len(batch) < 61 || int(binary.BigEndian.Uint32(batch[8:12])) != len(batch)-12 || batch[16] != 2 {
+		return nil, 0, fmt.Errorf("invalid record batch header")
+	}
+	if binary.BigEndian.Uint32(batch[17:21]) != crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) {
+		return nil, 0, fmt.Errorf("invalid record batch crc")
+	}
+	count := binary.BigEndian.Uint32(batch[57:61])
+	rr := bytes.NewReader(batch[61:])
+	for i := uint32(0); i < count; i++ {
+		var fields [6]int64
+		if _, err = binary.ReadVarint(rr); err != nil {
+			return
+		}
+		rr.ReadByte()
+		for j := 0; j < 4; j++ {
+			if fields[j], err = binary.ReadVarint(rr); err != nil {
+				return
+			}
+		}
+		if fields[1] != int64(i) || fields[2] != -1 {
+			return nil, 0, fmt.Errorf("invalid record %d", i)
+		}
+		value := make([]byte, fields[3])
+		io.ReadFull(rr, value)
+		binary.ReadVarint(rr)
+		recs = append(recs, string(value))
+	}
+	return
+}
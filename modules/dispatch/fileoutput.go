@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"fmt"
+	"os"
+)
+
+// fileOutput appends records to a file, one JSON record per line. The file is
+// rotated once it exceeds its maximum size, keeping up to maxfiles previous
+// files named after the file with a .1, .2, ... suffix, .1 being the most
+// recent.
+type fileOutput struct {
+	path     string
+	maxsize  int64
+	maxfiles int
+	fd       *os.File
+	size     int64
+}
+
+// newFileOutput returns a file output, maxsize is in megabytes and defaults
+// to 10, and maxfiles defaults to 5
+func newFileOutput(path string, maxsize, maxfiles int) *fileOutput {
+	if maxsize == 0 {
+		maxsize = 10
+	}
+	if maxfiles == 0 {
+		maxfiles = 5
+	}
+	return &fileOutput{
+		path:     path,
+		maxsize:  int64(maxsize) * 1024 * 1024,
+		maxfiles: maxfiles,
+	}
+}
+
+func (o *fileOutput) open() error {
+	fd, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	o.fd = fd
+	o.size = fi.Size()
+	return nil
+}
+
+func (o *fileOutput) close() {
+	if o.fd != nil {
+		o.fd.Close()
+		o.fd = nil
+	}
+}
+
+// rotate shifts the previous files and renames the current file with a .1
+// suffix, the oldest file is overwritten
+func (o *fileOutput) rotate() error {
+	o.close()
+	for i := o.maxfiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", o.path, i), fmt.Sprintf("%s.%d", o.path, i+1))
+	}
+	err := os.Rename(o.path, o.path+".1")
+	if err != nil {
+		return err
+	}
+	return o.open()
+}
+
+// send appends records to the file, and syncs it to disk before returning
+func (o *fileOutput) send(bufs [][]byte) (n int, err error) {
+	defer func() {
+		if err != nil {
+			o.close()
+			err = fmt.Errorf("file output: %v", err)
+		}
+	}()
+	if o.fd == nil {
+		err = o.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	for i, buf := range bufs {
+		line := append(buf, '\n')
+		if o.size > 0 && o.size+int64(len(line)) > o.maxsize {
+			err = o.rotate()
+			if err != nil {
+				return i, err
+			}
+		}
+		_, err = o.fd.Write(line)
+		if err != nil {
+			return i, err
+		}
+		o.size += int64(len(line))
+	}
+	err = o.fd.Sync()
+	if err != nil {
+		return 0, err
+	}
+	return len(bufs), nil
+}
@@ -0,0 +1,290 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// kafkaOutput is a minimal kafka producer. It sends records in batches to a
+// single partition of a topic using version 3 of the produce request, which
+// is supported by kafka 0.11 and later, and waits for the leader of the
+// partition to acknowledge them. The partition leader is not discovered from
+// the cluster metadata: the brokers are tried in turn until one of them
+// accepts the records.
+type kafkaOutput struct {
+	brokers     []string
+	cur         int // index of the broker in use
+	topic       string
+	partition   int32
+	timeout     time.Duration
+	conn        net.Conn
+	correlation int32
+}
+
+const (
+	kafkaProduceKey     = 0
+	kafkaProduceVersion = 3
+	kafkaClientID       = "mig-agent"
+)
+
+// kafkaErrors are the names of the errors a broker commonly returns to a
+// producer
+var kafkaErrors = map[int16]string{
+	2:  "corrupt message",
+	3:  "unknown topic or partition",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	29: "topic authorization failed",
+}
+
+// kafkaPermanentErrors are the errors returned by a broker that are caused by
+// the records themselves, and which the records can't be sent again after
+var kafkaPermanentErrors = map[int16]bool{
+	10: true,
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// newKafkaOutput returns a kafka output for a comma separated list of brokers
+func newKafkaOutput(brokers, topic string, partition int) (*kafkaOutput, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("kafkatopic must be set to use kafka")
+	}
+	if partition < 0 {
+		return nil, fmt.Errorf("invalid kafka partition %d", partition)
+	}
+	o := &kafkaOutput{
+		topic:     topic,
+		partition: int32(partition),
+		timeout:   30 * time.Second,
+	}
+	for _, b := range strings.Split(brokers, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			b = net.JoinHostPort(b, "9092")
+		}
+		o.brokers = append(o.brokers, b)
+	}
+	if len(o.brokers) == 0 {
+		return nil, fmt.Errorf("no kafka broker configured")
+	}
+	return o, nil
+}
+
+// send produces a batch of records, which are either all accepted or all
+// rejected by the broker. On failure, the next attempt uses the next broker.
+func (o *kafkaOutput) send(bufs [][]byte) (n int, err error) {
+	defer func() {
+		if err != nil {
+			if o.conn != nil {
+				o.conn.Close()
+				o.conn = nil
+			}
+			if pe, ok := err.(permanentError); ok {
+				err = permanentError{fmt.Errorf("kafka %s: %v", o.brokers[o.cur], pe.err)}
+			} else {
+				err = fmt.Errorf("kafka %s: %v", o.brokers[o.cur], err)
+			}
+			o.cur = (o.cur + 1) % len(o.brokers)
+		}
+	}()
+	if o.conn == nil {
+		o.conn, err = net.DialTimeout("tcp", o.brokers[o.cur], o.timeout)
+		if err != nil {
+			return 0, err
+		}
+	}
+	o.correlation++
+	o.conn.SetDeadline(time.Now().Add(2 * o.timeout))
+	_, err = o.conn.Write(o.produceRequest(bufs, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	var size int32
+	err = binary.Read(o.conn, binary.BigEndian, &size)
+	if err != nil {
+		return 0, err
+	}
+	if size < 4 || size > 1024*1024 {
+		return 0, fmt.Errorf("invalid response size %d", size)
+	}
+	resp := make([]byte, size)
+	_, err = io.ReadFull(o.conn, resp)
+	if err != nil {
+		return 0, err
+	}
+	err = o.parseResponse(resp)
+	if err != nil {
+		return 0, err
+	}
+	return len(bufs), nil
+}
+
+// produceRequest returns a size delimited produce request for a batch of
+// records
+func (o *kafkaOutput) produceRequest(bufs [][]byte, ts time.Time) []byte {
+	var b bytes.Buffer
+	// request header
+	binary.Write(&b, binary.BigEndian, int16(kafkaProduceKey))
+	binary.Write(&b, binary.BigEndian, int16(kafkaProduceVersion))
+	binary.Write(&b, binary.BigEndian, o.correlation)
+	kafkaString(&b, kafkaClientID)
+	// null transactional id, acks from the leader only, and timeout
+	binary.Write(&b, binary.BigEndian, int16(-1))
+	binary.Write(&b, binary.BigEndian, int16(1))
+	binary.Write(&b, binary.BigEndian, int32(o.timeout/time.Millisecond))
+	// a single topic with a single partition
+	binary.Write(&b, binary.BigEndian, int32(1))
+	kafkaString(&b, o.topic)
+	binary.Write(&b, binary.BigEndian, int32(1))
+	binary.Write(&b, binary.BigEndian, o.partition)
+	batch := recordBatch(bufs, ts)
+	binary.Write(&b, binary.BigEndian, int32(len(batch)))
+	b.Write(batch)
+
+	req := make([]byte, 4, 4+b.Len())
+	binary.BigEndian.PutUint32(req, uint32(b.Len()))
+	return append(req, b.Bytes()...)
+}
+
+// recordBatch encodes records in a version 2 record batch, without key and
+// without compression
+func recordBatch(bufs [][]byte, ts time.Time) []byte {
+	var recs bytes.Buffer
+	for i, buf := range bufs {
+		var r bytes.Buffer
+		r.WriteByte(0) // attributes
+		kafkaVarint(&r, 0)
+		kafkaVarint(&r, int64(i))
+		kafkaVarint(&r, -1) // null key
+		kafkaVarint(&r, int64(len(buf)))
+		r.Write(buf)
+		kafkaVarint(&r, 0) // no header
+		kafkaVarint(&recs, int64(r.Len()))
+		recs.Write(r.Bytes())
+	}
+	msec := ts.UnixNano() / int64(time.Millisecond)
+	// the crc covers the batch from the attributes to the end
+	var body bytes.Buffer
+	binary.Write(&body, binary.BigEndian, int16(0))
+	binary.Write(&body, binary.BigEndian, int32(len(bufs)-1))
+	binary.Write(&body, binary.BigEndian, msec)
+	binary.Write(&body, binary.BigEndian, msec)
+	// no producer id, epoch or sequence: the producer is not idempotent
+	binary.Write(&body, binary.BigEndian, int64(-1))
+	binary.Write(&body, binary.BigEndian, int16(-1))
+	binary.Write(&body, binary.BigEndian, int32(-1))
+	binary.Write(&body, binary.BigEndian, int32(len(bufs)))
+	body.Write(recs.Bytes())
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, int64(0)) // base offset, set by the broker
+	// length of the batch after this field: partition leader epoch, magic,
+	// crc and body
+	binary.Write(&b, binary.BigEndian, int32(4+1+4+body.Len()))
+	binary.Write(&b, binary.BigEndian, int32(-1))
+	b.WriteByte(2)
+	binary.Write(&b, binary.BigEndian, crc32.Checksum(body.Bytes(), crc32c))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// parseResponse returns an error if a produce response does not acknowledge
+// the records
+func (o *kafkaOutput) parseResponse(resp []byte) (err error) {
+	r := bytes.NewReader(resp)
+	var (
+		correlation, ntopics, nparts, partition int32
+		code                                    int16
+		offset, appendTime                      int64
+	)
+	err = binary.Read(r, binary.BigEndian, &correlation)
+	if err != nil {
+		return
+	}
+	if correlation != o.correlation {
+		return fmt.Errorf("unexpected correlation id %d in response", correlation)
+	}
+	err = binary.Read(r, binary.BigEndian, &ntopics)
+	if err != nil {
+		return
+	}
+	found := false
+	for i := int32(0); i < ntopics; i++ {
+		topic, err := readKafkaString(r)
+		if err != nil {
+			return err
+		}
+		err = binary.Read(r, binary.BigEndian, &nparts)
+		if err != nil {
+			return err
+		}
+		for j := int32(0); j < nparts; j++ {
+			for _, v := range []interface{}{&partition, &code, &offset, &appendTime} {
+				err = binary.Read(r, binary.BigEndian, v)
+				if err != nil {
+					return err
+				}
+			}
+			if topic != o.topic || partition != o.partition {
+				continue
+			}
+			if code != 0 {
+				name, ok := kafkaErrors[code]
+				if !ok {
+					name = "unknown error"
+				}
+				err = fmt.Errorf("broker returned error %d: %s", code, name)
+				if kafkaPermanentErrors[code] {
+					err = permanentError{err}
+				}
+				return err
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("response does not include partition %d of topic %s", o.partition, o.topic)
+	}
+	return nil
+}
+
+func kafkaString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, int16(len(s)))
+	b.WriteString(s)
+}
+
+func readKafkaString(r io.Reader) (string, error) {
+	var l int16
+	err := binary.Read(r, binary.BigEndian, &l)
+	if err != nil {
+		return "", err
+	}
+	if l < 0 {
+		return "", nil
+	}
+	buf := make([]byte, l)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// kafkaVarint writes a zigzag encoded variable length integer
+func kafkaVarint(b *bytes.Buffer, v int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, v)
+	b.Write(buf[:n])
+}
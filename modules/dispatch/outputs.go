@@ -0,0 +1,255 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig/modules"
+)
+
+// output is a destination records are dispatched to
+type output interface {
+	// send dispatches records, and returns the number of records that were
+	// dispatched before an error occurred
+	send(bufs [][]byte) (n int, err error)
+}
+
+// dispatchOutput is an output configured in the module, with the spool that
+// stores the records waiting to be sent to it. Each output has its own spool
+// so an output that is down does not delay the others.
+type dispatchOutput struct {
+	name  string
+	out   output
+	spool *spool
+}
+
+// Dispatch attempts that fail are retried with an exponential backoff between
+// these delays
+const (
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 10 * time.Minute
+)
+
+// permanentError is returned by outputs when the collector rejected the
+// records themselves, for example because they are too large, so sending them
+// again would fail the same way
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// isPermanent returns true if a dispatch error should not be retried
+func isPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+// newOutputs returns the outputs enabled in the configuration of the module
+func newOutputs(cfg config) (outs []dispatchOutput, err error) {
+	if cfg.Dispatch.HTTPURL != "" {
+		o, err := newHTTPOutput(cfg)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, dispatchOutput{name: "http", out: o})
+	}
+	if cfg.Dispatch.SNSTopic != "" {
+		o, err := newSNSOutput(cfg.Dispatch.SNSTopic)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, dispatchOutput{name: "sns", out: o})
+	}
+	if cfg.Dispatch.SyslogAddress != "" {
+		o, err := newSyslogOutput(cfg.Dispatch.SyslogAddress, cfg.Dispatch.SyslogCACert,
+			cfg.Dispatch.SyslogFacility)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, dispatchOutput{name: "syslog", out: o})
+	}
+	if cfg.Dispatch.FileOutput != "" {
+		o := newFileOutput(cfg.Dispatch.FileOutput, cfg.Dispatch.FileMaxSize, cfg.Dispatch.FileMaxFiles)
+		outs = append(outs, dispatchOutput{name: "file", out: o})
+	}
+	if cfg.Dispatch.KafkaBrokers != "" {
+		o, err := newKafkaOutput(cfg.Dispatch.KafkaBrokers, cfg.Dispatch.KafkaTopic, cfg.Dispatch.KafkaPartition)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, dispatchOutput{name: "kafka", out: o})
+	}
+	if len(outs) == 0 {
+		return nil, fmt.Errorf("no output configured")
+	}
+	return
+}
+
+// run dispatches the records of the spool of the output in batches, oldest
+// first. Records are only removed from the spool once they have been
+// dispatched, and failed attempts are retried with an exponential backoff.
+// Records the collector rejects permanently are dropped from the spool: when
+// a batch is rejected, its records are sent again one by one so only the ones
+// at fault are dropped.
+func (d dispatchOutput) run(batchsize int) {
+	var isolate int // number of records to send one by one
+	delay := minRetryDelay
+	for {
+		size := batchsize
+		if isolate > 0 {
+			size = 1
+		}
+		recs, bufs := d.spool.batch(size)
+		if len(recs) == 0 {
+			isolate = 0
+			<-d.spool.notify
+			continue
+		}
+		n, err := d.out.send(bufs)
+		d.spool.remove(recs[:n])
+		if isolate > 0 && (err == nil || isPermanent(err)) {
+			isolate--
+		}
+		if isPermanent(err) {
+			if len(recs)-n > 1 {
+				isolate = len(recs) - n
+				logChan <- fmt.Sprintf("%v rejected a batch of %v records, sending them one by one: %v",
+					d.name, isolate, err)
+				continue
+			}
+			d.spool.reject(recs[n:], err)
+			logChan <- fmt.Sprintf("%v rejected a record, dropping it: %v", d.name, err)
+			delay = minRetryDelay
+			continue
+		}
+		if err != nil {
+			d.spool.failed(err)
+			logChan <- fmt.Sprintf("%v dispatch failed, retrying in %v: %v", d.name, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			continue
+		}
+		delay = minRetryDelay
+	}
+}
+
+// httpOutput posts records to an HTTP endpoint. A batch of records is posted
+// as a JSON array, unless batching is disabled in which case each record is
+// posted on its own. Requests are authenticated with a bearer token, a client
+// certificate, or both, and signed with a HMAC key, when they are configured.
+type httpOutput struct {
+	url     string
+	batch   bool
+	token   string
+	hmackey []byte
+	client  http.Client
+}
+
+func newHTTPOutput(cfg config) (o *httpOutput, err error) {
+	o = &httpOutput{
+		url:     cfg.Dispatch.HTTPURL,
+		batch:   cfg.Dispatch.BatchSize > 1,
+		token:   cfg.Dispatch.HTTPBearerToken,
+		hmackey: []byte(cfg.Dispatch.HTTPHMACKey),
+	}
+	tlsConfig := &tls.Config{}
+	if cfg.Dispatch.HTTPCACert != "" {
+		pem, err := ioutil.ReadFile(cfg.Dispatch.HTTPCACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.Dispatch.HTTPCACert)
+		}
+	}
+	switch {
+	case cfg.Dispatch.HTTPClientCert != "" || cfg.Dispatch.HTTPClientKey != "":
+		if cfg.Dispatch.HTTPAgentCert {
+			return nil, fmt.Errorf("httpagentcert and httpclientcert cannot be combined")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.Dispatch.HTTPClientCert, cfg.Dispatch.HTTPClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("http client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case cfg.Dispatch.HTTPAgentCert:
+		cert, err := tls.X509KeyPair(modules.AgentCert, modules.AgentKey)
+		if err != nil {
+			return nil, fmt.Errorf("agent certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	o.client = http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return o, nil
+}
+
+func (o *httpOutput) send(bufs [][]byte) (n int, err error) {
+	body := bufs[0]
+	if o.batch {
+		var b bytes.Buffer
+		b.WriteString("[")
+		for i, buf := range bufs {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.Write(buf)
+		}
+		b.WriteString("]")
+		body = b.Bytes()
+	} else {
+		bufs = bufs[:1]
+	}
+	req, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("http post: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	if len(o.hmackey) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(o.hmackey, ts, body))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("http post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("http post: %v", strings.TrimSpace(resp.Status))
+		// client errors mean the collector won't accept the records, except
+		// when it timed out reading them or asks to slow down
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+			resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			err = permanentError{err}
+		}
+		return 0, err
+	}
+	return len(bufs), nil
+}
@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set by the HTTP output when a HMAC key is configured. The signature
+// is the hex encoded HMAC-SHA256 of the timestamp, a dot and the body of the
+// request, prefixed with "sha256=".
+const (
+	SignatureHeader = "X-Mig-Signature"
+	TimestampHeader = "X-Mig-Timestamp"
+)
+
+// Sign returns the signature of a request body sent at a given unix timestamp
+func Sign(key []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the signature of a request body, and that its
+// timestamp is no more than maxAge away from the current time. A maxAge of 0
+// disables the timestamp check.
+func VerifySignature(key []byte, timestamp, signature string, body []byte, maxAge time.Duration) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return fmt.Errorf("missing or unsupported signature")
+	}
+	if !hmac.Equal([]byte(Sign(key, timestamp, body)), []byte(signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if maxAge == 0 {
+		return nil
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	age := time.Since(time.Unix(ts, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("timestamp is too far from the current time")
+	}
+	return nil
+}
+
+// VerifyRequest reads the body of a request sent by the HTTP output of the
+// dispatch module and verifies its signature. It returns the body if the
+// signature is valid, and leaves the body of the request readable again.
+//
+// A collector would use it as follows:
+//
+//	body, err := dispatch.VerifyRequest(r, key, 5*time.Minute)
+//	if err != nil {
+//		http.Error(w, err.Error(), http.StatusUnauthorized)
+//		return
+//	}
+func VerifyRequest(r *http.Request, key []byte, maxAge time.Duration) (body []byte, err error) {
+	body, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	err = VerifySignature(key, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, maxAge)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -7,40 +7,52 @@
 package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 )
 
-var snsARN string
-var snsService *sns.SNS
-var awsSession *session.Session
+// snsOutput publishes records to an SNS topic in the region and account of the
+// instance the agent runs on
+type snsOutput struct {
+	arn     string
+	service *sns.SNS
+}
 
-func initSNS(cfg config) error {
-	awsSession = session.Must(session.NewSession())
+func newSNSOutput(topic string) (*snsOutput, error) {
+	awsSession := session.Must(session.NewSession())
 
 	meta := ec2metadata.New(awsSession)
 	instancedoc, err := meta.GetInstanceIdentityDocument()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Build an ARN we will use to publish
-	snsARN = "arn:aws:sns:" + instancedoc.Region + ":" +
-		instancedoc.AccountID + ":" + cfg.Dispatch.SNSTopic
-
-	snsService = sns.New(awsSession, &aws.Config{
+	o := &snsOutput{
+		arn: "arn:aws:sns:" + instancedoc.Region + ":" +
+			instancedoc.AccountID + ":" + topic,
+	}
+	o.service = sns.New(awsSession, &aws.Config{
 		Region: &instancedoc.Region,
 	})
-	return nil
+	return o, nil
 }
 
-func dispatchSNS(msg []byte) error {
-	params := &sns.PublishInput{
-		Message:  aws.String(string(msg)),
-		TopicArn: aws.String(snsARN),
+// send publishes records to the topic one by one
+func (o *snsOutput) send(bufs [][]byte) (n int, err error) {
+	for i, buf := range bufs {
+		params := &sns.PublishInput{
+			Message:  aws.String(string(buf)),
+			TopicArn: aws.String(o.arn),
+		}
+		_, err = o.service.Publish(params)
+		if err != nil {
+			return i, fmt.Errorf("sns dispatch: %v", err)
+		}
 	}
-	_, err := snsService.Publish(params)
-	return err
+	return len(bufs), nil
 }
@@ -0,0 +1,249 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spoolRecord is a dispatch record waiting in the spool, stored in its own
+// file named after the time it was spooled
+type spoolRecord struct {
+	name string
+	size int64
+	ts   time.Time
+}
+
+// spoolStats are the statistics of the spool returned by module queries
+type spoolStats struct {
+	Output      string    `json:"output"`
+	Directory   string    `json:"directory"`
+	Records     int       `json:"records"`          // records waiting to be dispatched
+	Bytes       int64     `json:"bytes"`            // size of the records waiting to be dispatched
+	Oldest      time.Time `json:"oldest,omitempty"` // time the oldest waiting record was spooled
+	Dispatched  int64     `json:"dispatched"`       // records dispatched since the module started
+	Failures    int64     `json:"failures"`         // failed dispatch attempts since the module started
+	Dropped     int64     `json:"dropped"`          // records dropped since the module started
+	Rejected    int64     `json:"rejected"`         // records rejected by the collector since the module started
+	LastSuccess time.Time `json:"lastsuccess,omitempty"`
+	LastError   string    `json:"lasterror,omitempty"`
+}
+
+// spool stores dispatch records on disk until they are dispatched, so they
+// survive collector outages and agent restarts. Records are dropped, oldest
+// first, once the spool exceeds its maximum size, or once they exceed the
+// maximum age.
+type spool struct {
+	sync.Mutex
+	dir     string
+	maxsize int64
+	maxage  time.Duration
+	seq     int64
+	records []spoolRecord // oldest first
+	size    int64
+	stats   spoolStats
+	notify  chan bool // signaled when a record is added
+}
+
+// newSpool opens a spool directory, creating it if needed, and loads the
+// records left by a previous run of the module
+func newSpool(dir string, maxsize int64, maxage time.Duration) (s *spool, err error) {
+	s = &spool{
+		dir:     dir,
+		maxsize: maxsize,
+		maxage:  maxage,
+		notify:  make(chan bool, 1),
+	}
+	s.stats.Directory = dir
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		name := fi.Name()
+		// remove records that were not completely written
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(path.Join(dir, name))
+			continue
+		}
+		ts, ok := spoolTime(name)
+		if !ok || !fi.Mode().IsRegular() {
+			continue
+		}
+		s.records = append(s.records, spoolRecord{name: name, size: fi.Size(), ts: ts})
+		s.size += fi.Size()
+	}
+	sort.Slice(s.records, func(i, j int) bool {
+		return s.records[i].name < s.records[j].name
+	})
+	s.prune()
+	return s, nil
+}
+
+// spoolTime returns the time a record was spooled from the name of its file
+func spoolTime(name string) (ts time.Time, ok bool) {
+	if !strings.HasSuffix(name, ".rec") {
+		return
+	}
+	i := strings.Index(name, "-")
+	if i == -1 {
+		return
+	}
+	nsec, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil {
+		return
+	}
+	return time.Unix(0, nsec), true
+}
+
+// put writes a record to the spool
+func (s *spool) put(buf []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.seq++
+	// names sort in the order records were spooled
+	name := fmt.Sprintf("%019d-%010d.rec", now.UnixNano(), s.seq)
+	tmp := path.Join(s.dir, name+".tmp")
+	err := ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		os.Remove(tmp)
+		s.stats.Dropped++
+		return err
+	}
+	err = os.Rename(tmp, path.Join(s.dir, name))
+	if err != nil {
+		os.Remove(tmp)
+		s.stats.Dropped++
+		return err
+	}
+	s.records = append(s.records, spoolRecord{name: name, size: int64(len(buf)), ts: now})
+	s.size += int64(len(buf))
+	s.prune()
+	select {
+	case s.notify <- true:
+	default:
+	}
+	return nil
+}
+
+// prune drops the records that exceed the maximum age, and the oldest records
+// while the spool exceeds its maximum size. It must be called with the lock
+// held.
+func (s *spool) prune() {
+	cutoff := time.Now().Add(-s.maxage)
+	for len(s.records) > 0 {
+		r := s.records[0]
+		if (s.maxage == 0 || !r.ts.Before(cutoff)) && (s.maxsize == 0 || s.size <= s.maxsize) {
+			break
+		}
+		os.Remove(path.Join(s.dir, r.name))
+		s.records = s.records[1:]
+		s.size -= r.size
+		s.stats.Dropped++
+	}
+}
+
+// batch returns up to n of the oldest records and their content. Records
+// that can't be read are dropped.
+func (s *spool) batch(n int) (recs []spoolRecord, bufs [][]byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.prune()
+	for i := 0; i < len(s.records) && len(recs) < n; {
+		r := s.records[i]
+		buf, err := ioutil.ReadFile(path.Join(s.dir, r.name))
+		if err != nil {
+			os.Remove(path.Join(s.dir, r.name))
+			s.records = append(s.records[:i], s.records[i+1:]...)
+			s.size -= r.size
+			s.stats.Dropped++
+			continue
+		}
+		recs = append(recs, r)
+		bufs = append(bufs, buf)
+		i++
+	}
+	return
+}
+
+// remove deletes records from the spool once they have been dispatched
+func (s *spool) remove(recs []spoolRecord) {
+	s.Lock()
+	defer s.Unlock()
+	s.discard(recs)
+	if len(recs) > 0 {
+		s.stats.Dispatched += int64(len(recs))
+		s.stats.LastSuccess = time.Now()
+	}
+}
+
+// reject deletes records from the spool that the collector won't accept
+func (s *spool) reject(recs []spoolRecord, err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.discard(recs)
+	s.stats.Rejected += int64(len(recs))
+	s.stats.LastError = err.Error()
+}
+
+// discard deletes records from the spool. It must be called with the lock
+// held.
+func (s *spool) discard(recs []spoolRecord) {
+	done := make(map[string]bool)
+	for _, r := range recs {
+		os.Remove(path.Join(s.dir, r.name))
+		done[r.name] = true
+	}
+	kept := s.records[:0]
+	for _, r := range s.records {
+		if done[r.name] {
+			s.size -= r.size
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.records = kept
+}
+
+// failed records a failed dispatch attempt
+func (s *spool) failed(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.Failures++
+	s.stats.LastError = err.Error()
+}
+
+// dropped counts records that were dropped before reaching the spool
+func (s *spool) dropped(n int) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.Dropped += int64(n)
+}
+
+// getStats returns the current statistics of the spool
+func (s *spool) getStats() spoolStats {
+	s.Lock()
+	defer s.Unlock()
+	st := s.stats
+	st.Records = len(s.records)
+	st.Bytes = s.size
+	if len(s.records) > 0 {
+		st.Oldest = s.records[0].ts
+	}
+	return st
+}
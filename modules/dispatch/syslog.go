@@ -0,0 +1,128 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package dispatch /* import "github.com/mozilla/mig/modules/dispatch" */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// syslogOutput sends records as RFC 5424 syslog messages over udp, tcp or tls.
+// Over tcp and tls, messages are framed with octet counting as described in
+// RFC 5425.
+type syslogOutput struct {
+	network   string // udp, tcp or tls
+	addr      string
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	conn      net.Conn
+}
+
+// severity of the messages sent by the syslog output: notice
+const syslogSeverity = 5
+
+// newSyslogOutput returns a syslog output for an address in the form
+// tls://host:port, tcp://host:port or udp://host:port. The port defaults to
+// 6514 for tls and 514 otherwise. Servers are authenticated using the
+// certificate authorities in cacert, or the system ones if it is empty.
+func newSyslogOutput(address, cacert string, facility int) (o *syslogOutput, err error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address: %v", err)
+	}
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %d", facility)
+	}
+	if facility == 0 {
+		// user-level messages
+		facility = 1
+	}
+	o = &syslogOutput{
+		network:  u.Scheme,
+		addr:     u.Host,
+		facility: facility,
+		hostname: agtHostname,
+	}
+	port := "514"
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		port = "6514"
+		o.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		if cacert != "" {
+			pem, err := ioutil.ReadFile(cacert)
+			if err != nil {
+				return nil, err
+			}
+			o.tlsConfig.RootCAs = x509.NewCertPool()
+			if !o.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", cacert)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("syslog address must start with udp://, tcp:// or tls://")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("syslog address is missing a host")
+	}
+	if u.Port() == "" {
+		o.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return o, nil
+}
+
+// format returns a record formatted as a syslog message
+func (o *syslogOutput) format(buf []byte, ts time.Time) []byte {
+	hostname := o.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	hdr := fmt.Sprintf("<%d>1 %s %s mig-agent %d dispatch - ", o.facility*8+syslogSeverity,
+		ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), hostname, os.Getpid())
+	msg := append([]byte(hdr), buf...)
+	if o.network == "udp" {
+		return msg
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+func (o *syslogOutput) connect() (err error) {
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	if o.network == "tls" {
+		o.conn, err = tls.DialWithDialer(&dialer, "tcp", o.addr, o.tlsConfig)
+	} else {
+		o.conn, err = dialer.Dial(o.network, o.addr)
+	}
+	return
+}
+
+// send writes records to the syslog server one by one. The connection is
+// closed and opened again on the next attempt if a write fails.
+func (o *syslogOutput) send(bufs [][]byte) (n int, err error) {
+	if o.conn == nil {
+		err = o.connect()
+		if err != nil {
+			return 0, fmt.Errorf("syslog connect: %v", err)
+		}
+	}
+	for i, buf := range bufs {
+		o.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		_, err = o.conn.Write(o.format(buf, time.Now()))
+		if err != nil {
+			o.conn.Close()
+			o.conn = nil
+			return i, fmt.Errorf("syslog write: %v", err)
+		}
+	}
+	return len(bufs), nil
+}
@@ -0,0 +1,398 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package file /* import "github.com/mozilla/mig/modules/file" */
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// archiveSeparator separates the path of an archive from the path of a file
+// it contains, as in /opt/app.war!/WEB-INF/lib/foo.jar!/META-INF/MANIFEST.MF
+const archiveSeparator = "!/"
+
+// default limits of the archives option: the number of nested archives walked,
+// and the number of decompressed bytes and of files read from a single archive
+const (
+	defaultArchiveDepth    float64 = 3
+	defaultArchiveMaxSize  float64 = 100 * 1024 * 1024
+	defaultArchiveMaxFiles float64 = 100000
+)
+
+// archiveSource is the content of an archive, either a file on disk or the
+// temporary copy of an archive member
+type archiveSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// archiveReader iterates over the regular files stored in an archive. next
+// returns io.EOF once all files have been returned.
+type archiveReader interface {
+	next() (name string, fi os.FileInfo, r io.Reader, err error)
+}
+
+type zipArchive struct {
+	files []*zip.File
+	cur   io.ReadCloser
+}
+
+func (z *zipArchive) next() (name string, fi os.FileInfo, r io.Reader, err error) {
+	if z.cur != nil {
+		z.cur.Close()
+		z.cur = nil
+	}
+	for len(z.files) > 0 {
+		f := z.files[0]
+		z.files = z.files[1:]
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		z.cur, err = f.Open()
+		if err != nil {
+			return
+		}
+		return memberName(f.Name), f.FileInfo(), z.cur, nil
+	}
+	return "", nil, nil, io.EOF
+}
+
+type tarArchive struct {
+	tr *tar.Reader
+}
+
+func (t *tarArchive) next() (name string, fi os.FileInfo, r io.Reader, err error) {
+	for {
+		hdr, err := t.tr.Next()
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		return memberName(hdr.Name), hdr.FileInfo(), t.tr, nil
+	}
+}
+
+// memberName returns the path of a file in an archive without leading slash
+// or dot components
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// isTar returns true if a block starts with the header of a posix tar archive
+func isTar(block []byte) bool {
+	return len(block) >= 262 && string(block[257:262]) == "ustar"
+}
+
+// openArchive returns a reader over the files of an archive, identified by its
+// magic bytes, or nil if the source is not an archive. Tar archives may be
+// compressed with gzip, bzip2 or xz.
+func openArchive(src archiveSource, size int64) (ar archiveReader, err error) {
+	header := make([]byte, 512)
+	n, err := src.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+	_, err = src.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	var stream io.Reader
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(src, size)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchive{files: zr.File}, nil
+	case isTar(header):
+		return &tarArchive{tr: tar.NewReader(src)}, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		stream, err = gzip.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(header, []byte("BZh")):
+		stream = bzip2.NewReader(src)
+	case bytes.HasPrefix(header, []byte("\xfd7zXZ\x00")):
+		stream, err = xz.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	// a compressed stream is only walked if it contains a tar archive, other
+	// compressed files are handled by the decompress option
+	br := bufio.NewReaderSize(stream, 512)
+	block, _ := br.Peek(512)
+	if !isTar(block) {
+		return nil, nil
+	}
+	return &tarArchive{tr: tar.NewReader(br)}, nil
+}
+
+// archiveWalker evaluates the files stored in an archive, and in the archives
+// it contains, within limits that protect the agent from archive bombs
+type archiveWalker struct {
+	r         *run
+	maxdepth  int
+	budget    int64 // number of decompressed bytes left to read
+	maxsize   int64
+	files     int64 // number of archive members left to read
+	maxfiles  int64
+	exhausted bool
+}
+
+// spool streams the content of an archive member to a temporary file, which
+// the caller must remove, and deducts its size from the budget of the walker.
+// Members are copied to disk rather than memory, as their content is read by
+// every check, and nested archives need random access to it.
+func (w *archiveWalker) spool(r io.Reader) (path string, size int64, err error) {
+	fd, err := ioutil.TempFile("", "mig-file-archive")
+	if err != nil {
+		return
+	}
+	defer func() {
+		fd.Close()
+		if err != nil {
+			os.Remove(fd.Name())
+		}
+	}()
+	size, err = io.Copy(fd, io.LimitReader(r, w.budget+1))
+	if err != nil {
+		return
+	}
+	if size > w.budget {
+		w.exhausted = true
+		err = fmt.Errorf("archive exceeds the maximum decompressed size of %d bytes", w.maxsize)
+		return
+	}
+	w.budget -= size
+	return fd.Name(), size, nil
+}
+
+// walkArchive evaluates the files stored in an archive, if it is one, against
+// the active searches that have the archives option set
+func (r *run) walkArchive(file string) (err error) {
+	var deactivated []string
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("walkArchive() -> %v", e)
+		}
+		for _, label := range deactivated {
+			search := r.Parameters.Searches[label]
+			search.activate()
+			r.Parameters.Searches[label] = search
+		}
+	}()
+	// only the searches that have the archives option set look into archives,
+	// use the largest limits of these searches
+	w := archiveWalker{r: r}
+	for label, search := range r.Parameters.Searches {
+		if !search.isactive {
+			continue
+		}
+		if !search.Options.Archives {
+			search.deactivate()
+			deactivated = append(deactivated, label)
+			r.Parameters.Searches[label] = search
+			continue
+		}
+		if int(search.Options.ArchiveDepth) > w.maxdepth {
+			w.maxdepth = int(search.Options.ArchiveDepth)
+		}
+		if int64(search.Options.ArchiveMaxSize) > w.maxsize {
+			w.maxsize = int64(search.Options.ArchiveMaxSize)
+		}
+		if int64(search.Options.ArchiveMaxFiles) > w.maxfiles {
+			w.maxfiles = int64(search.Options.ArchiveMaxFiles)
+		}
+	}
+	if w.maxdepth == 0 {
+		return
+	}
+	w.budget = w.maxsize
+	w.files = w.maxfiles
+	fd, err := os.Open(file)
+	if err != nil {
+		panic(err)
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		panic(err)
+	}
+	err = w.walk(file, fd, fi.Size(), 1)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// walk evaluates the files of an archive located at vpath, and walks the
+// archives it contains until the maximum depth is reached
+func (w *archiveWalker) walk(vpath string, src archiveSource, size int64, depth int) error {
+	ar, err := openArchive(src, size)
+	if err != nil {
+		return fmt.Errorf("%s: %v", vpath, err)
+	}
+	if ar == nil {
+		return nil
+	}
+	debugprint("walkArchive: walking into archive '%s'\n", vpath)
+	for {
+		// stop walking the archive once the searches reached their match limit
+		active := false
+		for _, search := range w.r.Parameters.Searches {
+			if stats.Totalhits >= search.Options.MatchLimit {
+				search.deactivate()
+			}
+			if search.isactive {
+				active = true
+			}
+		}
+		if !active {
+			return nil
+		}
+		name, fi, rd, err := ar.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", vpath, err)
+		}
+		if w.files <= 0 {
+			w.exhausted = true
+			return fmt.Errorf("%s: archive exceeds the maximum number of %d files", vpath, w.maxfiles)
+		}
+		w.files--
+		mpath := vpath + archiveSeparator + name
+		err = w.walkMember(mpath, rd, fi, depth)
+		if err != nil {
+			if w.exhausted {
+				return err
+			}
+			walkingErrors = append(walkingErrors, err.Error())
+		}
+	}
+}
+
+// walkMember evaluates a file stored in an archive at mpath, and walks it if
+// it is an archive itself and the maximum depth is not reached
+func (w *archiveWalker) walkMember(mpath string, rd io.Reader, fi os.FileInfo, depth int) error {
+	tmp, size, err := w.spool(rd)
+	if err != nil {
+		return fmt.Errorf("%s: %v", mpath, err)
+	}
+	defer os.Remove(tmp)
+	stats.Filescount++
+	err = w.r.evaluateEntry(fileEntry{filename: mpath, spool: tmp}, fi)
+	if err != nil {
+		walkingErrors = append(walkingErrors, err.Error())
+	}
+	if depth >= w.maxdepth {
+		return nil
+	}
+	fd, err := os.Open(tmp)
+	if err != nil {
+		return fmt.Errorf("%s: %v", mpath, err)
+	}
+	defer fd.Close()
+	return w.walk(mpath, fd, size, depth+1)
+}
+
+// readMember returns the metadata of a file stored in an archive, designated by
+// its virtual path, and the path of a temporary copy of its content, which the
+// caller must remove
+func readMember(vpath string, maxsize int64) (fi os.FileInfo, spool string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("readMember() -> %v", e)
+		}
+	}()
+	parts := strings.Split(vpath, archiveSeparator)
+	w := archiveWalker{budget: maxsize, maxsize: maxsize}
+	fi, spool, err = w.extract(parts[0], parts[0], parts[1:])
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// extract copies the file at path names in the archive stored in file, whose
+// virtual path is vpath, to a temporary file. Each name but the last one is an
+// archive nested in the previous one.
+func (w *archiveWalker) extract(file, vpath string, names []string) (fi os.FileInfo, spool string, err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	st, err := fd.Stat()
+	if err != nil {
+		return
+	}
+	ar, err := openArchive(fd, st.Size())
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", vpath, err)
+	}
+	if ar == nil {
+		return nil, "", fmt.Errorf("%s is not an archive", vpath)
+	}
+	vpath += archiveSeparator + names[0]
+	for {
+		mname, mfi, rd, err := ar.next()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("%s not found in archive", vpath)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", vpath, err)
+		}
+		if mname != names[0] {
+			continue
+		}
+		spool, _, err = w.spool(rd)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", vpath, err)
+		}
+		if len(names) == 1 {
+			return mfi, spool, nil
+		}
+		defer os.Remove(spool)
+		return w.extract(spool, vpath, names[1:])
+	}
+}
+
+// openEntry returns the metadata of a matched file, and an entry to read its
+// content, which is copied from its archive if the file is an archive member.
+// The entry must be released once it is no longer used.
+func openEntry(file string, maxsize float64) (fi os.FileInfo, f fileEntry, err error) {
+	f.filename = file
+	fi, err = os.Stat(file)
+	if err == nil || !strings.Contains(file, archiveSeparator) {
+		return
+	}
+	if maxsize == 0 {
+		maxsize = defaultArchiveMaxSize
+	}
+	fi, f.spool, err = readMember(file, int64(maxsize))
+	return
+}
@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package file /* import "github.com/mozilla/mig/modules/file" */
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func makeZip(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip.Create: %v", err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip.Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	writeTar(t, gw, files)
+	gw.Close()
+	return buf.Bytes()
+}
+
+func makeTarXz(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("xz.NewWriter: %v", err)
+	}
+	writeTar(t, xw, files)
+	xw.Close()
+	return buf.Bytes()
+}
+
+func writeTar(t *testing.T, w io.Writer, files map[string][]byte) {
+	tw := tar.NewWriter(w)
+	for name, data := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatalf("tar.WriteHeader: %v", err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar.Close: %v", err)
+	}
+}
+
+func TestArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	manifest := []byte("Manifest-Version: 1.0\nImplementation-Version: 2.14.1\n")
+	jar := makeZip(t, map[string][]byte{"META-INF/MANIFEST.MF": manifest})
+	war := makeZip(t, map[string][]byte{
+		"WEB-INF/lib/log4j-core.jar": jar,
+		"WEB-INF/web.xml":            []byte("<web-app/>"),
+	})
+	tgz := makeTarGz(t, map[string][]byte{"./app/app.war": war})
+	txz := makeTarXz(t, map[string][]byte{"app/app.war": war})
+	err = ioutil.WriteFile(filepath.Join(dir, "app.war"), war, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "release.tar.gz"), tgz, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "release.tar.xz"), txz, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	warManifest := filepath.Join(dir, "app.war") + "!/WEB-INF/lib/log4j-core.jar!/META-INF/MANIFEST.MF"
+	tgzManifest := filepath.Join(dir, "release.tar.gz") + "!/app/app.war!/WEB-INF/lib/log4j-core.jar!/META-INF/MANIFEST.MF"
+	txzManifest := filepath.Join(dir, "release.tar.xz") + "!/app/app.war!/WEB-INF/lib/log4j-core.jar!/META-INF/MANIFEST.MF"
+
+	s := Search{Paths: []string{dir}, Names: []string{"^MANIFEST\\.MF$"}, Contents: []string{"^Implementation-Version: 2\\.14"}}
+	files, _, errs := runSearch(t, s)
+	if len(files) != 0 || len(errs) != 0 {
+		t.Fatalf("archives were walked without the archives option: %v, %v", files, errs)
+	}
+
+	s.Options.Archives = true
+	s.Options.ReturnSHA256 = true
+	s.Options.ReturnContent = true
+	files, sr, errs := runSearch(t, s)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(files) != 3 || files[0] != warManifest || files[1] != tgzManifest || files[2] != txzManifest {
+		t.Fatalf("unexpected files %v", files)
+	}
+	for _, mf := range sr["s1"] {
+		if mf.FileInfo.Size != float64(len(manifest)) || mf.FileInfo.SHA256 == "" ||
+			len(mf.Content) != 1 || mf.Content[0].Line != 2 {
+			t.Fatalf("unexpected result for archive member %+v", mf)
+		}
+	}
+
+	s = Search{Paths: []string{dir}, Names: []string{"^MANIFEST\\.MF$"}}
+	s.Options.Archives = true
+	s.Options.ArchiveDepth = 2
+	files, _, _ = runSearch(t, s)
+	if len(files) != 1 || files[0] != warManifest {
+		t.Fatalf("expected archivedepth to limit nested archives, got %v", files)
+	}
+
+	s.Options.ArchiveDepth = 0
+	s.Options.ArchiveMaxSize = 100
+	files, _, errs = runSearch(t, s)
+	if len(files) != 0 || len(errs) != 3 || !strings.Contains(errs[0], "maximum decompressed size") {
+		t.Fatalf("expected archivemaxsize to stop walking archives, got %v, %v", files, errs)
+	}
+}
+
+func TestArchiveMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	members := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		members[fmt.Sprintf("empty%d", i)] = nil
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "bomb.zip"), makeZip(t, members), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := Search{Paths: []string{dir}, Names: []string{"^empty"}}
+	s.Options.Archives = true
+	s.Options.ArchiveMaxFiles = 10
+	files, _, errs := runSearch(t, s)
+	if len(files) != 10 || len(errs) != 1 || !strings.Contains(errs[0], "maximum number of 10 files") {
+		t.Fatalf("expected archivemaxfiles to stop walking the archive, got %d files, %v", len(files), errs)
+	}
+	if stats.Filescount != 11 {
+		t.Fatalf("expected 11 files to be read, got %v", stats.Filescount)
+	}
+}
+
+func TestReadMember(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	inner := makeTarGz(t, map[string][]byte{"etc/passwd": []byte("root:x:0:0::/root:/bin/bash\n")})
+	outer := makeZip(t, map[string][]byte{"rootfs.tar.gz": inner})
+	archive := filepath.Join(dir, "image.zip")
+	err = ioutil.WriteFile(archive, outer, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fi, spool, err := readMember(archive+"!/rootfs.tar.gz!/etc/passwd", 1024)
+	if err != nil {
+		t.Fatalf("readMember: %v", err)
+	}
+	data, err := ioutil.ReadFile(spool)
+	os.Remove(spool)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if fi.Name() != "passwd" || !bytes.HasPrefix(data, []byte("root:")) {
+		t.Fatalf("unexpected member %s: %q", fi.Name(), data)
+	}
+	_, _, err = readMember(archive+"!/rootfs.tar.gz!/etc/shadow", 1024)
+	if err == nil {
+		t.Fatal("readMember should fail on missing member")
+	}
+	_, _, err = readMember(archive+"!/rootfs.tar.gz!/etc/passwd", 10)
+	if err == nil {
+		t.Fatal("readMember should fail when the archive exceeds the maximum size")
+	}
+}
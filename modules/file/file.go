@@ -13,6 +13,7 @@ package file /* import "github.com/mozilla/mig/modules/file" */
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -86,6 +87,8 @@ type Search struct {
 	SHA1             []string `json:"sha1,omitempty"`
 	SHA2             []string `json:"sha2,omitempty"`
 	SHA3             []string `json:"sha3,omitempty"`
+	SSDeep           []string `json:"ssdeep,omitempty"`
+	Entropies        []string `json:"entropies,omitempty"`
 	Options          options  `json:"options,omitempty"`
 	checks           []check
 	checkmask        checkType
@@ -107,6 +110,26 @@ type options struct {
 	Debug        string   `json:"debug,omitempty"`
 	ReturnSHA256 bool     `json:"returnsha256,omitempty"`
 	Decompress   bool     `json:"decompress,omitempty"`
+
+	// ReturnContent returns the lines that matched content regexes, with
+	// ContextLines lines of context around them, up to MaxContentBytes bytes
+	// of text for all the files of the search
+	ReturnContent   bool    `json:"returncontent,omitempty"`
+	ContextLines    float64 `json:"contextlines,omitempty"`
+	MaxContentBytes float64 `json:"maxcontentbytes,omitempty"`
+
+	// Archives applies the checks to the files stored in zip and tar archives,
+	// and in the archives they contain up to ArchiveDepth levels, reading at
+	// most ArchiveMaxSize decompressed bytes and ArchiveMaxFiles files from
+	// each archive
+	Archives        bool    `json:"archives,omitempty"`
+	ArchiveDepth    float64 `json:"archivedepth,omitempty"`
+	ArchiveMaxSize  float64 `json:"archivemaxsize,omitempty"`
+	ArchiveMaxFiles float64 `json:"archivemaxfiles,omitempty"`
+
+	// SSDeepThreshold is the minimum similarity score of a file to the hash
+	// of an ssdeep check for the check to match
+	SSDeepThreshold float64 `json:"ssdeepthreshold,omitempty"`
 }
 
 type checkType uint64
@@ -128,6 +151,8 @@ const (
 	checkSHA3_256
 	checkSHA3_384
 	checkSHA3_512
+	checkSSDeep
+	checkEntropy
 )
 
 // check represents an individual check that is part of a search.
@@ -140,6 +165,8 @@ type check struct {
 	regex                  *regexp.Regexp
 	minsize, maxsize       uint64
 	minmtime, maxmtime     time.Time
+	minscore               float64
+	minentropy, maxentropy float64
 	inversematch, mismatch bool
 	matchChan              chan checkMatchNotify
 	waitNotify             chan bool
@@ -155,6 +182,17 @@ type checkMatchNotify struct {
 // pretty much infinity when it comes to file searches
 const unlimited float64 = 1125899906842624
 
+// default number of bytes of content returned by a search, and the maximum
+// number of context lines around a matched line
+const (
+	defaultMaxContentBytes float64 = 4096
+	maxContextLines        float64 = 100
+)
+
+// lines of matched files longer than maxContentLineLength are truncated when
+// their content is returned
+const maxContentLineLength = bufio.MaxScanTokenSize
+
 // processMatch processes incoming matches from individual checks which are part of the search. It
 // also manages the total hit statistics. The match processor does some preprocessing, such as identifying
 // files that match all checks for a search if MatchAll is set, to make building the results simpler.
@@ -254,6 +292,18 @@ func (s *Search) makeChecks() (err error) {
 	if s.Options.MatchLimit == 0 {
 		s.Options.MatchLimit = unlimited
 	}
+	if s.Options.ReturnContent && s.Options.MaxContentBytes == 0 {
+		s.Options.MaxContentBytes = defaultMaxContentBytes
+	}
+	if s.Options.Archives && s.Options.ArchiveDepth == 0 {
+		s.Options.ArchiveDepth = defaultArchiveDepth
+	}
+	if s.Options.Archives && s.Options.ArchiveMaxSize == 0 {
+		s.Options.ArchiveMaxSize = defaultArchiveMaxSize
+	}
+	if s.Options.Archives && s.Options.ArchiveMaxFiles == 0 {
+		s.Options.ArchiveMaxFiles = defaultArchiveMaxFiles
+	}
 	for _, v := range s.Contents {
 		c := nextCID()
 		c.code = checkContent
@@ -379,6 +429,34 @@ func (s *Search) makeChecks() (err error) {
 		s.checks = append(s.checks, c)
 		s.checkmask |= c.code
 	}
+	for _, v := range s.SSDeep {
+		c := nextCID()
+		c.code = checkSSDeep
+		c.value = v
+		c.minscore = s.Options.SSDeepThreshold
+		if c.minscore == 0 {
+			c.minscore = defaultSSDeepThreshold
+		}
+		if s.hasMismatch("ssdeep") {
+			c.mismatch = true
+		}
+		s.checks = append(s.checks, c)
+		s.checkmask |= c.code
+	}
+	for _, v := range s.Entropies {
+		c := nextCID()
+		c.code = checkEntropy
+		c.value = v
+		c.minentropy, c.maxentropy, err = parseEntropy(v)
+		if err != nil {
+			panic(err)
+		}
+		if s.hasMismatch("entropy") {
+			c.mismatch = true
+		}
+		s.checks = append(s.checks, c)
+		s.checkmask |= c.code
+	}
 	return
 }
 
@@ -619,6 +697,23 @@ func (r *run) ValidateParameters() (err error) {
 				return
 			}
 		}
+		for _, hash := range s.SSDeep {
+			debugprint("validating ssdeep '%s'\n", hash)
+			_, _, _, err = parseSSDeep(hash)
+			if err != nil {
+				return
+			}
+		}
+		for _, e := range s.Entropies {
+			debugprint("validating entropy '%s'\n", e)
+			_, _, err = parseEntropy(e)
+			if err != nil {
+				return
+			}
+		}
+		if s.Options.SSDeepThreshold < 0 || s.Options.SSDeepThreshold > 100 {
+			return fmt.Errorf("ssdeepthreshold must be between 0 and 100")
+		}
 		for _, mismatch := range s.Options.Mismatch {
 			debugprint("validating mismatch '%s'\n", mismatch)
 			err = validateMismatch(mismatch)
@@ -626,6 +721,14 @@ func (r *run) ValidateParameters() (err error) {
 				return
 			}
 		}
+		err = validateContentOptions(s)
+		if err != nil {
+			return
+		}
+		err = validateArchiveOptions(s)
+		if err != nil {
+			return
+		}
 		if s.Options.Decompress {
 			tryDecompress = true
 		} else {
@@ -635,6 +738,44 @@ func (r *run) ValidateParameters() (err error) {
 	return
 }
 
+func validateContentOptions(s *Search) error {
+	if !s.Options.ReturnContent {
+		if s.Options.ContextLines != 0 || s.Options.MaxContentBytes != 0 {
+			return fmt.Errorf("contextlines and maxcontentbytes require returncontent")
+		}
+		return nil
+	}
+	if len(s.Contents) == 0 {
+		return fmt.Errorf("returncontent requires at least one content regex")
+	}
+	if s.Options.ContextLines < 0 || s.Options.ContextLines > maxContextLines {
+		return fmt.Errorf("contextlines must be between 0 and %.0f", maxContextLines)
+	}
+	if s.Options.MaxContentBytes < 0 {
+		return fmt.Errorf("maxcontentbytes must be positive")
+	}
+	return nil
+}
+
+func validateArchiveOptions(s *Search) error {
+	if !s.Options.Archives {
+		if s.Options.ArchiveDepth != 0 || s.Options.ArchiveMaxSize != 0 || s.Options.ArchiveMaxFiles != 0 {
+			return fmt.Errorf("archivedepth, archivemaxsize and archivemaxfiles require archives")
+		}
+		return nil
+	}
+	if s.Options.ArchiveDepth < 0 {
+		return fmt.Errorf("archivedepth must be positive")
+	}
+	if s.Options.ArchiveMaxSize < 0 {
+		return fmt.Errorf("archivemaxsize must be positive")
+	}
+	if s.Options.ArchiveMaxFiles < 0 {
+		return fmt.Errorf("archivemaxfiles must be positive")
+	}
+	return nil
+}
+
 func validateLabel(label string) error {
 	if len(label) < 1 {
 		return fmt.Errorf("empty labels are not permitted")
@@ -728,7 +869,7 @@ func validateMismatch(filter string) error {
 	if len(filter) < 1 {
 		return fmt.Errorf("empty filters are not permitted")
 	}
-	filterregexp := `^(name|size|mode|mtime|content|md5|sha1|sha2|sha3)$`
+	filterregexp := `^(name|size|mode|mtime|content|md5|sha1|sha2|sha3|ssdeep|entropy)$`
 	re := regexp.MustCompile(filterregexp)
 	if !re.MatchString(filter) {
 		return fmt.Errorf("The syntax of filter '%s' is invalid. Must match regex %s", filter, filterregexp)
@@ -992,6 +1133,10 @@ func (r *run) pathWalk(path string, roots []string) (traversed []string, err err
 				if err != nil {
 					walkingErrors = append(walkingErrors, err.Error())
 				}
+				err = r.walkArchive(entryAbsPath)
+				if err != nil {
+					walkingErrors = append(walkingErrors, err.Error())
+				}
 			}
 		}
 	}
@@ -1020,6 +1165,11 @@ func (r *run) pathWalk(path string, roots []string) (traversed []string, err err
 			walkingErrors = append(walkingErrors, err.Error())
 			goto finish
 		}
+		err = r.walkArchive(path)
+		if err != nil {
+			walkingErrors = append(walkingErrors, err.Error())
+			goto finish
+		}
 	}
 
 	// if we found any sub directories, go down the rabbit hole recursively,
@@ -1073,10 +1223,20 @@ type fileEntry struct {
 	filename string
 	fd       *os.File
 	compRdr  io.Reader
+	spool    string // temporary copy of the content of files stored in archives
 }
 
 func (f *fileEntry) Close() {
-	f.fd.Close()
+	if f.fd != nil {
+		f.fd.Close()
+	}
+}
+
+// release removes the temporary copy of an archive member returned by openEntry
+func (f *fileEntry) release() {
+	if f.spool != "" {
+		os.Remove(f.spool)
+	}
 }
 
 // getReader returns an appropriate reader for the file being checked.
@@ -1090,43 +1250,65 @@ func (f *fileEntry) getReader() io.Reader {
 			err = fmt.Errorf("getReader() -> %v", err)
 		}
 	}()
-	f.fd, err = os.Open(f.filename)
+	path := f.filename
+	if f.spool != "" {
+		path = f.spool
+	}
+	f.fd, err = os.Open(path)
 	if err != nil {
 		stats.Openfailed++
 		panic(err)
 	}
+	rdr := f.fd
 	if tryDecompress != true {
-		return f.fd
+		return rdr
 	}
 	magic := make([]byte, 2)
-	n, err := f.fd.Read(magic)
+	n, err := rdr.Read(magic)
 	if err != nil {
 		panic(err)
 	}
 	if n != 2 {
-		return f.fd
+		return rdr
 	}
-	_, err = f.fd.Seek(0, 0)
+	_, err = rdr.Seek(0, 0)
 	if err != nil {
 		panic(err)
 	}
 	if magic[0] == 0x1f && magic[1] == 0x8b {
-		f.compRdr, err = gzip.NewReader(f.fd)
+		f.compRdr, err = gzip.NewReader(rdr)
 		if err != nil {
 			panic(err)
 		}
 		return f.compRdr
 	}
-	return f.fd
+	return rdr
 }
 
 // evaluateFile takes a single file and applies searches to it
 func (r *run) evaluateFile(file string) (err error) {
-	var activeSearches []string
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("evaluateFile() -> %v", e)
 		}
+	}()
+	stats.Filescount++
+	debugprint("evaluateFile: evaluating '%s'\n", file)
+	fi, err := os.Stat(file)
+	if err != nil {
+		panic(err)
+	}
+	return r.evaluateEntry(fileEntry{filename: file}, fi)
+}
+
+// evaluateEntry applies searches to a file on disk or stored in an archive
+func (r *run) evaluateEntry(f fileEntry, fi os.FileInfo) (err error) {
+	var activeSearches []string
+	file := f.filename
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("evaluateEntry() -> %v", e)
+		}
 		// restore list of active searches on exit
 		for _, label := range activeSearches {
 			search := r.Parameters.Searches[label]
@@ -1134,22 +1316,16 @@ func (r *run) evaluateFile(file string) (err error) {
 			r.Parameters.Searches[label] = search
 		}
 	}()
-	stats.Filescount++
-	debugprint("evaluateFile: evaluating '%s'\n", file)
 	// store list of active searches to restore it before leaving
 	for label, search := range r.Parameters.Searches {
 		if search.isactive {
-			debugprint("evaluateFile: search '%s' is active\n", label)
+			debugprint("evaluateEntry: search '%s' is active\n", label)
 			activeSearches = append(activeSearches, label)
 		}
 	}
 	// First pass: look at the file metadata and if MatchAll is set,
 	// deactivate the searches that don't match the current file.
 	// If MatchAll is not set, all checks will be performed individually
-	fi, err := os.Stat(file)
-	if err != nil {
-		panic(err)
-	}
 	for label, search := range r.Parameters.Searches {
 		if !search.isactive {
 			goto skip
@@ -1176,7 +1352,6 @@ func (r *run) evaluateFile(file string) (err error) {
 	// Second pass: Enter all content & hash checks across all searches.
 	// Only perform the searches that are active.
 	// Optimize to only read a file once per check type
-	f := fileEntry{filename: file}
 	r.checkContent(f)
 	r.checkHash(f, checkMD5)
 	r.checkHash(f, checkSHA1)
@@ -1187,6 +1362,7 @@ func (r *run) evaluateFile(file string) (err error) {
 	r.checkHash(f, checkSHA3_256)
 	r.checkHash(f, checkSHA3_384)
 	r.checkHash(f, checkSHA3_512)
+	r.checkFuzzy(f)
 	return
 }
 
@@ -1593,7 +1769,7 @@ func getHash(f fileEntry, hashType checkType) (hexhash string, err error) {
 	}()
 	reader := f.getReader()
 	defer f.Close()
-	debugprint("getHash: computing hash for '%s'\n", f.fd.Name())
+	debugprint("getHash: computing hash for '%s'\n", f.filename)
 	var h hash.Hash
 	switch hashType {
 	case checkMD5:
@@ -1633,6 +1809,120 @@ func getHash(f fileEntry, hashType checkType) (hexhash string, err error) {
 	return
 }
 
+// contentChecks returns the content checks of a search whose matching lines
+// are worth returning: lines matching an inverse or mismatched regex are not
+// the reason a file was matched
+func (s *Search) contentChecks() (ret []check) {
+	for _, c := range s.checks {
+		if c.code&checkContent == 0 || c.inversematch || c.mismatch {
+			continue
+		}
+		ret = append(ret, c)
+	}
+	return
+}
+
+// extractContent returns the lines of a file that match the regexes of the
+// content checks, with up to ctx lines of context before and after each of
+// them. The size of the returned text is deducted from budget, and extraction
+// stops with truncated set to true when the budget is exhausted.
+func extractContent(f fileEntry, checks []check, ctx int, budget *int) (matches []ContentMatch, truncated bool, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("extractContent() -> %v", e)
+		}
+	}()
+	reader := f.getReader()
+	defer f.Close()
+	// track the byte offset of each line, including the line terminators
+	// the scanner strips. Lines longer than maxContentLineLength are truncated,
+	// and the rest of the line is skipped.
+	var (
+		offset, next int
+		skipping     bool
+	)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 4096), maxContentLineLength)
+	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if skipping {
+			advance = bytes.IndexByte(data, '\n') + 1
+			if advance > 0 {
+				skipping = false
+			} else {
+				advance = len(data)
+			}
+			next += advance
+			return
+		}
+		advance, token, err = bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && len(data) >= maxContentLineLength {
+			advance, token = len(data), data[:maxContentLineLength]
+			skipping = true
+		}
+		offset = next
+		next += advance
+		return
+	})
+	var (
+		before  []string
+		pending []int // index of the matches that still need context after them
+		lineno  int
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineno++
+		for _, i := range pending {
+			if len(line) > *budget {
+				return matches, true, nil
+			}
+			matches[i].After = append(matches[i].After, line)
+			*budget -= len(line)
+		}
+		var remaining []int
+		for _, i := range pending {
+			if len(matches[i].After) < ctx {
+				remaining = append(remaining, i)
+			}
+		}
+		pending = remaining
+		for _, c := range checks {
+			if !c.regex.MatchString(line) {
+				continue
+			}
+			cm := ContentMatch{
+				Regex:  c.value,
+				Line:   float64(lineno),
+				Offset: float64(offset),
+				Text:   line,
+			}
+			size := len(line)
+			for _, b := range before {
+				size += len(b)
+			}
+			if size > *budget {
+				return matches, true, nil
+			}
+			*budget -= size
+			cm.Before = append(cm.Before, before...)
+			matches = append(matches, cm)
+			if ctx > 0 {
+				pending = append(pending, len(matches)-1)
+			}
+			break
+		}
+		if ctx > 0 {
+			before = append(before, line)
+			if len(before) > ctx {
+				before = before[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	return
+}
+
 // SearchResults is the search result element for an invocation of the file module
 type SearchResults map[string]SearchResult
 
@@ -1645,6 +1935,39 @@ type MatchedFile struct {
 	File     string `json:"file"`
 	Search   Search `json:"search"`
 	FileInfo Info   `json:"fileinfo"`
+
+	// Content contains the lines that matched content regexes, if the
+	// returncontent option was set. ContentTruncated is true if lines were
+	// left out because maxcontentbytes was reached.
+	Content          []ContentMatch `json:"content,omitempty"`
+	ContentTruncated bool           `json:"contenttruncated,omitempty"`
+}
+
+// ContentMatch is a line of a matched file that matched a content regex, with the
+// lines that surround it. Line numbers start at 1, and the offset is the position
+// in bytes of the beginning of the line in the file, or in its decompressed content
+// if the decompress option was set.
+type ContentMatch struct {
+	Regex  string   `json:"regex"`
+	Line   float64  `json:"line"`
+	Offset float64  `json:"offset"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// addContent extracts the content matched by checks from the file of a
+// matched file, if the returncontent option is set in the search
+func (mf *MatchedFile) addContent(f fileEntry, s *Search, checks []check, budget *int) {
+	if !s.Options.ReturnContent || mf.File == "" || len(checks) == 0 {
+		return
+	}
+	var err error
+	mf.Content, mf.ContentTruncated, err = extractContent(f, checks,
+		int(s.Options.ContextLines), budget)
+	if err != nil {
+		walkingErrors = append(walkingErrors, fmt.Sprintf("ERROR: %s: %v", mf.File, err))
+	}
 }
 
 // Info describes the metadata associated with a file matched as a result of a
@@ -1654,6 +1977,13 @@ type Info struct {
 	Mode   string  `json:"mode"`
 	Mtime  string  `json:"lastmodified"`
 	SHA256 string  `json:"sha256,omitempty"`
+
+	// SSDeep and Similarity are set if the file matched ssdeep checks, with
+	// the highest similarity score to their hashes. Entropy is set if the
+	// file matched entropy checks.
+	SSDeep     string  `json:"ssdeep,omitempty"`
+	Similarity float64 `json:"similarity,omitempty"`
+	Entropy    float64 `json:"entropy,omitempty"`
 }
 
 // newResults allocates a Results structure
@@ -1672,6 +2002,8 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 	var maxerrors int
 	for label, search := range r.Parameters.Searches {
 		var sr SearchResult
+		// the content returned is limited for the whole search
+		contentBudget := int(search.Options.MaxContentBytes)
 		// first pass on the results: if matchall is set, verify that all
 		// the checks matched on all the files
 		if search.Options.MatchAll {
@@ -1684,7 +2016,7 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 				var mf MatchedFile
 				mf.File = matchedFile
 				if mf.File != "" {
-					fi, err := os.Stat(mf.File)
+					fi, f, err := openEntry(mf.File, search.Options.ArchiveMaxSize)
 					if err != nil {
 						panic(err)
 					}
@@ -1692,12 +2024,15 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 					mf.FileInfo.Mode = fi.Mode().String()
 					mf.FileInfo.Mtime = fi.ModTime().UTC().String()
 					if search.Options.ReturnSHA256 {
-						f := fileEntry{filename: mf.File}
 						mf.FileInfo.SHA256, err = getHash(f, checkSHA256)
 						if err != nil {
+							f.release()
 							panic(err)
 						}
 					}
+					mf.addContent(f, search, search.contentChecks(), &contentBudget)
+					mf.addFuzzy(f, search.checks)
+					f.release()
 				}
 				mf.Search = *search
 				mf.Search.Options.MatchLimit = 0
@@ -1726,7 +2061,7 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 				var mf MatchedFile
 				mf.File = file
 				if mf.File != "" {
-					fi, err := os.Stat(file)
+					fi, f, err := openEntry(file, search.Options.ArchiveMaxSize)
 					if err != nil {
 						panic(err)
 					}
@@ -1734,6 +2069,11 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 					mf.FileInfo.Mode = fi.Mode().String()
 					mf.FileInfo.Mtime = fi.ModTime().UTC().String()
 					mf.Search.Paths = []string{filepath.Dir(mf.File)}
+					if c.code == checkContent && !c.inversematch && !c.mismatch {
+						mf.addContent(f, search, []check{c}, &contentBudget)
+					}
+					mf.addFuzzy(f, []check{c})
+					f.release()
 				} else {
 					mf.Search.Paths = search.Paths
 				}
@@ -1766,6 +2106,10 @@ func (r *run) buildResults(t0 time.Time) (resStr string, err error) {
 					mf.Search.SHA2 = append(mf.Search.SHA2, c.value)
 				case checkSHA3_224, checkSHA3_256, checkSHA3_384, checkSHA3_512:
 					mf.Search.SHA3 = append(mf.Search.SHA2, c.value)
+				case checkSSDeep:
+					mf.Search.SSDeep = append(mf.Search.SSDeep, c.value)
+				case checkEntropy:
+					mf.Search.Entropies = append(mf.Search.Entropies, c.value)
 				}
 				sr = append(sr, mf)
 			}
@@ -1845,10 +2189,17 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 				if mf.FileInfo.SHA256 != "" {
 					out += fmt.Sprintf(", sha256:%s", strings.ToLower(mf.FileInfo.SHA256))
 				}
+				if mf.FileInfo.SSDeep != "" {
+					out += fmt.Sprintf(", ssdeep:%s, similarity:%.0f", mf.FileInfo.SSDeep, mf.FileInfo.Similarity)
+				}
+				if len(mf.Search.Entropies) > 0 || mf.FileInfo.Entropy != 0 {
+					out += fmt.Sprintf(", entropy:%.4f", mf.FileInfo.Entropy)
+				}
 				out += fmt.Sprintf("] in search '%s'", label)
 			}
 			if mf.Search.Options.MatchAll {
 				prints = append(prints, out)
+				prints = append(prints, printContent(mf)...)
 				continue
 			}
 			out += " on checks"
@@ -1880,7 +2231,14 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 			for _, v := range mf.Search.SHA3 {
 				out += fmt.Sprintf(" sha3='%s'", v)
 			}
+			for _, v := range mf.Search.SSDeep {
+				out += fmt.Sprintf(" ssdeep='%s'", v)
+			}
+			for _, v := range mf.Search.Entropies {
+				out += fmt.Sprintf(" entropy='%s'", v)
+			}
 			prints = append(prints, out)
+			prints = append(prints, printContent(mf)...)
 		}
 	}
 	if !foundOnly {
@@ -1895,7 +2253,85 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	return
 }
 
-// Enhanced privacy mode for file module, mask file names being returned by the module
+// printContent returns the content of a matched file, formatted like grep with
+// the line number followed by a colon for matching lines, and by a dash for
+// context lines
+func printContent(mf MatchedFile) (prints []string) {
+	for _, cm := range mf.Content {
+		for i, l := range cm.Before {
+			prints = append(prints, fmt.Sprintf("    %.0f-%s", cm.Line-float64(len(cm.Before)-i), l))
+		}
+		prints = append(prints, fmt.Sprintf("    %.0f:%s", cm.Line, cm.Text))
+		for i, l := range cm.After {
+			prints = append(prints, fmt.Sprintf("    %.0f-%s", cm.Line+float64(i+1), l))
+		}
+	}
+	if mf.ContentTruncated {
+		prints = append(prints, "    content truncated, maxcontentbytes reached")
+	}
+	return
+}
+
+// FlattenResults() returns one row per matched file, or one row per matched line if
+// the content of the file was returned. If foundOnly is not set, searches that did
+// not match anything are returned as a row with an empty file column.
+func (r *run) FlattenResults(result modules.Result, foundOnly bool) (rows []modules.ResultRow, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("FlattenResults() -> %v", e)
+		}
+	}()
+	var el SearchResults
+	err = result.GetElements(&el)
+	if err != nil {
+		panic(err)
+	}
+	for label, sr := range el {
+		for _, mf := range sr {
+			if mf.File == "" {
+				if foundOnly {
+					continue
+				}
+				rows = append(rows, modules.ResultRow{"search": label, "file": ""})
+				continue
+			}
+			row := modules.ResultRow{
+				"search":       label,
+				"file":         mf.File,
+				"size":         mf.FileInfo.Size,
+				"mode":         mf.FileInfo.Mode,
+				"lastmodified": mf.FileInfo.Mtime,
+				"sha256":       strings.ToLower(mf.FileInfo.SHA256),
+			}
+			if mf.FileInfo.SSDeep != "" {
+				row["ssdeep"] = mf.FileInfo.SSDeep
+				row["similarity"] = mf.FileInfo.Similarity
+			}
+			if len(mf.Search.Entropies) > 0 || mf.FileInfo.Entropy != 0 {
+				row["entropy"] = mf.FileInfo.Entropy
+			}
+			if len(mf.Content) == 0 {
+				rows = append(rows, row)
+				continue
+			}
+			for _, cm := range mf.Content {
+				r := modules.ResultRow{
+					"line":   cm.Line,
+					"offset": cm.Offset,
+					"text":   cm.Text,
+				}
+				for k, v := range row {
+					r[k] = v
+				}
+				rows = append(rows, r)
+			}
+		}
+	}
+	return
+}
+
+// Enhanced privacy mode for file module, mask file names and file content being
+// returned by the module
 func (r *run) EnhancePrivacy(in modules.Result) (out modules.Result, err error) {
 	var el SearchResults
 	out = in
@@ -1914,6 +2350,16 @@ func (r *run) EnhancePrivacy(in modules.Result) (out modules.Result, err error)
 			if v[i].File != "" {
 				v[i].File = "masked"
 			}
+			for j := range v[i].Content {
+				cm := &v[i].Content[j]
+				cm.Text = "masked"
+				for k := range cm.Before {
+					cm.Before[k] = "masked"
+				}
+				for k := range cm.After {
+					cm.After[k] = "masked"
+				}
+			}
 		}
 		el[k] = v
 	}
@@ -10,11 +10,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -182,6 +184,247 @@ func TestMakeChecksBadMTime(t *testing.T) {
 	}
 }
 
+func TestValidateContentOptions(t *testing.T) {
+	var testcases = []struct {
+		s     Search
+		valid bool
+	}{
+		{Search{Contents: []string{"root"}, Options: options{ReturnContent: true}}, true},
+		{Search{Contents: []string{"root"}, Options: options{ReturnContent: true, ContextLines: 100, MaxContentBytes: 10}}, true},
+		{Search{Options: options{ReturnContent: true}}, false},
+		{Search{Contents: []string{"root"}, Options: options{ContextLines: 2}}, false},
+		{Search{Contents: []string{"root"}, Options: options{MaxContentBytes: 100}}, false},
+		{Search{Contents: []string{"root"}, Options: options{ReturnContent: true, ContextLines: 101}}, false},
+		{Search{Contents: []string{"root"}, Options: options{ReturnContent: true, MaxContentBytes: -1}}, false},
+	}
+	for i, tc := range testcases {
+		err := validateContentOptions(&tc.s)
+		if (err == nil) != tc.valid {
+			t.Fatalf("case %d: expected valid=%v, got %v", i, tc.valid, err)
+		}
+	}
+}
+
+func TestReturnContent(t *testing.T) {
+	var (
+		r  run
+		mr modules.Result
+		sr SearchResults
+	)
+	debug = false
+	walkingErrors = make([]string, 0)
+	tryDecompress = false
+	stats.Filescount = 0
+	stats.Openfailed = 0
+	stats.Totalhits = 0
+	stats.Exectime = ""
+
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	data := "first\r\nsecond\nalpha one\nthird\nfourth\nalpha two\nlast\n"
+	err = ioutil.WriteFile(path.Join(dir, "content"), []byte(data), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	r.Parameters = *newParameters()
+	s := Search{Paths: []string{dir}, Contents: []string{"^alpha"}}
+	s.Options.ReturnContent = true
+	s.Options.ContextLines = 1
+	r.Parameters.Searches["s1"] = &s
+	msg, err := modules.MakeMessage(modules.MsgClassParameters, r.Parameters, false)
+	if err != nil {
+		t.Fatalf("modules.MakeMessage: %v", err)
+	}
+	out := r.Run(modules.NewModuleReader(bytes.NewBuffer(msg)))
+	err = json.Unmarshal([]byte(out), &mr)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	err = mr.GetElements(&sr)
+	if err != nil {
+		t.Fatalf("GetElements: %v", err)
+	}
+	if len(sr["s1"]) != 1 {
+		t.Fatalf("expected 1 matched file, got %v", out)
+	}
+	mf := sr["s1"][0]
+	if len(mf.Content) != 2 || mf.ContentTruncated {
+		t.Fatalf("expected 2 content matches, got %+v", mf.Content)
+	}
+	cm := mf.Content[0]
+	if cm.Line != 3 || cm.Offset != 14 || cm.Text != "alpha one" || cm.Regex != "^alpha" ||
+		len(cm.Before) != 1 || cm.Before[0] != "second" || len(cm.After) != 1 || cm.After[0] != "third" {
+		t.Fatalf("unexpected first content match %+v", cm)
+	}
+	cm = mf.Content[1]
+	if cm.Line != 6 || cm.Offset != float64(strings.Index(data, "alpha two")) ||
+		cm.Before[0] != "fourth" || cm.After[0] != "last" {
+		t.Fatalf("unexpected second content match %+v", cm)
+	}
+	prints, err := r.PrintResults(mr, true)
+	if err != nil {
+		t.Fatalf("PrintResults: %v", err)
+	}
+	if len(prints) != 7 || prints[2] != "    3:alpha one" || prints[3] != "    4-third" {
+		t.Fatalf("unexpected printed results %q", prints)
+	}
+	rows, err := r.FlattenResults(mr, true)
+	if err != nil {
+		t.Fatalf("FlattenResults: %v", err)
+	}
+	if len(rows) != 2 || rows[1]["text"] != "alpha two" || rows[1]["line"] != float64(6) {
+		t.Fatalf("unexpected flattened results %v", rows)
+	}
+	mr, err = r.EnhancePrivacy(mr)
+	if err != nil {
+		t.Fatalf("EnhancePrivacy: %v", err)
+	}
+	err = mr.GetElements(&sr)
+	if err != nil {
+		t.Fatalf("GetElements: %v", err)
+	}
+	for _, cm := range sr["s1"][0].Content {
+		if cm.Text != "masked" || cm.Before[0] != "masked" || cm.After[0] != "masked" {
+			t.Fatalf("content was not masked: %+v", cm)
+		}
+	}
+}
+
+func TestExtractContentBudget(t *testing.T) {
+	fd, err := ioutil.TempFile("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer os.Remove(fd.Name())
+	fd.WriteString("match 1\nmatch 2\nmatch 3\n")
+	fd.Close()
+	checks := []check{{code: checkContent, value: "match", regex: regexp.MustCompile("match")}}
+	budget := 16
+	matches, truncated, err := extractContent(fileEntry{filename: fd.Name()}, checks, 0, &budget)
+	if err != nil {
+		t.Fatalf("extractContent: %v", err)
+	}
+	if len(matches) != 2 || !truncated || budget != 2 {
+		t.Fatalf("expected 2 matches and truncation, got %+v, %v, budget %d", matches, truncated, budget)
+	}
+	budget = 0
+	matches, truncated, err = extractContent(fileEntry{filename: fd.Name()}, checks, 0, &budget)
+	if err != nil || len(matches) != 0 || !truncated {
+		t.Fatalf("expected no match with an exhausted budget, got %+v, %v, %v", matches, truncated, err)
+	}
+}
+
+func TestReturnContentLongLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	long := "var x='" + strings.Repeat("a", 70*1024) + "';"
+	jsdata := "var secret=1;\n" + long + "\nvar secret=2;\n"
+	err = ioutil.WriteFile(path.Join(dir, "a.js"), []byte(jsdata), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, "b.txt"), []byte("secret=3\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	s := Search{Paths: []string{dir}, Contents: []string{"secret="}}
+	s.Options.ReturnContent = true
+	files, sr, errs := runSearch(t, s)
+	if len(files) != 2 {
+		t.Fatalf("expected 2 matched files, got %v", files)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	for _, mf := range sr["s1"] {
+		switch path.Base(mf.File) {
+		case "a.js":
+			if len(mf.Content) != 2 || mf.ContentTruncated {
+				t.Fatalf("expected 2 content matches in a.js, got %+v", mf.Content)
+			}
+			cm := mf.Content[1]
+			if cm.Line != 3 || cm.Offset != float64(strings.Index(jsdata, "var secret=2")) ||
+				cm.Text != "var secret=2;" {
+				t.Fatalf("unexpected content match after the long line %+v", cm)
+			}
+		case "b.txt":
+			if len(mf.Content) != 1 || mf.Content[0].Text != "secret=3" {
+				t.Fatalf("unexpected content in b.txt %+v", mf.Content)
+			}
+		}
+	}
+	// a long line that matches is truncated
+	checks := []check{{code: checkContent, value: "var x", regex: regexp.MustCompile("var x")}}
+	budget := 2 * maxContentLineLength
+	matches, _, err := extractContent(fileEntry{filename: path.Join(dir, "a.js")}, checks, 1, &budget)
+	if err != nil {
+		t.Fatalf("extractContent: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match of the long line, got %d", len(matches))
+	}
+	cm := matches[0]
+	if cm.Line != 2 || cm.Text != long[:maxContentLineLength] || len(cm.After) != 1 || cm.After[0] != "var secret=2;" {
+		t.Fatalf("unexpected match of the long line: line %v, %d bytes, after %q", cm.Line, len(cm.Text), cm.After)
+	}
+}
+
+// runSearch runs a single search and returns the files it matched, the results and
+// the errors of the run
+func runSearch(t *testing.T, s Search) (files []string, sr SearchResults, errs []string) {
+	var (
+		r  run
+		mr modules.Result
+	)
+	debug = false
+	walkingErrors = make([]string, 0)
+	tryDecompress = false
+	stats.Filescount = 0
+	stats.Openfailed = 0
+	stats.Totalhits = 0
+	stats.Exectime = ""
+	r.Parameters = *newParameters()
+	s.Options.MatchAll = true
+	r.Parameters.Searches["s1"] = &s
+	msg, err := modules.MakeMessage(modules.MsgClassParameters, r.Parameters, false)
+	if err != nil {
+		t.Fatalf("modules.MakeMessage: %v", err)
+	}
+	out := r.Run(modules.NewModuleReader(bytes.NewBuffer(msg)))
+	err = json.Unmarshal([]byte(out), &mr)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !mr.Success {
+		t.Fatalf("search failed: %v", out)
+	}
+	err = mr.GetElements(&sr)
+	if err != nil {
+		t.Fatalf("GetElements: %v", err)
+	}
+	for _, mf := range sr["s1"] {
+		if mf.File != "" {
+			files = append(files, mf.File)
+		}
+	}
+	sort.Strings(files)
+	return files, sr, mr.Errors
+}
+
 func TestBadRunParameters(t *testing.T) {
 	var (
 		r  run
@@ -0,0 +1,523 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package file /* import "github.com/mozilla/mig/modules/file" */
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file implements the context triggered piecewise hashes of ssdeep, and
+// their comparison, following the reference implementation of ssdeep 2.14.
+// A rolling hash over the last 7 bytes of the input decides where the input is
+// cut into pieces, and each piece is represented by one base64 character of a
+// hash of its content. The length of the pieces depends on the block size,
+// which is chosen to produce a digest of 32 to 64 characters. A digest contains
+// the pieces of the block size, and of twice the block size, such that digests
+// of files of similar sizes can be compared.
+
+const (
+	ssdeepWindow    = 7
+	ssdeepMinBlock  = 3
+	ssdeepHashPrime = 0x01000193
+	ssdeepHashInit  = 0x28021967
+	ssdeepLength    = 64
+	ssdeepNumBlocks = 31
+	ssdeepB64       = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// defaultSSDeepThreshold is the minimum similarity score, between 0 and 100, of
+// a file to an ssdeep hash for an ssdeep check to match
+const defaultSSDeepThreshold float64 = 50
+
+type rollingHash struct {
+	h1, h2, h3 uint32
+	n          int
+	window     [ssdeepWindow]byte
+}
+
+func (r *rollingHash) roll(c byte) {
+	r.h2 -= r.h1
+	r.h2 += ssdeepWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n])
+	r.window[r.n] = c
+	r.n = (r.n + 1) % ssdeepWindow
+	r.h3 <<= 5
+	r.h3 ^= uint32(c)
+}
+
+func (r *rollingHash) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+func sumHash(c byte, h uint32) uint32 {
+	return (h * ssdeepHashPrime) ^ uint32(c)
+}
+
+// blockHash is the digest of the input for a given block size. The character
+// following the digest is the piece that is updated once the digest is full.
+type blockHash struct {
+	h, halfh   uint32
+	digest     [ssdeepLength]byte
+	halfdigest byte
+	dlen       int
+}
+
+// ssdeepState computes the ssdeep hash of the data written to it, for all the
+// block sizes at once such that the input is only read once
+type ssdeepState struct {
+	bh       [ssdeepNumBlocks]blockHash
+	bhend    int
+	total    uint64
+	roll     rollingHash
+	lasth    uint32
+	needLast bool
+}
+
+func newSSDeep() *ssdeepState {
+	s := &ssdeepState{bhend: 1}
+	s.bh[0].h = ssdeepHashInit
+	s.bh[0].halfh = ssdeepHashInit
+	return s
+}
+
+func ssdeepBlockSize(i int) uint64 {
+	return ssdeepMinBlock << uint(i)
+}
+
+// fork starts the digest of the next block size, from the state of the
+// largest block size
+func (s *ssdeepState) fork() {
+	last := &s.bh[s.bhend-1]
+	if s.bhend < ssdeepNumBlocks {
+		next := &s.bh[s.bhend]
+		next.h = last.h
+		next.halfh = last.halfh
+		next.digest[0] = 0
+		next.halfdigest = 0
+		next.dlen = 0
+		s.bhend++
+	} else if !s.needLast {
+		s.needLast = true
+		s.lasth = last.h
+	}
+}
+
+func (s *ssdeepState) Write(p []byte) (int, error) {
+	s.total += uint64(len(p))
+	for _, c := range p {
+		s.roll.roll(c)
+		h := s.roll.sum()
+		for i := 0; i < s.bhend; i++ {
+			s.bh[i].h = sumHash(c, s.bh[i].h)
+			s.bh[i].halfh = sumHash(c, s.bh[i].halfh)
+		}
+		if s.needLast {
+			s.lasth = sumHash(c, s.lasth)
+		}
+		if h == 0 || h%ssdeepMinBlock != ssdeepMinBlock-1 {
+			continue
+		}
+		// the rolling hash hit a reset point, emit a piece for each block
+		// size it is a reset point of
+		for i := 0; i < s.bhend; i++ {
+			bs := ssdeepBlockSize(i)
+			if uint64(h)%bs != bs-1 {
+				break
+			}
+			if s.bh[i].dlen == 0 {
+				s.fork()
+			}
+			b := &s.bh[i]
+			b.digest[b.dlen] = ssdeepB64[b.h%64]
+			b.halfdigest = ssdeepB64[b.halfh%64]
+			// once the digest is full, the last piece covers the rest of
+			// the input
+			if b.dlen < ssdeepLength-1 {
+				b.dlen++
+				b.digest[b.dlen] = 0
+				b.h = ssdeepHashInit
+				if b.dlen < ssdeepLength/2 {
+					b.halfh = ssdeepHashInit
+					b.halfdigest = 0
+				}
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Sum returns the ssdeep hash of the data written so far, in the format
+// blocksize:digest:doubledigest
+func (s *ssdeepState) Sum() (string, error) {
+	bi := 0
+	for ssdeepBlockSize(bi)*ssdeepLength < s.total {
+		bi++
+		if bi >= ssdeepNumBlocks {
+			return "", fmt.Errorf("input is too large for ssdeep")
+		}
+	}
+	for bi >= s.bhend {
+		bi--
+	}
+	for bi > 0 && s.bh[bi].dlen < ssdeepLength/2 {
+		bi--
+	}
+	h := s.roll.sum()
+	b := s.bh[bi]
+	out := []byte(strconv.FormatUint(ssdeepBlockSize(bi), 10) + ":")
+	out = append(out, b.digest[:b.dlen]...)
+	if h != 0 {
+		out = append(out, ssdeepB64[b.h%64])
+	} else if b.digest[b.dlen] != 0 {
+		out = append(out, b.digest[b.dlen])
+	}
+	out = append(out, ':')
+	if bi < s.bhend-1 {
+		b = s.bh[bi+1]
+		n := b.dlen
+		if n > ssdeepLength/2-1 {
+			n = ssdeepLength/2 - 1
+		}
+		out = append(out, b.digest[:n]...)
+		if h != 0 {
+			out = append(out, ssdeepB64[b.halfh%64])
+		} else if b.halfdigest != 0 {
+			out = append(out, b.halfdigest)
+		}
+	} else if h != 0 {
+		if bi == 0 {
+			out = append(out, ssdeepB64[b.h%64])
+		} else {
+			out = append(out, ssdeepB64[s.lasth%64])
+		}
+	}
+	return string(out), nil
+}
+
+var ssdeepRegex = regexp.MustCompile(`^([0-9]+):([A-Za-z0-9+/]{0,64}):([A-Za-z0-9+/]{0,64})$`)
+
+// parseSSDeep returns the block size and the two digests of an ssdeep hash
+func parseSSDeep(hash string) (bs uint64, d1, d2 string, err error) {
+	m := ssdeepRegex.FindStringSubmatch(hash)
+	if m == nil {
+		return 0, "", "", fmt.Errorf("Invalid ssdeep hash '%s'. Must match regex %s", hash, ssdeepRegex.String())
+	}
+	bs, err = strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("Invalid block size in ssdeep hash '%s'", hash)
+	}
+	valid := false
+	for i := 0; i < ssdeepNumBlocks; i++ {
+		if bs == ssdeepBlockSize(i) {
+			valid = true
+		}
+	}
+	if !valid {
+		return 0, "", "", fmt.Errorf("Invalid block size in ssdeep hash '%s'", hash)
+	}
+	return bs, m[2], m[3], nil
+}
+
+// eliminateSequences removes the characters repeated more than three times in a
+// row, which carry little information
+func eliminateSequences(s string) string {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if i >= 3 && s[i] == s[i-1] && s[i] == s[i-2] && s[i] == s[i-3] {
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// hasCommonSubstring returns true if two digests share a sequence of
+// characters as long as the rolling window
+func hasCommonSubstring(s1, s2 string) bool {
+	for i := 0; i+ssdeepWindow <= len(s1); i++ {
+		if strings.Contains(s2, s1[i:i+ssdeepWindow]) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the weighted edit distance of ssdeep, where insertions and
+// deletions cost 1, and substitutions cost 2
+func editDistance(s1, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	cur := make([]int, len(s2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s2); j++ {
+			cost := 2
+			if s1[i-1] == s2[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(s2)]
+}
+
+// scoreDigests scores the similarity of two digests of the same block size
+// between 0 and 100. Small block sizes have their score capped to prevent
+// short digests of small files from reporting exaggerated similarities.
+func scoreDigests(s1, s2 string, bs uint64) uint64 {
+	if len(s1) < ssdeepWindow || len(s2) < ssdeepWindow || !hasCommonSubstring(s1, s2) {
+		return 0
+	}
+	score := uint64(editDistance(s1, s2))
+	score = score * ssdeepLength / uint64(len(s1)+len(s2))
+	score = 100 * score / ssdeepLength
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+	if bs >= (99+ssdeepWindow)/ssdeepWindow*ssdeepMinBlock {
+		return score
+	}
+	minlen := len(s1)
+	if len(s2) < minlen {
+		minlen = len(s2)
+	}
+	if limit := bs / ssdeepMinBlock * uint64(minlen); score > limit {
+		score = limit
+	}
+	return score
+}
+
+// compareSSDeep returns the similarity score of two ssdeep hashes, between 0
+// for unrelated inputs and 100 for identical inputs. Hashes can only be
+// compared if their block sizes are equal, or if one is twice the other.
+func compareSSDeep(a, b string) (score int, err error) {
+	bs1, s1a, s1b, err := parseSSDeep(a)
+	if err != nil {
+		return
+	}
+	bs2, s2a, s2b, err := parseSSDeep(b)
+	if err != nil {
+		return
+	}
+	if bs1 != bs2 && bs1 != 2*bs2 && bs2 != 2*bs1 {
+		return 0, nil
+	}
+	s1a, s1b = eliminateSequences(s1a), eliminateSequences(s1b)
+	s2a, s2b = eliminateSequences(s2a), eliminateSequences(s2b)
+	if bs1 == bs2 && s1a == s2a {
+		return 100, nil
+	}
+	var s uint64
+	switch {
+	case bs1 == bs2:
+		s = scoreDigests(s1a, s2a, bs1)
+		if s2 := scoreDigests(s1b, s2b, 2*bs1); s2 > s {
+			s = s2
+		}
+	case bs1 == 2*bs2:
+		s = scoreDigests(s1a, s2b, bs1)
+	default:
+		s = scoreDigests(s1b, s2a, bs2)
+	}
+	return int(s), nil
+}
+
+// entropyCounter counts the occurrences of each byte value written to it
+type entropyCounter struct {
+	counts [256]uint64
+	total  uint64
+}
+
+func (e *entropyCounter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		e.counts[c]++
+	}
+	e.total += uint64(len(p))
+	return len(p), nil
+}
+
+// Entropy returns the Shannon entropy of the data written so far, in bits per
+// byte, between 0 and 8
+func (e *entropyCounter) Entropy() (h float64) {
+	if e.total == 0 {
+		return 0
+	}
+	for _, n := range e.counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(e.total)
+		h -= p * math.Log2(p)
+	}
+	return
+}
+
+var entropyRegex = regexp.MustCompile(`^(<|>)?([0-9]+(?:\.[0-9]+)?)(?:-([0-9]+(?:\.[0-9]+)?))?$`)
+
+// parseEntropy parses an entropy range into inclusive bounds. Entropy accepts
+// the prefixes '<' and '>' for lower than and greater than, or a range of two
+// values separated by a dash. example: '>7.2' will find files with an entropy
+// greater than 7.2 bits per byte, which is typical of compressed or encrypted
+// data.
+func parseEntropy(entropy string) (min, max float64, err error) {
+	m := entropyRegex.FindStringSubmatch(entropy)
+	if m == nil || (m[1] != "" && m[3] != "") || (m[1] == "" && m[3] == "") {
+		return 0, 0, fmt.Errorf("Invalid entropy format for entropy '%s'. Must be <value, >value or min-max", entropy)
+	}
+	v, _ := strconv.ParseFloat(m[2], 64)
+	switch m[1] {
+	case "<":
+		return 0, math.Nextafter(v, math.Inf(-1)), nil
+	case ">":
+		return math.Nextafter(v, math.Inf(1)), 8, nil
+	}
+	max, _ = strconv.ParseFloat(m[3], 64)
+	if v > max || max > 8 {
+		return 0, 0, fmt.Errorf("Invalid entropy range '%s'. Values must be ordered and between 0 and 8", entropy)
+	}
+	return v, max, nil
+}
+
+// fuzzyDigest reads a file and returns its ssdeep hash and its entropy
+func fuzzyDigest(f fileEntry) (hash string, entropy float64, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("fuzzyDigest() -> %v", e)
+		}
+	}()
+	reader := f.getReader()
+	defer f.Close()
+	ss := newSSDeep()
+	var ec entropyCounter
+	_, err = io.Copy(io.MultiWriter(ss, &ec), reader)
+	if err != nil {
+		panic(err)
+	}
+	hash, err = ss.Sum()
+	if err != nil {
+		panic(err)
+	}
+	return hash, ec.Entropy(), nil
+}
+
+// checkFuzzy evaluates the ssdeep and entropy checks of the active searches
+// against a file
+func (r *run) checkFuzzy(f fileEntry) {
+	var (
+		err error
+	)
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("checkFuzzy() -> %v", e)
+			walkingErrors = append(walkingErrors, err.Error())
+		}
+	}()
+	// skip this check if no search has anything to run
+	nothingToDo := true
+	for _, search := range r.Parameters.Searches {
+		if search.isactive && (search.checkmask&(checkSSDeep|checkEntropy)) != 0 {
+			nothingToDo = false
+		}
+	}
+	if nothingToDo {
+		return
+	}
+	hash, entropy, err := fuzzyDigest(f)
+	if err != nil {
+		panic(err)
+	}
+	for label, search := range r.Parameters.Searches {
+		if search.isactive && (search.checkmask&(checkSSDeep|checkEntropy)) != 0 {
+			for i := range search.checks {
+				c := &search.checks[i]
+				match := false
+				switch c.code {
+				case checkSSDeep:
+					score, err := compareSSDeep(c.value, hash)
+					if err != nil {
+						panic(err)
+					}
+					if float64(score) >= c.minscore {
+						match = true
+						debugprint("checkFuzzy: file '%s' with ssdeep '%s' is %d%% similar to '%s'\n",
+							f.filename, hash, score, c.value)
+					}
+				case checkEntropy:
+					if entropy >= c.minentropy && entropy <= c.maxentropy {
+						match = true
+						debugprint("checkFuzzy: file '%s' entropy %.4f is in range '%s'\n",
+							f.filename, entropy, c.value)
+					}
+				default:
+					continue
+				}
+				if c.wantThis(match) {
+					c.storeMatch(f.filename)
+				} else if search.Options.MatchAll {
+					search.deactivate()
+				}
+			}
+		}
+		r.Parameters.Searches[label] = search
+	}
+	return
+}
+
+// addFuzzy adds the ssdeep hash and the entropy of a matched file to its
+// information when checks use them, with its highest similarity score to the
+// ssdeep hashes of the checks
+func (mf *MatchedFile) addFuzzy(f fileEntry, checks []check) {
+	var hasSSDeep, hasEntropy bool
+	for _, c := range checks {
+		switch c.code {
+		case checkSSDeep:
+			hasSSDeep = true
+		case checkEntropy:
+			hasEntropy = true
+		}
+	}
+	if !hasSSDeep && !hasEntropy {
+		return
+	}
+	hash, entropy, err := fuzzyDigest(f)
+	if err != nil {
+		panic(err)
+	}
+	if hasEntropy {
+		mf.FileInfo.Entropy = math.Floor(entropy*10000) / 10000
+	}
+	if !hasSSDeep {
+		return
+	}
+	mf.FileInfo.SSDeep = hash
+	for _, c := range checks {
+		if c.code != checkSSDeep {
+			continue
+		}
+		score, err := compareSSDeep(c.value, hash)
+		if err != nil {
+			panic(err)
+		}
+		if float64(score) > mf.FileInfo.Similarity {
+			mf.FileInfo.Similarity = float64(score)
+		}
+	}
+}
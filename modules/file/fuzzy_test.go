@@ -0,0 +1,181 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package file /* import "github.com/mozilla/mig/modules/file" */
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func ssdeepOf(t *testing.T, data []byte) string {
+	s := newSSDeep()
+	s.Write(data)
+	h, err := s.Sum()
+	if err != nil {
+		t.Fatalf("Sum: %v", err)
+	}
+	return h
+}
+
+func TestSSDeep(t *testing.T) {
+	var testcases = []struct {
+		data, hash string
+	}{
+		{"", "3::"},
+		{"Also called fuzzy hashes, CTPH can match inputs that have homologies.",
+			"3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C"},
+	}
+	for _, tc := range testcases {
+		h := ssdeepOf(t, []byte(tc.data))
+		if h != tc.hash {
+			t.Fatalf("expected ssdeep %s of %q, got %s", tc.hash, tc.data, h)
+		}
+	}
+	// hashing the data in several writes returns the same hash
+	data := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 500))
+	s := newSSDeep()
+	s.Write(data[:1000])
+	s.Write(data[1000:])
+	h, _ := s.Sum()
+	if h != ssdeepOf(t, data) {
+		t.Fatalf("hash of split writes %s differs from %s", h, ssdeepOf(t, data))
+	}
+}
+
+func TestCompareSSDeep(t *testing.T) {
+	score, err := compareSSDeep("3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C", "3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C")
+	if err != nil || score != 22 {
+		t.Fatalf("expected a score of 22, got %d, %v", score, err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	data := make([]byte, 64*1024)
+	rnd.Read(data)
+	modified := append([]byte{}, data...)
+	copy(modified[30000:], "some bytes of malware that were modified")
+	other := make([]byte, 64*1024)
+	rnd.Read(other)
+	h, hm, ho := ssdeepOf(t, data), ssdeepOf(t, modified), ssdeepOf(t, other)
+	if score, _ := compareSSDeep(h, h); score != 100 {
+		t.Fatalf("identical hashes should score 100, got %d", score)
+	}
+	if score, _ := compareSSDeep(h, hm); score < 80 || score == 100 {
+		t.Fatalf("expected a high score for modified data, got %d for %s and %s", score, h, hm)
+	}
+	if score, _ := compareSSDeep(h, ho); score != 0 {
+		t.Fatalf("expected a score of 0 for unrelated data, got %d for %s and %s", score, h, ho)
+	}
+	for _, invalid := range []string{"", "4:abc:def", "3:abc", "3:abc:de$f"} {
+		if _, err := compareSSDeep(invalid, h); err == nil {
+			t.Fatalf("invalid hash %q was accepted", invalid)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	var e entropyCounter
+	if e.Entropy() != 0 {
+		t.Fatalf("empty data should have an entropy of 0")
+	}
+	e.Write([]byte("aaaa"))
+	if e.Entropy() != 0 {
+		t.Fatalf("constant data should have an entropy of 0, got %f", e.Entropy())
+	}
+	e.Write([]byte("bbbb"))
+	if e.Entropy() != 1 {
+		t.Fatalf("expected an entropy of 1, got %f", e.Entropy())
+	}
+	var u entropyCounter
+	for i := 0; i < 256; i++ {
+		u.Write([]byte{byte(i)})
+	}
+	if math.Abs(u.Entropy()-8) > 1e-9 {
+		t.Fatalf("expected an entropy of 8, got %f", u.Entropy())
+	}
+}
+
+func TestParseEntropy(t *testing.T) {
+	var testcases = []struct {
+		value    string
+		entropy  float64
+		expected bool
+	}{
+		{"<7", 6.99, true},
+		{"<7", 7, false},
+		{">7.2", 7.2, false},
+		{">7.2", 7.21, true},
+		{"7-8", 7, true},
+		{"7-8", 8, true},
+		{"0-1.5", 1.6, false},
+	}
+	for _, tc := range testcases {
+		min, max, err := parseEntropy(tc.value)
+		if err != nil {
+			t.Fatalf("parseEntropy(%q): %v", tc.value, err)
+		}
+		if (tc.entropy >= min && tc.entropy <= max) != tc.expected {
+			t.Fatalf("entropy %f in %q: expected %v", tc.entropy, tc.value, tc.expected)
+		}
+	}
+	for _, invalid := range []string{"", "7", "<7-8", "8-7", "7-9", ">abc"} {
+		if _, _, err := parseEntropy(invalid); err == nil {
+			t.Fatalf("invalid entropy %q was accepted", invalid)
+		}
+	}
+}
+
+func TestFuzzyChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migfiletest")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatalf("EvalSymlinks: %v", err)
+	}
+	rnd := rand.New(rand.NewSource(2))
+	sample := make([]byte, 32*1024)
+	rnd.Read(sample)
+	variant := append([]byte{}, sample...)
+	copy(variant[1000:], "a slightly different variant of the sample")
+	text := []byte(strings.Repeat("nothing to see here\n", 1000))
+	for name, data := range map[string][]byte{"variant": variant, "text": text} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	s := Search{Paths: []string{dir}, SSDeep: []string{ssdeepOf(t, sample)}}
+	files, sr, errs := runSearch(t, s)
+	if len(errs) != 0 || len(files) != 1 || files[0] != filepath.Join(dir, "variant") {
+		t.Fatalf("expected the variant to match the ssdeep check, got %v, %v", files, errs)
+	}
+	fi := sr["s1"][0].FileInfo
+	if fi.SSDeep != ssdeepOf(t, variant) || fi.Similarity < 50 || fi.Similarity == 100 {
+		t.Fatalf("unexpected fuzzy hash information %+v", fi)
+	}
+
+	s.Options.SSDeepThreshold = 100
+	files, _, _ = runSearch(t, s)
+	if len(files) != 0 {
+		t.Fatalf("expected no file to be identical to the sample, got %v", files)
+	}
+
+	s = Search{Paths: []string{dir}, Entropies: []string{">7.5"}}
+	files, sr, _ = runSearch(t, s)
+	if len(files) != 1 || files[0] != filepath.Join(dir, "variant") || sr["s1"][0].FileInfo.Entropy < 7.5 {
+		t.Fatalf("expected the variant to match the entropy check, got %v", sr)
+	}
+	s.Options.Mismatch = []string{"entropy"}
+	files, _, _ = runSearch(t, s)
+	if len(files) != 1 || files[0] != filepath.Join(dir, "text") {
+		t.Fatalf("expected the text file to mismatch the entropy check, got %v", files)
+	}
+}
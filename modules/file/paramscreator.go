@@ -52,6 +52,15 @@ func printHelp(isCmd bool) {
 %ssha2 <hash>     .
 %ssha3 <hash>     - search file that matches a given hash
 
+%sssdeep <hash>   - search file similar to a given ssdeep fuzzy hash. the similarity
+		  score must be at least 'ssdeepthreshold'.
+		  ex: %sssdeep 3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2C
+
+%sentropy <range> - match files with a shannon entropy, in bits per byte between 0
+		  and 8, lower than, greater than or between values.
+		  ex: %sentropy >7.2  (compressed or encrypted data)
+		      %sentropy 4-6
+
 Options
 -------
 %smaxdepth <int>	- limit search depth to <int> levels. default to 1000, 0 means no limit.
@@ -85,6 +94,39 @@ Options
 %sdecompress		- decompress file before inspection
 			  ex: %sdecompress
 
+%sreturncontent		- return the lines that matched content regexes, with their
+			  line number and byte offset in the file.
+			  ex: %sreturncontent
+
+%scontextlines <int>	- with returncontent, also return <int> lines before and after
+			  each matched line. default to 0, maximum is 100.
+			  ex: %scontextlines 3
+
+%smaxcontentbytes <int> - with returncontent, limit the text returned for all the files
+			  of a search to <int> bytes. default to 4096.
+			  ex: %smaxcontentbytes 65536
+
+%sarchives		- also search the files stored in zip (jar, war, ...) and tar
+			  archives, optionally compressed with gzip or bzip2. archive
+			  members are returned as <archive>!/<path in archive>.
+			  ex: %sarchives
+
+%sarchivedepth <int>	- with archives, number of nested archives to walk into.
+			  default to 3.
+			  ex: %sarchivedepth 1
+
+%sarchivemaxsize <int>	- with archives, maximum number of decompressed bytes read
+			  from an archive. default to 104857600 (100MB).
+			  ex: %sarchivemaxsize 10485760
+
+%sarchivemaxfiles <int> - with archives, maximum number of files read from an archive.
+			  default to 100000.
+			  ex: %sarchivemaxfiles 1000
+
+%sssdeepthreshold <int> - minimum similarity score between 0 and 100 of a file to the
+			  hash of an ssdeep check for the check to match. default to 50.
+			  ex: %sssdeepthreshold 80
+
 %smaxerrors <int>	- limit walking errors returned during search to <int>.
 			  default to 30, 0 means no walking error is returned.
 			  ex: %smaxerrors 1000
@@ -95,7 +137,9 @@ Cheatsheet and examples are at http://mig.mozilla.org/doc/cheatsheet.rst.html
 		dash, dash, dash, dash, dash, dash, dash, dash, dash,
 		dash, dash, dash, dash, dash, dash, dash, dash, dash,
 		dash, dash, dash, dash, dash, dash, dash, dash, dash,
-		dash, dash, dash, dash, dash, dash, dash)
+		dash, dash, dash, dash, dash, dash, dash, dash, dash, dash,
+		dash, dash, dash, dash, dash, dash, dash, dash, dash, dash,
+		dash, dash, dash, dash, dash, dash, dash, dash)
 
 	return
 }
@@ -311,6 +355,39 @@ func (r *run) ParamsCreator() (interface{}, error) {
 					continue
 				}
 				search.SHA3 = append(search.SHA3, checkValue)
+			case "ssdeep":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				_, _, _, err = parseSSDeep(checkValue)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.SSDeep = append(search.SSDeep, checkValue)
+			case "entropy":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				_, _, err = parseEntropy(checkValue)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Entropies = append(search.Entropies, checkValue)
+			case "ssdeepthreshold":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.SSDeepThreshold = v
 			case "maxdepth":
 				if checkValue == "" {
 					fmt.Println("Missing parameter, try again")
@@ -386,6 +463,73 @@ func (r *run) ParamsCreator() (interface{}, error) {
 					continue
 				}
 				search.Options.Decompress = true
+			case "returncontent":
+				if checkValue != "" {
+					fmt.Println("This option doesn't take arguments, try again")
+					continue
+				}
+				search.Options.ReturnContent = true
+			case "contextlines":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.ContextLines = v
+			case "maxcontentbytes":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.MaxContentBytes = v
+			case "archives":
+				if checkValue != "" {
+					fmt.Println("This option doesn't take arguments, try again")
+					continue
+				}
+				search.Options.Archives = true
+			case "archivedepth":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.ArchiveDepth = v
+			case "archivemaxsize":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.ArchiveMaxSize = v
+			case "archivemaxfiles":
+				if checkValue == "" {
+					fmt.Println("Missing parameter, try again")
+					continue
+				}
+				v, err := strconv.ParseFloat(checkValue, 64)
+				if err != nil {
+					fmt.Printf("ERROR: %v\nTry again.\n", err)
+					continue
+				}
+				search.Options.ArchiveMaxFiles = v
 			default:
 				fmt.Printf("Invalid method!\n")
 				continue
@@ -407,9 +551,11 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
 		err error
 		paths, names, sizes, modes, mtimes, contents, md5s, sha1s, sha2s,
-		sha3s, mismatch flagParam
-		maxdepth, maxerrors, matchlimit                                float64
+		sha3s, ssdeeps, entropies, mismatch flagParam
+		maxdepth, maxerrors, matchlimit, contextlines, maxcontentbytes float64
+		archivedepth, archivemaxsize, archivemaxfiles, ssdeepthreshold float64
 		returnsha256, matchall, matchany, macroal, verbose, decompress bool
+		returncontent, archives                                        bool
 		fs                                                             flag.FlagSet
 	)
 	if len(args) < 1 || args[0] == "" || args[0] == "help" {
@@ -427,6 +573,8 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.Var(&sha1s, "sha1", "see help")
 	fs.Var(&sha2s, "sha2", "see help")
 	fs.Var(&sha3s, "sha3", "see help")
+	fs.Var(&ssdeeps, "ssdeep", "see help")
+	fs.Var(&entropies, "entropy", "see help")
 	fs.Var(&mismatch, "mismatch", "see help")
 	fs.Float64Var(&maxdepth, "maxdepth", 1000, "see help")
 	fs.Float64Var(&maxerrors, "maxerrors", 30, "see help")
@@ -437,6 +585,14 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.BoolVar(&debug, "verbose", false, "see help")
 	fs.BoolVar(&returnsha256, "returnsha256", false, "see help")
 	fs.BoolVar(&decompress, "decompress", false, "see help")
+	fs.BoolVar(&returncontent, "returncontent", false, "see help")
+	fs.Float64Var(&contextlines, "contextlines", 0, "see help")
+	fs.Float64Var(&maxcontentbytes, "maxcontentbytes", 0, "see help")
+	fs.BoolVar(&archives, "archives", false, "see help")
+	fs.Float64Var(&archivedepth, "archivedepth", 0, "see help")
+	fs.Float64Var(&archivemaxsize, "archivemaxsize", 0, "see help")
+	fs.Float64Var(&archivemaxfiles, "archivemaxfiles", 0, "see help")
+	fs.Float64Var(&ssdeepthreshold, "ssdeepthreshold", 0, "see help")
 	err = fs.Parse(args)
 	if err != nil {
 		return nil, err
@@ -452,6 +608,8 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	s.SHA1 = sha1s
 	s.SHA2 = sha2s
 	s.SHA3 = sha3s
+	s.SSDeep = ssdeeps
+	s.Entropies = entropies
 	s.Options.MaxDepth = maxdepth
 	s.Options.MaxErrors = maxerrors
 	s.Options.MatchLimit = matchlimit
@@ -460,6 +618,14 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	s.Options.MatchAll = matchall
 	s.Options.ReturnSHA256 = returnsha256
 	s.Options.Decompress = decompress
+	s.Options.ReturnContent = returncontent
+	s.Options.ContextLines = contextlines
+	s.Options.MaxContentBytes = maxcontentbytes
+	s.Options.Archives = archives
+	s.Options.ArchiveDepth = archivedepth
+	s.Options.ArchiveMaxSize = archivemaxsize
+	s.Options.ArchiveMaxFiles = archivemaxfiles
+	s.Options.SSDeepThreshold = ssdeepthreshold
 	if matchany {
 		s.Options.MatchAll = false
 	}
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/mozilla/mig/modules"
 )
@@ -56,29 +57,47 @@ const (
 
 // Create a new alert and send it to channel ch
 func newAlert(sev int, f string, a ...interface{}) {
-	var sevstr string
+	newFileAlert(sev, Alert{}, f, a...)
+}
+
+// Create a new alert about a file, with the details in a, and send it to
+// channel ch
+func newFileAlert(sev int, a Alert, f string, args ...interface{}) {
 	switch sev {
 	case ALERT_CRITICAL:
-		sevstr = "critical"
+		a.Severity = "critical"
 	case ALERT_HIGH:
-		sevstr = "high"
+		a.Severity = "high"
 	case ALERT_MEDIUM:
-		sevstr = "medium"
+		a.Severity = "medium"
 	case ALERT_LOW:
-		sevstr = "low"
+		a.Severity = "low"
 	default:
-		sevstr = "unknown"
-	}
-	alertChan <- Alert{
-		Severity: sevstr,
-		Alert:    fmt.Sprintf(f, a...),
+		a.Severity = "unknown"
 	}
+	a.Alert = fmt.Sprintf(f, args...)
+	alertChan <- a
 }
 
 // An alert generated by the fswatch module
 type Alert struct {
-	Severity string `json:"severity"` // critical, etc...
-	Alert    string `json:"alert"`    // alert text
+	Severity       string       `json:"severity"`                 // critical, etc...
+	Alert          string       `json:"alert"`                    // alert text
+	Profile        string       `json:"profile,omitempty"`        // profile monitoring the file
+	Event          string       `json:"event,omitempty"`          // create, modify, remove or chmod
+	Path           string       `json:"path,omitempty"`           // path of the file
+	OldSHA256      string       `json:"oldsha256,omitempty"`      // previous hash of the file
+	NewSHA256      string       `json:"newsha256,omitempty"`      // new hash of the file
+	BaselineSHA256 string       `json:"baselinesha256,omitempty"` // expected hash of the file
+	Diff           *fileDiff    `json:"diff,omitempty"`           // changes of the file
+	Process        *processInfo `json:"process,omitempty"`        // process that made the change
+}
+
+// Process that changed a file, known when fanotify is in use
+type processInfo struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable,omitempty"` // empty if the process exited before it was identified
+	Uid        int    `json:"uid"`                  // real uid, -1 if the process exited before it was identified
 }
 
 // String convers an alert to a JSON string
@@ -125,7 +144,7 @@ func requestHandler(p interface{}) (ret string) {
 			return
 		}
 	}()
-	e := elements{Ok: true}
+	e := elements{Ok: true, Baseline: getBaseline()}
 	resp, err := buildResults(e, &results)
 	if err != nil {
 		panic(err)
@@ -137,6 +156,10 @@ type config struct {
 	Paths struct {
 		Path []string
 	}
+	Profile map[string]*profileConfig
+	Options struct {
+		Fanotify bool // Attribute changes to processes using fanotify, on linux
+	}
 }
 
 func (r *run) PersistModConfig() interface{} {
@@ -201,6 +224,14 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	}
 	resStr := fmt.Sprintf("ok:%v", elem.Ok)
 	prints = append(prints, resStr)
+	for _, b := range elem.Baseline {
+		resStr = fmt.Sprintf("%v %v %v expected:%v current:%v", b.Profile, b.Path,
+			b.Status, b.Expected, b.Current)
+		if !b.LastChange.IsZero() {
+			resStr += fmt.Sprintf(" lastchange:%v", b.LastChange.Format(time.RFC3339))
+		}
+		prints = append(prints, resStr)
+	}
 	if !foundOnly {
 		for _, we := range result.Errors {
 			prints = append(prints, we)
@@ -210,7 +241,8 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 }
 
 type elements struct {
-	Ok bool `json:"ok"`
+	Ok       bool            `json:"ok"`
+	Baseline []baselineState `json:"baseline,omitempty"` // State of the files with an expected hash
 }
 
 type Parameters struct {
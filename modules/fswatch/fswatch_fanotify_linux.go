@@ -0,0 +1,329 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build amd64 arm64
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Values from linux/fanotify.h
+const (
+	fanCloexec          = 0x1
+	fanReportDFIDName   = 0xc00 // FAN_REPORT_DIR_FID | FAN_REPORT_NAME
+	fanMarkAdd          = 0x1
+	fanMarkRemove       = 0x2
+	fanModify           = 0x2
+	fanMovedFrom        = 0x40
+	fanMovedTo          = 0x80
+	fanCreate           = 0x100
+	fanDelete           = 0x200
+	fanEventOnChild     = 0x08000000
+	fanOnDir            = 0x40000000
+	fanMetadataVersion  = 3
+	fanMetadataLen      = 24
+	fanInfoTypeDFIDName = 2
+	atFdcwd             = -100
+	oPath               = 0x200000
+	capDacReadSearch    = 2
+	capSysAdmin         = 21
+)
+
+// Events requested for monitored directories and files
+const (
+	fanotifyDirMask  = fanModify | fanCreate | fanDelete | fanMovedFrom | fanMovedTo | fanEventOnChild | fanOnDir
+	fanotifyFileMask = fanModify
+)
+
+// Attributions older than this are discarded
+const attributionExpiry = time.Minute
+
+// fanotify and fsnotify events are read independently, so the fanotify event
+// of a change can be recorded slightly before fsnotify reports the change
+const attributionSkew = 250 * time.Millisecond
+
+// Process that last changed a file
+type attribution struct {
+	process processInfo
+	seen    time.Time
+}
+
+// fanotifyWatcher receives fanotify events for the paths monitored by the
+// module, and records which process last created, modified or removed each
+// file. It does not replace fsnotify, which still drives the monitoring, it
+// only attributes the changes fsnotify reports to processes.
+//
+// Events are reported with the handle of the parent directory and the name of
+// the file, which requires linux 5.9 and CAP_SYS_ADMIN to initialize fanotify,
+// and CAP_DAC_READ_SEARCH to resolve the handles.
+type fanotifyWatcher struct {
+	fd int
+
+	sync.Mutex                        // Protects mountfds, recent and lastPrune
+	mountfds   map[[2]int32]int       // Descriptors used to resolve handles, per filesystem id
+	recent     map[string]attribution // Recent attributions, per path
+	lastPrune  time.Time
+}
+
+// Initialize fanotify and start reading events; returns an error if the agent
+// does not have the capability to use fanotify or if the kernel is too old
+func newFanotifyWatcher() (f *fanotifyWatcher, err error) {
+	// Recent kernels let unprivileged processes initialize fanotify, but
+	// handles can then not be resolved to paths, so check the capabilities
+	// explicitly
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	if caps&(1<<capSysAdmin) == 0 || caps&(1<<capDacReadSearch) == 0 {
+		return nil, fmt.Errorf("CAP_SYS_ADMIN and CAP_DAC_READ_SEARCH are required")
+	}
+	fd, _, errno := syscall.Syscall(syscall.SYS_FANOTIFY_INIT, fanCloexec|fanReportDFIDName,
+		uintptr(syscall.O_RDONLY|syscall.O_LARGEFILE), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("fanotify_init: %v", errno)
+	}
+	f = &fanotifyWatcher{
+		fd:        int(fd),
+		mountfds:  make(map[[2]int32]int),
+		recent:    make(map[string]attribution),
+		lastPrune: time.Now(),
+	}
+	go f.run()
+	return f, nil
+}
+
+// Return the effective capabilities of the agent
+func effectiveCapabilities() (uint64, error) {
+	fd, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			return strconv.ParseUint(fields[1], 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("effective capabilities not found in /proc/self/status")
+}
+
+// Start receiving events for path
+func (f *fanotifyWatcher) mark(path string) error {
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mask := fanotifyFileMask
+	if finfo.IsDir() {
+		mask = fanotifyDirMask
+	}
+	err = f.fanotifyMark(fanMarkAdd, mask, path)
+	if err != nil {
+		return err
+	}
+	// Keep a descriptor on the filesystem of the path, to resolve the
+	// directory handles reported in its events
+	var st syscall.Statfs_t
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.mountfds[st.Fsid.X__val]; ok {
+		return nil
+	}
+	mfd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	f.mountfds[st.Fsid.X__val] = mfd
+	return nil
+}
+
+// Stop receiving events for path
+func (f *fanotifyWatcher) unmark(path string) {
+	f.fanotifyMark(fanMarkRemove, fanotifyDirMask, path)
+}
+
+func (f *fanotifyWatcher) fanotifyMark(flags, mask int, path string) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	dirfd := atFdcwd
+	_, _, errno := syscall.Syscall6(syscall.SYS_FANOTIFY_MARK, uintptr(f.fd), uintptr(flags),
+		uintptr(mask), uintptr(dirfd), uintptr(unsafe.Pointer(p)), 0)
+	if errno != 0 {
+		return fmt.Errorf("fanotify_mark %v: %v", path, errno)
+	}
+	return nil
+}
+
+// Read and record events until the fanotify descriptor fails
+func (f *fanotifyWatcher) run() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := syscall.Read(f.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			logChan <- fmt.Sprintf("fanotify read failed, changes will no longer be attributed: %v", err)
+			return
+		}
+		f.parseEvents(buf[:n])
+	}
+}
+
+// Parse a buffer of events read from fanotify. The structures use the byte
+// order of the host, which is little endian on the supported architectures.
+func (f *fanotifyWatcher) parseEvents(b []byte) {
+	le := binary.LittleEndian
+	for len(b) >= fanMetadataLen {
+		evlen := int(le.Uint32(b[0:]))
+		vers := b[4]
+		metalen := int(le.Uint16(b[6:]))
+		fd := int32(le.Uint32(b[16:]))
+		pid := int(int32(le.Uint32(b[20:])))
+		if evlen < fanMetadataLen || evlen > len(b) || metalen > evlen || vers != fanMetadataVersion {
+			return
+		}
+		if fd >= 0 {
+			syscall.Close(int(fd))
+		}
+		path := f.eventPath(b[metalen:evlen])
+		if path != "" {
+			f.record(path, pid)
+		}
+		b = b[evlen:]
+	}
+}
+
+// Return the path of the file an event relates to, from its information
+// records
+func (f *fanotifyWatcher) eventPath(info []byte) string {
+	le := binary.LittleEndian
+	for len(info) >= 4 {
+		infotype := info[0]
+		l := int(le.Uint16(info[2:]))
+		if l < 4 || l > len(info) {
+			return ""
+		}
+		rec := info[4:l]
+		info = info[l:]
+		// The record contains the filesystem id, a file_handle structure for
+		// the parent directory, and the null terminated name of the file
+		if infotype != fanInfoTypeDFIDName || len(rec) < 16 {
+			continue
+		}
+		fsid := [2]int32{int32(le.Uint32(rec[0:])), int32(le.Uint32(rec[4:]))}
+		hlen := int(le.Uint32(rec[8:]))
+		if 16+hlen > len(rec) {
+			return ""
+		}
+		dir, err := f.resolve(fsid, rec[8:16+hlen])
+		if err != nil {
+			if debugFSWatch {
+				logChan <- fmt.Sprintf("fanotify: %v", err)
+			}
+			return ""
+		}
+		name := rec[16+hlen:]
+		if i := bytes.IndexByte(name, 0); i != -1 {
+			name = name[:i]
+		}
+		return filepath.Join(dir, string(name))
+	}
+	return ""
+}
+
+// Resolve a directory handle to its path
+func (f *fanotifyWatcher) resolve(fsid [2]int32, handle []byte) (string, error) {
+	f.Lock()
+	mfd, ok := f.mountfds[fsid]
+	f.Unlock()
+	if !ok {
+		return "", fmt.Errorf("event for unknown filesystem %v", fsid)
+	}
+	h := make([]byte, len(handle))
+	copy(h, handle)
+	fd, _, errno := syscall.Syscall(sysOpenByHandleAt, uintptr(mfd), uintptr(unsafe.Pointer(&h[0])),
+		uintptr(oPath|syscall.O_CLOEXEC))
+	if errno != 0 {
+		return "", fmt.Errorf("open_by_handle_at: %v", errno)
+	}
+	defer syscall.Close(int(fd))
+	return os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+}
+
+// Record pid as the last process that changed path
+func (f *fanotifyWatcher) record(path string, pid int) {
+	p := processInfo{Pid: pid, Uid: processUid(pid)}
+	p.Executable, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	f.Lock()
+	defer f.Unlock()
+	now := time.Now()
+	f.recent[path] = attribution{process: p, seen: now}
+	if now.Sub(f.lastPrune) > attributionExpiry {
+		for k, v := range f.recent {
+			if now.Sub(v.seen) > attributionExpiry {
+				delete(f.recent, k)
+			}
+		}
+		f.lastPrune = now
+	}
+}
+
+// Return the real uid of a process, or -1 if it cannot be read
+func processUid(pid int) int {
+	fd, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return -1
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return -1
+		}
+		return uid
+	}
+	return -1
+}
+
+// Return the process that last changed path, if its fanotify event was not
+// recorded before since, the time fsnotify reported the change, allowing for
+// attributionSkew. An earlier attribution belongs to a previous change, and if
+// the fanotify event was not received yet the change is left unattributed.
+func (f *fanotifyWatcher) lookup(path string, since time.Time) *processInfo {
+	f.Lock()
+	a, ok := f.recent[path]
+	f.Unlock()
+	if !ok || a.seen.Before(since.Add(-attributionSkew)) {
+		return nil
+	}
+	return &a.process
+}
@@ -0,0 +1,8 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+// open_by_handle_at is not defined in the syscall package on amd64
+const sysOpenByHandleAt = 304
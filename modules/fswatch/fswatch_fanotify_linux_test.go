@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build amd64 arm64
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFanotifyAttribution(t *testing.T) {
+	logChan = make(chan string, 64)
+	f, err := newFanotifyWatcher()
+	if err != nil {
+		t.Skipf("fanotify not available: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "fswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = f.mark(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// lookup does not wait for the fanotify event, retry until it is read
+	check := func(path string, since time.Time) {
+		var p *processInfo
+		for i := 0; i < 100 && p == nil; i++ {
+			p = f.lookup(path, since)
+			time.Sleep(10 * time.Millisecond)
+		}
+		if p == nil {
+			t.Fatalf("change to %v was not attributed", path)
+		}
+		if p.Pid != os.Getpid() || p.Executable != exe || p.Uid != os.Getuid() {
+			t.Fatalf("unexpected attribution %+v for %v", p, path)
+		}
+	}
+
+	created := filepath.Join(dir, "created")
+	since := time.Now()
+	err = ioutil.WriteFile(created, []byte("test"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(created, since)
+	if f.lookup(created, time.Now().Add(time.Second)) != nil {
+		t.Fatal("attribution of a previous change was returned")
+	}
+
+	removed := filepath.Join(dir, "removed")
+	err = os.Mkdir(removed, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Lock()
+	delete(f.recent, removed)
+	f.Unlock()
+	since = time.Now()
+	err = os.Remove(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(removed, since)
+
+	if f.lookup(filepath.Join(dir, "unchanged"), since) != nil {
+		t.Fatal("unchanged file was attributed")
+	}
+}
+
+func TestFanotifyLookup(t *testing.T) {
+	now := time.Now()
+	f := &fanotifyWatcher{recent: map[string]attribution{
+		"/etc/passwd": {process: processInfo{Pid: 100}, seen: now},
+	}}
+	var tests = []struct {
+		Description string
+		Path        string
+		Since       time.Time
+		Expect      bool
+	}{
+		{`fanotify event recorded after the fsnotify event`, "/etc/passwd", now.Add(-time.Second), true},
+		{`fanotify event recorded slightly before the fsnotify event`, "/etc/passwd", now.Add(attributionSkew / 2), true},
+		{`attribution of a previous change`, "/etc/passwd", now.Add(2 * attributionSkew), false},
+		{`path without attribution`, "/etc/shadow", now, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Description, func(t *testing.T) {
+			p := f.lookup(tc.Path, tc.Since)
+			if (p != nil) != tc.Expect {
+				t.Fatalf("expected attribution %v, got %+v", tc.Expect, p)
+			}
+			if p != nil && p.Pid != 100 {
+				t.Fatalf("unexpected attribution %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux linux,!amd64,!arm64
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+import (
+	"fmt"
+	"time"
+)
+
+// fanotify is only used on linux, other platforms always fall back to
+// fsnotify without process attribution
+type fanotifyWatcher struct{}
+
+func newFanotifyWatcher() (*fanotifyWatcher, error) {
+	return nil, fmt.Errorf("fanotify is not supported on this platform")
+}
+
+func (f *fanotifyWatcher) mark(path string) error {
+	return nil
+}
+
+func (f *fanotifyWatcher) unmark(path string) {
+}
+
+func (f *fanotifyWatcher) lookup(path string, since time.Time) *processInfo {
+	return nil
+}
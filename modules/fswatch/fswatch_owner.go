@@ -0,0 +1,22 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Return the owner of a file as uid:gid
+func fileOwner(finfo os.FileInfo) string {
+	st, ok := finfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", st.Uid, st.Gid)
+}
@@ -7,5 +7,5 @@
 package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
 
 var localFsWatchProfile = profile{
-	entries: []profileEntry{},
+	entries: []*profileEntry{},
 }
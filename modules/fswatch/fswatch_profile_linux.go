@@ -7,7 +7,7 @@
 package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
 
 var localFsWatchProfile = profile{
-	entries: []profileEntry{
+	entries: []*profileEntry{
 		{path: "/boot", recursive: false},
 		{path: "/etc/cron.d", recursive: false},
 		{path: "/var/spool/cron", recursive: false},
@@ -7,10 +7,277 @@
 package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
 
 import (
-	"github.com/mozilla/mig/testutil"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/mozilla/mig/testutil"
+	"gopkg.in/gcfg.v1"
 )
 
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "fswatch")
 }
+
+func TestProfileConfig(t *testing.T) {
+	sum := sha256.Sum256([]byte("PermitRootLogin no\n"))
+	cfgdata := fmt.Sprintf(`
+[profile "ssh"]
+	path = /etc/ssh
+	exclude = *.pub
+	event = modify
+	event = remove
+	severity = high
+	suppress = 5m
+	diff = true
+	baseline = %x /etc/ssh/sshd_config
+[profile "web"]
+	path = /var/www
+	recursive = true
+	include = *.php
+	exclude = /var/www/cache
+`, sum)
+	var cfg config
+	err := gcfg.ReadStringInto(&cfg, cfgdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, rules, err := buildProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || len(p.entries) != 2 {
+		t.Fatalf("expected 2 rules and 2 entries, got %d and %d", len(rules), len(p.entries))
+	}
+	ssh := rules[0]
+	if ssh.name != "ssh" || ssh.severity != ALERT_HIGH || ssh.suppress.Minutes() != 5 || !ssh.diff {
+		t.Fatalf("unexpected ssh rule %+v", ssh)
+	}
+	if ssh.events[eventCreate] || !ssh.events[eventModify] || !ssh.events[eventRemove] {
+		t.Fatalf("unexpected ssh events %v", ssh.events)
+	}
+	if ssh.baseline["/etc/ssh/sshd_config"].Status != baselinePending {
+		t.Fatalf("sshd_config baseline not found")
+	}
+	if !p.entries[1].recursive || p.entries[1].rule.name != "web" {
+		t.Fatalf("unexpected web entry %+v", p.entries[1])
+	}
+}
+
+func TestNewRule(t *testing.T) {
+	var tests = []struct {
+		pc    profileConfig
+		valid bool
+	}{
+		{profileConfig{Path: []string{"/etc"}}, true},
+		{profileConfig{}, false},
+		{profileConfig{Path: []string{"etc"}}, false},
+		{profileConfig{Path: []string{"/etc"}, Include: []string{"[a"}}, false},
+		{profileConfig{Path: []string{"/etc"}, Event: []string{"delete"}}, false},
+		{profileConfig{Path: []string{"/etc"}, Severity: "urgent"}, false},
+		{profileConfig{Path: []string{"/etc"}, Suppress: "15"}, false},
+		{profileConfig{Path: []string{"/etc"}, Baseline: []string{"abcd /etc/passwd"}}, false},
+		{profileConfig{Path: []string{"/etc"},
+			Baseline: []string{fmt.Sprintf("%x /etc/ssh/sshd_config", sha256.Sum256(nil))}}, false},
+		{profileConfig{Path: []string{"/etc"}, Recursive: true,
+			Baseline: []string{fmt.Sprintf("%x /etc/ssh/sshd_config", sha256.Sum256(nil))}}, true},
+		{profileConfig{Path: []string{"/etc"}, Recursive: true, Exclude: []string{"ssh*"},
+			Baseline: []string{fmt.Sprintf("%x /etc/ssh/sshd_config", sha256.Sum256(nil))}}, false},
+	}
+	for i, tt := range tests {
+		_, err := newRule("test", &tt.pc)
+		if tt.valid && err != nil {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%d: invalid profile was accepted", i)
+		}
+	}
+}
+
+func TestRuleTracks(t *testing.T) {
+	r := newDefaultRule()
+	r.include = []string{"*.php", "/var/www/.htaccess"}
+	r.exclude = []string{"/var/www/cache/*", "test_*"}
+	var tests = []struct {
+		path   string
+		expect bool
+	}{
+		{"/var/www/index.php", true},
+		{"/var/www/index.html", false},
+		{"/var/www/.htaccess", true},
+		{"/var/www/app/.htaccess", false},
+		{"/var/www/cache/x.php", false},
+		{"/var/www/test_x.php", false},
+	}
+	for _, tt := range tests {
+		if r.tracks(tt.path) != tt.expect {
+			t.Errorf("tracks(%v) should be %v", tt.path, tt.expect)
+		}
+	}
+}
+
+func TestCountLineChanges(t *testing.T) {
+	added, removed := countLineChanges([]byte("a\nb\nc\n"), []byte("a\nc\nb\nd\ne\n"))
+	if added != 2 || removed != 0 {
+		t.Fatalf("expected 2 lines added and 0 removed, got %d and %d", added, removed)
+	}
+	added, removed = countLineChanges([]byte("a\nb\nb\n"), []byte("b\nx\n"))
+	if added != 1 || removed != 2 {
+		t.Fatalf("expected 1 line added and 2 removed, got %d and %d", added, removed)
+	}
+}
+
+func TestBaselineAlert(t *testing.T) {
+	logChan = make(chan string, 64)
+	alertChan = make(chan Alert, 16)
+	if fshasher.inQueue == nil {
+		fshasher.initialize()
+	}
+	dir, err := ioutil.TempDir("", "fswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "sshd_config")
+	err = ioutil.WriteFile(fpath, []byte("PermitRootLogin no\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := sha256.Sum256([]byte("PermitRootLogin no\n"))
+	r, err := newRule("ssh", &profileConfig{
+		Path:     []string{dir},
+		Severity: "high",
+		Suppress: "0s",
+		Diff:     true,
+		Baseline: []string{fmt.Sprintf("%x %v", expected, fpath)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setActiveRules([]*watchRule{r})
+	pe := profileEntry{path: dir, rule: r, objects: []object{{path: fpath, objtype: TYPE_FILE}}}
+
+	// The initial hash matches the baseline and does not alert
+	pe.updateObjectHash(fpath, fshasher.hash(fpath, r.diff), "", nil)
+	if len(alertChan) != 0 {
+		t.Fatalf("unexpected alert %v", <-alertChan)
+	}
+	b := getBaseline()
+	if len(b) != 1 || b[0].Status != baselineOK {
+		t.Fatalf("unexpected baseline state %+v", b)
+	}
+
+	err = ioutil.WriteFile(fpath, []byte("PermitRootLogin yes\nPasswordAuthentication yes\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe.updateObjectHash(fpath, fshasher.hash(fpath, r.diff), eventModify, nil)
+	if len(alertChan) != 1 {
+		t.Fatalf("expected an alert, got %d", len(alertChan))
+	}
+	a := <-alertChan
+	if a.Severity != "high" || a.Event != eventModify || a.Profile != "ssh" || a.Path != fpath {
+		t.Fatalf("unexpected alert %v", a)
+	}
+	if a.OldSHA256 != fmt.Sprintf("%x", expected) || a.BaselineSHA256 != a.OldSHA256 ||
+		a.NewSHA256 == a.OldSHA256 {
+		t.Fatalf("unexpected hashes in alert %v", a)
+	}
+	if a.Diff == nil || a.Diff.OldSize != 19 || a.Diff.NewSize != 47 || *a.Diff.LinesAdded != 2 ||
+		*a.Diff.LinesRemoved != 1 {
+		t.Fatalf("unexpected diff in alert %v", a)
+	}
+	b = getBaseline()
+	if b[0].Status != baselineModified || b[0].Current != a.NewSHA256 {
+		t.Fatalf("unexpected baseline state %+v", b)
+	}
+}
+
+func TestRemovedStateExpiry(t *testing.T) {
+	sum := sha256.Sum256(nil)
+	r, err := newRule("test", &profileConfig{
+		Path:     []string{"/etc"},
+		Baseline: []string{fmt.Sprintf("%x /etc/passwd", sum)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fileState{hash: sum[:]}
+	for _, p := range []string{"/etc/passwd", "/etc/a", "/etc/b", "/etc/c"} {
+		r.update(p, st)
+	}
+	// removed files are kept until they expire, unless they were created again
+	r.removed("/etc/a")
+	r.removed("/etc/b")
+	r.update("/etc/b", st)
+	if len(r.known) != 4 || r.known["/etc/a"].removed.IsZero() || !r.known["/etc/b"].removed.IsZero() {
+		t.Fatalf("unexpected known files %v", r.known)
+	}
+	r.gone[0].ts = r.gone[0].ts.Add(-2 * removedStateTTL)
+	r.known["/etc/a"] = fileState{hash: sum[:], removed: r.gone[0].ts}
+	r.gone[1].ts = r.gone[1].ts.Add(-2 * removedStateTTL)
+	r.removed("/etc/c")
+	if _, ok := r.known["/etc/a"]; ok || len(r.known) != 3 || len(r.gone) != 1 {
+		t.Fatalf("expected /etc/a to expire, got %v", r.known)
+	}
+	// files with an expected hash are never forgotten
+	r.removed("/etc/passwd")
+	for i := 0; i <= removedStateMax; i++ {
+		p := fmt.Sprintf("/etc/tmp%d", i)
+		r.update(p, st)
+		r.removed(p)
+	}
+	if len(r.known) != removedStateMax+2 || len(r.gone) != removedStateMax {
+		t.Fatalf("expected %d known files and %d removed, got %d and %d", removedStateMax+2,
+			removedStateMax, len(r.known), len(r.gone))
+	}
+	if _, ok := r.known["/etc/passwd"]; !ok {
+		t.Fatalf("/etc/passwd should be known")
+	}
+}
+
+func TestWatcherFallback(t *testing.T) {
+	logChan = make(chan string, 64)
+	dir, err := ioutil.TempDir("", "fswatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Whether or not fanotify is available, the watcher must initialize and
+	// monitor paths using fsnotify
+	var w fsWatcher
+	err = w.initialize(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.watcher.Close()
+	if w.fanotify == nil {
+		t.Logf("fanotify not available: %v", <-logChan)
+	}
+	err = w.addMonitor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, "testfile")
+	err = ioutil.WriteFile(fpath, []byte("test"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-w.watcher.Events:
+		if ev.Name != fpath {
+			t.Fatalf("unexpected event %v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received for created file")
+	}
+	err = w.removeMonitor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,476 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fswatch /* import "github.com/mozilla/mig/modules/fswatch" */
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profileConfig is a watch profile defined in the configuration of the
+// module, in a [profile "name"] section
+type profileConfig struct {
+	Path      []string // Paths monitored by the profile
+	Recursive bool     // Monitor the subdirectories of the paths
+	Include   []string // Globs of the files to track, all files if empty
+	Exclude   []string // Globs of the files and directories to ignore
+	Event     []string // Events that generate alerts: create, modify, remove, chmod
+	Severity  string   // Severity of the alerts: critical, high, medium or low
+	Suppress  string   // Alert suppression window per file, as a duration
+	Diff      bool     // Count the lines added and removed by modifications
+	Baseline  []string // Expected hashes of files, as "<sha256> <path>"
+}
+
+// Events that can generate alerts in a profile
+const (
+	eventCreate = "create"
+	eventModify = "modify"
+	eventRemove = "remove"
+	eventChmod  = "chmod"
+)
+
+// Files up to this size have their content kept in memory to count the lines
+// modified when the diff option of their profile is set
+const diffMaxSize = 64 * 1024
+
+// The last known state of a removed file is forgotten once it has been removed
+// for this long, or when more files than this have been removed since, unless
+// the file has an expected hash
+const (
+	removedStateTTL = time.Hour
+	removedStateMax = 1024
+)
+
+// Baseline status of a file
+const (
+	baselinePending  = "pending"  // the file has not been hashed yet
+	baselineOK       = "ok"       // the file matches its expected hash
+	baselineModified = "modified" // the file does not match its expected hash
+	baselineMissing  = "missing"  // the file does not exist
+)
+
+// baselineState is the state of a file that has an expected hash, returned by
+// queries of the module
+type baselineState struct {
+	Profile    string    `json:"profile"`
+	Path       string    `json:"path"`
+	Expected   string    `json:"expected"`
+	Current    string    `json:"current,omitempty"`
+	Status     string    `json:"status"`
+	LastChange time.Time `json:"lastchange,omitempty"`
+}
+
+// fileState is the last known state of a file
+type fileState struct {
+	hash    []byte
+	meta    fileMeta
+	content []byte
+	removed time.Time // Time the file was removed, zero if it exists
+}
+
+// removedFile is a file whose last known state is kept after its removal
+type removedFile struct {
+	path string
+	ts   time.Time
+}
+
+// watchRule describes how the files monitored by the entries of a profile are
+// filtered and alerted on
+type watchRule struct {
+	name           string
+	include        []string
+	exclude        []string
+	events         map[string]bool
+	severity       int
+	removeSeverity int
+	suppress       time.Duration
+	diff           bool
+
+	sync.Mutex                           // Protects known, gone and baseline
+	known      map[string]fileState      // Last known state of the files, kept for a while when they are removed
+	gone       []removedFile             // Files removed from known once they expire, oldest first
+	baseline   map[string]*baselineState // Expected hashes of files
+	expected   map[string][]byte
+}
+
+// newDefaultRule returns the rule of the paths of the legacy configuration
+// and of the default profiles: all files are tracked, modifications generate
+// critical alerts and removals low alerts
+func newDefaultRule() *watchRule {
+	return &watchRule{
+		name:           "default",
+		events:         map[string]bool{eventModify: true, eventRemove: true},
+		severity:       ALERT_CRITICAL,
+		removeSeverity: ALERT_LOW,
+		suppress:       alertSuppressWindow,
+		known:          make(map[string]fileState),
+		baseline:       make(map[string]*baselineState),
+		expected:       make(map[string][]byte),
+	}
+}
+
+// parseSeverity converts a severity name to an alert severity
+func parseSeverity(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "", "critical":
+		return ALERT_CRITICAL, nil
+	case "high":
+		return ALERT_HIGH, nil
+	case "medium":
+		return ALERT_MEDIUM, nil
+	case "low":
+		return ALERT_LOW, nil
+	}
+	return 0, fmt.Errorf("invalid severity %q", s)
+}
+
+// newRule validates a profile of the configuration and returns its rule
+func newRule(name string, pc *profileConfig) (r *watchRule, err error) {
+	r = newDefaultRule()
+	r.name = name
+	r.include = pc.Include
+	r.exclude = pc.Exclude
+	r.diff = pc.Diff
+	if len(pc.Path) == 0 {
+		return nil, fmt.Errorf("profile %s: no path to monitor", name)
+	}
+	for _, p := range pc.Path {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("profile %s: path %q is not absolute", name, p)
+		}
+	}
+	for _, g := range append(append([]string{}, pc.Include...), pc.Exclude...) {
+		_, err = filepath.Match(g, "")
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: invalid glob %q: %v", name, g, err)
+		}
+	}
+	if len(pc.Event) > 0 {
+		r.events = make(map[string]bool)
+		for _, ev := range pc.Event {
+			switch ev {
+			case eventCreate, eventModify, eventRemove, eventChmod:
+				r.events[ev] = true
+			default:
+				return nil, fmt.Errorf("profile %s: invalid event %q", name, ev)
+			}
+		}
+	}
+	r.severity, err = parseSeverity(pc.Severity)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %v", name, err)
+	}
+	r.removeSeverity = r.severity
+	if pc.Suppress != "" {
+		r.suppress, err = time.ParseDuration(pc.Suppress)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: invalid suppress window: %v", name, err)
+		}
+	}
+	for _, b := range pc.Baseline {
+		b = strings.TrimSpace(b)
+		i := strings.IndexAny(b, " \t")
+		if i == -1 {
+			return nil, fmt.Errorf("profile %s: baseline %q must be in the form '<sha256> <path>'", name, b)
+		}
+		h, err := hex.DecodeString(b[:i])
+		if err != nil || len(h) != 32 {
+			return nil, fmt.Errorf("profile %s: invalid sha256 in baseline %q", name, b)
+		}
+		p := filepath.Clean(strings.TrimSpace(b[i:]))
+		if !r.covers(pc, p) {
+			return nil, fmt.Errorf("profile %s: baseline path %s is not monitored by the profile", name, p)
+		}
+		r.expected[p] = h
+		r.baseline[p] = &baselineState{
+			Profile:  name,
+			Path:     p,
+			Expected: hex.EncodeToString(h),
+			Status:   baselinePending,
+		}
+	}
+	return r, nil
+}
+
+// covers returns true if a file is tracked by the paths of a profile
+func (r *watchRule) covers(pc *profileConfig, p string) bool {
+	for _, root := range pc.Path {
+		root = filepath.Clean(root)
+		if p == root {
+			return true
+		}
+		if pc.Recursive && strings.HasPrefix(p, root+string(filepath.Separator)) {
+			return r.tracks(p)
+		}
+		if filepath.Dir(p) == root {
+			return r.tracks(p)
+		}
+	}
+	return false
+}
+
+// globMatch matches a path against globs. Globs that contain a path separator
+// are matched against the full path, and others against the base name.
+func globMatch(globs []string, p string) bool {
+	for _, g := range globs {
+		target := filepath.Base(p)
+		if strings.ContainsRune(g, filepath.Separator) {
+			target = p
+		}
+		if ok, _ := filepath.Match(g, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tracks returns true if the include and exclude globs of the rule select
+// a file
+func (r *watchRule) tracks(p string) bool {
+	if len(r.include) > 0 && !globMatch(r.include, p) {
+		return false
+	}
+	return !globMatch(r.exclude, p)
+}
+
+// excludes returns true if a directory is excluded from monitoring
+func (r *watchRule) excludes(p string) bool {
+	return globMatch(r.exclude, p)
+}
+
+// lastKnown returns the last known state of a file, which is kept when the
+// file is removed so that it can be compared with the file that replaces it
+func (r *watchRule) lastKnown(p string) fileState {
+	r.Lock()
+	defer r.Unlock()
+	return r.known[p]
+}
+
+// update records the new state of a file, and updates its baseline status. It
+// returns the expected hash of the file, if it has one.
+func (r *watchRule) update(p string, st fileState) (expected []byte) {
+	r.Lock()
+	defer r.Unlock()
+	r.known[p] = st
+	b, ok := r.baseline[p]
+	if !ok {
+		return nil
+	}
+	status := baselineModified
+	if bytes.Equal(st.hash, r.expected[p]) {
+		status = baselineOK
+	}
+	cur := hex.EncodeToString(st.hash)
+	if b.Current != cur || b.Status != status {
+		b.Current = cur
+		b.Status = status
+		b.LastChange = time.Now()
+	}
+	return r.expected[p]
+}
+
+// removed marks a file with an expected hash as missing, and returns true if
+// it has an expected hash. The last known state of other files is kept until
+// it expires.
+func (r *watchRule) removed(p string) bool {
+	r.Lock()
+	defer r.Unlock()
+	b, ok := r.baseline[p]
+	if !ok {
+		if st, ok := r.known[p]; ok {
+			st.removed = time.Now()
+			r.known[p] = st
+			r.gone = append(r.gone, removedFile{path: p, ts: st.removed})
+		}
+		r.expire()
+		return false
+	}
+	if b.Status != baselineMissing {
+		b.Current = ""
+		b.Status = baselineMissing
+		b.LastChange = time.Now()
+	}
+	return true
+}
+
+// expire forgets the last known state of the files that were removed before
+// removedStateTTL, or that exceed removedStateMax. Files that were created
+// again since their removal are kept. It must be called with the lock held.
+func (r *watchRule) expire() {
+	cutoff := time.Now().Add(-removedStateTTL)
+	for len(r.gone) > 0 && (len(r.gone) > removedStateMax || r.gone[0].ts.Before(cutoff)) {
+		g := r.gone[0]
+		r.gone = r.gone[1:]
+		if st, ok := r.known[g.path]; ok && st.removed.Equal(g.ts) {
+			delete(r.known, g.path)
+		}
+	}
+}
+
+// checkMissing alerts on the files with an expected hash that do not exist
+// when monitoring starts
+func (r *watchRule) checkMissing() {
+	for p := range r.expected {
+		if _, err := os.Lstat(p); err == nil {
+			continue
+		}
+		r.removed(p)
+		newFileAlert(r.severity, Alert{Profile: r.name, Event: eventRemove, Path: p,
+			BaselineSHA256: hex.EncodeToString(r.expected[p])},
+			"%v is missing, expected sha256 %x", p, r.expected[p])
+	}
+}
+
+// baselineStates returns the baseline state of the files of the rule
+func (r *watchRule) baselineStates() (ret []baselineState) {
+	r.Lock()
+	defer r.Unlock()
+	for _, b := range r.baseline {
+		ret = append(ret, *b)
+	}
+	return
+}
+
+// Rules of the profiles being monitored, used to answer queries
+var (
+	activeRules     []*watchRule
+	activeRulesLock sync.Mutex
+)
+
+func setActiveRules(rules []*watchRule) {
+	activeRulesLock.Lock()
+	defer activeRulesLock.Unlock()
+	activeRules = rules
+}
+
+// getBaseline returns the baseline state of the files of all the profiles
+// being monitored, sorted by profile and path
+func getBaseline() (ret []baselineState) {
+	activeRulesLock.Lock()
+	defer activeRulesLock.Unlock()
+	for _, r := range activeRules {
+		ret = append(ret, r.baselineStates()...)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Profile != ret[j].Profile {
+			return ret[i].Profile < ret[j].Profile
+		}
+		return ret[i].Path < ret[j].Path
+	})
+	return
+}
+
+// buildProfile returns the monitoring profile described by the configuration.
+// Configured profiles are monitored first, followed by the legacy paths. The
+// default profile of the platform is used if the configuration is empty.
+func buildProfile(cfg config) (p profile, rules []*watchRule, err error) {
+	var names []string
+	for name := range cfg.Profile {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pc := cfg.Profile[name]
+		r, err := newRule(name, pc)
+		if err != nil {
+			return p, nil, err
+		}
+		rules = append(rules, r)
+		for _, x := range pc.Path {
+			p.entries = append(p.entries, &profileEntry{
+				path:      filepath.Clean(x),
+				recursive: pc.Recursive,
+				rule:      r,
+			})
+		}
+	}
+	if len(cfg.Paths.Path) == 0 && len(p.entries) > 0 {
+		return p, rules, nil
+	}
+	def := newDefaultRule()
+	rules = append(rules, def)
+	if len(cfg.Paths.Path) == 0 {
+		for _, x := range localFsWatchProfile.entries {
+			p.entries = append(p.entries, &profileEntry{path: x.path, recursive: x.recursive, rule: def})
+		}
+		return p, rules, nil
+	}
+	// Construct a profile to use based on the paths in the configuration
+	for _, x := range cfg.Paths.Path {
+		pent := &profileEntry{path: x, rule: def}
+		if strings.HasPrefix(x, "recursive:") {
+			pent.path = x[10:]
+			pent.recursive = true
+		}
+		p.entries = append(p.entries, pent)
+	}
+	return p, rules, nil
+}
+
+// fileDiff describes how the metadata and content of a file changed
+type fileDiff struct {
+	OldSize      int64     `json:"oldsize"`
+	NewSize      int64     `json:"newsize"`
+	OldMode      string    `json:"oldmode"`
+	NewMode      string    `json:"newmode"`
+	OldOwner     string    `json:"oldowner,omitempty"`
+	NewOwner     string    `json:"newowner,omitempty"`
+	OldMtime     time.Time `json:"oldmtime"`
+	NewMtime     time.Time `json:"newmtime"`
+	LinesAdded   *int      `json:"linesadded,omitempty"`
+	LinesRemoved *int      `json:"linesremoved,omitempty"`
+}
+
+// newFileDiff compares two states of a file, it returns nil if the previous
+// state is not known
+func newFileDiff(old, cur fileState) *fileDiff {
+	if old.meta.mtime.IsZero() {
+		return nil
+	}
+	d := &fileDiff{
+		OldSize:  old.meta.size,
+		NewSize:  cur.meta.size,
+		OldMode:  old.meta.mode.String(),
+		NewMode:  cur.meta.mode.String(),
+		OldOwner: old.meta.owner,
+		NewOwner: cur.meta.owner,
+		OldMtime: old.meta.mtime,
+		NewMtime: cur.meta.mtime,
+	}
+	if old.content != nil && cur.content != nil {
+		added, removed := countLineChanges(old.content, cur.content)
+		d.LinesAdded = &added
+		d.LinesRemoved = &removed
+	}
+	return d
+}
+
+// countLineChanges returns the number of lines of cur that are not in old, and
+// the number of lines of old that are not in cur. Lines that only moved are
+// not counted.
+func countLineChanges(old, cur []byte) (added, removed int) {
+	lines := make(map[string]int)
+	for _, l := range bytes.Split(old, []byte("\n")) {
+		lines[string(l)]++
+	}
+	for _, l := range bytes.Split(cur, []byte("\n")) {
+		if lines[string(l)] > 0 {
+			lines[string(l)]--
+			continue
+		}
+		added++
+	}
+	for _, n := range lines {
+		removed += n
+	}
+	return
+}
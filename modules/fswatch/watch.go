@@ -16,7 +16,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -31,14 +30,26 @@ var alertSuppressWindow = 15 * time.Minute
 
 // Interfaces with fsnotify to monitor the file system
 type fsWatcher struct {
-	watcher *fsnotify.Watcher
+	watcher  *fsnotify.Watcher
+	fanotify *fanotifyWatcher // Attributes changes to processes, nil if not in use
 
 	sync.Mutex // This lock should be picked up before making any changes to watcher
 }
 
-// Initialize fsWatcher f
-func (f *fsWatcher) initialize() (err error) {
+// Initialize fsWatcher f; if useFanotify is set and fanotify is available,
+// changes will also be attributed to the processes that made them
+func (f *fsWatcher) initialize(useFanotify bool) (err error) {
 	f.watcher, err = fsnotify.NewWatcher()
+	if err != nil || !useFanotify {
+		return
+	}
+	f.fanotify, err = newFanotifyWatcher()
+	if err != nil {
+		logChan <- fmt.Sprintf("fanotify unavailable, changes will not be attributed to processes: %v", err)
+		f.fanotify = nil
+		return nil
+	}
+	logChan <- "using fanotify to attribute changes to processes"
 	return
 }
 
@@ -46,22 +57,57 @@ func (f *fsWatcher) initialize() (err error) {
 func (f *fsWatcher) addMonitor(path string) error {
 	f.Lock()
 	defer f.Unlock()
-	return f.watcher.Add(path)
+	err := f.watcher.Add(path)
+	if err == nil && f.fanotify != nil {
+		ferr := f.fanotify.mark(path)
+		if ferr != nil {
+			logChan <- fmt.Sprintf("changes to %v will not be attributed to processes: %v", path, ferr)
+		}
+	}
+	return err
 }
 
 // Remove path from fsWatcher f
 func (f *fsWatcher) removeMonitor(path string) error {
 	f.Lock()
 	defer f.Unlock()
+	if f.fanotify != nil {
+		f.fanotify.unmark(path)
+	}
 	return f.watcher.Remove(path)
 }
 
+// Return the process that changed path in the event fsnotify reported at
+// evtime, or nil if it is not known
+func (f *fsWatcher) process(path string, evtime time.Time) *processInfo {
+	if f.fanotify == nil {
+		return nil
+	}
+	return f.fanotify.lookup(path, evtime)
+}
+
 var watcher fsWatcher
 
 // Used by hasher, describes a request to hash a file
 type hashRequest struct {
-	outChan chan []byte // Response will be sent on this channel
+	outChan chan hashResult // Response will be sent on this channel
 	path    string
+	content bool // Return the content of the file along with its hash
+}
+
+// Reply of the hasher to a hash request
+type hashResult struct {
+	hash    []byte   // SHA256 of the file, empty if it could not be read
+	meta    fileMeta // Metadata of the file
+	content []byte   // Content of the file if requested and small enough
+}
+
+// Metadata of a hashed file
+type fileMeta struct {
+	size  int64
+	mode  os.FileMode
+	owner string
+	mtime time.Time
 }
 
 // hasher aggregates hash requests and throttles file system access
@@ -74,7 +120,7 @@ var fshasher hasher
 // Respond to a new hash request, calculates SHA256 and replies on indicated
 // channel
 func (h *hasher) respond(nr hashRequest) {
-	var ret []byte
+	var ret hashResult
 	if debugFSWatch {
 		logChan <- fmt.Sprintf("hashing %v", nr.path)
 	}
@@ -84,7 +130,24 @@ func (h *hasher) respond(nr hashRequest) {
 		return
 	}
 	defer fd.Close()
+	finfo, err := fd.Stat()
+	if err != nil {
+		nr.outChan <- ret
+		return
+	}
+	meta := fileMeta{
+		size:  finfo.Size(),
+		mode:  finfo.Mode(),
+		owner: fileOwner(finfo),
+		mtime: finfo.ModTime(),
+	}
 	hash := sha256.New()
+	var w io.Writer = hash
+	var content bytes.Buffer
+	keep := nr.content && meta.size <= diffMaxSize
+	if keep {
+		w = io.MultiWriter(hash, &content)
+	}
 	buf := make([]byte, 4096)
 	for {
 		n, err := fd.Read(buf)
@@ -96,13 +159,28 @@ func (h *hasher) respond(nr hashRequest) {
 			return
 		}
 		if n > 0 {
-			hash.Write(buf[:n])
+			w.Write(buf[:n])
 		}
 	}
-	ret = hash.Sum(nil)
+	ret.hash = hash.Sum(nil)
+	ret.meta = meta
+	if keep && content.Len() <= diffMaxSize {
+		ret.content = content.Bytes()
+	}
 	nr.outChan <- ret
 }
 
+// Send a hash request for path to the hasher and wait for the reply
+func (h *hasher) hash(path string, content bool) hashResult {
+	nr := hashRequest{
+		path:    path,
+		content: content,
+		outChan: make(chan hashResult, 1),
+	}
+	h.inQueue <- nr
+	return <-nr.outChan
+}
+
 // Spawns new hasher, listens on input queue and replies to requests
 func (h *hasher) initialize() {
 	var (
@@ -130,7 +208,7 @@ func (h *hasher) initialize() {
 // A monitoring profile, contains a list of profile entries where each entry
 // describes a monitoring path on the file system
 type profile struct {
-	entries []profileEntry
+	entries []*profileEntry
 }
 
 // Initialize the monitoring profile, prepares each entry in the profile
@@ -172,6 +250,7 @@ func (p *profile) routeEvent(ev fsnotify.Event) {
 type profileEntry struct {
 	path      string
 	recursive bool
+	rule      *watchRule // Filters and alerting of the profile the entry belongs to
 
 	events  chan fsnotify.Event
 	objects []object
@@ -262,6 +341,9 @@ func (pe *profileEntry) processDirectory() (err error) {
 		if !finfo.Mode().IsDir() {
 			return nil
 		}
+		if p != pe.path && pe.rule.excludes(p) {
+			return filepath.SkipDir
+		}
 		paths = append(paths, p)
 		return nil
 	}
@@ -278,9 +360,12 @@ func (pe *profileEntry) processDirectory() (err error) {
 	return
 }
 
-// Update the current hash for an object in the profile entry; ensure the entry
-// lock has been acquired before calling this function
-func (pe *profileEntry) updateObjectHash(path string, newh []byte) error {
+// Update the current hash for an object in the profile entry, and alert if
+// the file changed or does not match its baseline; ensure the entry lock has
+// been acquired before calling this function. ev is the event that caused the
+// file to be hashed, it is empty for the initial hashing of the entry, and
+// proc is the process that made the change, if it is known.
+func (pe *profileEntry) updateObjectHash(path string, res hashResult, ev string, proc *processInfo) error {
 	var (
 		objidx int
 		found  bool
@@ -296,19 +381,56 @@ func (pe *profileEntry) updateObjectHash(path string, newh []byte) error {
 		logChan <- fmt.Sprintf("warning: received hash reply for untracked object %v", path)
 		return nil
 	}
-	pe.objects[objidx].previous = pe.objects[objidx].current
-	pe.objects[objidx].current = newh
+	obj := &pe.objects[objidx]
+	if len(res.hash) == 0 {
+		// The file could not be read, it was likely removed before it
+		// was hashed
+		return nil
+	}
+	obj.previous = obj.current
+	obj.current = res.hash
 	if debugFSWatch {
-		logChan <- fmt.Sprintf("hash update: %v %x -> %x", path,
-			pe.objects[objidx].previous, pe.objects[objidx].current)
+		logChan <- fmt.Sprintf("hash update: %v %x -> %x", path, obj.previous, obj.current)
 	}
-	if len(pe.objects[objidx].previous) == 0 {
-		// No previous hash, nothing to do here
-		return nil
+	// The last known state of the file is kept by the rule, so that a file that
+	// is removed and recreated is compared with its previous version
+	r := pe.rule
+	old := r.lastKnown(path)
+	cur := fileState{hash: res.hash, meta: res.meta, content: res.content}
+	expected := r.update(path, cur)
+	a := Alert{
+		Profile:   r.name,
+		Path:      path,
+		OldSHA256: fmt.Sprintf("%x", old.hash),
+		NewSHA256: fmt.Sprintf("%x", res.hash),
+		Process:   proc,
 	}
-	// Compare our new hash to the previously generated one
-	if bytes.Compare(pe.objects[objidx].previous, pe.objects[objidx].current) != 0 {
-		pe.objects[objidx].alert()
+	if r.diff {
+		a.Diff = newFileDiff(old, cur)
+	}
+	switch {
+	case expected != nil && !bytes.Equal(res.hash, expected) && !bytes.Equal(res.hash, old.hash):
+		// A file that does not match its baseline always generates an alert
+		a.Event = eventModify
+		a.BaselineSHA256 = fmt.Sprintf("%x", expected)
+		obj.alert(r, r.severity, a, "%v signature changed %x -> %x, expected %x", path,
+			old.hash, res.hash, expected)
+	case len(old.hash) == 0:
+		if ev == eventCreate && r.events[eventCreate] {
+			a.Event = eventCreate
+			obj.alert(r, r.severity, a, "%v created with signature %x", path, res.hash)
+		}
+	case !bytes.Equal(old.hash, res.hash):
+		if r.events[eventModify] {
+			a.Event = eventModify
+			obj.alert(r, r.severity, a, "%v signature changed %x -> %x", path, old.hash, res.hash)
+		}
+	case old.meta.mode != res.meta.mode || old.meta.owner != res.meta.owner:
+		if r.events[eventChmod] {
+			a.Event = eventChmod
+			obj.alert(r, r.severity, a, "%v permissions changed %v %v -> %v %v", path,
+				old.meta.mode, old.meta.owner, res.meta.mode, res.meta.owner)
+		}
 	}
 	return nil
 }
@@ -354,28 +476,23 @@ func (pe *profileEntry) initialize() (err error) {
 		pe.Lock()
 		objcopy := pe.objects
 		for _, x := range objcopy {
-			var nr hashRequest
 			if x.objtype == TYPE_FILE {
-				nr.path = x.path
-				nr.outChan = make(chan []byte, 1)
-				fshasher.inQueue <- nr
-				nhash := <-nr.outChan
-				pe.updateObjectHash(x.path, nhash)
+				pe.updateObjectHash(x.path, fshasher.hash(x.path, pe.rule.diff), "", nil)
 			} else {
 				dirents, _ := ioutil.ReadDir(x.path)
 				for _, y := range dirents {
 					if !y.Mode().IsRegular() {
 						continue
 					}
-					nr.path = path.Join(x.path, y.Name())
+					p := path.Join(x.path, y.Name())
+					if !pe.rule.tracks(p) {
+						continue
+					}
 					// Add the identifier object to the object list, but we don't
 					// use addObject here as we don't require monitoring since it is
 					// covered by the directory monitoring
-					pe.objects = append(pe.objects, object{path: nr.path, objtype: TYPE_FILE})
-					nr.outChan = make(chan []byte, 1)
-					fshasher.inQueue <- nr
-					nhash := <-nr.outChan
-					pe.updateObjectHash(nr.path, nhash)
+					pe.objects = append(pe.objects, object{path: p, objtype: TYPE_FILE})
+					pe.updateObjectHash(p, fshasher.hash(p, pe.rule.diff), "", nil)
 				}
 			}
 		}
@@ -409,9 +526,10 @@ func (pe *profileEntry) initialize() (err error) {
 // function is responsible for updating the object list as needed, and creating
 // hash requests for observed changes
 func (pe *profileEntry) handleEvent(ev fsnotify.Event) error {
-	if ev.Op == fsnotify.Create && pe.recursive {
-		// If this is a create event, and recursive monitoring is set for the
-		// entry, determine if a directory was created and if so we will start
+	evtime := time.Now()
+	if ev.Op == fsnotify.Create {
+		// If this is a create event, determine if a directory was created and
+		// if so and recursive monitoring is set for the entry, we will start
 		// monitoring it.
 		finfo, err := os.Stat(ev.Name)
 		if err != nil {
@@ -421,41 +539,70 @@ func (pe *profileEntry) handleEvent(ev fsnotify.Event) error {
 			return nil
 		}
 		if finfo.Mode().IsDir() {
+			if !pe.recursive || pe.rule.excludes(ev.Name) {
+				return nil
+			}
 			return pe.addObject(ev.Name, TYPE_DIRECTORY)
 		} else if finfo.Mode().IsRegular() {
+			if !pe.rule.tracks(ev.Name) {
+				return nil
+			}
 			// If it was a regular file creation, add it as an untracked object
 			pe.Lock()
 			pe.objects = append(pe.objects, object{path: ev.Name, objtype: TYPE_FILE})
 			pe.Unlock()
 		}
-	} else if ev.Op == fsnotify.Remove {
-		// An object was removed, determine if it is in our object list and
-		// if so remove it there as well.
+	} else if ev.Op == fsnotify.Remove || ev.Op == fsnotify.Rename {
+		// An object was removed or renamed, determine if it is in our object
+		// list and if so remove it there as well.
 		if pe.hasObject(ev.Name) {
-			newAlert(ALERT_LOW, "path %v removed", ev.Name)
+			a := Alert{Profile: pe.rule.name, Event: eventRemove, Path: ev.Name}
+			sev, msg := 0, fmt.Sprintf("path %v removed", ev.Name)
+			if pe.rule.removed(ev.Name) {
+				a.BaselineSHA256 = fmt.Sprintf("%x", pe.rule.expected[ev.Name])
+				sev = pe.rule.severity
+				msg += ", expected sha256 " + a.BaselineSHA256
+			} else if pe.rule.events[eventRemove] {
+				sev = pe.rule.removeSeverity
+			}
+			if sev != 0 {
+				a.Process = watcher.process(ev.Name, evtime)
+				newFileAlert(sev, a, "%v", msg)
+			}
 			return pe.removeObject(ev.Name)
 		}
+		return nil
 	}
-	if ev.Op == fsnotify.Create || ev.Op == fsnotify.Write {
-		// A change occurred, we will create a new async request to hash
-		// the file
-		go func() {
-			// Introduce a randomized delay before we generate the hash request,
-			// intended to provide some time for any remaining writes to the object
-			// to complete
-			time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
-			rch := make(chan []byte, 1)
-			nr := hashRequest{
-				path:    ev.Name,
-				outChan: rch,
-			}
-			fshasher.inQueue <- nr
-			nhash := <-rch
-			pe.Lock()
-			pe.updateObjectHash(ev.Name, nhash)
-			pe.Unlock()
-		}()
+	var evname string
+	switch ev.Op {
+	case fsnotify.Create:
+		evname = eventCreate
+	case fsnotify.Write:
+		evname = eventModify
+	case fsnotify.Chmod:
+		if !pe.rule.events[eventChmod] {
+			return nil
+		}
+		evname = eventChmod
+	default:
+		return nil
 	}
+	if !pe.hasObject(ev.Name) {
+		return nil
+	}
+	// A change occurred, we will create a new async request to hash
+	// the file
+	go func() {
+		// Introduce a randomized delay before we generate the hash request,
+		// intended to provide some time for any remaining writes to the object
+		// to complete
+		time.Sleep(time.Duration(rand.Intn(10)) * time.Second)
+		res := fshasher.hash(ev.Name, pe.rule.diff)
+		proc := watcher.process(ev.Name, evtime)
+		pe.Lock()
+		pe.updateObjectHash(ev.Name, res, evname, proc)
+		pe.Unlock()
+	}()
 	return nil
 }
 
@@ -475,54 +622,45 @@ type object struct {
 	lastAlert time.Time // Last time we alerted for this object
 }
 
-func (o *object) alert() {
+// Generate alert a for the object, unless we already alerted for it within
+// the suppression window of rule r
+func (o *object) alert(r *watchRule, sev int, a Alert, f string, args ...interface{}) {
 	// Determine when we last alerted for this object; if it has been within
-	// alertSuppressWindow we dont generate one
+	// the suppression window we dont generate one
 	if !o.lastAlert.IsZero() {
-		if time.Now().Sub(o.lastAlert) <= alertSuppressWindow {
+		if time.Now().Sub(o.lastAlert) <= r.suppress {
 			if debugFSWatch {
 				logChan <- fmt.Sprintf("suppressed alert for %v", o.path)
 			}
 			return
 		}
 	}
-	newAlert(ALERT_CRITICAL, "%v signature changed %x -> %x", o.path,
-		o.previous, o.current)
+	newFileAlert(sev, a, f, args...)
 	o.lastAlert = time.Now()
 }
 
 // Main entry routine for file system monitor
 func fsWatch(cfg config) {
-	var (
-		err          error
-		localprofile profile
-	)
 	fshasher.initialize()
-	err = watcher.initialize()
+	err := watcher.initialize(cfg.Options.Fanotify)
 	if err != nil {
 		handlerErrChan <- err
 		return
 	}
-	if len(cfg.Paths.Path) == 0 {
-		localprofile = localFsWatchProfile
-	} else {
-		// Construct a profile to use based on the paths in the configuration
-		for _, x := range cfg.Paths.Path {
-			var pent profileEntry
-			if strings.HasPrefix(x, "recursive:") {
-				pent.path = x[10:]
-				pent.recursive = true
-			} else {
-				pent.path = x
-			}
-			localprofile.entries = append(localprofile.entries, pent)
-		}
+	localprofile, rules, err := buildProfile(cfg)
+	if err != nil {
+		handlerErrChan <- err
+		return
 	}
+	setActiveRules(rules)
 	err = localprofile.initialize()
 	if err != nil {
 		handlerErrChan <- err
 		return
 	}
+	for _, r := range rules {
+		r.checkMissing()
+	}
 	for {
 		select {
 		case ev, ok := <-watcher.watcher.Events:
@@ -29,6 +29,10 @@ import (
 
 var ModuleRunDir string
 
+// AgentCert and AgentKey contain the PEM encoded certificate and private key
+// the agent uses to connect to the relay
+var AgentCert, AgentKey []byte
+
 // Message defines the input messages received by modules.
 //
 // All messages will have Class and Parameters set. PersistSock is used in a case
@@ -616,6 +620,73 @@ type HasResultsPrinter interface {
 	PrintResults(Result, bool) ([]string, error)
 }
 
+// ResultRow is a flat representation of a single item found by a module, keyed
+// by column name. Values are strings, numbers or booleans.
+type ResultRow map[string]interface{}
+
+// HasResultsFlattener implements functions used by module to flatten their
+// results into rows that can be exported to tabular formats. If foundOnly is
+// set, only rows describing an actual match are returned.
+type HasResultsFlattener interface {
+	FlattenResults(Result, bool) ([]ResultRow, error)
+}
+
+// FlattenElements is the default flattener used for modules that do not implement
+// HasResultsFlattener. It returns a single row where nested objects of the elements
+// become dot separated columns, and arrays are stored as JSON strings.
+func FlattenElements(r Result) (rows []ResultRow, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("FlattenElements() -> %v", e)
+		}
+	}()
+	var el interface{}
+	err = r.GetElements(&el)
+	if err != nil {
+		panic(err)
+	}
+	row := make(ResultRow)
+	err = flattenValue(row, "", el)
+	if err != nil {
+		panic(err)
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	return
+}
+
+// flattenValue stores v in row under prefix, recursing into objects
+func flattenValue(row ResultRow, prefix string, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		for k, sub := range val {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			err := flattenValue(row, name, sub)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		buf, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		row[prefix] = string(buf)
+	default:
+		if prefix == "" {
+			prefix = "value"
+		}
+		row[prefix] = val
+	}
+	return nil
+}
+
 // GetElements reads the elements from a struct of results into the el interface
 func (r Result) GetElements(el interface{}) (err error) {
 	defer func() {
@@ -124,6 +124,39 @@ func TestGetElements(t *testing.T) {
 
 }
 
+func TestFlattenElements(t *testing.T) {
+	var r Result
+	r.Elements = map[string]interface{}{
+		"name":  "foo",
+		"count": 2,
+		"nested": map[string]interface{}{
+			"ok":   true,
+			"list": []string{"a", "b"},
+		},
+	}
+	rows, err := FlattenElements(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	expect := ResultRow{
+		"name":        "foo",
+		"count":       float64(2),
+		"nested.ok":   true,
+		"nested.list": `["a","b"]`,
+	}
+	if len(rows[0]) != len(expect) {
+		t.Fatalf("expected %d columns, got %d", len(expect), len(rows[0]))
+	}
+	for k, v := range expect {
+		if rows[0][k] != v {
+			t.Fatalf("column %s has value %v, expected %v", k, rows[0][k], v)
+		}
+	}
+}
+
 type statistics struct {
 	SomeCounter float64 `json:"somecounter"`
 }
@@ -45,6 +45,15 @@ type params struct {
 	ConnectedIP      []string `json:"connectedip,omitempty"`
 	ListeningPort    []string `json:"listeningport,omitempty"`
 	SearchNamespaces bool     `json:"namespaces,empty"`
+
+	// List returns the socket table of the endpoint, optionally filtered
+	// on the state of the sockets, the name of their process, their remote
+	// address and their ports
+	List      bool     `json:"list,omitempty"`
+	States    []string `json:"states,omitempty"`    // socket states, ex: LISTEN or ESTABLISHED
+	Processes []string `json:"processes,omitempty"` // regexps on the name of the owning process
+	RemoteIPs []string `json:"remoteips,omitempty"` // remote ips or cidrs
+	Ports     []string `json:"ports,omitempty"`     // local or remote ports, or port ranges, ex: 1024-65535
 }
 
 type elements struct {
@@ -54,6 +63,7 @@ type elements struct {
 	NeighborIP    map[string][]element `json:"neighborip,omitempty"`
 	ConnectedIP   map[string][]element `json:"connectedip,omitempty"`
 	ListeningPort map[string][]element `json:"listeningport,omitempty"`
+	Sockets       []element            `json:"sockets,omitempty"`
 }
 
 type element struct {
@@ -64,6 +74,13 @@ type element struct {
 	RemoteAddr    string  `json:"remoteaddr,omitempty"`
 	RemotePort    float64 `json:"remoteport,omitempty"`
 	Namespace     string  `json:"namespace,omitempty"`
+	Protocol      string  `json:"protocol,omitempty"` // tcp, tcp6, udp, udp6 or unix, in list mode
+	State         string  `json:"state,omitempty"`
+	Path          string  `json:"path,omitempty"` // path of unix sockets
+	Inode         float64 `json:"inode,omitempty"`
+	UID           float64 `json:"uid,omitempty"`
+	PID           float64 `json:"pid,omitempty"`
+	ProcessName   string  `json:"processname,omitempty"`
 }
 
 func newElements() *elements {
@@ -128,6 +145,11 @@ func (r *run) ValidateParameters() (err error) {
 			return
 		}
 	}
+	if !r.Parameters.List && (len(r.Parameters.States) > 0 || len(r.Parameters.Processes) > 0 ||
+		len(r.Parameters.RemoteIPs) > 0 || len(r.Parameters.Ports) > 0) {
+		return fmt.Errorf("states, processes, remoteips and ports filters require list mode")
+	}
+	_, err = newSocketFilter(r.Parameters)
 	return
 }
 
@@ -166,6 +188,138 @@ func validatePort(val string) error {
 	return nil
 }
 
+// parsePortRange parses a port, or a range of ports such as 1024-65535
+func parsePortRange(val string) (min, max int, err error) {
+	bounds := strings.SplitN(val, "-", 2)
+	for _, b := range bounds {
+		err = validatePort(b)
+		if err != nil {
+			return
+		}
+	}
+	min, _ = strconv.Atoi(bounds[0])
+	max = min
+	if len(bounds) == 2 {
+		max, _ = strconv.Atoi(bounds[1])
+		if max < min {
+			return min, max, fmt.Errorf("invalid port range %s", val)
+		}
+	}
+	return
+}
+
+// socketStates are the states a socket can be in, as reported in list mode
+var socketStates = map[string]bool{
+	"ESTABLISHED": true, "SYN_SENT": true, "SYN_RECV": true, "FIN_WAIT1": true,
+	"FIN_WAIT2": true, "TIME_WAIT": true, "CLOSE": true, "CLOSE_WAIT": true,
+	"LAST_ACK": true, "LISTEN": true, "CLOSING": true, "UNCONN": true,
+	"CONNECTED": true, "CONNECTING": true, "DISCONNECTING": true,
+}
+
+// socketFilter is the compiled form of the filters of list mode. Within a
+// filter, a socket matches if any of the values matches, and it must match
+// all the filters that are set.
+type socketFilter struct {
+	states    map[string]bool
+	processes []*regexp.Regexp
+	remotes   []*net.IPNet
+	ports     [][2]int
+}
+
+func newSocketFilter(p params) (f socketFilter, err error) {
+	if len(p.States) > 0 {
+		f.states = make(map[string]bool)
+	}
+	for _, s := range p.States {
+		s = strings.ToUpper(s)
+		if !socketStates[s] {
+			return f, fmt.Errorf("invalid socket state '%s'", s)
+		}
+		f.states[s] = true
+	}
+	for _, val := range p.Processes {
+		re, err := regexp.Compile(val)
+		if err != nil {
+			return f, fmt.Errorf("invalid process regexp '%s': %v", val, err)
+		}
+		f.processes = append(f.processes, re)
+	}
+	for _, val := range p.RemoteIPs {
+		err = validateIP(val)
+		if err != nil {
+			return
+		}
+		if strings.IndexAny(val, "/") < 0 {
+			if strings.Contains(val, ":") {
+				val += "/128"
+			} else {
+				val += "/32"
+			}
+		}
+		_, ipnet, _ := net.ParseCIDR(val)
+		f.remotes = append(f.remotes, ipnet)
+	}
+	for _, val := range p.Ports {
+		min, max, err := parsePortRange(val)
+		if err != nil {
+			return f, err
+		}
+		f.ports = append(f.ports, [2]int{min, max})
+	}
+	return
+}
+
+// match returns true if a socket from the socket table is selected by the
+// filter. Unix sockets never match the remote ip and port filters, and
+// unconnected sockets never match the remote ip filter.
+func (f socketFilter) match(el element) bool {
+	if f.states != nil && !f.states[el.State] {
+		return false
+	}
+	if len(f.processes) > 0 {
+		found := false
+		for _, re := range f.processes {
+			if re.MatchString(el.ProcessName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.remotes) > 0 {
+		ip := net.ParseIP(el.RemoteAddr)
+		found := false
+		for _, ipnet := range f.remotes {
+			if ip != nil && !ip.IsUnspecified() && ipnet.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.ports) > 0 {
+		if el.Protocol == "unix" {
+			return false
+		}
+		found := false
+		for _, r := range f.ports {
+			for _, port := range []int{int(el.LocalPort), int(el.RemotePort)} {
+				if port >= r[0] && port <= r[1] {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -255,6 +409,20 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 			r.Results.FoundAnything = true
 		}
 	}
+	if r.Parameters.List {
+		f, err := newSocketFilter(r.Parameters)
+		if err != nil {
+			panic(err)
+		}
+		els.Sockets, err = ListSockets(f)
+		if err != nil {
+			r.Results.Errors = append(r.Results.Errors, fmt.Sprintf("%v", err))
+		}
+		stats.Totalhits += float64(len(els.Sockets))
+		if len(els.Sockets) > 0 {
+			r.Results.FoundAnything = true
+		}
+	}
 	r.Results.Elements = els
 	// calculate execution time
 	t1 := time.Now()
@@ -352,6 +520,24 @@ func printNamespaceId(ns string) string {
 	return ""
 }
 
+// printSocket formats a socket of the socket table like netstat does
+func printSocket(el element) string {
+	var resStr string
+	if el.Protocol == "unix" {
+		resStr = fmt.Sprintf("socket unix %s %s", el.State, el.Path)
+	} else {
+		resStr = fmt.Sprintf("socket %s %s %s %s", el.Protocol,
+			net.JoinHostPort(el.LocalAddr, fmt.Sprintf("%.0f", el.LocalPort)),
+			net.JoinHostPort(el.RemoteAddr, fmt.Sprintf("%.0f", el.RemotePort)), el.State)
+	}
+	resStr += fmt.Sprintf(" uid=%.0f inode=%.0f", el.UID, el.Inode)
+	if el.PID > 0 {
+		resStr += fmt.Sprintf(" pid=%.0f process=%s", el.PID, el.ProcessName)
+	}
+	resStr += printNamespaceId(el.Namespace)
+	return resStr
+}
+
 func (r *run) PrintResults(result modules.Result, matchOnly bool) (prints []string, err error) {
 	var (
 		el    elements
@@ -451,6 +637,9 @@ func (r *run) PrintResults(result modules.Result, matchOnly bool) (prints []stri
 			prints = append(prints, resStr)
 		}
 	}
+	for _, el := range el.Sockets {
+		prints = append(prints, printSocket(el))
+	}
 	if matchOnly {
 		return
 	}
@@ -467,6 +656,73 @@ func (r *run) PrintResults(result modules.Result, matchOnly bool) (prints []stri
 	return
 }
 
+// FlattenResults returns one row per element found, with the type of search and
+// the searched value in the check and search columns
+func (r *run) FlattenResults(result modules.Result, matchOnly bool) (rows []modules.ResultRow, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("FlattenResults() -> %v", e)
+		}
+	}()
+	el := *newElements()
+	err = result.GetElements(&el)
+	if err != nil {
+		panic(err)
+	}
+	checks := []struct {
+		name string
+		res  map[string][]element
+	}{
+		{"localmac", el.LocalMAC},
+		{"neighbormac", el.NeighborMAC},
+		{"neighborip", el.NeighborIP},
+		{"localip", el.LocalIP},
+		{"connectedip", el.ConnectedIP},
+		{"listeningport", el.ListeningPort},
+	}
+	for _, c := range checks {
+		for val, res := range c.res {
+			if len(res) == 0 {
+				if !matchOnly {
+					rows = append(rows, modules.ResultRow{"check": c.name, "search": val})
+				}
+				continue
+			}
+			for _, e := range res {
+				rows = append(rows, modules.ResultRow{
+					"check":         c.name,
+					"search":        val,
+					"localmacaddr":  e.LocalMACAddr,
+					"remotemacaddr": e.RemoteMACAddr,
+					"localaddr":     e.LocalAddr,
+					"localport":     e.LocalPort,
+					"remoteaddr":    e.RemoteAddr,
+					"remoteport":    e.RemotePort,
+					"namespace":     e.Namespace,
+				})
+			}
+		}
+	}
+	for _, e := range el.Sockets {
+		rows = append(rows, modules.ResultRow{
+			"check":       "list",
+			"protocol":    e.Protocol,
+			"localaddr":   e.LocalAddr,
+			"localport":   e.LocalPort,
+			"remoteaddr":  e.RemoteAddr,
+			"remoteport":  e.RemotePort,
+			"path":        e.Path,
+			"state":       e.State,
+			"inode":       e.Inode,
+			"uid":         e.UID,
+			"pid":         e.PID,
+			"processname": e.ProcessName,
+			"namespace":   e.Namespace,
+		})
+	}
+	return
+}
+
 // Enhanced privacy mode for the netstat module, mask returned address information
 //
 // On an agent with enhanced privacy mode enabled, this does not provide much for queries
@@ -485,6 +741,11 @@ func (r *run) EnhancePrivacy(in modules.Result) (out modules.Result, err error)
 			el.ConnectedIP[k][i].RemoteAddr = "masked"
 		}
 	}
+	for i := range el.Sockets {
+		if el.Sockets[i].RemoteAddr != "" {
+			el.Sockets[i].RemoteAddr = "masked"
+		}
+	}
 	out.Elements = el
 	return
 }
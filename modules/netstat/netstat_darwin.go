@@ -184,3 +184,9 @@ func HasSeenIP(val string) (found bool, elements []element, err error) {
 	err = fmt.Errorf("HasSeenIP(): operation is not implemented on darwin")
 	return
 }
+
+// ListSockets is not implemented on this platform, the socket table is only
+// available on linux
+func ListSockets(f socketFilter) (elements []element, err error) {
+	return nil, fmt.Errorf("ListSockets(): listing sockets is not supported on darwin")
+}
@@ -721,3 +721,212 @@ func hasSeenIP6(ip net.IP, ipnet *net.IPNet) (found bool, elements []element, er
 	}
 	return
 }
+
+// tcpStates are the states of tcp sockets in /proc/net/tcp, indexed by their
+// hexadecimal value. udp sockets use the same values, but are only ever
+// ESTABLISHED or unconnected.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED", "02": "SYN_SENT", "03": "SYN_RECV", "04": "FIN_WAIT1",
+	"05": "FIN_WAIT2", "06": "TIME_WAIT", "07": "CLOSE", "08": "CLOSE_WAIT",
+	"09": "LAST_ACK", "0A": "LISTEN", "0B": "CLOSING",
+}
+
+// unixStates are the states of unix sockets in /proc/net/unix
+var unixStates = map[string]string{
+	"01": "UNCONN", "02": "CONNECTING", "03": "CONNECTED", "04": "DISCONNECTING",
+}
+
+// unixAcceptCon is the flag of listening unix sockets
+const unixAcceptCon = 0x10000
+
+// socketOwner is the process that holds a socket
+type socketOwner struct {
+	pid  int
+	name string
+}
+
+// ListSockets on linux returns the sockets of /proc/net/{tcp,tcp6,udp,udp6,unix},
+// or of the same files in each network namespace in namespace mode. Sockets are
+// attributed to a process by looking for their inode in the file descriptors
+// of /proc/<pid>/fd.
+func ListSockets(f socketFilter) (elements []element, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("ListSockets(): %v", e)
+		}
+	}()
+	owners := socketOwners()
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+		lns, err := procSocketEntries(proto)
+		if err != nil {
+			panic(err)
+		}
+		for _, ent := range lns {
+			var el element
+			if proto == "unix" {
+				el, err = parseUnixSocket(ent.line)
+			} else {
+				el, err = parseInetSocket(proto, ent.line)
+			}
+			if err != nil {
+				panic(err)
+			}
+			el.Namespace = ent.nsIdentifier
+			if owner, ok := owners[uint64(el.Inode)]; ok {
+				el.PID = float64(owner.pid)
+				el.ProcessName = owner.name
+			}
+			stats.Examined++
+			if f.match(el) {
+				elements = append(elements, el)
+			}
+		}
+	}
+	return
+}
+
+// procSocketEntries returns the lines of a socket table of /proc/net, in all
+// network namespaces in namespace mode
+func procSocketEntries(proto string) (ret []procNetLine, err error) {
+	if namespaceMode {
+		return procNetNS(proto)
+	}
+	fd, err := os.Open(path.Join("/proc/net", proto))
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	scanner.Scan() // Skip the header
+	for scanner.Scan() {
+		ret = append(ret, procNetLine{line: scanner.Text(), nsIdentifier: "default"})
+	}
+	return ret, scanner.Err()
+}
+
+// parseInetSocket parses a line of /proc/net/{tcp,tcp6,udp,udp6}:
+// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+func parseInetSocket(proto, line string) (el element, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 10 {
+		return el, fmt.Errorf("invalid %s entry '%s'", proto, line)
+	}
+	el.Protocol = proto
+	var port uint64
+	el.LocalAddr, port, err = parseHexEndpoint(fields[1])
+	if err != nil {
+		return
+	}
+	el.LocalPort = float64(port)
+	el.RemoteAddr, port, err = parseHexEndpoint(fields[2])
+	if err != nil {
+		return
+	}
+	el.RemotePort = float64(port)
+	el.State = tcpStates[fields[3]]
+	if strings.HasPrefix(proto, "udp") && el.State != "ESTABLISHED" {
+		el.State = "UNCONN"
+	}
+	uid, err := strconv.ParseUint(fields[7], 10, 32)
+	if err != nil {
+		return el, fmt.Errorf("invalid uid in %s entry '%s'", proto, line)
+	}
+	el.UID = float64(uid)
+	inode, err := strconv.ParseUint(fields[9], 10, 64)
+	if err != nil {
+		return el, fmt.Errorf("invalid inode in %s entry '%s'", proto, line)
+	}
+	el.Inode = float64(inode)
+	return el, nil
+}
+
+// parseHexEndpoint converts an <ip>:<port> endpoint of /proc/net/tcp, in
+// hexadecimal notation, into an ip address and a port
+func parseHexEndpoint(val string) (addr string, port uint64, err error) {
+	endpoint := strings.Split(val, ":")
+	if len(endpoint) != 2 {
+		return "", 0, fmt.Errorf("endpoint '%s' isn't in the form <ip>:<port>", val)
+	}
+	var ip net.IP
+	switch len(endpoint[0]) {
+	case 8:
+		ip = hexToIP4(endpoint[0])
+	case 32:
+		ip = hexToIP6(endpoint[0])
+	}
+	if ip == nil {
+		return "", 0, fmt.Errorf("failed to convert ip of endpoint '%s'", val)
+	}
+	port, err = strconv.ParseUint(endpoint[1], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to convert port of endpoint '%s'", val)
+	}
+	return ip.String(), port, nil
+}
+
+// parseUnixSocket parses a line of /proc/net/unix:
+// Num RefCount Protocol Flags Type St Inode Path
+func parseUnixSocket(line string) (el element, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return el, fmt.Errorf("invalid unix entry '%s'", line)
+	}
+	el.Protocol = "unix"
+	flags, err := strconv.ParseUint(fields[3], 16, 32)
+	if err != nil {
+		return el, fmt.Errorf("invalid flags in unix entry '%s'", line)
+	}
+	el.State = unixStates[fields[5]]
+	if flags&unixAcceptCon != 0 {
+		el.State = "LISTEN"
+	}
+	inode, err := strconv.ParseUint(fields[6], 10, 64)
+	if err != nil {
+		return el, fmt.Errorf("invalid inode in unix entry '%s'", line)
+	}
+	el.Inode = float64(inode)
+	if len(fields) > 7 {
+		el.Path = fields[7]
+	}
+	return el, nil
+}
+
+// socketOwners maps the inodes of sockets to the processes that hold them.
+// Processes that exit, or whose descriptors can't be read, are skipped.
+func socketOwners() map[uint64]socketOwner {
+	owners := make(map[uint64]socketOwner)
+	dirents, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, x := range dirents {
+		pid, err := strconv.Atoi(x.Name())
+		if err != nil {
+			continue
+		}
+		fds, err := ioutil.ReadDir(path.Join("/proc", x.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		var name string
+		for _, fd := range fds {
+			link, err := os.Readlink(path.Join("/proc", x.Name(), "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[8:], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := owners[inode]; ok {
+				continue
+			}
+			if name == "" {
+				comm, _ := ioutil.ReadFile(path.Join("/proc", x.Name(), "comm"))
+				name = strings.TrimSpace(string(comm))
+			}
+			owners[inode] = socketOwner{pid: pid, name: name}
+		}
+	}
+	return owners
+}
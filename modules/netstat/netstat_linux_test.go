@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package netstat /* import "github.com/mozilla/mig/modules/netstat" */
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParseInetSocket(t *testing.T) {
+	line := "   1: 0100007F:0CEA 0200007F:C350 01 00000000:00000000 00:00000000 00000000  1000        0 123456 1 0000000000000000 20 4 30 10 -1"
+	el, err := parseInetSocket("tcp", line)
+	if err != nil {
+		t.Fatalf("parseInetSocket: %v", err)
+	}
+	if el.LocalAddr != "127.0.0.1" || el.LocalPort != 3306 || el.RemoteAddr != "127.0.0.2" ||
+		el.RemotePort != 50000 || el.State != "ESTABLISHED" || el.UID != 1000 || el.Inode != 123456 {
+		t.Fatalf("unexpected tcp socket %+v", el)
+	}
+	line = "  12: 00000000000000000000000001000000:0035 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 4242 2 0000000000000000 0"
+	el, err = parseInetSocket("udp6", line)
+	if err != nil {
+		t.Fatalf("parseInetSocket: %v", err)
+	}
+	if el.LocalAddr != "::1" || el.LocalPort != 53 || el.State != "UNCONN" || el.Inode != 4242 {
+		t.Fatalf("unexpected udp6 socket %+v", el)
+	}
+	if _, err = parseInetSocket("tcp", "   1: 0100007F:0CEA"); err == nil {
+		t.Fatalf("truncated entry was parsed")
+	}
+}
+
+func TestParseUnixSocket(t *testing.T) {
+	el, err := parseUnixSocket("0000000000000000: 00000002 00000000 00010000 0001 01 17521 /run/systemd/private")
+	if err != nil {
+		t.Fatalf("parseUnixSocket: %v", err)
+	}
+	if el.State != "LISTEN" || el.Inode != 17521 || el.Path != "/run/systemd/private" {
+		t.Fatalf("unexpected unix socket %+v", el)
+	}
+	el, err = parseUnixSocket("0000000000000000: 00000003 00000000 00000000 0001 03 20133")
+	if err != nil {
+		t.Fatalf("parseUnixSocket: %v", err)
+	}
+	if el.State != "CONNECTED" || el.Path != "" {
+		t.Fatalf("unexpected unix socket %+v", el)
+	}
+}
+
+func TestListSockets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	f, err := newSocketFilter(params{List: true, States: []string{"LISTEN"},
+		Ports: []string{strconv.Itoa(port)}})
+	if err != nil {
+		t.Fatalf("newSocketFilter: %v", err)
+	}
+	sockets, err := ListSockets(f)
+	if err != nil {
+		t.Fatalf("ListSockets: %v", err)
+	}
+	if len(sockets) != 1 {
+		t.Fatalf("expected 1 socket listening on port %d, got %+v", port, sockets)
+	}
+	s := sockets[0]
+	if s.Protocol != "tcp" || s.LocalAddr != "127.0.0.1" || int(s.PID) != os.Getpid() ||
+		int(s.UID) != os.Getuid() || s.Namespace != "default" {
+		t.Fatalf("unexpected socket %+v", s)
+	}
+
+	// in namespace mode, the socket is found in the namespace of the test
+	namespaceMode = true
+	defer func() { namespaceMode = false }()
+	sockets, err = ListSockets(f)
+	if err != nil {
+		t.Fatalf("ListSockets: %v", err)
+	}
+	ns, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		t.Skipf("network namespace not available: %v", err)
+	}
+	if len(sockets) != 1 || sockets[0].Namespace != ns {
+		t.Fatalf("expected 1 socket in namespace %s, got %+v", ns, sockets)
+	}
+}
@@ -7,6 +7,7 @@
 package netstat /* import "github.com/mozilla/mig/modules/netstat" */
 
 import (
+	"fmt"
 	"github.com/mozilla/mig/testutil"
 	"testing"
 )
@@ -14,3 +15,59 @@ import (
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "netstat")
 }
+
+func TestSocketFilter(t *testing.T) {
+	sockets := []element{
+		{Protocol: "tcp", LocalAddr: "0.0.0.0", LocalPort: 22, RemoteAddr: "0.0.0.0", State: "LISTEN", ProcessName: "sshd"},
+		{Protocol: "tcp", LocalAddr: "10.0.0.5", LocalPort: 22, RemoteAddr: "10.0.1.7", RemotePort: 50123,
+			State: "ESTABLISHED", ProcessName: "sshd"},
+		{Protocol: "tcp6", LocalAddr: "2001:db8::5", LocalPort: 41000, RemoteAddr: "2001:db8:1::80", RemotePort: 443,
+			State: "ESTABLISHED", ProcessName: "curl"},
+		{Protocol: "udp", LocalAddr: "127.0.0.1", LocalPort: 53, RemoteAddr: "0.0.0.0", State: "UNCONN"},
+		{Protocol: "unix", Path: "/run/systemd/notify", State: "LISTEN", ProcessName: "systemd"},
+	}
+	var testcases = []struct {
+		p      params
+		expect []int
+	}{
+		{params{List: true}, []int{0, 1, 2, 3, 4}},
+		{params{List: true, States: []string{"listen"}}, []int{0, 4}},
+		{params{List: true, Processes: []string{"^sshd$", "curl"}}, []int{0, 1, 2}},
+		{params{List: true, RemoteIPs: []string{"10.0.0.0/8", "2001:db8:1::80"}}, []int{1, 2}},
+		{params{List: true, RemoteIPs: []string{"0.0.0.0/0"}}, []int{1}},
+		{params{List: true, Ports: []string{"443", "1-100"}}, []int{0, 1, 2, 3}},
+		{params{List: true, Ports: []string{"1024-65535"}, States: []string{"ESTABLISHED"}}, []int{1, 2}},
+	}
+	for i, tc := range testcases {
+		f, err := newSocketFilter(tc.p)
+		if err != nil {
+			t.Fatalf("case %d: newSocketFilter: %v", i, err)
+		}
+		var matched []int
+		for j, s := range sockets {
+			if f.match(s) {
+				matched = append(matched, j)
+			}
+		}
+		if fmt.Sprint(matched) != fmt.Sprint(tc.expect) {
+			t.Fatalf("case %d: expected sockets %v, got %v", i, tc.expect, matched)
+		}
+	}
+}
+
+func TestValidateList(t *testing.T) {
+	var invalid = []params{
+		{States: []string{"LISTEN"}},
+		{List: true, States: []string{"BOUND"}},
+		{List: true, Processes: []string{"(sshd"}},
+		{List: true, RemoteIPs: []string{"10.0.0.0/33"}},
+		{List: true, Ports: []string{"1024-80"}},
+		{List: true, Ports: []string{"70000"}},
+	}
+	for i, p := range invalid {
+		r := run{Parameters: p}
+		if r.ValidateParameters() == nil {
+			t.Fatalf("case %d: invalid parameters %+v were accepted", i, p)
+		}
+	}
+}
@@ -41,6 +41,23 @@ listeningport <port>	search for an open socket on the local system listening on
 
 namespaces              enable namespace resolution (linux)
                         example: > namespaces
+
+list			list the tcp, udp and unix sockets of the system, with the
+			process that holds them (linux). the following filters
+			restrict the list:
+			example: > list
+
+state <state>		only list sockets in a state, such as LISTEN or ESTABLISHED
+			example: > state LISTEN
+
+process <regex>		only list sockets held by a process whose name matches <regex>
+			example: > process ^sshd$
+
+remoteip <ip|cidr>	only list sockets connected to an ip within <cidr>
+			example: > remoteip 10.0.0.0/8
+
+port <port|min-max>	only list sockets with a local or remote port in a range
+			example: > port 1-1023
 `
 
 // ParamsCreator implements an interactive parameters creation interface, which
@@ -71,6 +88,10 @@ func (r *run) ParamsCreator() (interface{}, error) {
 			p.SearchNamespaces = true
 			continue
 		}
+		if input == "list" {
+			p.List = true
+			continue
+		}
 		arr := strings.SplitN(input, " ", 2)
 		if len(arr) != 2 {
 			fmt.Printf("Invalid input format!\n%s\n", help)
@@ -127,6 +148,29 @@ func (r *run) ParamsCreator() (interface{}, error) {
 			}
 			p.ListeningPort = append(p.ListeningPort, checkValue)
 			fmt.Printf("Stored %s '%s'. Enter another search or 'done'.\n", checkType, checkValue)
+		case "state", "process", "remoteip", "port":
+			var f params
+			switch checkType {
+			case "state":
+				f.States = []string{checkValue}
+			case "process":
+				f.Processes = []string{checkValue}
+			case "remoteip":
+				f.RemoteIPs = []string{checkValue}
+			case "port":
+				f.Ports = []string{checkValue}
+			}
+			_, err = newSocketFilter(f)
+			if err != nil {
+				fmt.Printf("ERROR: %v\nTry again.\n", err)
+				continue
+			}
+			p.List = true
+			p.States = append(p.States, f.States...)
+			p.Processes = append(p.Processes, f.Processes...)
+			p.RemoteIPs = append(p.RemoteIPs, f.RemoteIPs...)
+			p.Ports = append(p.Ports, f.Ports...)
+			fmt.Printf("Stored %s '%s'. Enter another search or 'done'.\n", checkType, checkValue)
 		default:
 			fmt.Printf("Invalid method!\nTry 'help'\n")
 			continue
@@ -164,6 +208,22 @@ const cmd_help string = `
 
 -namespaces <bool> enable namespace resolution (linux)
                    example: -namespaces
+
+-list		   list the tcp, udp and unix sockets of the system, with the
+		   process that holds them (linux)
+		   example: -list
+
+-state <state>	   only list sockets in a state, such as LISTEN or ESTABLISHED
+		   example: -list -state LISTEN
+
+-process <regex>   only list sockets held by a process whose name matches <regex>
+		   example: -list -process ^nginx
+
+-remoteip <cidr>   only list sockets connected to an ip within <cidr>
+		   example: -list -remoteip 10.0.0.0/8
+
+-port <min-max>	   only list sockets with a local or remote port in a range
+		   example: -list -port 1-1023
 `
 
 // ParamsParser implements a command line parameters parser that takes a string
@@ -173,8 +233,9 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
 		err                    error
 		lm, nm, li, ni, ci, lp flagParam
+		state, proc, rip, port flagParam
 		fs                     flag.FlagSet
-		namespaces             bool
+		namespaces, list       bool
 	)
 	if len(args) < 1 || args[0] == "" || args[0] == "help" {
 		fmt.Println(cmd_help)
@@ -188,6 +249,11 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.Var(&ci, "ci", "see help")
 	fs.Var(&lp, "lp", "see help")
 	fs.BoolVar(&namespaces, "namespaces", false, "see help")
+	fs.BoolVar(&list, "list", false, "see help")
+	fs.Var(&state, "state", "see help")
+	fs.Var(&proc, "process", "see help")
+	fs.Var(&rip, "remoteip", "see help")
+	fs.Var(&port, "port", "see help")
 	err = fs.Parse(args)
 	if err != nil {
 		return nil, err
@@ -200,6 +266,11 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	p.ConnectedIP = ci
 	p.ListeningPort = lp
 	p.SearchNamespaces = namespaces
+	p.List = list
+	p.States = state
+	p.Processes = proc
+	p.RemoteIPs = rip
+	p.Ports = port
 
 	r.Parameters = p
 	return p, r.ValidateParameters()
@@ -24,24 +24,35 @@ func printHelp(isCmd bool) {
 		dash = "-"
 	}
 	fmt.Printf(`Ping module checks connectivity between an endpoint and a remote host. It supports
-icmp, tcp and udp ping.
+icmp, tcp and udp ping, traceroute and path MTU discovery.
 
 %sd <ip/fqdn>	Destination Address can be ipv4, ipv6 or FQDN
 		example: %sd www.mozilla.org
 			 %sd 63.245.217.105
 
 %sdp <port>	For TCP and UDP, specifies the port to test connectivity to
+		For traceroute, defaults to 33434 for udp probes and 80 for tcp probes
 		example: %sdp 53
 
-%sp <protocol>	Protocol to use for the ping. This can be "icmp", "tcp" or "udp"
+%sp <protocol>	Protocol to use for the ping. This can be "icmp", "tcp", "udp",
+		"traceroute" to find the hops to the destination, or "pmtu" to
+		discover the path MTU to an ipv4 destination
 		example: %sp udp
 
 %sc <count>	Number of ping/connection attempts. Defaults to 3.
+		For traceroute, number of probes sent to each hop.
 		example: %sc 5
 
 %st <timeout>	Connection timeout in seconds. Defaults to 5.
+		For traceroute, time to wait for the probes of each hop.
 		example: %st 10
-`, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash)
+
+%sprobe <proto>	Traceroute probes, "udp" or "tcp" SYN. Defaults to udp.
+		example: %sprobe tcp
+
+%smaxhops <n>	Maximum number of hops of a traceroute. Defaults to 30.
+		example: %smaxhops 15
+`, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash, dash)
 
 	return
 }
@@ -49,11 +60,11 @@ icmp, tcp and udp ping.
 // ParamsParser implements a command line parameter parser for the ping module
 func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
-		err      error
-		pa       params
-		d, p     string
-		dp, c, t float64
-		fs       flag.FlagSet
+		err         error
+		pa          params
+		d, p, pr    string
+		dp, c, t, m float64
+		fs          flag.FlagSet
 	)
 	if len(args) < 1 || args[0] == "" || args[0] == "help" {
 		printHelp(true)
@@ -65,6 +76,8 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.StringVar(&p, "p", "icmp", "see help")
 	fs.Float64Var(&c, "c", 3, "see help")
 	fs.Float64Var(&t, "t", 5, "see help")
+	fs.StringVar(&pr, "probe", "", "see help")
+	fs.Float64Var(&m, "maxhops", 0, "see help")
 
 	err = fs.Parse(args)
 	if err != nil {
@@ -75,6 +88,8 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	pa.Protocol = p
 	pa.Count = c
 	pa.Timeout = t
+	pa.Probe = pr
+	pa.MaxHops = m
 	r.Parameters = pa
 	return pa, r.ValidateParameters()
 }
@@ -123,6 +138,14 @@ func (r *run) ParamsCreator() (interface{}, error) {
 				fmt.Printf("invalid timeout: %v\n", err)
 				continue
 			}
+		case "probe":
+			p.Probe = splitted[1]
+		case "maxhops":
+			p.MaxHops, err = strconv.ParseFloat(splitted[1], 64)
+			if err != nil {
+				fmt.Printf("invalid maxhops: %v\n", err)
+				continue
+			}
 		case "help":
 			printHelp(false)
 		case "done":
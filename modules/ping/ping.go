@@ -43,17 +43,22 @@ type run struct {
 type params struct {
 	Destination     string  `json:"destination"`               // ipv4, ipv6 or fqdn.
 	DestinationPort float64 `json:"destinationport,omitempty"` // 16 bits integer. Throws an error when used with icmp. Defaults to 80 otherwise.
-	Protocol        string  `json:"protocol"`                  // icmp, tcp, udp
-	Count           float64 `json:"count,omitempty"`           // Number of tests
+	Protocol        string  `json:"protocol"`                  // icmp, tcp, udp, traceroute, pmtu
+	Count           float64 `json:"count,omitempty"`           // Number of tests, or of probes per hop for traceroute
 	Timeout         float64 `json:"timeout,omitempty"`         // Timeout for individual test. defaults to 5s.
+	Probe           string  `json:"probe,omitempty"`           // udp or tcp probes for traceroute. defaults to udp.
+	MaxHops         float64 `json:"maxhops,omitempty"`         // Maximum ttl of traceroute probes. defaults to 30.
 	ipDest          string
 }
 
 type elements struct {
-	Latencies    []float64 `json:"latencies"`    // response latency in milliseconds: 9999999 indicates timeout, -1 indicates unreachable, 0 general error.
-	Protocol     string    `json:"protocol"`     // icmp, tcp, udp
-	ResolvedHost string    `json:"resolvedhost"` // Information about the ip:port being pinged
-	Failures     []string  `json:"failures"`     // ping failures, soft errors
+	Latencies    []float64 `json:"latencies"`         // response latency in milliseconds: 9999999 indicates timeout, -1 indicates unreachable, 0 general error.
+	Protocol     string    `json:"protocol"`          // icmp, tcp, udp, traceroute, pmtu
+	ResolvedHost string    `json:"resolvedhost"`      // Information about the ip:port being pinged
+	Failures     []string  `json:"failures"`          // ping failures, soft errors
+	Hops         []hop     `json:"hops,omitempty"`    // traceroute hops, in order of ttl
+	Reached      bool      `json:"reached,omitempty"` // traceroute reached the destination
+	PathMTU      int       `json:"pathmtu,omitempty"` // path MTU to the destination, in bytes
 }
 
 const (
@@ -84,11 +89,27 @@ func (r *run) Run(in modules.ModuleReader) (out string) {
 	}
 
 	el.ResolvedHost = r.Parameters.Destination
-	if r.Parameters.Protocol == "udp" || r.Parameters.Protocol == "tcp" {
+	if r.Parameters.Protocol == "udp" || r.Parameters.Protocol == "tcp" || r.Parameters.Protocol == "traceroute" {
 		el.ResolvedHost += fmt.Sprintf(":%.0f", r.Parameters.DestinationPort)
 	}
 	el.ResolvedHost += " (" + r.Parameters.ipDest + ")"
 	el.Protocol = r.Parameters.Protocol
+	switch r.Parameters.Protocol {
+	case "traceroute":
+		el.Hops, el.Reached, err = r.traceroute()
+		if err != nil {
+			el.Failures = append(el.Failures, fmt.Sprintf("traceroute failed with error: %v", err))
+		}
+		r.Results.FoundAnything = el.Reached
+		return r.buildResults(el)
+	case "pmtu":
+		el.PathMTU, err = r.pathMTU()
+		if err != nil {
+			el.Failures = append(el.Failures, fmt.Sprintf("path mtu discovery failed with error: %v", err))
+		}
+		r.Results.FoundAnything = el.PathMTU > 0
+		return r.buildResults(el)
+	}
 	for i := 0; i < int(r.Parameters.Count); i += 1 {
 		var err error
 		// startTime for calculating the latency/RTT
@@ -140,13 +161,22 @@ func (r *run) Run(in modules.ModuleReader) (out string) {
 func (r *run) ValidateParameters() (err error) {
 	// check if Protocol is a valid one that we support with this module
 	switch r.Parameters.Protocol {
-	case "icmp", "udp", "tcp":
+	case "icmp", "udp", "tcp", "pmtu":
 		break
+	case "traceroute":
+		err = r.validateTraceroute()
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("%s is not a supported ping protocol", r.Parameters.Protocol)
 	}
+	if r.Parameters.Protocol != "traceroute" && (r.Parameters.Probe != "" || r.Parameters.MaxHops != 0) {
+		return fmt.Errorf("probe and maxhops can only be used with traceroute")
+	}
 	// tcp and udp pings must have a destination port
-	if r.Parameters.Protocol != "icmp" && (r.Parameters.DestinationPort < 0 || r.Parameters.DestinationPort > 65535) {
+	if (r.Parameters.Protocol == "tcp" || r.Parameters.Protocol == "udp") &&
+		(r.Parameters.DestinationPort < 0 || r.Parameters.DestinationPort > 65535) {
 		return fmt.Errorf("%s ping requires a valid destination port between 0 and 65535, got %.0f",
 			r.Parameters.Protocol, r.Parameters.DestinationPort)
 	}
@@ -170,6 +200,10 @@ func (r *run) ValidateParameters() (err error) {
 		return fmt.Errorf("destination IP is invalid: %v", ip)
 	}
 	r.Parameters.ipDest = ip
+	// path mtu discovery relies on the don't fragment flag of ipv4
+	if r.Parameters.Protocol == "pmtu" && ip_parsed.To4() == nil {
+		return fmt.Errorf("path mtu discovery only supports ipv4 destinations")
+	}
 
 	// if timeout is not set, default to 5 seconds
 	if r.Parameters.Timeout == 0.0 {
@@ -184,6 +218,37 @@ func (r *run) ValidateParameters() (err error) {
 	return
 }
 
+// validateTraceroute checks the traceroute parameters and sets their defaults
+func (r *run) validateTraceroute() error {
+	switch r.Parameters.Probe {
+	case "":
+		r.Parameters.Probe = "udp"
+	case "udp", "tcp":
+		break
+	default:
+		return fmt.Errorf("%s is not a supported traceroute probe, must be udp or tcp", r.Parameters.Probe)
+	}
+	// traceroute to the ports used by the traceroute utility by default,
+	// which are unlikely to be open
+	if r.Parameters.DestinationPort <= 0 {
+		r.Parameters.DestinationPort = 33434
+		if r.Parameters.Probe == "tcp" {
+			r.Parameters.DestinationPort = 80
+		}
+	}
+	if r.Parameters.DestinationPort > 65535 {
+		return fmt.Errorf("traceroute requires a valid destination port between 1 and 65535, got %.0f",
+			r.Parameters.DestinationPort)
+	}
+	if r.Parameters.MaxHops == 0 {
+		r.Parameters.MaxHops = 30
+	}
+	if r.Parameters.MaxHops < 1 || r.Parameters.MaxHops > 255 {
+		return fmt.Errorf("maximum number of hops must be between 1 and 255, got %.0f", r.Parameters.MaxHops)
+	}
+	return nil
+}
+
 // pingIcmp performs a ping to a destination. It select between ipv4 or ipv6 ping based
 // on the format of the destination ip.
 func (r *run) pingIcmp() (err error) {
@@ -329,6 +394,12 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	if err != nil {
 		panic(err)
 	}
+	switch el.Protocol {
+	case "traceroute":
+		return printTraceroute(el, foundOnly), nil
+	case "pmtu":
+		return printPathMTU(el, foundOnly), nil
+	}
 	if result.FoundAnything {
 		prints = append(prints,
 			fmt.Sprintf("%s ping of %s succeeded. Target is reachable.",
@@ -367,3 +438,44 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	}
 	return
 }
+
+func printTraceroute(el elements, foundOnly bool) (prints []string) {
+	if el.Reached {
+		prints = append(prints, fmt.Sprintf("traceroute to %s reached the destination in %d hops",
+			el.ResolvedHost, len(el.Hops)))
+	} else if foundOnly {
+		return
+	} else {
+		prints = append(prints, fmt.Sprintf("traceroute to %s did not reach the destination",
+			el.ResolvedHost))
+	}
+	for _, h := range el.Hops {
+		addrs := "*"
+		if len(h.Addresses) > 0 {
+			addrs = strings.Join(h.Addresses, ",")
+		}
+		var lats []string
+		for _, lat := range h.Latencies {
+			if lat == 9999999 {
+				lats = append(lats, "*")
+			} else {
+				lats = append(lats, fmt.Sprintf("%.1fms", lat))
+			}
+		}
+		prints = append(prints, fmt.Sprintf("hop %d %s %s", h.TTL, addrs, strings.Join(lats, " ")))
+	}
+	if !foundOnly {
+		prints = append(prints, el.Failures...)
+	}
+	return
+}
+
+func printPathMTU(el elements, foundOnly bool) (prints []string) {
+	if el.PathMTU > 0 {
+		prints = append(prints, fmt.Sprintf("path mtu to %s is %d bytes", el.ResolvedHost, el.PathMTU))
+	}
+	if !foundOnly {
+		prints = append(prints, el.Failures...)
+	}
+	return
+}
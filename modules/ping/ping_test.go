@@ -8,9 +8,85 @@ package ping /* import "github.com/mozilla/mig/modules/ping" */
 
 import (
 	"github.com/mozilla/mig/testutil"
+	"net"
+	"strings"
 	"testing"
 )
 
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "ping")
 }
+
+func TestParseQuoted(t *testing.T) {
+	ip4 := []byte{
+		0x45, 0, 0, 28, 0, 0, 0x40, 0, 1, 17, 0, 0,
+		10, 0, 0, 1, 192, 0, 2, 1,
+		0xa0, 0x00, 0x82, 0x9a, 0, 8, 0, 0,
+	}
+	proto, dst, src, dport, ok := parseQuoted(ip4)
+	if !ok || proto != 17 || !dst.Equal(net.ParseIP("192.0.2.1")) || src != 40960 || dport != 33434 {
+		t.Fatalf("unexpected quoted ipv4 packet: %d %v %d %d %v", proto, dst, src, dport, ok)
+	}
+	ip6 := make([]byte, 48)
+	ip6[0] = 0x60
+	ip6[6] = 6
+	copy(ip6[24:40], net.ParseIP("2001:db8::1"))
+	copy(ip6[40:], []byte{0xa0, 0x01, 0x00, 0x50})
+	proto, dst, src, dport, ok = parseQuoted(ip6)
+	if !ok || proto != 6 || !dst.Equal(net.ParseIP("2001:db8::1")) || src != 40961 || dport != 80 {
+		t.Fatalf("unexpected quoted ipv6 packet: %d %v %d %d %v", proto, dst, src, dport, ok)
+	}
+	if _, _, _, _, ok = parseQuoted(ip4[:22]); ok {
+		t.Fatalf("truncated packet was parsed")
+	}
+}
+
+func TestValidateTraceroute(t *testing.T) {
+	r := run{Parameters: params{Destination: "127.0.0.1", Protocol: "traceroute", DestinationPort: -1}}
+	err := r.ValidateParameters()
+	if err != nil {
+		t.Fatalf("ValidateParameters: %v", err)
+	}
+	if r.Parameters.Probe != "udp" || r.Parameters.DestinationPort != 33434 || r.Parameters.MaxHops != 30 {
+		t.Fatalf("unexpected defaults %+v", r.Parameters)
+	}
+	r = run{Parameters: params{Destination: "127.0.0.1", Protocol: "traceroute", Probe: "tcp"}}
+	err = r.ValidateParameters()
+	if err != nil || r.Parameters.DestinationPort != 80 {
+		t.Fatalf("unexpected tcp traceroute parameters %+v, %v", r.Parameters, err)
+	}
+	var invalid = []params{
+		{Destination: "127.0.0.1", Protocol: "traceroute", Probe: "icmp"},
+		{Destination: "127.0.0.1", Protocol: "traceroute", MaxHops: 300},
+		{Destination: "127.0.0.1", Protocol: "tcp", DestinationPort: 80, Probe: "tcp"},
+		{Destination: "::1", Protocol: "pmtu"},
+	}
+	for i, p := range invalid {
+		r := run{Parameters: p}
+		if r.ValidateParameters() == nil {
+			t.Fatalf("case %d: invalid parameters %+v were accepted", i, p)
+		}
+	}
+}
+
+func TestPrintTraceroute(t *testing.T) {
+	el := elements{
+		Protocol:     "traceroute",
+		ResolvedHost: "192.0.2.1:33434 (192.0.2.1)",
+		Reached:      true,
+		Hops: []hop{
+			{TTL: 1, Addresses: []string{"10.0.0.1"}, Latencies: []float64{1.21, 1.5, 9999999}},
+			{TTL: 2, Addresses: []string{}, Latencies: []float64{9999999, 9999999, 9999999}},
+			{TTL: 3, Addresses: []string{"192.0.2.1"}, Latencies: []float64{12, 12, 12}},
+		},
+	}
+	prints := printTraceroute(el, true)
+	if len(prints) != 4 || !strings.Contains(prints[0], "in 3 hops") ||
+		prints[1] != "hop 1 10.0.0.1 1.2ms 1.5ms *" || prints[2] != "hop 2 * * * *" {
+		t.Fatalf("unexpected traceroute output %q", prints)
+	}
+	el.Reached = false
+	if prints = printTraceroute(el, true); len(prints) != 0 {
+		t.Fatalf("unexpected traceroute output %q", prints)
+	}
+}
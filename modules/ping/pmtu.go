@@ -0,0 +1,206 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ping /* import "github.com/mozilla/mig/modules/ping" */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// minMTU is the smallest MTU all ipv4 links must support
+const minMTU = 68
+
+// mtuProber sends icmp echo requests with the don't fragment flag set
+type mtuProber struct {
+	conn     *ipv4.RawConn
+	dst      net.IP
+	id, seq  int
+	timeout  time.Duration
+	attempts int
+}
+
+// pathMTU discovers the size of the largest packet that reaches the
+// destination without being fragmented. The search starts at the MTU of the
+// local interface, and uses the MTU reported by routers in fragmentation needed
+// errors when there is one. Probes that are not answered are retried, then
+// considered too large, to detect routers that drop large packets silently.
+func (r *run) pathMTU() (mtu int, err error) {
+	c, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to listen for icmp: %v", err)
+	}
+	defer c.Close()
+	rc, err := ipv4.NewRawConn(c)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open raw socket: %v", err)
+	}
+	p := mtuProber{
+		conn:     rc,
+		dst:      net.ParseIP(r.Parameters.ipDest).To4(),
+		id:       os.Getpid() & 0xffff,
+		timeout:  time.Duration(r.Parameters.Timeout * float64(time.Second)),
+		attempts: int(r.Parameters.Count),
+	}
+	lo, hi := minMTU, interfaceMTU(p.dst)
+	size := hi
+	for lo <= hi {
+		fits, next, err := p.probe(size)
+		if err != nil {
+			return 0, err
+		}
+		if fits {
+			mtu = size
+			lo = size + 1
+		} else {
+			hi = size - 1
+		}
+		size = (lo + hi + 1) / 2
+		if !fits && next >= lo && next <= hi {
+			size = next
+		}
+	}
+	if mtu == 0 {
+		return 0, fmt.Errorf("destination did not answer probes of any size")
+	}
+	return mtu, nil
+}
+
+// probe sends a packet of a given size, retrying if it is not answered. It
+// returns true if the destination answered, and the MTU of the next hop if a
+// router reported the packet as too large.
+func (p *mtuProber) probe(size int) (fits bool, next int, err error) {
+	for i := 0; i < p.attempts; i++ {
+		var timeout bool
+		fits, next, timeout, err = p.send(size)
+		if err != nil || !timeout {
+			return
+		}
+	}
+	return false, 0, nil
+}
+
+func (p *mtuProber) send(size int) (fits bool, next int, timeout bool, err error) {
+	p.seq = (p.seq + 1) & 0xffff
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{
+			ID:   p.id,
+			Seq:  p.seq,
+			Data: make([]byte, size-ipv4.HeaderLen-8),
+		},
+	}
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return
+	}
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TotalLen: size,
+		TTL:      64,
+		Protocol: 1,
+		Flags:    ipv4.DontFragment,
+		Dst:      p.dst,
+	}
+	err = p.conn.WriteTo(h, wb, nil)
+	if err != nil {
+		// the packet is larger than the MTU of the local interface
+		if isMsgSize(err) {
+			return false, 0, false, nil
+		}
+		return false, 0, false, fmt.Errorf("failed to send probe: %v", err)
+	}
+	deadline := time.Now().Add(p.timeout)
+	buf := make([]byte, 65536)
+	for {
+		p.conn.SetReadDeadline(deadline)
+		_, payload, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return false, 0, true, nil
+			}
+			return false, 0, false, fmt.Errorf("failed to read icmp: %v", err)
+		}
+		reply, err := icmp.ParseMessage(1, payload)
+		if err != nil {
+			continue
+		}
+		switch body := reply.Body.(type) {
+		case *icmp.Echo:
+			if reply.Type == ipv4.ICMPTypeEchoReply && body.ID == p.id && body.Seq == p.seq {
+				return true, 0, false, nil
+			}
+		case *icmp.DstUnreach:
+			// code 4 is fragmentation needed, with the MTU of the next
+			// hop in the last two bytes of the icmp header
+			if reply.Code == 4 && len(payload) >= 8 && p.quotes(body.Data) {
+				return false, int(binary.BigEndian.Uint16(payload[6:8])), false, nil
+			}
+		}
+	}
+}
+
+// quotes returns true if an icmp error quotes the current probe
+func (p *mtuProber) quotes(data []byte) bool {
+	proto, dst, _, _, ok := parseQuoted(data)
+	if !ok || proto != 1 || !dst.Equal(p.dst) {
+		return false
+	}
+	hl := int(data[0]&0x0f) * 4
+	if len(data) < hl+8 {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(data[hl+4:])) == p.id &&
+		int(binary.BigEndian.Uint16(data[hl+6:])) == p.seq
+}
+
+func isMsgSize(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.EMSGSIZE
+}
+
+// interfaceMTU returns the MTU of the interface that routes packets to the
+// destination, or 1500 if it can't be found
+func interfaceMTU(dst net.IP) int {
+	// connecting a udp socket selects a route without sending anything
+	c, err := net.Dial("udp4", net.JoinHostPort(dst.String(), "9"))
+	if err != nil {
+		return 1500
+	}
+	defer c.Close()
+	local := c.LocalAddr().(*net.UDPAddr).IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 1500
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(local) {
+				// the total length of an ipv4 packet is 16 bits
+				if iface.MTU > 65535 {
+					return 65535
+				}
+				return iface.MTU
+			}
+		}
+	}
+	return 1500
+}
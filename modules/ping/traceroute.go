@@ -0,0 +1,278 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ping /* import "github.com/mozilla/mig/modules/ping" */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// hop is a router, or the destination, found at a given ttl by a traceroute
+type hop struct {
+	TTL       int       `json:"ttl"`
+	Addresses []string  `json:"addresses"` // addresses that answered the probes, empty if none did
+	Latencies []float64 `json:"latencies"` // latency of each probe in milliseconds, 9999999 indicates timeout
+}
+
+// traceProbe is a single probe sent by a traceroute, identified by the port
+// quoted in the icmp errors it triggers: the destination port of udp probes,
+// and the source port of tcp probes
+type traceProbe struct {
+	port    int
+	sent    time.Time
+	tcp     *tcpProbe
+	addr    string
+	latency float64
+	done    bool
+}
+
+// tracer holds the sockets of a traceroute
+type tracer struct {
+	dst       net.IP
+	port      int
+	probe     string
+	timeout   time.Duration
+	icmp      *icmp.PacketConn
+	icmpProto int
+	udp       *net.UDPConn
+	udpPort   int
+	seq       int
+}
+
+// traceroute sends probes with increasing ttl to the destination and records
+// the hosts that return icmp time exceeded errors, until the destination is
+// reached, a host reports it as unreachable, or the maximum number of hops is
+// reached
+func (r *run) traceroute() (hops []hop, reached bool, err error) {
+	t := tracer{
+		dst:     net.ParseIP(r.Parameters.ipDest),
+		port:    int(r.Parameters.DestinationPort),
+		probe:   r.Parameters.Probe,
+		timeout: time.Duration(r.Parameters.Timeout * float64(time.Second)),
+	}
+	if t.dst.To4() != nil {
+		t.icmp, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		t.icmpProto = 1
+	} else {
+		t.icmp, err = icmp.ListenPacket("ip6:ipv6-icmp", "::")
+		t.icmpProto = 58
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to listen for icmp: %v", err)
+	}
+	defer t.icmp.Close()
+	if t.probe == "udp" {
+		t.udp, err = net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open udp socket: %v", err)
+		}
+		defer t.udp.Close()
+		t.udpPort = t.udp.LocalAddr().(*net.UDPAddr).Port
+	}
+	for ttl := 1; ttl <= int(r.Parameters.MaxHops); ttl++ {
+		h, stop, err := t.probeHop(ttl, int(r.Parameters.Count))
+		if err != nil {
+			return hops, false, err
+		}
+		hops = append(hops, h)
+		for _, addr := range h.Addresses {
+			if net.ParseIP(addr).Equal(t.dst) {
+				reached = true
+			}
+		}
+		if reached || stop {
+			break
+		}
+	}
+	return
+}
+
+// probeHop sends count probes with a given ttl and waits for their answers. It
+// returns true if the destination, or a host reporting it unreachable,
+// answered.
+func (t *tracer) probeHop(ttl, count int) (h hop, stop bool, err error) {
+	h.TTL = ttl
+	probes := make([]*traceProbe, 0, count)
+	defer func() {
+		for _, p := range probes {
+			if p.tcp != nil {
+				p.tcp.close()
+			}
+		}
+	}()
+	for i := 0; i < count; i++ {
+		p := &traceProbe{latency: 9999999}
+		switch t.probe {
+		case "udp":
+			err = t.sendUDP(p, ttl)
+		case "tcp":
+			p.tcp, err = newTCPProbe(t.dst, t.port, ttl)
+			if err == nil {
+				p.port = p.tcp.port
+			}
+		}
+		if err != nil {
+			return
+		}
+		p.sent = time.Now()
+		probes = append(probes, p)
+	}
+	deadline := time.Now().Add(t.timeout)
+	buf := make([]byte, 1500)
+	for pending(probes) && time.Now().Before(deadline) {
+		// tcp probes are also answered by the establishment or the refusal
+		// of the connection, so check them regularly
+		wait := deadline
+		if t.probe == "tcp" {
+			wait = time.Now().Add(10 * time.Millisecond)
+			if wait.After(deadline) {
+				wait = deadline
+			}
+		}
+		t.icmp.SetReadDeadline(wait)
+		n, peer, err := t.icmp.ReadFrom(buf)
+		if err == nil {
+			if t.handleICMP(probes, buf[:n], peer) {
+				stop = true
+			}
+		} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return h, stop, fmt.Errorf("failed to read icmp: %v", err)
+		}
+		for _, p := range probes {
+			if !p.done && p.tcp != nil && p.tcp.connected() {
+				p.done = true
+				p.addr = t.dst.String()
+				p.latency = time.Since(p.sent).Seconds() * 1000
+			}
+		}
+	}
+	for _, p := range probes {
+		h.Latencies = append(h.Latencies, p.latency)
+		if p.addr != "" && !contains(h.Addresses, p.addr) {
+			h.Addresses = append(h.Addresses, p.addr)
+		}
+	}
+	if h.Addresses == nil {
+		h.Addresses = []string{}
+	}
+	return h, stop, nil
+}
+
+// sendUDP sends a udp probe to a port that is unique to the probe, starting at
+// the destination port
+func (t *tracer) sendUDP(p *traceProbe, ttl int) (err error) {
+	p.port = t.port + t.seq
+	t.seq++
+	if p.port > 65535 {
+		return fmt.Errorf("no destination port left for probe at ttl %d", ttl)
+	}
+	if t.dst.To4() != nil {
+		err = ipv4.NewPacketConn(t.udp).SetTTL(ttl)
+	} else {
+		err = ipv6.NewPacketConn(t.udp).SetHopLimit(ttl)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set ttl: %v", err)
+	}
+	_, err = t.udp.WriteTo([]byte("MIGTraceroute"), &net.UDPAddr{IP: t.dst, Port: p.port})
+	if err != nil {
+		return fmt.Errorf("failed to send udp probe: %v", err)
+	}
+	return nil
+}
+
+// handleICMP matches an icmp error with the probe that triggered it, and
+// returns true if the error indicates the destination is unreachable
+func (t *tracer) handleICMP(probes []*traceProbe, b []byte, peer net.Addr) (unreach bool) {
+	msg, err := icmp.ParseMessage(t.icmpProto, b)
+	if err != nil {
+		return
+	}
+	var data []byte
+	switch body := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.DstUnreach:
+		data = body.Data
+		unreach = true
+	default:
+		return false
+	}
+	proto, dst, srcPort, dstPort, ok := parseQuoted(data)
+	if !ok || !dst.Equal(t.dst) {
+		return false
+	}
+	port := dstPort
+	switch {
+	case t.probe == "udp" && proto == 17 && srcPort == t.udpPort:
+	case t.probe == "tcp" && proto == 6 && dstPort == t.port:
+		port = srcPort
+	default:
+		return false
+	}
+	for _, p := range probes {
+		if p.port == port && !p.done {
+			p.done = true
+			p.addr = peer.String()
+			p.latency = time.Since(p.sent).Seconds() * 1000
+			return unreach
+		}
+	}
+	return false
+}
+
+// parseQuoted returns the protocol, the destination and the ports of the ipv4
+// or ipv6 packet quoted in an icmp error
+func parseQuoted(data []byte) (proto int, dst net.IP, srcPort, dstPort int, ok bool) {
+	if len(data) < 1 {
+		return
+	}
+	switch data[0] >> 4 {
+	case 4:
+		hl := int(data[0]&0x0f) * 4
+		if hl < ipv4.HeaderLen || len(data) < hl+4 {
+			return
+		}
+		proto = int(data[9])
+		dst = net.IP(data[16:20])
+		data = data[hl:]
+	case 6:
+		if len(data) < ipv6.HeaderLen+4 {
+			return
+		}
+		proto = int(data[6])
+		dst = net.IP(data[24:40])
+		data = data[ipv6.HeaderLen:]
+	default:
+		return
+	}
+	srcPort = int(binary.BigEndian.Uint16(data[0:2]))
+	dstPort = int(binary.BigEndian.Uint16(data[2:4]))
+	return proto, dst, srcPort, dstPort, true
+}
+
+func pending(probes []*traceProbe) bool {
+	for _, p := range probes {
+		if !p.done {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package ping /* import "github.com/mozilla/mig/modules/ping" */
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// tcpProbe is a non blocking connection attempt, sent with a given ttl. The
+// connection is never completed by the module: it is closed as soon as the
+// destination accepts or refuses it.
+type tcpProbe struct {
+	fd   int
+	port int // local port, quoted in the icmp errors sent by routers
+}
+
+func newTCPProbe(dst net.IP, port, ttl int) (p *tcpProbe, err error) {
+	var (
+		sa     syscall.Sockaddr
+		family int
+	)
+	if ip4 := dst.To4(); ip4 != nil {
+		sa4 := &syscall.SockaddrInet4{Port: port}
+		copy(sa4.Addr[:], ip4)
+		sa, family = sa4, syscall.AF_INET
+	} else {
+		sa6 := &syscall.SockaddrInet6{Port: port}
+		copy(sa6.Addr[:], dst.To16())
+		sa, family = sa6, syscall.AF_INET6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tcp socket: %v", err)
+	}
+	p = &tcpProbe{fd: fd}
+	defer func() {
+		if err != nil {
+			p.close()
+			p = nil
+		}
+	}()
+	err = syscall.SetNonblock(fd, true)
+	if err != nil {
+		return
+	}
+	if family == syscall.AF_INET {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+	} else {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+	}
+	if err != nil {
+		return p, fmt.Errorf("failed to set ttl: %v", err)
+	}
+	err = syscall.Connect(fd, sa)
+	if err != nil && err != syscall.EINPROGRESS {
+		return p, fmt.Errorf("failed to send tcp probe: %v", err)
+	}
+	local, err := syscall.Getsockname(fd)
+	if err != nil {
+		return
+	}
+	switch local := local.(type) {
+	case *syscall.SockaddrInet4:
+		p.port = local.Port
+	case *syscall.SockaddrInet6:
+		p.port = local.Port
+	}
+	return p, nil
+}
+
+// connected returns true if the destination answered the probe, by accepting
+// or refusing the connection
+func (p *tcpProbe) connected() bool {
+	errno, err := syscall.GetsockoptInt(p.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && syscall.Errno(errno) == syscall.ECONNREFUSED {
+		return true
+	}
+	_, err = syscall.Getpeername(p.fd)
+	return err == nil
+}
+
+func (p *tcpProbe) close() {
+	syscall.Close(p.fd)
+}
@@ -0,0 +1,66 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package ping /* import "github.com/mozilla/mig/modules/ping" */
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// traceroutes and path mtu discovery use raw sockets, and can only be tested
+// as root
+func newLocalRun(t *testing.T, p params) run {
+	if os.Geteuid() != 0 {
+		t.Skip("raw sockets require root")
+	}
+	r := run{Parameters: p}
+	err := r.ValidateParameters()
+	if err != nil {
+		t.Fatalf("ValidateParameters: %v", err)
+	}
+	return r
+}
+
+func TestTracerouteUDP(t *testing.T) {
+	r := newLocalRun(t, params{Destination: "127.0.0.1", Protocol: "traceroute", Timeout: 2})
+	hops, reached, err := r.traceroute()
+	if err != nil {
+		t.Fatalf("traceroute: %v", err)
+	}
+	if !reached || len(hops) != 1 || hops[0].Addresses[0] != "127.0.0.1" || len(hops[0].Latencies) != 3 {
+		t.Fatalf("unexpected traceroute to localhost: %+v", hops)
+	}
+}
+
+func TestTracerouteTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	r := newLocalRun(t, params{Destination: "127.0.0.1", Protocol: "traceroute", Probe: "tcp", Count: 1,
+		DestinationPort: float64(ln.Addr().(*net.TCPAddr).Port), Timeout: 2})
+	hops, reached, err := r.traceroute()
+	if err != nil {
+		t.Fatalf("traceroute: %v", err)
+	}
+	if !reached || len(hops) != 1 || hops[0].Latencies[0] == 9999999 {
+		t.Fatalf("unexpected traceroute to localhost: %+v", hops)
+	}
+}
+
+func TestPathMTU(t *testing.T) {
+	r := newLocalRun(t, params{Destination: "127.0.0.1", Protocol: "pmtu", Timeout: 1})
+	mtu, err := r.pathMTU()
+	if err != nil {
+		t.Fatalf("pathMTU: %v", err)
+	}
+	if mtu != interfaceMTU(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("unexpected path mtu to localhost %d", mtu)
+	}
+}
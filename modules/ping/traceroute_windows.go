@@ -0,0 +1,26 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ping /* import "github.com/mozilla/mig/modules/ping" */
+
+import (
+	"fmt"
+	"net"
+)
+
+// tcpProbe is not implemented on Windows, only udp traceroutes are supported
+type tcpProbe struct {
+	port int
+}
+
+func newTCPProbe(dst net.IP, port, ttl int) (*tcpProbe, error) {
+	return nil, fmt.Errorf("tcp traceroute is not supported on windows")
+}
+
+func (p *tcpProbe) connected() bool {
+	return false
+}
+
+func (p *tcpProbe) close() {
+}
@@ -0,0 +1,472 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pkg /* import "github.com/mozilla/mig/modules/pkg" */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	scribelib "github.com/mozilla/scribe"
+)
+
+// Advisory describes the versions of a package affected by a vulnerability
+type Advisory struct {
+	ID       string         `json:"id"`             // Identifier of the vulnerability, usually a CVE
+	Package  string         `json:"package"`        // Name of the package
+	Type     string         `json:"type,omitempty"` // Package type (rpm, dpkg or apk), any type if empty
+	Affected []VersionRange `json:"affected"`       // Affected versions
+}
+
+// VersionRange is a range of affected versions. Versions are compared using
+// the ordering of the package manager of the installed package. A range with
+// no bound affects all versions.
+type VersionRange struct {
+	Introduced   string `json:"introduced,omitempty"`   // First affected version, all versions if empty
+	Fixed        string `json:"fixed,omitempty"`        // First fixed version, if any
+	LastAffected string `json:"lastaffected,omitempty"` // Last affected version, if any
+}
+
+// AdvisoryResult is the result of the evaluation of an advisory
+type AdvisoryResult struct {
+	ID         string             `json:"id"`
+	Package    string             `json:"package"`
+	Vulnerable bool               `json:"vulnerable"`          // True if any installed version is affected
+	Installed  []InstalledVersion `json:"installed,omitempty"` // Installed versions of the package
+}
+
+// InstalledVersion is an installed version of a package named in an advisory
+type InstalledVersion struct {
+	Version    string `json:"version"`
+	Type       string `json:"type"`
+	Arch       string `json:"arch"`
+	Vulnerable bool   `json:"vulnerable"`
+}
+
+var advisoryTypes = map[string]bool{"": true, "rpm": true, "dpkg": true, "apk": true}
+
+func (a Advisory) validate() error {
+	if a.ID == "" || a.Package == "" {
+		return fmt.Errorf("advisories must have an id and a package")
+	}
+	if !advisoryTypes[a.Type] {
+		return fmt.Errorf("advisory %s has invalid package type '%s'", a.ID, a.Type)
+	}
+	if len(a.Affected) == 0 {
+		return fmt.Errorf("advisory %s has no affected versions", a.ID)
+	}
+	if a.Type == "" {
+		return nil
+	}
+	for _, r := range a.Affected {
+		for _, v := range []string{r.Introduced, r.Fixed, r.LastAffected} {
+			if v == "" || v == "0" {
+				continue
+			}
+			if _, err := compareVersions(a.Type, v, v); err != nil {
+				return fmt.Errorf("advisory %s: %v", a.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesName returns true if an installed package is the package of the
+// advisory. dpkg can report the architecture after the name of multiarch
+// packages, such as libc6:amd64.
+func (a Advisory) matchesName(p scribelib.PackageInfo) bool {
+	if a.Type != "" && a.Type != p.Type {
+		return false
+	}
+	name := p.Name
+	if p.Type == "dpkg" {
+		name = strings.SplitN(name, ":", 2)[0]
+	}
+	return name == a.Package
+}
+
+// affects returns true if a version of a package of the given type is within
+// one of the affected ranges of the advisory
+func (a Advisory) affects(pkgtype, version string) (bool, error) {
+	for _, r := range a.Affected {
+		in, err := r.contains(pkgtype, version)
+		if err != nil || in {
+			return in, err
+		}
+	}
+	return false, nil
+}
+
+func (r VersionRange) contains(pkgtype, version string) (bool, error) {
+	if r.Introduced != "" && r.Introduced != "0" {
+		c, err := compareVersions(pkgtype, version, r.Introduced)
+		if err != nil || c < 0 {
+			return false, err
+		}
+	}
+	if r.Fixed != "" {
+		c, err := compareVersions(pkgtype, version, r.Fixed)
+		if err != nil || c >= 0 {
+			return false, err
+		}
+	}
+	if r.LastAffected != "" {
+		c, err := compareVersions(pkgtype, version, r.LastAffected)
+		if err != nil || c > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// evaluateAdvisory compares the installed packages with an advisory. Errors
+// are returned when a version can't be compared, and the version is then
+// considered not vulnerable.
+func evaluateAdvisory(a Advisory, pkglist []scribelib.PackageInfo) (res AdvisoryResult, errs []string) {
+	res.ID = a.ID
+	res.Package = a.Package
+	for _, p := range pkglist {
+		if !a.matchesName(p) {
+			continue
+		}
+		iv := InstalledVersion{Version: p.Version, Type: p.Type, Arch: p.Arch}
+		vuln, err := a.affects(p.Type, p.Version)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("advisory %s: %v", a.ID, err))
+		}
+		iv.Vulnerable = vuln
+		res.Vulnerable = res.Vulnerable || vuln
+		res.Installed = append(res.Installed, iv)
+	}
+	return
+}
+
+// apkInstalledDB is the database of installed packages on Alpine systems
+var apkInstalledDB = "/lib/apk/db/installed"
+
+// apkPackages returns the packages installed by apk, which are not reported
+// by scribe
+func apkPackages() (ret []scribelib.PackageInfo) {
+	fd, err := os.Open(apkInstalledDB)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	var p scribelib.PackageInfo
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if p.Name != "" {
+				ret = append(ret, p)
+			}
+			p = scribelib.PackageInfo{}
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		switch line[0] {
+		case 'P':
+			p.Name = line[2:]
+			p.Type = "apk"
+		case 'V':
+			p.Version = line[2:]
+		case 'A':
+			p.Arch = line[2:]
+		}
+	}
+	if p.Name != "" {
+		ret = append(ret, p)
+	}
+	return
+}
+
+// LoadAdvisories reads advisories from a vulnerability feed. Feeds can be OVAL
+// definitions in XML, such as the ones published by Red Hat and Ubuntu, or OSV
+// vulnerabilities in JSON, as a single vulnerability, a list, or an object with
+// a "vulns" list.
+func LoadAdvisories(path string) (advs []Advisory, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	buf = bytes.TrimSpace(buf)
+	if bytes.HasPrefix(buf, []byte("<")) {
+		advs, err = parseOVAL(buf)
+	} else {
+		advs, err = parseOSV(buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if len(advs) == 0 {
+		return nil, fmt.Errorf("no package advisory found in %s", path)
+	}
+	return
+}
+
+type osvVuln struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced   string `json:"introduced"`
+				Fixed        string `json:"fixed"`
+				LastAffected string `json:"last_affected"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+// osvPackageType returns the package type of an OSV ecosystem, such as
+// Debian:11 or Alpine:v3.16, or an empty string if the ecosystem is not a
+// distribution supported by the module
+func osvPackageType(ecosystem string) string {
+	switch strings.SplitN(ecosystem, ":", 2)[0] {
+	case "Debian", "Ubuntu":
+		return "dpkg"
+	case "Alpine":
+		return "apk"
+	case "Red Hat", "Rocky Linux", "AlmaLinux", "SUSE", "openSUSE", "Mageia":
+		return "rpm"
+	}
+	return ""
+}
+
+// osvIDs returns the CVE identifiers of a vulnerability, or its OSV identifier
+// if it has none
+func osvIDs(v osvVuln) (ids []string) {
+	for _, id := range append([]string{v.ID}, v.Aliases...) {
+		if strings.HasPrefix(id, "CVE-") {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		ids = []string{v.ID}
+	}
+	return
+}
+
+func parseOSV(buf []byte) (advs []Advisory, err error) {
+	var vulns []osvVuln
+	switch {
+	case bytes.HasPrefix(buf, []byte("[")):
+		err = json.Unmarshal(buf, &vulns)
+	default:
+		var doc struct {
+			osvVuln
+			Vulns []osvVuln `json:"vulns"`
+		}
+		err = json.Unmarshal(buf, &doc)
+		vulns = doc.Vulns
+		if doc.ID != "" {
+			vulns = append(vulns, doc.osvVuln)
+		}
+	}
+	if err != nil {
+		return
+	}
+	for _, v := range vulns {
+		for _, aff := range v.Affected {
+			pkgtype := osvPackageType(aff.Package.Ecosystem)
+			if pkgtype == "" {
+				continue
+			}
+			var ranges []VersionRange
+			for _, r := range aff.Ranges {
+				if r.Type != "ECOSYSTEM" {
+					continue
+				}
+				var cur *VersionRange
+				for _, e := range r.Events {
+					switch {
+					case e.Introduced != "":
+						if cur != nil {
+							ranges = append(ranges, *cur)
+						}
+						cur = &VersionRange{Introduced: e.Introduced}
+					case e.Fixed != "" && cur != nil:
+						cur.Fixed = e.Fixed
+						ranges = append(ranges, *cur)
+						cur = nil
+					case e.LastAffected != "" && cur != nil:
+						cur.LastAffected = e.LastAffected
+						ranges = append(ranges, *cur)
+						cur = nil
+					}
+				}
+				if cur != nil {
+					ranges = append(ranges, *cur)
+				}
+			}
+			if len(ranges) == 0 {
+				continue
+			}
+			for _, id := range osvIDs(v) {
+				advs = append(advs, Advisory{
+					ID:       id,
+					Package:  aff.Package.Name,
+					Type:     pkgtype,
+					Affected: ranges,
+				})
+			}
+		}
+	}
+	return
+}
+
+// The OVAL structures only describe the elements used by the module. Tests,
+// objects and states are matched by their local name, regardless of the schema
+// of the distribution that defines them.
+type ovalDefinitions struct {
+	Definitions []ovalDefinition `xml:"definitions>definition"`
+	Tests       struct {
+		Items []ovalTest `xml:",any"`
+	} `xml:"tests"`
+	Objects struct {
+		Items []ovalObject `xml:",any"`
+	} `xml:"objects"`
+	States struct {
+		Items []ovalState `xml:",any"`
+	} `xml:"states"`
+}
+
+type ovalDefinition struct {
+	ID         string `xml:"id,attr"`
+	References []struct {
+		Source string `xml:"source,attr"`
+		RefID  string `xml:"ref_id,attr"`
+	} `xml:"metadata>reference"`
+	Criteria ovalCriteria `xml:"criteria"`
+}
+
+type ovalCriteria struct {
+	Criteria  []ovalCriteria `xml:"criteria"`
+	Criterion []struct {
+		TestRef string `xml:"test_ref,attr"`
+	} `xml:"criterion"`
+}
+
+// testRefs returns the tests referenced by the criteria and its children
+func (c ovalCriteria) testRefs() (refs []string) {
+	for _, crit := range c.Criterion {
+		refs = append(refs, crit.TestRef)
+	}
+	for _, child := range c.Criteria {
+		refs = append(refs, child.testRefs()...)
+	}
+	return
+}
+
+type ovalTest struct {
+	XMLName xml.Name
+	ID      string `xml:"id,attr"`
+	Object  struct {
+		Ref string `xml:"object_ref,attr"`
+	} `xml:"object"`
+	State struct {
+		Ref string `xml:"state_ref,attr"`
+	} `xml:"state"`
+}
+
+type ovalObject struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name"`
+}
+
+type ovalState struct {
+	ID  string `xml:"id,attr"`
+	EVR struct {
+		Operation string `xml:"operation,attr"`
+		Value     string `xml:",chardata"`
+	} `xml:"evr"`
+}
+
+// parseOVAL converts the package tests of OVAL definitions into advisories.
+// Only the tests that compare the version of a package with a fixed version,
+// using the "less than" operation, are used. Other criteria, such as the
+// version of the distribution, are ignored, so actions should target the
+// distribution the definitions were written for.
+func parseOVAL(buf []byte) (advs []Advisory, err error) {
+	var doc ovalDefinitions
+	err = xml.Unmarshal(buf, &doc)
+	if err != nil {
+		return
+	}
+	objects := make(map[string]string)
+	for _, o := range doc.Objects.Items {
+		objects[o.ID] = strings.TrimSpace(o.Name)
+	}
+	states := make(map[string]string)
+	for _, s := range doc.States.Items {
+		if s.EVR.Operation == "less than" {
+			states[s.ID] = strings.TrimSpace(s.EVR.Value)
+		}
+	}
+	type pkgtest struct {
+		name, pkgtype, fixed string
+	}
+	tests := make(map[string]pkgtest)
+	for _, t := range doc.Tests.Items {
+		var pkgtype string
+		switch t.XMLName.Local {
+		case "rpminfo_test":
+			pkgtype = "rpm"
+		case "dpkginfo_test":
+			pkgtype = "dpkg"
+		default:
+			continue
+		}
+		name, fixed := objects[t.Object.Ref], states[t.State.Ref]
+		if name == "" || fixed == "" {
+			continue
+		}
+		tests[t.ID] = pkgtest{name: name, pkgtype: pkgtype, fixed: fixed}
+	}
+	seen := make(map[string]bool)
+	for _, d := range doc.Definitions {
+		var ids []string
+		for _, r := range d.References {
+			if r.Source == "CVE" {
+				ids = append(ids, r.RefID)
+			}
+		}
+		if len(ids) == 0 {
+			ids = []string{d.ID}
+		}
+		for _, ref := range d.Criteria.testRefs() {
+			t, ok := tests[ref]
+			if !ok {
+				continue
+			}
+			for _, id := range ids {
+				key := id + "|" + t.name + "|" + t.pkgtype + "|" + t.fixed
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				advs = append(advs, Advisory{
+					ID:       id,
+					Package:  t.name,
+					Type:     t.pkgtype,
+					Affected: []VersionRange{{Fixed: t.fixed}},
+				})
+			}
+		}
+	}
+	return
+}
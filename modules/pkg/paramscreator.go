@@ -28,7 +28,13 @@ func printHelp(isCmd bool) {
 %sversion <regexp>  - Version string search, use !<regexp> to invert it
                     ex: version ^1\..*
 		    optionally filter returned packages to include or exclude version
-`, dash, dash)
+
+%sadvisories <file> - Evaluate advisories from a vulnerability feed
+                    ex: advisories com.redhat.rhsa-RHEL7.xml
+		    compare installed packages with the fixed versions of an OVAL
+		    definitions file, or OSV vulnerabilities in JSON, and report
+		    each CVE as vulnerable or not vulnerable
+`, dash, dash, dash)
 }
 
 func (r *run) ParamsCreator() (interface{}, error) {
@@ -64,6 +70,14 @@ func (r *run) ParamsCreator() (interface{}, error) {
 			p.PkgMatch.Matches = append(p.PkgMatch.Matches, checkValue)
 		case "version":
 			p.VerMatch = checkValue
+		case "advisories":
+			advs, err := LoadAdvisories(checkValue)
+			if err != nil {
+				fmt.Printf("ERROR: %v\nTry again.\n", err)
+				continue
+			}
+			fmt.Printf("loaded %d advisories\n", len(advs))
+			p.Advisories = append(p.Advisories, advs...)
 		default:
 			fmt.Printf("Invalid method!\nTry 'help'\n")
 			continue
@@ -78,8 +92,9 @@ exit:
 func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
 		fs       flag.FlagSet
-		pkgMatch flagParam
-		verMatch string
+		pkgMatch   flagParam
+		verMatch   string
+		advisories flagParam
 	)
 
 	if len(args) < 1 || args[0] == "" || args[0] == "help" {
@@ -90,6 +105,7 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.Init("pkg", flag.ContinueOnError)
 	fs.Var(&pkgMatch, "name", "see help")
 	fs.StringVar(&verMatch, "version", "", "see help")
+	fs.Var(&advisories, "advisories", "see help")
 	err := fs.Parse(args)
 	if err != nil {
 		return nil, err
@@ -100,6 +116,13 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	if verMatch != "" {
 		p.VerMatch = verMatch
 	}
+	for _, path := range advisories {
+		advs, err := LoadAdvisories(path)
+		if err != nil {
+			return nil, err
+		}
+		p.Advisories = append(p.Advisories, advs...)
+	}
 
 	r.Parameters = *p
 
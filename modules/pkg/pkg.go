@@ -55,6 +55,11 @@ func buildResults(e elements, r *modules.Result) (buf []byte, err error) {
 	if len(e.Packages) > 0 {
 		r.FoundAnything = true
 	}
+	for _, a := range e.Advisories {
+		if a.Vulnerable {
+			r.FoundAnything = true
+		}
+	}
 	endCounters()
 	r.Statistics = stats
 	buf, err = json.Marshal(r)
@@ -94,7 +99,7 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 
 	e := &elements{}
 	e.Packages = make([]scribelib.PackageInfo, 0)
-	pkglist := scribelib.QueryPackages()
+	pkglist := installedPackages()
 	for _, x := range r.Parameters.PkgMatch.Matches {
 		re, err := regexp.Compile(x)
 		if err != nil {
@@ -130,6 +135,11 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 			e.Packages = append(e.Packages, y)
 		}
 	}
+	for _, a := range r.Parameters.Advisories {
+		res, errs := evaluateAdvisory(a, pkglist)
+		e.Advisories = append(e.Advisories, res)
+		r.Results.Errors = append(r.Results.Errors, errs...)
+	}
 	buf, err := buildResults(*e, &r.Results)
 	if err != nil {
 		panic(err)
@@ -138,9 +148,21 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	return
 }
 
+// installedPackages returns the packages installed on the system, it is a
+// variable so tests can provide their own list
+var installedPackages = func() []scribelib.PackageInfo {
+	return append(scribelib.QueryPackages(), apkPackages()...)
+}
+
 func (r *run) ValidateParameters() (err error) {
-	if len(r.Parameters.PkgMatch.Matches) == 0 {
-		return fmt.Errorf("must specify at least one package to match")
+	if len(r.Parameters.PkgMatch.Matches) == 0 && len(r.Parameters.Advisories) == 0 {
+		return fmt.Errorf("must specify at least one package to match or one advisory")
+	}
+	for _, a := range r.Parameters.Advisories {
+		err = a.validate()
+		if err != nil {
+			return err
+		}
 	}
 	// Make sure all package match parameters are valid expressions.
 	for _, x := range r.Parameters.PkgMatch.Matches {
@@ -182,6 +204,26 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 			x.Type, x.Arch)
 		prints = append(prints, resStr)
 	}
+	for _, a := range elem.Advisories {
+		if foundOnly && !a.Vulnerable {
+			continue
+		}
+		status := "not vulnerable"
+		if a.Vulnerable {
+			status = "vulnerable"
+		}
+		if len(a.Installed) == 0 {
+			prints = append(prints, fmt.Sprintf("advisory %v %v package=%v not installed",
+				a.ID, status, a.Package))
+		}
+		for _, x := range a.Installed {
+			if foundOnly && !x.Vulnerable {
+				continue
+			}
+			prints = append(prints, fmt.Sprintf("advisory %v %v package=%v version=%v type=%v arch=%v vulnerable=%v",
+				a.ID, status, a.Package, x.Version, x.Type, x.Arch, x.Vulnerable))
+		}
+	}
 
 	if !foundOnly {
 		for _, we := range result.Errors {
@@ -194,8 +236,49 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	return
 }
 
+// FlattenResults returns one row per package that matched the query
+func (r *run) FlattenResults(result modules.Result, foundOnly bool) (rows []modules.ResultRow, err error) {
+	var elem elements
+	err = result.GetElements(&elem)
+	if err != nil {
+		return
+	}
+	for _, x := range elem.Packages {
+		rows = append(rows, modules.ResultRow{
+			"name":    x.Name,
+			"version": x.Version,
+			"type":    x.Type,
+			"arch":    x.Arch,
+		})
+	}
+	for _, a := range elem.Advisories {
+		if foundOnly && !a.Vulnerable {
+			continue
+		}
+		if len(a.Installed) == 0 {
+			rows = append(rows, modules.ResultRow{
+				"advisory":   a.ID,
+				"name":       a.Package,
+				"vulnerable": false,
+			})
+		}
+		for _, x := range a.Installed {
+			rows = append(rows, modules.ResultRow{
+				"advisory":   a.ID,
+				"name":       a.Package,
+				"version":    x.Version,
+				"type":       x.Type,
+				"arch":       x.Arch,
+				"vulnerable": x.Vulnerable,
+			})
+		}
+	}
+	return
+}
+
 type elements struct {
-	Packages []scribelib.PackageInfo `json:"packages"` // Results of package query.
+	Packages   []scribelib.PackageInfo `json:"packages"`             // Results of package query.
+	Advisories []AdvisoryResult        `json:"advisories,omitempty"` // Results of advisory evaluation.
 }
 
 type Statistics struct {
@@ -203,8 +286,9 @@ type Statistics struct {
 }
 
 type Parameters struct {
-	PkgMatch PkgMatch `json:"pkgmatch"` // List of strings to use as regexp package matches.
-	VerMatch string   `json:"vermatch"` // Optionally filter returned packages on version string
+	PkgMatch   PkgMatch   `json:"pkgmatch"`             // List of strings to use as regexp package matches.
+	VerMatch   string     `json:"vermatch"`             // Optionally filter returned packages on version string
+	Advisories []Advisory `json:"advisories,omitempty"` // Advisories to evaluate against installed packages
 }
 
 type PkgMatch struct {
@@ -7,10 +7,188 @@
 package pkg /* import "github.com/mozilla/mig/modules/pkg" */
 
 import (
+	"bytes"
+	"encoding/json"
+	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/testutil"
+	scribelib "github.com/mozilla/scribe"
 	"testing"
 )
 
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "pkg")
 }
+
+func TestCompareVersions(t *testing.T) {
+	var testcases = []struct {
+		pkgtype, a, b string
+		expect        int
+	}{
+		{"rpm", "2.12-1.149.el6_6.5", "2.12-1.149.el6_6.5", 0},
+		{"rpm", "2.12-1.149.el6", "2.12-1.149.el6_6.5", -1},
+		{"rpm", "2.12-1.149.el6_6.9", "2.12-1.149.el6_6.10", -1},
+		{"rpm", "0:2.12-1.149.el6_6.5", "2.12-1.149.el6_6.5", 0},
+		{"rpm", "1:1.0-1", "2.0-1", 1},
+		{"rpm", "2.17-55.93.amzn1", "2.17-55.140.amzn1", -1},
+		{"rpm", "1.0a", "1.0", 1},
+		{"rpm", "1.0", "1.0.1", -1},
+		{"rpm", "1.0~rc1", "1.0", -1},
+		{"rpm", "1.0^git1", "1.0", 1},
+		{"rpm", "1.0^git1", "1.0.1", -1},
+		{"rpm", "1.0-2", "1.0", 0},
+		{"rpm", "1.010", "1.9", 1},
+		{"rpm", "1.a", "1.1", -1},
+		{"dpkg", "2.15-0ubuntu10.10", "2.15-0ubuntu10.9", 1},
+		{"dpkg", "2.15-0ubuntu10.10", "2.15-0ubuntu10.10", 0},
+		{"dpkg", "1.0~rc1-1", "1.0-1", -1},
+		{"dpkg", "1:0.9", "2.0", 1},
+		{"dpkg", "2.13-38+deb7u6", "2.13-38+deb7u7", -1},
+		{"dpkg", "1.0", "1.0-0", 0},
+		{"dpkg", "1.0a", "1.0+", -1},
+		{"apk", "1.1.1n-r0", "1.1.1m-r1", 1},
+		{"apk", "1.2_rc1-r0", "1.2-r0", -1},
+		{"apk", "1.2_p1", "1.2", 1},
+		{"apk", "1.2.10", "1.2.9", 1},
+		{"apk", "3.0.1-r0", "3.0.1-r2", -1},
+	}
+	for _, tc := range testcases {
+		c, err := compareVersions(tc.pkgtype, tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("compareVersions(%s, %s, %s): %v", tc.pkgtype, tc.a, tc.b, err)
+		}
+		if c != tc.expect {
+			t.Fatalf("compareVersions(%s, %s, %s): expected %d, got %d",
+				tc.pkgtype, tc.a, tc.b, tc.expect, c)
+		}
+		c, _ = compareVersions(tc.pkgtype, tc.b, tc.a)
+		if c != -tc.expect {
+			t.Fatalf("compareVersions(%s, %s, %s) is not symmetric", tc.pkgtype, tc.b, tc.a)
+		}
+	}
+	if _, err := compareVersions("apk", "1.0_foo", "1.0"); err == nil {
+		t.Fatalf("invalid apk version was accepted")
+	}
+	if _, err := compareVersions("msi", "1.0", "1.0"); err == nil {
+		t.Fatalf("invalid package type was accepted")
+	}
+}
+
+func TestLoadOSV(t *testing.T) {
+	advs, err := LoadAdvisories("./testdata/osv.json")
+	if err != nil {
+		t.Fatalf("LoadAdvisories: %v", err)
+	}
+	if len(advs) != 2 {
+		t.Fatalf("expected 2 advisories, got %+v", advs)
+	}
+	if advs[0].ID != "CVE-2015-0235" || advs[0].Package != "eglibc" || advs[0].Type != "dpkg" ||
+		len(advs[0].Affected) != 1 || advs[0].Affected[0].Fixed != "2.13-38+deb7u7" {
+		t.Fatalf("unexpected advisory %+v", advs[0])
+	}
+	if advs[1].ID != "CVE-2022-0778" || advs[1].Type != "apk" || len(advs[1].Affected) != 2 ||
+		advs[1].Affected[1].Introduced != "3.0.0-r0" || advs[1].Affected[1].LastAffected != "3.0.1-r0" {
+		t.Fatalf("unexpected advisory %+v", advs[1])
+	}
+}
+
+func TestLoadOVAL(t *testing.T) {
+	advs, err := LoadAdvisories("./testdata/oval.xml")
+	if err != nil {
+		t.Fatalf("LoadAdvisories: %v", err)
+	}
+	if len(advs) != 2 {
+		t.Fatalf("expected 2 advisories, got %+v", advs)
+	}
+	for i, name := range []string{"glibc", "glibc-common"} {
+		a := advs[i]
+		if a.ID != "CVE-2015-0235" || a.Package != name || a.Type != "rpm" ||
+			len(a.Affected) != 1 || a.Affected[0].Fixed != "0:2.12-1.149.el6_6.5" {
+			t.Fatalf("unexpected advisory %+v", a)
+		}
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	var invalid = []Parameters{
+		{},
+		{Advisories: []Advisory{{Package: "glibc", Affected: []VersionRange{{}}}}},
+		{Advisories: []Advisory{{ID: "CVE-2015-0235", Package: "glibc"}}},
+		{Advisories: []Advisory{{ID: "CVE-2015-0235", Package: "glibc", Type: "msi",
+			Affected: []VersionRange{{Fixed: "1.0"}}}}},
+		{Advisories: []Advisory{{ID: "CVE-2022-0778", Package: "openssl", Type: "apk",
+			Affected: []VersionRange{{Fixed: "not a version"}}}}},
+	}
+	for i, p := range invalid {
+		r := run{Parameters: p}
+		if r.ValidateParameters() == nil {
+			t.Fatalf("case %d: invalid parameters %+v were accepted", i, p)
+		}
+	}
+}
+
+func TestRunAdvisories(t *testing.T) {
+	installedPackages = func() []scribelib.PackageInfo {
+		return []scribelib.PackageInfo{
+			{Name: "glibc", Version: "2.12-1.149.el6_6.4", Type: "rpm", Arch: "x86_64"},
+			{Name: "glibc", Version: "2.12-1.149.el6_6.5", Type: "rpm", Arch: "i686"},
+			{Name: "libc6:amd64", Version: "2.15-0ubuntu10.10", Type: "dpkg", Arch: "amd64"},
+			{Name: "openssl", Version: "3.0.1-r0", Type: "apk", Arch: "x86_64"},
+		}
+	}
+	p := newParameters()
+	p.Advisories = []Advisory{
+		{ID: "CVE-2015-0235", Package: "glibc", Type: "rpm",
+			Affected: []VersionRange{{Fixed: "2.12-1.149.el6_6.5"}}},
+		{ID: "CVE-2015-0235", Package: "libc6", Type: "dpkg",
+			Affected: []VersionRange{{Fixed: "2.15-0ubuntu10.10"}}},
+		{ID: "CVE-2022-0778", Package: "openssl",
+			Affected: []VersionRange{{Fixed: "1.1.1n-r0"}, {Introduced: "3.0.0-r0", LastAffected: "3.0.1-r0"}}},
+		{ID: "CVE-2014-0160", Package: "openssl", Type: "rpm",
+			Affected: []VersionRange{{Fixed: "1.0.1e-16.el6_5.7"}}},
+	}
+	msg, err := modules.MakeMessage(modules.MsgClassParameters, p, false)
+	if err != nil {
+		t.Fatalf("MakeMessage: %v", err)
+	}
+	r := run{}
+	var result modules.Result
+	err = json.Unmarshal([]byte(r.Run(modules.NewModuleReader(bytes.NewBuffer(msg)))), &result)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !result.Success || !result.FoundAnything {
+		t.Fatalf("module run failed: %v", result.Errors)
+	}
+	var el elements
+	err = result.GetElements(&el)
+	if err != nil {
+		t.Fatalf("GetElements: %v", err)
+	}
+	if len(el.Packages) != 0 || len(el.Advisories) != 4 {
+		t.Fatalf("unexpected results %+v", el)
+	}
+	var expect = []struct {
+		vulnerable bool
+		installed  []bool
+	}{
+		{true, []bool{true, false}},
+		{false, []bool{false}},
+		{true, []bool{true}},
+		{false, nil},
+	}
+	for i, e := range expect {
+		a := el.Advisories[i]
+		if a.Vulnerable != e.vulnerable || len(a.Installed) != len(e.installed) {
+			t.Fatalf("unexpected result for advisory %d: %+v", i, a)
+		}
+		for j, v := range e.installed {
+			if a.Installed[j].Vulnerable != v {
+				t.Fatalf("unexpected result for advisory %d: %+v", i, a)
+			}
+		}
+	}
+	prints, err := r.PrintResults(result, true)
+	if err != nil || len(prints) != 2 {
+		t.Fatalf("unexpected printed results %v, %v", prints, err)
+	}
+}
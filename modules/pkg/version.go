@@ -0,0 +1,350 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package pkg /* import "github.com/mozilla/mig/modules/pkg" */
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// compareVersions compares two versions of a package using the ordering of its
+// package manager. It returns -1 if a is older than b, 0 if they are equal and
+// 1 if a is newer than b.
+func compareVersions(pkgtype, a, b string) (int, error) {
+	switch pkgtype {
+	case "rpm":
+		return compareRPM(a, b), nil
+	case "dpkg":
+		return compareDpkg(a, b), nil
+	case "apk":
+		return compareAPK(a, b)
+	}
+	return 0, fmt.Errorf("unsupported package type '%s'", pkgtype)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// splitEpoch returns the epoch of a version, which precedes the first colon and
+// defaults to 0, and the rest of the version
+func splitEpoch(v string) (int, string) {
+	i := strings.IndexByte(v, ':')
+	if i < 0 {
+		return 0, v
+	}
+	epoch, err := strconv.Atoi(v[:i])
+	if err != nil {
+		return 0, v
+	}
+	return epoch, v[i+1:]
+}
+
+// splitRevision splits a version at its last dash, into the version and the
+// release (rpm) or revision (dpkg)
+func splitRevision(v string) (string, string) {
+	i := strings.LastIndexByte(v, '-')
+	if i < 0 {
+		return v, ""
+	}
+	return v[:i], v[i+1:]
+}
+
+// compareRPM compares two epoch:version-release strings. Like rpm, the release
+// is only compared if both versions have one.
+func compareRPM(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	va, ra := splitRevision(a)
+	vb, rb := splitRevision(b)
+	if c := rpmvercmp(va, vb); c != 0 || ra == "" || rb == "" {
+		return c
+	}
+	return rpmvercmp(ra, rb)
+}
+
+// rpmvercmp is a port of the function of the same name in librpm. Versions are
+// split in numeric and alphabetic segments, numeric segments are newer than
+// alphabetic ones, a tilde sorts before anything and a caret sorts after the
+// end of the version but before anything else.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	separator := func(r rune) bool {
+		if r < 128 && (isDigit(byte(r)) || isAlpha(byte(r))) {
+			return false
+		}
+		return r != '~' && r != '^'
+	}
+	for a != "" || b != "" {
+		a = strings.TrimLeftFunc(a, separator)
+		b = strings.TrimLeftFunc(b, separator)
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+		var sa, sb string
+		numeric := isDigit(a[0])
+		if numeric {
+			sa, a = span(a, isDigit)
+			sb, b = span(b, isDigit)
+		} else {
+			sa, a = span(a, isAlpha)
+			sb, b = span(b, isAlpha)
+		}
+		// segments of different types, numeric is newer
+		if sb == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			sa = strings.TrimLeft(sa, "0")
+			sb = strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				return sign(len(sa) - len(sb))
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	if a == "" && b == "" {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	return 1
+}
+
+// span returns the prefix of s made of characters accepted by f, and the rest
+func span(s string, f func(byte) bool) (string, string) {
+	i := 0
+	for i < len(s) && f(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareDpkg compares two epoch:upstream-revision strings, following the
+// algorithm of dpkg
+func compareDpkg(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if ea != eb {
+		return sign(ea - eb)
+	}
+	ua, ra := splitRevision(a)
+	ub, rb := splitRevision(b)
+	if c := verrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return verrevcmp(ra, rb)
+}
+
+// dpkgOrder is the weight of a character in the non digit parts of a version:
+// a tilde sorts before the end of the part, and letters sort before other
+// characters
+func dpkgOrder(s string) int {
+	if s == "" {
+		return 0
+	}
+	c := s[0]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+func verrevcmp(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			ac, bc := dpkgOrder(a), dpkgOrder(b)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			if a != "" {
+				a = a[1:]
+			}
+			if b != "" {
+				b = b[1:]
+			}
+		}
+		a = strings.TrimLeft(a, "0")
+		b = strings.TrimLeft(b, "0")
+		diff := 0
+		for a != "" && b != "" && isDigit(a[0]) && isDigit(b[0]) {
+			if diff == 0 {
+				diff = int(a[0]) - int(b[0])
+			}
+			a, b = a[1:], b[1:]
+		}
+		if a != "" && isDigit(a[0]) {
+			return 1
+		}
+		if b != "" && isDigit(b[0]) {
+			return -1
+		}
+		if diff != 0 {
+			return sign(diff)
+		}
+	}
+	return 0
+}
+
+// apkVersionRegex matches the versions of Alpine packages: numbers separated by
+// dots, an optional letter, suffixes and a package revision
+var apkVersionRegex = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)*)([a-z]?)((?:_[a-z]+[0-9]*)*)(?:-r([0-9]+))?$`)
+
+// apkSuffixes are the ranks of version suffixes, pre-release suffixes sort
+// before the version without suffix, at rank 4
+var apkSuffixes = map[string]int{
+	"alpha": 0, "beta": 1, "pre": 2, "rc": 3,
+	"cvs": 5, "svn": 6, "git": 7, "hg": 8, "p": 9,
+}
+
+type apkVersion struct {
+	numbers  []int64
+	letter   string
+	suffixes [][2]int64 // rank and number of each suffix
+	revision int64
+}
+
+func parseAPKVersion(v string) (ret apkVersion, err error) {
+	m := apkVersionRegex.FindStringSubmatch(v)
+	if m == nil {
+		return ret, fmt.Errorf("invalid apk version '%s'", v)
+	}
+	for _, n := range strings.Split(m[1], ".") {
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return ret, fmt.Errorf("invalid apk version '%s'", v)
+		}
+		ret.numbers = append(ret.numbers, i)
+	}
+	ret.letter = m[2]
+	for _, s := range strings.Split(m[3], "_")[1:] {
+		name, num := span(s, isAlpha)
+		rank, ok := apkSuffixes[name]
+		if !ok {
+			return ret, fmt.Errorf("invalid suffix '%s' in apk version '%s'", name, v)
+		}
+		var n int64
+		if num != "" {
+			n, _ = strconv.ParseInt(num, 10, 64)
+		}
+		ret.suffixes = append(ret.suffixes, [2]int64{int64(rank), n})
+	}
+	if m[4] != "" {
+		ret.revision, _ = strconv.ParseInt(m[4], 10, 64)
+	}
+	return
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareAPK compares two versions of Alpine packages
+func compareAPK(a, b string) (int, error) {
+	va, err := parseAPKVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseAPKVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(va.numbers) || i < len(vb.numbers); i++ {
+		// a missing number is older than any number, so 1.2 < 1.2.0
+		if i >= len(va.numbers) {
+			return -1, nil
+		}
+		if i >= len(vb.numbers) {
+			return 1, nil
+		}
+		if c := compareInt64(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c, nil
+		}
+	}
+	if c := strings.Compare(va.letter, vb.letter); c != 0 {
+		return c, nil
+	}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		// a missing suffix has the rank of a release
+		sa, sb := [2]int64{4, 0}, [2]int64{4, 0}
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if c := compareInt64(sa[0], sb[0]); c != 0 {
+			return c, nil
+		}
+		if c := compareInt64(sa[1], sb[1]); c != 0 {
+			return c, nil
+		}
+	}
+	return compareInt64(va.revision, vb.revision), nil
+}
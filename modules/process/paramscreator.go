@@ -0,0 +1,147 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func printHelp(isCmd bool) {
+	dash := " "
+	if isCmd {
+		dash = "-"
+	}
+	fmt.Printf(`Query parameters
+----------------
+Without parameters, all running processes are returned. Options of the same
+type match any of their values, and processes must match all options.
+
+%vuser <string>      - only return processes owned by a user name or uid
+                      ex: user www-data
+
+%vexe <regexp>       - only return processes whose executable path matches
+                      ex: exe ^/usr/sbin/
+
+%vcmdline <regexp>   - only return processes whose command line matches
+                      ex: cmdline -c /etc/nginx
+
+%vparent <regexp>    - only return processes whose parent name matches
+                      ex: parent ^(apache2|httpd)$
+
+%vdeleted            - only return processes whose executable was deleted
+
+%vintmp              - only return processes running from a temporary directory
+                      (/tmp, /var/tmp or /dev/shm)
+`, dash, dash, dash, dash, dash, dash)
+}
+
+// ParamsCreator is used by mig-console to create parameters for the module
+func (r *run) ParamsCreator() (interface{}, error) {
+	p := newParameters()
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Printf("search> ")
+		scanmore := scanner.Scan()
+		if err := scanner.Err(); err != nil {
+			fmt.Println("Invalid input. Try again")
+			continue
+		}
+		if !scanmore {
+			goto exit
+		}
+		input := scanner.Text()
+		if input == "done" {
+			goto exit
+		} else if input == "help" {
+			printHelp(false)
+			continue
+		}
+		arr := strings.SplitN(input, " ", 2)
+		checkType := arr[0]
+		checkValue := ""
+		if len(arr) > 1 {
+			checkValue = arr[1]
+		}
+		switch checkType {
+		case "deleted":
+			p.Deleted = true
+			continue
+		case "intmp":
+			p.InTmp = true
+			continue
+		}
+		if checkValue == "" {
+			fmt.Println("Missing parameter, try again")
+			continue
+		}
+		switch checkType {
+		case "user":
+			p.Users = append(p.Users, checkValue)
+		case "exe":
+			p.Exes = append(p.Exes, checkValue)
+		case "cmdline":
+			p.CmdLines = append(p.CmdLines, checkValue)
+		case "parent":
+			p.Parents = append(p.Parents, checkValue)
+		default:
+			fmt.Printf("Invalid command, try help\n")
+			continue
+		}
+	}
+
+exit:
+	r.Parameters = *p
+	return r.Parameters, r.ValidateParameters()
+}
+
+// ParamsParser is used by the mig command line tool to parse parameters for the module
+func (r *run) ParamsParser(args []string) (interface{}, error) {
+	var (
+		fs                             flag.FlagSet
+		users, exes, cmdlines, parents flagParam
+		deleted, intmp                 bool
+	)
+	if len(args) > 0 && args[0] == "help" {
+		printHelp(true)
+		return nil, nil
+	}
+
+	fs.Init("process", flag.ContinueOnError)
+	fs.Var(&users, "user", "see help")
+	fs.Var(&exes, "exe", "see help")
+	fs.Var(&cmdlines, "cmdline", "see help")
+	fs.Var(&parents, "parent", "see help")
+	fs.BoolVar(&deleted, "deleted", false, "see help")
+	fs.BoolVar(&intmp, "intmp", false, "see help")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newParameters()
+	p.Users = users
+	p.Exes = exes
+	p.CmdLines = cmdlines
+	p.Parents = parents
+	p.Deleted = deleted
+	p.InTmp = intmp
+	r.Parameters = *p
+	return r.Parameters, r.ValidateParameters()
+}
+
+type flagParam []string
+
+func (f *flagParam) String() string {
+	return fmt.Sprint([]string(*f))
+}
+
+func (f *flagParam) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
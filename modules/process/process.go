@@ -0,0 +1,313 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package process lists the processes running on an endpoint, with their
+// owner, command line, executable and listening sockets. The list can be
+// filtered by user, executable path, command line and parent process, or
+// restricted to processes running from a deleted executable or from a
+// temporary directory.
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig/modules"
+)
+
+type module struct {
+}
+
+func (m *module) NewRun() modules.Runner {
+	return new(run)
+}
+
+func init() {
+	modules.Register("process", new(module))
+}
+
+type run struct {
+	Parameters Parameters
+	Results    modules.Result
+}
+
+// Parameters are the filters applied to the list of processes. Within a filter,
+// a process matches if any of the values matches. A process is returned if it
+// matches all the filters that are set.
+type Parameters struct {
+	Users    []string `json:"users,omitempty"`    // user names or uids
+	Exes     []string `json:"exes,omitempty"`     // regexps on the executable path
+	CmdLines []string `json:"cmdlines,omitempty"` // regexps on the command line
+	Parents  []string `json:"parents,omitempty"`  // regexps on the name of the parent process
+	Deleted  bool     `json:"deleted,omitempty"`  // only processes whose executable was deleted
+	InTmp    bool     `json:"intmp,omitempty"`    // only processes running from a temporary directory
+}
+
+func newParameters() *Parameters {
+	return &Parameters{}
+}
+
+// Process describes a running process
+type Process struct {
+	PID        int       `json:"pid"`
+	PPID       int       `json:"ppid"`
+	Name       string    `json:"name"`
+	ParentName string    `json:"parentname,omitempty"`
+	UID        int       `json:"uid"`
+	User       string    `json:"user,omitempty"`
+	CmdLine    string    `json:"cmdline,omitempty"`
+	Exe        string    `json:"exe,omitempty"`
+	ExeDeleted bool      `json:"exedeleted,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+	StartTime  time.Time `json:"starttime"`
+	Listening  []string  `json:"listening,omitempty"` // proto and address of listening sockets, ex: tcp 0.0.0.0:22
+}
+
+type elements struct {
+	Processes []Process `json:"processes"`
+}
+
+// Statistics counts the processes inspected and returned by the module
+type Statistics struct {
+	Inspected int    `json:"inspected"`
+	Matched   int    `json:"matched"`
+	Exectime  string `json:"exectime"`
+}
+
+// tmpDirs are the directories in which a running executable is suspicious
+var tmpDirs = []string{"/tmp/", "/var/tmp/", "/dev/shm/"}
+
+// filter is the compiled form of Parameters
+type filter struct {
+	users    []string
+	exes     []*regexp.Regexp
+	cmdlines []*regexp.Regexp
+	parents  []*regexp.Regexp
+	deleted  bool
+	intmp    bool
+}
+
+func compileRegexps(exprs []string) (res []*regexp.Regexp, err error) {
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp '%s': %v", expr, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+func newFilter(p Parameters) (f filter, err error) {
+	f.users = p.Users
+	f.deleted = p.Deleted
+	f.intmp = p.InTmp
+	f.exes, err = compileRegexps(p.Exes)
+	if err != nil {
+		return
+	}
+	f.cmdlines, err = compileRegexps(p.CmdLines)
+	if err != nil {
+		return
+	}
+	f.parents, err = compileRegexps(p.Parents)
+	return
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	if len(res) == 0 {
+		return true
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// inTmp returns true if the executable path is located in a temporary directory
+func inTmp(exe string) bool {
+	for _, dir := range tmpDirs {
+		if strings.HasPrefix(exe, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the process is selected by the filter. Kernel threads
+// have no executable, and never match the exe filter.
+func (f filter) match(p Process) bool {
+	if len(f.users) > 0 {
+		found := false
+		uid := strconv.Itoa(p.UID)
+		for _, u := range f.users {
+			if u == p.User || u == uid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.deleted && !p.ExeDeleted {
+		return false
+	}
+	if f.intmp && !inTmp(p.Exe) {
+		return false
+	}
+	if len(f.exes) > 0 && (p.Exe == "" || !matchAny(f.exes, p.Exe)) {
+		return false
+	}
+	return matchAny(f.cmdlines, p.CmdLine) && matchAny(f.parents, p.ParentName)
+}
+
+func (r *run) Run(in modules.ModuleReader) (resStr string) {
+	var stats Statistics
+	start := time.Now()
+	defer func() {
+		if e := recover(); e != nil {
+			r.Results.Errors = append(r.Results.Errors, fmt.Sprintf("%v", e))
+			r.Results.Success = false
+			stats.Exectime = time.Now().Sub(start).String()
+			r.Results.Statistics = stats
+			buf, _ := json.Marshal(r.Results)
+			resStr = string(buf)
+		}
+	}()
+
+	// Restrict go runtime processor utilization here, this might be moved
+	// into a more generic agent module function at some point.
+	runtime.GOMAXPROCS(1)
+
+	err := modules.ReadInputParameters(in, &r.Parameters)
+	if err != nil {
+		panic(err)
+	}
+	err = r.ValidateParameters()
+	if err != nil {
+		panic(err)
+	}
+	f, err := newFilter(r.Parameters)
+	if err != nil {
+		panic(err)
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		panic(err)
+	}
+	stats.Inspected = len(procs)
+	e := elements{Processes: make([]Process, 0)}
+	for _, p := range procs {
+		if !f.match(p) {
+			continue
+		}
+		// the hash and the sockets are only collected for the processes that
+		// are returned, since reading them is expensive
+		err = inspectProcess(&p)
+		if err != nil {
+			r.Results.Errors = append(r.Results.Errors, err.Error())
+		}
+		e.Processes = append(e.Processes, p)
+	}
+	stats.Matched = len(e.Processes)
+	stats.Exectime = time.Now().Sub(start).String()
+
+	r.Results.Success = true
+	r.Results.FoundAnything = len(e.Processes) > 0
+	r.Results.Elements = e
+	r.Results.Statistics = stats
+	buf, err := json.Marshal(r.Results)
+	if err != nil {
+		panic(err)
+	}
+	resStr = string(buf)
+	return
+}
+
+func (r *run) ValidateParameters() (err error) {
+	for _, u := range r.Parameters.Users {
+		if u == "" {
+			return fmt.Errorf("user filter cannot be empty")
+		}
+	}
+	_, err = newFilter(r.Parameters)
+	return
+}
+
+func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PrintResults() -> %v", e)
+		}
+	}()
+	var (
+		el    elements
+		stats Statistics
+	)
+	err = result.GetElements(&el)
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range el.Processes {
+		exe := p.Exe
+		if p.ExeDeleted {
+			exe += " (deleted)"
+		}
+		resStr := fmt.Sprintf("pid=%d ppid=%d parent=%s user=%s uid=%d start=%s exe=%s sha256=%s cmdline=%q",
+			p.PID, p.PPID, p.ParentName, p.User, p.UID, p.StartTime.Format(time.RFC3339),
+			exe, p.SHA256, p.CmdLine)
+		if len(p.Listening) > 0 {
+			resStr += " listening=" + strings.Join(p.Listening, ",")
+		}
+		prints = append(prints, resStr)
+	}
+	if foundOnly {
+		return
+	}
+	for _, e := range result.Errors {
+		prints = append(prints, fmt.Sprintf("error: %v", e))
+	}
+	err = result.GetStatistics(&stats)
+	if err != nil {
+		panic(err)
+	}
+	prints = append(prints, fmt.Sprintf("Statistics: %d processes inspected, %d matched, exectime %s",
+		stats.Inspected, stats.Matched, stats.Exectime))
+	return
+}
+
+// FlattenResults returns one row per process returned by the module
+func (r *run) FlattenResults(result modules.Result, foundOnly bool) (rows []modules.ResultRow, err error) {
+	var el elements
+	err = result.GetElements(&el)
+	if err != nil {
+		return
+	}
+	for _, p := range el.Processes {
+		rows = append(rows, modules.ResultRow{
+			"pid":        p.PID,
+			"ppid":       p.PPID,
+			"name":       p.Name,
+			"parentname": p.ParentName,
+			"uid":        p.UID,
+			"user":       p.User,
+			"cmdline":    p.CmdLine,
+			"exe":        p.Exe,
+			"exedeleted": p.ExeDeleted,
+			"sha256":     p.SHA256,
+			"starttime":  p.StartTime,
+			"listening":  strings.Join(p.Listening, ","),
+		})
+	}
+	return
+}
@@ -0,0 +1,346 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicks is the number of clock ticks per second used by the kernel to
+// express times in /proc/<pid>/stat. It is fixed to 100 by the kernel ABI on
+// all architectures, regardless of the internal timer frequency.
+const clockTicks = 100
+
+var procDir = "/proc"
+
+// Caches shared by the processes of a single run. Executables are hashed once
+// per file, and the sockets of each network namespace are read once.
+var (
+	userNames map[int]string
+	exeHashes map[[2]uint64]string
+	nsSockets map[string]map[string]string
+)
+
+// listProcesses reads the processes from /proc. Processes that exit while the
+// list is being built are ignored.
+func listProcesses() (procs []Process, err error) {
+	userNames = make(map[int]string)
+	exeHashes = make(map[[2]uint64]string)
+	nsSockets = make(map[string]map[string]string)
+	boot, err := bootTime()
+	if err != nil {
+		return
+	}
+	dirents, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return
+	}
+	names := make(map[int]string)
+	for _, d := range dirents {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || !d.IsDir() {
+			continue
+		}
+		p, err := readProcess(pid, boot)
+		if err != nil {
+			continue
+		}
+		names[p.PID] = p.Name
+		procs = append(procs, p)
+	}
+	for i := range procs {
+		procs[i].ParentName = names[procs[i].PPID]
+	}
+	return procs, nil
+}
+
+// bootTime returns the boot time of the system, read from /proc/stat
+func bootTime() (t time.Time, err error) {
+	fd, err := os.Open(path.Join(procDir, "stat"))
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("invalid boot time in /proc/stat: %v", err)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	return t, fmt.Errorf("boot time not found in /proc/stat")
+}
+
+func readProcess(pid int, boot time.Time) (p Process, err error) {
+	dir := path.Join(procDir, strconv.Itoa(pid))
+	stat, err := ioutil.ReadFile(path.Join(dir, "stat"))
+	if err != nil {
+		return
+	}
+	p, err = parseStat(string(stat), boot)
+	if err != nil {
+		return
+	}
+	status, err := ioutil.ReadFile(path.Join(dir, "status"))
+	if err != nil {
+		return
+	}
+	p.UID, err = parseStatusUID(string(status))
+	if err != nil {
+		return
+	}
+	p.User = userName(p.UID)
+	cmdline, err := ioutil.ReadFile(path.Join(dir, "cmdline"))
+	if err != nil {
+		return
+	}
+	p.CmdLine = strings.TrimSpace(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1)))
+	// the exe link can't be read for kernel threads, or without privileges
+	exe, err := os.Readlink(path.Join(dir, "exe"))
+	if err == nil {
+		p.Exe = strings.TrimSuffix(exe, " (deleted)")
+		p.ExeDeleted = p.Exe != exe
+	}
+	return p, nil
+}
+
+// parseStat reads the name, the parent and the start time of a process from the
+// content of /proc/<pid>/stat. The name is enclosed in parenthesis and can
+// itself contain spaces and parenthesis, so fields are counted from the last one.
+func parseStat(stat string, boot time.Time) (p Process, err error) {
+	open := strings.IndexByte(stat, '(')
+	end := strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return p, fmt.Errorf("invalid stat format")
+	}
+	p.PID, err = strconv.Atoi(strings.TrimSpace(stat[:open]))
+	if err != nil {
+		return p, fmt.Errorf("invalid pid in stat: %v", err)
+	}
+	p.Name = stat[open+1 : end]
+	// fields start at the state, which is the third field of the file
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return p, fmt.Errorf("invalid stat format")
+	}
+	p.PPID, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return p, fmt.Errorf("invalid ppid in stat: %v", err)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid start time in stat: %v", err)
+	}
+	p.StartTime = boot.Add(time.Duration(ticks) * time.Second / clockTicks)
+	return
+}
+
+// parseStatusUID returns the real uid from the content of /proc/<pid>/status
+func parseStatusUID(status string) (uid int, err error) {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		return strconv.Atoi(fields[1])
+	}
+	return 0, fmt.Errorf("uid not found in status")
+}
+
+// userName returns the name of the user with the given uid, or an empty string
+// if the user is unknown
+func userName(uid int) string {
+	name, ok := userNames[uid]
+	if !ok {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err == nil {
+			name = u.Username
+		}
+		userNames[uid] = name
+	}
+	return name
+}
+
+// inspectProcess sets the hash of the executable and the listening sockets of
+// a process
+func inspectProcess(p *Process) (err error) {
+	dir := path.Join(procDir, strconv.Itoa(p.PID))
+	if p.Exe != "" {
+		p.SHA256, err = hashExe(path.Join(dir, "exe"))
+		if err != nil {
+			return fmt.Errorf("failed to hash executable of process %d: %v", p.PID, err)
+		}
+	}
+	p.Listening, err = listeningSockets(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list sockets of process %d: %v", p.PID, err)
+	}
+	return
+}
+
+// hashExe returns the sha256 of an executable. It is read through the exe link
+// of the process, so that deleted executables can still be hashed.
+func hashExe(exe string) (string, error) {
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return "", err
+	}
+	var key [2]uint64
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		key = [2]uint64{uint64(st.Dev), uint64(st.Ino)}
+		if h, ok := exeHashes[key]; ok {
+			return h, nil
+		}
+	}
+	fd, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, fd)
+	if err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	if key != [2]uint64{} {
+		exeHashes[key] = sum
+	}
+	return sum, nil
+}
+
+// listeningSockets returns the listening sockets opened by the process in dir,
+// by matching the inodes of its socket descriptors with the sockets of its
+// network namespace
+func listeningSockets(dir string) (ret []string, err error) {
+	fds, err := ioutil.ReadDir(path.Join(dir, "fd"))
+	if err != nil {
+		// descriptors of processes owned by other users can't be read
+		// without privileges
+		if os.IsPermission(err) {
+			return nil, nil
+		}
+		return
+	}
+	var inodes []string
+	for _, fd := range fds {
+		link, err := os.Readlink(path.Join(dir, "fd", fd.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inodes = append(inodes, strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"))
+	}
+	if len(inodes) == 0 {
+		return
+	}
+	ns, err := os.Readlink(path.Join(dir, "ns", "net"))
+	if err != nil {
+		ns = dir
+	}
+	sockets, ok := nsSockets[ns]
+	if !ok {
+		sockets, err = readSockets(path.Join(dir, "net"))
+		if err != nil {
+			return
+		}
+		nsSockets[ns] = sockets
+	}
+	for _, inode := range inodes {
+		if s, ok := sockets[inode]; ok {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+// readSockets returns the listening tcp sockets and the bound udp sockets found
+// in the net directory of a process, indexed by inode
+func readSockets(netDir string) (map[string]string, error) {
+	sockets := make(map[string]string)
+	files := []struct {
+		name, proto, state string
+	}{
+		{"tcp", "tcp", "0A"},
+		{"tcp6", "tcp", "0A"},
+		{"udp", "udp", "07"},
+		{"udp6", "udp", "07"},
+	}
+	for _, f := range files {
+		fd, err := os.Open(path.Join(netDir, f.name))
+		if err != nil {
+			// ipv6 can be disabled on the host
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != f.state {
+				continue
+			}
+			addr, err := parseSocketAddr(fields[1])
+			if err != nil {
+				continue
+			}
+			sockets[fields[9]] = f.proto + " " + addr
+		}
+		err = scanner.Err()
+		fd.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sockets, nil
+}
+
+// parseSocketAddr converts an address of /proc/net/tcp, such as 0100007F:0016,
+// into a host:port string. Addresses are stored as words of 4 bytes in host
+// byte order, which is little endian on the architectures supported by the
+// agent.
+func parseSocketAddr(hexAddr string) (string, error) {
+	parts := strings.Split(hexAddr, ":")
+	if len(parts) != 2 || (len(parts[0]) != 8 && len(parts[0]) != 32) {
+		return "", fmt.Errorf("invalid socket address '%s'", hexAddr)
+	}
+	ip := make(net.IP, len(parts[0])/2)
+	for i := 0; i < len(ip); i += 4 {
+		for j := 0; j < 4; j++ {
+			pos := (i + 3 - j) * 2
+			b, err := strconv.ParseUint(parts[0][pos:pos+2], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid socket address '%s'", hexAddr)
+			}
+			ip[i+j] = uint8(b)
+		}
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid socket port '%s'", hexAddr)
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
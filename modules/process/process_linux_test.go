@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig/modules"
+)
+
+func TestParseStat(t *testing.T) {
+	boot := time.Unix(1500000000, 0).UTC()
+	stat := "4242 (my (odd) proc) S 1 4242 4242 0 -1 4194560 1000 0 0 0 10 5 0 0 20 0 1 0 12345 100000 500"
+	p, err := parseStat(stat, boot)
+	if err != nil {
+		t.Fatalf("parseStat: %v", err)
+	}
+	if p.PID != 4242 || p.PPID != 1 || p.Name != "my (odd) proc" {
+		t.Fatalf("unexpected process %+v", p)
+	}
+	if !p.StartTime.Equal(boot.Add(123450 * time.Millisecond)) {
+		t.Fatalf("unexpected start time %v", p.StartTime)
+	}
+	_, err = parseStat("4242 my proc S 1", boot)
+	if err == nil {
+		t.Fatalf("parseStat should fail on invalid input")
+	}
+}
+
+func TestParseSocketAddr(t *testing.T) {
+	var testcases = []struct {
+		in, expect string
+	}{
+		{"0100007F:0016", "127.0.0.1:22"},
+		{"00000000:1F90", "0.0.0.0:8080"},
+		{"00000000000000000000000001000000:0050", "[::1]:80"},
+	}
+	for _, tc := range testcases {
+		addr, err := parseSocketAddr(tc.in)
+		if err != nil {
+			t.Fatalf("parseSocketAddr(%s): %v", tc.in, err)
+		}
+		if addr != tc.expect {
+			t.Fatalf("parseSocketAddr(%s): expected %s, got %s", tc.in, tc.expect, addr)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable: %v", err)
+	}
+	action := fmt.Sprintf(`{"class": "parameters", "parameters": {"exes": [%q]}}`, "^"+exe+"$")
+	reader := modules.NewModuleReader(bytes.NewReader([]byte(action)))
+	r := run{}
+	var result modules.Result
+	err = json.Unmarshal([]byte(r.Run(reader)), &result)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !result.Success || !result.FoundAnything {
+		t.Fatalf("module run failed: %v", result.Errors)
+	}
+	var el elements
+	err = result.GetElements(&el)
+	if err != nil {
+		t.Fatalf("GetElements: %v", err)
+	}
+	var self *Process
+	for i, p := range el.Processes {
+		if p.PID == os.Getpid() {
+			self = &el.Processes[i]
+		}
+	}
+	if self == nil {
+		t.Fatalf("current process not found in %d processes", len(el.Processes))
+	}
+	if self.UID != os.Getuid() || self.PPID != os.Getppid() || len(self.SHA256) != 64 {
+		t.Fatalf("unexpected process %+v", self)
+	}
+	listening := "tcp " + ln.Addr().String()
+	found := false
+	for _, l := range self.Listening {
+		if l == listening {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %s in listening sockets %v", listening, self.Listening)
+	}
+}
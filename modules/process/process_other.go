@@ -0,0 +1,20 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func listProcesses() (procs []Process, err error) {
+	return nil, fmt.Errorf("the process module is not supported on %s", runtime.GOOS)
+}
+
+func inspectProcess(p *Process) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package process /* import "github.com/mozilla/mig/modules/process" */
+
+import (
+	"testing"
+
+	"github.com/mozilla/mig/testutil"
+)
+
+func TestRegistration(t *testing.T) {
+	testutil.CheckModuleRegistration(t, "process")
+}
+
+func TestFilter(t *testing.T) {
+	procs := []Process{
+		{PID: 1, Name: "init", UID: 0, User: "root", Exe: "/sbin/init", CmdLine: "/sbin/init"},
+		{PID: 2, Name: "kthreadd", UID: 0, User: "root"},
+		{PID: 100, PPID: 1, Name: "nginx", ParentName: "init", UID: 33, User: "www-data",
+			Exe: "/usr/sbin/nginx", CmdLine: "nginx: worker process"},
+		{PID: 200, PPID: 100, Name: "sh", ParentName: "nginx", UID: 33, User: "www-data",
+			Exe: "/tmp/.x/sh", ExeDeleted: true, CmdLine: "sh -i"},
+	}
+	var testcases = []struct {
+		params Parameters
+		expect []int
+	}{
+		{Parameters{}, []int{1, 2, 100, 200}},
+		{Parameters{Users: []string{"root"}}, []int{1, 2}},
+		{Parameters{Users: []string{"33", "nobody"}}, []int{100, 200}},
+		{Parameters{Exes: []string{"^/usr/"}}, []int{100}},
+		{Parameters{Exes: []string{".*"}}, []int{1, 100, 200}},
+		{Parameters{CmdLines: []string{"worker", "^sh "}}, []int{100, 200}},
+		{Parameters{Parents: []string{"^nginx$"}}, []int{200}},
+		{Parameters{Deleted: true}, []int{200}},
+		{Parameters{InTmp: true}, []int{200}},
+		{Parameters{Users: []string{"www-data"}, Parents: []string{"init"}}, []int{100}},
+	}
+	for i, tc := range testcases {
+		f, err := newFilter(tc.params)
+		if err != nil {
+			t.Fatalf("case %d: newFilter: %v", i, err)
+		}
+		var pids []int
+		for _, p := range procs {
+			if f.match(p) {
+				pids = append(pids, p.PID)
+			}
+		}
+		if len(pids) != len(tc.expect) {
+			t.Fatalf("case %d: expected pids %v, got %v", i, tc.expect, pids)
+		}
+		for j := range pids {
+			if pids[j] != tc.expect[j] {
+				t.Fatalf("case %d: expected pids %v, got %v", i, tc.expect, pids)
+			}
+		}
+	}
+}
+
+func TestParamsParser(t *testing.T) {
+	r := run{}
+	_, err := r.ParamsParser([]string{"-user", "root", "-exe", "^/usr/", "-exe", "^/opt/", "-deleted"})
+	if err != nil {
+		t.Fatalf("ParamsParser: %v", err)
+	}
+	p := r.Parameters
+	if len(p.Users) != 1 || len(p.Exes) != 2 || !p.Deleted || p.InTmp {
+		t.Fatalf("unexpected parameters %+v", p)
+	}
+	_, err = r.ParamsParser([]string{"-cmdline", "(invalid"})
+	if err == nil {
+		t.Fatalf("invalid regexp should fail validation")
+	}
+}
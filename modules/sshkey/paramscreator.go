@@ -28,7 +28,23 @@ func printHelp(isCmd bool) {
                   Linux and Darwin, and c:\Users for Windows).
 
 %vmaxdepth <int> - override default search depth of 8.
-`, dash, dash)
+
+Search parameters
+-----------------
+If search parameters are given, only the keys matching one of them are returned.
+
+%vfingerprint <fp> - search for a key fingerprint, in the SHA256:<base64> format
+                    of recent versions of OpenSSH, or in MD5 hex format. can be
+                    specified more than once.
+                    ex: fingerprint SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8
+
+%vcomment <regexp> - search for keys whose comment matches the regexp. can be
+                    specified more than once.
+                    ex: comment ^jdoe@
+
+%vweak             - search for weak keys: DSA keys, and RSA keys shorter than
+                    2048 bits.
+`, dash, dash, dash, dash, dash)
 }
 
 // ParamsCreator is used by mig-console to create parameters for the module
@@ -65,6 +81,20 @@ func (r *run) ParamsCreator() (interface{}, error) {
 				continue
 			}
 			p.Paths = append(p.Paths, checkValue)
+		case "fingerprint":
+			if checkValue == "" {
+				fmt.Println("Missing parameter, try again")
+				continue
+			}
+			p.Fingerprints = append(p.Fingerprints, checkValue)
+		case "comment":
+			if checkValue == "" {
+				fmt.Println("Missing parameter, try again")
+				continue
+			}
+			p.Comments = append(p.Comments, checkValue)
+		case "weak":
+			p.Weak = true
 		case "maxdepth":
 			var err error
 			if checkValue == "" {
@@ -89,9 +119,12 @@ exit:
 // ParamsParser is used by the mig command line tool to parse parameters for the module
 func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
-		paths    flagParam
-		fs       flag.FlagSet
-		maxdepth int
+		paths        flagParam
+		fingerprints flagParam
+		comments     flagParam
+		fs           flag.FlagSet
+		maxdepth     int
+		weak         bool
 	)
 	if len(args) > 0 && args[0] == "help" {
 		printHelp(true)
@@ -101,6 +134,9 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.Init("sshkey", flag.ContinueOnError)
 	fs.Var(&paths, "path", "see help")
 	fs.IntVar(&maxdepth, "maxdepth", 0, "see help")
+	fs.Var(&fingerprints, "fingerprint", "see help")
+	fs.Var(&comments, "comment", "see help")
+	fs.BoolVar(&weak, "weak", false, "see help")
 	err := fs.Parse(args)
 	if err != nil {
 		return nil, err
@@ -109,6 +145,9 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	p := newParameters()
 	p.Paths = paths
 	p.MaxDepth = maxdepth
+	p.Fingerprints = fingerprints
+	p.Comments = comments
+	p.Weak = weak
 	r.Parameters = *p
 	return r.Parameters, r.ValidateParameters()
 }
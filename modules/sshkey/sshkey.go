@@ -9,11 +9,16 @@ package sshkey /* import "github.com/mozilla/mig/modules/sshkey" */
 
 import (
 	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -24,8 +29,11 @@ import (
 )
 
 // candidateRegex is the default content search regex we apply to locate private and
-// public SSH keys
-var candidateRegex = "^(-----BEGIN (RSA|DSA) PRIVATE KEY|ssh-(rsa|dss) )"
+// public SSH keys. Public keys can be preceded by options in authorized keys files.
+var candidateRegex = "^(-----BEGIN (RSA|DSA|EC|OPENSSH) PRIVATE KEY|(.+ )?(ssh-(rsa|dss|ed25519)|ecdsa-sha2-nistp[0-9]+) )"
+
+// minRSABits is the size under which RSA keys are considered weak
+const minRSABits = 2048
 
 // Constants used in KeyInfo.Type
 const (
@@ -112,6 +120,19 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	for _, x := range cands {
 		processCandidate(x, e)
 	}
+	if r.Parameters.searching() {
+		s, err := newSearch(r.Parameters)
+		if err != nil {
+			panic(err)
+		}
+		var matches []KeyInfo
+		for _, k := range e.Keys {
+			if s.match(k) {
+				matches = append(matches, k)
+			}
+		}
+		e.Keys = matches
+	}
 
 	buf, err := buildResults(*e, &r.Results)
 	if err != nil {
@@ -126,7 +147,70 @@ func (r *run) ValidateParameters() (err error) {
 	if r.Parameters.MaxDepth < 0 || r.Parameters.MaxDepth > 1000 {
 		return errors.New("maxdepth should be specified from 0-1000")
 	}
-	return nil
+	_, err = newSearch(r.Parameters)
+	return err
+}
+
+// search is the compiled form of the search parameters. A key matches if any of
+// its fingerprints, its comment, or its weakness matches the search.
+type search struct {
+	fingerprints map[string]bool
+	comments     []*regexp.Regexp
+	weak         bool
+}
+
+func newSearch(p parameters) (s search, err error) {
+	s.fingerprints = make(map[string]bool)
+	for _, fp := range p.Fingerprints {
+		nfp := normalizeFingerprint(fp)
+		if nfp == "" {
+			return s, fmt.Errorf("invalid fingerprint '%v', must be in SHA256:<base64> or MD5 hex format", fp)
+		}
+		s.fingerprints[nfp] = true
+	}
+	for _, c := range p.Comments {
+		re, err := regexp.Compile(c)
+		if err != nil {
+			return s, fmt.Errorf("invalid comment regexp '%v': %v", c, err)
+		}
+		s.comments = append(s.comments, re)
+	}
+	s.weak = p.Weak
+	return
+}
+
+// normalizeFingerprint converts a fingerprint into the format used in KeyInfo,
+// and returns an empty string if the fingerprint is not valid. SHA256 fingerprints
+// are case sensitive, MD5 fingerprints can be prefixed with MD5: and be in any case.
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimSpace(fp)
+	if strings.HasPrefix(fp, "SHA256:") {
+		if len(fp) == len("SHA256:") {
+			return ""
+		}
+		return fp
+	}
+	fp = strings.ToLower(strings.TrimPrefix(fp, "MD5:"))
+	if !md5FingerprintRegex.MatchString(fp) {
+		return ""
+	}
+	return fp
+}
+
+var md5FingerprintRegex = regexp.MustCompile("^([0-9a-f]{2}:){15}[0-9a-f]{2}$")
+
+func (s search) match(k KeyInfo) bool {
+	if s.fingerprints[k.FingerprintSHA256] || s.fingerprints[k.FingerprintMD5] {
+		return true
+	}
+	if k.Comment != "" {
+		for _, re := range s.comments {
+			if re.MatchString(k.Comment) {
+				return true
+			}
+		}
+	}
+	return s.weak && k.Weak
 }
 
 // PrintResults returns a list of strings representing the formatted results output for the module
@@ -153,6 +237,24 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 			sha256fp = x.FingerprintSHA256
 		}
 		ln += fmt.Sprintf(" md5fp=%v sha256fp=%v", md5fp, sha256fp)
+		if x.Algorithm != "" {
+			ln += fmt.Sprintf(" algorithm=%v size=%v", x.Algorithm, x.Size)
+		}
+		if x.Weak {
+			ln += " weak=true"
+		}
+		if x.Comment != "" {
+			ln += fmt.Sprintf(" comment=%q", x.Comment)
+		}
+		if len(x.Options) > 0 {
+			ln += fmt.Sprintf(" options=%q", strings.Join(x.Options, ","))
+		}
+		if x.Owner != "" {
+			ln += fmt.Sprintf(" owner=%v mode=%v", x.Owner, x.Mode)
+		}
+		for _, p := range x.Problems {
+			ln += fmt.Sprintf(" problem=%q", p)
+		}
 		prints = append(prints, ln)
 	}
 	if !foundOnly {
@@ -218,12 +320,12 @@ func findCandidates(paths []string, maxdepth int) (ret []string, err error) {
 func processCandidate(path string, e *Elements) {
 	ski, err := checkPrivateKey(path)
 	if err == nil {
-		e.Keys = append(e.Keys, ski)
+		e.Keys = append(e.Keys, withFileInfo(path, []KeyInfo{ski})...)
 		return
 	}
 	skilist, err := checkPublicKey(path)
 	if err == nil {
-		e.Keys = append(e.Keys, skilist...)
+		e.Keys = append(e.Keys, withFileInfo(path, skilist)...)
 		return
 	}
 }
@@ -244,6 +346,7 @@ func checkPrivateKey(path string) (ret KeyInfo, err error) {
 		// Parsing was successful, get a fingerprint and return
 		ret.FingerprintMD5 = ssh.FingerprintLegacyMD5(signer.PublicKey())
 		ret.FingerprintSHA256 = ssh.FingerprintSHA256(signer.PublicKey())
+		setAlgorithm(&ret, signer.PublicKey())
 		ret.Type = KeyTypePrivate
 		return
 	}
@@ -274,11 +377,13 @@ func checkPublicKey(path string) (ret []KeyInfo, err error) {
 	}
 	if !isAuthKeys {
 		ski := KeyInfo{Path: path}
-		pubkey, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+		pubkey, comment, _, _, err := ssh.ParseAuthorizedKey(buf)
 		if err == nil {
 			// Parsing was successful, get a fingerprint and return
 			ski.FingerprintMD5 = ssh.FingerprintLegacyMD5(pubkey)
 			ski.FingerprintSHA256 = ssh.FingerprintSHA256(pubkey)
+			ski.Comment = comment
+			setAlgorithm(&ski, pubkey)
 			ski.Type = KeyTypePublic
 			ret = append(ret, ski)
 			return ret, nil
@@ -290,15 +395,19 @@ func checkPublicKey(path string) (ret []KeyInfo, err error) {
 	sbuf := bytes.Split(buf, []byte("\n"))
 	parseerror := false
 	for _, x := range sbuf {
-		if len(x) == 0 {
-			// Ignore any extra line feeds at the end of the file
+		x = bytes.TrimSpace(x)
+		if len(x) == 0 || x[0] == '#' {
+			// Ignore comments and any extra line feeds at the end of the file
 			continue
 		}
 		ski := KeyInfo{Path: path}
-		pubkey, _, _, _, err := ssh.ParseAuthorizedKey(x)
+		pubkey, comment, options, _, err := ssh.ParseAuthorizedKey(x)
 		if err == nil {
 			ski.FingerprintMD5 = ssh.FingerprintLegacyMD5(pubkey)
 			ski.FingerprintSHA256 = ssh.FingerprintSHA256(pubkey)
+			ski.Comment = comment
+			setAlgorithm(&ski, pubkey)
+			setOptions(&ski, options)
 			ski.Type = KeyTypeAuthorizedKeys
 			ret = append(ret, ski)
 		} else {
@@ -312,6 +421,68 @@ func checkPublicKey(path string) (ret []KeyInfo, err error) {
 	return ret, nil
 }
 
+// setAlgorithm sets the algorithm and the size of a key, and flags DSA keys and
+// short RSA keys as weak
+func setAlgorithm(ki *KeyInfo, pubkey ssh.PublicKey) {
+	ki.Algorithm = pubkey.Type()
+	cpk, ok := pubkey.(ssh.CryptoPublicKey)
+	if !ok {
+		return
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		ki.Size = k.N.BitLen()
+		ki.Weak = ki.Size < minRSABits
+	case *dsa.PublicKey:
+		ki.Size = k.P.BitLen()
+		ki.Weak = true
+	case *ecdsa.PublicKey:
+		ki.Size = k.Curve.Params().BitSize
+	default:
+		if ki.Algorithm == ssh.KeyAlgoED25519 {
+			ki.Size = 256
+		}
+	}
+}
+
+// setOptions stores the options of an authorized key, and extracts the
+// restrictions that are most relevant to an investigation
+func setOptions(ki *KeyInfo, options []string) {
+	ki.Options = options
+	for _, o := range options {
+		kv := strings.SplitN(o, "=", 2)
+		name := strings.ToLower(kv[0])
+		value := ""
+		if len(kv) == 2 {
+			value = strings.Trim(kv[1], "\"")
+		}
+		switch name {
+		case "command":
+			ki.Command = value
+		case "from":
+			ki.From = value
+		case "no-pty":
+			ki.NoPTY = true
+		}
+	}
+}
+
+// withFileInfo sets the owner and the mode of the file containing the keys, and
+// the problems found with its permissions
+func withFileInfo(path string, keys []KeyInfo) []KeyInfo {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return keys
+	}
+	owner, problems := checkFile(path, fi, keys[0].Type)
+	for i := range keys {
+		keys[i].Owner = owner
+		keys[i].Mode = fi.Mode().String()
+		keys[i].Problems = problems
+	}
+	return keys
+}
+
 func defaultSearchPaths() []string {
 	switch runtime.GOOS {
 	case "linux", "darwin":
@@ -335,6 +506,18 @@ type KeyInfo struct {
 	Path              string `json:"path"`               // Path to file
 	Encrypted         bool   `json:"encrypted"`          // True if private key is encrypted
 	Type              string `json:"type"`               // Type of file (e.g., private, public)
+
+	Algorithm string   `json:"algorithm,omitempty"` // Key algorithm (e.g., ssh-rsa, ssh-ed25519)
+	Size      int      `json:"size,omitempty"`      // Key size in bits
+	Weak      bool     `json:"weak,omitempty"`      // True for DSA keys and RSA keys shorter than 2048 bits
+	Comment   string   `json:"comment,omitempty"`   // Comment of public and authorized keys
+	Options   []string `json:"options,omitempty"`   // Options of authorized keys
+	Command   string   `json:"command,omitempty"`   // Forced command of an authorized key
+	From      string   `json:"from,omitempty"`      // Source restriction of an authorized key
+	NoPTY     bool     `json:"nopty,omitempty"`     // True if an authorized key can't allocate a pty
+	Owner     string   `json:"owner,omitempty"`     // User owning the file
+	Mode      string   `json:"mode,omitempty"`      // Mode of the file
+	Problems  []string `json:"problems,omitempty"`  // Problems with the ownership or mode of the file
 }
 
 // Elements is the type that contains the results of a module invocation
@@ -347,6 +530,16 @@ type Elements struct {
 type parameters struct {
 	Paths    []string `json:"paths"`    // Used to override default module search paths
 	MaxDepth int      `json:"maxdepth"` // Override default maximum search depth
+
+	// If any of the following is set, only the keys that match one of them are
+	// returned
+	Fingerprints []string `json:"fingerprints,omitempty"` // SHA256 or MD5 fingerprints to search for
+	Comments     []string `json:"comments,omitempty"`     // Regexps to search for in key comments
+	Weak         bool     `json:"weak,omitempty"`         // Search for weak keys
+}
+
+func (p parameters) searching() bool {
+	return len(p.Fingerprints) > 0 || len(p.Comments) > 0 || p.Weak
 }
 
 func newParameters() *parameters {
@@ -8,12 +8,19 @@ package sshkey /* import "github.com/mozilla/mig/modules/sshkey" */
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/testutil"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestRegistration(t *testing.T) {
@@ -137,3 +144,135 @@ func TestProcessCandidate(t *testing.T) {
 		t.Fatalf("processCandidate: number of keys in elements not correct")
 	}
 }
+
+func TestKeyDetails(t *testing.T) {
+	e := &Elements{}
+	processCandidate("./testdata/testkey3.pub", e)
+	if len(e.Keys) != 1 {
+		t.Fatalf("processCandidate: number of keys in elements not correct")
+	}
+	k := e.Keys[0]
+	if k.Algorithm != "ssh-dss" || k.Size != 1024 || !k.Weak || k.Comment != "testkey3" {
+		t.Fatalf("processCandidate: unexpected key details %+v", k)
+	}
+
+	e = &Elements{}
+	processCandidate("./testdata/home/testkey1", e)
+	if len(e.Keys) != 1 {
+		t.Fatalf("processCandidate: number of keys in elements not correct")
+	}
+	k = e.Keys[0]
+	if k.Algorithm != "ssh-rsa" || k.Size != 2048 || k.Weak || k.Owner == "" {
+		t.Fatalf("processCandidate: unexpected key details %+v", k)
+	}
+}
+
+func TestAuthorizedKeysOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshkey")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	rsakey, err := ioutil.ReadFile("./testdata/home/testkey1.pub")
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile: %v", err)
+	}
+	edpub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+	edkey, err := ssh.NewPublicKey(edpub)
+	if err != nil {
+		t.Fatalf("ssh.NewPublicKey: %v", err)
+	}
+	content := "# backups\n" +
+		`command="/usr/bin/backup --full",from="10.0.0.0/8",no-pty ` + string(rsakey) +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(edkey))) + " ops@example.com\n"
+	path := filepath.Join(dir, "authorized_keys")
+	err = ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("ioutil.WriteFile: %v", err)
+	}
+	// WriteFile is subject to the umask
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		t.Fatalf("os.Chmod: %v", err)
+	}
+
+	softErrors = nil
+	e := &Elements{}
+	processCandidate(path, e)
+	if len(softErrors) != 0 {
+		t.Fatalf("processCandidate: unexpected errors %v", softErrors)
+	}
+	if len(e.Keys) != 2 {
+		t.Fatalf("processCandidate: number of keys in elements not correct")
+	}
+	k := e.Keys[0]
+	if k.Command != "/usr/bin/backup --full" || k.From != "10.0.0.0/8" || !k.NoPTY ||
+		len(k.Options) != 3 || k.Comment != "testkey1" {
+		t.Fatalf("processCandidate: unexpected options %+v", k)
+	}
+	k = e.Keys[1]
+	if k.Algorithm != ssh.KeyAlgoED25519 || k.Size != 256 || k.Weak || k.Comment != "ops@example.com" {
+		t.Fatalf("processCandidate: unexpected key details %+v", k)
+	}
+	if runtime.GOOS != "windows" && (len(k.Problems) != 1 || k.Mode != "-rw-rw-rw-") {
+		t.Fatalf("processCandidate: unexpected file problems %+v", k)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	var testcases = []struct {
+		params parameters
+		found  bool
+	}{
+		{parameters{Fingerprints: []string{"SHA256:PdU9Glu/aBOCa62eJ8GjcK7bBaX/aejE7tN+oItsCtY"}}, true},
+		{parameters{Fingerprints: []string{"MD5:14:0F:ED:33:5D:27:57:58:2C:03:C8:3C:25:84:B0:EF"}}, true},
+		{parameters{Fingerprints: []string{"SHA256:unknown"}, Comments: []string{"^nobody@"}}, false},
+		{parameters{Comments: []string{"^testkey[13]$"}}, true},
+		{parameters{Weak: true}, true},
+	}
+	for i, tc := range testcases {
+		tc.params.Paths = []string{"./testdata"}
+		r := run{}
+		msg, err := modules.MakeMessage(modules.MsgClassParameters, tc.params, false)
+		if err != nil {
+			t.Fatalf("case %d: MakeMessage: %v", i, err)
+		}
+		var result modules.Result
+		err = json.Unmarshal([]byte(r.Run(modules.NewModuleReader(bytes.NewReader(msg)))), &result)
+		if err != nil {
+			t.Fatalf("case %d: json.Unmarshal: %v", i, err)
+		}
+		e := Elements{}
+		err = result.GetElements(&e)
+		if err != nil {
+			t.Fatalf("case %d: GetElements: %v", i, err)
+		}
+		if result.FoundAnything != tc.found || (len(e.Keys) > 0) != tc.found {
+			t.Fatalf("case %d: expected found=%v, got %v with %d keys", i, tc.found,
+				result.FoundAnything, len(e.Keys))
+		}
+		s, _ := newSearch(tc.params)
+		for _, k := range e.Keys {
+			if !s.match(k) {
+				t.Fatalf("case %d: returned key %+v does not match the search", i, k)
+			}
+		}
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	var invalid = []parameters{
+		{Fingerprints: []string{"14:0f:ed"}},
+		{Fingerprints: []string{"SHA256:"}},
+		{Comments: []string{"(invalid"}},
+	}
+	for i, p := range invalid {
+		r := run{Parameters: p}
+		if r.ValidateParameters() == nil {
+			t.Fatalf("case %d: invalid parameters %+v were accepted", i, p)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !windows
+
+package sshkey /* import "github.com/mozilla/mig/modules/sshkey" */
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// checkFile returns the owner of a key file, and the problems with its ownership
+// and mode that would let other users read a private key, or add authorized keys
+func checkFile(path string, fi os.FileInfo, keytype string) (owner string, problems []string) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	owner = strconv.FormatUint(uint64(st.Uid), 10)
+	if u, err := user.LookupId(owner); err == nil {
+		owner = u.Username
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		problems = append(problems, "file is writable by group or others")
+	}
+	if keytype == KeyTypePrivate && fi.Mode().Perm()&0044 != 0 {
+		problems = append(problems, "private key is readable by group or others")
+	}
+	if keytype != KeyTypeAuthorizedKeys {
+		return
+	}
+	dfi, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	if dst, ok := dfi.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && st.Uid != dst.Uid {
+		problems = append(problems, "file is not owned by root or by the owner of its directory")
+	}
+	if dfi.Mode().Perm()&0022 != 0 {
+		problems = append(problems, "directory is writable by group or others")
+	}
+	return
+}
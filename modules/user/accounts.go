@@ -0,0 +1,552 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user /* import "github.com/mozilla/mig/modules/user" */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locations of the account databases, relative to the root of the system
+const (
+	passwdPath  = "etc/passwd"
+	shadowPath  = "etc/shadow"
+	groupPath   = "etc/group"
+	sudoersPath = "etc/sudoers"
+	wtmpPath    = "var/log/wtmp"
+	lastlogPath = "var/log/lastlog"
+)
+
+// collectFrom reads the local accounts of the system mounted at root. Only
+// passwd is required, the other files enrich the accounts when they can be
+// read, and failures to read them are returned as soft errors.
+func collectFrom(root string) (users []User, softerrs []string, err error) {
+	fd, err := os.Open(filepath.Join(root, passwdPath))
+	if err != nil {
+		return
+	}
+	users, err = parsePasswd(fd)
+	fd.Close()
+	if err != nil {
+		return
+	}
+
+	var (
+		groups []group
+		shadow map[string]Password
+		logins map[string]Login
+	)
+	err = readFile(filepath.Join(root, groupPath), func(r io.Reader) (err error) {
+		groups, err = parseGroup(r)
+		return
+	})
+	if err != nil {
+		softerrs = append(softerrs, err.Error())
+	}
+	err = readFile(filepath.Join(root, shadowPath), func(r io.Reader) (err error) {
+		shadow, err = parseShadow(r)
+		return
+	})
+	if err != nil {
+		softerrs = append(softerrs, err.Error())
+	}
+	rules, err := parseSudoers(root, filepath.Join(root, sudoersPath))
+	if err != nil {
+		softerrs = append(softerrs, err.Error())
+	}
+	err = readFile(filepath.Join(root, wtmpPath), func(r io.Reader) (err error) {
+		logins, err = parseWtmp(r)
+		return
+	})
+	if err != nil {
+		softerrs = append(softerrs, err.Error())
+	}
+	lastlog, err := os.Open(filepath.Join(root, lastlogPath))
+	if err != nil && !os.IsNotExist(err) {
+		softerrs = append(softerrs, err.Error())
+	}
+	if err == nil {
+		defer lastlog.Close()
+	} else {
+		lastlog = nil
+	}
+	err = nil
+
+	for i := range users {
+		u := &users[i]
+		for _, g := range groups {
+			if g.gid == u.GID || g.hasMember(u.Name) {
+				u.Groups = append(u.Groups, g.name)
+			}
+		}
+		if p, ok := shadow[u.Name]; ok {
+			u.Password = &p
+		}
+		for _, r := range rules {
+			if r.appliesTo(u) {
+				u.Sudo = append(u.Sudo, r.line)
+			}
+		}
+		if login, ok := logins[u.Name]; ok {
+			u.LastLogin = &login
+		}
+		if lastlog != nil {
+			login, err := readLastlog(lastlog, u.UID)
+			if err != nil {
+				softerrs = append(softerrs, err.Error())
+			} else if login != nil && (u.LastLogin == nil || login.Time.After(u.LastLogin.Time)) {
+				u.LastLogin = login
+			}
+		}
+	}
+	return
+}
+
+// readFile opens a file and parses it. Missing files are ignored, since most
+// of the account databases are optional.
+func readFile(path string, parse func(io.Reader) error) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+	err = parse(fd)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+// splitLines returns the lines of a colon separated database, skipping blank
+// lines and comments
+func splitLines(r io.Reader, nfields int) (entries [][]string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < nfields {
+			continue
+		}
+		entries = append(entries, fields)
+	}
+	return entries, scanner.Err()
+}
+
+func parsePasswd(r io.Reader) (users []User, err error) {
+	entries, err := splitLines(r, 7)
+	if err != nil {
+		return
+	}
+	for _, f := range entries {
+		// NIS compat entries such as +:: are not local accounts
+		if f[0] == "" || f[0][0] == '+' || f[0][0] == '-' {
+			continue
+		}
+		u := User{Name: f[0], Gecos: f[4], Home: f[5], Shell: f[6]}
+		u.UID, err = strconv.Atoi(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid for user %s", u.Name)
+		}
+		u.GID, err = strconv.Atoi(f[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid for user %s", u.Name)
+		}
+		users = append(users, u)
+	}
+	return
+}
+
+type group struct {
+	name    string
+	gid     int
+	members []string
+}
+
+func (g group) hasMember(name string) bool {
+	for _, m := range g.members {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseGroup(r io.Reader) (groups []group, err error) {
+	entries, err := splitLines(r, 4)
+	if err != nil {
+		return
+	}
+	for _, f := range entries {
+		gid, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		g := group{name: f[0], gid: gid}
+		for _, m := range strings.Split(f[3], ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				g.members = append(g.members, m)
+			}
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// parseShadow returns the status and aging information of passwords. The
+// password hashes are never returned by the module.
+func parseShadow(r io.Reader) (pw map[string]Password, err error) {
+	pw = make(map[string]Password)
+	entries, err := splitLines(r, 8)
+	if err != nil {
+		return
+	}
+	for _, f := range entries {
+		p := Password{
+			Status:       passwordStatus(f[1]),
+			LastChange:   shadowDate(f[2]),
+			MinDays:      shadowInt(f[3]),
+			MaxDays:      shadowInt(f[4]),
+			WarnDays:     shadowInt(f[5]),
+			InactiveDays: shadowInt(f[6]),
+			Expire:       shadowDate(f[7]),
+		}
+		pw[f[0]] = p
+	}
+	return pw, nil
+}
+
+func passwordStatus(hash string) string {
+	switch {
+	case hash == "":
+		return "empty"
+	case hash == "*" || hash == "!" || hash == "!!" || hash == "!*":
+		return "disabled"
+	case hash[0] == '!':
+		return "locked"
+	}
+	return "set"
+}
+
+// shadowDate converts a number of days since the epoch into a date
+func shadowDate(days string) string {
+	n, err := strconv.ParseInt(days, 10, 64)
+	if err != nil || n < 0 {
+		return ""
+	}
+	return time.Unix(n*86400, 0).UTC().Format("2006-01-02")
+}
+
+// shadowInt returns the value of an aging field, or -1 if it is not set
+func shadowInt(days string) int {
+	n, err := strconv.Atoi(days)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// sudoRule is a user specification of sudoers. Users are the user list of the
+// rule, with aliases expanded.
+type sudoRule struct {
+	users []string
+	line  string
+}
+
+// appliesTo returns true if the rule grants privileges to a user, either by
+// name or uid, through one of its groups, or to ALL. Like in sudo, the last
+// entry of the list that matches the user wins, so negated entries exclude
+// users matched by earlier entries.
+func (r sudoRule) appliesTo(u *User) (applies bool) {
+	for _, entry := range r.users {
+		negated := false
+		for strings.HasPrefix(entry, "!") {
+			entry = entry[1:]
+			negated = !negated
+		}
+		if matchSudoUser(entry, u) {
+			applies = !negated
+		}
+	}
+	return
+}
+
+func matchSudoUser(entry string, u *User) bool {
+	switch {
+	case entry == "ALL" || entry == u.Name || entry == "#"+strconv.Itoa(u.UID):
+		return true
+	case entry == "%#"+strconv.Itoa(u.GID):
+		return true
+	case strings.HasPrefix(entry, "%"):
+		for _, g := range u.Groups {
+			if entry == "%"+g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseSudoers reads the user specifications of a sudoers file, and of the
+// files it includes. Include paths are resolved under root.
+func parseSudoers(root, path string) (rules []sudoRule, err error) {
+	aliases := make(map[string][]string)
+	err = readSudoers(root, path, aliases, &rules, 0)
+	if err != nil {
+		return
+	}
+	for i := range rules {
+		rules[i].users = expandAliases(rules[i].users, aliases, 0)
+	}
+	return
+}
+
+func readSudoers(root, path string, aliases map[string][]string, rules *[]sudoRule, depth int) error {
+	// sudo itself limits the nesting of includes to 128
+	if depth > 128 {
+		return fmt.Errorf("too many levels of includes in %s", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && depth == 0 {
+			return nil
+		}
+		return err
+	}
+	for _, line := range sudoersLines(string(data)) {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "#include", "@include", "#includedir", "@includedir":
+			if len(fields) < 2 {
+				continue
+			}
+			inc := fields[1]
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(path), inc)
+			} else {
+				inc = filepath.Join(root, inc)
+			}
+			if strings.HasSuffix(fields[0], "dir") {
+				err = readSudoersDir(root, inc, aliases, rules, depth+1)
+			} else {
+				err = readSudoers(root, inc, aliases, rules, depth+1)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if line[0] == '#' && !isUID(fields[0]) {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "Defaults") {
+			continue
+		}
+		switch fields[0] {
+		case "User_Alias":
+			for _, def := range strings.Split(strings.TrimPrefix(line, "User_Alias"), ":") {
+				kv := strings.SplitN(def, "=", 2)
+				if len(kv) == 2 {
+					aliases[strings.TrimSpace(kv[0])] = splitList(kv[1])
+				}
+			}
+			continue
+		case "Host_Alias", "Runas_Alias", "Cmnd_Alias", "Cmd_Alias":
+			continue
+		}
+		if users, ok := ruleUsers(line); ok {
+			*rules = append(*rules, sudoRule{users: users, line: line})
+		}
+	}
+	return nil
+}
+
+// readSudoersDir reads the files of an included directory, ignoring files which
+// name ends with ~ or contains a dot, like sudo does
+func readSudoersDir(root, dir string, aliases map[string][]string, rules *[]sudoRule, depth int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), "~") || strings.Contains(f.Name(), ".") {
+			continue
+		}
+		err = readSudoers(root, filepath.Join(dir, f.Name()), aliases, rules, depth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sudoersLines joins continued lines and removes blank lines and trailing
+// comments
+func sudoersLines(data string) (lines []string) {
+	var cur string
+	for _, l := range strings.Split(data, "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasSuffix(l, "\\") {
+			cur += strings.TrimSuffix(l, "\\") + " "
+			continue
+		}
+		l = strings.TrimSpace(cur + l)
+		cur = ""
+		// a # followed by digits is a uid and not a comment
+		if i := strings.Index(l, " #"); i > 0 && !strings.HasPrefix(l, "#include") {
+			if rest := strings.Fields(l[i+1:]); !isUID(rest[0]) {
+				l = strings.TrimSpace(l[:i])
+			}
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return
+}
+
+// isUID returns true if a user list entry is a uid, such as #1000
+func isUID(entry string) bool {
+	entry = strings.TrimRight(entry, ",")
+	_, err := strconv.Atoi(strings.TrimPrefix(entry, "#"))
+	return len(entry) > 1 && err == nil
+}
+
+// ruleUsers returns the user list of a user specification, which is followed by
+// a host list and an equal sign. Lists are separated by commas, optionally
+// followed by spaces.
+func ruleUsers(line string) (users []string, ok bool) {
+	eq := strings.Index(line, "=")
+	if eq < 0 {
+		return nil, false
+	}
+	fields := strings.Fields(line[:eq])
+	if len(fields) < 2 {
+		return nil, false
+	}
+	list := fields[0]
+	for _, f := range fields[1:] {
+		if !strings.HasSuffix(list, ",") && !strings.HasPrefix(f, ",") {
+			break
+		}
+		list += f
+	}
+	return splitList(list), true
+}
+
+func splitList(list string) (entries []string) {
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return
+}
+
+// expandAliases replaces user aliases by their members. The negation of an
+// alias is applied to each of its members.
+func expandAliases(users []string, aliases map[string][]string, depth int) (ret []string) {
+	for _, u := range users {
+		name := strings.TrimLeft(u, "!")
+		prefix := u[:len(u)-len(name)]
+		if members, ok := aliases[name]; ok && depth < 16 {
+			for _, m := range expandAliases(members, aliases, depth+1) {
+				ret = append(ret, prefix+m)
+			}
+			continue
+		}
+		ret = append(ret, u)
+	}
+	return
+}
+
+// The utmp records of wtmp and the records of lastlog use the glibc layout of
+// 64 bit Linux systems, where times are stored on 32 bits. Integers are in the
+// byte order of the host, which is little endian on the supported platforms.
+const (
+	utmpSize       = 384
+	utmpUserProc   = 7
+	lastlogSize    = 292
+	utmpLineOffset = 8
+	utmpUserOffset = 44
+	utmpHostOffset = 76
+	utmpTimeOffset = 340
+)
+
+// parseWtmp returns the most recent login of each user found in wtmp
+func parseWtmp(r io.Reader) (logins map[string]Login, err error) {
+	logins = make(map[string]Login)
+	rec := make([]byte, utmpSize)
+	for {
+		_, err = io.ReadFull(r, rec)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return logins, nil
+		}
+		if err != nil {
+			return
+		}
+		if int16(binary.LittleEndian.Uint16(rec[0:2])) != utmpUserProc {
+			continue
+		}
+		name := cString(rec[utmpUserOffset:utmpHostOffset])
+		if name == "" {
+			continue
+		}
+		l := Login{
+			Time: time.Unix(int64(int32(binary.LittleEndian.Uint32(rec[utmpTimeOffset:]))), 0).UTC(),
+			TTY:  cString(rec[utmpLineOffset:utmpUserOffset]),
+			Host: cString(rec[utmpHostOffset : utmpHostOffset+256]),
+		}
+		if prev, ok := logins[name]; !ok || l.Time.After(prev.Time) {
+			logins[name] = l
+		}
+	}
+}
+
+// readLastlog returns the last login of a uid from lastlog, which is a sparse
+// file indexed by uid. It returns nil if the user never logged in.
+func readLastlog(r io.ReaderAt, uid int) (*Login, error) {
+	rec := make([]byte, lastlogSize)
+	_, err := r.ReadAt(rec, int64(uid)*lastlogSize)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lastlog of uid %d: %v", uid, err)
+	}
+	t := int64(binary.LittleEndian.Uint32(rec[0:4]))
+	if t == 0 {
+		return nil, nil
+	}
+	return &Login{
+		Time: time.Unix(t, 0).UTC(),
+		TTY:  cString(rec[4:36]),
+		Host: cString(rec[36:]),
+	}, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
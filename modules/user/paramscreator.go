@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user /* import "github.com/mozilla/mig/modules/user" */
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func printHelp(isCmd bool) {
+	dash := " "
+	if isCmd {
+		dash = "-"
+	}
+	fmt.Printf(`Query parameters
+----------------
+Without parameters, all local accounts are returned. Options of the same type
+match any of their values, and accounts must match all options.
+
+%vname <regexp>       - only return accounts whose name matches
+                       ex: name ^svc-
+
+%vuid <uid|min-max>   - only return accounts with a uid, or a uid in a range
+                       ex: uid 0
+                       ex: uid 1000-60000
+
+%vshell <regexp>      - only return accounts whose login shell matches
+                       ex: shell sh$
+
+%vsudo                - only return accounts with sudo rights
+
+%vlastlogin <window>  - only return accounts last logged in within a window,
+                       using the <, > and d, h, m notation of the file module
+                       ex: lastlogin <7d   logged in during the last 7 days
+                       ex: lastlogin >90d  not logged in for 90 days, or never
+`, dash, dash, dash, dash, dash)
+}
+
+// ParamsCreator is used by mig-console to create parameters for the module
+func (r *run) ParamsCreator() (interface{}, error) {
+	p := newParameters()
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Printf("search> ")
+		scanmore := scanner.Scan()
+		if err := scanner.Err(); err != nil {
+			fmt.Println("Invalid input. Try again")
+			continue
+		}
+		if !scanmore {
+			goto exit
+		}
+		input := scanner.Text()
+		if input == "done" {
+			goto exit
+		} else if input == "help" {
+			printHelp(false)
+			continue
+		}
+		arr := strings.SplitN(input, " ", 2)
+		checkType := arr[0]
+		checkValue := ""
+		if len(arr) > 1 {
+			checkValue = arr[1]
+		}
+		if checkType == "sudo" {
+			p.Sudo = true
+			continue
+		}
+		if checkValue == "" {
+			fmt.Println("Missing parameter, try again")
+			continue
+		}
+		switch checkType {
+		case "name":
+			p.Names = append(p.Names, checkValue)
+		case "uid":
+			p.UIDs = append(p.UIDs, checkValue)
+		case "shell":
+			p.Shells = append(p.Shells, checkValue)
+		case "lastlogin":
+			p.LastLogin = checkValue
+		default:
+			fmt.Printf("Invalid command, try help\n")
+			continue
+		}
+	}
+
+exit:
+	r.Parameters = *p
+	return r.Parameters, r.ValidateParameters()
+}
+
+// ParamsParser is used by the mig command line tool to parse parameters for the module
+func (r *run) ParamsParser(args []string) (interface{}, error) {
+	var (
+		fs                  flag.FlagSet
+		names, uids, shells flagParam
+		sudo                bool
+		lastlogin           string
+	)
+	if len(args) > 0 && args[0] == "help" {
+		printHelp(true)
+		return nil, nil
+	}
+
+	fs.Init("user", flag.ContinueOnError)
+	fs.Var(&names, "name", "see help")
+	fs.Var(&uids, "uid", "see help")
+	fs.Var(&shells, "shell", "see help")
+	fs.BoolVar(&sudo, "sudo", false, "see help")
+	fs.StringVar(&lastlogin, "lastlogin", "", "see help")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newParameters()
+	p.Names = names
+	p.UIDs = uids
+	p.Shells = shells
+	p.Sudo = sudo
+	p.LastLogin = lastlogin
+	r.Parameters = *p
+	return r.Parameters, r.ValidateParameters()
+}
+
+type flagParam []string
+
+func (f *flagParam) String() string {
+	return fmt.Sprint([]string(*f))
+}
+
+func (f *flagParam) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,377 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package user enumerates the local accounts of an endpoint. It reads passwd,
+// group, shadow, sudoers and the login records of the system, and returns
+// accounts with their groups, password status, sudo rights and last login.
+// Password hashes are never returned.
+package user /* import "github.com/mozilla/mig/modules/user" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig/modules"
+)
+
+type module struct {
+}
+
+func (m *module) NewRun() modules.Runner {
+	return new(run)
+}
+
+func init() {
+	modules.Register("user", new(module))
+}
+
+type run struct {
+	Parameters Parameters
+	Results    modules.Result
+}
+
+// Parameters are the filters applied to the accounts. Within a filter, an
+// account matches if any of the values matches. An account is returned if it
+// matches all the filters that are set.
+type Parameters struct {
+	Names     []string `json:"names,omitempty"`     // regexps on the user name
+	UIDs      []string `json:"uids,omitempty"`      // uids or ranges of uids, ex: 1000-1999
+	Shells    []string `json:"shells,omitempty"`    // regexps on the login shell
+	Sudo      bool     `json:"sudo,omitempty"`      // only accounts with sudo rights
+	LastLogin string   `json:"lastlogin,omitempty"` // last login window, ex: <30d or >90d
+}
+
+func newParameters() *Parameters {
+	return &Parameters{}
+}
+
+// User is a local account
+type User struct {
+	Name      string    `json:"name"`
+	UID       int       `json:"uid"`
+	GID       int       `json:"gid"`
+	Gecos     string    `json:"gecos,omitempty"`
+	Home      string    `json:"home"`
+	Shell     string    `json:"shell"`
+	Groups    []string  `json:"groups,omitempty"`
+	Password  *Password `json:"password,omitempty"`  // nil if shadow could not be read
+	Sudo      []string  `json:"sudo,omitempty"`      // sudoers rules that apply to the user
+	LastLogin *Login    `json:"lastlogin,omitempty"` // nil if the user never logged in
+}
+
+// Password describes the status and the aging of a password, as found in
+// shadow. Dates are formatted as YYYY-MM-DD, and durations are in days, set to
+// -1 if the field is empty.
+type Password struct {
+	Status       string `json:"status"` // set, empty, locked or disabled
+	LastChange   string `json:"lastchange,omitempty"`
+	MinDays      int    `json:"mindays"`
+	MaxDays      int    `json:"maxdays"`
+	WarnDays     int    `json:"warndays"`
+	InactiveDays int    `json:"inactivedays"`
+	Expire       string `json:"expire,omitempty"`
+}
+
+// Login is the last login of a user, as found in wtmp or lastlog
+type Login struct {
+	Time time.Time `json:"time"`
+	TTY  string    `json:"tty,omitempty"`
+	Host string    `json:"host,omitempty"`
+}
+
+type elements struct {
+	Users []User `json:"users"`
+}
+
+// Statistics counts the accounts inspected and returned by the module
+type Statistics struct {
+	Inspected int    `json:"inspected"`
+	Matched   int    `json:"matched"`
+	Exectime  string `json:"exectime"`
+}
+
+type uidRange struct {
+	min, max int
+}
+
+// filter is the compiled form of Parameters
+type filter struct {
+	names            []*regexp.Regexp
+	uids             []uidRange
+	shells           []*regexp.Regexp
+	sudo             bool
+	minlast, maxlast time.Time
+	never            bool // also match users who never logged in
+}
+
+func compileRegexps(exprs []string) (res []*regexp.Regexp, err error) {
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp '%s': %v", expr, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+// parseUIDRange parses a uid, or a range of uids such as 1000-1999
+func parseUIDRange(s string) (r uidRange, err error) {
+	bounds := strings.SplitN(s, "-", 2)
+	r.min, err = strconv.Atoi(bounds[0])
+	if err != nil || r.min < 0 {
+		return r, fmt.Errorf("invalid uid range '%s'", s)
+	}
+	r.max = r.min
+	if len(bounds) == 2 {
+		r.max, err = strconv.Atoi(bounds[1])
+		if err != nil || r.max < r.min {
+			return r, fmt.Errorf("invalid uid range '%s'", s)
+		}
+	}
+	return r, nil
+}
+
+var lastLoginRe = regexp.MustCompile("^(<|>)([0-9]+)(d|h|m)$")
+
+// parseLastLogin converts a login window, in the format of the mtime filter of
+// the file module, into time bounds. <30d selects logins of the last 30 days,
+// and >90d selects logins older than 90 days, as well as users who never
+// logged in.
+func parseLastLogin(window string) (minlast, maxlast time.Time, never bool, err error) {
+	m := lastLoginRe.FindStringSubmatch(window)
+	if m == nil {
+		err = fmt.Errorf("invalid lastlogin format '%s', must match regex %s", window, lastLoginRe)
+		return
+	}
+	n, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return
+	}
+	unit := time.Minute
+	switch m[3] {
+	case "d":
+		unit = 24 * time.Hour
+	case "h":
+		unit = time.Hour
+	}
+	since := time.Now().Add(-time.Duration(n) * unit)
+	if m[1] == "<" {
+		return since, time.Now().AddDate(100, 0, 0), false, nil
+	}
+	return time.Time{}, since, true, nil
+}
+
+func newFilter(p Parameters) (f filter, err error) {
+	f.names, err = compileRegexps(p.Names)
+	if err != nil {
+		return
+	}
+	f.shells, err = compileRegexps(p.Shells)
+	if err != nil {
+		return
+	}
+	for _, s := range p.UIDs {
+		r, err := parseUIDRange(s)
+		if err != nil {
+			return f, err
+		}
+		f.uids = append(f.uids, r)
+	}
+	f.sudo = p.Sudo
+	if p.LastLogin != "" {
+		f.minlast, f.maxlast, f.never, err = parseLastLogin(p.LastLogin)
+	}
+	return
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	if len(res) == 0 {
+		return true
+	}
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the account is selected by the filter
+func (f filter) match(u User) bool {
+	if !matchAny(f.names, u.Name) || !matchAny(f.shells, u.Shell) {
+		return false
+	}
+	if len(f.uids) > 0 {
+		found := false
+		for _, r := range f.uids {
+			if u.UID >= r.min && u.UID <= r.max {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.sudo && len(u.Sudo) == 0 {
+		return false
+	}
+	if !f.maxlast.IsZero() {
+		if u.LastLogin == nil {
+			return f.never
+		}
+		if u.LastLogin.Time.Before(f.minlast) || u.LastLogin.Time.After(f.maxlast) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *run) Run(in modules.ModuleReader) (resStr string) {
+	var stats Statistics
+	start := time.Now()
+	defer func() {
+		if e := recover(); e != nil {
+			r.Results.Errors = append(r.Results.Errors, fmt.Sprintf("%v", e))
+			r.Results.Success = false
+			stats.Exectime = time.Now().Sub(start).String()
+			r.Results.Statistics = stats
+			buf, _ := json.Marshal(r.Results)
+			resStr = string(buf)
+		}
+	}()
+
+	// Restrict go runtime processor utilization here, this might be moved
+	// into a more generic agent module function at some point.
+	runtime.GOMAXPROCS(1)
+
+	err := modules.ReadInputParameters(in, &r.Parameters)
+	if err != nil {
+		panic(err)
+	}
+	err = r.ValidateParameters()
+	if err != nil {
+		panic(err)
+	}
+	f, err := newFilter(r.Parameters)
+	if err != nil {
+		panic(err)
+	}
+
+	users, softerrs, err := collect()
+	if err != nil {
+		panic(err)
+	}
+	r.Results.Errors = append(r.Results.Errors, softerrs...)
+	stats.Inspected = len(users)
+	e := elements{Users: make([]User, 0)}
+	for _, u := range users {
+		if f.match(u) {
+			e.Users = append(e.Users, u)
+		}
+	}
+	stats.Matched = len(e.Users)
+	stats.Exectime = time.Now().Sub(start).String()
+
+	r.Results.Success = true
+	r.Results.FoundAnything = len(e.Users) > 0
+	r.Results.Elements = e
+	r.Results.Statistics = stats
+	buf, err := json.Marshal(r.Results)
+	if err != nil {
+		panic(err)
+	}
+	resStr = string(buf)
+	return
+}
+
+func (r *run) ValidateParameters() (err error) {
+	_, err = newFilter(r.Parameters)
+	return
+}
+
+func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PrintResults() -> %v", e)
+		}
+	}()
+	var (
+		el    elements
+		stats Statistics
+	)
+	err = result.GetElements(&el)
+	if err != nil {
+		panic(err)
+	}
+	for _, u := range el.Users {
+		resStr := fmt.Sprintf("user name=%s uid=%d gid=%d home=%s shell=%s groups=%s",
+			u.Name, u.UID, u.GID, u.Home, u.Shell, strings.Join(u.Groups, ","))
+		if u.Password != nil {
+			resStr += fmt.Sprintf(" password=%s lastchange=%s maxdays=%d expire=%s",
+				u.Password.Status, u.Password.LastChange, u.Password.MaxDays, u.Password.Expire)
+		}
+		if u.LastLogin != nil {
+			resStr += fmt.Sprintf(" lastlogin=%s from=%s", u.LastLogin.Time.Format(time.RFC3339),
+				u.LastLogin.Host)
+		} else {
+			resStr += " lastlogin=never"
+		}
+		prints = append(prints, resStr)
+		for _, rule := range u.Sudo {
+			prints = append(prints, fmt.Sprintf("user name=%s sudo=%q", u.Name, rule))
+		}
+	}
+	if foundOnly {
+		return
+	}
+	for _, e := range result.Errors {
+		prints = append(prints, fmt.Sprintf("error: %v", e))
+	}
+	err = result.GetStatistics(&stats)
+	if err != nil {
+		panic(err)
+	}
+	prints = append(prints, fmt.Sprintf("Statistics: %d accounts inspected, %d matched, exectime %s",
+		stats.Inspected, stats.Matched, stats.Exectime))
+	return
+}
+
+// FlattenResults returns one row per account returned by the module
+func (r *run) FlattenResults(result modules.Result, foundOnly bool) (rows []modules.ResultRow, err error) {
+	var el elements
+	err = result.GetElements(&el)
+	if err != nil {
+		return
+	}
+	for _, u := range el.Users {
+		row := modules.ResultRow{
+			"name":   u.Name,
+			"uid":    u.UID,
+			"gid":    u.GID,
+			"gecos":  u.Gecos,
+			"home":   u.Home,
+			"shell":  u.Shell,
+			"groups": strings.Join(u.Groups, ","),
+			"sudo":   len(u.Sudo) > 0,
+		}
+		if u.Password != nil {
+			row["password"] = u.Password.Status
+			row["lastchange"] = u.Password.LastChange
+			row["expire"] = u.Password.Expire
+		}
+		if u.LastLogin != nil {
+			row["lastlogin"] = u.LastLogin.Time
+			row["lastloginhost"] = u.LastLogin.Host
+		}
+		rows = append(rows, row)
+	}
+	return
+}
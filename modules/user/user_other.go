@@ -0,0 +1,16 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// +build !linux
+
+package user /* import "github.com/mozilla/mig/modules/user" */
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func collect() (users []User, softerrs []string, err error) {
+	return nil, nil, fmt.Errorf("the user module is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,189 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package user /* import "github.com/mozilla/mig/modules/user" */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig/testutil"
+)
+
+func TestRegistration(t *testing.T) {
+	testutil.CheckModuleRegistration(t, "user")
+}
+
+func findUser(users []User, name string) *User {
+	for i := range users {
+		if users[i].Name == name {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	users, softerrs, err := collectFrom("./testdata")
+	if err != nil {
+		t.Fatalf("collectFrom: %v", err)
+	}
+	if len(softerrs) != 0 {
+		t.Fatalf("unexpected errors: %v", softerrs)
+	}
+	if len(users) != 7 {
+		t.Fatalf("expected 7 users, got %d", len(users))
+	}
+	var testcases = []struct {
+		name     string
+		groups   int
+		password string
+		sudo     int
+	}{
+		{"root", 1, "disabled", 1},
+		{"daemon", 1, "disabled", 0},
+		{"alice", 2, "set", 1},
+		{"bob", 2, "locked", 0},
+		{"toor", 1, "empty", 0},
+		{"deploy", 2, "", 2},
+	}
+	for _, tc := range testcases {
+		u := findUser(users, tc.name)
+		if u == nil {
+			t.Fatalf("user %s not found", tc.name)
+		}
+		if len(u.Groups) != tc.groups {
+			t.Fatalf("user %s: expected %d groups, got %v", tc.name, tc.groups, u.Groups)
+		}
+		if tc.password == "" && u.Password != nil {
+			t.Fatalf("user %s: unexpected password %+v", tc.name, u.Password)
+		}
+		if tc.password != "" && (u.Password == nil || u.Password.Status != tc.password) {
+			t.Fatalf("user %s: expected password status %s, got %+v", tc.name, tc.password, u.Password)
+		}
+		if len(u.Sudo) != tc.sudo {
+			t.Fatalf("user %s: expected %d sudo rules, got %v", tc.name, tc.sudo, u.Sudo)
+		}
+	}
+	alice := findUser(users, "alice")
+	if alice.Password.MaxDays != 90 || alice.Password.InactiveDays != 30 ||
+		alice.Password.LastChange != "2020-08-26" || alice.Password.Expire != "" {
+		t.Fatalf("unexpected password aging for alice: %+v", alice.Password)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	users := []User{
+		{Name: "root", UID: 0, Shell: "/bin/bash", Sudo: []string{"root ALL=(ALL) ALL"},
+			LastLogin: &Login{Time: now.Add(-time.Hour)}},
+		{Name: "daemon", UID: 1, Shell: "/usr/sbin/nologin"},
+		{Name: "alice", UID: 1000, Shell: "/bin/bash", Sudo: []string{"%sudo ALL=(ALL) ALL"},
+			LastLogin: &Login{Time: now.AddDate(0, 0, -100)}},
+		{Name: "svc-backup", UID: 1500, Shell: "/bin/sh",
+			LastLogin: &Login{Time: now.AddDate(0, 0, -3)}},
+	}
+	var testcases = []struct {
+		params Parameters
+		expect []string
+	}{
+		{Parameters{}, []string{"root", "daemon", "alice", "svc-backup"}},
+		{Parameters{Names: []string{"^svc-", "^root$"}}, []string{"root", "svc-backup"}},
+		{Parameters{UIDs: []string{"0"}}, []string{"root"}},
+		{Parameters{UIDs: []string{"1000-60000"}}, []string{"alice", "svc-backup"}},
+		{Parameters{Shells: []string{"sh$"}, Sudo: true}, []string{"root", "alice"}},
+		{Parameters{LastLogin: "<7d"}, []string{"root", "svc-backup"}},
+		{Parameters{LastLogin: ">90d"}, []string{"daemon", "alice"}},
+		{Parameters{LastLogin: ">30m", UIDs: []string{"1000-1999"}}, []string{"alice", "svc-backup"}},
+	}
+	for i, tc := range testcases {
+		f, err := newFilter(tc.params)
+		if err != nil {
+			t.Fatalf("case %d: newFilter: %v", i, err)
+		}
+		var names []string
+		for _, u := range users {
+			if f.match(u) {
+				names = append(names, u.Name)
+			}
+		}
+		if len(names) != len(tc.expect) {
+			t.Fatalf("case %d: expected %v, got %v", i, tc.expect, names)
+		}
+		for j := range names {
+			if names[j] != tc.expect[j] {
+				t.Fatalf("case %d: expected %v, got %v", i, tc.expect, names)
+			}
+		}
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	var invalid = []Parameters{
+		{Names: []string{"(invalid"}},
+		{UIDs: []string{"abc"}},
+		{UIDs: []string{"2000-1000"}},
+		{LastLogin: "30d"},
+		{LastLogin: "<30w"},
+	}
+	for i, p := range invalid {
+		r := run{Parameters: p}
+		if r.ValidateParameters() == nil {
+			t.Fatalf("case %d: invalid parameters %+v were accepted", i, p)
+		}
+	}
+}
+
+func utmpRecord(typ int16, user, line, host string, t time.Time) []byte {
+	rec := make([]byte, utmpSize)
+	binary.LittleEndian.PutUint16(rec[0:], uint16(typ))
+	copy(rec[utmpLineOffset:], line)
+	copy(rec[utmpUserOffset:], user)
+	copy(rec[utmpHostOffset:], host)
+	binary.LittleEndian.PutUint32(rec[utmpTimeOffset:], uint32(t.Unix()))
+	return rec
+}
+
+func TestParseWtmp(t *testing.T) {
+	t1 := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(48 * time.Hour)
+	var buf bytes.Buffer
+	buf.Write(utmpRecord(2, "reboot", "~", "4.15.0", t1))
+	buf.Write(utmpRecord(utmpUserProc, "alice", "pts/0", "10.0.0.1", t2))
+	buf.Write(utmpRecord(utmpUserProc, "alice", "pts/1", "10.0.0.2", t1))
+	buf.Write(utmpRecord(8, "", "pts/0", "", t2))
+	logins, err := parseWtmp(&buf)
+	if err != nil {
+		t.Fatalf("parseWtmp: %v", err)
+	}
+	if len(logins) != 1 {
+		t.Fatalf("expected logins of 1 user, got %v", logins)
+	}
+	l := logins["alice"]
+	if !l.Time.Equal(t2) || l.TTY != "pts/0" || l.Host != "10.0.0.1" {
+		t.Fatalf("unexpected last login %+v", l)
+	}
+}
+
+func TestReadLastlog(t *testing.T) {
+	ts := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	data := make([]byte, 3*lastlogSize)
+	rec := data[2*lastlogSize:]
+	binary.LittleEndian.PutUint32(rec, uint32(ts.Unix()))
+	copy(rec[4:], "tty1")
+	l, err := readLastlog(bytes.NewReader(data), 1)
+	if err != nil || l != nil {
+		t.Fatalf("uid 1 should have never logged in, got %+v, %v", l, err)
+	}
+	l, err = readLastlog(bytes.NewReader(data), 2)
+	if err != nil || l == nil || !l.Time.Equal(ts) || l.TTY != "tty1" {
+		t.Fatalf("unexpected last login of uid 2: %+v, %v", l, err)
+	}
+	l, err = readLastlog(bytes.NewReader(data), 1000)
+	if err != nil || l != nil {
+		t.Fatalf("uid 1000 should have never logged in, got %+v, %v", l, err)
+	}
+}
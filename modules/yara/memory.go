@@ -0,0 +1,140 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package yara /* import "github.com/mozilla/mig/modules/yara" */
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	yara "github.com/hillu/go-yara"
+	"github.com/mozilla/masche/memaccess"
+	"github.com/mozilla/masche/process"
+)
+
+// memoryChunkSize is the size of the blocks of process memory scanned by the
+// rules. Memory regions larger than this are scanned in blocks that overlap by
+// half their size, so a string shorter than half a block is always found, even
+// when it crosses the boundary of two blocks.
+const memoryChunkSize = 16 * 1024 * 1024
+
+// findProcesses returns the pids of the processes selected by the process
+// name and pid parameters. The agent never scans its own memory, which
+// contains the rules it was sent.
+func (r *run) findProcesses() (pids []uint, err error) {
+	if r.Parameters.ProcessPid != 0 {
+		pids = append(pids, uint(r.Parameters.ProcessPid))
+	} else {
+		var serr []error
+		pids, serr, err = process.GetAllPids()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range serr {
+			r.Results.Errors = append(r.Results.Errors, e.Error())
+		}
+	}
+	self := uint(os.Getpid())
+	ret := make([]uint, 0, len(pids))
+	for _, pid := range pids {
+		if pid != self {
+			ret = append(ret, pid)
+		}
+	}
+	return ret, nil
+}
+
+// scanProcesses scans the memory of the selected processes, and returns one
+// match per block of memory that matched at least one rule
+func (r *run) scanProcesses(rules *yara.Rules) (matches []YaraMatch, err error) {
+	var re *regexp.Regexp
+	if r.Parameters.ProcessName != "" {
+		re, err = regexp.Compile(r.Parameters.ProcessName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pids, err := r.findProcesses()
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range pids {
+		pm, err := r.scanProcess(rules, pid, re)
+		if err != nil {
+			r.Results.Errors = append(r.Results.Errors, fmt.Sprintf("pid %d: %v", pid, err))
+		}
+		matches = append(matches, pm...)
+	}
+	return matches, nil
+}
+
+// scanProcess walks the readable memory regions of a process and scans them
+// with the rules, if the name of the process matches the name regex
+func (r *run) scanProcess(rules *yara.Rules, pid uint, re *regexp.Regexp) (matches []YaraMatch, err error) {
+	proc, _, err := process.OpenFromPid(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.Close()
+	procname, _, err := proc.Name()
+	if err != nil {
+		return nil, err
+	}
+	if re != nil && !re.MatchString(procname) {
+		return nil, nil
+	}
+	var (
+		scanerr error
+		scanned uintptr
+	)
+	walkfn := func(address uintptr, buf []byte) bool {
+		mr, err := rules.ScanMem(buf, 0, time.Second*10)
+		if err != nil {
+			scanerr = fmt.Errorf("%s at 0x%x: %v", procname, address, err)
+			return false
+		}
+		mr = unseenMatches(mr, address, scanned)
+		if end := address + uintptr(len(buf)); end > scanned {
+			scanned = end
+		}
+		if len(mr) != 0 {
+			nm := newMatch(procname, mr)
+			nm.Pid = float64(pid)
+			nm.ProcessName = procname
+			nm.Address = fmt.Sprintf("0x%x", address)
+			matches = append(matches, nm)
+		}
+		return true
+	}
+	// soft errors are returned when regions disappear or can't be read, which
+	// is expected from a running process, and don't stop the scan
+	serr, err := memaccess.SlidingWalkMemory(proc, 0, memoryChunkSize, walkfn)
+	for _, e := range serr {
+		r.Results.Errors = append(r.Results.Errors, fmt.Sprintf("pid %d: %v", pid, e))
+	}
+	if err != nil {
+		return matches, err
+	}
+	return matches, scanerr
+}
+
+// unseenMatches returns the rules of mr, matched in a block of memory starting
+// at address, that have a string ending after address scanned. The other rules
+// only matched in the half of the block already scanned with the previous one.
+func unseenMatches(mr []yara.MatchRule, address, scanned uintptr) (ret []yara.MatchRule) {
+	if address >= scanned {
+		return mr
+	}
+	for _, m := range mr {
+		for _, s := range m.Strings {
+			if address+uintptr(s.Offset)+uintptr(len(s.Data)) > scanned {
+				ret = append(ret, m)
+				break
+			}
+		}
+	}
+	return
+}
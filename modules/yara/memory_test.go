@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package yara /* import "github.com/mozilla/mig/modules/yara" */
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	yara "github.com/hillu/go-yara"
+)
+
+// markerRule matches a string that is in the memory of the test process, if
+// only because the source of the rule is
+const markerRule = `rule marker { strings: $a = "mig yara module memory scan test marker" condition: $a }`
+
+func TestScanProcess(t *testing.T) {
+	rules, err := yara.Compile(markerRule, nil)
+	if err != nil {
+		t.Fatalf("yara.Compile: %v", err)
+	}
+	defer rules.Destroy()
+	pid := uint(os.Getpid())
+	var r run
+	matches, err := r.scanProcess(rules, pid, nil)
+	if err != nil {
+		t.Fatalf("scanProcess: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("rule did not match the memory of the test process")
+	}
+	for _, m := range matches {
+		if m.Pid != float64(pid) || len(m.MatchedRules) != 1 || m.MatchedRules[0] != "marker" {
+			t.Fatalf("unexpected match %+v", m)
+		}
+	}
+	matches, err = r.scanProcess(rules, pid, regexp.MustCompile("^no such process$"))
+	if err != nil {
+		t.Fatalf("scanProcess: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Fatalf("process name should not match, got %+v", matches)
+	}
+}
+
+func TestUnseenMatches(t *testing.T) {
+	rule := func(name string, offset uint64) yara.MatchRule {
+		return yara.MatchRule{
+			Rule:    name,
+			Strings: []yara.MatchString{{Name: "$a", Offset: offset, Data: []byte("abcd")}},
+		}
+	}
+	var cases = []struct {
+		Description string
+		Address     uintptr
+		Scanned     uintptr
+		Expect      []string
+	}{
+		{
+			Description: "a block after the scanned memory keeps all the rules",
+			Address:     0x2000,
+			Scanned:     0x1000,
+			Expect:      []string{"start", "middle", "end"},
+		},
+		{
+			Description: "a block overlapping the scanned memory drops the rules matched in the overlap",
+			Address:     0x1000,
+			Scanned:     0x1900,
+			Expect:      []string{"end"},
+		},
+		{
+			Description: "a string crossing the end of the scanned memory is new",
+			Address:     0x1000,
+			Scanned:     0x1802,
+			Expect:      []string{"middle", "end"},
+		},
+		{
+			Description: "a string ending with the scanned memory was already seen",
+			Address:     0x1000,
+			Scanned:     0x1804,
+			Expect:      []string{"end"},
+		},
+	}
+	mr := []yara.MatchRule{rule("start", 0), rule("middle", 0x800), rule("end", 0xffc)}
+	for _, c := range cases {
+		var got []string
+		for _, m := range unseenMatches(mr, c.Address, c.Scanned) {
+			got = append(got, m.Rule)
+		}
+		if len(got) != len(c.Expect) {
+			t.Fatalf("%s: got %v, expected %v", c.Description, got, c.Expect)
+		}
+		for i := range got {
+			if got[i] != c.Expect[i] {
+				t.Fatalf("%s: got %v, expected %v", c.Description, got, c.Expect)
+			}
+		}
+	}
+}
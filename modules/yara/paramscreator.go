@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -32,7 +33,18 @@ func printHelp(isCmd bool) {
 		    parameters as supplied to the file module for scanning,
 		    each matching file will be scanned using rules. see the
 		    help output for the file module for available options.
-`, dash, dash)
+
+%sprocname <regex> - scan the memory of processes using rules
+		    ex: procname '^/usr/sbin/sshd$'
+		    the regex is matched against the binary path of running
+		    processes, the readable memory of each matching process
+		    is scanned using rules. cannot be combined with files.
+
+%spid <pid>        - scan the memory of the process with this pid using rules
+		    ex: pid 1234
+		    can be combined with procname, cannot be combined with
+		    files.
+`, dash, dash, dash, dash)
 }
 
 func (r *run) ParamsCreator() (interface{}, error) {
@@ -73,6 +85,15 @@ func (r *run) ParamsCreator() (interface{}, error) {
 			p.YaraRules = string(rulebuf)
 		case "files":
 			p.FileSearch = checkValue
+		case "procname":
+			p.ProcessName = checkValue
+		case "pid":
+			pid, err := strconv.ParseUint(checkValue, 10, 32)
+			if err != nil {
+				fmt.Printf("Invalid pid: %v\n", err)
+				continue
+			}
+			p.ProcessPid = float64(pid)
 		default:
 			fmt.Printf("Invalid method!\nTry 'help'\n")
 			continue
@@ -86,9 +107,11 @@ exit:
 
 func (r *run) ParamsParser(args []string) (interface{}, error) {
 	var (
-		fs         flag.FlagSet
-		yaraPath   string
-		fileSearch string
+		fs          flag.FlagSet
+		yaraPath    string
+		fileSearch  string
+		processName string
+		processPid  uint
 	)
 
 	if len(args) < 1 || args[0] == "" || args[0] == "help" {
@@ -99,6 +122,8 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	fs.Init("yara", flag.ContinueOnError)
 	fs.StringVar(&yaraPath, "rules", "", "see help")
 	fs.StringVar(&fileSearch, "files", "", "see help")
+	fs.StringVar(&processName, "procname", "", "see help")
+	fs.UintVar(&processPid, "pid", 0, "see help")
 	err := fs.Parse(args)
 	if err != nil {
 		return nil, err
@@ -115,10 +140,11 @@ func (r *run) ParamsParser(args []string) (interface{}, error) {
 	}
 	p.YaraRules = string(rulebuf)
 	p.FileSearch = fileSearch
-	// Right now file searching is the only supported search, so this
-	// option must be specified.
-	if p.FileSearch == "" {
-		return nil, fmt.Errorf("-files option is required")
+	p.ProcessName = processName
+	p.ProcessPid = float64(processPid)
+	// Either files or processes must be selected for scanning.
+	if p.FileSearch == "" && p.ProcessName == "" && p.ProcessPid == 0 {
+		return nil, fmt.Errorf("one of -files, -procname or -pid options is required")
 	}
 	r.Parameters = *p
 
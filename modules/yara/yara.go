@@ -104,6 +104,21 @@ func findFiles(args []string) ([]string, error) {
 	return ret, nil
 }
 
+// newMatch converts the rules matched against an object into a YaraMatch
+func newMatch(object string, mr []yara.MatchRule) YaraMatch {
+	nm := YaraMatch{Object: object}
+	for _, y := range mr {
+		nm.MatchedRules = append(nm.MatchedRules, y.Rule)
+		nm.Rules = append(nm.Rules, YaraRule{
+			Name:      y.Rule,
+			Namespace: y.Namespace,
+			Tags:      y.Tags,
+			Meta:      y.Meta,
+		})
+	}
+	return nm
+}
+
 func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -156,13 +171,14 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 				continue
 			}
 			if len(mr) != 0 {
-				nm := YaraMatch{Object: x}
-				for _, y := range mr {
-					nm.MatchedRules = append(nm.MatchedRules, y.Rule)
-				}
-				e.Matches = append(e.Matches, nm)
+				e.Matches = append(e.Matches, newMatch(x, mr))
 			}
 		}
+	} else if r.Parameters.ProcessName != "" || r.Parameters.ProcessPid != 0 {
+		e.Matches, err = r.scanProcesses(rules)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	buf, err := buildResults(*e, &r.Results)
@@ -183,6 +199,18 @@ func (r *run) ValidateParameters() (err error) {
 		if err != nil {
 			return err
 		}
+		if r.Parameters.ProcessName != "" || r.Parameters.ProcessPid != 0 {
+			return fmt.Errorf("file and process scans cannot be combined")
+		}
+	}
+	if r.Parameters.ProcessName != "" {
+		_, err = regexp.Compile(r.Parameters.ProcessName)
+		if err != nil {
+			return fmt.Errorf("invalid process name regex: %v", err)
+		}
+	}
+	if r.Parameters.ProcessPid < 0 || r.Parameters.ProcessPid != float64(uint32(r.Parameters.ProcessPid)) {
+		return fmt.Errorf("invalid pid %v", r.Parameters.ProcessPid)
 	}
 	return nil
 }
@@ -201,6 +229,11 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 		for _, y := range x.MatchedRules {
 			rn = append(rn, y)
 		}
+		if x.Address != "" {
+			prints = append(prints, fmt.Sprintf("%v pid %.0f at %v [%v]", x.ProcessName, x.Pid,
+				x.Address, strings.Join(rn, ",")))
+			continue
+		}
 		prints = append(prints, fmt.Sprintf("%v [%v]", x.Object, strings.Join(rn, ",")))
 	}
 	if !foundOnly {
@@ -212,8 +245,21 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 }
 
 type YaraMatch struct {
-	Object       string   // Object matched (e.g., file name)
-	MatchedRules []string // Matched rule
+	Object       string     // Object matched (e.g., file name)
+	MatchedRules []string   // Matched rule
+	Rules        []YaraRule // Metadata and tags of matched rules
+
+	// Set when scanning process memory
+	Pid         float64 `json:",omitempty"` // Process ID
+	ProcessName string  `json:",omitempty"` // Process binary path
+	Address     string  `json:",omitempty"` // Address of the memory block that matched
+}
+
+type YaraRule struct {
+	Name      string                 // Rule name
+	Namespace string                 // Rule namespace
+	Tags      []string               // Rule tags
+	Meta      map[string]interface{} // Rule metadata
 }
 
 type YaraElements struct {
@@ -223,6 +269,9 @@ type YaraElements struct {
 type parameters struct {
 	YaraRules  string `json:"yara"`       // Yara rules as a string
 	FileSearch string `json:"filesearch"` // file module parameters for file search
+
+	ProcessName string  `json:"processname"` // regex on binary path of processes to scan
+	ProcessPid  float64 `json:"pid"`         // pid of process to scan
 }
 
 func newParameters() *parameters {
@@ -14,3 +14,28 @@ import (
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "yara")
 }
+
+func TestValidateParameters(t *testing.T) {
+	var cases = []struct {
+		p     parameters
+		valid bool
+	}{
+		{parameters{YaraRules: "rule"}, true},
+		{parameters{YaraRules: "rule", ProcessName: "^/usr/sbin/sshd$"}, true},
+		{parameters{YaraRules: "rule", ProcessName: "^/usr/sbin/sshd$", ProcessPid: 1234}, true},
+		{parameters{ProcessPid: 1234}, false},
+		{parameters{YaraRules: "rule", ProcessName: "(sshd"}, false},
+		{parameters{YaraRules: "rule", ProcessPid: -1}, false},
+		{parameters{YaraRules: "rule", ProcessPid: 1.5}, false},
+	}
+	for i, c := range cases {
+		r := run{Parameters: c.p}
+		err := r.ValidateParameters()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: parameters should be invalid", i)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AgentConfDirEnv is the environment variable that makes the test binary of
+// mig-agent run the agent, with its configuration and runtime files in the
+// directory named by the variable. Modules executed by the agent inherit it.
+const AgentConfDirEnv = "MIG_AGENT_TEST_CONFDIR"
+
+// agentConfig is the configuration of the agents started by StartAgent. They
+// run in the foreground and poll the API for messages every second.
+const agentConfig = `[agent]
+    api = "%s"
+    transport = "https"
+    socket = "127.0.0.1:0"
+    heartbeatfreq = "1s"
+    moduletimeout = "60s"
+    isimmortal = off
+    installservice = off
+    discoverpublicip = off
+    discoverawsmeta = off
+    checkin = off
+    nopersistmods = on
+%s
+[certs]
+    ca = "%s"
+    cert = "%s"
+    key = "%s"
+[logging]
+    mode = "stdout"
+    level = "info"
+`
+
+// Agent is a mig-agent process that exchanges messages with the scheduler
+// through the API, and accepts the actions of the investigators given to
+// StartAgent
+type Agent struct {
+	*process
+	// Tags are the tags of the agent, which actions can target as tag.<key>
+	Tags map[string]string
+	// ConfDir holds the configuration, keyring and ACL of the agent
+	ConfDir string
+}
+
+// StartAgent runs the mig-agent test binary bin, built with BuildTestBinary,
+// as an agent with its files in directory dir. The agent authenticates to api
+// with the client certificate of pki, and accepts actions signed by any of
+// invs.
+func StartAgent(dir, bin string, api *API, pki PKI, tags map[string]string, invs ...Investigator) (agt *Agent, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("StartAgent() -> %v", e)
+		}
+	}()
+	agt = &Agent{Tags: tags, ConfDir: dir}
+	keydir := filepath.Join(dir, "agentkeys")
+	err = os.MkdirAll(keydir, 0750)
+	if err != nil {
+		panic(err)
+	}
+	signers := make(map[string]interface{})
+	for _, inv := range invs {
+		err = ioutil.WriteFile(filepath.Join(keydir, inv.PGPFingerprint), inv.PublicKey, 0600)
+		if err != nil {
+			panic(err)
+		}
+		signers[inv.Name] = map[string]interface{}{"fingerprint": inv.PGPFingerprint, "weight": 1}
+	}
+	buf, err := json.Marshal(map[string]interface{}{
+		"default": map[string]interface{}{"minimumweight": 1, "investigators": signers},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "acl.cfg"), buf, 0600)
+	if err != nil {
+		panic(err)
+	}
+	var tagLines []string
+	for k, v := range tags {
+		tagLines = append(tagLines, fmt.Sprintf("    tags = \"%s:%s\"", k, v))
+	}
+	conf := filepath.Join(dir, "mig-agent.cfg")
+	err = ioutil.WriteFile(conf, []byte(fmt.Sprintf(agentConfig, api.URL, strings.Join(tagLines, "\n"),
+		pki.CACert, pki.AgentCert, pki.AgentKey)), 0600)
+	if err != nil {
+		panic(err)
+	}
+	// the agent stores its identifier in a file named after its runtime
+	// directory, which must end with a separator to keep it in dir
+	env := []string{AgentConfDirEnv + "=" + dir + string(filepath.Separator)}
+	agt.process, err = startProcess(filepath.Join(dir, "agent.log"), env, bin, "-f", "-c", conf)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// BaseRoute is the route under which the API started by StartAPI serves its
+// endpoints
+const BaseRoute = "/api/v1"
+
+// apiConfig is the configuration of the API started by StartAPI. Investigators
+// authenticate with signed tokens, and agents with the client certificate
+// signed by the CA of the test.
+const apiConfig = `[authentication]
+    enabled = on
+    tokenduration = 10m
+[manifest]
+    requiredsignatures = 1
+[server]
+    ip = "127.0.0.1"
+    port = %d
+    host = "%s"
+    baseroute = "%s"
+    tlscert = "%s"
+    tlskey = "%s"
+    clientca = "%s"
+[sqlite]
+    path = "%s"
+[logging]
+    mode = "stdout"
+    level = "info"
+`
+
+// API is a mig-api process serving HTTPS on the loopback interface, with the
+// SQLite database at the path given to StartAPI
+type API struct {
+	*process
+	// URL is the base URL of the API, as configured in agents
+	URL    string
+	client *http.Client
+}
+
+// StartAPI runs the mig-api binary bin with a configuration written to dir,
+// and waits until it answers requests. The API uses the server certificate of
+// pki, and shares the SQLite database at dbpath with the scheduler.
+func StartAPI(dir, bin string, pki PKI, dbpath string) (api *API, err error) {
+	port, err := freePort()
+	if err != nil {
+		return
+	}
+	host := fmt.Sprintf("https://127.0.0.1:%d", port)
+	conf := filepath.Join(dir, "api.cfg")
+	err = ioutil.WriteFile(conf, []byte(fmt.Sprintf(apiConfig, port, host, BaseRoute,
+		pki.ServerCert, pki.ServerKey, pki.CACert, dbpath)), 0600)
+	if err != nil {
+		return
+	}
+	cacert, err := ioutil.ReadFile(pki.CACert)
+	if err != nil {
+		return
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cacert)
+	api = &API{
+		URL: host + BaseRoute + "/",
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+			Timeout:   10 * time.Second,
+		},
+	}
+	api.process, err = startProcess(filepath.Join(dir, "api.log"), nil, bin, "-c", conf)
+	if err != nil {
+		return nil, err
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		resp, gerr := api.client.Get(api.URL + "heartbeat")
+		if gerr == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return api, nil
+			}
+		}
+		select {
+		case <-api.exited:
+			return nil, fmt.Errorf("mig-api exited during startup: %s", api.Output())
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			api.Stop()
+			return nil, fmt.Errorf("mig-api did not start: %s", api.Output())
+		}
+	}
+}
+
+// CreateAction submits an action signed by inv to the API, and returns the
+// action created by the API
+func (api *API) CreateAction(inv Investigator, a mig.Action) (created mig.Action, err error) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", api.URL+"action/create/",
+		strings.NewReader(url.Values{"action": {string(body)}}.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err = api.do(inv, req, http.StatusAccepted, &created)
+	return
+}
+
+// Action returns the action of ID id, as stored by the API
+func (api *API) Action(inv Investigator, id float64) (a mig.Action, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%saction?actionid=%.0f", api.URL, id), nil)
+	if err != nil {
+		return
+	}
+	err = api.do(inv, req, http.StatusOK, &a)
+	return
+}
+
+// do sends req authenticated with a token of inv, and decodes the value of the
+// first item of the returned collection into v
+func (api *API) do(inv Investigator, req *http.Request, status int, v interface{}) error {
+	token, err := inv.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-PGPAUTHORIZATION", token)
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var resource *cljs.Resource
+	err = json.NewDecoder(resp.Body).Decode(&resource)
+	if err != nil {
+		return fmt.Errorf("%s %s returned %d: '%v'", req.Method, req.URL.Path, resp.StatusCode, err)
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("%s %s returned %d: %+v", req.Method, req.URL.Path, resp.StatusCode,
+			resource.Collection.Error)
+	}
+	if len(resource.Collection.Items) == 0 || len(resource.Collection.Items[0].Data) == 0 {
+		return fmt.Errorf("%s %s returned no data", req.Method, req.URL.Path)
+	}
+	buf, err := json.Marshal(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
@@ -0,0 +1,39 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BuildCommand compiles the command in package pkg into directory dir, and
+// returns the path of the binary. Extra arguments, such as build tags, are
+// passed to go build.
+func BuildCommand(dir, pkg string, args ...string) (path string, err error) {
+	path = filepath.Join(dir, filepath.Base(pkg))
+	err = goTool(append(append([]string{"build", "-o", path}, args...), pkg)...)
+	return
+}
+
+// BuildTestBinary compiles the tests of package pkg into directory dir, and
+// returns the path of the test binary
+func BuildTestBinary(dir, pkg string, args ...string) (path string, err error) {
+	path = filepath.Join(dir, filepath.Base(pkg)+".test")
+	err = goTool(append(append([]string{"test", "-c", "-o", path}, args...), pkg)...)
+	return
+}
+
+// goTool runs the go command of the toolchain that built the test with args
+func goTool(args ...string) error {
+	out, err := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go %s failed: '%v': %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
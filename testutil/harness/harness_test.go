@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewPKI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "harness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pki, err := NewPKI(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacert, err := ioutil.ReadFile(pki.CACert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cacert) {
+		t.Fatal("failed to load the CA certificate")
+	}
+	testCases := []struct {
+		Description string
+		Cert, Key   string
+		Opts        x509.VerifyOptions
+	}{
+		{
+			Description: `API certificate is valid for TLS servers on 127.0.0.1`,
+			Cert:        pki.ServerCert,
+			Key:         pki.ServerKey,
+			Opts: x509.VerifyOptions{
+				Roots:     roots,
+				DNSName:   "127.0.0.1",
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+		},
+		{
+			Description: `Agent certificate is valid for TLS clients`,
+			Cert:        pki.AgentCert,
+			Key:         pki.AgentKey,
+			Opts: x509.VerifyOptions{
+				Roots:     roots,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		pair, err := tls.LoadX509KeyPair(tc.Cert, tc.Key)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Description, err)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Description, err)
+		}
+		_, err = cert.Verify(tc.Opts)
+		if err != nil {
+			t.Errorf("%s: %v", tc.Description, err)
+		}
+	}
+}
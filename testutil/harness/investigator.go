@@ -0,0 +1,100 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/pgp"
+)
+
+// Investigator is an investigator with a private key, which can sign actions
+type Investigator struct {
+	mig.Investigator
+	privkey []byte
+}
+
+// InvestigatorStore is the database the investigators of a test are stored in,
+// such as the Postgres or SQLite backends of the database package
+type InvestigatorStore interface {
+	InsertInvestigator(inv mig.Investigator) (float64, error)
+}
+
+// NewInvestigator generates a key pair for a new investigator, and stores the
+// investigator in db
+func NewInvestigator(db InvestigatorStore, name string) (inv Investigator, err error) {
+	pubkey, privkey, fp, err := pgp.GenerateKeyPair(name, "MIG test investigator", name+"@harness")
+	if err != nil {
+		return
+	}
+	inv.Name = name
+	inv.PGPFingerprint = strings.ToUpper(fp)
+	inv.PublicKey = pubkey
+	inv.privkey = privkey
+	inv.Permissions.DefaultSet()
+	inv.ID, err = db.InsertInvestigator(inv.Investigator)
+	return
+}
+
+// Sign adds the signature of the investigator to an action
+func (inv Investigator) Sign(a *mig.Action) error {
+	secring, _, err := pgp.ArmoredKeysToKeyring([][]byte{inv.privkey})
+	if err != nil {
+		return fmt.Errorf("Sign() -> %v", err)
+	}
+	sig, err := a.Sign(inv.PGPFingerprint, secring)
+	if err != nil {
+		return fmt.Errorf("Sign() -> %v", err)
+	}
+	a.PGPSignatures = append(a.PGPSignatures, sig)
+	return nil
+}
+
+// Token returns a signed token that authenticates the investigator to the API
+// in the X-PGPAUTHORIZATION header, as made by the clients
+func (inv Investigator) Token() (string, error) {
+	secring, _, err := pgp.ArmoredKeysToKeyring([][]byte{inv.privkey})
+	if err != nil {
+		return "", fmt.Errorf("Token() -> %v", err)
+	}
+	str := fmt.Sprintf("1;%s;%.0f", time.Now().UTC().Format(time.RFC3339), mig.GenID())
+	sig, err := pgp.Sign(str+"\n", inv.PGPFingerprint, secring)
+	if err != nil {
+		return "", fmt.Errorf("Token() -> %v", err)
+	}
+	return str + ";" + sig, nil
+}
+
+// NewAction returns an unsigned action that runs operations on the agents
+// matching target, valid from now and for the next ten minutes
+func NewAction(name, target string, operations ...mig.Operation) mig.Action {
+	return mig.Action{
+		Name:          name,
+		Target:        target,
+		ValidFrom:     time.Now().Add(-5 * time.Second).UTC(),
+		ExpireAfter:   time.Now().Add(10 * time.Minute).UTC(),
+		Operations:    operations,
+		SyntaxVersion: mig.ActionVersion,
+	}
+}
+
+// FileSearch returns an operation of the file module that searches the files
+// under path for content. The file module must be imported by the test.
+func FileSearch(path, content string) mig.Operation {
+	return mig.Operation{
+		Module: "file",
+		Parameters: map[string]interface{}{
+			"searches": map[string]interface{}{
+				"s1": map[string]interface{}{
+					"paths":    []string{path},
+					"contents": []string{content},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// PKI holds the paths of the certificates and keys generated for a test. The
+// CA signs the certificate of the API server, valid for 127.0.0.1, and the
+// client certificate shared by the agents.
+type PKI struct {
+	CACert                string
+	ServerCert, ServerKey string
+	AgentCert, AgentKey   string
+
+	ca    *x509.Certificate
+	cakey *ecdsa.PrivateKey
+}
+
+// NewPKI generates a CA and the certificates it signs in directory dir
+func NewPKI(dir string) (pki PKI, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("NewPKI() -> %v", e)
+		}
+	}()
+	pki.CACert = filepath.Join(dir, "ca.crt")
+	pki.ServerCert = filepath.Join(dir, "api.crt")
+	pki.ServerKey = filepath.Join(dir, "api.key")
+	pki.AgentCert = filepath.Join(dir, "agent.crt")
+	pki.AgentKey = filepath.Join(dir, "agent.key")
+
+	pki.cakey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := certTemplate("MIG test CA")
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pki.cakey.PublicKey, pki.cakey)
+	if err != nil {
+		panic(err)
+	}
+	pki.ca, err = x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	err = writePEM(pki.CACert, "CERTIFICATE", der)
+	if err != nil {
+		panic(err)
+	}
+
+	tmpl = certTemplate("127.0.0.1")
+	tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	err = pki.issue(tmpl, pki.ServerCert, pki.ServerKey)
+	if err != nil {
+		panic(err)
+	}
+
+	tmpl = certTemplate("mig-agent")
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	err = pki.issue(tmpl, pki.AgentCert, pki.AgentKey)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// issue signs a certificate made from tmpl with the CA, and writes it and its
+// private key to certPath and keyPath
+func (pki PKI) issue(tmpl *x509.Certificate, certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, pki.ca, &key.PublicKey, pki.cakey)
+	if err != nil {
+		return err
+	}
+	err = writePEM(certPath, "CERTIFICATE", der)
+	if err != nil {
+		return err
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(keyPath, "EC PRIVATE KEY", kder)
+}
+
+// certTemplate returns the template of a certificate for cn, valid for a day
+func certTemplate(cn string) *x509.Certificate {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		panic(err)
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"MIG test harness"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+}
+
+func writePEM(path, blockType string, der []byte) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package harness /* import "github.com/mozilla/mig/testutil/harness" */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// process is a MIG component running as a child process of the test, with
+// its output written to a log file
+type process struct {
+	cmd     *exec.Cmd
+	logPath string
+	exited  chan error
+}
+
+// startProcess runs bin with args and env added to the environment of the
+// test, and writes its output to logPath
+func startProcess(logPath string, env []string, bin string, args ...string) (p *process, err error) {
+	logfd, err := os.Create(logPath)
+	if err != nil {
+		return
+	}
+	defer logfd.Close()
+	p = &process{
+		cmd:     exec.Command(bin, args...),
+		logPath: logPath,
+		exited:  make(chan error, 1),
+	}
+	p.cmd.Env = append(os.Environ(), env...)
+	p.cmd.Stdout = logfd
+	p.cmd.Stderr = logfd
+	err = p.cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start %s: '%v'", bin, err)
+	}
+	go func() {
+		p.exited <- p.cmd.Wait()
+		close(p.exited)
+	}()
+	return
+}
+
+// Output returns what the process has written to its log so far
+func (p *process) Output() string {
+	buf, err := ioutil.ReadFile(p.logPath)
+	if err != nil {
+		return fmt.Sprintf("failed to read %s: %v", p.logPath, err)
+	}
+	return string(buf)
+}
+
+// Stop interrupts the process, and kills it if it hasn't exited after five
+// seconds
+func (p *process) Stop() {
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.exited:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// freePort returns a TCP port of the loopback interface that is not in use
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
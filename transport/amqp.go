@@ -0,0 +1,222 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package transport /* import "github.com/mozilla/mig/transport" */
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/streadway/amqp"
+)
+
+// AMQPAgent is the agent side of the AMQP transport. Commands are consumed from
+// the queue of the agent on the relay, and results are published to the relay.
+// Heartbeats are posted to the API.
+type AMQPAgent struct {
+	conn       *amqp.Connection
+	ch         *amqp.Channel
+	queue      string
+	expiration time.Duration
+	apiURL     string
+	client     *http.Client
+}
+
+// NewAMQPAgent returns the AMQP transport of the agent listening on queueLoc,
+// using a connection to the relay that is already established. Results that are
+// not collected by the scheduler before expiration are discarded by the relay.
+func NewAMQPAgent(conn *amqp.Connection, queueLoc string, expiration time.Duration, apiURL string) (t *AMQPAgent, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("NewAMQPAgent() -> %v", e)
+		}
+	}()
+	t = &AMQPAgent{
+		conn:       conn,
+		queue:      AgentQueue(queueLoc),
+		expiration: expiration,
+		apiURL:     apiURL,
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+	t.ch, err = conn.Channel()
+	if err != nil {
+		panic(err)
+	}
+	// Limit the number of message the channel will receive at once
+	err = t.ch.Qos(1, // prefetch count (in # of msg)
+		0,     // prefetch size (in bytes)
+		false) // is global
+	if err != nil {
+		panic(err)
+	}
+	_, err = t.ch.QueueDeclare(t.queue, // Queue name
+		true,  // is durable
+		false, // is autoDelete
+		false, // is exclusive
+		false, // is noWait
+		nil)   // AMQP args
+	if err != nil {
+		panic(err)
+	}
+	err = t.ch.QueueBind(t.queue, // Queue name
+		t.queue,              // Routing key name
+		mig.ExchangeToAgents, // Exchange name
+		false,                // is noWait
+		nil)                  // AMQP args
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// Messages consumes the queue of the agent. Messages are acknowledged before
+// being passed along, and messages that cannot be acknowledged are dropped.
+func (t *AMQPAgent) Messages() (<-chan Message, error) {
+	deliveries, err := t.ch.Consume(t.queue, // queue name
+		"",    // some tag
+		false, // is autoAck
+		false, // is exclusive
+		false, // is noLocal
+		false, // is noWait
+		nil)   // AMQP args
+	if err != nil {
+		return nil, fmt.Errorf("Messages() -> %v", err)
+	}
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		for m := range deliveries {
+			// Ack this message only
+			if m.Ack(true) != nil {
+				continue
+			}
+			msgs <- Message{Type: m.Type, Body: m.Body}
+		}
+	}()
+	return msgs, nil
+}
+
+// PublishHeartbeat posts a heartbeat to the API
+func (t *AMQPAgent) PublishHeartbeat(body []byte) error {
+	return postAPI(t.client, t.apiURL, "heartbeat", body)
+}
+
+// PublishResult publishes the results of a command to the relay
+func (t *AMQPAgent) PublishResult(body []byte) error {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  "text/plain",
+		Expiration:   fmt.Sprintf("%d", int64(t.expiration/time.Millisecond)),
+		Body:         body,
+	}
+	return t.ch.Publish(mig.ExchangeToSchedulers, mig.QueueAgentResults,
+		true,  // is mandatory
+		false, // is immediate
+		msg)   // AMQP message
+}
+
+// Close closes the connection to the relay
+func (t *AMQPAgent) Close() error {
+	return t.conn.Close()
+}
+
+// AMQPScheduler is the scheduler side of the AMQP transport
+type AMQPScheduler struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// NewAMQPScheduler returns the AMQP transport of the scheduler, using a connection
+// to the relay that is already established. The exchanges used to communicate with
+// agents are declared on the relay.
+func NewAMQPScheduler(conn *amqp.Connection) (t *AMQPScheduler, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("NewAMQPScheduler() -> %v", e)
+		}
+	}()
+	t = &AMQPScheduler{conn: conn}
+	t.ch, err = conn.Channel()
+	if err != nil {
+		panic(err)
+	}
+	// declare the "toagents" exchange used for communication from schedulers to agents
+	err = t.ch.ExchangeDeclare(mig.ExchangeToAgents, "direct", true, false, false, false, nil)
+	if err != nil {
+		panic(err)
+	}
+	// declare the "toschedulers" exchange used for communication from agents to schedulers
+	err = t.ch.ExchangeDeclare(mig.ExchangeToSchedulers, "direct", true, false, false, false, nil)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SendToAgent publishes a message to the queue of an agent
+func (t *AMQPScheduler) SendToAgent(queueLoc string, msg Message, expiration time.Duration) error {
+	pub := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		ContentType:  "text/plain",
+		Type:         msg.Type,
+		Expiration:   fmt.Sprintf("%d", int64(expiration/time.Millisecond)),
+		Body:         msg.Body,
+	}
+	return t.ch.Publish(mig.ExchangeToAgents, AgentQueue(queueLoc), true, false, pub)
+}
+
+// Heartbeats consumes the heartbeats published to the relay by agents
+func (t *AMQPScheduler) Heartbeats() (<-chan Message, error) {
+	msgs, err := t.consume(mig.QueueAgentHeartbeat)
+	if err != nil {
+		return nil, fmt.Errorf("Heartbeats() -> %v", err)
+	}
+	return msgs, nil
+}
+
+// Results consumes the command results published to the relay by agents
+func (t *AMQPScheduler) Results() (<-chan Message, error) {
+	msgs, err := t.consume(mig.QueueAgentResults)
+	if err != nil {
+		return nil, fmt.Errorf("Results() -> %v", err)
+	}
+	return msgs, nil
+}
+
+// consume declares a queue bound to the scheduler exchange and consumes it
+func (t *AMQPScheduler) consume(queue string) (<-chan Message, error) {
+	_, err := t.ch.QueueDeclare(queue, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = t.ch.QueueBind(queue, queue, mig.ExchangeToSchedulers, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = t.ch.Qos(0, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := t.ch.Consume(queue, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		for m := range deliveries {
+			msgs <- Message{Type: m.Type, Body: m.Body}
+		}
+	}()
+	return msgs, nil
+}
+
+// Close closes the connection to the relay
+func (t *AMQPScheduler) Close() error {
+	return t.conn.Close()
+}
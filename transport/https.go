@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package transport /* import "github.com/mozilla/mig/transport" */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// number of consecutive failed polls after which the channel of messages is
+// closed, in the same way it is when the connection to a relay is lost
+const maxPollFailures = 5
+
+// HTTPSAgent is the agent side of the HTTPS transport. Messages are retrieved by
+// long-polling the API, and heartbeats and results are posted to it.
+type HTTPSAgent struct {
+	client   *http.Client
+	apiURL   string
+	queueLoc string
+	wait     time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// ID of the last message passed to the agent, acknowledged in the next poll
+	ack float64
+}
+
+// NewHTTPSAgent returns the HTTPS transport of the agent listening on queueLoc.
+// Each poll of the API waits up to wait for new messages, a wait of zero returns
+// the pending messages immediately. If client is nil, a default client is used.
+func NewHTTPSAgent(client *http.Client, apiURL, queueLoc string, wait time.Duration) *HTTPSAgent {
+	if client == nil {
+		client = &http.Client{Timeout: wait + 30*time.Second}
+	}
+	t := &HTTPSAgent{
+		client:   client,
+		apiURL:   apiURL,
+		queueLoc: queueLoc,
+		wait:     wait,
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t
+}
+
+// Messages polls the API for the messages of the agent until the transport is
+// closed, or until the API fails to respond too many times in a row.
+func (t *HTTPSAgent) Messages() (<-chan Message, error) {
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		failures := 0
+		for {
+			pending, err := t.poll()
+			if err != nil {
+				failures++
+				if failures >= maxPollFailures {
+					return
+				}
+				if !t.sleep(time.Duration(failures) * 5 * time.Second) {
+					return
+				}
+				continue
+			}
+			failures = 0
+			for _, m := range pending {
+				select {
+				case msgs <- m:
+					t.ack = m.ID
+				case <-t.ctx.Done():
+					return
+				}
+			}
+			// without long-polling, don't hammer the API
+			if len(pending) == 0 && t.wait == 0 {
+				if !t.sleep(time.Second) {
+					return
+				}
+			}
+		}
+	}()
+	return msgs, nil
+}
+
+// poll acknowledges the messages received since the last poll, and retrieves
+// the pending messages of the agent from the API. Messages that are not
+// acknowledged are returned again by the next poll.
+func (t *HTTPSAgent) poll() (msgs []Message, err error) {
+	target, err := apiEndpoint(t.apiURL, "agent/messages")
+	if err != nil {
+		return
+	}
+	q := url.Values{}
+	q.Set("queueloc", t.queueLoc)
+	q.Set("wait", fmt.Sprintf("%.0f", t.wait.Seconds()))
+	q.Set("ack", fmt.Sprintf("%.0f", t.ack))
+	r, err := http.NewRequest("GET", target+"?"+q.Encode(), nil)
+	if err != nil {
+		return
+	}
+	resp, err := t.client.Do(r.WithContext(t.ctx))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&msgs)
+	return
+}
+
+// sleep waits for d, and returns false if the transport was closed in the meantime
+func (t *HTTPSAgent) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-t.ctx.Done():
+		return false
+	}
+}
+
+// PublishHeartbeat posts a heartbeat to the API
+func (t *HTTPSAgent) PublishHeartbeat(body []byte) error {
+	return postAPI(t.client, t.apiURL, "heartbeat", body)
+}
+
+// PublishResult posts the results of a command to the API
+func (t *HTTPSAgent) PublishResult(body []byte) error {
+	return postAPI(t.client, t.apiURL, "agent/results", body)
+}
+
+// Close stops polling the API
+func (t *HTTPSAgent) Close() error {
+	t.cancel()
+	return nil
+}
+
+// apiEndpoint returns the URL of endpoint under the base URL of the API
+func apiEndpoint(apiURL, endpoint string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, endpoint)
+	return u.String(), nil
+}
+
+// postAPI posts a JSON body to an endpoint of the API
+func postAPI(client *http.Client, apiURL, endpoint string, body []byte) error {
+	target, err := apiEndpoint(apiURL, endpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// statusError returns an error describing an unexpected response from the API
+func statusError(resp *http.Response) error {
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("Expected status code %d but got %d \n %s", http.StatusOK, resp.StatusCode, string(content))
+}
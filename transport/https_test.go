@@ -0,0 +1,148 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package transport /* import "github.com/mozilla/mig/transport" */
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPSAgent(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		pending = []Message{{ID: 1, Body: []byte("cmd1")}, {ID: 2, Type: "aclbundle", Body: []byte("bundle")}}
+		posted  = make(map[string][]byte)
+		acked   = make(chan string, 100)
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/agent/messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("queueloc") != "linux.agent" {
+			http.Error(w, "wrong queue", http.StatusBadRequest)
+			return
+		}
+		// messages are returned until they are acknowledged
+		ack, err := strconv.ParseFloat(r.URL.Query().Get("ack"), 64)
+		if err != nil {
+			http.Error(w, "invalid ack", http.StatusBadRequest)
+			return
+		}
+		acked <- r.URL.Query().Get("ack")
+		lock.Lock()
+		msgs := []Message{}
+		for _, m := range pending {
+			if m.ID > ack {
+				msgs = append(msgs, m)
+			}
+		}
+		pending = msgs
+		lock.Unlock()
+		json.NewEncoder(w).Encode(msgs)
+	})
+	upload := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		posted[r.URL.Path] = body
+		lock.Unlock()
+		w.Write([]byte(`{"error":null}`))
+	}
+	mux.HandleFunc("/api/v1/agent/results", upload)
+	mux.HandleFunc("/api/v1/heartbeat", upload)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var agt AgentTransport = NewHTTPSAgent(nil, server.URL+"/api/v1/", "linux.agent", 0)
+	msgs, err := agt.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expect := range []string{"cmd1", "bundle"} {
+		select {
+		case m := <-msgs:
+			if string(m.Body) != expect {
+				t.Fatalf("message %d has body %q, expected %q", i, m.Body, expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+	// the next poll acknowledges both messages
+	for ack := range acked {
+		if ack == "2" {
+			break
+		}
+		if ack != "0" {
+			t.Fatalf("unexpected acknowledgement %s", ack)
+		}
+	}
+	err = agt.PublishResult([]byte(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agt.PublishHeartbeat([]byte(`{"name":"agent"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	if string(posted["/api/v1/agent/results"]) != `{"id":1}` {
+		t.Fatalf("unexpected result posted: %q", posted["/api/v1/agent/results"])
+	}
+	if string(posted["/api/v1/heartbeat"]) != `{"name":"agent"}` {
+		t.Fatalf("unexpected heartbeat posted: %q", posted["/api/v1/heartbeat"])
+	}
+	lock.Unlock()
+
+	// closing the transport closes the channel of messages
+	agt.Close()
+	select {
+	case _, ok := <-msgs:
+		if ok {
+			t.Fatal("received a message after closing the transport")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel of messages was not closed")
+	}
+}
+
+func TestHTTPSAgentErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	agt := NewHTTPSAgent(nil, server.URL, "linux.agent", 0)
+	defer agt.Close()
+	if agt.PublishResult([]byte(`{"id":1}`)) == nil {
+		t.Fatal("expected publishing a result to fail")
+	}
+	if _, err := agt.poll(); err == nil {
+		t.Fatal("expected polling to fail")
+	}
+}
+
+func TestHTTPSQueueOwner(t *testing.T) {
+	testCases := []struct {
+		Description string
+		QueueLoc    string
+		Identity    string
+		Expected    bool
+	}{
+		{`queue named after the certificate`, HTTPSQueueLoc("linux.agent", "host1.example.net"), "host1.example.net", true},
+		{`queue of another certificate`, HTTPSQueueLoc("linux.agent", "host1.example.net"), "host2.example.net", false},
+		{`identity is only part of the last component`, "linux.agent.myhost1", "host1", false},
+		{`queue without a certificate identity`, "linux.agent", "", false},
+	}
+	for _, testCase := range testCases {
+		if HTTPSQueueOwner(testCase.QueueLoc, testCase.Identity) != testCase.Expected {
+			t.Errorf("%s: HTTPSQueueOwner(%q, %q) is not %v", testCase.Description,
+				testCase.QueueLoc, testCase.Identity, testCase.Expected)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package transport abstracts over the way messages are exchanged between
+// agents and the scheduler. Agents receive commands and ACL bundles, and
+// publish heartbeats and command results. The scheduler sends messages to
+// agents, and receives their heartbeats and results.
+//
+// Two implementations are provided: the AMQP transport uses a RabbitMQ relay,
+// and the HTTPS transport lets agents long-poll the API instead.
+package transport /* import "github.com/mozilla/mig/transport" */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// AMQP is the name of the transport that uses a RabbitMQ relay
+	AMQP = "amqp"
+	// HTTPS is the name of the transport where agents poll the API
+	HTTPS = "https"
+)
+
+// Message is a message exchanged between an agent and the scheduler. Type is
+// empty for commands and results, and set to mig.ACLBundleMessageType for ACL
+// bundles. ID is only set on the messages an agent polls from the API, which
+// it acknowledges once they are received.
+type Message struct {
+	ID   float64 `json:"id,omitempty"`
+	Type string  `json:"type"`
+	Body []byte  `json:"body"`
+}
+
+// AgentTransport is used by agents to receive messages from the scheduler
+// and to publish their heartbeats and command results.
+type AgentTransport interface {
+	// Messages returns a channel that receives the messages sent to the
+	// agent. The channel is closed when messages can no longer be received.
+	Messages() (<-chan Message, error)
+	PublishHeartbeat(body []byte) error
+	PublishResult(body []byte) error
+	Close() error
+}
+
+// SchedulerTransport is used by the scheduler to send messages to agents, and
+// to receive their heartbeats and command results.
+type SchedulerTransport interface {
+	// SendToAgent sends a message to the agent listening on queueLoc. The
+	// message is discarded if it has not been delivered after expiration.
+	SendToAgent(queueLoc string, msg Message, expiration time.Duration) error
+	Heartbeats() (<-chan Message, error)
+	Results() (<-chan Message, error)
+	Close() error
+}
+
+// AgentQueue returns the name of the queue the messages of the agent listening
+// on queueLoc are sent to
+func AgentQueue(queueLoc string) string {
+	return fmt.Sprintf("mig.agt.%s", queueLoc)
+}
+
+// HTTPSQueueLoc returns the queue location of an agent using the HTTPS
+// transport, which ends with the identity of the certificate the agent
+// presents to the API. The API only serves the messages of a queue to the
+// certificate named in its location.
+func HTTPSQueueLoc(queueLoc, identity string) string {
+	return queueLoc + "." + identity
+}
+
+// HTTPSQueueOwner returns true if queueLoc is the location of a queue of an
+// agent whose certificate has the given identity
+func HTTPSQueueOwner(queueLoc, identity string) bool {
+	return identity != "" && strings.HasSuffix(queueLoc, "."+identity)
+}
+
+// Validate returns an error if name is not a known transport
+func Validate(name string) error {
+	switch name {
+	case AMQP, HTTPS:
+		return nil
+	}
+	return fmt.Errorf("unknown transport %q, must be %q or %q", name, AMQP, HTTPS)
+}